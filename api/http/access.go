@@ -0,0 +1,309 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"path"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+
+	"github.com/mendersoftware/reporting/model"
+)
+
+const (
+	// RoleClaimDefault is the JWT claim holding the user's role(s)
+	RoleClaimDefault = "roles"
+	// RoleDefault is the role applied to the users without any
+	// configured role
+	RoleDefault = "*"
+
+	// ctxKeyAttributeFilter is the gin context key of the caller's
+	// attributeFilter
+	ctxKeyAttributeFilter = "reporting.attribute_filter"
+	// ctxKeyAttributeRoles is the gin context key of the caller's roles
+	// the attributeFilter was resolved from
+	ctxKeyAttributeRoles = "reporting.attribute_roles"
+)
+
+// AttributeAccess configures the attributes visible to each user role
+type AttributeAccess struct {
+	// RoleClaim is the JWT claim holding the user's role, or list of roles
+	RoleClaim string
+	// Roles maps the roles to the attributes they're allowed to see, as
+	// "<scope>/<name>" patterns, e.g. "inventory/*"; a user with several
+	// roles sees the union of their attributes. The RoleDefault role
+	// applies to the users without any configured role; if it's not
+	// configured, they see all the attributes.
+	Roles map[string][]string
+}
+
+// attributeFilter is the list of "<scope>/<name>" patterns of the
+// attributes the caller is allowed to see
+type attributeFilter []string
+
+func (f attributeFilter) allows(scope, name string) bool {
+	for _, pattern := range f {
+		if ok, _ := path.Match(pattern, scope+"/"+name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// attributeAccessMiddleware resolves the caller's roles (from the JWT)
+// to the attributes they're allowed to see, applied by filterAttributes
+// and checkAttributes
+func attributeAccessMiddleware(conf AttributeAccess) gin.HandlerFunc {
+	if conf.RoleClaim == "" {
+		conf.RoleClaim = RoleClaimDefault
+	}
+
+	return func(c *gin.Context) {
+		var roles []string
+		if jwt, err := identity.ExtractJWTFromHeader(c.Request); err == nil {
+			roles, _ = extractRoles(jwt, conf.RoleClaim)
+		}
+
+		var (
+			filter     attributeFilter
+			resolved   []string
+			restricted bool
+		)
+		for _, role := range roles {
+			if patterns, ok := conf.Roles[role]; ok {
+				filter = append(filter, patterns...)
+				resolved = append(resolved, role)
+				restricted = true
+			}
+		}
+		if !restricted {
+			filter, restricted = conf.Roles[RoleDefault]
+			resolved = []string{RoleDefault}
+		}
+		if restricted {
+			sort.Strings(resolved)
+			c.Set(ctxKeyAttributeFilter, filter)
+			c.Set(ctxKeyAttributeRoles, resolved)
+		}
+	}
+}
+
+// getAttributeFilter returns the caller's attributeFilter, if the
+// caller's access is restricted
+func getAttributeFilter(c *gin.Context) (attributeFilter, bool) {
+	v, ok := c.Get(ctxKeyAttributeFilter)
+	if !ok {
+		return nil, false
+	}
+	return v.(attributeFilter), true
+}
+
+// getAttributeRoles returns the sorted roles the caller's attributeFilter
+// was resolved from, nil if the caller's access isn't restricted
+func getAttributeRoles(c *gin.Context) []string {
+	v, ok := c.Get(ctxKeyAttributeRoles)
+	if !ok {
+		return nil
+	}
+	return v.([]string)
+}
+
+// checkAttributes refuses the request if it filters, sorts or aggregates
+// by an attribute the caller isn't allowed to see, which would disclose
+// the attribute's values through the matching devices or the counts
+func checkAttributes(c *gin.Context, attrs []model.SelectAttribute) error {
+	filter, ok := getAttributeFilter(c)
+	if !ok {
+		return nil
+	}
+	for _, attr := range attrs {
+		if !filter.allows(attr.Scope, attr.Attribute) {
+			return forbidden(errors.Errorf(
+				"access to the attribute %s/%s is not allowed",
+				attr.Scope, attr.Attribute))
+		}
+	}
+	return nil
+}
+
+// filtersAttributes returns the attributes the filters refer to
+func filtersAttributes(filters ...[]model.FilterPredicate) []model.SelectAttribute {
+	var attrs []model.SelectAttribute
+	for _, fs := range filters {
+		for _, f := range fs {
+			attrs = append(attrs, model.SelectAttribute{
+				Scope:     f.Scope,
+				Attribute: f.Attribute,
+			})
+		}
+	}
+	return attrs
+}
+
+// searchAttributes returns the attributes the search filters and sorts by
+func searchAttributes(params *model.SearchParams) []model.SelectAttribute {
+	attrs := filtersAttributes(append([][]model.FilterPredicate{
+		params.Filters, params.PostFilters,
+	}, params.Or...)...)
+	for _, s := range params.Sort {
+		attrs = append(attrs, model.SelectAttribute{
+			Scope:     s.Scope,
+			Attribute: s.Attribute,
+		})
+	}
+	return attrs
+}
+
+// extractRoles returns the roles in the JWT claim, either a single
+// string or a list of strings
+func extractRoles(jwt, claim string) ([]string, error) {
+	claims, err := extractClaims(jwt)
+	if err != nil {
+		return nil, err
+	}
+
+	switch v := claims[claim].(type) {
+	case string:
+		return []string{v}, nil
+	case []interface{}:
+		roles := make([]string, 0, len(v))
+		for _, role := range v {
+			if s, ok := role.(string); ok {
+				roles = append(roles, s)
+			}
+		}
+		return roles, nil
+	default:
+		return nil, nil
+	}
+}
+
+// filterAttributes strips the attributes the caller isn't allowed to see
+// from the devices, if the caller's access is restricted
+func filterAttributes(c *gin.Context, devs ...*model.InvDevice) {
+	filter, ok := getAttributeFilter(c)
+	if !ok {
+		return
+	}
+
+	for _, dev := range devs {
+		attrs := make(model.DeviceAttributes, 0, len(dev.Attributes))
+		for _, attr := range dev.Attributes {
+			if filter.allows(attr.Scope, attr.Name) {
+				attrs = append(attrs, attr)
+			}
+		}
+		dev.Attributes = attrs
+
+		if dev.Highlights != nil {
+			highlights := dev.Highlights[:0:0]
+			for _, h := range dev.Highlights {
+				if filter.allows(h.Scope, h.Name) {
+					highlights = append(highlights, h)
+				}
+			}
+			dev.Highlights = highlights
+		}
+	}
+}
+
+// filterHistory strips the history of the attributes the caller
+// isn't allowed to see, if the caller's access is restricted
+func filterHistory(c *gin.Context, entries []model.AttributeHistory) []model.AttributeHistory {
+	filter, ok := getAttributeFilter(c)
+	if !ok {
+		return entries
+	}
+
+	ret := make([]model.AttributeHistory, 0, len(entries))
+	for _, entry := range entries {
+		if filter.allows(entry.Scope, entry.Name) {
+			ret = append(ret, entry)
+		}
+	}
+	return ret
+}
+
+// filterComparison strips the attributes the caller isn't allowed to see
+// from the device comparison, if the caller's access is restricted
+func filterComparison(c *gin.Context, cmp *model.DeviceComparison) {
+	filter, ok := getAttributeFilter(c)
+	if !ok {
+		return
+	}
+
+	filterAttrs := func(attrs []model.InvDeviceAttribute) []model.InvDeviceAttribute {
+		ret := make([]model.InvDeviceAttribute, 0, len(attrs))
+		for _, attr := range attrs {
+			if filter.allows(attr.Scope, attr.Name) {
+				ret = append(ret, attr)
+			}
+		}
+		return ret
+	}
+	cmp.Common = filterAttrs(cmp.Common)
+	cmp.OnlyInA = filterAttrs(cmp.OnlyInA)
+	cmp.OnlyInB = filterAttrs(cmp.OnlyInB)
+
+	diffs := make([]model.AttributeDiff, 0, len(cmp.Different))
+	for _, diff := range cmp.Different {
+		if filter.allows(diff.Scope, diff.Name) {
+			diffs = append(diffs, diff)
+		}
+	}
+	cmp.Different = diffs
+}
+
+// filterSearchableAttrs strips the attributes the caller isn't allowed
+// to see from the searchable attributes, if the caller's access is
+// restricted
+func filterSearchableAttrs(c *gin.Context, attrs []model.InvFilterAttr) []model.InvFilterAttr {
+	filter, ok := getAttributeFilter(c)
+	if !ok {
+		return attrs
+	}
+
+	ret := make([]model.InvFilterAttr, 0, len(attrs))
+	for _, attr := range attrs {
+		if filter.allows(attr.Scope, attr.Name) {
+			ret = append(ret, attr)
+		}
+	}
+	return ret
+}
+
+// filterAttributesMetadata strips the metadata of the attributes the
+// caller isn't allowed to see, if the caller's access is restricted
+func filterAttributesMetadata(
+	c *gin.Context,
+	attrs []model.InvAttrMetadata,
+) []model.InvAttrMetadata {
+	filter, ok := getAttributeFilter(c)
+	if !ok {
+		return attrs
+	}
+
+	ret := make([]model.InvAttrMetadata, 0, len(attrs))
+	for _, attr := range attrs {
+		if filter.allows(attr.Scope, attr.Name) {
+			ret = append(ret, attr)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,458 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	mapp "github.com/mendersoftware/reporting/app/reporting/mocks"
+	"github.com/mendersoftware/reporting/model"
+)
+
+func TestManagementSearchAttributeAccess(t *testing.T) {
+	t.Parallel()
+	newDevices := func() []model.InvDevice {
+		return []model.InvDevice{{
+			ID: "dev1",
+			Attributes: model.DeviceAttributes{
+				{Scope: "identity", Name: "mac", Value: "00:11:22:33:44:55"},
+				{Scope: "inventory", Name: "device_type", Value: "rpi4"},
+				{Scope: "inventory", Name: "ipv4_eth0", Value: "10.0.0.2"},
+				{Scope: "monitor", Name: "alerts", Value: true},
+			},
+		}}
+	}
+	access := AttributeAccess{
+		Roles: map[string][]string{
+			"support":    {"identity/*", "inventory/device_type"},
+			"operations": {"inventory/*", "monitor/*"},
+			RoleDefault:  {"identity/*"},
+		},
+	}
+
+	testCases := []struct {
+		Name string
+
+		Roles interface{}
+
+		Attributes []string
+	}{{
+		Name: "support role",
+
+		Roles:      "support",
+		Attributes: []string{"identity/mac", "inventory/device_type"},
+	}, {
+		Name: "operations role",
+
+		Roles: []string{"operations"},
+		Attributes: []string{
+			"inventory/device_type",
+			"inventory/ipv4_eth0",
+			"monitor/alerts",
+		},
+	}, {
+		Name: "several roles, union",
+
+		Roles: []string{"support", "operations"},
+		Attributes: []string{
+			"identity/mac",
+			"inventory/device_type",
+			"inventory/ipv4_eth0",
+			"monitor/alerts",
+		},
+	}, {
+		Name: "unknown role, default role",
+
+		Roles:      "guest",
+		Attributes: []string{"identity/mac"},
+	}, {
+		Name: "no role, default role",
+
+		Attributes: []string{"identity/mac"},
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			a := new(mapp.App)
+			a.On("InventorySearchDevices",
+				contextMatcher,
+				mock.AnythingOfType("*model.SearchParams")).
+				Return(newDevices(), 1, nil)
+			defer a.AssertExpectations(t)
+			router := NewRouter(a, WithAttributeAccess(access))
+
+			claims := map[string]interface{}{
+				"sub":           "851f90b3-cee5-425e-8f6e-b36de1993e7e",
+				"mender.tenant": "123456789012345678901234",
+			}
+			if tc.Roles != nil {
+				claims[RoleClaimDefault] = tc.Roles
+			}
+			req, _ := http.NewRequest(
+				http.MethodPost,
+				URIManagement+URIInventorySearch,
+				strings.NewReader(`{}`),
+			)
+			req.Header.Set("Authorization", "Bearer "+GenerateJWT(claims))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			var res []model.InvDevice
+			_ = json.Unmarshal(w.Body.Bytes(), &res)
+			if assert.Len(t, res, 1) {
+				attrs := []string{}
+				for _, attr := range res[0].Attributes {
+					attrs = append(attrs, attr.Scope+"/"+attr.Name)
+				}
+				assert.Equal(t, tc.Attributes, attrs)
+			}
+		})
+	}
+}
+
+func TestManagementSearchAttributeAccessDisabled(t *testing.T) {
+	t.Parallel()
+	devices := []model.InvDevice{{
+		ID: "dev1",
+		Attributes: model.DeviceAttributes{
+			{Scope: "identity", Name: "mac", Value: "00:11:22:33:44:55"},
+			{Scope: "inventory", Name: "device_type", Value: "rpi4"},
+		},
+	}}
+
+	a := new(mapp.App)
+	a.On("InventorySearchDevices",
+		contextMatcher,
+		mock.AnythingOfType("*model.SearchParams")).
+		Return(devices, 1, nil)
+	defer a.AssertExpectations(t)
+	router := NewRouter(a)
+
+	req, _ := http.NewRequest(
+		http.MethodPost,
+		URIManagement+URIInventorySearch,
+		strings.NewReader(`{}`),
+	)
+	req.Header.Set("Authorization", "Bearer "+GenerateJWT(map[string]interface{}{
+		"sub":           "851f90b3-cee5-425e-8f6e-b36de1993e7e",
+		"mender.tenant": "123456789012345678901234",
+		"roles":         "support",
+	}))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	b, _ := json.Marshal(devices)
+	assert.JSONEq(t, string(b), w.Body.String())
+}
+
+func TestManagementCompareDevicesAttributeAccess(t *testing.T) {
+	t.Parallel()
+	a := new(mapp.App)
+	a.On("CompareDevices",
+		contextMatcher,
+		mock.AnythingOfType("*model.CompareDevicesParams")).
+		Return(&model.DeviceComparison{
+			DeviceA: "dev1",
+			DeviceB: "dev2",
+			Common: []model.InvDeviceAttribute{
+				{Scope: "inventory", Name: "device_type", Value: "rpi4"},
+			},
+			OnlyInA: []model.InvDeviceAttribute{
+				{Scope: "identity", Name: "mac", Value: "00:11:22:33:44:55"},
+			},
+			OnlyInB: []model.InvDeviceAttribute{},
+			Different: []model.AttributeDiff{
+				{Scope: "identity", Name: "serial", ValueA: "1", ValueB: "2"},
+				{Scope: "inventory", Name: "kernel", ValueA: "5.10", ValueB: "5.15"},
+			},
+		}, nil)
+	defer a.AssertExpectations(t)
+	router := NewRouter(a, WithAttributeAccess(AttributeAccess{
+		Roles: map[string][]string{"support": {"inventory/*"}},
+	}))
+
+	req, _ := http.NewRequest(
+		http.MethodGet,
+		URIManagement+URIInventoryCompare+"?device_a=dev1&device_b=dev2",
+		nil,
+	)
+	req.Header.Set("Authorization", "Bearer "+GenerateJWT(map[string]interface{}{
+		"sub":           "851f90b3-cee5-425e-8f6e-b36de1993e7e",
+		"mender.tenant": "123456789012345678901234",
+		"roles":         "support",
+	}))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{
+		"device_a": "dev1",
+		"device_b": "dev2",
+		"common": [{"scope": "inventory", "name": "device_type", "value": "rpi4"}],
+		"only_in_a": [],
+		"only_in_b": [],
+		"different": [{"scope": "inventory", "name": "kernel",
+			"value_a": "5.10", "value_b": "5.15"}]
+	}`, w.Body.String())
+}
+
+func TestManagementAttributeAccessQueries(t *testing.T) {
+	t.Parallel()
+	access := AttributeAccess{
+		Roles: map[string][]string{
+			"support": {"identity/*", "inventory/device_type"},
+		},
+	}
+	forbiddenRes := func(attr string) ErrorResponse {
+		return ErrorResponse{
+			Code: ErrCodeForbidden,
+			Err:  "access to the attribute " + attr + " is not allowed",
+		}
+	}
+	const (
+		allowedFilter = `{"scope": "inventory", "attribute": "device_type",
+			"type": "$eq", "value": "rpi4"}`
+		forbiddenFilter = `{"scope": "inventory", "attribute": "ipv4_eth0",
+			"type": "$eq", "value": "10.0.0.2"}`
+	)
+
+	testCases := []struct {
+		Name string
+
+		URI  string
+		Body string
+		App  func() *mapp.App
+
+		Code     int
+		Response interface{}
+	}{{
+		Name: "ok, search by allowed attributes",
+
+		URI: URIInventorySearch,
+		Body: `{"filters": [` + allowedFilter + `],
+			"sort": [{"scope": "identity", "attribute": "mac", "order": "asc"}]}`,
+		App: func() *mapp.App {
+			a := new(mapp.App)
+			a.On("InventorySearchDevices",
+				contextMatcher,
+				mock.AnythingOfType("*model.SearchParams")).
+				Return([]model.InvDevice{}, 0, nil)
+			return a
+		},
+
+		Code:     http.StatusOK,
+		Response: []model.InvDevice{},
+	}, {
+		Name: "error, search filter",
+
+		URI:  URIInventorySearch,
+		Body: `{"filters": [` + forbiddenFilter + `]}`,
+
+		Code:     http.StatusForbidden,
+		Response: forbiddenRes("inventory/ipv4_eth0"),
+	}, {
+		Name: "error, search post filter",
+
+		URI:  URIInventorySearch,
+		Body: `{"post_filters": [` + forbiddenFilter + `]}`,
+
+		Code:     http.StatusForbidden,
+		Response: forbiddenRes("inventory/ipv4_eth0"),
+	}, {
+		Name: "error, search or filter",
+
+		URI:  URIInventorySearch,
+		Body: `{"or": [[` + allowedFilter + `], [` + forbiddenFilter + `]]}`,
+
+		Code:     http.StatusForbidden,
+		Response: forbiddenRes("inventory/ipv4_eth0"),
+	}, {
+		Name: "error, search sort",
+
+		URI:  URIInventorySearch,
+		Body: `{"sort": [{"scope": "monitor", "attribute": "alerts", "order": "asc"}]}`,
+
+		Code:     http.StatusForbidden,
+		Response: forbiddenRes("monitor/alerts"),
+	}, {
+		Name: "error, export filter",
+
+		URI:  URIInventoryExport,
+		Body: `{"filters": [` + forbiddenFilter + `]}`,
+
+		Code:     http.StatusForbidden,
+		Response: forbiddenRes("inventory/ipv4_eth0"),
+	}, {
+		Name: "error, scan sort",
+
+		URI:  URIInventoryScan,
+		Body: `{"sort": [{"scope": "monitor", "attribute": "alerts", "order": "asc"}]}`,
+
+		Code:     http.StatusForbidden,
+		Response: forbiddenRes("monitor/alerts"),
+	}, {
+		Name: "ok, facets of an allowed attribute",
+
+		URI:  URIInventoryFacets,
+		Body: `{"scope": "inventory", "attribute": "device_type"}`,
+		App: func() *mapp.App {
+			a := new(mapp.App)
+			a.On("GetFacets",
+				contextMatcher,
+				mock.AnythingOfType("*model.FacetsParams")).
+				Return(&model.Facets{Buckets: []model.FacetBucket{}}, nil)
+			return a
+		},
+
+		Code:     http.StatusOK,
+		Response: &model.Facets{Buckets: []model.FacetBucket{}},
+	}, {
+		Name: "error, facets attribute",
+
+		URI:  URIInventoryFacets,
+		Body: `{"scope": "inventory", "attribute": "ipv4_eth0"}`,
+
+		Code:     http.StatusForbidden,
+		Response: forbiddenRes("inventory/ipv4_eth0"),
+	}, {
+		Name: "error, facets filter",
+
+		URI: URIInventoryFacets,
+		Body: `{"scope": "inventory", "attribute": "device_type",
+			"filters": [` + forbiddenFilter + `]}`,
+
+		Code:     http.StatusForbidden,
+		Response: forbiddenRes("inventory/ipv4_eth0"),
+	}, {
+		Name: "error, pivot dimension",
+
+		URI: URIInventoryPivot,
+		Body: `{"group_by": [{"scope": "inventory", "attribute": "device_type"},
+			{"scope": "monitor", "attribute": "alerts"}]}`,
+
+		Code:     http.StatusForbidden,
+		Response: forbiddenRes("monitor/alerts"),
+	}, {
+		Name: "error, ip ranges attribute",
+
+		URI:  URIInventoryIPRanges,
+		Body: `{"scope": "inventory", "attribute": "ipv4_eth0", "ranges": ["10.0.0.0/8"]}`,
+
+		Code:     http.StatusForbidden,
+		Response: forbiddenRes("inventory/ipv4_eth0"),
+	}, {
+		Name: "error, geohash grid attribute",
+
+		URI:  URIInventoryGeohashGrid,
+		Body: `{"scope": "inventory", "attribute": "location"}`,
+
+		Code:     http.StatusForbidden,
+		Response: forbiddenRes("inventory/location"),
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			a := new(mapp.App)
+			if tc.App != nil {
+				a = tc.App()
+			}
+			defer a.AssertExpectations(t)
+			router := NewRouter(a, WithAttributeAccess(access))
+
+			req, _ := http.NewRequest(
+				http.MethodPost,
+				URIManagement+tc.URI,
+				strings.NewReader(tc.Body),
+			)
+			req.Header.Set("Authorization", "Bearer "+GenerateJWT(map[string]interface{}{
+				"sub":           "851f90b3-cee5-425e-8f6e-b36de1993e7e",
+				"mender.tenant": "123456789012345678901234",
+				"roles":         "support",
+			}))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.Code, w.Code)
+			switch typ := tc.Response.(type) {
+			case ErrorResponse:
+				var actual ErrorResponse
+				err := json.NewDecoder(w.Body).Decode(&actual)
+				if assert.NoError(t, err, "unexpected response schema") {
+					assert.EqualError(t, actual, typ.Error())
+				}
+			default:
+				b, _ := json.Marshal(tc.Response)
+				assert.JSONEq(t, string(b), w.Body.String())
+			}
+		})
+	}
+}
+
+func TestManagementSearchAttrsAttributeAccess(t *testing.T) {
+	t.Parallel()
+	a := new(mapp.App)
+	a.On("GetSearchableInvAttrs", contextMatcher, "123456789012345678901234").
+		Return([]model.InvFilterAttr{
+			{Scope: "identity", Name: "mac", Count: 10},
+			{Scope: "inventory", Name: "device_type", Count: 10},
+			{Scope: "inventory", Name: "ipv4_eth0", Count: 8},
+		}, nil)
+	a.On("GetAttributesMetadata", contextMatcher, "123456789012345678901234").
+		Return([]model.InvAttrMetadata{
+			{Scope: "inventory", Name: "device_type"},
+			{Scope: "inventory", Name: "ipv4_eth0"},
+		}, nil)
+	defer a.AssertExpectations(t)
+	router := NewRouter(a, WithAttributeAccess(AttributeAccess{
+		Roles: map[string][]string{"support": {"inventory/device_type"}},
+	}))
+
+	request := func(uri string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodGet, URIManagement+uri, nil)
+		req.Header.Set("Authorization", "Bearer "+GenerateJWT(map[string]interface{}{
+			"sub":           "851f90b3-cee5-425e-8f6e-b36de1993e7e",
+			"mender.tenant": "123456789012345678901234",
+			"roles":         "support",
+		}))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request(URIInventorySearchAttrs)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t,
+		`[{"scope": "inventory", "name": "device_type", "count": 10}]`,
+		w.Body.String())
+
+	w = request(URIInventoryAttrsMetadata)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var metadata []model.InvAttrMetadata
+	_ = json.Unmarshal(w.Body.Bytes(), &metadata)
+	assert.Equal(t, []model.InvAttrMetadata{
+		{Scope: "inventory", Name: "device_type"},
+	}, metadata)
+}
@@ -0,0 +1,192 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"container/list"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/rbac"
+)
+
+const (
+	hdrCache  = "X-Cache"
+	cacheHit  = "HIT"
+	cacheMiss = "MISS"
+
+	// AggregationCacheMaxSizeDefault is the default max number of
+	// cached aggregation results
+	AggregationCacheMaxSizeDefault = 1000
+)
+
+// AggregationCacheConfig configures the in-memory cache of the results of
+// the aggregation endpoints (groups, facets, pivot); the results are cached for
+// TTL, and not invalidated by the device updates
+type AggregationCacheConfig struct {
+	// TTL is how long the results are cached; 0 disables the cache
+	TTL time.Duration
+	// MaxSize is the max number of cached results; once full, the
+	// oldest results are evicted
+	MaxSize int
+}
+
+type cacheEntry struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+// aggregationCache is a TTL cache of the aggregation results, keyed by
+// the endpoint, the caller's tenant, scope and roles, and the request
+// parameters
+type aggregationCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	maxSize int
+	// entries are in insertion order, which is also the expiry order
+	entries *list.List
+	index   map[string]*list.Element
+	now     func() time.Time
+}
+
+func newAggregationCache(conf AggregationCacheConfig) *aggregationCache {
+	if conf.MaxSize <= 0 {
+		conf.MaxSize = AggregationCacheMaxSizeDefault
+	}
+	return &aggregationCache{
+		ttl:     conf.TTL,
+		maxSize: conf.MaxSize,
+		entries: list.New(),
+		index:   make(map[string]*list.Element),
+		now:     time.Now,
+	}
+}
+
+// cacheKey returns the cache key of the endpoint's params, scoped to the
+// tenant, the RBAC groups and the attribute access roles of the caller, so
+// that the results are never shared across tenants or users with different
+// scopes or attribute access; the params can't be relied upon for that, as
+// they may not serialize the tenant ID
+func cacheKey(
+	endpoint, tenantID string,
+	groups, roles []string,
+	params interface{},
+) (string, bool) {
+	b, err := json.Marshal(struct {
+		TenantID string      `json:"tenant_id"`
+		Groups   []string    `json:"groups"`
+		Roles    []string    `json:"roles"`
+		Params   interface{} `json:"params"`
+	}{
+		TenantID: tenantID,
+		Groups:   groups,
+		Roles:    roles,
+		Params:   params,
+	})
+	if err != nil {
+		return "", false
+	}
+	return endpoint + ":" + string(b), true
+}
+
+func (ac *aggregationCache) get(key string) (interface{}, bool) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	elem, ok := ac.index[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if !ac.now().Before(entry.expires) {
+		ac.remove(elem)
+		return nil, false
+	}
+	return entry.value, true
+}
+
+func (ac *aggregationCache) put(key string, value interface{}) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	now := ac.now()
+	if elem, ok := ac.index[key]; ok {
+		ac.remove(elem)
+	}
+	// evict the expired results, then the oldest ones if still full
+	for elem := ac.entries.Front(); elem != nil; elem = ac.entries.Front() {
+		if now.Before(elem.Value.(*cacheEntry).expires) &&
+			ac.entries.Len() < ac.maxSize {
+			break
+		}
+		ac.remove(elem)
+	}
+
+	ac.index[key] = ac.entries.PushBack(&cacheEntry{
+		key:     key,
+		value:   value,
+		expires: now.Add(ac.ttl),
+	})
+}
+
+func (ac *aggregationCache) remove(elem *list.Element) {
+	ac.entries.Remove(elem)
+	delete(ac.index, elem.Value.(*cacheEntry).key)
+}
+
+// cachedAggregation renders the result of fetch, from the cache if
+// enabled; the X-Cache header tells if the result was cached
+func (mc *ManagementController) cachedAggregation(
+	c *gin.Context,
+	endpoint string,
+	params interface{},
+	fetch func() (interface{}, error),
+) (interface{}, error) {
+	if mc.cache == nil {
+		return fetch()
+	}
+
+	var (
+		tenantID string
+		groups   []string
+	)
+	if id := identity.FromContext(c.Request.Context()); id != nil {
+		tenantID = id.Tenant
+	}
+	if scope := rbac.ExtractScopeFromHeader(c.Request); scope != nil {
+		groups = scope.DeviceGroups
+	}
+	key, ok := cacheKey(endpoint, tenantID, groups, getAttributeRoles(c), params)
+	if !ok {
+		return fetch()
+	}
+	if res, ok := mc.cache.get(key); ok {
+		c.Header(hdrCache, cacheHit)
+		return res, nil
+	}
+
+	res, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+	mc.cache.put(key, res)
+	c.Header(hdrCache, cacheMiss)
+	return res, nil
+}
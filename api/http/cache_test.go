@@ -0,0 +1,182 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/rbac"
+
+	mapp "github.com/mendersoftware/reporting/app/reporting/mocks"
+	"github.com/mendersoftware/reporting/model"
+)
+
+func TestAggregationCache(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2021, 11, 1, 12, 0, 0, 0, time.UTC)
+	cache := newAggregationCache(AggregationCacheConfig{
+		TTL:     time.Minute,
+		MaxSize: 2,
+	})
+	cache.now = func() time.Time { return now }
+
+	_, ok := cache.get("a")
+	assert.False(t, ok, "miss, empty cache")
+
+	cache.put("a", 1)
+	v, ok := cache.get("a")
+	assert.True(t, ok, "hit")
+	assert.Equal(t, 1, v)
+
+	now = now.Add(30 * time.Second)
+	cache.put("b", 2)
+	cache.put("c", 3)
+	_, ok = cache.get("a")
+	assert.False(t, ok, "miss, oldest evicted when full")
+	_, ok = cache.get("b")
+	assert.True(t, ok, "hit")
+
+	now = now.Add(time.Minute)
+	_, ok = cache.get("b")
+	assert.False(t, ok, "miss, expired")
+	_, ok = cache.get("c")
+	assert.False(t, ok, "miss, expired")
+	assert.Equal(t, 0, cache.entries.Len())
+	assert.Empty(t, cache.index)
+}
+
+func TestManagementGroupsCache(t *testing.T) {
+	t.Parallel()
+	groups := []model.GroupCount{{Group: "production", Count: 10}}
+
+	a := new(mapp.App)
+	a.On("GetGroups",
+		contextMatcher,
+		mock.MatchedBy(func(params *model.GroupsParams) bool {
+			return params.TenantID == "tenant1"
+		})).
+		Return(groups, nil).
+		Once()
+	a.On("GetGroups",
+		contextMatcher,
+		mock.MatchedBy(func(params *model.GroupsParams) bool {
+			return params.TenantID == "tenant2"
+		})).
+		Return([]model.GroupCount{}, nil).
+		Once()
+	defer a.AssertExpectations(t)
+	router := NewRouter(a, WithAggregationCache(AggregationCacheConfig{
+		TTL: time.Minute,
+	}))
+
+	request := func(tenant string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(
+			http.MethodGet,
+			URIManagement+URIInventoryGroups,
+			nil,
+		)
+		req.Header.Set("Authorization", "Bearer "+GenerateJWT(identity.Identity{
+			Subject: "851f90b3-cee5-425e-8f6e-b36de1993e7e",
+			Tenant:  tenant,
+		}))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	b, _ := json.Marshal(groups)
+
+	w := request("tenant1")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, cacheMiss, w.Header().Get(hdrCache))
+	assert.JSONEq(t, string(b), w.Body.String())
+
+	w = request("tenant1")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, cacheHit, w.Header().Get(hdrCache))
+	assert.JSONEq(t, string(b), w.Body.String())
+
+	// the results are never shared across tenants
+	w = request("tenant2")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, cacheMiss, w.Header().Get(hdrCache))
+	assert.JSONEq(t, `[]`, w.Body.String())
+}
+
+func TestManagementFacetsCache(t *testing.T) {
+	t.Parallel()
+	facets := &model.Facets{
+		Buckets: []model.FacetBucket{{Value: "rpi4", Count: 10}},
+	}
+
+	// the facets params don't serialize the tenant, the groups nor the roles
+	a := new(mapp.App)
+	a.On("GetFacets", contextMatcher, mock.AnythingOfType("*model.FacetsParams")).
+		Return(facets, nil).
+		Times(4)
+	defer a.AssertExpectations(t)
+	router := NewRouter(a,
+		WithAggregationCache(AggregationCacheConfig{
+			TTL: time.Minute,
+		}),
+		WithAttributeAccess(AttributeAccess{
+			Roles: map[string][]string{
+				"support":    {"inventory/*"},
+				"operations": {"inventory/*", "monitor/*"},
+			},
+		}),
+	)
+
+	request := func(tenant, groups, role string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(
+			http.MethodPost,
+			URIManagement+URIInventoryFacets,
+			strings.NewReader(`{"scope": "inventory", "attribute": "device_type"}`),
+		)
+		req.Header.Set("Authorization", "Bearer "+GenerateJWT(map[string]interface{}{
+			"sub":           "851f90b3-cee5-425e-8f6e-b36de1993e7e",
+			"mender.tenant": tenant,
+			"roles":         role,
+		}))
+		if groups != "" {
+			req.Header.Set(rbac.ScopeHeader, groups)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request("tenant1", "", "support")
+	assert.Equal(t, cacheMiss, w.Header().Get(hdrCache))
+	w = request("tenant1", "", "support")
+	assert.Equal(t, cacheHit, w.Header().Get(hdrCache))
+
+	// the results are never shared across tenants, scopes, nor roles
+	w = request("tenant2", "", "support")
+	assert.Equal(t, cacheMiss, w.Header().Get(hdrCache))
+	w = request("tenant1", "production", "support")
+	assert.Equal(t, cacheMiss, w.Header().Get(hdrCache))
+	w = request("tenant1", "", "operations")
+	assert.Equal(t, cacheMiss, w.Header().Get(hdrCache))
+}
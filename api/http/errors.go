@@ -0,0 +1,187 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/requestid"
+
+	"github.com/mendersoftware/reporting/app/reporting"
+	"github.com/mendersoftware/reporting/model"
+	"github.com/mendersoftware/reporting/store"
+)
+
+// error codes of the API error responses
+const (
+	ErrCodeBadRequest           = "bad_request"
+	ErrCodeUnauthorized         = "unauthorized"
+	ErrCodeForbidden            = "forbidden"
+	ErrCodeInvalidFilter        = "invalid_filter"
+	ErrCodeInvalidQuery         = "invalid_query"
+	ErrCodeQueryTooComplex      = "query_too_complex"
+	ErrCodeInvalidScanCursor    = "invalid_scan_cursor"
+	ErrCodeScanCursorExpired    = "scan_cursor_expired"
+	ErrCodeInvalidChangesCursor = "invalid_changes_cursor"
+	ErrCodeTooManyBuckets       = "too_many_buckets"
+	ErrCodeHistoryDisabled      = "history_disabled"
+	ErrCodeUnknownService       = "unknown_service"
+	ErrCodeDeviceNotFound       = "device_not_found"
+	ErrCodeReindexQueueFull     = "reindex_queue_full"
+	ErrCodeReindexTenantFull    = "reindex_tenant_queue_full"
+	ErrCodeReindexTaskRunning   = "reindex_task_running"
+	ErrCodeReindexTaskNotFound  = "reindex_task_not_found"
+	ErrCodeSearchProfileOff     = "search_profile_disabled"
+	ErrCodePurgeNotConfirmed    = "purge_not_confirmed"
+	ErrCodeStoreUnavailable     = "store_unavailable"
+	ErrCodeTooManySearches      = "too_many_searches"
+	ErrCodeFeatureDisabled      = "feature_disabled"
+	ErrCodeInvalidAggregations  = "invalid_aggregations"
+	ErrCodeInternalServerError  = "internal_error"
+)
+
+// errMsgInternalServerError is the message of all the internal errors,
+// whose details aren't disclosed to the client
+const errMsgInternalServerError = "internal error"
+
+// ErrorResponse is the body of all the API error responses
+type ErrorResponse struct {
+	Code      string `json:"code"`
+	Err       string `json:"error"`
+	RequestID string `json:"request_id,omitempty"`
+	// InvalidFilters lists all the invalid filter predicates of a search
+	InvalidFilters model.FilterErrors `json:"invalid_filters,omitempty"`
+}
+
+func (err ErrorResponse) Error() string {
+	return err.Err
+}
+
+// apiError is an error with the status and code of its response
+type apiError struct {
+	status int
+	code   string
+	err    error
+}
+
+func (e *apiError) Error() string {
+	return e.err.Error()
+}
+
+func (e *apiError) Unwrap() error {
+	return e.err
+}
+
+// badRequest marks err as a client error, rendered with 400
+func badRequest(err error) error {
+	return &apiError{
+		status: http.StatusBadRequest,
+		code:   ErrCodeBadRequest,
+		err:    err,
+	}
+}
+
+// unauthorized marks err as an authentication error, rendered with 401
+func unauthorized(err error) error {
+	return &apiError{
+		status: http.StatusUnauthorized,
+		code:   ErrCodeUnauthorized,
+		err:    err,
+	}
+}
+
+// forbidden marks err as an authorization error, rendered with 403
+func forbidden(err error) error {
+	return &apiError{
+		status: http.StatusForbidden,
+		code:   ErrCodeForbidden,
+		err:    err,
+	}
+}
+
+// errorCodes maps the typed errors of the app and the store to the
+// status and code of their responses
+var errorCodes = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{model.ErrArrayNotSupported, http.StatusBadRequest, ErrCodeInvalidFilter},
+	{model.ErrArrayRequired, http.StatusBadRequest, ErrCodeInvalidFilter},
+	{model.ErrStrRequired, http.StatusBadRequest, ErrCodeInvalidFilter},
+	{model.ErrNumRequired, http.StatusBadRequest, ErrCodeInvalidFilter},
+	{model.ErrBoolRequired, http.StatusBadRequest, ErrCodeInvalidFilter},
+	{model.ErrRangeFilterType, http.StatusBadRequest, ErrCodeInvalidFilter},
+	{model.ErrTooManyValues, http.StatusBadRequest, ErrCodeInvalidFilter},
+	{model.ErrQueryTooComplex, http.StatusBadRequest, ErrCodeQueryTooComplex},
+	{model.ErrNotIPAttribute, http.StatusBadRequest, ErrCodeInvalidFilter},
+	{model.ErrNotGeoPointAttribute, http.StatusBadRequest, ErrCodeInvalidFilter},
+	{reporting.ErrInvalidQuery, http.StatusBadRequest, ErrCodeInvalidQuery},
+	{model.ErrInvalidScanCursor, http.StatusBadRequest, ErrCodeInvalidScanCursor},
+	{model.ErrInvalidChangesCursor, http.StatusBadRequest, ErrCodeInvalidChangesCursor},
+	{reporting.ErrScanCursorExpired, http.StatusGone, ErrCodeScanCursorExpired},
+	{reporting.ErrTooManyBuckets, http.StatusBadRequest, ErrCodeTooManyBuckets},
+	{reporting.ErrHistoryDisabled, http.StatusNotFound, ErrCodeHistoryDisabled},
+	{reporting.ErrUnknownService, http.StatusBadRequest, ErrCodeUnknownService},
+	{reporting.ErrDeviceNotFound, http.StatusNotFound, ErrCodeDeviceNotFound},
+	{reporting.ErrReindexChannelFull, http.StatusTooManyRequests, ErrCodeReindexQueueFull},
+	{reporting.ErrReindexTenantQueueFull, http.StatusTooManyRequests, ErrCodeReindexTenantFull},
+	{reporting.ErrReindexTaskRunning, http.StatusConflict, ErrCodeReindexTaskRunning},
+	{reporting.ErrReindexTaskNotFound, http.StatusNotFound, ErrCodeReindexTaskNotFound},
+	{ErrSearchProfileDisabled, http.StatusBadRequest, ErrCodeSearchProfileOff},
+	{ErrPurgeNotConfirmed, http.StatusBadRequest, ErrCodePurgeNotConfirmed},
+	{store.ErrStoreUnavailable, http.StatusServiceUnavailable, ErrCodeStoreUnavailable},
+	{reporting.ErrTooManySearches, http.StatusServiceUnavailable, ErrCodeTooManySearches},
+	{ErrFeatureDisabled, http.StatusNotImplemented, ErrCodeFeatureDisabled},
+	{model.ErrInvalidRawAggs, http.StatusBadRequest, ErrCodeInvalidAggregations},
+}
+
+// renderError renders err as an ErrorResponse: the typed errors get
+// their own status and code, any other error is an internal error,
+// logged but not disclosed to the client
+func renderError(c *gin.Context, err error) {
+	_ = c.Error(err)
+
+	res := ErrorResponse{
+		Code:      ErrCodeInternalServerError,
+		Err:       errMsgInternalServerError,
+		RequestID: requestid.FromContext(c.Request.Context()),
+	}
+	status := http.StatusInternalServerError
+
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		status, res.Code, res.Err = apiErr.status, apiErr.code, apiErr.Error()
+	} else {
+		for _, e := range errorCodes {
+			if errors.Is(err, e.err) {
+				status, res.Code, res.Err = e.status, e.code, err.Error()
+				break
+			}
+		}
+	}
+	var filterErrs model.FilterErrors
+	if errors.As(err, &filterErrs) {
+		res.InvalidFilters = filterErrs
+	}
+	if errors.Is(err, reporting.ErrTooManySearches) {
+		status = renderSearchLimit(c)
+	}
+
+	c.JSON(status, res)
+}
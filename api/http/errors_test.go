@@ -0,0 +1,280 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/requestid"
+
+	"github.com/mendersoftware/reporting/app/reporting"
+	mapp "github.com/mendersoftware/reporting/app/reporting/mocks"
+	"github.com/mendersoftware/reporting/model"
+	"github.com/mendersoftware/reporting/store"
+)
+
+func TestRenderError(t *testing.T) {
+	t.Parallel()
+	testCases := map[string]struct {
+		err error
+
+		status   int
+		response ErrorResponse
+	}{
+		"bad request": {
+			err:    badRequest(errors.New("size must be a positive integer")),
+			status: http.StatusBadRequest,
+			response: ErrorResponse{
+				Code: ErrCodeBadRequest,
+				Err:  "size must be a positive integer",
+			},
+		},
+		"unauthorized": {
+			err:    unauthorized(errors.New("Authorization not present in header")),
+			status: http.StatusUnauthorized,
+			response: ErrorResponse{
+				Code: ErrCodeUnauthorized,
+				Err:  "Authorization not present in header",
+			},
+		},
+		"forbidden": {
+			err:    forbidden(errors.New("tenant tokens are not allowed")),
+			status: http.StatusForbidden,
+			response: ErrorResponse{
+				Code: ErrCodeForbidden,
+				Err:  "tenant tokens are not allowed",
+			},
+		},
+		"invalid filter": {
+			err:    errors.Wrap(model.ErrNumRequired, "failed to build query"),
+			status: http.StatusBadRequest,
+			response: ErrorResponse{
+				Code: ErrCodeInvalidFilter,
+				Err:  "failed to build query: " + model.ErrNumRequired.Error(),
+			},
+		},
+		"invalid filters": {
+			err: badRequest(errors.Wrap(model.FilterErrors{
+				{Field: "filters", Index: 0, Err: "type: must be a valid value."},
+				{Field: "filters", Index: 2, Err: "scope: must be a valid value."},
+			}, "malformed request body")),
+			status: http.StatusBadRequest,
+			response: ErrorResponse{
+				Code: ErrCodeBadRequest,
+				Err: "malformed request body: " +
+					"filters[0]: type: must be a valid value.; " +
+					"filters[2]: scope: must be a valid value.",
+				InvalidFilters: model.FilterErrors{
+					{Field: "filters", Index: 0, Err: "type: must be a valid value."},
+					{Field: "filters", Index: 2, Err: "scope: must be a valid value."},
+				},
+			},
+		},
+		"invalid range filter": {
+			err: errors.Wrap(model.ErrRangeFilterType,
+				"attribute inventory/mac is not numeric"),
+			status: http.StatusBadRequest,
+			response: ErrorResponse{
+				Code: ErrCodeInvalidFilter,
+				Err:  "attribute inventory/mac is not numeric: cannot apply range filter",
+			},
+		},
+		"too many filter values": {
+			err: fmt.Errorf("%w: $in inventory/mac has 2000000 values, the limit is 1048576",
+				model.ErrTooManyValues),
+			status: http.StatusBadRequest,
+			response: ErrorResponse{
+				Code: ErrCodeInvalidFilter,
+				Err: "filter has too many values: " +
+					"$in inventory/mac has 2000000 values, the limit is 1048576",
+			},
+		},
+		"query too complex": {
+			err: fmt.Errorf("%w: 12 nested bool queries, the limit is 10",
+				model.ErrQueryTooComplex),
+			status: http.StatusBadRequest,
+			response: ErrorResponse{
+				Code: ErrCodeQueryTooComplex,
+				Err:  "query is too complex: 12 nested bool queries, the limit is 10",
+			},
+		},
+		"invalid query": {
+			err: fmt.Errorf("%w: failed to create query: Illegal version string: 5",
+				reporting.ErrInvalidQuery),
+			status: http.StatusBadRequest,
+			response: ErrorResponse{
+				Code: ErrCodeInvalidQuery,
+				Err:  "invalid query: failed to create query: Illegal version string: 5",
+			},
+		},
+		"too many buckets": {
+			err:    reporting.ErrTooManyBuckets,
+			status: http.StatusBadRequest,
+			response: ErrorResponse{
+				Code: ErrCodeTooManyBuckets,
+				Err:  reporting.ErrTooManyBuckets.Error(),
+			},
+		},
+		"history disabled": {
+			err:    reporting.ErrHistoryDisabled,
+			status: http.StatusNotFound,
+			response: ErrorResponse{
+				Code: ErrCodeHistoryDisabled,
+				Err:  reporting.ErrHistoryDisabled.Error(),
+			},
+		},
+		"unknown service": {
+			err:    reporting.ErrUnknownService,
+			status: http.StatusBadRequest,
+			response: ErrorResponse{
+				Code: ErrCodeUnknownService,
+				Err:  reporting.ErrUnknownService.Error(),
+			},
+		},
+		"reindex queue full": {
+			err:    reporting.ErrReindexChannelFull,
+			status: http.StatusTooManyRequests,
+			response: ErrorResponse{
+				Code: ErrCodeReindexQueueFull,
+				Err:  reporting.ErrReindexChannelFull.Error(),
+			},
+		},
+		"reindex tenant queue full": {
+			err:    reporting.ErrReindexTenantQueueFull,
+			status: http.StatusTooManyRequests,
+			response: ErrorResponse{
+				Code: ErrCodeReindexTenantFull,
+				Err:  reporting.ErrReindexTenantQueueFull.Error(),
+			},
+		},
+		"reindex task running": {
+			err:    reporting.ErrReindexTaskRunning,
+			status: http.StatusConflict,
+			response: ErrorResponse{
+				Code: ErrCodeReindexTaskRunning,
+				Err:  reporting.ErrReindexTaskRunning.Error(),
+			},
+		},
+		"reindex task not found": {
+			err:    reporting.ErrReindexTaskNotFound,
+			status: http.StatusNotFound,
+			response: ErrorResponse{
+				Code: ErrCodeReindexTaskNotFound,
+				Err:  reporting.ErrReindexTaskNotFound.Error(),
+			},
+		},
+		"search profile disabled": {
+			err:    ErrSearchProfileDisabled,
+			status: http.StatusBadRequest,
+			response: ErrorResponse{
+				Code: ErrCodeSearchProfileOff,
+				Err:  ErrSearchProfileDisabled.Error(),
+			},
+		},
+		"purge not confirmed": {
+			err:    ErrPurgeNotConfirmed,
+			status: http.StatusBadRequest,
+			response: ErrorResponse{
+				Code: ErrCodePurgeNotConfirmed,
+				Err:  ErrPurgeNotConfirmed.Error(),
+			},
+		},
+		"store unavailable": {
+			err:    errors.Wrap(store.ErrStoreUnavailable, "failed to search"),
+			status: http.StatusServiceUnavailable,
+			response: ErrorResponse{
+				Code: ErrCodeStoreUnavailable,
+				Err:  "failed to search: " + store.ErrStoreUnavailable.Error(),
+			},
+		},
+		"internal error, not disclosed": {
+			err:    errors.New("elasticsearch: connection refused"),
+			status: http.StatusInternalServerError,
+			response: ErrorResponse{
+				Code: ErrCodeInternalServerError,
+				Err:  errMsgInternalServerError,
+			},
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest(http.MethodGet, "/", nil)
+			c.Request = c.Request.WithContext(
+				requestid.WithContext(c.Request.Context(), "test-request-id"))
+
+			renderError(c, tc.err)
+
+			assert.Equal(t, tc.status, w.Code)
+			var res ErrorResponse
+			dec := json.NewDecoder(w.Body)
+			dec.DisallowUnknownFields()
+			if assert.NoError(t, dec.Decode(&res)) {
+				tc.response.RequestID = "test-request-id"
+				assert.Equal(t, tc.response, res)
+			}
+			// the original error is always kept for the access log
+			if assert.Len(t, c.Errors, 1) {
+				assert.Equal(t, tc.err, c.Errors[0].Err)
+			}
+		})
+	}
+}
+
+func TestManagementSearchStoreUnavailable(t *testing.T) {
+	t.Parallel()
+	a := new(mapp.App)
+	a.On("InventorySearchDevices",
+		contextMatcher,
+		mock.AnythingOfType("*model.SearchParams")).
+		Return(nil, 0, store.ErrStoreUnavailable)
+	defer a.AssertExpectations(t)
+	router := NewRouter(a)
+
+	req, _ := http.NewRequest(
+		http.MethodPost,
+		URIManagement+URIInventorySearch,
+		strings.NewReader(`{}`),
+	)
+	req.Header.Set("Authorization", "Bearer "+GenerateJWT(identity.Identity{
+		Subject: "851f90b3-cee5-425e-8f6e-b36de1993e7e",
+		Tenant:  "123456789012345678901234",
+	}))
+	req.Header.Set(requestid.RequestIdHeader, "test-request-id")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	b, _ := json.Marshal(ErrorResponse{
+		Code:      ErrCodeStoreUnavailable,
+		Err:       store.ErrStoreUnavailable.Error(),
+		RequestID: "test-request-id",
+	})
+	assert.JSONEq(t, string(b), w.Body.String())
+}
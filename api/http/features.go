@@ -0,0 +1,102 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// Features are the names of the endpoints which can be disabled,
+// e.g. while they are rolled out gradually
+const (
+	FeatureSearchAttributes   = "search_attributes"
+	FeatureAttributesMetadata = "attributes_metadata"
+	FeatureGroups             = "groups"
+	FeatureExport             = "export"
+	FeatureScan               = "scan"
+	FeatureFacets             = "facets"
+	FeaturePivot              = "pivot"
+	FeatureIPRanges           = "ip_ranges"
+	FeatureGeohashGrid        = "geohash_grid"
+	FeatureHistory            = "history"
+	FeatureCompare            = "compare"
+	FeatureChanges            = "changes"
+	FeatureDeviceDetails      = "device_details"
+	FeatureBulkGet            = "bulk_get"
+	FeatureDevicesExist       = "devices_exist"
+	FeatureIndexSettings      = "index_settings"
+)
+
+var (
+	ErrFeatureDisabled = errors.New("the endpoint is disabled")
+	ErrUnknownFeature  = errors.New("unknown feature")
+
+	// features are all the known features
+	features = map[string]struct{}{
+		FeatureSearchAttributes:   {},
+		FeatureAttributesMetadata: {},
+		FeatureGroups:             {},
+		FeatureExport:             {},
+		FeatureScan:               {},
+		FeatureFacets:             {},
+		FeaturePivot:              {},
+		FeatureIPRanges:           {},
+		FeatureGeohashGrid:        {},
+		FeatureHistory:            {},
+		FeatureCompare:            {},
+		FeatureChanges:            {},
+		FeatureDeviceDetails:      {},
+		FeatureBulkGet:            {},
+		FeatureDevicesExist:       {},
+		FeatureIndexSettings:      {},
+	}
+)
+
+// ValidateFeatures checks that all the names are known features,
+// so that a misspelled feature flag fails at startup
+func ValidateFeatures(names []string) error {
+	for _, name := range names {
+		if _, ok := features[name]; !ok {
+			return fmt.Errorf("%w: %q", ErrUnknownFeature, name)
+		}
+	}
+	return nil
+}
+
+// WithDisabledFeatures disables the endpoints of the features: they're
+// answered with 501 Not Implemented, without reaching the app
+func WithDisabledFeatures(names []string) RouterOption {
+	return func(rc *routerConfig) {
+		rc.disabledFeatures = make(map[string]bool, len(names))
+		for _, name := range names {
+			rc.disabledFeatures[name] = true
+		}
+	}
+}
+
+// feature returns handler, unless the feature is disabled
+func (rc *routerConfig) feature(name string, handler gin.HandlerFunc) gin.HandlerFunc {
+	if rc.disabledFeatures[name] {
+		return featureDisabled
+	}
+	return handler
+}
+
+func featureDisabled(c *gin.Context) {
+	renderError(c, ErrFeatureDisabled)
+}
@@ -0,0 +1,110 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/requestid"
+
+	mapp "github.com/mendersoftware/reporting/app/reporting/mocks"
+	"github.com/mendersoftware/reporting/model"
+)
+
+func TestDisabledFeatures(t *testing.T) {
+	t.Parallel()
+	testCases := map[string]struct {
+		disabled []string
+		method   string
+		uri      string
+		setup    func(a *mapp.App)
+
+		code int
+	}{
+		"disabled, export": {
+			disabled: []string{FeatureExport, FeaturePivot},
+			method:   http.MethodPost,
+			uri:      URIManagement + URIInventoryExport,
+			code:     http.StatusNotImplemented,
+		},
+		"disabled, internal bulk get": {
+			disabled: []string{FeatureBulkGet},
+			method:   http.MethodPost,
+			uri:      URIInternal + URIDevicesBulkGetInternal,
+			code:     http.StatusNotImplemented,
+		},
+		"enabled, groups": {
+			disabled: []string{FeatureExport, FeaturePivot},
+			method:   http.MethodGet,
+			uri:      URIManagement + URIInventoryGroups,
+			setup: func(a *mapp.App) {
+				a.On("GetGroups", contextMatcher, mock.Anything).
+					Return([]model.GroupCount{}, nil)
+			},
+			code: http.StatusOK,
+		},
+	}
+	for name := range testCases {
+		tc := testCases[name]
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			a := new(mapp.App)
+			if tc.setup != nil {
+				tc.setup(a)
+			}
+			// the disabled endpoints never reach the app
+			defer a.AssertExpectations(t)
+			router := NewRouter(a, WithDisabledFeatures(tc.disabled))
+
+			req, _ := http.NewRequest(tc.method, tc.uri, strings.NewReader(`{}`))
+			req.Header.Set("Authorization", "Bearer "+GenerateJWT(identity.Identity{
+				Subject: "851f90b3-cee5-425e-8f6e-b36de1993e7e",
+				Tenant:  "123456789012345678901234",
+			}))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.code, w.Code)
+			if tc.code == http.StatusNotImplemented {
+				b, _ := json.Marshal(ErrorResponse{
+					Code:      ErrCodeFeatureDisabled,
+					Err:       ErrFeatureDisabled.Error(),
+					RequestID: w.Header().Get(requestid.RequestIdHeader),
+				})
+				assert.JSONEq(t, string(b), w.Body.String())
+			}
+		})
+	}
+}
+
+func TestValidateFeatures(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, ValidateFeatures(nil))
+	assert.NoError(t, ValidateFeatures([]string{FeatureExport, FeatureChanges}))
+
+	err := ValidateFeatures([]string{FeatureExport, "exprot"})
+	assert.True(t, errors.Is(err, ErrUnknownFeature))
+	assert.EqualError(t, err, `unknown feature: "exprot"`)
+}
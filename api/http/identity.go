@@ -0,0 +1,163 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/log"
+)
+
+const (
+	// TenantClaimDefault is the JWT claim holding the tenant ID
+	TenantClaimDefault = "mender.tenant"
+)
+
+var (
+	ErrTenantMissing = errors.New("tenant identity not present in the request")
+)
+
+// IdentityConfig configures the extraction of the identity of the
+// management requests
+type IdentityConfig struct {
+	// TenantClaim is the JWT claim holding the tenant ID
+	TenantClaim string
+	// TenantHeader is the header holding the tenant ID, if any; it takes
+	// precedence over the JWT claim, and must only be set if the header
+	// is set by a trusted gateway
+	TenantHeader string
+	// RequireTenant rejects the requests without a tenant ID
+	RequireTenant bool
+}
+
+// identityMiddleware extracts the identity from the request JWT (or the
+// "JWT" cookie) into the request context, with the tenant ID taken from
+// the configured claim or header; responds 401 if the identity is missing
+func identityMiddleware(conf IdentityConfig) gin.HandlerFunc {
+	if conf.TenantClaim == "" {
+		conf.TenantClaim = TenantClaimDefault
+	}
+
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		id, err := extractIdentity(c.Request, conf)
+		if err != nil {
+			c.Header("WWW-Authenticate", `Bearer realm="ManagementJWT"`)
+			renderError(c, unauthorized(err))
+			c.Abort()
+			return
+		}
+
+		key := "sub"
+		if id.IsDevice {
+			key = "device_id"
+		} else if id.IsUser {
+			key = "user_id"
+		}
+		logCtx := log.Ctx{key: id.Subject}
+		if id.Tenant != "" {
+			logCtx["tenant_id"] = id.Tenant
+		}
+		ctx = log.WithContext(ctx, log.FromContext(ctx).F(logCtx))
+		ctx = identity.WithContext(ctx, id)
+
+		c.Request = c.Request.WithContext(ctx)
+	}
+}
+
+// noTenantToken wraps the handler of an internal endpoint acting across or
+// on behalf of tenants, to refuse the requests carrying a user or device
+// token, which may only reach the internal API through a misconfiguration
+func noTenantToken(handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, err := identity.ExtractJWTFromHeader(c.Request); err == nil {
+			renderError(c, forbidden(errors.New("tenant tokens are not allowed")))
+			return
+		}
+		handler(c)
+	}
+}
+
+func extractIdentity(r *http.Request, conf IdentityConfig) (*identity.Identity, error) {
+	jwt, err := identity.ExtractJWTFromHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	id, err := identity.ExtractIdentity(jwt)
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.TenantClaim != TenantClaimDefault {
+		id.Tenant, err = extractClaim(jwt, conf.TenantClaim)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if conf.TenantHeader != "" {
+		if tenant := r.Header.Get(conf.TenantHeader); tenant != "" {
+			id.Tenant = tenant
+		}
+	}
+
+	if conf.RequireTenant && id.Tenant == "" {
+		return nil, ErrTenantMissing
+	}
+	return &id, nil
+}
+
+// extractClaim returns the string claim of the JWT, or an empty string
+// if the claim is not present; the signature is not verified
+func extractClaim(jwt, claim string) (string, error) {
+	claims, err := extractClaims(jwt)
+	if err != nil {
+		return "", err
+	}
+
+	switch v := claims[claim].(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	default:
+		return "", errors.Errorf("identity: claim %q is not a string", claim)
+	}
+}
+
+// extractClaims decodes the claims of the JWT; the signature is not verified
+func extractClaims(jwt string) (map[string]interface{}, error) {
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("identity: incorrect token format")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.Wrap(err, "identity: failed to decode base64 JWT claims")
+	}
+
+	var claims map[string]interface{}
+	if err := json.Unmarshal(b, &claims); err != nil {
+		return nil, errors.Wrap(err, "identity: failed to decode JSON JWT claims")
+	}
+	return claims, nil
+}
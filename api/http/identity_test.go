@@ -0,0 +1,125 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+)
+
+func TestIdentityMiddleware(t *testing.T) {
+	t.Parallel()
+	testCases := map[string]struct {
+		conf    IdentityConfig
+		token   interface{}
+		headers map[string]string
+
+		code   int
+		tenant string
+	}{
+		"ok": {
+			token: identity.Identity{
+				Subject: "user1",
+				Tenant:  "tenant1",
+				IsUser:  true,
+			},
+			code:   http.StatusOK,
+			tenant: "tenant1",
+		},
+		"ok, no tenant": {
+			token: identity.Identity{
+				Subject: "user1",
+				IsUser:  true,
+			},
+			code: http.StatusOK,
+		},
+		"ok, custom claim": {
+			conf: IdentityConfig{TenantClaim: "org_id"},
+			token: map[string]interface{}{
+				"sub":           "user1",
+				"org_id":        "tenant2",
+				"mender.tenant": "tenant1",
+			},
+			code:   http.StatusOK,
+			tenant: "tenant2",
+		},
+		"ok, header": {
+			conf: IdentityConfig{TenantHeader: "X-Tenant-ID"},
+			token: identity.Identity{
+				Subject: "user1",
+				Tenant:  "tenant1",
+			},
+			headers: map[string]string{"X-Tenant-ID": "tenant3"},
+			code:    http.StatusOK,
+			tenant:  "tenant3",
+		},
+		"error, missing token": {
+			code: http.StatusUnauthorized,
+		},
+		"error, missing tenant": {
+			conf: IdentityConfig{RequireTenant: true},
+			token: identity.Identity{
+				Subject: "user1",
+			},
+			code: http.StatusUnauthorized,
+		},
+		"error, custom claim not a string": {
+			conf: IdentityConfig{TenantClaim: "org_id"},
+			token: map[string]interface{}{
+				"sub":    "user1",
+				"org_id": 42,
+			},
+			code: http.StatusUnauthorized,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			router := gin.New()
+			router.Use(identityMiddleware(tc.conf))
+			router.GET("/", func(c *gin.Context) {
+				id := identity.FromContext(c.Request.Context())
+				c.JSON(http.StatusOK, id.Tenant)
+			})
+
+			req, _ := http.NewRequest(http.MethodGet, "/", nil)
+			if tc.token != nil {
+				req.Header.Set("Authorization", "Bearer "+GenerateJWT(tc.token))
+			}
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.code, w.Code)
+			if tc.code == http.StatusOK {
+				var tenant string
+				_ = json.Unmarshal(w.Body.Bytes(), &tenant)
+				assert.Equal(t, tc.tenant, tenant)
+			} else {
+				assert.NotEmpty(t, w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
@@ -22,14 +22,34 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/mendersoftware/go-lib-micro/identity"
-	"github.com/mendersoftware/go-lib-micro/rest.utils"
 
 	"github.com/mendersoftware/reporting/app/reporting"
+	"github.com/mendersoftware/reporting/model"
+)
+
+var (
+	ErrSearchProfileDisabled = errors.New("search profiling is disabled")
+	ErrPurgeNotConfirmed     = errors.New("the purge must be confirmed with confirm=true")
 )
 
 // InternalController contains internal end-points
 type InternalController struct {
 	reporting reporting.App
+
+	// searchProfile enables the ES query profiling of the searches
+	searchProfile bool
+	// strictDecoding rejects the unknown fields of the search params
+	strictDecoding bool
+	// rawAggregations enables the raw aggregations passthrough
+	rawAggregations RawAggregationsConfig
+}
+
+// RawAggregationsConfig configures the passthrough of the raw ES
+// aggregations, for the aggregation features without a dedicated endpoint
+type RawAggregationsConfig struct {
+	Enabled bool
+	// AllowScripts allows the scripts in the aggregations
+	AllowScripts bool
 }
 
 // NewInternalController returns a new InternalController
@@ -44,37 +64,34 @@ func (h InternalController) Alive(c *gin.Context) {
 	c.JSON(http.StatusNoContent, nil)
 }
 
+// Version responds to GET /version
+func (ic *InternalController) Version(c *gin.Context) {
+	c.JSON(http.StatusOK, ic.reporting.GetVersion(c.Request.Context()))
+}
+
 func (mc *InternalController) Search(c *gin.Context) {
 	tid := c.Param("tenant_id")
 
 	ctx := c.Request.Context()
 	ctx = identity.WithContext(ctx, &identity.Identity{Tenant: tid})
 
-	params, err := parseSearchParams(ctx, c)
+	params, err := parseSearchParams(ctx, c, mc.strictDecoding)
 
 	if err != nil {
-		rest.RenderError(c,
-			http.StatusBadRequest,
-			errors.Wrap(err, "malformed request body"),
-		)
+		renderError(c, badRequest(errors.Wrap(err, "malformed request body")))
 		return
 	}
 
-	res, total, err := mc.reporting.InventorySearchDevices(ctx, params)
-	if err != nil {
-		rest.RenderError(c,
-			http.StatusInternalServerError,
-			err,
-		)
+	if params.Profile && !mc.searchProfile {
+		renderError(c, ErrSearchProfileDisabled)
 		return
 	}
 
-	pageLinkHdrs(c, params.Page, params.PerPage, total)
-
-	c.Header(hdrTotalCount, strconv.Itoa(total))
-	c.JSON(http.StatusOK, res)
+	searchDevices(ctx, c, mc.reporting, params)
 }
 
+// Reindex queues the reindex of a device; with changes=true, the device is
+// reindexed right away instead, and the changed attributes are returned
 func (ic *InternalController) Reindex(c *gin.Context) {
 	tid := c.Param("tenant_id")
 	did := c.Param("device_id")
@@ -84,31 +101,204 @@ func (ic *InternalController) Reindex(c *gin.Context) {
 	ctx := c.Request.Context()
 	ctx = identity.WithContext(ctx, &identity.Identity{Tenant: tid})
 
-	err := ic.reporting.Reindex(ctx, tid, did, service)
-
-	switch err {
-	case nil:
-		c.Status(http.StatusAccepted)
-	case reporting.ErrUnknownService:
+	if changes, _ := strconv.ParseBool(c.Query("changes")); changes {
+		res, err := ic.reporting.ReindexDevice(ctx, tid, did, service)
 		if err != nil {
-			rest.RenderError(c,
-				http.StatusBadRequest,
-				err,
-			)
+			renderError(c, err)
 			return
 		}
-	case reporting.ErrReindexChannelFull:
-		rest.RenderError(c,
-			http.StatusServiceUnavailable,
-			err,
-		)
+		c.JSON(http.StatusOK, res)
 		return
-	default:
-		c.Error(err) //nolint:errcheck
-		rest.RenderError(c,
-			http.StatusInternalServerError,
-			errors.New(http.StatusText(http.StatusInternalServerError)),
-		)
+	}
+
+	err := ic.reporting.Reindex(ctx, tid, did, service)
+
+	if err != nil {
+		renderError(c, err)
 		return
 	}
+
+	c.Status(http.StatusAccepted)
+}
+
+// BulkGetDevices fetches devices across tenants, for the admin tooling
+func (ic *InternalController) BulkGetDevices(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var params model.BulkGetParams
+	if err := c.ShouldBindJSON(&params); err != nil {
+		renderError(c, badRequest(errors.Wrap(err, "malformed request body")))
+		return
+	}
+	if err := params.Validate(); err != nil {
+		renderError(c, badRequest(err))
+		return
+	}
+
+	res, err := ic.reporting.BulkGetDevices(ctx, params)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// DevicesExist returns which of the tenant's device ids are indexed,
+// e.g. before a sync from the upstream services
+func (ic *InternalController) DevicesExist(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var params model.DevicesExistParams
+	if err := c.ShouldBindJSON(&params); err != nil {
+		renderError(c, badRequest(errors.Wrap(err, "malformed request body")))
+		return
+	}
+	if err := params.Validate(); err != nil {
+		renderError(c, badRequest(err))
+		return
+	}
+	ctx = identity.WithContext(ctx, &identity.Identity{Tenant: params.TenantID})
+
+	res, err := ic.reporting.DevicesExist(ctx, &params)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+type reindexTenantRes struct {
+	TaskID string `json:"task_id"`
+}
+
+type reindexTenantStatusRes struct {
+	*model.Task
+	Progress float64 `json:"progress"`
+}
+
+func (ic *InternalController) ReindexTenant(c *gin.Context) {
+	tid := c.Param("tenant_id")
+
+	ctx := c.Request.Context()
+	ctx = identity.WithContext(ctx, &identity.Identity{Tenant: tid})
+
+	taskID, err := ic.reporting.ReindexTenant(ctx, tid)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, reindexTenantRes{TaskID: taskID})
+}
+
+func (ic *InternalController) ReindexTenantStatus(c *gin.Context) {
+	tid := c.Param("tenant_id")
+
+	ctx := c.Request.Context()
+	ctx = identity.WithContext(ctx, &identity.Identity{Tenant: tid})
+
+	task, err := ic.reporting.GetReindexTenantStatus(ctx, tid)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, reindexTenantStatusRes{
+		Task:     task,
+		Progress: task.Progress(),
+	})
+}
+
+func (ic *InternalController) CancelReindexTenant(c *gin.Context) {
+	tid := c.Param("tenant_id")
+
+	ctx := c.Request.Context()
+	ctx = identity.WithContext(ctx, &identity.Identity{Tenant: tid})
+
+	err := ic.reporting.CancelReindexTenant(ctx, tid)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+type deleteTenantDevicesRes struct {
+	Deleted int `json:"deleted"`
+}
+
+// DeleteTenantDevices purges all the tenant's devices; the purge must be
+// explicitly confirmed with confirm=true
+func (ic *InternalController) DeleteTenantDevices(c *gin.Context) {
+	tid := c.Param("tenant_id")
+
+	if confirm, _ := strconv.ParseBool(c.Query("confirm")); !confirm {
+		renderError(c, ErrPurgeNotConfirmed)
+		return
+	}
+
+	ctx := c.Request.Context()
+	ctx = identity.WithContext(ctx, &identity.Identity{Tenant: tid})
+
+	deleted, err := ic.reporting.DeleteTenantDevices(ctx, tid)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, deleteTenantDevicesRes{Deleted: deleted})
+}
+
+// RawAggregations runs a raw ES aggregations object over the tenant's
+// devices and returns the raw results; the aggregations are validated,
+// and restricted to the tenant's devices
+func (ic *InternalController) RawAggregations(c *gin.Context) {
+	if !ic.rawAggregations.Enabled {
+		renderError(c, ErrFeatureDisabled)
+		return
+	}
+
+	tid := c.Param("tenant_id")
+
+	ctx := c.Request.Context()
+	ctx = identity.WithContext(ctx, &identity.Identity{Tenant: tid})
+
+	var params model.RawAggsParams
+	if err := c.ShouldBindJSON(&params); err != nil {
+		renderError(c, badRequest(errors.Wrap(err, "malformed request body")))
+		return
+	}
+	params.TenantID = tid
+	params.AllowScripts = ic.rawAggregations.AllowScripts
+	if err := params.Validate(); err != nil {
+		renderError(c, err)
+		return
+	}
+
+	res, err := ic.reporting.GetRawAggregations(ctx, &params)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, model.M{"aggregations": res})
+}
+
+// IndexSettings returns the settings of the tenant's devices index, for support
+func (ic *InternalController) IndexSettings(c *gin.Context) {
+	tid := c.Param("tenant_id")
+
+	ctx := c.Request.Context()
+	ctx = identity.WithContext(ctx, &identity.Identity{Tenant: tid})
+
+	settings, err := ic.reporting.GetIndexSettings(ctx, tid)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, settings)
 }
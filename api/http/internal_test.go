@@ -29,7 +29,8 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 
-	"github.com/mendersoftware/go-lib-micro/rest.utils"
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/requestid"
 
 	"github.com/mendersoftware/reporting/app/reporting"
 	mapp "github.com/mendersoftware/reporting/app/reporting/mocks"
@@ -170,8 +171,11 @@ func TestInternalSearch(t *testing.T) {
 			}},
 			TenantID: "123456789012345678901234",
 		},
-		Code:     http.StatusBadRequest,
-		Response: rest.Error{Err: "malformed request body: type: must be a valid value."},
+		Code: http.StatusBadRequest,
+		Response: ErrorResponse{
+			Code: ErrCodeBadRequest,
+			Err:  "malformed request body: filters[0]: type: must be a valid value.",
+		},
 	}, {
 		Name: "error, internal app error",
 
@@ -203,7 +207,7 @@ func TestInternalSearch(t *testing.T) {
 		},
 
 		Code:     http.StatusInternalServerError,
-		Response: rest.Error{Err: "internal error"},
+		Response: ErrorResponse{Code: ErrCodeInternalServerError, Err: "internal error"},
 	}}
 	for i := range testCases {
 		tc := testCases[i]
@@ -235,13 +239,14 @@ func TestInternalSearch(t *testing.T) {
 				b, _ := json.Marshal(res)
 				assert.JSONEq(t, string(b), w.Body.String())
 
-			case rest.Error:
-				var actual rest.Error
+			case ErrorResponse:
+				var actual ErrorResponse
 				dec := json.NewDecoder(w.Body)
 				dec.DisallowUnknownFields()
 				err := dec.Decode(&actual)
-				if assert.NoError(t, err, "response schema did not match expected rest.Error") {
+				if assert.NoError(t, err, "response schema did not match expected ErrorResponse") {
 					assert.EqualError(t, res, actual.Error())
+					assert.Equal(t, res.Code, actual.Code)
 				}
 
 			case nil:
@@ -255,6 +260,74 @@ func TestInternalSearch(t *testing.T) {
 	}
 }
 
+func TestInternalSearchProfile(t *testing.T) {
+	t.Parallel()
+	devs := []model.InvDevice{{ID: "dev1"}}
+	profile := map[string]interface{}{
+		"shards": []interface{}{map[string]interface{}{"id": "[node1][devices][0]"}},
+	}
+	testCases := map[string]struct {
+		Enabled bool
+
+		Code     int
+		Response interface{}
+	}{
+		"ok": {
+			Enabled: true,
+			Code:    http.StatusOK,
+			Response: searchEnvelope{
+				Items:   devs,
+				Page:    ParamPageDefault,
+				PerPage: ParamPerPageDefault,
+				Total:   1,
+				Profile: profile,
+			},
+		},
+		"error, disabled": {
+			Code: http.StatusBadRequest,
+			Response: ErrorResponse{
+				Code: ErrCodeSearchProfileOff,
+				Err:  ErrSearchProfileDisabled.Error(),
+			},
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			app := new(mapp.App)
+			if tc.Enabled {
+				app.On("InventorySearchDevicesInfo",
+					contextMatcher,
+					mock.MatchedBy(func(params *model.SearchParams) bool {
+						return params.Profile &&
+							params.TenantID == "123456789012345678901234"
+					})).
+					Return(devs, 1, &model.SearchInfo{Profile: profile}, nil)
+			}
+			defer app.AssertExpectations(t)
+			router := NewRouter(app, WithSearchProfile(tc.Enabled))
+
+			repl := strings.NewReplacer(":tenant_id", "123456789012345678901234")
+			req, _ := http.NewRequest(
+				http.MethodPost,
+				URIInternal+repl.Replace(URIInventorySearchInternal),
+				strings.NewReader(`{"profile": true}`),
+			)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.Code, w.Code)
+			b, _ := json.Marshal(tc.Response)
+			if res, ok := tc.Response.(ErrorResponse); ok {
+				res.RequestID = w.Header().Get(requestid.RequestIdHeader)
+				b, _ = json.Marshal(res)
+			}
+			assert.JSONEq(t, string(b), w.Body.String())
+		})
+	}
+}
+
 func TestReindex(t *testing.T) {
 	t.Parallel()
 	type testCase struct {
@@ -286,6 +359,56 @@ func TestReindex(t *testing.T) {
 
 		Code:     http.StatusAccepted,
 		Response: nil,
+	}, {
+		Name: "ok, with the changes",
+
+		App: func(t *testing.T, self testCase) *mapp.App {
+			app := new(mapp.App)
+			app.On("ReindexDevice", contextMatcher, self.TenantID,
+				self.DeviceID, "inventory").
+				Return(self.Response, nil)
+			return app
+		},
+		TenantID: "123456789012345678901234",
+		DeviceID: "3ff2da3a-342f-45a1-b7f7-d79c080db5f1",
+		Q: url.Values{
+			"service": []string{"inventory"},
+			"changes": []string{"true"},
+		},
+
+		Code: http.StatusOK,
+		Response: &model.ReindexResult{
+			DeviceID: "3ff2da3a-342f-45a1-b7f7-d79c080db5f1",
+			Changes: []model.AttributeChange{{
+				Scope:    "inventory",
+				Name:     "kernel",
+				OldValue: []interface{}{"5.10"},
+				NewValue: []interface{}{"5.15"},
+			}},
+		},
+	}, {
+		Name: "error, with the changes, device not found",
+
+		App: func(t *testing.T, self testCase) *mapp.App {
+			app := new(mapp.App)
+			app.On("ReindexDevice", contextMatcher, self.TenantID,
+				self.DeviceID, "inventory").
+				Return(nil, errors.Wrap(reporting.ErrDeviceNotFound, self.DeviceID))
+			return app
+		},
+		TenantID: "123456789012345678901234",
+		DeviceID: "3ff2da3a-342f-45a1-b7f7-d79c080db5f1",
+		Q: url.Values{
+			"service": []string{"inventory"},
+			"changes": []string{"true"},
+		},
+
+		Code: http.StatusNotFound,
+		Response: ErrorResponse{
+			Code: ErrCodeDeviceNotFound,
+			Err: "3ff2da3a-342f-45a1-b7f7-d79c080db5f1: " +
+				reporting.ErrDeviceNotFound.Error(),
+		},
 	}, {
 		Name: "error, service unknown",
 
@@ -303,8 +426,9 @@ func TestReindex(t *testing.T) {
 		},
 
 		Code: http.StatusBadRequest,
-		Response: rest.Error{
-			Err: reporting.ErrUnknownService.Error(),
+		Response: ErrorResponse{
+			Code: ErrCodeUnknownService,
+			Err:  reporting.ErrUnknownService.Error(),
 		},
 	}, {
 		Name: "error, internal error",
@@ -320,8 +444,30 @@ func TestReindex(t *testing.T) {
 		DeviceID: "3ff2da3a-342f-45a1-b7f7-d79c080db5f1",
 
 		Code: http.StatusInternalServerError,
-		Response: rest.Error{
-			Err: http.StatusText(http.StatusInternalServerError),
+		Response: ErrorResponse{
+			Code: ErrCodeInternalServerError,
+			Err:  errMsgInternalServerError,
+		},
+	}, {
+		Name: "error, reindex queue full",
+
+		App: func(t *testing.T, self testCase) *mapp.App {
+			app := new(mapp.App)
+			app.On("Reindex", contextMatcher, self.TenantID,
+				self.DeviceID, "inventory").
+				Return(reporting.ErrReindexChannelFull)
+			return app
+		},
+		TenantID: "123456789012345678901234",
+		DeviceID: "3ff2da3a-342f-45a1-b7f7-d79c080db5f1",
+		Q: url.Values{
+			"service": []string{"inventory"},
+		},
+
+		Code: http.StatusTooManyRequests,
+		Response: ErrorResponse{
+			Code: ErrCodeReindexQueueFull,
+			Err:  reporting.ErrReindexChannelFull.Error(),
 		},
 	}}
 	for i := range testCases {
@@ -353,8 +499,8 @@ func TestReindex(t *testing.T) {
 			assert.Equal(t, tc.Code, w.Code)
 
 			switch typ := tc.Response.(type) {
-			case rest.Error:
-				var actual rest.Error
+			case ErrorResponse:
+				var actual ErrorResponse
 				dec := json.NewDecoder(w.Body)
 				dec.DisallowUnknownFields()
 				err := dec.Decode(&actual)
@@ -362,6 +508,9 @@ func TestReindex(t *testing.T) {
 					assert.EqualError(t, actual, typ.Error())
 				}
 
+			case *model.ReindexResult:
+				b, _ := json.Marshal(typ)
+				assert.JSONEq(t, string(b), w.Body.String())
 			case nil:
 				assert.Empty(t, w.Body.Bytes())
 			default:
@@ -370,3 +519,642 @@ func TestReindex(t *testing.T) {
 		})
 	}
 }
+
+func TestDeleteTenantDevices(t *testing.T) {
+	t.Parallel()
+	type testCase struct {
+		Name string
+
+		App      func(*testing.T, testCase) *mapp.App
+		TenantID string
+		Q        url.Values
+		Token    string
+
+		Code     int
+		Response interface{}
+	}
+	testCases := []testCase{{
+		Name: "ok",
+
+		App: func(t *testing.T, self testCase) *mapp.App {
+			app := new(mapp.App)
+			app.On("DeleteTenantDevices", contextMatcher, self.TenantID).
+				Return(42, nil)
+			return app
+		},
+		TenantID: "123456789012345678901234",
+		Q: url.Values{
+			"confirm": []string{"true"},
+		},
+
+		Code:     http.StatusOK,
+		Response: deleteTenantDevicesRes{Deleted: 42},
+	}, {
+		Name: "error, tenant token",
+
+		TenantID: "123456789012345678901234",
+		Q: url.Values{
+			"confirm": []string{"true"},
+		},
+		Token: GenerateJWT(identity.Identity{
+			Subject: "user",
+			Tenant:  "123456789012345678901234",
+		}),
+
+		Code: http.StatusForbidden,
+		Response: ErrorResponse{
+			Code: ErrCodeForbidden,
+			Err:  "tenant tokens are not allowed",
+		},
+	}, {
+		Name: "error, not confirmed",
+
+		TenantID: "123456789012345678901234",
+
+		Code: http.StatusBadRequest,
+		Response: ErrorResponse{
+			Code: ErrCodePurgeNotConfirmed,
+			Err:  ErrPurgeNotConfirmed.Error(),
+		},
+	}, {
+		Name: "error, confirmation declined",
+
+		TenantID: "123456789012345678901234",
+		Q: url.Values{
+			"confirm": []string{"false"},
+		},
+
+		Code: http.StatusBadRequest,
+		Response: ErrorResponse{
+			Code: ErrCodePurgeNotConfirmed,
+			Err:  ErrPurgeNotConfirmed.Error(),
+		},
+	}, {
+		Name: "error, internal error",
+
+		App: func(t *testing.T, self testCase) *mapp.App {
+			app := new(mapp.App)
+			app.On("DeleteTenantDevices", contextMatcher, self.TenantID).
+				Return(0, errors.New("internal error"))
+			return app
+		},
+		TenantID: "123456789012345678901234",
+		Q: url.Values{
+			"confirm": []string{"true"},
+		},
+
+		Code: http.StatusInternalServerError,
+		Response: ErrorResponse{
+			Code: ErrCodeInternalServerError,
+			Err:  errMsgInternalServerError,
+		},
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			var app *mapp.App
+			if tc.App == nil {
+				app = new(mapp.App)
+			} else {
+				app = tc.App(t, tc)
+			}
+			defer app.AssertExpectations(t)
+			router := NewRouter(app)
+
+			req, _ := http.NewRequest(
+				http.MethodDelete,
+				URIInternal+strings.Replace(
+					URITenantDevicesInternal, ":tenant_id", tc.TenantID, 1,
+				),
+				nil,
+			)
+			req.URL.RawQuery = tc.Q.Encode()
+			if tc.Token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.Token)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.Code, w.Code)
+
+			switch typ := tc.Response.(type) {
+			case ErrorResponse:
+				var actual ErrorResponse
+				dec := json.NewDecoder(w.Body)
+				dec.DisallowUnknownFields()
+				err := dec.Decode(&actual)
+				if assert.NoError(t, err, "unexpected response schema") {
+					assert.EqualError(t, actual, typ.Error())
+				}
+
+			case deleteTenantDevicesRes:
+				var actual deleteTenantDevicesRes
+				err := json.NewDecoder(w.Body).Decode(&actual)
+				if assert.NoError(t, err) {
+					assert.Equal(t, typ, actual)
+				}
+			default:
+				panic("[TEST ERR] Dunno what to compare!")
+			}
+		})
+	}
+}
+
+func TestInternalIndexSettings(t *testing.T) {
+	t.Parallel()
+	settings := &model.IndexSettings{
+		TenantID: "123456789012345678901234",
+		Index:    "devices",
+		Settings: map[string]interface{}{
+			"number_of_shards":   "2",
+			"number_of_replicas": "1",
+			"refresh_interval":   "5s",
+		},
+	}
+	testCases := map[string]struct {
+		err   error
+		token string
+
+		code     int
+		response interface{}
+	}{
+		"ok": {
+			code:     http.StatusOK,
+			response: settings,
+		},
+		"error, tenant token": {
+			token: GenerateJWT(identity.Identity{
+				Subject: "user",
+				Tenant:  "123456789012345678901234",
+			}),
+			code: http.StatusForbidden,
+			response: ErrorResponse{
+				Code: ErrCodeForbidden,
+				Err:  "tenant tokens are not allowed",
+			},
+		},
+		"error, internal error": {
+			err:  errors.New("failed to get devices index from store"),
+			code: http.StatusInternalServerError,
+			response: ErrorResponse{
+				Code: ErrCodeInternalServerError,
+				Err:  errMsgInternalServerError,
+			},
+		},
+	}
+	for name := range testCases {
+		tc := testCases[name]
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			app := new(mapp.App)
+			var res *model.IndexSettings
+			if tc.err == nil {
+				res = settings
+			}
+			if tc.token == "" {
+				app.On("GetIndexSettings", contextMatcher, "123456789012345678901234").
+					Return(res, tc.err)
+			}
+			defer app.AssertExpectations(t)
+			router := NewRouter(app)
+
+			req, _ := http.NewRequest(
+				http.MethodGet,
+				URIInternal+strings.Replace(URIIndexSettingsInternal,
+					":tenant_id", "123456789012345678901234", 1),
+				nil,
+			)
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.code, w.Code)
+			b, _ := json.Marshal(tc.response)
+			if res, ok := tc.response.(ErrorResponse); ok {
+				res.RequestID = w.Header().Get(requestid.RequestIdHeader)
+				b, _ = json.Marshal(res)
+			}
+			assert.JSONEq(t, string(b), w.Body.String())
+		})
+	}
+}
+
+func TestInternalBulkGetDevices(t *testing.T) {
+	t.Parallel()
+	type testCase struct {
+		Name string
+
+		Body  string
+		Token string
+		App   func(*testing.T, testCase) *mapp.App
+
+		Code     int
+		Response interface{}
+	}
+	res := &model.BulkGetResult{
+		Devices: []model.TenantInvDevice{{
+			TenantID: "tenant1",
+			InvDevice: model.InvDevice{
+				ID: "dev1",
+				Attributes: model.DeviceAttributes{{
+					Name:  "mac",
+					Value: "00:11:22:33:44:55",
+					Scope: model.AttrScopeIdentity,
+				}},
+			},
+		}},
+		// tenant3 has no index yet
+		NotFound: map[string][]string{
+			"tenant1": {"dev2"},
+			"tenant3": {"dev3"},
+		},
+	}
+	testCases := []testCase{{
+		Name: "ok",
+
+		Body: `{"tenant1": ["dev1", "dev2"], "tenant3": ["dev3"]}`,
+		App: func(t *testing.T, self testCase) *mapp.App {
+			app := new(mapp.App)
+			app.On("BulkGetDevices", contextMatcher, model.BulkGetParams{
+				"tenant1": {"dev1", "dev2"},
+				"tenant3": {"dev3"},
+			}).Return(res, nil)
+			return app
+		},
+
+		Code:     http.StatusOK,
+		Response: res,
+	}, {
+		Name: "error, tenant token",
+
+		Body:  `{"tenant1": ["dev1"]}`,
+		Token: GenerateJWT(identity.Identity{Subject: "user", Tenant: "tenant1"}),
+
+		Code:     http.StatusForbidden,
+		Response: ErrorResponse{Code: ErrCodeForbidden, Err: "tenant tokens are not allowed"},
+	}, {
+		Name: "error, no devices",
+
+		Body: `{"tenant1": []}`,
+
+		Code:     http.StatusBadRequest,
+		Response: ErrorResponse{Code: ErrCodeBadRequest, Err: model.ErrBulkGetEmpty.Error()},
+	}, {
+		Name: "error, malformed body",
+
+		Body: `["dev1"]`,
+
+		Code: http.StatusBadRequest,
+		Response: ErrorResponse{Code: ErrCodeBadRequest, Err: "malformed request body: json: " +
+			"cannot unmarshal array into Go value of type model.BulkGetParams"},
+	}, {
+		Name: "error, internal error",
+
+		Body: `{"tenant1": ["dev1"]}`,
+		App: func(t *testing.T, self testCase) *mapp.App {
+			app := new(mapp.App)
+			app.On("BulkGetDevices", contextMatcher, model.BulkGetParams{
+				"tenant1": {"dev1"},
+			}).Return(nil, errors.New("mget failed"))
+			return app
+		},
+
+		Code: http.StatusInternalServerError,
+		Response: ErrorResponse{
+			Code: ErrCodeInternalServerError,
+			Err:  errMsgInternalServerError,
+		},
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			var app *mapp.App
+			if tc.App == nil {
+				app = new(mapp.App)
+			} else {
+				app = tc.App(t, tc)
+			}
+			defer app.AssertExpectations(t)
+			router := NewRouter(app)
+
+			req, _ := http.NewRequest(
+				http.MethodPost,
+				URIInternal+URIDevicesBulkGetInternal,
+				strings.NewReader(tc.Body),
+			)
+			if tc.Token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.Token)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.Code, w.Code)
+
+			switch typ := tc.Response.(type) {
+			case ErrorResponse:
+				var actual ErrorResponse
+				err := json.NewDecoder(w.Body).Decode(&actual)
+				if assert.NoError(t, err, "unexpected response schema") {
+					assert.EqualError(t, actual, typ.Error())
+				}
+			default:
+				b, _ := json.Marshal(tc.Response)
+				assert.JSONEq(t, string(b), w.Body.String())
+			}
+		})
+	}
+}
+
+func TestInternalDevicesExist(t *testing.T) {
+	t.Parallel()
+	type testCase struct {
+		Name string
+
+		Body   string
+		Params *model.DevicesExistParams
+		Result *model.DevicesExistResult
+		Error  error
+
+		Code     int
+		Response interface{}
+	}
+	testCases := []testCase{{
+		Name: "ok, some devices exist",
+
+		Body: `{"tenant_id": "tenant1", "device_ids": ["dev1", "dev2", "dev3"]}`,
+		Params: &model.DevicesExistParams{
+			TenantID:  "tenant1",
+			DeviceIDs: []string{"dev1", "dev2", "dev3"},
+		},
+		Result: &model.DevicesExistResult{DeviceIDs: []string{"dev1", "dev3"}},
+
+		Code:     http.StatusOK,
+		Response: &model.DevicesExistResult{DeviceIDs: []string{"dev1", "dev3"}},
+	}, {
+		Name: "ok, none exist",
+
+		Body: `{"tenant_id": "tenant1", "device_ids": ["dev4"]}`,
+		Params: &model.DevicesExistParams{
+			TenantID:  "tenant1",
+			DeviceIDs: []string{"dev4"},
+		},
+		Result: &model.DevicesExistResult{DeviceIDs: []string{}},
+
+		Code:     http.StatusOK,
+		Response: &model.DevicesExistResult{DeviceIDs: []string{}},
+	}, {
+		Name: "error, no tenant",
+
+		Body: `{"device_ids": ["dev1"]}`,
+
+		Code:     http.StatusBadRequest,
+		Response: ErrorResponse{Code: ErrCodeBadRequest, Err: "tenant_id: cannot be blank."},
+	}, {
+		Name: "error, too many devices",
+
+		Body: `{"tenant_id": "tenant1", "device_ids": [` +
+			strings.Repeat(`"dev",`, model.DevicesExistMaxDevices) + `"dev"]}`,
+
+		Code: http.StatusBadRequest,
+		Response: ErrorResponse{
+			Code: ErrCodeBadRequest,
+			Err:  "device_ids: the length must be between 1 and 1000.",
+		},
+	}, {
+		Name: "error, internal error",
+
+		Body: `{"tenant_id": "tenant1", "device_ids": ["dev1"]}`,
+		Params: &model.DevicesExistParams{
+			TenantID:  "tenant1",
+			DeviceIDs: []string{"dev1"},
+		},
+		Error: errors.New("mget failed"),
+
+		Code: http.StatusInternalServerError,
+		Response: ErrorResponse{
+			Code: ErrCodeInternalServerError,
+			Err:  errMsgInternalServerError,
+		},
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			app := new(mapp.App)
+			if tc.Params != nil {
+				app.On("DevicesExist",
+					mock.MatchedBy(func(ctx context.Context) bool {
+						id := identity.FromContext(ctx)
+						return id != nil && id.Tenant == tc.Params.TenantID
+					}),
+					tc.Params,
+				).Return(tc.Result, tc.Error)
+			}
+			defer app.AssertExpectations(t)
+			router := NewRouter(app)
+
+			req, _ := http.NewRequest(
+				http.MethodPost,
+				URIInternal+URIDevicesExistInternal,
+				strings.NewReader(tc.Body),
+			)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.Code, w.Code)
+
+			switch typ := tc.Response.(type) {
+			case ErrorResponse:
+				var actual ErrorResponse
+				err := json.NewDecoder(w.Body).Decode(&actual)
+				if assert.NoError(t, err, "unexpected response schema") {
+					assert.EqualError(t, actual, typ.Error())
+				}
+			default:
+				b, _ := json.Marshal(tc.Response)
+				assert.JSONEq(t, string(b), w.Body.String())
+			}
+		})
+	}
+}
+
+func TestInternalRawAggregations(t *testing.T) {
+	t.Parallel()
+	aggs := map[string]interface{}{
+		"os": map[string]interface{}{
+			"terms": map[string]interface{}{"field": "inventory_os_str"},
+		},
+	}
+	type testCase struct {
+		Name string
+
+		Config RawAggregationsConfig
+		Body   string
+		Token  string
+		Params *model.RawAggsParams
+		Result model.M
+		Error  error
+
+		Code     int
+		Response interface{}
+	}
+	testCases := []testCase{{
+		Name: "ok",
+
+		Config: RawAggregationsConfig{Enabled: true},
+		Body:   `{"aggs": {"os": {"terms": {"field": "inventory_os_str"}}}}`,
+		Params: &model.RawAggsParams{TenantID: "tenant1", Aggs: aggs},
+		Result: model.M{"os": map[string]interface{}{"buckets": []interface{}{}}},
+
+		Code:     http.StatusOK,
+		Response: model.M{"aggregations": model.M{"os": model.M{"buckets": []interface{}{}}}},
+	}, {
+		Name: "ok, scripts allowed",
+
+		Config: RawAggregationsConfig{Enabled: true, AllowScripts: true},
+		Body:   `{"aggs": {"os": {"terms": {"field": "inventory_os_str"}}}}`,
+		Params: &model.RawAggsParams{TenantID: "tenant1", Aggs: aggs, AllowScripts: true},
+		Result: model.M{},
+
+		Code:     http.StatusOK,
+		Response: model.M{"aggregations": model.M{}},
+	}, {
+		Name: "error, disabled",
+
+		Body: `{"aggs": {"os": {"terms": {"field": "inventory_os_str"}}}}`,
+
+		Code: http.StatusNotImplemented,
+		Response: ErrorResponse{
+			Code: ErrCodeFeatureDisabled,
+			Err:  ErrFeatureDisabled.Error(),
+		},
+	}, {
+		Name: "error, tenant token",
+
+		Config: RawAggregationsConfig{Enabled: true},
+		Body:   `{"aggs": {"os": {"terms": {"field": "inventory_os_str"}}}}`,
+		Token:  GenerateJWT(identity.Identity{Subject: "user", Tenant: "tenant1"}),
+
+		Code: http.StatusForbidden,
+		Response: ErrorResponse{
+			Code: ErrCodeForbidden,
+			Err:  "tenant tokens are not allowed",
+		},
+	}, {
+		Name: "error, malformed body",
+
+		Config: RawAggregationsConfig{Enabled: true},
+		Body:   `{"aggs": [}`,
+
+		Code: http.StatusBadRequest,
+		Response: ErrorResponse{
+			Code: ErrCodeBadRequest,
+			Err: "malformed request body: " +
+				"invalid character '}' looking for beginning of value",
+		},
+	}, {
+		Name: "error, scripts not allowed",
+
+		Config: RawAggregationsConfig{Enabled: true},
+		Body:   `{"aggs": {"mem": {"sum": {"script": "1"}}}}`,
+
+		Code: http.StatusBadRequest,
+		Response: ErrorResponse{
+			Code: ErrCodeInvalidAggregations,
+			Err:  "aggs.mem.sum.script: scripts are not allowed: invalid aggregations",
+		},
+	}, {
+		Name: "error, internal error",
+
+		Config: RawAggregationsConfig{Enabled: true},
+		Body:   `{"aggs": {"os": {"terms": {"field": "inventory_os_str"}}}}`,
+		Params: &model.RawAggsParams{TenantID: "tenant1", Aggs: aggs},
+		Error:  errors.New("search failed"),
+
+		Code: http.StatusInternalServerError,
+		Response: ErrorResponse{
+			Code: ErrCodeInternalServerError,
+			Err:  errMsgInternalServerError,
+		},
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			app := new(mapp.App)
+			if tc.Params != nil {
+				app.On("GetRawAggregations",
+					mock.MatchedBy(func(ctx context.Context) bool {
+						id := identity.FromContext(ctx)
+						return id != nil && id.Tenant == tc.Params.TenantID
+					}),
+					tc.Params,
+				).Return(tc.Result, tc.Error)
+			}
+			defer app.AssertExpectations(t)
+			router := NewRouter(app, WithRawAggregations(tc.Config))
+
+			req, _ := http.NewRequest(
+				http.MethodPost,
+				URIInternal+strings.Replace(URIRawAggsInternal,
+					":tenant_id", "tenant1", 1),
+				strings.NewReader(tc.Body),
+			)
+			if tc.Token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.Token)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.Code, w.Code)
+
+			switch typ := tc.Response.(type) {
+			case ErrorResponse:
+				var actual ErrorResponse
+				err := json.NewDecoder(w.Body).Decode(&actual)
+				if assert.NoError(t, err, "unexpected response schema") {
+					assert.EqualError(t, actual, typ.Error())
+				}
+			default:
+				b, _ := json.Marshal(tc.Response)
+				assert.JSONEq(t, string(b), w.Body.String())
+			}
+		})
+	}
+}
+
+func TestInternalVersion(t *testing.T) {
+	t.Parallel()
+	testCases := map[string]*model.Version{
+		"ok": {
+			Version:       "3.2.0",
+			Elasticsearch: "7.15.1",
+		},
+		"ok, elasticsearch unreachable": {
+			Version:       "3.2.0",
+			Elasticsearch: model.VersionUnknown,
+		},
+	}
+	for name := range testCases {
+		version := testCases[name]
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			app := new(mapp.App)
+			app.On("GetVersion", contextMatcher).Return(version)
+			defer app.AssertExpectations(t)
+			router := NewRouter(app)
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodGet, URIInternal+URIVersion, nil)
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			b, _ := json.Marshal(version)
+			assert.JSONEq(t, string(b), w.Body.String())
+		})
+	}
+}
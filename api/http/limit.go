@@ -0,0 +1,77 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	hdrRetryAfter = "Retry-After"
+
+	// ctxKeySearchLimit is the gin context key of the SearchLimitConfig
+	ctxKeySearchLimit = "reporting.searchLimit"
+
+	// SearchLimitRetryAfterDefault is the default Retry-After of the
+	// searches rejected by the concurrency limit
+	SearchLimitRetryAfterDefault = time.Second
+)
+
+// SearchLimitConfig configures the responses to the searches rejected
+// because too many are in flight, see reporting.WithMaxConcurrentSearches
+type SearchLimitConfig struct {
+	// Status is the status of the responses, either 503 (the default)
+	// or 429
+	Status int
+	// RetryAfter is sent as the Retry-After header, rounded up to seconds
+	RetryAfter time.Duration
+}
+
+func (conf SearchLimitConfig) status() int {
+	if conf.Status == http.StatusTooManyRequests {
+		return conf.Status
+	}
+	return http.StatusServiceUnavailable
+}
+
+func (conf SearchLimitConfig) retryAfter() string {
+	retryAfter := conf.RetryAfter
+	if retryAfter <= 0 {
+		retryAfter = SearchLimitRetryAfterDefault
+	}
+	return strconv.Itoa(int(math.Ceil(retryAfter.Seconds())))
+}
+
+// searchLimitMiddleware makes the config available to renderError
+func searchLimitMiddleware(conf SearchLimitConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(ctxKeySearchLimit, conf)
+		c.Next()
+	}
+}
+
+// renderSearchLimit sets the Retry-After header of a search rejected by
+// the concurrency limit, and returns the status of the response
+func renderSearchLimit(c *gin.Context) int {
+	v, _ := c.Get(ctxKeySearchLimit)
+	conf, _ := v.(SearchLimitConfig)
+	c.Header(hdrRetryAfter, conf.retryAfter())
+	return conf.status()
+}
@@ -0,0 +1,101 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/requestid"
+
+	"github.com/mendersoftware/reporting/app/reporting"
+	mapp "github.com/mendersoftware/reporting/app/reporting/mocks"
+)
+
+func TestSearchLimit(t *testing.T) {
+	t.Parallel()
+	testCases := map[string]struct {
+		conf *SearchLimitConfig
+
+		code       int
+		retryAfter string
+	}{
+		"default": {
+			code:       http.StatusServiceUnavailable,
+			retryAfter: "1",
+		},
+		"too many requests": {
+			conf: &SearchLimitConfig{
+				Status:     http.StatusTooManyRequests,
+				RetryAfter: 2500 * time.Millisecond,
+			},
+			code:       http.StatusTooManyRequests,
+			retryAfter: "3",
+		},
+		"unsupported status": {
+			conf: &SearchLimitConfig{
+				Status:     http.StatusBadRequest,
+				RetryAfter: 5 * time.Second,
+			},
+			code:       http.StatusServiceUnavailable,
+			retryAfter: "5",
+		},
+	}
+	for name := range testCases {
+		tc := testCases[name]
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			a := new(mapp.App)
+			a.On("InventorySearchDevices", contextMatcher, mock.Anything).
+				Return(nil, 0, reporting.ErrTooManySearches)
+			defer a.AssertExpectations(t)
+
+			var opts []RouterOption
+			if tc.conf != nil {
+				opts = append(opts, WithSearchLimit(*tc.conf))
+			}
+			router := NewRouter(a, opts...)
+
+			req, _ := http.NewRequest(
+				http.MethodPost,
+				URIManagement+URIInventorySearch,
+				strings.NewReader(`{}`),
+			)
+			req.Header.Set("Authorization", "Bearer "+GenerateJWT(identity.Identity{
+				Subject: "851f90b3-cee5-425e-8f6e-b36de1993e7e",
+				Tenant:  "123456789012345678901234",
+			}))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.code, w.Code)
+			assert.Equal(t, tc.retryAfter, w.Header().Get(hdrRetryAfter))
+			b, _ := json.Marshal(ErrorResponse{
+				Code:      ErrCodeTooManySearches,
+				Err:       reporting.ErrTooManySearches.Error(),
+				RequestID: w.Header().Get(requestid.RequestIdHeader),
+			})
+			assert.JSONEq(t, string(b), w.Body.String())
+		})
+	}
+}
@@ -16,17 +16,21 @@ package http
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"mime"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/pkg/errors"
 
 	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/log"
 	"github.com/mendersoftware/go-lib-micro/rbac"
-	"github.com/mendersoftware/go-lib-micro/rest.utils"
 
 	"github.com/mendersoftware/reporting/app/reporting"
 	"github.com/mendersoftware/reporting/model"
@@ -37,10 +41,64 @@ const (
 	ParamPerPageDefault = 20
 
 	hdrTotalCount = "X-Total-Count"
+	// hdrTerminatedEarly is set if the search was cut short by terminate_after
+	hdrTerminatedEarly = "X-Terminated-Early"
+	// hdrTimedOut is set if the search hit its timeout, i.e. the results
+	// are partial
+	hdrTimedOut = "X-Timed-Out"
+	// hdrHasMore tells if there's a next page, when the total isn't counted
+	hdrHasMore = "X-Has-More"
+
+	// MediaTypeEnvelope is the media type clients can request in the
+	// Accept header to receive search results wrapped in an envelope
+	MediaTypeEnvelope = "application/vnd.mender.envelope+json"
+
+	paramEnvelope = "envelope"
+	paramSize     = "size"
+	paramScope    = "scope"
+	paramAttr     = "attribute"
+	paramFrom     = "from"
+	paramTo       = "to"
+	paramDeviceA  = "device_a"
+	paramDeviceB  = "device_b"
+	paramSince    = "since"
+	paramCursor   = "cursor"
+	paramValidate = "validate"
+	paramGroupBy  = "group_by"
+
+	// groupByScope groups the device's attributes by scope
+	groupByScope = "scope"
+
+	mediaTypeNDJSON = "application/x-ndjson"
+
+	// exportFlushInterval is the num of exported devices after which
+	// the response is flushed to the client
+	exportFlushInterval = 100
 )
 
+// searchEnvelope wraps the search results with the pagination metadata
+type searchEnvelope struct {
+	Items   interface{} `json:"items"`
+	Page    int         `json:"page"`
+	PerPage int         `json:"per_page"`
+	Total   int         `json:"total"`
+	// TerminatedEarly is set if the search was cut short by terminate_after
+	TerminatedEarly bool `json:"terminated_early,omitempty"`
+	// TimedOut is set if the search hit its timeout
+	TimedOut bool `json:"timed_out,omitempty"`
+	// HasMore tells if there's a next page, when the total isn't counted
+	HasMore *bool `json:"has_more,omitempty"`
+	// Profile is the ES query profile, if requested (internal API only)
+	Profile interface{} `json:"profile,omitempty"`
+}
+
 type ManagementController struct {
 	reporting reporting.App
+
+	// cache caches the results of the aggregation endpoints, if enabled
+	cache *aggregationCache
+	// strictDecoding rejects the unknown fields of the search params
+	strictDecoding bool
 }
 
 func NewManagementController(r reporting.App) *ManagementController {
@@ -51,45 +109,263 @@ func NewManagementController(r reporting.App) *ManagementController {
 
 func (mc *ManagementController) Search(c *gin.Context) {
 	ctx := c.Request.Context()
-	params, err := parseSearchParams(ctx, c)
+	params, err := parseSearchParams(ctx, c, mc.strictDecoding)
 	if err != nil {
-		rest.RenderError(c,
-			http.StatusBadRequest,
-			errors.Wrap(err, "malformed request body"),
-		)
+		renderError(c, badRequest(errors.Wrap(err, "malformed request body")))
 		return
 	}
 
+	if err := checkAttributes(c, searchAttributes(params)); err != nil {
+		renderError(c, err)
+		return
+	}
 	if scope := rbac.ExtractScopeFromHeader(c.Request); scope != nil {
 		params.Groups = scope.DeviceGroups
 	}
-	res, total, err := mc.reporting.InventorySearchDevices(ctx, params)
+	// the query profiling is only available on the internal API
+	params.Profile = false
+
+	if validate, _ := strconv.ParseBool(c.Query(paramValidate)); validate {
+		validation, err := mc.reporting.ValidateSearch(ctx, params)
+		if err != nil {
+			renderError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, validation)
+		return
+	}
+
+	searchDevices(ctx, c, mc.reporting, params)
+}
+
+// searchDevices searches the devices and renders the results; the search
+// metadata is only fetched if the search params may produce any
+func searchDevices(
+	ctx context.Context,
+	c *gin.Context,
+	app reporting.App,
+	params *model.SearchParams,
+) {
+	var (
+		res   []model.InvDevice
+		total int
+		info  *model.SearchInfo
+		err   error
+	)
+	if params.Profile || params.TerminateAfter > 0 || params.Timeout != "" ||
+		!params.TrackTotalHits.CountsAll() {
+		res, total, info, err = app.InventorySearchDevicesInfo(ctx, params)
+	} else {
+		res, total, err = app.InventorySearchDevices(ctx, params)
+	}
 	if err != nil {
-		rest.RenderError(c,
-			http.StatusInternalServerError,
-			err,
-		)
+		renderError(c, err)
 		return
 	}
+	for i := range res {
+		filterAttributes(c, &res[i])
+	}
 
-	pageLinkHdrs(c, params.Page, params.PerPage, total)
+	renderSearchResult(c, params, res, total, info)
+}
 
+// renderSearchResult renders the search results either as a bare array,
+// or wrapped in an envelope if the client asked for it (or a profile,
+// which only fits in the envelope); the pagination headers are set
+// in both cases
+func renderSearchResult(
+	c *gin.Context,
+	params *model.SearchParams,
+	res interface{},
+	total int,
+	info *model.SearchInfo,
+) {
+	if info == nil {
+		info = &model.SearchInfo{}
+	}
+
+	// without the total count, the next page is known from has-more
+	var hasMore *bool
+	hasNext := total > (params.PerPage*params.Page - 1)
+	if !params.TrackTotalHits.CountsAll() {
+		hasMore = &info.HasMore
+		hasNext = info.HasMore
+		c.Header(hdrHasMore, strconv.FormatBool(info.HasMore))
+	}
+
+	pageLinkHdrs(c, params.Page, params.PerPage, hasNext)
 	c.Header(hdrTotalCount, strconv.Itoa(total))
+	if info.TerminatedEarly {
+		c.Header(hdrTerminatedEarly, "true")
+	}
+	if info.TimedOut {
+		c.Header(hdrTimedOut, "true")
+	}
+
+	if wantsEnvelope(c) || params.Profile {
+		c.JSON(http.StatusOK, searchEnvelope{
+			Items:           res,
+			Page:            params.Page,
+			PerPage:         params.PerPage,
+			Total:           total,
+			TerminatedEarly: info.TerminatedEarly,
+			TimedOut:        info.TimedOut,
+			HasMore:         hasMore,
+			Profile:         info.Profile,
+		})
+		return
+	}
 	c.JSON(http.StatusOK, res)
 }
 
-func parseSearchParams(ctx context.Context, c *gin.Context) (*model.SearchParams, error) {
+// wantsEnvelope checks whether the client opted in to the response envelope,
+// either with the 'envelope' query parameter or the MediaTypeEnvelope
+// media type in the Accept header
+func wantsEnvelope(c *gin.Context) bool {
+	if v, ok := c.GetQuery(paramEnvelope); ok {
+		envelope, _ := strconv.ParseBool(v)
+		return envelope
+	}
+	for _, accept := range strings.Split(c.GetHeader("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err == nil && mediaType == MediaTypeEnvelope {
+			return true
+		}
+	}
+	return false
+}
+
+// Export streams all the devices matching the search params (ignoring the
+// pagination) as newline-delimited JSON, one device per line
+func (mc *ManagementController) Export(c *gin.Context) {
+	ctx := c.Request.Context()
+	l := log.FromContext(ctx)
+
+	params, err := parseSearchParams(ctx, c, mc.strictDecoding)
+	if err != nil {
+		renderError(c, badRequest(errors.Wrap(err, "malformed request body")))
+		return
+	}
+
+	if err := checkAttributes(c, searchAttributes(params)); err != nil {
+		renderError(c, err)
+		return
+	}
+	if scope := rbac.ExtractScopeFromHeader(c.Request); scope != nil {
+		params.Groups = scope.DeviceGroups
+	}
+
+	// the response is committed with the first device; any later error
+	// can only be logged, and the client sees a truncated stream
+	count := 0
+	enc := json.NewEncoder(c.Writer)
+	emit := func(dev *model.InvDevice) error {
+		filterAttributes(c, dev)
+		if count == 0 {
+			c.Header("Content-Type", mediaTypeNDJSON)
+			c.Status(http.StatusOK)
+		}
+		if err := enc.Encode(dev); err != nil {
+			return err
+		}
+		count++
+		if count%exportFlushInterval == 0 {
+			c.Writer.Flush()
+		}
+		return nil
+	}
+
+	// the request context is cancelled when the client disconnects,
+	// which stops the iteration
+	err = mc.reporting.ExportDevices(ctx, params, emit)
+	switch {
+	case err != nil && count == 0:
+		renderError(c, err)
+	case err != nil:
+		l.Errorf("export aborted after %d devices: %v", count, err)
+	case count == 0:
+		c.Data(http.StatusOK, mediaTypeNDJSON, nil)
+	default:
+		c.Writer.Flush()
+	}
+}
+
+// Scan returns a page of the devices matching the search params, along with
+// the cursor of the next page; unlike the search pagination, the scan pages
+// through a snapshot of the devices, and can be resumed with the cursor
+func (mc *ManagementController) Scan(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var params model.ScanParams
+	if err := bindJSON(c, &params, mc.strictDecoding); err != nil {
+		renderError(c, badRequest(errors.Wrap(err, "malformed request body")))
+		return
+	}
+	if err := completeSearchParams(ctx, &params.SearchParams); err != nil {
+		renderError(c, badRequest(errors.Wrap(err, "malformed request body")))
+		return
+	}
+	if err := checkAttributes(c, searchAttributes(&params.SearchParams)); err != nil {
+		renderError(c, err)
+		return
+	}
+
+	if scope := rbac.ExtractScopeFromHeader(c.Request); scope != nil {
+		params.Groups = scope.DeviceGroups
+	}
+
+	page, err := mc.reporting.ScanDevices(ctx, &params)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	for i := range page.Devices {
+		filterAttributes(c, &page.Devices[i])
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// bindJSON binds the JSON request body to obj; in strict mode, the unknown
+// fields (e.g. misspelled ones) are rejected instead of ignored
+func bindJSON(c *gin.Context, obj interface{}, strict bool) error {
+	if !strict {
+		return c.ShouldBindJSON(obj)
+	}
+	if c.Request.Body == nil {
+		return errors.New("missing request body")
+	}
+	dec := json.NewDecoder(c.Request.Body)
+	dec.DisallowUnknownFields()
+	return dec.Decode(obj)
+}
+
+func parseSearchParams(
+	ctx context.Context,
+	c *gin.Context,
+	strict bool,
+) (*model.SearchParams, error) {
 	var searchParams model.SearchParams
 
-	err := c.ShouldBindJSON(&searchParams)
+	err := bindJSON(c, &searchParams, strict)
 	if err != nil {
 		return nil, err
 	}
 
+	if err := completeSearchParams(ctx, &searchParams); err != nil {
+		return nil, err
+	}
+
+	return &searchParams, nil
+}
+
+// completeSearchParams sets the tenant and the pagination defaults
+// of the search params, and validates them
+func completeSearchParams(ctx context.Context, searchParams *model.SearchParams) error {
 	if id := identity.FromContext(ctx); id != nil {
 		searchParams.TenantID = id.Tenant
 	} else {
-		return nil, errors.New("missing tenant ID from the context")
+		return errors.New("missing tenant ID from the context")
 	}
 
 	if searchParams.PerPage <= 0 {
@@ -99,14 +375,10 @@ func parseSearchParams(ctx context.Context, c *gin.Context) (*model.SearchParams
 		searchParams.Page = ParamPageDefault
 	}
 
-	if err := searchParams.Validate(); err != nil {
-		return nil, err
-	}
-
-	return &searchParams, nil
+	return searchParams.Validate()
 }
 
-func pageLinkHdrs(c *gin.Context, page, perPage, total int) {
+func pageLinkHdrs(c *gin.Context, page, perPage int, hasNext bool) {
 	url := &url.URL{
 		Path:     c.Request.URL.Path,
 		RawQuery: c.Request.URL.RawQuery,
@@ -127,7 +399,7 @@ func pageLinkHdrs(c *gin.Context, page, perPage, total int) {
 	}
 
 	// Next page
-	if total > (perPage*page - 1) {
+	if hasNext {
 		query.Set("page", fmt.Sprintf("%d", page+1))
 		url.RawQuery = query.Encode()
 		Link = fmt.Sprintf(`%s, <%s>;rel="next"`, Link, url.String())
@@ -142,12 +414,385 @@ func (mc *ManagementController) SearchAttrs(c *gin.Context) {
 	id := identity.FromContext(ctx)
 	res, err := mc.reporting.GetSearchableInvAttrs(ctx, id.Tenant)
 	if err != nil {
-		rest.RenderError(c,
-			http.StatusInternalServerError,
-			err,
-		)
+		renderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, filterSearchableAttrs(c, res))
+}
+
+// AttributesMetadata returns the searchable attributes with their
+// configured display names, descriptions and units
+func (mc *ManagementController) AttributesMetadata(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	id := identity.FromContext(ctx)
+	res, err := mc.reporting.GetAttributesMetadata(ctx, id.Tenant)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, filterAttributesMetadata(c, res))
+}
+
+func (mc *ManagementController) Facets(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	params := &model.FacetsParams{}
+	if err := c.ShouldBindJSON(params); err != nil {
+		renderError(c, badRequest(errors.Wrap(err, "malformed request body")))
+		return
+	}
+	if err := params.Validate(); err != nil {
+		renderError(c, badRequest(err))
+		return
+	}
+	attrs := append(filtersAttributes(params.Filters, params.PostFilters),
+		model.SelectAttribute{Scope: params.Scope, Attribute: params.Attribute})
+	if err := checkAttributes(c, attrs); err != nil {
+		renderError(c, err)
+		return
+	}
+
+	if id := identity.FromContext(ctx); id != nil {
+		params.TenantID = id.Tenant
+	}
+	if scope := rbac.ExtractScopeFromHeader(c.Request); scope != nil {
+		params.Groups = scope.DeviceGroups
+	}
+
+	res, err := mc.cachedAggregation(c, URIInventoryFacets, params,
+		func() (interface{}, error) {
+			return mc.reporting.GetFacets(ctx, params)
+		})
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// Pivot returns the device counts grouped by several attributes
+func (mc *ManagementController) Pivot(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	params := &model.PivotParams{}
+	if err := c.ShouldBindJSON(params); err != nil {
+		renderError(c, badRequest(errors.Wrap(err, "malformed request body")))
+		return
+	}
+	if err := params.Validate(); err != nil {
+		renderError(c, badRequest(err))
+		return
+	}
+	attrs := filtersAttributes(params.Filters)
+	for _, dim := range params.GroupBy {
+		attrs = append(attrs,
+			model.SelectAttribute{Scope: dim.Scope, Attribute: dim.Attribute})
+	}
+	if err := checkAttributes(c, attrs); err != nil {
+		renderError(c, err)
+		return
+	}
+
+	if id := identity.FromContext(ctx); id != nil {
+		params.TenantID = id.Tenant
+	}
+	if scope := rbac.ExtractScopeFromHeader(c.Request); scope != nil {
+		params.Groups = scope.DeviceGroups
+	}
+
+	res, err := mc.cachedAggregation(c, URIInventoryPivot, params,
+		func() (interface{}, error) {
+			return mc.reporting.GetPivot(ctx, params)
+		})
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// IPRanges returns the device counts by CIDR range of an IPv4 attribute
+func (mc *ManagementController) IPRanges(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	params := &model.IPRangesParams{}
+	if err := c.ShouldBindJSON(params); err != nil {
+		renderError(c, badRequest(errors.Wrap(err, "malformed request body")))
+		return
+	}
+	if err := params.Validate(); err != nil {
+		renderError(c, badRequest(err))
+		return
+	}
+	attrs := append(filtersAttributes(params.Filters),
+		model.SelectAttribute{Scope: params.Scope, Attribute: params.Attribute})
+	if err := checkAttributes(c, attrs); err != nil {
+		renderError(c, err)
+		return
+	}
+
+	if id := identity.FromContext(ctx); id != nil {
+		params.TenantID = id.Tenant
+	}
+	if scope := rbac.ExtractScopeFromHeader(c.Request); scope != nil {
+		params.Groups = scope.DeviceGroups
+	}
+
+	res, err := mc.cachedAggregation(c, URIInventoryIPRanges, params,
+		func() (interface{}, error) {
+			return mc.reporting.GetIPRanges(ctx, params)
+		})
+	if err != nil {
+		renderError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, res)
 }
+
+// GeohashGrid returns the device counts by geohash cell of a location
+// attribute, e.g. to cluster the devices on a map
+func (mc *ManagementController) GeohashGrid(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	params := &model.GeohashGridParams{}
+	if err := c.ShouldBindJSON(params); err != nil {
+		renderError(c, badRequest(errors.Wrap(err, "malformed request body")))
+		return
+	}
+	if err := params.Validate(); err != nil {
+		renderError(c, badRequest(err))
+		return
+	}
+	attrs := append(filtersAttributes(params.Filters),
+		model.SelectAttribute{Scope: params.Scope, Attribute: params.Attribute})
+	if err := checkAttributes(c, attrs); err != nil {
+		renderError(c, err)
+		return
+	}
+
+	if id := identity.FromContext(ctx); id != nil {
+		params.TenantID = id.Tenant
+	}
+	if scope := rbac.ExtractScopeFromHeader(c.Request); scope != nil {
+		params.Groups = scope.DeviceGroups
+	}
+
+	res, err := mc.cachedAggregation(c, URIInventoryGeohashGrid, params,
+		func() (interface{}, error) {
+			return mc.reporting.GetGeohashGrid(ctx, params)
+		})
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (mc *ManagementController) Groups(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	params := &model.GroupsParams{
+		Size: model.GroupsSizeDefault,
+	}
+	if id := identity.FromContext(ctx); id != nil {
+		params.TenantID = id.Tenant
+	}
+	if v, ok := c.GetQuery(paramSize); ok {
+		size, err := strconv.Atoi(v)
+		if err != nil || size <= 0 {
+			renderError(c, badRequest(errors.New("size must be a positive integer")))
+			return
+		}
+		params.Size = size
+	}
+	if scope := rbac.ExtractScopeFromHeader(c.Request); scope != nil {
+		params.Groups = scope.DeviceGroups
+	}
+
+	res, err := mc.cachedAggregation(c, URIInventoryGroups, params,
+		func() (interface{}, error) {
+			return mc.reporting.GetGroups(ctx, params)
+		})
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (mc *ManagementController) AttributeHistory(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	params := &model.AttributeHistoryParams{
+		DeviceID:  c.Param("device_id"),
+		Scope:     c.Query(paramScope),
+		Attribute: c.Query(paramAttr),
+		Size:      model.AttributeHistorySizeDefault,
+	}
+	if id := identity.FromContext(ctx); id != nil {
+		params.TenantID = id.Tenant
+	}
+	if v, ok := c.GetQuery(paramSize); ok {
+		size, err := strconv.Atoi(v)
+		if err != nil || size <= 0 {
+			renderError(c, badRequest(errors.New("size must be a positive integer")))
+			return
+		}
+		params.Size = size
+	}
+	var err error
+	if params.From, err = parseTimeQuery(c, paramFrom); err != nil {
+		renderError(c, badRequest(err))
+		return
+	}
+	if params.To, err = parseTimeQuery(c, paramTo); err != nil {
+		renderError(c, badRequest(err))
+		return
+	}
+	if err := params.Validate(); err != nil {
+		renderError(c, badRequest(err))
+		return
+	}
+
+	res, err := mc.reporting.GetAttributeHistory(ctx, params)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, filterHistory(c, res))
+}
+
+// CompareDevices returns the diff of the attributes of two devices
+func (mc *ManagementController) CompareDevices(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	params := &model.CompareDevicesParams{
+		DeviceA: c.Query(paramDeviceA),
+		DeviceB: c.Query(paramDeviceB),
+	}
+	if id := identity.FromContext(ctx); id != nil {
+		params.TenantID = id.Tenant
+	}
+	if scope := rbac.ExtractScopeFromHeader(c.Request); scope != nil {
+		params.Groups = scope.DeviceGroups
+	}
+	if err := params.Validate(); err != nil {
+		renderError(c, badRequest(err))
+		return
+	}
+
+	res, err := mc.reporting.CompareDevices(ctx, params)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+
+	filterComparison(c, res)
+	c.JSON(http.StatusOK, res)
+}
+
+// GetDevice returns the attributes of a device, either as a flat list, the
+// same as the search results, or grouped by scope with group_by=scope
+func (mc *ManagementController) GetDevice(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	params := &model.DeviceParams{
+		DeviceID: c.Param("device_id"),
+	}
+	if id := identity.FromContext(ctx); id != nil {
+		params.TenantID = id.Tenant
+	}
+	if scope := rbac.ExtractScopeFromHeader(c.Request); scope != nil {
+		params.Groups = scope.DeviceGroups
+	}
+	groupBy := c.Query(paramGroupBy)
+	if groupBy != "" && groupBy != groupByScope {
+		renderError(c, badRequest(errors.New("group_by must be 'scope'")))
+		return
+	}
+	if err := params.Validate(); err != nil {
+		renderError(c, badRequest(err))
+		return
+	}
+
+	dev, err := mc.reporting.GetDevice(ctx, params)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+
+	filterAttributes(c, dev)
+	if groupBy == groupByScope {
+		c.JSON(http.StatusOK, dev.GroupByScope())
+		return
+	}
+	c.JSON(http.StatusOK, dev)
+}
+
+// Changes returns a page of the devices updated after the 'since' timestamp
+// or the 'cursor' of the previous page, in ascending updated_ts order, i.e.
+// a change feed polled with the returned cursor
+func (mc *ManagementController) Changes(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	params := &model.ChangesParams{
+		Cursor: c.Query(paramCursor),
+		Size:   model.ChangesSizeDefault,
+	}
+	if id := identity.FromContext(ctx); id != nil {
+		params.TenantID = id.Tenant
+	}
+	if v, ok := c.GetQuery(paramSize); ok {
+		size, err := strconv.Atoi(v)
+		if err != nil || size <= 0 {
+			renderError(c, badRequest(errors.New("size must be a positive integer")))
+			return
+		}
+		params.Size = size
+	}
+	var err error
+	if params.Since, err = parseTimeQuery(c, paramSince); err != nil {
+		renderError(c, badRequest(err))
+		return
+	}
+	if scope := rbac.ExtractScopeFromHeader(c.Request); scope != nil {
+		params.Groups = scope.DeviceGroups
+	}
+	if err := params.Validate(); err != nil {
+		renderError(c, badRequest(err))
+		return
+	}
+
+	page, err := mc.reporting.GetDeviceChanges(ctx, params)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	for i := range page.Devices {
+		filterAttributes(c, &page.Devices[i])
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// parseTimeQuery parses the optional RFC3339 timestamp query parameter
+func parseTimeQuery(c *gin.Context, param string) (*time.Time, error) {
+	v, ok := c.GetQuery(param)
+	if !ok {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return nil, errors.Errorf("%s must be a RFC3339 timestamp", param)
+	}
+	return &t, nil
+}
@@ -23,6 +23,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -33,13 +34,16 @@ import (
 
 	"github.com/mendersoftware/go-lib-micro/identity"
 	"github.com/mendersoftware/go-lib-micro/rbac"
-	"github.com/mendersoftware/go-lib-micro/rest.utils"
+	"github.com/mendersoftware/go-lib-micro/requestid"
 
+	"github.com/mendersoftware/reporting/app/reporting"
 	mapp "github.com/mendersoftware/reporting/app/reporting/mocks"
 	"github.com/mendersoftware/reporting/model"
 )
 
-func GenerateJWT(id identity.Identity) string {
+// GenerateJWT generates an unsigned JWT with the claims of id,
+// either an identity.Identity or a map of custom claims
+func GenerateJWT(id interface{}) string {
 	JWT := base64.RawURLEncoding.EncodeToString(
 		[]byte(`{"alg":"HS256","typ":"JWT"}`),
 	)
@@ -215,8 +219,11 @@ func TestManagementSearch(t *testing.T) {
 			}},
 			TenantID: "123456789012345678901234",
 		},
-		Code:     http.StatusBadRequest,
-		Response: rest.Error{Err: "malformed request body: type: must be a valid value."},
+		Code: http.StatusBadRequest,
+		Response: ErrorResponse{
+			Code: ErrCodeBadRequest,
+			Err:  "malformed request body: filters[0]: type: must be a valid value.",
+		},
 	}, {
 		Name: "error, internal app error",
 
@@ -253,7 +260,7 @@ func TestManagementSearch(t *testing.T) {
 		},
 
 		Code:     http.StatusInternalServerError,
-		Response: rest.Error{Err: "internal error"},
+		Response: ErrorResponse{Code: ErrCodeInternalServerError, Err: "internal error"},
 	}, {
 		Name: "error, request identity not present",
 
@@ -263,8 +270,11 @@ func TestManagementSearch(t *testing.T) {
 		CTX:    identity.WithContext(context.Background(), nil),
 		Params: &model.SearchParams{},
 
-		Code:     http.StatusUnauthorized,
-		Response: rest.Error{Err: "Authorization not present in header"},
+		Code: http.StatusUnauthorized,
+		Response: ErrorResponse{
+			Code: ErrCodeUnauthorized,
+			Err:  "Authorization not present in header",
+		},
 	}, {
 		Name: "error, malformed request body",
 
@@ -282,7 +292,8 @@ func TestManagementSearch(t *testing.T) {
 		},
 
 		Code: http.StatusBadRequest,
-		Response: rest.Error{
+		Response: ErrorResponse{
+			Code: ErrCodeBadRequest,
 			Err: "malformed request body: json: " +
 				"cannot unmarshal string into Go struct field " +
 				"SearchParams.filters of type []model.FilterPredicate",
@@ -323,13 +334,14 @@ func TestManagementSearch(t *testing.T) {
 				b, _ := json.Marshal(res)
 				assert.JSONEq(t, string(b), w.Body.String())
 
-			case rest.Error:
-				var actual rest.Error
+			case ErrorResponse:
+				var actual ErrorResponse
 				dec := json.NewDecoder(w.Body)
 				dec.DisallowUnknownFields()
 				err := dec.Decode(&actual)
-				if assert.NoError(t, err, "response schema did not match expected rest.Error") {
+				if assert.NoError(t, err, "response schema did not match expected ErrorResponse") {
 					assert.EqualError(t, res, actual.Error())
+					assert.Equal(t, res.Code, actual.Code)
 				}
 
 			case nil:
@@ -342,3 +354,1735 @@ func TestManagementSearch(t *testing.T) {
 		})
 	}
 }
+
+func TestManagementSearchEnvelope(t *testing.T) {
+	t.Parallel()
+	devices := []model.InvDevice{{
+		ID: model.DeviceID("5975e1e6-49a6-4218-a46d-f181154a98cc"),
+		Attributes: model.DeviceAttributes{{
+			Scope: "inventory",
+			Name:  "ip4",
+			Value: "10.0.0.2",
+		}},
+	}}
+	envelope := map[string]interface{}{
+		"items":    devices,
+		"page":     2,
+		"per_page": 10,
+		"total":    11,
+	}
+
+	testCases := []struct {
+		Name string
+
+		Query  string
+		Accept string
+
+		Response interface{}
+	}{{
+		Name: "ok, bare array by default",
+
+		Response: devices,
+	}, {
+		Name: "ok, envelope with query param",
+
+		Query:    "?envelope=true",
+		Response: envelope,
+	}, {
+		Name: "ok, envelope with accept header",
+
+		Accept:   "text/html, " + MediaTypeEnvelope + "; q=0.9",
+		Response: envelope,
+	}, {
+		Name: "ok, query param takes precedence",
+
+		Query:    "?envelope=false",
+		Accept:   MediaTypeEnvelope,
+		Response: devices,
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			app := new(mapp.App)
+			app.On("InventorySearchDevices",
+				contextMatcher,
+				mock.AnythingOfType("*model.SearchParams")).
+				Return(devices, 11, nil)
+			defer app.AssertExpectations(t)
+			router := NewRouter(app)
+
+			req, _ := http.NewRequest(
+				http.MethodPost,
+				URIManagement+URIInventorySearch+tc.Query,
+				strings.NewReader(`{"page": 2, "per_page": 10}`),
+			)
+			req.Header.Set("Authorization", "Bearer "+GenerateJWT(identity.Identity{
+				Subject: "851f90b3-cee5-425e-8f6e-b36de1993e7e",
+				Tenant:  "123456789012345678901234",
+			}))
+			if tc.Accept != "" {
+				req.Header.Set("Accept", tc.Accept)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "11", w.Header().Get(hdrTotalCount))
+			assert.NotEmpty(t, w.Header().Get("Link"))
+			b, _ := json.Marshal(tc.Response)
+			assert.JSONEq(t, string(b), w.Body.String())
+		})
+	}
+}
+
+func TestManagementSearchTerminateAfter(t *testing.T) {
+	t.Parallel()
+	devices := []model.InvDevice{{ID: "dev1"}}
+
+	testCases := []struct {
+		Name string
+
+		Query           string
+		TerminatedEarly bool
+
+		Response interface{}
+	}{{
+		Name: "ok, terminated early",
+
+		TerminatedEarly: true,
+		Response:        devices,
+	}, {
+		Name: "ok, terminated early, envelope",
+
+		Query:           "?envelope=true",
+		TerminatedEarly: true,
+		Response: map[string]interface{}{
+			"items":            devices,
+			"page":             1,
+			"per_page":         20,
+			"total":            1,
+			"terminated_early": true,
+		},
+	}, {
+		Name: "ok, not terminated early, envelope",
+
+		Query: "?envelope=true",
+		Response: map[string]interface{}{
+			"items":    devices,
+			"page":     1,
+			"per_page": 20,
+			"total":    1,
+		},
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			app := new(mapp.App)
+			app.On("InventorySearchDevicesInfo",
+				contextMatcher,
+				mock.MatchedBy(func(params *model.SearchParams) bool {
+					return params.TerminateAfter == 500
+				})).
+				Return(devices, 1, &model.SearchInfo{
+					TerminatedEarly: tc.TerminatedEarly,
+				}, nil)
+			defer app.AssertExpectations(t)
+			router := NewRouter(app)
+
+			req, _ := http.NewRequest(
+				http.MethodPost,
+				URIManagement+URIInventorySearch+tc.Query,
+				strings.NewReader(`{"terminate_after": 500}`),
+			)
+			req.Header.Set("Authorization", "Bearer "+GenerateJWT(identity.Identity{
+				Subject: "851f90b3-cee5-425e-8f6e-b36de1993e7e",
+				Tenant:  "123456789012345678901234",
+			}))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			if tc.TerminatedEarly {
+				assert.Equal(t, "true", w.Header().Get(hdrTerminatedEarly))
+			} else {
+				assert.Empty(t, w.Header().Get(hdrTerminatedEarly))
+			}
+			b, _ := json.Marshal(tc.Response)
+			assert.JSONEq(t, string(b), w.Body.String())
+		})
+	}
+}
+
+func TestManagementSearchTimeout(t *testing.T) {
+	t.Parallel()
+	devices := []model.InvDevice{{ID: "dev1"}}
+
+	testCases := []struct {
+		Name string
+
+		Query    string
+		TimedOut bool
+
+		Response interface{}
+	}{{
+		Name: "ok, timed out",
+
+		TimedOut: true,
+		Response: devices,
+	}, {
+		Name: "ok, timed out, envelope",
+
+		Query:    "?envelope=true",
+		TimedOut: true,
+		Response: map[string]interface{}{
+			"items":     devices,
+			"page":      1,
+			"per_page":  20,
+			"total":     1,
+			"timed_out": true,
+		},
+	}, {
+		Name: "ok, not timed out, envelope",
+
+		Query: "?envelope=true",
+		Response: map[string]interface{}{
+			"items":    devices,
+			"page":     1,
+			"per_page": 20,
+			"total":    1,
+		},
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			app := new(mapp.App)
+			app.On("InventorySearchDevicesInfo",
+				contextMatcher,
+				mock.MatchedBy(func(params *model.SearchParams) bool {
+					return params.Timeout == "2s"
+				})).
+				Return(devices, 1, &model.SearchInfo{
+					TimedOut: tc.TimedOut,
+				}, nil)
+			defer app.AssertExpectations(t)
+			router := NewRouter(app)
+
+			req, _ := http.NewRequest(
+				http.MethodPost,
+				URIManagement+URIInventorySearch+tc.Query,
+				strings.NewReader(`{"timeout": "2s"}`),
+			)
+			req.Header.Set("Authorization", "Bearer "+GenerateJWT(identity.Identity{
+				Subject: "851f90b3-cee5-425e-8f6e-b36de1993e7e",
+				Tenant:  "123456789012345678901234",
+			}))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			if tc.TimedOut {
+				assert.Equal(t, "true", w.Header().Get(hdrTimedOut))
+			} else {
+				assert.Empty(t, w.Header().Get(hdrTimedOut))
+			}
+			b, _ := json.Marshal(tc.Response)
+			assert.JSONEq(t, string(b), w.Body.String())
+		})
+	}
+}
+
+func TestManagementSearchHasMore(t *testing.T) {
+	t.Parallel()
+	devices := []model.InvDevice{{ID: "dev1"}}
+
+	testCases := []struct {
+		Name string
+
+		Query   string
+		HasMore bool
+
+		Link     string
+		Response interface{}
+	}{{
+		Name: "ok, has more",
+
+		HasMore: true,
+		Link: `<` + URIManagement + URIInventorySearch + `?page=1&per_page=1>;rel="first", ` +
+			`<` + URIManagement + URIInventorySearch + `?page=2&per_page=1>;rel="next"`,
+		Response: devices,
+	}, {
+		Name: "ok, last page, envelope",
+
+		Query: "?envelope=true",
+		Link: `<` + URIManagement + URIInventorySearch +
+			`?envelope=true&page=1&per_page=1>;rel="first"`,
+		Response: map[string]interface{}{
+			"items":    devices,
+			"page":     1,
+			"per_page": 1,
+			"total":    0,
+			"has_more": false,
+		},
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			a := new(mapp.App)
+			a.On("InventorySearchDevicesInfo",
+				contextMatcher,
+				mock.MatchedBy(func(params *model.SearchParams) bool {
+					return !params.TrackTotalHits.CountsAll()
+				})).
+				Return(devices, 0, &model.SearchInfo{HasMore: tc.HasMore}, nil)
+			defer a.AssertExpectations(t)
+			router := NewRouter(a)
+
+			req, _ := http.NewRequest(
+				http.MethodPost,
+				URIManagement+URIInventorySearch+tc.Query,
+				strings.NewReader(`{"per_page": 1, "track_total_hits": false}`),
+			)
+			req.Header.Set("Authorization", "Bearer "+GenerateJWT(identity.Identity{
+				Subject: "851f90b3-cee5-425e-8f6e-b36de1993e7e",
+				Tenant:  "123456789012345678901234",
+			}))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, strconv.FormatBool(tc.HasMore), w.Header().Get(hdrHasMore))
+			assert.Equal(t, tc.Link, w.Header().Get("Link"))
+			b, _ := json.Marshal(tc.Response)
+			assert.JSONEq(t, string(b), w.Body.String())
+		})
+	}
+}
+
+func TestManagementSearchStrictDecoding(t *testing.T) {
+	t.Parallel()
+	devices := []model.InvDevice{{ID: "dev1"}}
+	// "filtesr" is a typo of "filters"
+	const body = `{"filtesr": [{"scope": "inventory", "attribute": "foo",` +
+		` "type": "$eq", "value": "bar"}]}`
+
+	testCases := []struct {
+		Name string
+
+		Strict bool
+
+		Code     int
+		Response interface{}
+	}{{
+		Name: "ok, lenient",
+
+		Code:     http.StatusOK,
+		Response: devices,
+	}, {
+		Name: "error, strict",
+
+		Strict: true,
+		Code:   http.StatusBadRequest,
+		Response: ErrorResponse{
+			Code: ErrCodeBadRequest,
+			Err:  `malformed request body: json: unknown field "filtesr"`,
+		},
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			a := new(mapp.App)
+			if !tc.Strict {
+				// the unknown field is ignored: no filters
+				a.On("InventorySearchDevices",
+					contextMatcher,
+					mock.MatchedBy(func(params *model.SearchParams) bool {
+						return len(params.Filters) == 0
+					})).
+					Return(devices, 1, nil)
+			}
+			defer a.AssertExpectations(t)
+			router := NewRouter(a, WithStrictDecoding(tc.Strict))
+
+			req, _ := http.NewRequest(
+				http.MethodPost,
+				URIManagement+URIInventorySearch,
+				strings.NewReader(body),
+			)
+			req.Header.Set("Authorization", "Bearer "+GenerateJWT(identity.Identity{
+				Subject: "851f90b3-cee5-425e-8f6e-b36de1993e7e",
+				Tenant:  "123456789012345678901234",
+			}))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.Code, w.Code)
+			if res, ok := tc.Response.(ErrorResponse); ok {
+				res.RequestID = w.Header().Get(requestid.RequestIdHeader)
+				tc.Response = res
+			}
+			b, _ := json.Marshal(tc.Response)
+			assert.JSONEq(t, string(b), w.Body.String())
+		})
+	}
+}
+
+func TestManagementGroups(t *testing.T) {
+	t.Parallel()
+	type testCase struct {
+		Name string
+
+		Query  string
+		Scope  []string
+		App    func(*testing.T, testCase) *mapp.App
+		Params *model.GroupsParams
+
+		Code     int
+		Response interface{}
+	}
+	testCases := []testCase{{
+		Name: "ok",
+
+		Params: &model.GroupsParams{
+			TenantID: "123456789012345678901234",
+			Size:     model.GroupsSizeDefault,
+		},
+		Code: http.StatusOK,
+		Response: []model.GroupCount{
+			{Group: "prod", Count: 10},
+			{Group: "", Count: 5},
+		},
+	}, {
+		Name: "ok, with size and scope",
+
+		Query: "?size=5",
+		Scope: []string{"prod"},
+		Params: &model.GroupsParams{
+			TenantID: "123456789012345678901234",
+			Size:     5,
+			Groups:   []string{"prod"},
+		},
+		Code: http.StatusOK,
+		Response: []model.GroupCount{
+			{Group: "prod", Count: 10},
+		},
+	}, {
+		Name: "error, invalid size",
+
+		Query:    "?size=-1",
+		Code:     http.StatusBadRequest,
+		Response: ErrorResponse{Code: ErrCodeBadRequest, Err: "size must be a positive integer"},
+	}, {
+		Name: "error, too many buckets",
+
+		Query: "?size=100000",
+		Params: &model.GroupsParams{
+			TenantID: "123456789012345678901234",
+			Size:     100000,
+		},
+		Code: http.StatusBadRequest,
+		Response: ErrorResponse{
+			Code: ErrCodeTooManyBuckets,
+			Err:  reporting.ErrTooManyBuckets.Error(),
+		},
+	}, {
+		Name: "error, internal app error",
+
+		Params: &model.GroupsParams{
+			TenantID: "123456789012345678901234",
+			Size:     model.GroupsSizeDefault,
+		},
+		Code:     http.StatusInternalServerError,
+		Response: ErrorResponse{Code: ErrCodeInternalServerError, Err: "internal error"},
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			app := new(mapp.App)
+			if tc.Params != nil {
+				var res []model.GroupCount
+				var err error
+				switch r := tc.Response.(type) {
+				case []model.GroupCount:
+					res = r
+				case ErrorResponse:
+					err = errors.New(r.Err)
+					if r.Err == reporting.ErrTooManyBuckets.Error() {
+						err = reporting.ErrTooManyBuckets
+					}
+				}
+				app.On("GetGroups", contextMatcher, tc.Params).
+					Return(res, err)
+			}
+			defer app.AssertExpectations(t)
+			router := NewRouter(app)
+
+			req, _ := http.NewRequest(
+				http.MethodGet,
+				URIManagement+URIInventoryGroups+tc.Query,
+				nil,
+			)
+			req.Header.Set("Authorization", "Bearer "+GenerateJWT(identity.Identity{
+				Subject: "851f90b3-cee5-425e-8f6e-b36de1993e7e",
+				Tenant:  "123456789012345678901234",
+			}))
+			if len(tc.Scope) > 0 {
+				req.Header.Set(rbac.ScopeHeader, strings.Join(tc.Scope, ","))
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.Code, w.Code)
+			b, _ := json.Marshal(tc.Response)
+			if res, ok := tc.Response.(ErrorResponse); ok {
+				res.RequestID = w.Header().Get(requestid.RequestIdHeader)
+				b, _ = json.Marshal(res)
+			}
+			assert.JSONEq(t, string(b), w.Body.String())
+		})
+	}
+}
+
+func TestManagementExport(t *testing.T) {
+	t.Parallel()
+
+	devs := []model.InvDevice{{ID: "dev1"}, {ID: "dev2"}}
+	type testCase struct {
+		Name string
+
+		Body    string
+		Devices []model.InvDevice
+		Error   error
+
+		Code        int
+		ContentType string
+		Lines       []model.InvDevice
+	}
+	testCases := []testCase{{
+		Name: "ok",
+
+		Body:    `{"filters":[{"scope":"inventory","attribute":"foo","type":"$eq","value":"bar"}]}`,
+		Devices: devs,
+
+		Code:        http.StatusOK,
+		ContentType: mediaTypeNDJSON,
+		Lines:       devs,
+	}, {
+		Name: "ok, no devices",
+
+		Body: `{}`,
+
+		Code:        http.StatusOK,
+		ContentType: mediaTypeNDJSON,
+	}, {
+		Name: "ok, error while streaming truncates the output",
+
+		Body:    `{}`,
+		Devices: devs[:1],
+		Error:   errors.New("search failed"),
+
+		Code:        http.StatusOK,
+		ContentType: mediaTypeNDJSON,
+		Lines:       devs[:1],
+	}, {
+		Name: "error, internal app error",
+
+		Body:  `{}`,
+		Error: errors.New("search failed"),
+
+		Code: http.StatusInternalServerError,
+	}, {
+		Name: "error, malformed request body",
+
+		Body: `{"page": "foo"}`,
+		Code: http.StatusBadRequest,
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			app := new(mapp.App)
+			if tc.Code != http.StatusBadRequest {
+				app.On("ExportDevices",
+					contextMatcher,
+					mock.AnythingOfType("*model.SearchParams"),
+					mock.AnythingOfType("func(*model.InvDevice) error"),
+				).Run(func(args mock.Arguments) {
+					emit := args.Get(2).(func(*model.InvDevice) error)
+					for i := range tc.Devices {
+						_ = emit(&tc.Devices[i])
+					}
+				}).Return(tc.Error)
+			}
+			defer app.AssertExpectations(t)
+			router := NewRouter(app)
+
+			req, _ := http.NewRequest(
+				http.MethodPost,
+				URIManagement+URIInventoryExport,
+				strings.NewReader(tc.Body),
+			)
+			req.Header.Set("Authorization", "Bearer "+GenerateJWT(identity.Identity{
+				Subject: "851f90b3-cee5-425e-8f6e-b36de1993e7e",
+				Tenant:  "123456789012345678901234",
+			}))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.Code, w.Code)
+			if tc.Code != http.StatusOK {
+				return
+			}
+			assert.Equal(t, tc.ContentType, w.Header().Get("Content-Type"))
+
+			// one JSON document per line
+			body := w.Body.String()
+			var lines []model.InvDevice
+			dec := json.NewDecoder(strings.NewReader(body))
+			for dec.More() {
+				var dev model.InvDevice
+				if assert.NoError(t, dec.Decode(&dev)) {
+					lines = append(lines, dev)
+				}
+			}
+			assert.Equal(t, tc.Lines, lines)
+			assert.Equal(t, len(tc.Lines), strings.Count(body, "\n"))
+		})
+	}
+}
+
+func TestManagementScan(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		Name string
+
+		Body   string
+		Params *model.ScanParams
+		Page   *model.ScanPage
+		Error  error
+
+		Code     int
+		Response interface{}
+	}
+	testCases := []testCase{{
+		Name: "ok",
+
+		Body: `{"per_page":2,"cursor":"abc"}`,
+		Params: &model.ScanParams{
+			SearchParams: model.SearchParams{
+				Page:     ParamPageDefault,
+				PerPage:  2,
+				TenantID: "123456789012345678901234",
+			},
+			Cursor: "abc",
+		},
+		Page: &model.ScanPage{
+			Devices: []model.InvDevice{{ID: "dev1"}, {ID: "dev2"}},
+			Cursor:  "def",
+		},
+
+		Code: http.StatusOK,
+		Response: &model.ScanPage{
+			Devices: []model.InvDevice{{ID: "dev1"}, {ID: "dev2"}},
+			Cursor:  "def",
+		},
+	}, {
+		Name: "error, cursor expired",
+
+		Body: `{"cursor":"abc"}`,
+		Params: &model.ScanParams{
+			SearchParams: model.SearchParams{
+				Page:     ParamPageDefault,
+				PerPage:  ParamPerPageDefault,
+				TenantID: "123456789012345678901234",
+			},
+			Cursor: "abc",
+		},
+		Error: reporting.ErrScanCursorExpired,
+
+		Code: http.StatusGone,
+		Response: &ErrorResponse{
+			Code: ErrCodeScanCursorExpired,
+			Err:  reporting.ErrScanCursorExpired.Error(),
+		},
+	}, {
+		Name: "error, invalid cursor",
+
+		Body: `{"cursor":"abc"}`,
+		Params: &model.ScanParams{
+			SearchParams: model.SearchParams{
+				Page:     ParamPageDefault,
+				PerPage:  ParamPerPageDefault,
+				TenantID: "123456789012345678901234",
+			},
+			Cursor: "abc",
+		},
+		Error: model.ErrInvalidScanCursor,
+
+		Code: http.StatusBadRequest,
+		Response: &ErrorResponse{
+			Code: ErrCodeInvalidScanCursor,
+			Err:  model.ErrInvalidScanCursor.Error(),
+		},
+	}, {
+		Name: "error, malformed request body",
+
+		Body: `{"cursor": 1}`,
+		Code: http.StatusBadRequest,
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			a := new(mapp.App)
+			if tc.Params != nil {
+				a.On("ScanDevices", contextMatcher, tc.Params).
+					Return(tc.Page, tc.Error)
+			}
+			defer a.AssertExpectations(t)
+			router := NewRouter(a)
+
+			req, _ := http.NewRequest(
+				http.MethodPost,
+				URIManagement+URIInventoryScan,
+				strings.NewReader(tc.Body),
+			)
+			req.Header.Set("Authorization", "Bearer "+GenerateJWT(identity.Identity{
+				Subject: "851f90b3-cee5-425e-8f6e-b36de1993e7e",
+				Tenant:  "123456789012345678901234",
+			}))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.Code, w.Code)
+			switch res := tc.Response.(type) {
+			case *ErrorResponse:
+				res.RequestID = w.Header().Get(requestid.RequestIdHeader)
+				b, _ := json.Marshal(res)
+				assert.JSONEq(t, string(b), w.Body.String())
+			case *model.ScanPage:
+				b, _ := json.Marshal(res)
+				assert.JSONEq(t, string(b), w.Body.String())
+			}
+		})
+	}
+}
+
+func TestManagementFacets(t *testing.T) {
+	t.Parallel()
+	type testCase struct {
+		Name string
+
+		Body   string
+		Scope  []string
+		Params *model.FacetsParams
+
+		Code     int
+		Response interface{}
+	}
+	testCases := []testCase{{
+		Name: "ok",
+
+		Body: `{"scope": "inventory", "attribute": "device_type", "size": 2}`,
+		Params: &model.FacetsParams{
+			Scope:     "inventory",
+			Attribute: "device_type",
+			Size:      2,
+			TenantID:  "123456789012345678901234",
+		},
+		Code: http.StatusOK,
+		Response: &model.Facets{
+			Buckets: []model.FacetBucket{
+				{Value: "qemux86-64", Count: 4},
+				{Value: "raspberrypi4", Count: 12},
+			},
+			AfterKey: "raspberrypi4",
+		},
+	}, {
+		Name: "ok, next page with filters and scope",
+
+		Body: `{
+			"scope": "inventory",
+			"attribute": "device_type",
+			"after": "raspberrypi4",
+			"filters": [{
+				"scope": "identity",
+				"attribute": "status",
+				"type": "$eq",
+				"value": "accepted"
+			}]
+		}`,
+		Scope: []string{"prod"},
+		Params: &model.FacetsParams{
+			Scope:     "inventory",
+			Attribute: "device_type",
+			After:     "raspberrypi4",
+			Filters: []model.FilterPredicate{{
+				Scope:     "identity",
+				Attribute: "status",
+				Type:      "$eq",
+				Value:     "accepted",
+			}},
+			Groups:   []string{"prod"},
+			TenantID: "123456789012345678901234",
+		},
+		Code: http.StatusOK,
+		Response: &model.Facets{
+			Buckets: []model.FacetBucket{
+				{Value: "raspberrypi5", Count: 1},
+			},
+		},
+	}, {
+		Name: "error, missing attribute",
+
+		Body:     `{"scope": "inventory"}`,
+		Code:     http.StatusBadRequest,
+		Response: ErrorResponse{Code: ErrCodeBadRequest, Err: "attribute: cannot be blank."},
+	}, {
+		Name: "error, internal app error",
+
+		Body: `{"scope": "inventory", "attribute": "device_type"}`,
+		Params: &model.FacetsParams{
+			Scope:     "inventory",
+			Attribute: "device_type",
+			TenantID:  "123456789012345678901234",
+		},
+		Code:     http.StatusInternalServerError,
+		Response: ErrorResponse{Code: ErrCodeInternalServerError, Err: "internal error"},
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			app := new(mapp.App)
+			if tc.Params != nil {
+				var res *model.Facets
+				var err error
+				switch r := tc.Response.(type) {
+				case *model.Facets:
+					res = r
+				case ErrorResponse:
+					err = errors.New(r.Err)
+				}
+				app.On("GetFacets", contextMatcher, tc.Params).
+					Return(res, err)
+			}
+			defer app.AssertExpectations(t)
+			router := NewRouter(app)
+
+			req, _ := http.NewRequest(
+				http.MethodPost,
+				URIManagement+URIInventoryFacets,
+				strings.NewReader(tc.Body),
+			)
+			req.Header.Set("Authorization", "Bearer "+GenerateJWT(identity.Identity{
+				Subject: "851f90b3-cee5-425e-8f6e-b36de1993e7e",
+				Tenant:  "123456789012345678901234",
+			}))
+			if len(tc.Scope) > 0 {
+				req.Header.Set(rbac.ScopeHeader, strings.Join(tc.Scope, ","))
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.Code, w.Code)
+			b, _ := json.Marshal(tc.Response)
+			if res, ok := tc.Response.(ErrorResponse); ok {
+				res.RequestID = w.Header().Get(requestid.RequestIdHeader)
+				b, _ = json.Marshal(res)
+			}
+			assert.JSONEq(t, string(b), w.Body.String())
+		})
+	}
+}
+
+func TestManagementAttributeHistory(t *testing.T) {
+	t.Parallel()
+	from := time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)
+	type testCase struct {
+		Name string
+
+		Query  string
+		Params *model.AttributeHistoryParams
+		Error  error
+
+		Code     int
+		Response interface{}
+	}
+	testCases := []testCase{{
+		Name: "ok",
+
+		Query: "?scope=inventory&attribute=kernel&from=2021-10-01T00:00:00Z&size=10",
+		Params: &model.AttributeHistoryParams{
+			TenantID:  "123456789012345678901234",
+			DeviceID:  "dev1",
+			Scope:     "inventory",
+			Attribute: "kernel",
+			From:      &from,
+			Size:      10,
+		},
+		Code: http.StatusOK,
+		Response: []model.AttributeHistory{{
+			DeviceID:  "dev1",
+			Scope:     "inventory",
+			Name:      "kernel",
+			Value:     []interface{}{"5.10"},
+			Timestamp: from.Add(time.Hour),
+		}},
+	}, {
+		Name: "error, missing attribute",
+
+		Query:    "?scope=inventory",
+		Code:     http.StatusBadRequest,
+		Response: ErrorResponse{Code: ErrCodeBadRequest, Err: "attribute: cannot be blank."},
+	}, {
+		Name: "error, invalid from",
+
+		Query:    "?scope=inventory&attribute=kernel&from=yesterday",
+		Code:     http.StatusBadRequest,
+		Response: ErrorResponse{Code: ErrCodeBadRequest, Err: "from must be a RFC3339 timestamp"},
+	}, {
+		Name: "error, history disabled",
+
+		Query: "?scope=inventory&attribute=kernel",
+		Params: &model.AttributeHistoryParams{
+			TenantID:  "123456789012345678901234",
+			DeviceID:  "dev1",
+			Scope:     "inventory",
+			Attribute: "kernel",
+			Size:      model.AttributeHistorySizeDefault,
+		},
+		Error: reporting.ErrHistoryDisabled,
+		Code:  http.StatusNotFound,
+		Response: ErrorResponse{
+			Code: ErrCodeHistoryDisabled,
+			Err:  reporting.ErrHistoryDisabled.Error(),
+		},
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			app := new(mapp.App)
+			if tc.Params != nil {
+				res, _ := tc.Response.([]model.AttributeHistory)
+				app.On("GetAttributeHistory", contextMatcher, tc.Params).
+					Return(res, tc.Error)
+			}
+			defer app.AssertExpectations(t)
+			router := NewRouter(app)
+
+			req, _ := http.NewRequest(
+				http.MethodGet,
+				URIManagement+"/devices/history/dev1"+tc.Query,
+				nil,
+			)
+			req.Header.Set("Authorization", "Bearer "+GenerateJWT(identity.Identity{
+				Subject: "851f90b3-cee5-425e-8f6e-b36de1993e7e",
+				Tenant:  "123456789012345678901234",
+			}))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.Code, w.Code)
+			b, _ := json.Marshal(tc.Response)
+			if res, ok := tc.Response.(ErrorResponse); ok {
+				res.RequestID = w.Header().Get(requestid.RequestIdHeader)
+				b, _ = json.Marshal(res)
+			}
+			assert.JSONEq(t, string(b), w.Body.String())
+		})
+	}
+}
+
+func TestManagementAttributesMetadata(t *testing.T) {
+	t.Parallel()
+	attrs := []model.InvAttrMetadata{{
+		Scope: "inventory",
+		Name:  "kernel_osrelease",
+		AttributeMetadata: model.AttributeMetadata{
+			DisplayName: "Kernel version",
+		},
+	}, {
+		Scope: "inventory",
+		Name:  "region",
+	}}
+
+	a := new(mapp.App)
+	a.On("GetAttributesMetadata", contextMatcher, "123456789012345678901234").
+		Return(attrs, nil)
+	defer a.AssertExpectations(t)
+	router := NewRouter(a)
+
+	req, _ := http.NewRequest(
+		http.MethodGet,
+		URIManagement+URIInventoryAttrsMetadata,
+		nil,
+	)
+	req.Header.Set("Authorization", "Bearer "+GenerateJWT(identity.Identity{
+		Subject: "851f90b3-cee5-425e-8f6e-b36de1993e7e",
+		Tenant:  "123456789012345678901234",
+	}))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[
+		{"scope": "inventory", "name": "kernel_osrelease",
+		 "display_name": "Kernel version"},
+		{"scope": "inventory", "name": "region"}
+	]`, w.Body.String())
+}
+
+func TestManagementCompareDevices(t *testing.T) {
+	t.Parallel()
+	comparison := &model.DeviceComparison{
+		DeviceA: "dev1",
+		DeviceB: "dev2",
+		Common: []model.InvDeviceAttribute{
+			{Scope: "inventory", Name: "device_type", Value: []interface{}{"rpi4"}},
+		},
+		OnlyInA: []model.InvDeviceAttribute{},
+		OnlyInB: []model.InvDeviceAttribute{},
+		Different: []model.AttributeDiff{{
+			Scope:  "inventory",
+			Name:   "kernel",
+			ValueA: []interface{}{"5.10"},
+			ValueB: []interface{}{"5.15"},
+		}},
+	}
+	type testCase struct {
+		Name string
+
+		Query  string
+		Scope  []string
+		Params *model.CompareDevicesParams
+		Error  error
+
+		Code     int
+		Response interface{}
+	}
+	testCases := []testCase{{
+		Name: "ok",
+
+		Query: "?device_a=dev1&device_b=dev2",
+		Params: &model.CompareDevicesParams{
+			TenantID: "123456789012345678901234",
+			DeviceA:  "dev1",
+			DeviceB:  "dev2",
+		},
+		Code:     http.StatusOK,
+		Response: comparison,
+	}, {
+		Name: "ok, restricted to groups",
+
+		Query: "?device_a=dev1&device_b=dev2",
+		Scope: []string{"group1", "group2"},
+		Params: &model.CompareDevicesParams{
+			TenantID: "123456789012345678901234",
+			DeviceA:  "dev1",
+			DeviceB:  "dev2",
+			Groups:   []string{"group1", "group2"},
+		},
+		Code:     http.StatusOK,
+		Response: comparison,
+	}, {
+		Name: "error, missing device",
+
+		Query:    "?device_a=dev1",
+		Code:     http.StatusBadRequest,
+		Response: ErrorResponse{Code: ErrCodeBadRequest, Err: "device_b: cannot be blank."},
+	}, {
+		Name: "error, device not found",
+
+		Query: "?device_a=dev1&device_b=dev2",
+		Params: &model.CompareDevicesParams{
+			TenantID: "123456789012345678901234",
+			DeviceA:  "dev1",
+			DeviceB:  "dev2",
+		},
+		Error: errors.Wrap(reporting.ErrDeviceNotFound, "dev2"),
+		Code:  http.StatusNotFound,
+		Response: ErrorResponse{
+			Code: ErrCodeDeviceNotFound,
+			Err:  "dev2: " + reporting.ErrDeviceNotFound.Error(),
+		},
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			a := new(mapp.App)
+			if tc.Params != nil {
+				res, _ := tc.Response.(*model.DeviceComparison)
+				a.On("CompareDevices", contextMatcher, tc.Params).
+					Return(res, tc.Error)
+			}
+			defer a.AssertExpectations(t)
+			router := NewRouter(a)
+
+			req, _ := http.NewRequest(
+				http.MethodGet,
+				URIManagement+URIInventoryCompare+tc.Query,
+				nil,
+			)
+			req.Header.Set("Authorization", "Bearer "+GenerateJWT(identity.Identity{
+				Subject: "851f90b3-cee5-425e-8f6e-b36de1993e7e",
+				Tenant:  "123456789012345678901234",
+			}))
+			if tc.Scope != nil {
+				req.Header.Set(rbac.ScopeHeader, strings.Join(tc.Scope, ","))
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.Code, w.Code)
+			b, _ := json.Marshal(tc.Response)
+			if res, ok := tc.Response.(ErrorResponse); ok {
+				res.RequestID = w.Header().Get(requestid.RequestIdHeader)
+				b, _ = json.Marshal(res)
+			}
+			assert.JSONEq(t, string(b), w.Body.String())
+		})
+	}
+}
+
+func TestManagementGetDevice(t *testing.T) {
+	t.Parallel()
+	updated := time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)
+	dev := &model.InvDevice{
+		ID: "dev1",
+		Attributes: model.DeviceAttributes{
+			{Scope: model.AttrScopeInventory, Name: "os", Value: "linux"},
+			{Scope: model.AttrScopeInventory, Name: "kernel", Value: "5.10"},
+			{Scope: model.AttrScopeIdentity, Name: "mac", Value: "00:11:22:33:44:55"},
+			{Scope: model.AttrScopeSystem, Name: model.AttrNameGroup, Value: "group1"},
+		},
+		UpdatedTs: updated,
+	}
+	type testCase struct {
+		Name string
+
+		Query  string
+		Scope  []string
+		Params *model.DeviceParams
+		Error  error
+
+		Code     int
+		Response interface{}
+	}
+	testCases := []testCase{{
+		Name: "ok, flat",
+
+		Params: &model.DeviceParams{
+			TenantID: "123456789012345678901234",
+			DeviceID: "dev1",
+		},
+		Code:     http.StatusOK,
+		Response: dev,
+	}, {
+		Name: "ok, grouped by scope",
+
+		Query: "?group_by=scope",
+		Scope: []string{"group1"},
+		Params: &model.DeviceParams{
+			TenantID: "123456789012345678901234",
+			DeviceID: "dev1",
+			Groups:   []string{"group1"},
+		},
+		Code: http.StatusOK,
+		Response: map[string]interface{}{
+			"id": "dev1",
+			"attributes": map[string]interface{}{
+				"inventory": map[string]interface{}{"os": "linux", "kernel": "5.10"},
+				"identity":  map[string]interface{}{"mac": "00:11:22:33:44:55"},
+				"system":    map[string]interface{}{"group": "group1"},
+			},
+			"updated_ts": updated,
+		},
+	}, {
+		Name: "error, invalid group_by",
+
+		Query:    "?group_by=name",
+		Code:     http.StatusBadRequest,
+		Response: ErrorResponse{Code: ErrCodeBadRequest, Err: "group_by must be 'scope'"},
+	}, {
+		Name: "error, device not found",
+
+		Params: &model.DeviceParams{
+			TenantID: "123456789012345678901234",
+			DeviceID: "dev1",
+		},
+		Error: errors.Wrap(reporting.ErrDeviceNotFound, "dev1"),
+		Code:  http.StatusNotFound,
+		Response: ErrorResponse{
+			Code: ErrCodeDeviceNotFound,
+			Err:  "dev1: " + reporting.ErrDeviceNotFound.Error(),
+		},
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			a := new(mapp.App)
+			if tc.Params != nil {
+				var res *model.InvDevice
+				if tc.Error == nil {
+					// the handler filters the attributes in place
+					cp := *dev
+					cp.Attributes = append(model.DeviceAttributes{}, dev.Attributes...)
+					res = &cp
+				}
+				a.On("GetDevice", contextMatcher, tc.Params).
+					Return(res, tc.Error)
+			}
+			defer a.AssertExpectations(t)
+			router := NewRouter(a)
+
+			req, _ := http.NewRequest(
+				http.MethodGet,
+				URIManagement+"/devices/details/dev1"+tc.Query,
+				nil,
+			)
+			req.Header.Set("Authorization", "Bearer "+GenerateJWT(identity.Identity{
+				Subject: "851f90b3-cee5-425e-8f6e-b36de1993e7e",
+				Tenant:  "123456789012345678901234",
+			}))
+			if tc.Scope != nil {
+				req.Header.Set(rbac.ScopeHeader, strings.Join(tc.Scope, ","))
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.Code, w.Code)
+			b, _ := json.Marshal(tc.Response)
+			if res, ok := tc.Response.(ErrorResponse); ok {
+				res.RequestID = w.Header().Get(requestid.RequestIdHeader)
+				b, _ = json.Marshal(res)
+			}
+			assert.JSONEq(t, string(b), w.Body.String())
+		})
+	}
+}
+
+func TestManagementSearchInvalidFilters(t *testing.T) {
+	t.Parallel()
+	a := new(mapp.App)
+	defer a.AssertExpectations(t)
+	router := NewRouter(a)
+
+	body := `{
+		"filters": [
+			{"scope": "inventory", "attribute": "os", "type": "$eq", "value": "linux"},
+			{"scope": "inventory", "attribute": "os", "type": "$like", "value": "linux"},
+			{"scope": "hardware", "attribute": "os", "type": "$eq", "value": "linux"},
+			{"scope": "inventory", "attribute": "os", "type": "$eq", "value": ["linux"]}
+		]
+	}`
+	req, _ := http.NewRequest(
+		http.MethodPost,
+		URIManagement+URIInventorySearch,
+		strings.NewReader(body),
+	)
+	req.Header.Set("Authorization", "Bearer "+GenerateJWT(identity.Identity{
+		Subject: "851f90b3-cee5-425e-8f6e-b36de1993e7e",
+		Tenant:  "123456789012345678901234",
+	}))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// all the invalid filters are reported at once
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	var res ErrorResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, ErrCodeBadRequest, res.Code)
+	assert.Equal(t, model.FilterErrors{
+		{Field: "filters", Index: 1, Err: "type: must be a valid value."},
+		{Field: "filters", Index: 2, Err: "scope: must be a valid value."},
+		{Field: "filters", Index: 3, Err: model.ErrArrayNotSupported.Error()},
+	}, res.InvalidFilters)
+}
+
+func TestManagementSearchValidate(t *testing.T) {
+	t.Parallel()
+	type testCase struct {
+		Name string
+
+		Query      string
+		Validation *model.QueryValidation
+		Error      error
+
+		Code     int
+		Response interface{}
+	}
+	testCases := []testCase{{
+		Name: "ok, valid",
+
+		Query:      "?validate=true",
+		Validation: &model.QueryValidation{Valid: true},
+		Code:       http.StatusOK,
+		Response:   &model.QueryValidation{Valid: true},
+	}, {
+		Name: "ok, invalid",
+
+		Query: "?validate=1",
+		Validation: &model.QueryValidation{
+			Error: "failed to parse date field [yesterday]",
+		},
+		Code: http.StatusOK,
+		Response: &model.QueryValidation{
+			Error: "failed to parse date field [yesterday]",
+		},
+	}, {
+		Name: "error, internal",
+
+		Query: "?validate=true",
+		Error: errors.New("connection refused"),
+		Code:  http.StatusInternalServerError,
+		Response: ErrorResponse{
+			Code: ErrCodeInternalServerError,
+			Err:  errMsgInternalServerError,
+		},
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			a := new(mapp.App)
+			a.On("ValidateSearch", contextMatcher,
+				mock.AnythingOfType("*model.SearchParams")).
+				Return(tc.Validation, tc.Error)
+			defer a.AssertExpectations(t)
+			router := NewRouter(a)
+
+			req, _ := http.NewRequest(
+				http.MethodPost,
+				URIManagement+URIInventorySearch+tc.Query,
+				strings.NewReader(`{"filters": [{"scope": "inventory",`+
+					`"attribute": "date", "type": "$gt", "value": "yesterday"}]}`),
+			)
+			req.Header.Set("Authorization", "Bearer "+GenerateJWT(identity.Identity{
+				Subject: "851f90b3-cee5-425e-8f6e-b36de1993e7e",
+				Tenant:  "123456789012345678901234",
+			}))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			// no search is run in validate mode
+			a.AssertNotCalled(t, "InventorySearchDevices",
+				mock.Anything, mock.Anything)
+			assert.Equal(t, tc.Code, w.Code)
+			b, _ := json.Marshal(tc.Response)
+			if res, ok := tc.Response.(ErrorResponse); ok {
+				res.RequestID = w.Header().Get(requestid.RequestIdHeader)
+				b, _ = json.Marshal(res)
+			}
+			assert.JSONEq(t, string(b), w.Body.String())
+		})
+	}
+}
+
+func TestManagementChanges(t *testing.T) {
+	t.Parallel()
+	since := time.Date(2021, 8, 19, 10, 25, 32, 0, time.UTC)
+	page := &model.ChangesPage{
+		Devices: []model.InvDevice{{
+			ID:         "dev1",
+			Attributes: model.DeviceAttributes{},
+		}},
+		Cursor: "eyJhZnRlciI6WzE2Mj",
+	}
+	type testCase struct {
+		Name string
+
+		Query  string
+		Scope  []string
+		Params *model.ChangesParams
+		Error  error
+
+		Code     int
+		Response interface{}
+	}
+	testCases := []testCase{{
+		Name: "ok, since",
+
+		Query: "?since=2021-08-19T10:25:32Z",
+		Params: &model.ChangesParams{
+			TenantID: "123456789012345678901234",
+			Since:    &since,
+			Size:     model.ChangesSizeDefault,
+		},
+		Code:     http.StatusOK,
+		Response: page,
+	}, {
+		Name: "ok, cursor, restricted to groups",
+
+		Query: "?cursor=eyJhZnRlciI6WzE2Mj&size=10",
+		Scope: []string{"group1"},
+		Params: &model.ChangesParams{
+			TenantID: "123456789012345678901234",
+			Groups:   []string{"group1"},
+			Cursor:   "eyJhZnRlciI6WzE2Mj",
+			Size:     10,
+		},
+		Code:     http.StatusOK,
+		Response: page,
+	}, {
+		Name: "error, malformed since",
+
+		Query: "?since=yesterday",
+		Code:  http.StatusBadRequest,
+		Response: ErrorResponse{
+			Code: ErrCodeBadRequest,
+			Err:  "since must be a RFC3339 timestamp",
+		},
+	}, {
+		Name: "error, size too large",
+
+		Query: "?size=1001",
+		Code:  http.StatusBadRequest,
+		Response: ErrorResponse{
+			Code: ErrCodeBadRequest,
+			Err:  "size: must be no greater than 1000.",
+		},
+	}, {
+		Name: "error, invalid cursor",
+
+		Query: "?cursor=foo",
+		Params: &model.ChangesParams{
+			TenantID: "123456789012345678901234",
+			Cursor:   "foo",
+			Size:     model.ChangesSizeDefault,
+		},
+		Error: model.ErrInvalidChangesCursor,
+		Code:  http.StatusBadRequest,
+		Response: ErrorResponse{
+			Code: ErrCodeInvalidChangesCursor,
+			Err:  model.ErrInvalidChangesCursor.Error(),
+		},
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			a := new(mapp.App)
+			if tc.Params != nil {
+				res, _ := tc.Response.(*model.ChangesPage)
+				a.On("GetDeviceChanges", contextMatcher, tc.Params).
+					Return(res, tc.Error)
+			}
+			defer a.AssertExpectations(t)
+			router := NewRouter(a)
+
+			req, _ := http.NewRequest(
+				http.MethodGet,
+				URIManagement+URIInventoryChanges+tc.Query,
+				nil,
+			)
+			req.Header.Set("Authorization", "Bearer "+GenerateJWT(identity.Identity{
+				Subject: "851f90b3-cee5-425e-8f6e-b36de1993e7e",
+				Tenant:  "123456789012345678901234",
+			}))
+			if tc.Scope != nil {
+				req.Header.Set(rbac.ScopeHeader, strings.Join(tc.Scope, ","))
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.Code, w.Code)
+			b, _ := json.Marshal(tc.Response)
+			if res, ok := tc.Response.(ErrorResponse); ok {
+				res.RequestID = w.Header().Get(requestid.RequestIdHeader)
+				b, _ = json.Marshal(res)
+			}
+			assert.JSONEq(t, string(b), w.Body.String())
+		})
+	}
+}
+
+func TestManagementPivot(t *testing.T) {
+	t.Parallel()
+	type testCase struct {
+		Name string
+
+		Body   string
+		Scope  []string
+		Params *model.PivotParams
+
+		Code     int
+		Response interface{}
+	}
+	testCases := []testCase{{
+		Name: "ok",
+
+		Body: `{"group_by": [
+			{"scope": "inventory", "attribute": "os"},
+			{"scope": "system", "attribute": "group", "size": 5}
+		]}`,
+		Scope: []string{"production", "staging"},
+		Params: &model.PivotParams{
+			GroupBy: []model.PivotDimension{
+				{Scope: "inventory", Attribute: "os"},
+				{Scope: "system", Attribute: "group", Size: 5},
+			},
+			Groups:   []string{"production", "staging"},
+			TenantID: "123456789012345678901234",
+		},
+		Code: http.StatusOK,
+		Response: &model.Pivot{
+			Buckets: []model.PivotBucket{{
+				Value: "linux",
+				Count: 10,
+				Buckets: []model.PivotBucket{
+					{Value: "production", Count: 6},
+					{Value: "staging", Count: 4},
+				},
+			}},
+		},
+	}, {
+		Name: "error, no group-by attributes",
+
+		Body:     `{"group_by": []}`,
+		Code:     http.StatusBadRequest,
+		Response: ErrorResponse{Code: ErrCodeBadRequest, Err: "group_by: cannot be blank."},
+	}, {
+		Name: "error, malformed body",
+
+		Body: `{"group_by": "os"}`,
+		Code: http.StatusBadRequest,
+		Response: ErrorResponse{
+			Code: ErrCodeBadRequest,
+			Err: "malformed request body: json: cannot unmarshal string into " +
+				"Go struct field PivotParams.group_by of type []model.PivotDimension",
+		},
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			a := new(mapp.App)
+			if tc.Params != nil {
+				res, _ := tc.Response.(*model.Pivot)
+				a.On("GetPivot", contextMatcher, tc.Params).
+					Return(res, nil)
+			}
+			defer a.AssertExpectations(t)
+			router := NewRouter(a)
+
+			req, _ := http.NewRequest(
+				http.MethodPost,
+				URIManagement+URIInventoryPivot,
+				strings.NewReader(tc.Body),
+			)
+			req.Header.Set("Authorization", "Bearer "+GenerateJWT(identity.Identity{
+				Subject: "851f90b3-cee5-425e-8f6e-b36de1993e7e",
+				Tenant:  "123456789012345678901234",
+			}))
+			if len(tc.Scope) > 0 {
+				req.Header.Set(rbac.ScopeHeader, strings.Join(tc.Scope, ","))
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.Code, w.Code)
+			b, _ := json.Marshal(tc.Response)
+			if res, ok := tc.Response.(ErrorResponse); ok {
+				res.RequestID = w.Header().Get(requestid.RequestIdHeader)
+				b, _ = json.Marshal(res)
+			}
+			assert.JSONEq(t, string(b), w.Body.String())
+		})
+	}
+}
+
+func TestManagementIPRanges(t *testing.T) {
+	t.Parallel()
+	type testCase struct {
+		Name string
+
+		Body   string
+		Params *model.IPRangesParams
+		Result *model.IPRanges
+		Err    error
+
+		Code     int
+		Response interface{}
+	}
+	params := &model.IPRangesParams{
+		Scope:     "inventory",
+		Attribute: "ipv4_eth0",
+		Ranges:    []string{"10.0.0.0/8"},
+		TenantID:  "123456789012345678901234",
+	}
+	result := &model.IPRanges{
+		Buckets: []model.IPRangeBucket{{
+			Range: "10.0.0.0/8",
+			From:  "10.0.0.0",
+			To:    "11.0.0.0",
+			Count: 4,
+		}},
+	}
+	testCases := []testCase{{
+		Name: "ok",
+
+		Body:     `{"scope": "inventory", "attribute": "ipv4_eth0", "ranges": ["10.0.0.0/8"]}`,
+		Params:   params,
+		Result:   result,
+		Code:     http.StatusOK,
+		Response: result,
+	}, {
+		Name: "error, invalid range",
+
+		Body: `{"scope": "inventory", "attribute": "ipv4_eth0", "ranges": ["10.0.0.0/33"]}`,
+		Code: http.StatusBadRequest,
+		Response: ErrorResponse{
+			Code: ErrCodeBadRequest,
+			Err:  `ranges: "10.0.0.0/33" is not a valid IPv4 CIDR range`,
+		},
+	}, {
+		Name: "error, attribute not mapped as ip",
+
+		Body:   `{"scope": "inventory", "attribute": "ipv4_eth0", "ranges": ["10.0.0.0/8"]}`,
+		Params: params,
+		Err:    errors.Wrap(model.ErrNotIPAttribute, "inventory/ipv4_eth0"),
+		Code:   http.StatusBadRequest,
+		Response: ErrorResponse{
+			Code: ErrCodeInvalidFilter,
+			Err:  "inventory/ipv4_eth0: attribute is not mapped as ip",
+		},
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			a := new(mapp.App)
+			if tc.Params != nil {
+				a.On("GetIPRanges", contextMatcher, tc.Params).
+					Return(tc.Result, tc.Err)
+			}
+			defer a.AssertExpectations(t)
+			router := NewRouter(a)
+
+			req, _ := http.NewRequest(
+				http.MethodPost,
+				URIManagement+URIInventoryIPRanges,
+				strings.NewReader(tc.Body),
+			)
+			req.Header.Set("Authorization", "Bearer "+GenerateJWT(identity.Identity{
+				Subject: "851f90b3-cee5-425e-8f6e-b36de1993e7e",
+				Tenant:  "123456789012345678901234",
+			}))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.Code, w.Code)
+			b, _ := json.Marshal(tc.Response)
+			if res, ok := tc.Response.(ErrorResponse); ok {
+				res.RequestID = w.Header().Get(requestid.RequestIdHeader)
+				b, _ = json.Marshal(res)
+			}
+			assert.JSONEq(t, string(b), w.Body.String())
+		})
+	}
+}
+
+func TestManagementGeohashGrid(t *testing.T) {
+	t.Parallel()
+	type testCase struct {
+		Name string
+
+		Body   string
+		Params *model.GeohashGridParams
+		Result *model.GeohashGrid
+		Err    error
+
+		Code     int
+		Response interface{}
+	}
+	params := &model.GeohashGridParams{
+		Scope:     "inventory",
+		Attribute: "location",
+		Precision: 4,
+		BoundingBox: &model.GeoBoundingBox{
+			TopLeft:     model.GeoPoint{Lat: 60, Lon: 5},
+			BottomRight: model.GeoPoint{Lat: 55, Lon: 15},
+		},
+		TenantID: "123456789012345678901234",
+	}
+	result := &model.GeohashGrid{
+		Cells: []model.GeohashCell{{Key: "u4pr", Count: 4}},
+	}
+	body := `{"scope": "inventory", "attribute": "location", "precision": 4,
+		"bounding_box": {
+			"top_left": {"lat": 60, "lon": 5},
+			"bottom_right": {"lat": 55, "lon": 15}
+		}}`
+	testCases := []testCase{{
+		Name: "ok",
+
+		Body:     body,
+		Params:   params,
+		Result:   result,
+		Code:     http.StatusOK,
+		Response: result,
+	}, {
+		Name: "error, invalid precision",
+
+		Body: `{"scope": "inventory", "attribute": "location", "precision": 20}`,
+		Code: http.StatusBadRequest,
+		Response: ErrorResponse{
+			Code: ErrCodeBadRequest,
+			Err:  "precision: must be no greater than 12.",
+		},
+	}, {
+		Name: "error, attribute not mapped as geo_point",
+
+		Body:   body,
+		Params: params,
+		Err:    errors.Wrap(model.ErrNotGeoPointAttribute, "inventory/location"),
+		Code:   http.StatusBadRequest,
+		Response: ErrorResponse{
+			Code: ErrCodeInvalidFilter,
+			Err:  "inventory/location: attribute is not mapped as geo_point",
+		},
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			a := new(mapp.App)
+			if tc.Params != nil {
+				a.On("GetGeohashGrid", contextMatcher, tc.Params).
+					Return(tc.Result, tc.Err)
+			}
+			defer a.AssertExpectations(t)
+			router := NewRouter(a)
+
+			req, _ := http.NewRequest(
+				http.MethodPost,
+				URIManagement+URIInventoryGeohashGrid,
+				strings.NewReader(tc.Body),
+			)
+			req.Header.Set("Authorization", "Bearer "+GenerateJWT(identity.Identity{
+				Subject: "851f90b3-cee5-425e-8f6e-b36de1993e7e",
+				Tenant:  "123456789012345678901234",
+			}))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.Code, w.Code)
+			b, _ := json.Marshal(tc.Response)
+			if res, ok := tc.Response.(ErrorResponse); ok {
+				res.RequestID = w.Header().Get(requestid.RequestIdHeader)
+				b, _ = json.Marshal(res)
+			}
+			assert.JSONEq(t, string(b), w.Body.String())
+		})
+	}
+}
@@ -15,11 +15,13 @@
 package http
 
 import (
+	"expvar"
+
 	"github.com/gin-gonic/gin"
 
 	"github.com/mendersoftware/go-lib-micro/accesslog"
-	"github.com/mendersoftware/go-lib-micro/identity"
 	"github.com/mendersoftware/go-lib-micro/rbac"
+	"github.com/mendersoftware/go-lib-micro/requestid"
 
 	"github.com/mendersoftware/reporting/app/reporting"
 )
@@ -30,33 +32,169 @@ const (
 	URIManagement = "/api/management/v1/reporting"
 
 	URILiveliness              = "/alive"
+	URIMetrics                 = "/metrics"
+	URIVersion                 = "/version"
 	URIInventorySearch         = "/devices/search"
 	URIInventorySearchAttrs    = "/devices/search/attributes"
+	URIInventoryAttrsMetadata  = "/devices/attributes/metadata"
+	URIInventoryGroups         = "/devices/groups"
+	URIInventoryExport         = "/devices/export"
+	URIInventoryScan           = "/devices/scan"
+	URIInventoryFacets         = "/devices/facets"
+	URIInventoryPivot          = "/devices/pivot"
+	URIInventoryIPRanges       = "/devices/ip_ranges"
+	URIInventoryGeohashGrid    = "/devices/geohash_grid"
+	URIInventoryHistory        = "/devices/history/:device_id"
+	URIInventoryCompare        = "/devices/compare"
+	URIInventoryChanges        = "/devices/changes"
+	URIInventoryDevice         = "/devices/details/:device_id"
 	URIInventorySearchInternal = "/inventory/tenants/:tenant_id/search"
+	URIDevicesBulkGetInternal  = "/devices/bulk"
+	URIDevicesExistInternal    = "/devices/exist"
 	URIReindexInternal         = "/tenants/:tenant_id/devices/:device_id/reindex"
+	URIReindexTenantInternal   = "/tenants/:tenant_id/reindex"
+	URITenantDevicesInternal   = "/tenants/:tenant_id/devices"
+	URIIndexSettingsInternal   = "/tenants/:tenant_id/index/settings"
+	URIRawAggsInternal         = "/tenants/:tenant_id/devices/aggregations"
 )
 
+type RouterOption func(*routerConfig)
+
+type routerConfig struct {
+	identity         IdentityConfig
+	attributeAccess  AttributeAccess
+	aggregationCache AggregationCacheConfig
+	searchLimit      SearchLimitConfig
+	searchProfile    bool
+	strictDecoding   bool
+	disabledFeatures map[string]bool
+	rawAggregations  RawAggregationsConfig
+}
+
+// WithSearchProfile enables the ES query profiling of the internal searches
+func WithSearchProfile(enabled bool) RouterOption {
+	return func(rc *routerConfig) {
+		rc.searchProfile = enabled
+	}
+}
+
+// WithStrictDecoding rejects the search requests with unknown fields,
+// e.g. misspelled ones, which are ignored otherwise
+func WithStrictDecoding(enabled bool) RouterOption {
+	return func(rc *routerConfig) {
+		rc.strictDecoding = enabled
+	}
+}
+
+// WithRawAggregations enables the internal raw aggregations passthrough
+func WithRawAggregations(conf RawAggregationsConfig) RouterOption {
+	return func(rc *routerConfig) {
+		rc.rawAggregations = conf
+	}
+}
+
+// WithAttributeAccess restricts the attributes visible to the user roles
+func WithAttributeAccess(conf AttributeAccess) RouterOption {
+	return func(rc *routerConfig) {
+		rc.attributeAccess = conf
+	}
+}
+
+// WithAggregationCache enables the cache of the aggregation results
+func WithAggregationCache(conf AggregationCacheConfig) RouterOption {
+	return func(rc *routerConfig) {
+		rc.aggregationCache = conf
+	}
+}
+
+// WithSearchLimit sets the responses to the searches rejected by the
+// limit of concurrent searches
+func WithSearchLimit(conf SearchLimitConfig) RouterOption {
+	return func(rc *routerConfig) {
+		rc.searchLimit = conf
+	}
+}
+
+// WithIdentityConfig sets the extraction of the identity of the
+// management requests
+func WithIdentityConfig(conf IdentityConfig) RouterOption {
+	return func(rc *routerConfig) {
+		rc.identity = conf
+	}
+}
+
 // NewRouter returns the gin router
-func NewRouter(reporting reporting.App) *gin.Engine {
+func NewRouter(reporting reporting.App, opts ...RouterOption) *gin.Engine {
+	conf := &routerConfig{
+		identity: IdentityConfig{
+			TenantClaim: TenantClaimDefault,
+		},
+	}
+	for _, opt := range opts {
+		opt(conf)
+	}
+
 	gin.SetMode(gin.ReleaseMode)
 	gin.DisableConsoleColor()
 
 	router := gin.New()
 	router.Use(accesslog.Middleware())
+	router.Use(requestid.Middleware())
 	router.Use(gin.Recovery())
+	router.Use(searchLimitMiddleware(conf.searchLimit))
 
 	internal := NewInternalController(reporting)
+	internal.searchProfile = conf.searchProfile
+	internal.strictDecoding = conf.strictDecoding
+	internal.rawAggregations = conf.rawAggregations
 	internalAPI := router.Group(URIInternal)
 	internalAPI.GET(URILiveliness, internal.Alive)
+	internalAPI.GET(URIMetrics, gin.WrapH(expvar.Handler()))
+	internalAPI.GET(URIVersion, internal.Version)
 	internalAPI.POST(URIInventorySearchInternal, internal.Search)
+	internalAPI.POST(URIDevicesBulkGetInternal,
+		conf.feature(FeatureBulkGet, noTenantToken(internal.BulkGetDevices)))
+	internalAPI.POST(URIDevicesExistInternal,
+		conf.feature(FeatureDevicesExist, internal.DevicesExist))
 	internalAPI.POST(URIReindexInternal, internal.Reindex)
+	internalAPI.POST(URIReindexTenantInternal, internal.ReindexTenant)
+	internalAPI.GET(URIReindexTenantInternal, internal.ReindexTenantStatus)
+	internalAPI.DELETE(URIReindexTenantInternal, internal.CancelReindexTenant)
+	internalAPI.DELETE(URITenantDevicesInternal,
+		noTenantToken(internal.DeleteTenantDevices))
+	internalAPI.GET(URIIndexSettingsInternal,
+		conf.feature(FeatureIndexSettings, noTenantToken(internal.IndexSettings)))
+	internalAPI.POST(URIRawAggsInternal, noTenantToken(internal.RawAggregations))
 
 	mgmt := NewManagementController(reporting)
+	mgmt.strictDecoding = conf.strictDecoding
+	if conf.aggregationCache.TTL > 0 {
+		mgmt.cache = newAggregationCache(conf.aggregationCache)
+	}
 	mgmtAPI := router.Group(URIManagement)
-	mgmtAPI.Use(identity.Middleware())
+	mgmtAPI.Use(identityMiddleware(conf.identity))
 	mgmtAPI.Use(rbac.Middleware())
+	if len(conf.attributeAccess.Roles) > 0 {
+		mgmtAPI.Use(attributeAccessMiddleware(conf.attributeAccess))
+	}
 	mgmtAPI.POST(URIInventorySearch, mgmt.Search)
-	mgmtAPI.GET(URIInventorySearchAttrs, mgmt.SearchAttrs)
+	mgmtAPI.GET(URIInventorySearchAttrs,
+		conf.feature(FeatureSearchAttributes, mgmt.SearchAttrs))
+	mgmtAPI.GET(URIInventoryAttrsMetadata,
+		conf.feature(FeatureAttributesMetadata, mgmt.AttributesMetadata))
+	mgmtAPI.GET(URIInventoryGroups, conf.feature(FeatureGroups, mgmt.Groups))
+	mgmtAPI.POST(URIInventoryExport, conf.feature(FeatureExport, mgmt.Export))
+	mgmtAPI.POST(URIInventoryScan, conf.feature(FeatureScan, mgmt.Scan))
+	mgmtAPI.POST(URIInventoryFacets, conf.feature(FeatureFacets, mgmt.Facets))
+	mgmtAPI.POST(URIInventoryPivot, conf.feature(FeaturePivot, mgmt.Pivot))
+	mgmtAPI.POST(URIInventoryIPRanges, conf.feature(FeatureIPRanges, mgmt.IPRanges))
+	mgmtAPI.POST(URIInventoryGeohashGrid,
+		conf.feature(FeatureGeohashGrid, mgmt.GeohashGrid))
+	mgmtAPI.GET(URIInventoryHistory,
+		conf.feature(FeatureHistory, mgmt.AttributeHistory))
+	mgmtAPI.GET(URIInventoryCompare, conf.feature(FeatureCompare, mgmt.CompareDevices))
+	mgmtAPI.GET(URIInventoryChanges, conf.feature(FeatureChanges, mgmt.Changes))
+	mgmtAPI.GET(URIInventoryDevice, conf.feature(FeatureDeviceDetails, mgmt.GetDevice))
 
 	return router
 }
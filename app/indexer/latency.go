@@ -0,0 +1,100 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package indexer
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+
+	"github.com/mendersoftware/reporting/store"
+)
+
+var (
+	// metricBatchLatency is the indexing latency of the last batch, in ms
+	metricBatchLatency = expvar.NewInt("indexing_batch_latency_msec")
+	// metricSlowBatches counts the batches slower than the threshold
+	metricSlowBatches = expvar.NewInt("indexing_slow_batches")
+	// metricSlowBatchesTenant counts, for each tenant, the slow batches
+	// with some of its devices
+	metricSlowBatchesTenant = expvar.NewMap("indexing_slow_batches_tenant")
+)
+
+// LatencyMonitor measures the indexing latency of the batches, and warns
+// about the ones slower than a threshold, with the number of items of
+// each tenant, so that ops can tell which tenants the slow batches hit
+type LatencyMonitor struct {
+	threshold time.Duration
+}
+
+// NewLatencyMonitor returns a monitor warning about the batches slower
+// than threshold; a threshold <= 0 disables the warnings
+func NewLatencyMonitor(threshold time.Duration) *LatencyMonitor {
+	return &LatencyMonitor{
+		threshold: threshold,
+	}
+}
+
+// Observe records the latency of indexing the items, and tells
+// if the batch is slow
+func (m *LatencyMonitor) Observe(
+	ctx context.Context,
+	items []store.BulkItem,
+	latency time.Duration,
+) bool {
+	metricBatchLatency.Set(latency.Milliseconds())
+	if m.threshold <= 0 || latency <= m.threshold {
+		return false
+	}
+
+	tenants := tenantBreakdown(items)
+	log.FromContext(ctx).Warnf(
+		"slow indexing batch: %d items took %s (threshold %s), items per tenant: %s",
+		len(items), latency, m.threshold, formatBreakdown(tenants))
+
+	metricSlowBatches.Add(1)
+	for tenant := range tenants {
+		metricSlowBatchesTenant.Add(tenant, 1)
+	}
+	return true
+}
+
+// tenantBreakdown counts the items of each tenant
+func tenantBreakdown(items []store.BulkItem) map[string]int {
+	tenants := map[string]int{}
+	for _, item := range items {
+		if item.Action == nil || item.Action.Desc == nil {
+			continue
+		}
+		tenants[item.Action.Desc.Tenant]++
+	}
+	return tenants
+}
+
+// formatBreakdown formats the counts as "<tenant>=<count>",
+// sorted by tenant
+func formatBreakdown(tenants map[string]int) string {
+	parts := make([]string, 0, len(tenants))
+	for tenant, count := range tenants {
+		parts = append(parts, fmt.Sprintf("%s=%d", tenant, count))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ", ")
+}
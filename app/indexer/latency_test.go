@@ -0,0 +1,74 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package indexer
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+
+	"github.com/mendersoftware/reporting/store"
+)
+
+func TestLatencyMonitor(t *testing.T) {
+	item := func(tenant, id string) store.BulkItem {
+		return store.BulkItem{
+			Action: &store.BulkAction{
+				Type: "index",
+				Desc: &store.BulkActionDesc{ID: id, Tenant: tenant},
+			},
+		}
+	}
+	items := []store.BulkItem{
+		item("tenant2", "dev3"),
+		item("tenant1", "dev1"),
+		item("tenant1", "dev2"),
+	}
+
+	var buf bytes.Buffer
+	logger := logrus.New()
+	logger.Out = &buf
+	ctx := log.WithContext(context.Background(), log.NewFromLogger(logger, log.Ctx{}))
+
+	slow := metricSlowBatches.Value()
+	m := NewLatencyMonitor(time.Second)
+
+	// a fast batch isn't reported
+	assert.False(t, m.Observe(ctx, items, 500*time.Millisecond))
+	assert.Empty(t, buf.String())
+	assert.Equal(t, slow, metricSlowBatches.Value())
+	assert.Equal(t, int64(500), metricBatchLatency.Value())
+
+	// a slow batch is, with the tenant breakdown
+	assert.True(t, m.Observe(ctx, items, 2*time.Second))
+	assert.Contains(t, buf.String(), "level=warning")
+	assert.Contains(t, buf.String(),
+		"slow indexing batch: 3 items took 2s (threshold 1s), "+
+			"items per tenant: tenant1=2, tenant2=1")
+	assert.Equal(t, slow+1, metricSlowBatches.Value())
+	assert.Equal(t, "1", metricSlowBatchesTenant.Get("tenant1").String())
+	assert.Equal(t, "1", metricSlowBatchesTenant.Get("tenant2").String())
+
+	// no threshold, no warnings
+	buf.Reset()
+	assert.False(t, NewLatencyMonitor(0).Observe(ctx, items, time.Hour))
+	assert.Empty(t, buf.String())
+}
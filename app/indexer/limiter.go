@@ -0,0 +1,116 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package indexer
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// TenantLimiter limits the indexing throughput of each tenant with
+// a token bucket per tenant, so that a single tenant flooding device
+// updates doesn't starve the others
+type TenantLimiter struct {
+	rate   float64
+	burst  int
+	quotas map[string]float64
+
+	buckets map[string]*bucket
+	mu      sync.Mutex
+
+	now func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewTenantLimiter returns a limiter granting each tenant 'rate' requests
+// per second, with bursts of up to 'burst' requests; quotas overrides the
+// rate for specific tenants. A rate <= 0 means unlimited.
+func NewTenantLimiter(rate float64, burst int, quotas map[string]float64) *TenantLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &TenantLimiter{
+		rate:    rate,
+		burst:   burst,
+		quotas:  quotas,
+		buckets: map[string]*bucket{},
+		now:     time.Now,
+	}
+}
+
+// Reserve takes a token from the tenant's bucket and returns how long the
+// caller has to wait before proceeding; requests exceeding the quota are
+// never refused, the bucket goes into debt and they're delayed instead
+func (tl *TenantLimiter) Reserve(tenant string) time.Duration {
+	rate := tl.tenantRate(tenant)
+	if rate <= 0 {
+		return 0
+	}
+
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+
+	now := tl.now()
+	b, ok := tl.buckets[tenant]
+	if !ok {
+		b = &bucket{tokens: float64(tl.burst), last: now}
+		tl.buckets[tenant] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > float64(tl.burst) {
+		b.tokens = float64(tl.burst)
+	}
+	b.last = now
+
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / rate * float64(time.Second))
+}
+
+func (tl *TenantLimiter) tenantRate(tenant string) float64 {
+	if rate, ok := tl.quotas[tenant]; ok {
+		return rate
+	}
+	return tl.rate
+}
+
+// ParseTenantQuotas parses the per-tenant quotas in the form
+// "<tenant_id>=<requests per second>"
+func ParseTenantQuotas(quotas []string) (map[string]float64, error) {
+	ret := make(map[string]float64, len(quotas))
+	for _, q := range quotas {
+		parts := strings.SplitN(q, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errors.Errorf("invalid tenant quota %q", q)
+		}
+		rate, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid tenant quota %q", q)
+		}
+		ret[parts[0]] = rate
+	}
+	return ret, nil
+}
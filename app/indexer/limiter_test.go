@@ -0,0 +1,101 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package indexer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTenantLimiter(t *testing.T) {
+	now := time.Now()
+	limiter := NewTenantLimiter(10, 2, map[string]float64{
+		"unlimited": 0,
+		"premium":   100,
+	})
+	limiter.now = func() time.Time { return now }
+
+	// the burst is granted right away
+	assert.Equal(t, time.Duration(0), limiter.Reserve("tenant1"))
+	assert.Equal(t, time.Duration(0), limiter.Reserve("tenant1"))
+
+	// over quota: throttled, not refused, with growing delays
+	assert.Equal(t, 100*time.Millisecond, limiter.Reserve("tenant1"))
+	assert.Equal(t, 200*time.Millisecond, limiter.Reserve("tenant1"))
+
+	// the other tenants flow freely
+	assert.Equal(t, time.Duration(0), limiter.Reserve("tenant2"))
+	assert.Equal(t, time.Duration(0), limiter.Reserve("tenant2"))
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, time.Duration(0), limiter.Reserve("unlimited"))
+	}
+
+	// per-tenant quotas override the default rate
+	limiter.Reserve("premium")
+	limiter.Reserve("premium")
+	assert.Equal(t, 10*time.Millisecond, limiter.Reserve("premium"))
+
+	// tokens are refilled over time, up to the burst
+	now = now.Add(time.Second)
+	assert.Equal(t, time.Duration(0), limiter.Reserve("tenant1"))
+	assert.Equal(t, time.Duration(0), limiter.Reserve("tenant1"))
+	assert.Equal(t, 100*time.Millisecond, limiter.Reserve("tenant1"))
+}
+
+func TestTenantLimiterUnlimited(t *testing.T) {
+	limiter := NewTenantLimiter(0, 0, nil)
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, time.Duration(0), limiter.Reserve("tenant1"))
+	}
+}
+
+func TestParseTenantQuotas(t *testing.T) {
+	testCases := map[string]struct {
+		quotas []string
+
+		res map[string]float64
+		err string
+	}{
+		"ok": {
+			quotas: []string{"tenant1=10", "tenant2=0.5"},
+			res:    map[string]float64{"tenant1": 10, "tenant2": 0.5},
+		},
+		"ok, empty": {
+			res: map[string]float64{},
+		},
+		"error, missing rate": {
+			quotas: []string{"tenant1"},
+			err:    `invalid tenant quota "tenant1"`,
+		},
+		"error, invalid rate": {
+			quotas: []string{"tenant1=fast"},
+			err: `invalid tenant quota "tenant1=fast": ` +
+				`strconv.ParseFloat: parsing "fast": invalid syntax`,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			res, err := ParseTenantQuotas(tc.quotas)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.res, res)
+			}
+		})
+	}
+}
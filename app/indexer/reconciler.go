@@ -0,0 +1,217 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package indexer
+
+import (
+	"context"
+	"expvar"
+	"sort"
+	"time"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+
+	"github.com/mendersoftware/reporting/client/inventory"
+	"github.com/mendersoftware/reporting/store"
+)
+
+// ReconcileIntervalDefault is the interval of the reconciliations,
+// unless configured
+const ReconcileIntervalDefault = time.Hour
+
+var (
+	// metricReconcileDelta is the device count delta (source - indexed)
+	// of each drifted tenant, as of the last reconciliation
+	metricReconcileDelta = expvar.NewMap("reconcile_device_count_delta")
+	// metricReconcileDriftedTenants is the number of drifted tenants,
+	// as of the last reconciliation
+	metricReconcileDriftedTenants = expvar.NewInt("reconcile_drifted_tenants")
+	// metricReconcileMissingDevices is the number of sampled source devices
+	// which aren't indexed, as of the last reconciliation
+	metricReconcileMissingDevices = expvar.NewInt("reconcile_missing_devices")
+	// metricReconcileRuns counts the reconciliations
+	metricReconcileRuns = expvar.NewInt("reconcile_runs")
+)
+
+// ReconcilerConfig configures the periodic reconciliation of the indexed
+// devices with the source service: every Interval the device counts of each
+// tenant are compared, and up to SampleSize of the tenant's source devices
+// are checked to be indexed (0: no sampling)
+type ReconcilerConfig struct {
+	Interval   time.Duration
+	SampleSize int
+}
+
+// TenantDrift is the outcome of the reconciliation of a tenant
+type TenantDrift struct {
+	TenantID string
+	// Indexed is the number of devices in ES
+	Indexed int
+	// Source is the number of devices in the source service
+	Source int
+	// Missing are the sampled source devices which aren't indexed
+	Missing []string
+}
+
+// Delta is the number of devices the index lacks (or has in excess,
+// if negative) compared to the source service
+func (d TenantDrift) Delta() int {
+	return d.Source - d.Indexed
+}
+
+// Drifted tells if the index doesn't match the source service
+func (d TenantDrift) Drifted() bool {
+	return d.Delta() != 0 || len(d.Missing) > 0
+}
+
+// Reconciler detects the indexing drift, i.e. the tenants whose indexed
+// devices don't match the source service's ones; the drift is logged and
+// exposed in the metrics, not repaired
+type Reconciler struct {
+	conf      ReconcilerConfig
+	store     store.Store
+	inventory inventory.Client
+}
+
+func NewReconciler(
+	conf ReconcilerConfig,
+	store store.Store,
+	client inventory.Client,
+) *Reconciler {
+	if conf.Interval <= 0 {
+		conf.Interval = ReconcileIntervalDefault
+	}
+	return &Reconciler{
+		conf:      conf,
+		store:     store,
+		inventory: client,
+	}
+}
+
+// Run reconciles every conf.Interval, until ctx is done
+func (r *Reconciler) Run(ctx context.Context) {
+	l := log.FromContext(ctx)
+
+	tick := time.NewTicker(r.conf.Interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			if _, err := r.Reconcile(ctx); err != nil {
+				l.Errorf("failed to reconcile the indexed devices: %s", err)
+			}
+		}
+	}
+}
+
+// Reconcile compares the indexed devices of all the tenants with the source
+// service once, and updates the metrics; only the drifted tenants are
+// returned. The tenants failing to reconcile are skipped.
+func (r *Reconciler) Reconcile(ctx context.Context) ([]TenantDrift, error) {
+	l := log.FromContext(ctx)
+
+	counts, err := r.store.CountTenantsDevices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tenants := make([]string, 0, len(counts))
+	for tenant := range counts {
+		tenants = append(tenants, tenant)
+	}
+	sort.Strings(tenants)
+
+	drifts := []TenantDrift{}
+	for _, tenant := range tenants {
+		drift, err := r.reconcileTenant(ctx, tenant, counts[tenant])
+		if err != nil {
+			l.Errorf("failed to reconcile the devices of tenant %s: %s", tenant, err)
+			continue
+		}
+		if drift.Drifted() {
+			l.Warnf("indexing drift of tenant %s: %d devices in the source, "+
+				"%d indexed, %d sampled devices missing",
+				tenant, drift.Source, drift.Indexed, len(drift.Missing))
+			drifts = append(drifts, *drift)
+		}
+	}
+
+	updateReconcileMetrics(drifts)
+	return drifts, nil
+}
+
+// reconcileTenant compares the tenant's indexed devices with the source
+// service, sampling the first conf.SampleSize source devices
+func (r *Reconciler) reconcileTenant(
+	ctx context.Context,
+	tenant string,
+	indexed int,
+) (*TenantDrift, error) {
+	perPage := r.conf.SampleSize
+	if perPage <= 0 {
+		perPage = 1
+	}
+	devs, total, err := r.inventory.SearchDevices(ctx, tenant, &inventory.SearchReq{
+		Page:    1,
+		PerPage: perPage,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	drift := &TenantDrift{
+		TenantID: tenant,
+		Indexed:  indexed,
+		Source:   total,
+	}
+	if r.conf.SampleSize <= 0 || len(devs) == 0 {
+		return drift, nil
+	}
+
+	ids := make([]string, 0, len(devs))
+	for _, dev := range devs {
+		ids = append(ids, string(dev.ID))
+	}
+	found, err := r.store.DevicesExist(ctx, tenant, ids)
+	if err != nil {
+		return nil, err
+	}
+	exists := make(map[string]bool, len(found))
+	for _, id := range found {
+		exists[id] = true
+	}
+	for _, id := range ids {
+		if !exists[id] {
+			drift.Missing = append(drift.Missing, id)
+		}
+	}
+	return drift, nil
+}
+
+// updateReconcileMetrics replaces the metrics with the outcome of
+// the last reconciliation
+func updateReconcileMetrics(drifts []TenantDrift) {
+	missing := 0
+	metricReconcileDelta.Init()
+	for _, drift := range drifts {
+		delta := new(expvar.Int)
+		delta.Set(int64(drift.Delta()))
+		metricReconcileDelta.Set(drift.TenantID, delta)
+		missing += len(drift.Missing)
+	}
+	metricReconcileDriftedTenants.Set(int64(len(drifts)))
+	metricReconcileMissingDevices.Set(int64(missing))
+	metricReconcileRuns.Add(1)
+}
@@ -0,0 +1,124 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package indexer
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/reporting/client/inventory"
+	minventory "github.com/mendersoftware/reporting/client/inventory/mocks"
+	"github.com/mendersoftware/reporting/model"
+	mstore "github.com/mendersoftware/reporting/store/mocks"
+)
+
+var contextMatcher = mock.MatchedBy(func(_ context.Context) bool { return true })
+
+func TestReconcile(t *testing.T) {
+	store := new(mstore.Store)
+	store.On("CountTenantsDevices", contextMatcher).
+		Return(map[string]int{
+			"tenant1": 10,
+			"tenant2": 5,
+			"tenant3": 7,
+			"tenant4": 1,
+		}, nil)
+	// tenant3 is in sync, but one of its sampled devices isn't indexed
+	store.On("DevicesExist", contextMatcher, "tenant1", []string{"dev1", "dev2"}).
+		Return([]string{"dev1", "dev2"}, nil)
+	store.On("DevicesExist", contextMatcher, "tenant2", []string{"dev3", "dev4"}).
+		Return([]string{"dev3", "dev4"}, nil)
+	store.On("DevicesExist", contextMatcher, "tenant3", []string{"dev5", "dev6"}).
+		Return([]string{"dev6"}, nil)
+	defer store.AssertExpectations(t)
+
+	devices := func(ids ...string) []model.InvDevice {
+		devs := make([]model.InvDevice, len(ids))
+		for i, id := range ids {
+			devs[i].ID = model.DeviceID(id)
+		}
+		return devs
+	}
+	req := &inventory.SearchReq{Page: 1, PerPage: 2}
+	inv := new(minventory.Client)
+	inv.On("SearchDevices", contextMatcher, "tenant1", req).
+		Return(devices("dev1", "dev2"), 12, nil)
+	inv.On("SearchDevices", contextMatcher, "tenant2", req).
+		Return(devices("dev3", "dev4"), 3, nil)
+	inv.On("SearchDevices", contextMatcher, "tenant3", req).
+		Return(devices("dev5", "dev6"), 7, nil)
+	// a failing tenant is skipped
+	inv.On("SearchDevices", contextMatcher, "tenant4", req).
+		Return(nil, 0, errors.New("connection refused"))
+	defer inv.AssertExpectations(t)
+
+	runs := metricReconcileRuns.Value()
+	r := NewReconciler(ReconcilerConfig{SampleSize: 2}, store, inv)
+	drifts, err := r.Reconcile(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []TenantDrift{
+		{TenantID: "tenant1", Indexed: 10, Source: 12},
+		{TenantID: "tenant2", Indexed: 5, Source: 3},
+		{TenantID: "tenant3", Indexed: 7, Source: 7, Missing: []string{"dev5"}},
+	}, drifts)
+	assert.Equal(t, 2, drifts[0].Delta())
+	assert.Equal(t, -2, drifts[1].Delta())
+
+	assert.Equal(t, "2", metricReconcileDelta.Get("tenant1").String())
+	assert.Equal(t, "-2", metricReconcileDelta.Get("tenant2").String())
+	assert.Equal(t, "0", metricReconcileDelta.Get("tenant3").String())
+	assert.Nil(t, metricReconcileDelta.Get("tenant4"))
+	assert.Equal(t, int64(3), metricReconcileDriftedTenants.Value())
+	assert.Equal(t, int64(1), metricReconcileMissingDevices.Value())
+	assert.Equal(t, runs+1, metricReconcileRuns.Value())
+}
+
+func TestReconcileInSync(t *testing.T) {
+	store := new(mstore.Store)
+	store.On("CountTenantsDevices", contextMatcher).
+		Return(map[string]int{"tenant1": 10}, nil)
+	defer store.AssertExpectations(t)
+
+	// without sampling, only the counts are compared
+	inv := new(minventory.Client)
+	inv.On("SearchDevices", contextMatcher, "tenant1",
+		&inventory.SearchReq{Page: 1, PerPage: 1}).
+		Return([]model.InvDevice{{ID: "dev1"}}, 10, nil)
+	defer inv.AssertExpectations(t)
+
+	r := NewReconciler(ReconcilerConfig{}, store, inv)
+	drifts, err := r.Reconcile(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, drifts)
+
+	assert.Nil(t, metricReconcileDelta.Get("tenant1"))
+	assert.Equal(t, int64(0), metricReconcileDriftedTenants.Value())
+	assert.Equal(t, int64(0), metricReconcileMissingDevices.Value())
+}
+
+func TestReconcileError(t *testing.T) {
+	store := new(mstore.Store)
+	store.On("CountTenantsDevices", contextMatcher).
+		Return(nil, errors.New("connection refused"))
+	defer store.AssertExpectations(t)
+
+	r := NewReconciler(ReconcilerConfig{}, store, new(minventory.Client))
+	_, err := r.Reconcile(context.Background())
+	assert.EqualError(t, err, "connection refused")
+}
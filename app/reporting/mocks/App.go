@@ -17,6 +17,7 @@ package mocks
 
 import (
 	context "context"
+	time "time"
 
 	model "github.com/mendersoftware/reporting/model"
 	mock "github.com/stretchr/testify/mock"
@@ -27,6 +28,400 @@ type App struct {
 	mock.Mock
 }
 
+// BulkGetDevices provides a mock function with given fields: ctx, params
+func (_m *App) BulkGetDevices(ctx context.Context, params model.BulkGetParams) (*model.BulkGetResult, error) {
+	ret := _m.Called(ctx, params)
+
+	var r0 *model.BulkGetResult
+	if rf, ok := ret.Get(0).(func(context.Context, model.BulkGetParams) *model.BulkGetResult); ok {
+		r0 = rf(ctx, params)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.BulkGetResult)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, model.BulkGetParams) error); ok {
+		r1 = rf(ctx, params)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CancelReindexTenant provides a mock function with given fields: ctx, tid
+func (_m *App) CancelReindexTenant(ctx context.Context, tid string) error {
+	ret := _m.Called(ctx, tid)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, tid)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// CompareDevices provides a mock function with given fields: ctx, params
+func (_m *App) CompareDevices(ctx context.Context, params *model.CompareDevicesParams) (*model.DeviceComparison, error) {
+	ret := _m.Called(ctx, params)
+
+	var r0 *model.DeviceComparison
+	if rf, ok := ret.Get(0).(func(context.Context, *model.CompareDevicesParams) *model.DeviceComparison); ok {
+		r0 = rf(ctx, params)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.DeviceComparison)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *model.CompareDevicesParams) error); ok {
+		r1 = rf(ctx, params)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DeleteTenantDevices provides a mock function with given fields: ctx, tid
+func (_m *App) DeleteTenantDevices(ctx context.Context, tid string) (int, error) {
+	ret := _m.Called(ctx, tid)
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func(context.Context, string) int); ok {
+		r0 = rf(ctx, tid)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, tid)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DevicesExist provides a mock function with given fields: ctx, params
+func (_m *App) DevicesExist(ctx context.Context, params *model.DevicesExistParams) (*model.DevicesExistResult, error) {
+	ret := _m.Called(ctx, params)
+
+	var r0 *model.DevicesExistResult
+	if rf, ok := ret.Get(0).(func(context.Context, *model.DevicesExistParams) *model.DevicesExistResult); ok {
+		r0 = rf(ctx, params)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.DevicesExistResult)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *model.DevicesExistParams) error); ok {
+		r1 = rf(ctx, params)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ExportDevices provides a mock function with given fields: ctx, searchParams, emit
+func (_m *App) ExportDevices(ctx context.Context, searchParams *model.SearchParams, emit func(*model.InvDevice) error) error {
+	ret := _m.Called(ctx, searchParams, emit)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.SearchParams, func(*model.InvDevice) error) error); ok {
+		r0 = rf(ctx, searchParams, emit)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// GetAttributeHistory provides a mock function with given fields: ctx, params
+func (_m *App) GetAttributeHistory(ctx context.Context, params *model.AttributeHistoryParams) ([]model.AttributeHistory, error) {
+	ret := _m.Called(ctx, params)
+
+	var r0 []model.AttributeHistory
+	if rf, ok := ret.Get(0).(func(context.Context, *model.AttributeHistoryParams) []model.AttributeHistory); ok {
+		r0 = rf(ctx, params)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.AttributeHistory)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *model.AttributeHistoryParams) error); ok {
+		r1 = rf(ctx, params)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetAttributesMetadata provides a mock function with given fields: ctx, tid
+func (_m *App) GetAttributesMetadata(ctx context.Context, tid string) ([]model.InvAttrMetadata, error) {
+	ret := _m.Called(ctx, tid)
+
+	var r0 []model.InvAttrMetadata
+	if rf, ok := ret.Get(0).(func(context.Context, string) []model.InvAttrMetadata); ok {
+		r0 = rf(ctx, tid)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.InvAttrMetadata)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, tid)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetDevice provides a mock function with given fields: ctx, params
+func (_m *App) GetDevice(ctx context.Context, params *model.DeviceParams) (*model.InvDevice, error) {
+	ret := _m.Called(ctx, params)
+
+	var r0 *model.InvDevice
+	if rf, ok := ret.Get(0).(func(context.Context, *model.DeviceParams) *model.InvDevice); ok {
+		r0 = rf(ctx, params)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.InvDevice)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *model.DeviceParams) error); ok {
+		r1 = rf(ctx, params)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetDeviceChanges provides a mock function with given fields: ctx, params
+func (_m *App) GetDeviceChanges(ctx context.Context, params *model.ChangesParams) (*model.ChangesPage, error) {
+	ret := _m.Called(ctx, params)
+
+	var r0 *model.ChangesPage
+	if rf, ok := ret.Get(0).(func(context.Context, *model.ChangesParams) *model.ChangesPage); ok {
+		r0 = rf(ctx, params)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.ChangesPage)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *model.ChangesParams) error); ok {
+		r1 = rf(ctx, params)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetFacets provides a mock function with given fields: ctx, params
+func (_m *App) GetFacets(ctx context.Context, params *model.FacetsParams) (*model.Facets, error) {
+	ret := _m.Called(ctx, params)
+
+	var r0 *model.Facets
+	if rf, ok := ret.Get(0).(func(context.Context, *model.FacetsParams) *model.Facets); ok {
+		r0 = rf(ctx, params)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Facets)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *model.FacetsParams) error); ok {
+		r1 = rf(ctx, params)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetGeohashGrid provides a mock function with given fields: ctx, params
+func (_m *App) GetGeohashGrid(ctx context.Context, params *model.GeohashGridParams) (*model.GeohashGrid, error) {
+	ret := _m.Called(ctx, params)
+
+	var r0 *model.GeohashGrid
+	if rf, ok := ret.Get(0).(func(context.Context, *model.GeohashGridParams) *model.GeohashGrid); ok {
+		r0 = rf(ctx, params)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.GeohashGrid)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *model.GeohashGridParams) error); ok {
+		r1 = rf(ctx, params)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetGroups provides a mock function with given fields: ctx, params
+func (_m *App) GetGroups(ctx context.Context, params *model.GroupsParams) ([]model.GroupCount, error) {
+	ret := _m.Called(ctx, params)
+
+	var r0 []model.GroupCount
+	if rf, ok := ret.Get(0).(func(context.Context, *model.GroupsParams) []model.GroupCount); ok {
+		r0 = rf(ctx, params)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.GroupCount)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *model.GroupsParams) error); ok {
+		r1 = rf(ctx, params)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetIPRanges provides a mock function with given fields: ctx, params
+func (_m *App) GetIPRanges(ctx context.Context, params *model.IPRangesParams) (*model.IPRanges, error) {
+	ret := _m.Called(ctx, params)
+
+	var r0 *model.IPRanges
+	if rf, ok := ret.Get(0).(func(context.Context, *model.IPRangesParams) *model.IPRanges); ok {
+		r0 = rf(ctx, params)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.IPRanges)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *model.IPRangesParams) error); ok {
+		r1 = rf(ctx, params)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetIndexSettings provides a mock function with given fields: ctx, tid
+func (_m *App) GetIndexSettings(ctx context.Context, tid string) (*model.IndexSettings, error) {
+	ret := _m.Called(ctx, tid)
+
+	var r0 *model.IndexSettings
+	if rf, ok := ret.Get(0).(func(context.Context, string) *model.IndexSettings); ok {
+		r0 = rf(ctx, tid)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.IndexSettings)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, tid)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetPivot provides a mock function with given fields: ctx, params
+func (_m *App) GetPivot(ctx context.Context, params *model.PivotParams) (*model.Pivot, error) {
+	ret := _m.Called(ctx, params)
+
+	var r0 *model.Pivot
+	if rf, ok := ret.Get(0).(func(context.Context, *model.PivotParams) *model.Pivot); ok {
+		r0 = rf(ctx, params)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Pivot)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *model.PivotParams) error); ok {
+		r1 = rf(ctx, params)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetRawAggregations provides a mock function with given fields: ctx, params
+func (_m *App) GetRawAggregations(ctx context.Context, params *model.RawAggsParams) (model.M, error) {
+	ret := _m.Called(ctx, params)
+
+	var r0 model.M
+	if rf, ok := ret.Get(0).(func(context.Context, *model.RawAggsParams) model.M); ok {
+		r0 = rf(ctx, params)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(model.M)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *model.RawAggsParams) error); ok {
+		r1 = rf(ctx, params)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetReindexTenantStatus provides a mock function with given fields: ctx, tid
+func (_m *App) GetReindexTenantStatus(ctx context.Context, tid string) (*model.Task, error) {
+	ret := _m.Called(ctx, tid)
+
+	var r0 *model.Task
+	if rf, ok := ret.Get(0).(func(context.Context, string) *model.Task); ok {
+		r0 = rf(ctx, tid)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Task)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, tid)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetSearchableInvAttrs provides a mock function with given fields: ctx, tid
 func (_m *App) GetSearchableInvAttrs(ctx context.Context, tid string) ([]model.InvFilterAttr, error) {
 	ret := _m.Called(ctx, tid)
@@ -50,6 +445,22 @@ func (_m *App) GetSearchableInvAttrs(ctx context.Context, tid string) ([]model.I
 	return r0, r1
 }
 
+// GetVersion provides a mock function with given fields: ctx
+func (_m *App) GetVersion(ctx context.Context) *model.Version {
+	ret := _m.Called(ctx)
+
+	var r0 *model.Version
+	if rf, ok := ret.Get(0).(func(context.Context) *model.Version); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Version)
+		}
+	}
+
+	return r0
+}
+
 // InventorySearchDevices provides a mock function with given fields: ctx, searchParams
 func (_m *App) InventorySearchDevices(ctx context.Context, searchParams *model.SearchParams) ([]model.InvDevice, int, error) {
 	ret := _m.Called(ctx, searchParams)
@@ -80,6 +491,45 @@ func (_m *App) InventorySearchDevices(ctx context.Context, searchParams *model.S
 	return r0, r1, r2
 }
 
+// InventorySearchDevicesInfo provides a mock function with given fields: ctx, searchParams
+func (_m *App) InventorySearchDevicesInfo(ctx context.Context, searchParams *model.SearchParams) ([]model.InvDevice, int, *model.SearchInfo, error) {
+	ret := _m.Called(ctx, searchParams)
+
+	var r0 []model.InvDevice
+	if rf, ok := ret.Get(0).(func(context.Context, *model.SearchParams) []model.InvDevice); ok {
+		r0 = rf(ctx, searchParams)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.InvDevice)
+		}
+	}
+
+	var r1 int
+	if rf, ok := ret.Get(1).(func(context.Context, *model.SearchParams) int); ok {
+		r1 = rf(ctx, searchParams)
+	} else {
+		r1 = ret.Get(1).(int)
+	}
+
+	var r2 *model.SearchInfo
+	if rf, ok := ret.Get(2).(func(context.Context, *model.SearchParams) *model.SearchInfo); ok {
+		r2 = rf(ctx, searchParams)
+	} else {
+		if ret.Get(2) != nil {
+			r2 = ret.Get(2).(*model.SearchInfo)
+		}
+	}
+
+	var r3 error
+	if rf, ok := ret.Get(3).(func(context.Context, *model.SearchParams) error); ok {
+		r3 = rf(ctx, searchParams)
+	} else {
+		r3 = ret.Error(3)
+	}
+
+	return r0, r1, r2, r3
+}
+
 // Reindex provides a mock function with given fields: ctx, tenantID, devID, service
 func (_m *App) Reindex(ctx context.Context, tenantID string, devID string, service string) error {
 	ret := _m.Called(ctx, tenantID, devID, service)
@@ -93,3 +543,137 @@ func (_m *App) Reindex(ctx context.Context, tenantID string, devID string, servi
 
 	return r0
 }
+
+// ReindexDevice provides a mock function with given fields: ctx, tenantID, devID, service
+func (_m *App) ReindexDevice(ctx context.Context, tenantID string, devID string, service string) (*model.ReindexResult, error) {
+	ret := _m.Called(ctx, tenantID, devID, service)
+
+	var r0 *model.ReindexResult
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, string) *model.ReindexResult); ok {
+		r0 = rf(ctx, tenantID, devID, service)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.ReindexResult)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, string) error); ok {
+		r1 = rf(ctx, tenantID, devID, service)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ReindexTenant provides a mock function with given fields: ctx, tid
+func (_m *App) ReindexTenant(ctx context.Context, tid string) (string, error) {
+	ret := _m.Called(ctx, tid)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(context.Context, string) string); ok {
+		r0 = rf(ctx, tid)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, tid)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ReindexTenantSince provides a mock function with given fields: ctx, tid, since
+func (_m *App) ReindexTenantSince(ctx context.Context, tid string, since time.Time) (int, error) {
+	ret := _m.Called(ctx, tid, since)
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Time) int); ok {
+		r0 = rf(ctx, tid, since)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, time.Time) error); ok {
+		r1 = rf(ctx, tid, since)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RenameAttribute provides a mock function with given fields: ctx, params
+func (_m *App) RenameAttribute(ctx context.Context, params *model.RenameAttributeParams) (*model.Task, error) {
+	ret := _m.Called(ctx, params)
+
+	var r0 *model.Task
+	if rf, ok := ret.Get(0).(func(context.Context, *model.RenameAttributeParams) *model.Task); ok {
+		r0 = rf(ctx, params)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Task)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *model.RenameAttributeParams) error); ok {
+		r1 = rf(ctx, params)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ScanDevices provides a mock function with given fields: ctx, params
+func (_m *App) ScanDevices(ctx context.Context, params *model.ScanParams) (*model.ScanPage, error) {
+	ret := _m.Called(ctx, params)
+
+	var r0 *model.ScanPage
+	if rf, ok := ret.Get(0).(func(context.Context, *model.ScanParams) *model.ScanPage); ok {
+		r0 = rf(ctx, params)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.ScanPage)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *model.ScanParams) error); ok {
+		r1 = rf(ctx, params)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ValidateSearch provides a mock function with given fields: ctx, searchParams
+func (_m *App) ValidateSearch(ctx context.Context, searchParams *model.SearchParams) (*model.QueryValidation, error) {
+	ret := _m.Called(ctx, searchParams)
+
+	var r0 *model.QueryValidation
+	if rf, ok := ret.Get(0).(func(context.Context, *model.SearchParams) *model.QueryValidation); ok {
+		r0 = rf(ctx, searchParams)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.QueryValidation)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *model.SearchParams) error); ok {
+		r1 = rf(ctx, searchParams)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
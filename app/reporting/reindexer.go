@@ -17,12 +17,14 @@ import (
 	"context"
 	"errors"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/panjf2000/ants/v2"
 
 	"github.com/mendersoftware/go-lib-micro/log"
 
+	"github.com/mendersoftware/reporting/app/indexer"
 	"github.com/mendersoftware/reporting/client/inventory"
 	"github.com/mendersoftware/reporting/model"
 	"github.com/mendersoftware/reporting/store"
@@ -31,7 +33,8 @@ import (
 var (
 	l = log.New(nil)
 
-	ErrReindexChannelFull = errors.New("reindex input channel is full")
+	ErrReindexChannelFull     = errors.New("reindex input channel is full")
+	ErrReindexTenantQueueFull = errors.New("reindex queue of the tenant is full")
 )
 
 type reindexReq struct {
@@ -43,6 +46,9 @@ type reindexReq struct {
 type Reindexer interface {
 	Run() error
 	Handle(r reindexReq) error
+	// useSources sets the registry resolving the requests' services
+	// to the clients the devices are fetched from
+	useSources(sources map[string]SourceClient)
 }
 
 type reindexer struct {
@@ -50,13 +56,39 @@ type reindexer struct {
 	store     store.Store
 	inventory inventory.Client
 	conf      *ReindexerConfig
+	sources   map[string]SourceClient
+
+	// requests buffered but not yet picked up for processing,
+	// used to coalesce duplicates arriving close together
+	pending map[string]struct{}
+	// number of requests of each tenant buffered but not yet
+	// picked up for processing, bounded by the input buffer length
+	queued    map[string]int
+	pendingMu sync.Mutex
+
+	limiter *indexer.TenantLimiter
+	latency *indexer.LatencyMonitor
 }
 
+// ReindexerConfig configures the reindex pipeline:
+// BuffLen bounds the input queue and the requests queued by each tenant
+// (Handle fails fast when either is full),
+// NumWorkers caps the number of concurrent bulk requests to ES,
+// TenantRate/TenantBurst/TenantQuotas throttle the requests of each tenant
+// (see indexer.TenantLimiter), SlowBatchThreshold is the indexing latency
+// of a batch beyond which it's reported (see indexer.LatencyMonitor)
 type ReindexerConfig struct {
-	NumWorkers  int
-	BatchSize   int
-	MaxTimeMsec int
-	BuffLen     int
+	NumWorkers   int
+	BatchSize    int
+	MaxTimeMsec  int
+	BuffLen      int
+	TenantRate   float64
+	TenantBurst  int
+	TenantQuotas map[string]float64
+	// BulkIndexer sends the updates through the store's concurrent
+	// bulk indexer, instead of a single bulk request per batch
+	BulkIndexer        bool
+	SlowBatchThreshold time.Duration
 }
 
 func NewReindexer(conf *ReindexerConfig, client inventory.Client, store store.Store) *reindexer {
@@ -64,6 +96,15 @@ func NewReindexer(conf *ReindexerConfig, client inventory.Client, store store.St
 		inventory: client,
 		store:     store,
 		conf:      conf,
+		sources:   defaultSources(client),
+		pending:   map[string]struct{}{},
+		queued:    map[string]int{},
+		limiter: indexer.NewTenantLimiter(
+			conf.TenantRate,
+			conf.TenantBurst,
+			conf.TenantQuotas,
+		),
+		latency: indexer.NewLatencyMonitor(conf.SlowBatchThreshold),
 	}
 }
 
@@ -72,18 +113,37 @@ func (ri *reindexer) Run() error {
 	c1 := buffer(ri.conf.BuffLen)
 	ri.inChan = c1
 
-	c2 := batch(c1, ri.conf.BatchSize, ri.conf.MaxTimeMsec)
-	c3 := squash(c2)
-	c4 := fetch(c3, ri.inventory, ri.store)
+	throttled := throttle(c1, ri.limiter)
+	c2 := batch(throttled, ri.conf.BatchSize, ri.conf.MaxTimeMsec)
+	c3 := squash(c2, ri.release)
+	c4 := fetch(c3, ri.source, ri.inventory, ri.store)
 	c5 := merge_updates(c4)
-	err := update(c5, ri.store, ri.conf.NumWorkers)
+	err := update(c5, ri.store, ri.conf.NumWorkers, ri.conf.BulkIndexer, ri.latency)
 	return err
 }
 
 func (ri *reindexer) Handle(r reindexReq) error {
 	l.Debug("reindexer.Handle")
+
+	ri.pendingMu.Lock()
+	defer ri.pendingMu.Unlock()
+
+	k := r.key()
+	if _, ok := ri.pending[k]; ok {
+		l.Debugf("reindexer.Handle coalesced request %v", r)
+		return nil
+	}
+
+	// the requests of a tenant flooding the reindexer are refused
+	// once its queue is full, the other tenants' still get through
+	if ri.queued[r.Tenant] >= ri.conf.BuffLen {
+		return ErrReindexTenantQueueFull
+	}
+
 	select {
 	case ri.inChan <- r:
+		ri.pending[k] = struct{}{}
+		ri.queued[r.Tenant]++
 		l.Debugf("reindexer.Handle buffered request, chan len %v", len(ri.inChan))
 		return nil
 	default:
@@ -91,6 +151,34 @@ func (ri *reindexer) Handle(r reindexReq) error {
 	}
 }
 
+func (ri *reindexer) useSources(sources map[string]SourceClient) {
+	ri.sources = sources
+}
+
+// source resolves the service name to its registered client
+func (ri *reindexer) source(service string) (SourceClient, bool) {
+	client, ok := ri.sources[service]
+	return client, ok
+}
+
+// release forgets about the pending requests once they're picked up for processing,
+// any new request for the same devices will be queued again
+func (ri *reindexer) release(batch []reindexReq) {
+	ri.pendingMu.Lock()
+	defer ri.pendingMu.Unlock()
+
+	for _, r := range batch {
+		delete(ri.pending, r.key())
+		if ri.queued[r.Tenant]--; ri.queued[r.Tenant] <= 0 {
+			delete(ri.queued, r.Tenant)
+		}
+	}
+}
+
+func (r reindexReq) key() string {
+	return r.Tenant + ":" + r.Device + ":" + strings.Join(r.Services, ",")
+}
+
 // buffer simply creates the input buffer
 func buffer(length int) chan reindexReq {
 	l.Debug("spawning buffer() stage")
@@ -99,6 +187,74 @@ func buffer(length int) chan reindexReq {
 	return out
 }
 
+// throttledReq is a request delayed by the tenant's indexing quota
+type throttledReq struct {
+	req reindexReq
+	at  time.Time
+}
+
+// throttle delays the requests of the tenants exceeding their indexing quota,
+// while the requests of the other tenants flow through undelayed; the delayed
+// requests are queued by tenant, in order, and the stage keeps reading the
+// input meanwhile (Handle bounds the requests queued by each tenant)
+func throttle(inchan chan reindexReq, limiter *indexer.TenantLimiter) chan reindexReq {
+	l.Debug("spawning throttle() stage")
+	out := make(chan reindexReq)
+
+	go func() {
+		queues := map[string][]throttledReq{}
+		for {
+			// the requests are sent in order within each tenant,
+			// the earliest due first across tenants
+			var next *throttledReq
+			for _, q := range queues {
+				if next == nil || q[0].at.Before(next.at) {
+					next = &q[0]
+				}
+			}
+
+			var (
+				send  chan reindexReq
+				head  reindexReq
+				due   <-chan time.Time
+				timer *time.Timer
+			)
+			if next != nil {
+				head = next.req
+				if wait := time.Until(next.at); wait > 0 {
+					timer = time.NewTimer(wait)
+					due = timer.C
+				} else {
+					send = out
+				}
+			}
+
+			select {
+			case r, ok := <-inchan:
+				if !ok {
+					inchan = nil
+					break
+				}
+				queues[r.Tenant] = append(queues[r.Tenant], throttledReq{
+					req: r,
+					at:  time.Now().Add(limiter.Reserve(r.Tenant)),
+				})
+			case send <- head:
+				tenant := head.Tenant
+				queues[tenant] = queues[tenant][1:]
+				if len(queues[tenant]) == 0 {
+					delete(queues, tenant)
+				}
+			case <-due:
+			}
+			if timer != nil {
+				timer.Stop()
+			}
+		}
+	}()
+	return out
+}
+
 // batch groups incoming reindex requests into batches
 func batch(inchan chan reindexReq, batchSize int, maxMsec int) chan []reindexReq {
 	l.Debug("spawning batch() stage")
@@ -138,7 +294,7 @@ func batch(inchan chan reindexReq, batchSize int, maxMsec int) chan []reindexReq
 
 // squash squashes reindex requests for a device from individual services into a single one
 // it will save us some ES io at the final bulk update stage
-func squash(inchan chan []reindexReq) chan []reindexReq {
+func squash(inchan chan []reindexReq, release func([]reindexReq)) chan []reindexReq {
 	l.Debug("spawning squash() stage")
 	out := make(chan []reindexReq)
 
@@ -146,6 +302,7 @@ func squash(inchan chan []reindexReq) chan []reindexReq {
 		defer close(out)
 		for batch := range inchan {
 			l.Debugf("squash recv %v\n", batch)
+			release(batch)
 			squashed := []reindexReq{}
 
 			//map tid:did:services
@@ -187,8 +344,14 @@ func squash(inchan chan []reindexReq) chan []reindexReq {
 }
 
 // fetch pulls all the representations of a given device from service APIs within the reindexRequest
-// for subsequent merging/update preparation
-func fetch(inchan chan []reindexReq, client inventory.Client, store store.Store) chan []mergeJob {
+// for subsequent merging/update preparation; the services are resolved to their source clients,
+// the devices of the inventory backed ones are fetched in one go per tenant
+func fetch(
+	inchan chan []reindexReq,
+	sources func(service string) (SourceClient, bool),
+	client inventory.Client,
+	store store.Store,
+) chan []mergeJob {
 	l.Debug("spawning fetch() stage")
 	out := make(chan []mergeJob)
 
@@ -204,6 +367,7 @@ func fetch(inchan chan []reindexReq, client inventory.Client, store store.Store)
 			// tenant by tenant,so do that
 			// (most popular convention in our APIs)
 			tenantDevs := map[string][]string{}
+			invTenantDevs := map[string][]string{}
 
 			for _, r := range batch {
 				j := mergeJob{
@@ -211,10 +375,19 @@ func fetch(inchan chan []reindexReq, client inventory.Client, store store.Store)
 					Device:  r.Device,
 					Index:   store.GetDevicesIndex(r.Tenant),
 					Routing: store.GetDevicesRoutingKey(r.Tenant),
-					// we know we can only have inventory for now
-					// later, find out which sources asked for reindex
+					// empty if the attribute history is disabled
+					HistoryIndex: store.GetHistoryIndex(r.Tenant),
+					Services:     r.Services,
 					SrcInventory: &mergeSrcInventory{},
-					SrcElastic:   &mergeSrcElastic{},
+					SrcServices: &mergeSrcServices{
+						devices: map[string]*model.Device{},
+					},
+					SrcElastic: &mergeSrcElastic{},
+				}
+
+				fetchInv, ok := fetchSources(&j, sources)
+				if !ok {
+					continue
 				}
 
 				// preinit output jobs
@@ -225,20 +398,23 @@ func fetch(inchan chan []reindexReq, client inventory.Client, store store.Store)
 				jobs[r.Tenant][r.Device] = j
 
 				// and prep the per tenant device lookup
-				if devs, ok := tenantDevs[r.Tenant]; !ok {
-					tenantDevs[r.Tenant] = []string{r.Device}
-				} else {
-					tenantDevs[r.Tenant] = append(devs, r.Device)
+				tenantDevs[r.Tenant] = append(tenantDevs[r.Tenant], r.Device)
+				if fetchInv {
+					invTenantDevs[r.Tenant] = append(
+						invTenantDevs[r.Tenant], r.Device)
 				}
 			}
+			if len(jobs) == 0 {
+				continue
+			}
 
 			// TODO async scatter/gather?
-			for tenant, devs := range tenantDevs {
+			for tenant, devs := range invTenantDevs {
 				invDevs, err := client.GetDevices(context.TODO(), tenant, devs)
 				if err != nil {
 					l.Debugf("fetch inventory error %v for devs %v",
 						err,
-						tenantDevs)
+						invTenantDevs)
 					continue
 				} else {
 					l.Debugf("fetch inventory got devs %v \n", invDevs)
@@ -260,8 +436,9 @@ func fetch(inchan chan []reindexReq, client inventory.Client, store store.Store)
 				l.Debugf("fetch elastic got devs %+#v \n", esDevs)
 			}
 
-			for _, d := range esDevs {
-				jobs[*d.TenantID][*d.ID].SrcElastic.device = &d
+			for i := range esDevs {
+				d := &esDevs[i]
+				jobs[*d.TenantID][*d.ID].SrcElastic.device = d
 			}
 
 			// flatten the list of merge jobs
@@ -279,6 +456,40 @@ func fetch(inchan chan []reindexReq, client inventory.Client, store store.Store)
 	return out
 }
 
+// fetchSources fetches the device from the job's services, except the
+// inventory backed ones, fetched in bulk by the caller: fetchInv is true
+// if they're among the services; ok is false if none of the services
+// could be fetched from, so that the device isn't deleted for lack of a source
+func fetchSources(
+	j *mergeJob,
+	sources func(service string) (SourceClient, bool),
+) (fetchInv bool, ok bool) {
+	for _, service := range j.Services {
+		source, found := sources(service)
+		if !found {
+			l.Warnf("reindex of dev %v:%v skipped for unknown service %v",
+				j.Tenant, j.Device, service)
+			continue
+		}
+		if _, isInv := source.(*inventorySource); isInv {
+			fetchInv = true
+			continue
+		}
+
+		dev, err := source.FetchDevice(context.TODO(), j.Tenant, j.Device)
+		if errors.Is(err, ErrSourceDeviceNotFound) {
+			dev = nil
+		} else if err != nil {
+			l.Errorf("fetch %v error %v for dev %v:%v",
+				service, err, j.Tenant, j.Device)
+			return false, false
+		}
+		j.SrcServices.devices[service] = dev
+		ok = true
+	}
+	return fetchInv, ok || fetchInv
+}
+
 // mergeJob aggregates all the fetched representations of a device
 // (inventory API + other service APIs + ES)
 // if a representation is null - service didn't ask for an update
@@ -287,14 +498,24 @@ type mergeJob struct {
 	Device       string
 	Index        string
 	Routing      string
+	HistoryIndex string
+	// Services asked for the reindex; only their scopes are replaced
+	Services     []string
 	SrcInventory *mergeSrcInventory
-	SrcElastic   *mergeSrcElastic
+	// SrcServices are the devices fetched from the other source services
+	SrcServices *mergeSrcServices
+	SrcElastic  *mergeSrcElastic
 }
 
 type mergeSrcInventory struct {
 	device *model.InvDevice
 }
 
+// mergeSrcServices holds the devices by service, nil if not found
+type mergeSrcServices struct {
+	devices map[string]*model.Device
+}
+
 type mergeSrcElastic struct {
 	device *model.Device
 }
@@ -318,6 +539,7 @@ func merge_updates(inchan chan []mergeJob) chan []store.BulkItem {
 			for _, job := range batch {
 				item, _ := merge(&job)
 				bulkItems = append(bulkItems, *item)
+				bulkItems = append(bulkItems, history(&job, item)...)
 			}
 
 			out <- bulkItems
@@ -327,9 +549,9 @@ func merge_updates(inchan chan []mergeJob) chan []store.BulkItem {
 }
 
 // merge merges all the update sources into an update object
-// for now it's just inventory
 func merge(j *mergeJob) (*store.BulkItem, error) {
 	now := time.Now()
+	newdev := j.sourceDevice()
 
 	action := &store.BulkAction{
 		Desc: &store.BulkActionDesc{
@@ -345,7 +567,7 @@ func merge(j *mergeJob) (*store.BulkItem, error) {
 	}
 
 	switch {
-	case j.SrcInventory.device == nil:
+	case newdev == nil:
 		item.Action.Type = "delete"
 
 		if j.SrcElastic.device != nil {
@@ -354,15 +576,19 @@ func merge(j *mergeJob) (*store.BulkItem, error) {
 			item.Action.Desc.IfPrimaryTerm = j.SrcElastic.device.Meta.PrimaryTerm
 		}
 	case j.SrcElastic.device == nil:
-		newdev, _ := model.NewDeviceFromInv(j.Tenant, j.SrcInventory.device)
-
 		newdev.SetCreatedAt(now)
 		newdev.SetUpdatedAt(now)
 		item.Doc = newdev
 		item.Action.Type = "create"
 
 	default:
-		newdev, _ := model.NewDeviceFromInv(j.Tenant, j.SrcInventory.device)
+		// merge the services' scopes into the indexed device,
+		// preserving the scopes owned by the other services
+		if scopes, ok := servicesScopes(j.Services); ok {
+			merged := j.SrcElastic.device.Copy()
+			merged.MergeScopes(newdev, scopes)
+			newdev = merged
+		}
 
 		newdev.SetUpdatedAt(now)
 
@@ -377,10 +603,78 @@ func merge(j *mergeJob) (*store.BulkItem, error) {
 	return item, nil
 }
 
+// sourceDevice returns the device as fetched from the services asking for
+// the reindex, nil if none of them knows about it; the devices fetched from
+// several services are merged by the scopes they own
+func (j *mergeJob) sourceDevice() *model.Device {
+	var dev *model.Device
+	if j.SrcInventory != nil && j.SrcInventory.device != nil {
+		dev, _ = model.NewDeviceFromInv(j.Tenant, j.SrcInventory.device)
+	}
+	if j.SrcServices == nil {
+		return dev
+	}
+	for _, service := range j.Services {
+		src := j.SrcServices.devices[service]
+		if src == nil {
+			continue
+		}
+		scopes, ok := servicesScopes([]string{service})
+		if dev == nil || !ok {
+			dev = src
+			continue
+		}
+		dev.MergeScopes(src, scopes)
+	}
+	return dev
+}
+
+// history returns the bulk items appending the attributes changed by
+// the update item to the history index, if enabled; the items are sent
+// along with the update, so they're recorded even if the update fails
+// and is retried later
+func history(j *mergeJob, item *store.BulkItem) []store.BulkItem {
+	if j.HistoryIndex == "" {
+		return nil
+	}
+	newdev, ok := item.Doc.(*model.Device)
+	if !ok {
+		// deleted device
+		return nil
+	}
+
+	changes := model.AttributeChanges(
+		j.SrcElastic.device, newdev, newdev.GetUpdatedAt(),
+	)
+	items := make([]store.BulkItem, 0, len(changes))
+	for i := range changes {
+		items = append(items, store.BulkItem{
+			Action: &store.BulkAction{
+				Type: "create",
+				Desc: &store.BulkActionDesc{
+					Index:   j.HistoryIndex,
+					Routing: j.Routing,
+					Tenant:  j.Tenant,
+				},
+			},
+			Doc: &changes[i],
+		})
+	}
+	return items
+}
+
 // bulk executes bulk update jobs for a device batch
-func update(inchan chan []store.BulkItem, store store.Store, numWorkers int) error {
+func update(
+	inchan chan []store.BulkItem,
+	store store.Store,
+	numWorkers int,
+	bulkIndexer bool,
+	latency *indexer.LatencyMonitor,
+) error {
 	l.Debug("spawning update() stage")
 
+	// Submit blocks when all the workers are busy, which stalls
+	// the pipeline and eventually fills up the input buffer
 	p, err := ants.NewPool(numWorkers)
 	if err != nil {
 		return err
@@ -391,18 +685,13 @@ func update(inchan chan []store.BulkItem, store store.Store, numWorkers int) err
 			l.Debugf("update recv %v\n", bulkItems)
 
 			err := p.Submit(func() {
-				res, err := store.BulkRaw(context.TODO(), bulkItems)
-				if err != nil {
-					l.Errorf("BulkRaw failed for bulkItems %v with error %v",
-						bulkItems,
-						err)
+				start := time.Now()
+				if bulkIndexer {
+					bulkIndex(store, bulkItems)
+				} else {
+					bulkRaw(store, bulkItems)
 				}
-
-				l.Debugf("bulk response %v", res)
-
-				// inspect the bulk response and at least emit warnings
-				// (future: requeue conflicting devices?)
-				handleBulkResponse(res)
+				latency.Observe(context.TODO(), bulkItems, time.Since(start))
 			})
 			if err != nil {
 				l.Errorf("failed to submit bulk update to pool %v\n", bulkItems)
@@ -413,6 +702,40 @@ func update(inchan chan []store.BulkItem, store store.Store, numWorkers int) err
 	return nil
 }
 
+// bulkRaw sends the items in a single bulk request,
+// and emits warnings for the failed ones
+func bulkRaw(st store.Store, bulkItems []store.BulkItem) {
+	res, err := st.BulkRaw(context.TODO(), bulkItems)
+	if err != nil {
+		l.Errorf("BulkRaw failed for bulkItems %v with error %v",
+			bulkItems,
+			err)
+	}
+
+	l.Debugf("bulk response %v", res)
+
+	// inspect the bulk response and at least emit warnings
+	// (future: requeue conflicting devices?)
+	handleBulkResponse(res)
+}
+
+// bulkIndex sends the items through the store's concurrent bulk indexer,
+// and emits warnings for the failed ones
+func bulkIndex(st store.Store, bulkItems []store.BulkItem) {
+	failed, err := st.BulkIndex(context.TODO(), bulkItems)
+	if err != nil {
+		l.Errorf("BulkIndex failed for bulkItems %v with error %v",
+			bulkItems,
+			err)
+	}
+	for _, itemErr := range failed {
+		l.Warnf("bulk update failed for dev %v:%v, %v\n",
+			itemErr.Item.Action.Desc.ID,
+			itemErr.Item.Action.Desc.Index,
+			itemErr)
+	}
+}
+
 func handleBulkResponse(res map[string]interface{}) {
 	hasErrs := res["errors"].(bool)
 	l.Debugf("bulk response hasErrs %v", hasErrs)
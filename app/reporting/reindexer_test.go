@@ -0,0 +1,545 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package reporting
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/reporting/app/indexer"
+	"github.com/mendersoftware/reporting/client/inventory"
+	minventory "github.com/mendersoftware/reporting/client/inventory/mocks"
+	"github.com/mendersoftware/reporting/model"
+	"github.com/mendersoftware/reporting/store"
+	mstore "github.com/mendersoftware/reporting/store/mocks"
+)
+
+func TestReindexerHandleCoalesce(t *testing.T) {
+	ri := NewReindexer(&ReindexerConfig{BuffLen: 2}, nil, nil)
+	ri.inChan = buffer(ri.conf.BuffLen)
+
+	dev1 := reindexReq{Tenant: "t1", Device: "d1", Services: []string{SvcInventory}}
+	dev2 := reindexReq{Tenant: "t1", Device: "d2", Services: []string{SvcInventory}}
+	dev3 := reindexReq{Tenant: "t2", Device: "d1", Services: []string{SvcInventory}}
+
+	// duplicates are coalesced while pending
+	assert.NoError(t, ri.Handle(dev1))
+	assert.NoError(t, ri.Handle(dev1))
+	assert.NoError(t, ri.Handle(dev2))
+	assert.Len(t, ri.inChan, 2)
+
+	// queue is full
+	assert.Equal(t, ErrReindexChannelFull, ri.Handle(dev3))
+
+	// but duplicates are still accepted
+	assert.NoError(t, ri.Handle(dev2))
+
+	// once picked up for processing, requests are queued again
+	batch := []reindexReq{<-ri.inChan, <-ri.inChan}
+	ri.release(batch)
+
+	assert.NoError(t, ri.Handle(dev1))
+	assert.NoError(t, ri.Handle(dev3))
+	assert.Len(t, ri.inChan, 2)
+}
+
+func TestReindexerUpdateConcurrency(t *testing.T) {
+	const (
+		numWorkers = 2
+		numBatches = 6
+	)
+
+	var (
+		mu        sync.Mutex
+		running   int
+		maxActive int
+		done      sync.WaitGroup
+	)
+	unblock := make(chan struct{})
+
+	st := new(mstore.Store)
+	st.On("BulkRaw", mock.Anything, mock.AnythingOfType("[]store.BulkItem")).
+		Run(func(args mock.Arguments) {
+			mu.Lock()
+			running++
+			if running > maxActive {
+				maxActive = running
+			}
+			mu.Unlock()
+
+			<-unblock
+
+			mu.Lock()
+			running--
+			mu.Unlock()
+			done.Done()
+		}).
+		Return(map[string]interface{}{"errors": false}, nil)
+
+	in := make(chan []store.BulkItem)
+	err := update(in, st, numWorkers, false, indexer.NewLatencyMonitor(0))
+	assert.NoError(t, err)
+
+	done.Add(numBatches)
+	go func() {
+		for i := 0; i < numBatches; i++ {
+			in <- []store.BulkItem{}
+		}
+	}()
+
+	// let the pool saturate
+	time.Sleep(100 * time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, numWorkers, running)
+	mu.Unlock()
+
+	close(unblock)
+	done.Wait()
+
+	assert.Equal(t, numWorkers, maxActive)
+	st.AssertNumberOfCalls(t, "BulkRaw", numBatches)
+}
+
+func TestReindexerUpdateBulkIndexer(t *testing.T) {
+	items := []store.BulkItem{{
+		Action: &store.BulkAction{
+			Type: "index",
+			Desc: &store.BulkActionDesc{ID: "dev1", Index: "devices", Routing: "t1"},
+		},
+		Doc: model.NewDevice("dev1").SetTenantID("t1"),
+	}}
+
+	done := make(chan struct{})
+	st := new(mstore.Store)
+	st.On("BulkIndex", mock.Anything, items).
+		Run(func(args mock.Arguments) { close(done) }).
+		Return([]store.BulkItemError{{Item: items[0], Status: 409}}, nil)
+	defer st.AssertExpectations(t)
+
+	in := make(chan []store.BulkItem, 1)
+	err := update(in, st, 1, true, indexer.NewLatencyMonitor(0))
+	assert.NoError(t, err)
+
+	in <- items
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the items didn't go through the bulk indexer")
+	}
+}
+
+func TestReindexerThrottle(t *testing.T) {
+	limiter := indexer.NewTenantLimiter(1, 1, nil)
+	in := make(chan reindexReq, 4)
+	out := throttle(in, limiter)
+
+	in <- reindexReq{Tenant: "t1", Device: "d1"}
+	in <- reindexReq{Tenant: "t1", Device: "d2"}
+	in <- reindexReq{Tenant: "t2", Device: "d1"}
+
+	// t1's second request is over quota and delayed,
+	// but doesn't hold back t2
+	assert.Equal(t, reindexReq{Tenant: "t1", Device: "d1"}, <-out)
+	assert.Equal(t, reindexReq{Tenant: "t2", Device: "d1"}, <-out)
+
+	select {
+	case r := <-out:
+		t.Fatalf("unexpected request, should be throttled: %v", r)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	select {
+	case r := <-out:
+		assert.Equal(t, reindexReq{Tenant: "t1", Device: "d2"}, r)
+	case <-time.After(2 * time.Second):
+		t.Fatal("throttled request was dropped")
+	}
+}
+
+func TestReindexerThrottleQueue(t *testing.T) {
+	limiter := indexer.NewTenantLimiter(10, 1, nil)
+	in := make(chan reindexReq, 4)
+	out := throttle(in, limiter)
+
+	devs := []string{"d1", "d2", "d3", "d4"}
+	for _, dev := range devs {
+		in <- reindexReq{Tenant: "t1", Device: dev}
+	}
+
+	// the throttled requests are queued, the input is drained
+	assert.Eventually(t, func() bool {
+		return len(in) == 0
+	}, time.Second, 10*time.Millisecond)
+
+	// and they keep their order
+	for _, dev := range devs {
+		select {
+		case r := <-out:
+			assert.Equal(t, reindexReq{Tenant: "t1", Device: dev}, r)
+		case <-time.After(time.Second):
+			t.Fatal("throttled request was dropped")
+		}
+	}
+}
+
+func TestReindexerHandleTenantQueue(t *testing.T) {
+	ri := NewReindexer(&ReindexerConfig{
+		BuffLen:      2,
+		TenantBurst:  1,
+		TenantQuotas: map[string]float64{"t1": 0.01},
+	}, nil, nil)
+	ri.inChan = buffer(ri.conf.BuffLen)
+	out := throttle(ri.inChan, ri.limiter)
+
+	// t1 floods the reindexer: its first request goes through,
+	// the next ones are throttled until its queue is full
+	assert.NoError(t, ri.Handle(reindexReq{Tenant: "t1", Device: "d1"}))
+	assert.Equal(t, reindexReq{Tenant: "t1", Device: "d1"}, <-out)
+	ri.release([]reindexReq{{Tenant: "t1", Device: "d1"}})
+
+	assert.NoError(t, ri.Handle(reindexReq{Tenant: "t1", Device: "d2"}))
+	assert.NoError(t, ri.Handle(reindexReq{Tenant: "t1", Device: "d3"}))
+	assert.Equal(t, ErrReindexTenantQueueFull,
+		ri.Handle(reindexReq{Tenant: "t1", Device: "d4"}))
+
+	// the other tenants' requests still flow, beyond the input buffer length
+	for _, dev := range []string{"d1", "d2", "d3", "d4", "d5"} {
+		r := reindexReq{Tenant: "t2", Device: dev}
+		assert.NoError(t, ri.Handle(r))
+		select {
+		case got := <-out:
+			assert.Equal(t, r, got)
+		case <-time.After(time.Second):
+			t.Fatalf("request of another tenant was held back: %v", r)
+		}
+		ri.release([]reindexReq{r})
+	}
+
+	// while t1 is still refused
+	assert.Equal(t, ErrReindexTenantQueueFull,
+		ri.Handle(reindexReq{Tenant: "t1", Device: "d4"}))
+}
+
+func TestReindexTenantSince(t *testing.T) {
+	const tenantID = "tenant1"
+	since := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+	ts := func(sec int) time.Time {
+		return since.Add(time.Duration(sec) * time.Second)
+	}
+	searchReq := func(page int, op, value string) *inventory.SearchReq {
+		return &inventory.SearchReq{
+			Page:    page,
+			PerPage: 2,
+			Filters: []model.FilterPredicate{{
+				Scope:     "system",
+				Attribute: "updated_ts",
+				Type:      op,
+				Value:     value,
+			}},
+			Sort: []model.SortCriteria{{
+				Scope:     "system",
+				Attribute: "updated_ts",
+				Order:     "asc",
+			}},
+		}
+	}
+
+	dev1 := model.InvDevice{ID: "dev1", UpdatedTs: ts(1)}
+	dev2 := model.InvDevice{ID: "dev2", UpdatedTs: ts(2)}
+	dev3 := model.InvDevice{ID: "dev3", UpdatedTs: ts(2)}
+	dev4 := model.InvDevice{ID: "dev4", UpdatedTs: ts(2)}
+	dev5 := model.InvDevice{ID: "dev5", UpdatedTs: ts(3)}
+	// dev1 is updated again while the reindex runs
+	dev1Updated := model.InvDevice{ID: "dev1", UpdatedTs: ts(4)}
+
+	inv := new(minventory.Client)
+	for _, page := range []struct {
+		req  *inventory.SearchReq
+		devs []model.InvDevice
+	}{
+		{searchReq(1, "$gt", "2021-10-01T12:00:00Z"), []model.InvDevice{dev1, dev2}},
+		// dev2 is skipped, then the page of devices updated at the
+		// same time is skipped with the offset
+		{searchReq(1, "$gte", "2021-10-01T12:00:02Z"), []model.InvDevice{dev2, dev3}},
+		{searchReq(2, "$gte", "2021-10-01T12:00:02Z"), []model.InvDevice{dev4, dev5}},
+		{searchReq(1, "$gte", "2021-10-01T12:00:03Z"), []model.InvDevice{dev5, dev1Updated}},
+		{searchReq(1, "$gte", "2021-10-01T12:00:04Z"), []model.InvDevice{dev1Updated}},
+	} {
+		inv.On("SearchDevices", contextMatcher, tenantID, page.req).
+			Return(page.devs, 0, nil).Once()
+	}
+	defer inv.AssertExpectations(t)
+
+	// dev1 is already indexed, the others are new
+	existing := model.NewDevice("dev1").
+		SetTenantID(tenantID).
+		WithMeta(&model.DeviceMeta{SeqNo: 3, PrimaryTerm: 1})
+
+	var batches [][]store.BulkItem
+	st := new(mstore.Store)
+	st.On("GetDevicesIndex", tenantID).Return("devices")
+	st.On("GetDevicesRoutingKey", tenantID).Return(tenantID)
+	st.On("GetHistoryIndex", tenantID).Return("")
+	st.On("GetDevices", contextMatcher, map[string][]string{tenantID: {"dev1", "dev2"}}).
+		Return([]model.Device{*existing}, nil).Once()
+	st.On("GetDevices", contextMatcher, map[string][]string{tenantID: {"dev3"}}).
+		Return([]model.Device{}, nil).Once()
+	st.On("GetDevices", contextMatcher, map[string][]string{tenantID: {"dev4", "dev5"}}).
+		Return([]model.Device{}, nil).Once()
+	st.On("GetDevices", contextMatcher, map[string][]string{tenantID: {"dev1"}}).
+		Return([]model.Device{*existing}, nil).Once()
+	st.On("BulkRaw", contextMatcher, mock.AnythingOfType("[]store.BulkItem")).
+		Run(func(args mock.Arguments) {
+			batches = append(batches, args.Get(1).([]store.BulkItem))
+		}).
+		Return(map[string]interface{}{"errors": false}, nil)
+	defer st.AssertExpectations(t)
+
+	app := NewApp(st, inv, nil).(*app)
+	app.reindexBatchSize = 2
+
+	count, err := app.ReindexTenantSince(context.Background(), tenantID, since)
+	assert.NoError(t, err)
+	assert.Equal(t, 6, count)
+
+	if assert.Len(t, batches, 4) {
+		assert.Len(t, batches[0], 2)
+		assert.Len(t, batches[1], 1)
+		assert.Len(t, batches[2], 2)
+		assert.Len(t, batches[3], 1)
+
+		assert.Equal(t, "index", batches[0][0].Action.Type)
+		assert.Equal(t, int64(3), batches[0][0].Action.Desc.IfSeqNo)
+		assert.Equal(t, "create", batches[0][1].Action.Type)
+		assert.Equal(t, "dev3", batches[1][0].Action.Desc.ID)
+		assert.Equal(t, "dev1", batches[3][0].Action.Desc.ID)
+	}
+}
+
+func TestReindexTenantSinceSuspendRefresh(t *testing.T) {
+	const tenantID = "tenant1"
+	since := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	var calls []string
+	record := func(name string) func(mock.Arguments) {
+		return func(mock.Arguments) {
+			calls = append(calls, name)
+		}
+	}
+
+	// the refresh is restored also when the reindex fails
+	inv := new(minventory.Client)
+	inv.On("SearchDevices", contextMatcher, tenantID, mock.Anything).
+		Run(record("SearchDevices")).
+		Return(nil, 0, errors.New("inventory unavailable")).Once()
+	defer inv.AssertExpectations(t)
+
+	st := new(mstore.Store)
+	st.On("SuspendRefresh", contextMatcher, tenantID).
+		Run(record("SuspendRefresh")).
+		Return(nil).Once()
+	st.On("RestoreRefresh", contextMatcher, tenantID).
+		Run(record("RestoreRefresh")).
+		Return(nil).Once()
+	defer st.AssertExpectations(t)
+
+	app := NewApp(st, inv, nil, WithReindexSuspendRefresh(true))
+	_, err := app.ReindexTenantSince(context.Background(), tenantID, since)
+	assert.EqualError(t, err, "inventory unavailable")
+	assert.Equal(t, []string{"SuspendRefresh", "SearchDevices", "RestoreRefresh"}, calls)
+}
+
+func TestReindexerMergeScopes(t *testing.T) {
+	const tenantID = "tenant1"
+
+	indexed, _ := model.NewDeviceFromInv(tenantID, &model.InvDevice{
+		ID: "dev1",
+		Attributes: model.DeviceAttributes{
+			{Scope: "identity", Name: "mac", Value: "00:11:22:33:44:55"},
+			{Scope: "identity", Name: "status", Value: "pending"},
+			{Scope: "inventory", Name: "os", Value: "linux"},
+			{Scope: "system", Name: "group", Value: "dev"},
+		},
+	})
+	indexed.WithMeta(&model.DeviceMeta{SeqNo: 3, PrimaryTerm: 1})
+
+	source := &model.InvDevice{
+		ID: "dev1",
+		Attributes: model.DeviceAttributes{
+			{Scope: "identity", Name: "mac", Value: "66:77:88:99:aa:bb"},
+			{Scope: "identity", Name: "status", Value: "accepted"},
+			{Scope: "inventory", Name: "os", Value: "debian"},
+			{Scope: "system", Name: "group", Value: "prod"},
+		},
+	}
+
+	testCases := map[string]struct {
+		services []string
+
+		identity  string
+		status    string
+		inventory string
+		group     string
+	}{
+		"identity, inventory preserved": {
+			services: []string{SvcDeviceauth},
+
+			identity:  "66:77:88:99:aa:bb",
+			status:    "accepted",
+			inventory: "linux",
+			group:     "dev",
+		},
+		"inventory, identity preserved": {
+			services: []string{SvcInventory},
+
+			identity:  "00:11:22:33:44:55",
+			status:    "pending",
+			inventory: "debian",
+			group:     "prod",
+		},
+		"all services": {
+			services: []string{SvcInventory, SvcDeviceauth},
+
+			identity:  "66:77:88:99:aa:bb",
+			status:    "accepted",
+			inventory: "debian",
+			group:     "prod",
+		},
+		"unknown services, replaced": {
+			identity:  "66:77:88:99:aa:bb",
+			status:    "accepted",
+			inventory: "debian",
+			group:     "prod",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			item, err := merge(&mergeJob{
+				Tenant:       tenantID,
+				Device:       "dev1",
+				Index:        "devices",
+				Routing:      tenantID,
+				Services:     tc.services,
+				SrcInventory: &mergeSrcInventory{device: source},
+				SrcElastic:   &mergeSrcElastic{device: indexed},
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, "index", item.Action.Type)
+			assert.Equal(t, int64(3), item.Action.Desc.IfSeqNo)
+
+			dev := item.Doc.(*model.Device)
+			assert.Equal(t, tc.identity, dev.IdentityAttributes[0].GetString())
+			assert.Equal(t, tc.status, dev.GetStatus())
+			assert.Equal(t, tc.inventory, dev.InventoryAttributes[0].GetString())
+			assert.Equal(t, tc.group, dev.GetGroupName())
+
+			// the indexed device is left untouched
+			assert.Equal(t, "00:11:22:33:44:55",
+				indexed.IdentityAttributes[0].GetString())
+			assert.Equal(t, "linux", indexed.InventoryAttributes[0].GetString())
+
+			// nor shares its attributes with the merged device, which the
+			// store may alter while indexing it
+			dev.IdentityAttributes[0].String[0] = "changed"
+			dev.InventoryAttributes[0].String[0] = "changed"
+			*dev.Status = "changed"
+			assert.Equal(t, "00:11:22:33:44:55",
+				indexed.IdentityAttributes[0].GetString())
+			assert.Equal(t, "linux", indexed.InventoryAttributes[0].GetString())
+			assert.Equal(t, "pending", indexed.GetStatus())
+		})
+	}
+}
+
+func TestReindexerHistory(t *testing.T) {
+	const tenantID = "tenant1"
+
+	old, _ := model.NewDeviceFromInv(tenantID, &model.InvDevice{
+		ID: "dev1",
+		Attributes: model.DeviceAttributes{
+			{Scope: "inventory", Name: "os", Value: "linux"},
+			{Scope: "inventory", Name: "kernel", Value: "5.10"},
+			{Scope: "inventory", Name: "cpu", Value: "arm"},
+		},
+	})
+	old.WithMeta(&model.DeviceMeta{SeqNo: 3, PrimaryTerm: 1})
+
+	job := mergeJob{
+		Tenant:       tenantID,
+		Device:       "dev1",
+		Index:        "devices",
+		Routing:      tenantID,
+		HistoryIndex: "devices-history",
+		SrcInventory: &mergeSrcInventory{device: &model.InvDevice{
+			ID: "dev1",
+			Attributes: model.DeviceAttributes{
+				{Scope: "inventory", Name: "os", Value: "linux"},
+				{Scope: "inventory", Name: "kernel", Value: "5.15"},
+				{Scope: "inventory", Name: "mem", Value: float64(512)},
+			},
+		}},
+		SrcElastic: &mergeSrcElastic{device: old},
+	}
+
+	out := merge_updates(func() chan []mergeJob {
+		in := make(chan []mergeJob, 1)
+		in <- []mergeJob{job}
+		close(in)
+		return in
+	}())
+	items := <-out
+
+	// the device update, followed by the changed attributes
+	if !assert.Len(t, items, 4) {
+		return
+	}
+	assert.Equal(t, "index", items[0].Action.Type)
+	ts := items[0].Doc.(*model.Device).GetUpdatedAt()
+
+	var entries []model.AttributeHistory
+	for _, item := range items[1:] {
+		assert.Equal(t, "create", item.Action.Type)
+		assert.Equal(t, "devices-history", item.Action.Desc.Index)
+		assert.Equal(t, tenantID, item.Action.Desc.Routing)
+		assert.Empty(t, item.Action.Desc.ID)
+		entries = append(entries, *item.Doc.(*model.AttributeHistory))
+	}
+	entry := func(name string, value interface{}) model.AttributeHistory {
+		return model.AttributeHistory{
+			TenantID:  tenantID,
+			DeviceID:  "dev1",
+			Scope:     "inventory",
+			Name:      name,
+			Value:     value,
+			Timestamp: ts,
+		}
+	}
+	assert.Equal(t, []model.AttributeHistory{
+		entry("cpu", nil),
+		entry("kernel", []string{"5.15"}),
+		entry("mem", []float64{512}),
+	}, entries)
+
+	// disabled history
+	job.HistoryIndex = ""
+	item, _ := merge(&job)
+	assert.Empty(t, history(&job, item))
+}
@@ -15,8 +15,13 @@ package reporting
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/mendersoftware/go-lib-micro/log"
 
@@ -28,43 +33,267 @@ import (
 const (
 	SvcInventory  = "inventory"
 	SvcDeviceauth = "deviceauth"
+
+	// reindexSinceBatchSize is the num of devices fetched from the inventory
+	// and bulk indexed together by ReindexTenantSince
+	reindexSinceBatchSize = 100
+
+	// exportBatchSize is the num of devices fetched per search by ExportDevices
+	exportBatchSize = 500
+
+	// ScanCursorTTLDefault is the default TTL of the scan cursors
+	ScanCursorTTLDefault = 10 * time.Minute
 )
 
 var (
-	knownServices = []string{SvcInventory, SvcDeviceauth}
-
 	ErrUnknownService = errors.New("unknown service name")
+	ErrDeviceNotFound = errors.New("device not found")
+
+	ErrReindexTaskNotFound = errors.New("no reindex task found for the tenant")
+	ErrReindexTaskRunning  = errors.New("a reindex task is already running for the tenant")
+
+	ErrTooManyBuckets  = store.ErrTooManyBuckets
+	ErrHistoryDisabled = store.ErrHistoryDisabled
+	ErrInvalidQuery    = store.ErrInvalidQuery
+
+	ErrScanCursorExpired = errors.New("the scan cursor expired")
+	ErrTooManySearches   = errors.New("too many concurrent searches")
 )
 
 //nolint:lll
 //go:generate ../../x/mockgen.sh
 type App interface {
+	BulkGetDevices(ctx context.Context, params model.BulkGetParams) (*model.BulkGetResult, error)
+	CancelReindexTenant(ctx context.Context, tid string) error
+	DeleteTenantDevices(ctx context.Context, tid string) (int, error)
+	DevicesExist(ctx context.Context, params *model.DevicesExistParams) (*model.DevicesExistResult, error)
+	ExportDevices(ctx context.Context, searchParams *model.SearchParams, emit func(*model.InvDevice) error) error
+	CompareDevices(ctx context.Context, params *model.CompareDevicesParams) (*model.DeviceComparison, error)
+	GetDevice(ctx context.Context, params *model.DeviceParams) (*model.InvDevice, error)
+	GetAttributeHistory(ctx context.Context, params *model.AttributeHistoryParams) ([]model.AttributeHistory, error)
+	GetDeviceChanges(ctx context.Context, params *model.ChangesParams) (*model.ChangesPage, error)
+	GetFacets(ctx context.Context, params *model.FacetsParams) (*model.Facets, error)
+	GetGroups(ctx context.Context, params *model.GroupsParams) ([]model.GroupCount, error)
+	GetPivot(ctx context.Context, params *model.PivotParams) (*model.Pivot, error)
+	GetRawAggregations(ctx context.Context, params *model.RawAggsParams) (model.M, error)
+	GetIPRanges(ctx context.Context, params *model.IPRangesParams) (*model.IPRanges, error)
+	GetGeohashGrid(ctx context.Context, params *model.GeohashGridParams) (*model.GeohashGrid, error)
+	GetReindexTenantStatus(ctx context.Context, tid string) (*model.Task, error)
 	GetSearchableInvAttrs(ctx context.Context, tid string) ([]model.InvFilterAttr, error)
+	GetIndexSettings(ctx context.Context, tid string) (*model.IndexSettings, error)
+	RenameAttribute(ctx context.Context, params *model.RenameAttributeParams) (*model.Task, error)
+	GetAttributesMetadata(ctx context.Context, tid string) ([]model.InvAttrMetadata, error)
+	GetVersion(ctx context.Context) *model.Version
 	InventorySearchDevices(ctx context.Context, searchParams *model.SearchParams) ([]model.InvDevice, int, error)
+	InventorySearchDevicesInfo(ctx context.Context, searchParams *model.SearchParams) ([]model.InvDevice, int, *model.SearchInfo, error)
 	Reindex(ctx context.Context, tenantID, devID string, service string) error
+	ReindexDevice(ctx context.Context, tenantID, devID string, service string) (*model.ReindexResult, error)
+	ReindexTenant(ctx context.Context, tid string) (string, error)
+	ReindexTenantSince(ctx context.Context, tid string, since time.Time) (int, error)
+	ScanDevices(ctx context.Context, params *model.ScanParams) (*model.ScanPage, error)
+	ValidateSearch(ctx context.Context, searchParams *model.SearchParams) (*model.QueryValidation, error)
 }
 
 type app struct {
 	store     store.Store
 	invClient inventory.Client
 	reindexer Reindexer
+
+	// reindex task ids, keyed by tenant
+	tasks   map[string]string
+	tasksMu sync.Mutex
+
+	reindexBatchSize int
+	exportBatchSize  int
+
+	// whether the index refresh is suspended during ReindexTenantSince
+	reindexSuspendRefresh bool
+
+	// how long a scan cursor stays valid after its page is returned
+	scanCursorTTL time.Duration
+
+	// max number of buckets requested by the aggregations (0: no limit)
+	maxBuckets int
+
+	// max number of values of a search $in/$nin clause (0: no limit)
+	maxClauseCount int
+
+	// custom sort orders of the attributes, see model.SortOrderKey
+	sortOrders map[string][]string
+
+	// bounds of the complexity of the search queries
+	complexityLimits model.ComplexityLimits
+
+	// semaphore bounding the in-flight searches, nil if unlimited
+	searches chan struct{}
+
+	// source service clients, keyed by service name
+	sources map[string]SourceClient
+
+	// display metadata of the attributes, see model.AttributeMetadataKey
+	attrMetadata map[string]model.AttributeMetadata
+
+	// cached versions, see GetVersion
+	version       *model.Version
+	versionExpiry time.Time
+	versionMu     sync.Mutex
+}
+
+type AppOption func(*app)
+
+// WithMaxBuckets caps the number of buckets any aggregation can request;
+// larger sizes are clamped to max
+func WithMaxBuckets(max int) AppOption {
+	return func(app *app) {
+		app.maxBuckets = max
+	}
+}
+
+// WithMaxClauseCount splits the $in and $nin filters of the searches with
+// more than max values in several clauses, and rejects the ones needing more
+// than max clauses with model.ErrTooManyValues
+func WithMaxClauseCount(max int) AppOption {
+	return func(app *app) {
+		app.maxClauseCount = max
+	}
+}
+
+// WithComplexityLimits rejects the searches whose compiled query exceeds
+// the limits with model.ErrQueryTooComplex, before they're sent to ES
+func WithComplexityLimits(limits model.ComplexityLimits) AppOption {
+	return func(app *app) {
+		app.complexityLimits = limits
+	}
+}
+
+// WithSortOrders sets the custom orders of the values of the string
+// attributes, keyed by "<scope>/<name>"; the searches sorting on these
+// attributes sort by the position of the values in the order
+func WithSortOrders(orders map[string][]string) AppOption {
+	return func(app *app) {
+		app.sortOrders = make(map[string][]string, len(orders))
+		for key, values := range orders {
+			app.sortOrders[strings.ToLower(key)] = values
+		}
+	}
+}
+
+// WithAttributeMetadata sets the display metadata of the attributes,
+// keyed by "<scope>/<name>"
+func WithAttributeMetadata(meta map[string]model.AttributeMetadata) AppOption {
+	return func(app *app) {
+		app.attrMetadata = make(map[string]model.AttributeMetadata, len(meta))
+		for key, m := range meta {
+			app.attrMetadata[strings.ToLower(key)] = m
+		}
+	}
+}
+
+// WithScanCursorTTL sets how long a scan cursor stays valid after its page
+// is returned, i.e. how long the ES point in time of the scan is kept alive
+func WithScanCursorTTL(ttl time.Duration) AppOption {
+	return func(app *app) {
+		if ttl > 0 {
+			app.scanCursorTTL = ttl
+		}
+	}
+}
+
+// WithMaxConcurrentSearches bounds the number of searches in flight to ES,
+// incl. the aggregations; the searches beyond max fail right away with
+// ErrTooManySearches instead of queueing (0: no limit)
+func WithMaxConcurrentSearches(max int) AppOption {
+	return func(app *app) {
+		if max > 0 {
+			app.searches = make(chan struct{}, max)
+		}
+	}
+}
+
+// WithReindexSuspendRefresh suspends the periodic refresh of the devices
+// index for the duration of ReindexTenantSince, to speed up its bulk writes;
+// as the index may be shared by the tenants, their searches don't see the
+// writes of the meantime either, until the reindex is over
+func WithReindexSuspendRefresh(suspend bool) AppOption {
+	return func(app *app) {
+		app.reindexSuspendRefresh = suspend
+	}
 }
 
-func NewApp(store store.Store, client inventory.Client, ri Reindexer) App {
-	return &app{
+func NewApp(
+	store store.Store,
+	client inventory.Client,
+	ri Reindexer,
+	opts ...AppOption,
+) App {
+	app := &app{
 		store:     store,
 		invClient: client,
 		reindexer: ri,
+		tasks:     map[string]string{},
+		sources:   defaultSources(client),
+
+		reindexBatchSize: reindexSinceBatchSize,
+		exportBatchSize:  exportBatchSize,
+		scanCursorTTL:    ScanCursorTTLDefault,
+	}
+	for _, opt := range opts {
+		opt(app)
 	}
+	if ri != nil {
+		// the queued reindex fetches the devices from the same sources
+		ri.useSources(app.sources)
+	}
+	return app
 }
 
 func (app *app) InventorySearchDevices(
 	ctx context.Context,
 	searchParams *model.SearchParams,
 ) ([]model.InvDevice, int, error) {
-	query, err := model.BuildQuery(*searchParams)
+	res, total, _, err := app.searchDevices(ctx, searchParams)
+	return res, total, err
+}
+
+// InventorySearchDevicesInfo searches the devices, and returns the search
+// metadata along with the results, e.g. the ES query profile if requested
+func (app *app) InventorySearchDevicesInfo(
+	ctx context.Context,
+	searchParams *model.SearchParams,
+) ([]model.InvDevice, int, *model.SearchInfo, error) {
+	return app.searchDevices(ctx, searchParams)
+}
+
+// ValidateSearch builds the query of the search params and checks with ES
+// whether it accepts it, without running the search
+func (app *app) ValidateSearch(
+	ctx context.Context,
+	searchParams *model.SearchParams,
+) (*model.QueryValidation, error) {
+	query, err := app.buildSearchQuery(ctx, searchParams)
+	if err != nil {
+		return nil, err
+	}
+	return app.store.ValidateQuery(ctx, query)
+}
+
+// buildSearchQuery builds the ES query of the search params, restricted
+// to the tenant and the requested devices
+func (app *app) buildSearchQuery(
+	ctx context.Context,
+	searchParams *model.SearchParams,
+) (model.Query, error) {
+	params := *searchParams
+	params.MaxClauseCount = app.maxClauseCount
+	params.SortOrders = app.sortOrders
+	query, err := model.BuildQuery(params)
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.checkRangeFilters(ctx, searchParams)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
 
 	if searchParams.TenantID != "" {
@@ -83,18 +312,324 @@ func (app *app) InventorySearchDevices(
 		})
 	}
 
-	esRes, err := app.store.Search(ctx, query)
+	if err := model.CheckComplexity(query, app.complexityLimits); err != nil {
+		return nil, err
+	}
+
+	return query, nil
+}
+
+// searchDevices searches the devices, returning the search metadata as well
+func (app *app) searchDevices(
+	ctx context.Context,
+	searchParams *model.SearchParams,
+) ([]model.InvDevice, int, *model.SearchInfo, error) {
+	query, err := app.buildSearchQuery(ctx, searchParams)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	// unless the total is exact, one more device tells if there's a next page
+	if !searchParams.TrackTotalHits.CountsAll() {
+		query = query.With(model.M{"size": searchParams.PerPage + 1})
+	}
+
+	esRes, err := app.search(ctx, query)
 
 	if err != nil {
-		return nil, 0, err
+		return nil, 0, nil, err
 	}
 
 	res, total, err := app.storeToInventoryDevs(esRes)
 	if err != nil {
-		return nil, 0, err
+		return nil, 0, nil, err
 	}
 
-	return res, total, err
+	terminatedEarly, _ := esRes["terminated_early"].(bool)
+	timedOut, _ := esRes["timed_out"].(bool)
+	info := &model.SearchInfo{
+		TerminatedEarly: terminatedEarly,
+		TimedOut:        timedOut,
+		Profile:         esRes["profile"],
+	}
+	if !searchParams.TrackTotalHits.CountsAll() && len(res) > searchParams.PerPage {
+		res = res[:searchParams.PerPage]
+		info.HasMore = true
+	}
+
+	return res, total, info, err
+}
+
+// search sends the query to the store, within the limit of concurrent searches
+func (app *app) search(ctx context.Context, query model.Query) (model.M, error) {
+	if app.searches != nil {
+		select {
+		case app.searches <- struct{}{}:
+			defer func() { <-app.searches }()
+		default:
+			return nil, ErrTooManySearches
+		}
+	}
+	return app.store.Search(ctx, query)
+}
+
+// DevicesExist returns which of the tenant's devices are indexed
+func (app *app) DevicesExist(
+	ctx context.Context,
+	params *model.DevicesExistParams,
+) (*model.DevicesExistResult, error) {
+	ids, err := app.store.DevicesExist(ctx, params.TenantID, params.DeviceIDs)
+	if err != nil {
+		return nil, err
+	}
+	return &model.DevicesExistResult{DeviceIDs: ids}, nil
+}
+
+// BulkGetDevices fetches devices across tenants; the missing devices
+// are reported by tenant instead of failing the whole request
+func (app *app) BulkGetDevices(
+	ctx context.Context,
+	params model.BulkGetParams,
+) (*model.BulkGetResult, error) {
+	devs, err := app.store.GetDevicesAttributes(ctx, map[string][]string(params), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]map[string]bool, len(params))
+	res := &model.BulkGetResult{
+		Devices:  make([]model.TenantInvDevice, 0, len(devs)),
+		NotFound: map[string][]string{},
+	}
+	for i := range devs {
+		tid := devs[i].GetTenantID()
+		invDev, err := app.storeDevToInventoryDev(&devs[i])
+		if err != nil {
+			return nil, err
+		}
+		res.Devices = append(res.Devices, model.TenantInvDevice{
+			TenantID:  tid,
+			InvDevice: *invDev,
+		})
+		if found[tid] == nil {
+			found[tid] = map[string]bool{}
+		}
+		found[tid][devs[i].GetID()] = true
+	}
+
+	for tid, ids := range params {
+		for _, id := range ids {
+			if !found[tid][id] {
+				res.NotFound[tid] = append(res.NotFound[tid], id)
+			}
+		}
+	}
+
+	return res, nil
+}
+
+// storeDevToInventoryDev converts a device fetched by id to the inventory
+// format, the same way as the search hits' sources
+func (a *app) storeDevToInventoryDev(dev *model.Device) (*model.InvDevice, error) {
+	data, err := json.Marshal(dev)
+	if err != nil {
+		return nil, err
+	}
+	var source map[string]interface{}
+	if err := json.Unmarshal(data, &source); err != nil {
+		return nil, err
+	}
+	return a.storeToInventoryDev(map[string]interface{}{
+		"_source": source,
+	})
+}
+
+// ExportDevices iterates over all the devices matching the search params,
+// ignoring the pagination, and passes them one by one to emit; the devices
+// are fetched in batches with search_after, so that the whole result set
+// is never loaded in memory. The iteration stops as soon as the context
+// is cancelled, or emit returns an error.
+func (app *app) ExportDevices(
+	ctx context.Context,
+	searchParams *model.SearchParams,
+	emit func(*model.InvDevice) error,
+) error {
+	query, err := app.buildSearchQuery(ctx, searchParams)
+	if err != nil {
+		return err
+	}
+	// the device id breaks the ties of the user-defined sort,
+	// so that search_after never skips nor repeats devices
+	query = query.WithSort(model.M{"id": "asc"})
+
+	var searchAfter interface{}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		batchQuery := query.Copy().WithPage(1, app.exportBatchSize)
+		if searchAfter != nil {
+			batchQuery = batchQuery.With(model.M{"search_after": searchAfter})
+		}
+
+		esRes, err := app.search(ctx, batchQuery)
+		if err != nil {
+			return err
+		}
+
+		devs, _, err := app.storeToInventoryDevs(esRes)
+		if err != nil {
+			return err
+		}
+		for i := range devs {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := emit(&devs[i]); err != nil {
+				return err
+			}
+		}
+
+		if len(devs) < app.exportBatchSize {
+			return nil
+		}
+		searchAfter = lastHitSort(esRes)
+		if searchAfter == nil {
+			return errors.New("can't process the sort values of the last hit")
+		}
+	}
+}
+
+// ScanDevices returns a page of the devices matching the search params,
+// sorted by id after the user-defined sort; params.Page is ignored. The scan
+// pages through an ES point in time, i.e. a snapshot of the index, and the
+// returned cursor resumes it after the page, also from another process,
+// within the scan cursor TTL.
+func (app *app) ScanDevices(
+	ctx context.Context,
+	params *model.ScanParams,
+) (*model.ScanPage, error) {
+	l := log.FromContext(ctx)
+
+	query, err := app.buildSearchQuery(ctx, &params.SearchParams)
+	if err != nil {
+		return nil, err
+	}
+	query = query.
+		WithSort(model.M{"id": "asc"}).
+		WithPage(1, params.PerPage)
+
+	var pitID string
+	if params.Cursor != "" {
+		cursor, err := model.DecodeScanCursor(params.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		if cursor.TenantID != params.TenantID {
+			return nil, model.ErrInvalidScanCursor
+		}
+		pitID = cursor.PITID
+		query = query.With(model.M{"search_after": cursor.SearchAfter})
+	} else {
+		pitID, err = app.store.OpenPointInTime(ctx, params.TenantID, app.scanCursorTTL)
+		if err != nil {
+			return nil, err
+		}
+	}
+	keepAlive := fmt.Sprintf("%dms", app.scanCursorTTL.Milliseconds())
+	query = query.WithPointInTime(pitID, keepAlive)
+
+	esRes, err := app.search(ctx, query)
+	if errors.Is(err, store.ErrPointInTimeNotFound) {
+		return nil, ErrScanCursorExpired
+	} else if err != nil {
+		return nil, err
+	}
+
+	devs, _, err := app.storeToInventoryDevs(esRes)
+	if err != nil {
+		return nil, err
+	}
+	page := &model.ScanPage{Devices: devs}
+
+	// ES may change the point in time id between the searches
+	if id, _ := esRes["pit_id"].(string); id != "" {
+		pitID = id
+	}
+	searchAfter, _ := lastHitSort(esRes).([]interface{})
+	if len(devs) < params.PerPage || len(searchAfter) == 0 {
+		if err := app.store.ClosePointInTime(ctx, pitID); err != nil {
+			// the point in time expires anyway
+			l.Warnf("failed to close the scan point in time: %v", err)
+		}
+		return page, nil
+	}
+
+	page.Cursor = (&model.ScanCursor{
+		TenantID:    params.TenantID,
+		PITID:       pitID,
+		SearchAfter: searchAfter,
+	}).Encode()
+	return page, nil
+}
+
+// GetDeviceChanges returns a page of the changes feed, i.e. the devices
+// updated after params.Since or params.Cursor, in ascending updated_ts order.
+// The feed searches the live index: a device updated again moves to the end
+// of the feed, and the order relies on updated_ts being set by the writes.
+func (app *app) GetDeviceChanges(
+	ctx context.Context,
+	params *model.ChangesParams,
+) (*model.ChangesPage, error) {
+	var searchAfter []interface{}
+	if params.Cursor != "" {
+		cursor, err := model.DecodeChangesCursor(params.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		if cursor.TenantID != params.TenantID {
+			return nil, model.ErrInvalidChangesCursor
+		}
+		searchAfter = cursor.SearchAfter
+	}
+
+	query, err := model.BuildChangesQuery(*params, searchAfter)
+	if err != nil {
+		return nil, err
+	}
+
+	esRes, err := app.search(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	devs, _, err := app.storeToInventoryDevs(esRes)
+	if err != nil {
+		return nil, err
+	}
+	page := &model.ChangesPage{
+		Devices: devs,
+		Cursor:  params.Cursor,
+	}
+	if after, _ := lastHitSort(esRes).([]interface{}); len(after) > 0 {
+		page.Cursor = (&model.ChangesCursor{
+			TenantID:    params.TenantID,
+			SearchAfter: after,
+		}).Encode()
+	}
+	return page, nil
+}
+
+// lastHitSort returns the sort values of the last hit, to search after it
+func lastHitSort(storeRes map[string]interface{}) interface{} {
+	hitsM, _ := storeRes["hits"].(map[string]interface{})
+	hitsS, _ := hitsM["hits"].([]interface{})
+	if len(hitsS) == 0 {
+		return nil
+	}
+	hit, _ := hitsS[len(hitsS)-1].(map[string]interface{})
+	return hit["sort"]
 }
 
 // storeToInventoryDevs translates ES results directly to iventory devices
@@ -108,14 +643,18 @@ func (a *app) storeToInventoryDevs(
 		return nil, 0, errors.New("can't process store hits map")
 	}
 
-	hitsTotalM, ok := hitsM["total"].(map[string]interface{})
-	if !ok {
-		return nil, 0, errors.New("can't process total hits struct")
-	}
+	// the total is missing if not counted, see SearchParams.TrackTotalHits
+	var total float64
+	if hitsTotal, ok := hitsM["total"]; ok {
+		hitsTotalM, ok := hitsTotal.(map[string]interface{})
+		if !ok {
+			return nil, 0, errors.New("can't process total hits struct")
+		}
 
-	total, ok := hitsTotalM["value"].(float64)
-	if !ok {
-		return nil, 0, errors.New("can't process total hits value")
+		total, ok = hitsTotalM["value"].(float64)
+		if !ok {
+			return nil, 0, errors.New("can't process total hits value")
+		}
 	}
 
 	hitsS, ok := hitsM["hits"].([]interface{})
@@ -194,6 +733,21 @@ func (a *app) storeToInventoryDev(storeRes interface{}) (*model.InvDevice, error
 
 	ret.Attributes = attrs
 
+	highlights, err := model.ParseHighlight(resM)
+	if err != nil {
+		return nil, err
+	}
+	ret.Highlights = highlights
+
+	// only present if requested, see SearchParams.TrackSeqNo
+	if _, ok := resM["_seq_no"]; ok {
+		meta, err := model.ParseDeviceMeta(resM)
+		if err != nil {
+			return nil, err
+		}
+		ret.Meta = meta
+	}
+
 	return ret, nil
 }
 
@@ -201,14 +755,8 @@ func (app *app) Reindex(ctx context.Context, tenantID, devID string, service str
 	l := log.FromContext(ctx)
 	l.Debugf("triggered reindexing for device %v:%v", tenantID, devID)
 
-	known := false
-	for _, s := range knownServices {
-		if service == s {
-			known = true
-		}
-	}
-	if !known {
-		return ErrUnknownService
+	if _, err := app.sourceClient(service); err != nil {
+		return err
 	}
 
 	err := app.reindexer.Handle(
@@ -220,42 +768,462 @@ func (app *app) Reindex(ctx context.Context, tenantID, devID string, service str
 	return err
 }
 
-func (app *app) GetSearchableInvAttrs(
+// ReindexDevice reindexes the device right away, rather than queueing it
+// like Reindex, and returns the attributes changed by the reindex, e.g. for
+// auditing; all the attributes are new on the device's first index
+func (app *app) ReindexDevice(
 	ctx context.Context,
-	tid string,
-) ([]model.InvFilterAttr, error) {
-	l := log.FromContext(ctx)
-
-	index, err := app.store.GetDevIndex(ctx, tid)
+	tenantID, devID string,
+	service string,
+) (*model.ReindexResult, error) {
+	source, err := app.sourceClient(service)
 	if err != nil {
 		return nil, err
 	}
 
-	// inventory attributes are under 'mappings.properties'
-	mappings, ok := index["mappings"]
-	if !ok {
-		return nil, errors.New("can't parse index mappings")
+	devs, err := app.store.GetDevices(ctx, map[string][]string{tenantID: {devID}})
+	if err != nil {
+		return nil, err
+	}
+	var prev *model.Device
+	for i := range devs {
+		if devs[i].GetID() == devID {
+			prev = &devs[i]
+		}
 	}
 
-	mappingsM, ok := mappings.(map[string]interface{})
-	if !ok {
-		return nil, errors.New("can't parse index mappings")
+	dev, err := source.FetchDevice(ctx, tenantID, devID)
+	if errors.Is(err, ErrSourceDeviceNotFound) {
+		dev = nil
+	} else if err != nil {
+		return nil, err
+	}
+	if dev == nil && prev == nil {
+		return nil, fmt.Errorf("%w: %s", ErrDeviceNotFound, devID)
 	}
 
-	props, ok := mappingsM["properties"]
-	if !ok {
-		return nil, errors.New("can't parse index properties")
+	item := store.BulkItem{
+		Action: &store.BulkAction{
+			Desc: &store.BulkActionDesc{
+				ID:      devID,
+				Index:   app.store.GetDevicesIndex(tenantID),
+				Routing: app.store.GetDevicesRoutingKey(tenantID),
+				Tenant:  tenantID,
+			},
+		},
+	}
+	now := time.Now()
+	switch {
+	case dev == nil:
+		item.Action.Type = "delete"
+	case prev == nil:
+		dev.SetCreatedAt(now)
+		dev.SetUpdatedAt(now)
+		item.Action.Type = "create"
+		item.Doc = dev
+	default:
+		// same as the queued reindex, only the service's scopes are replaced
+		if scopes, ok := servicesScopes([]string{service}); ok {
+			merged := prev.Copy()
+			merged.MergeScopes(dev, scopes)
+			dev = merged
+		}
+		dev.SetUpdatedAt(now)
+		item.Action.Type = "index"
+		item.Doc = dev
+	}
+	if prev != nil && prev.Meta != nil {
+		// concurrency control
+		item.Action.Desc.IfSeqNo = prev.Meta.SeqNo
+		item.Action.Desc.IfPrimaryTerm = prev.Meta.PrimaryTerm
 	}
 
-	propsM, ok := props.(map[string]interface{})
-	if !ok {
-		return nil, errors.New("can't parse index properties")
+	job := &mergeJob{
+		Tenant:       tenantID,
+		Device:       devID,
+		Routing:      item.Action.Desc.Routing,
+		HistoryIndex: app.store.GetHistoryIndex(tenantID),
+		SrcElastic:   &mergeSrcElastic{device: prev},
+	}
+	items := append([]store.BulkItem{item}, history(job, &item)...)
+	failed, err := app.store.BulkIndex(ctx, items)
+	if err != nil {
+		return nil, err
+	}
+	if len(failed) > 0 {
+		return nil, failed[0]
 	}
 
-	ret := []model.InvFilterAttr{}
+	return &model.ReindexResult{
+		DeviceID: devID,
+		Created:  prev == nil,
+		Deleted:  dev == nil,
+		Changes:  model.DiffDevices(prev, dev),
+	}, nil
+}
 
-	for k := range propsM {
-		s, n, err := model.MaybeParseAttr(k)
+// ReindexTenant starts an asynchronous reindex of all the tenant's devices;
+// only one reindex task per tenant is allowed to run at a time
+func (app *app) ReindexTenant(ctx context.Context, tid string) (string, error) {
+	l := log.FromContext(ctx)
+
+	app.tasksMu.Lock()
+	defer app.tasksMu.Unlock()
+
+	if taskID, ok := app.tasks[tid]; ok {
+		task, err := app.store.GetTask(ctx, taskID)
+		if err != nil && err != store.ErrTaskNotFound {
+			return "", err
+		}
+		if task != nil && !task.Completed {
+			return "", ErrReindexTaskRunning
+		}
+	}
+
+	taskID, err := app.store.ReindexTenant(ctx, tid)
+	if err != nil {
+		return "", err
+	}
+
+	l.Infof("started reindex task %s for tenant %s", taskID, tid)
+	app.tasks[tid] = taskID
+
+	return taskID, nil
+}
+
+// GetReindexTenantStatus polls the status of the tenant's last reindex task
+func (app *app) GetReindexTenantStatus(ctx context.Context, tid string) (*model.Task, error) {
+	taskID, ok := app.getTask(tid)
+	if !ok {
+		return nil, ErrReindexTaskNotFound
+	}
+
+	task, err := app.store.GetTask(ctx, taskID)
+	if err == store.ErrTaskNotFound {
+		return nil, ErrReindexTaskNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	task.TenantID = tid
+	return task, nil
+}
+
+// CancelReindexTenant cancels the tenant's running reindex task
+func (app *app) CancelReindexTenant(ctx context.Context, tid string) error {
+	l := log.FromContext(ctx)
+
+	taskID, ok := app.getTask(tid)
+	if !ok {
+		return ErrReindexTaskNotFound
+	}
+
+	err := app.store.CancelTask(ctx, taskID)
+	if err == store.ErrTaskNotFound {
+		return ErrReindexTaskNotFound
+	} else if err != nil {
+		return err
+	}
+
+	l.Infof("cancelled reindex task %s for tenant %s", taskID, tid)
+	return nil
+}
+
+// DeleteTenantDevices purges all the tenant's reporting data,
+// returns the number of devices deleted
+func (app *app) DeleteTenantDevices(ctx context.Context, tid string) (int, error) {
+	l := log.FromContext(ctx)
+
+	deleted, err := app.store.DeleteTenantDevices(ctx, tid)
+	if err != nil {
+		return deleted, err
+	}
+
+	l.Infof("purged %d devices of tenant %s", deleted, tid)
+	return deleted, nil
+}
+
+// ReindexTenantSince reindexes the tenant's devices updated since the given time,
+// e.g. to recover from an outage of the indexing pipeline, and returns the number
+// of reindexed devices. The devices are enumerated from the inventory service,
+// the source of truth, by the devices' 'updated_ts' system attribute; the
+// 'updatedAt' field of the index can't be used, as it only tracks when
+// the device was last indexed.
+// Devices removed from the inventory in the meantime are not enumerated,
+// so they're not removed from the index either.
+func (app *app) ReindexTenantSince(
+	ctx context.Context,
+	tid string,
+	since time.Time,
+) (int, error) {
+	l := log.FromContext(ctx)
+
+	if app.reindexSuspendRefresh {
+		if err := app.store.SuspendRefresh(ctx, tid); err != nil {
+			return 0, err
+		}
+		// the refresh is restored even if the reindex is cancelled
+		defer func() {
+			ctx := log.WithContext(context.Background(), l)
+			if err := app.store.RestoreRefresh(ctx, tid); err != nil {
+				l.Errorf("failed to restore the refresh of tenant %s: %v", tid, err)
+			}
+		}()
+	}
+
+	// the devices are paged by their last update, rather than with an
+	// offset, so that the devices updated meanwhile, moving to the end of
+	// the sort order, are neither skipped nor shifting the next pages;
+	// the devices updated at the same time as the last one of the previous
+	// page are fetched again, and skipped
+	searchReq := &inventory.SearchReq{
+		Page:    1,
+		PerPage: app.reindexBatchSize,
+		Filters: []model.FilterPredicate{{
+			Scope:     model.AttrScopeSystem,
+			Attribute: model.AttrNameUpdated,
+			Type:      "$gt",
+			Value:     since.UTC().Format(time.RFC3339Nano),
+		}},
+		Sort: []model.SortCriteria{{
+			Scope:     model.AttrScopeSystem,
+			Attribute: model.AttrNameUpdated,
+			Order:     "asc",
+		}},
+	}
+
+	count := 0
+	after := since
+	seen := map[model.DeviceID]struct{}{}
+	for {
+		invDevs, _, err := app.invClient.SearchDevices(ctx, tid, searchReq)
+		if err != nil {
+			return count, err
+		}
+
+		batch := make([]model.InvDevice, 0, len(invDevs))
+		for _, d := range invDevs {
+			if _, ok := seen[d.ID]; !ok {
+				batch = append(batch, d)
+			}
+		}
+		if len(batch) > 0 {
+			if err := app.reindexBatch(ctx, tid, batch); err != nil {
+				return count, err
+			}
+			count += len(batch)
+		}
+
+		if len(invDevs) < app.reindexBatchSize {
+			break
+		}
+
+		// a whole page of devices updated at the same time can only be
+		// skipped with the offset
+		if last := invDevs[len(invDevs)-1].UpdatedTs; last.Equal(after) {
+			searchReq.Page++
+		} else {
+			after = last
+			seen = map[model.DeviceID]struct{}{}
+			searchReq.Page = 1
+			searchReq.Filters[0].Type = "$gte"
+			searchReq.Filters[0].Value = after.UTC().Format(time.RFC3339Nano)
+		}
+		for _, d := range invDevs {
+			if d.UpdatedTs.Equal(after) {
+				seen[d.ID] = struct{}{}
+			}
+		}
+	}
+
+	l.Infof("reindexed %d devices of tenant %s updated since %s", count, tid, since)
+
+	return count, nil
+}
+
+// reindexBatch reindexes the inventory devices in a single bulk request
+func (app *app) reindexBatch(ctx context.Context, tid string, invDevs []model.InvDevice) error {
+	ids := make([]string, 0, len(invDevs))
+	for _, d := range invDevs {
+		ids = append(ids, string(d.ID))
+	}
+
+	// the current docs are needed for concurrency control
+	esDevs, err := app.store.GetDevices(ctx, map[string][]string{tid: ids})
+	if err != nil {
+		return err
+	}
+	esDevsByID := make(map[string]*model.Device, len(esDevs))
+	for i := range esDevs {
+		esDevsByID[esDevs[i].GetID()] = &esDevs[i]
+	}
+
+	items := make([]store.BulkItem, 0, len(invDevs))
+	for i := range invDevs {
+		job := mergeJob{
+			Tenant:       tid,
+			Device:       ids[i],
+			Index:        app.store.GetDevicesIndex(tid),
+			Routing:      app.store.GetDevicesRoutingKey(tid),
+			HistoryIndex: app.store.GetHistoryIndex(tid),
+			SrcInventory: &mergeSrcInventory{device: &invDevs[i]},
+			SrcElastic:   &mergeSrcElastic{device: esDevsByID[ids[i]]},
+		}
+		item, err := merge(&job)
+		if err != nil {
+			return err
+		}
+		items = append(items, *item)
+		items = append(items, history(&job, item)...)
+	}
+
+	res, err := app.store.BulkRaw(ctx, items)
+	if err != nil {
+		return err
+	}
+	handleBulkResponse(res)
+
+	return nil
+}
+
+func (app *app) getTask(tid string) (string, bool) {
+	app.tasksMu.Lock()
+	defer app.tasksMu.Unlock()
+
+	taskID, ok := app.tasks[tid]
+	return taskID, ok
+}
+
+// indexProperties returns the fields of the devices index definition,
+// incl. the inventory attributes, found under 'mappings.properties'
+func indexProperties(index map[string]interface{}) (map[string]interface{}, error) {
+	mappings, ok := index["mappings"]
+	if !ok {
+		return nil, errors.New("can't parse index mappings")
+	}
+
+	mappingsM, ok := mappings.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("can't parse index mappings")
+	}
+
+	props, ok := mappingsM["properties"]
+	if !ok {
+		return nil, errors.New("can't parse index properties")
+	}
+
+	propsM, ok := props.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("can't parse index properties")
+	}
+
+	return propsM, nil
+}
+
+// checkRangeFilters checks the search's range filters against the mapped
+// types of their attributes, so that e.g. a numeric range on a string
+// attribute fails with a clear error; the index mapping is only fetched
+// if the search has range filters
+func (app *app) checkRangeFilters(
+	ctx context.Context,
+	searchParams *model.SearchParams,
+) error {
+	filters := append([]model.FilterPredicate{}, searchParams.Filters...)
+	filters = append(filters, searchParams.PostFilters...)
+	for _, group := range searchParams.Or {
+		filters = append(filters, group...)
+	}
+	if !model.HasRangeFilters(filters) {
+		return nil
+	}
+
+	index, err := app.store.GetDevIndex(ctx, searchParams.TenantID)
+	if err != nil {
+		return err
+	}
+	props, err := indexProperties(index)
+	if err != nil {
+		return err
+	}
+
+	fieldTypes := make(map[string]string, len(props))
+	for field, mapping := range props {
+		if m, ok := mapping.(map[string]interface{}); ok {
+			fieldTypes[field], _ = m["type"].(string)
+		}
+	}
+
+	return model.CheckRangeFilters(fieldTypes, filters)
+}
+
+// RenameAttribute renames an attribute in all the tenant's indexed devices,
+// e.g. after the inventory renamed it, so that the searches on the new name
+// match the devices not updated since; it can be safely rerun, e.g. to
+// catch the devices skipped because of concurrent updates
+func (app *app) RenameAttribute(
+	ctx context.Context,
+	params *model.RenameAttributeParams,
+) (*model.Task, error) {
+	l := log.FromContext(ctx)
+
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+
+	task, err := app.store.UpdateTenantDevicesByQuery(ctx, params.TenantID,
+		model.BuildRenameAttributeQuery(*params))
+	if err != nil {
+		return nil, err
+	}
+
+	l.Infof("renamed attribute %s/%s to %s in %d devices of tenant %s "+
+		"(%d version conflicts)", params.Scope, params.From, params.To,
+		task.Updated, params.TenantID, task.Conflicts)
+	return task, nil
+}
+
+// GetIndexSettings returns the settings of the tenant's devices index
+func (app *app) GetIndexSettings(
+	ctx context.Context,
+	tid string,
+) (*model.IndexSettings, error) {
+	index, err := app.store.GetDevIndex(ctx, tid)
+	if err != nil {
+		return nil, err
+	}
+
+	settings, err := model.ParseIndexSettings(index)
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.IndexSettings{
+		TenantID: tid,
+		Index:    app.store.GetDevicesIndex(tid),
+		Settings: settings,
+	}, nil
+}
+
+func (app *app) GetSearchableInvAttrs(
+	ctx context.Context,
+	tid string,
+) ([]model.InvFilterAttr, error) {
+	l := log.FromContext(ctx)
+
+	index, err := app.store.GetDevIndex(ctx, tid)
+	if err != nil {
+		return nil, err
+	}
+
+	propsM, err := indexProperties(index)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []model.InvFilterAttr{}
+
+	for k := range propsM {
+		s, n, err := model.MaybeParseAttr(k)
 
 		if err != nil {
 			return nil, err
@@ -282,3 +1250,281 @@ func (app *app) GetSearchableInvAttrs(
 
 	return ret, nil
 }
+
+// GetAttributesMetadata returns the searchable attributes, annotated with
+// their configured display metadata; the attributes without metadata are
+// returned as they are
+func (app *app) GetAttributesMetadata(
+	ctx context.Context,
+	tid string,
+) ([]model.InvAttrMetadata, error) {
+	attrs, err := app.GetSearchableInvAttrs(ctx, tid)
+	if err != nil {
+		return nil, err
+	}
+
+	// the attributes are listed once per type, the metadata once per name
+	ret := make([]model.InvAttrMetadata, 0, len(attrs))
+	seen := make(map[string]bool, len(attrs))
+	for _, attr := range attrs {
+		key := model.AttributeMetadataKey(attr.Scope, attr.Name)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		ret = append(ret, model.InvAttrMetadata{
+			Scope:             attr.Scope,
+			Name:              attr.Name,
+			AttributeMetadata: app.attrMetadata[key],
+		})
+	}
+
+	return ret, nil
+}
+
+// GetAttributeHistory returns the history of a device attribute,
+// in chronological order
+func (app *app) GetAttributeHistory(
+	ctx context.Context,
+	params *model.AttributeHistoryParams,
+) ([]model.AttributeHistory, error) {
+	history, err := app.store.GetAttributeHistory(ctx, *params)
+	if err != nil {
+		return nil, err
+	}
+
+	// the history is returned per device and tenant
+	for i := range history {
+		history[i].TenantID = ""
+	}
+	return history, nil
+}
+
+// CompareDevices returns the diff of the attributes of the two devices;
+// ErrDeviceNotFound if either doesn't exist, or isn't in params.Groups
+func (app *app) CompareDevices(
+	ctx context.Context,
+	params *model.CompareDevicesParams,
+) (*model.DeviceComparison, error) {
+	ids := []string{params.DeviceA, params.DeviceB}
+	devs, err := app.store.GetDevicesAttributes(ctx,
+		map[string][]string{params.TenantID: ids}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[string]*model.Device, len(devs))
+	for i := range devs {
+		if len(params.Groups) > 0 && !inGroups(devs[i].GetGroupName(), params.Groups) {
+			continue
+		}
+		byID[devs[i].GetID()] = &devs[i]
+	}
+	for _, id := range ids {
+		if byID[id] == nil {
+			return nil, fmt.Errorf("%w: %s", ErrDeviceNotFound, id)
+		}
+	}
+
+	return model.CompareDevices(byID[params.DeviceA], byID[params.DeviceB]), nil
+}
+
+// GetDevice returns the device's attributes in the inventory format
+func (app *app) GetDevice(
+	ctx context.Context,
+	params *model.DeviceParams,
+) (*model.InvDevice, error) {
+	devs, err := app.store.GetDevicesAttributes(ctx, map[string][]string{
+		params.TenantID: {params.DeviceID},
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range devs {
+		if devs[i].GetID() != params.DeviceID {
+			continue
+		}
+		if len(params.Groups) > 0 && !inGroups(devs[i].GetGroupName(), params.Groups) {
+			break
+		}
+		return app.storeDevToInventoryDev(&devs[i])
+	}
+	return nil, fmt.Errorf("%w: %s", ErrDeviceNotFound, params.DeviceID)
+}
+
+// inGroups reports whether group is one of groups
+func inGroups(group string, groups []string) bool {
+	for _, g := range groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// clampBuckets returns the aggregation size, or the default one if unset,
+// capped to the configured max number of buckets
+func (app *app) clampBuckets(size, defaultSize int) int {
+	if size <= 0 {
+		size = defaultSize
+	}
+	if app.maxBuckets > 0 && size > app.maxBuckets {
+		size = app.maxBuckets
+	}
+	return size
+}
+
+// GetFacets returns a page of the distinct values of an attribute,
+// with the number of devices for each
+func (app *app) GetFacets(
+	ctx context.Context,
+	params *model.FacetsParams,
+) (*model.Facets, error) {
+	params.Size = app.clampBuckets(params.Size, model.FacetsSizeDefault)
+
+	query, err := model.BuildFacetsQuery(*params)
+	if err != nil {
+		return nil, err
+	}
+
+	esRes, err := app.search(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return model.ParseFacetsAggregation(esRes, params.Size)
+}
+
+// GetRawAggregations runs the raw ES aggregations over the tenant's
+// devices, and returns their raw results
+func (app *app) GetRawAggregations(
+	ctx context.Context,
+	params *model.RawAggsParams,
+) (model.M, error) {
+	esRes, err := app.search(ctx, model.BuildRawAggsQuery(*params))
+	if err != nil {
+		return nil, err
+	}
+
+	aggs, ok := esRes["aggregations"].(map[string]interface{})
+	if !ok {
+		return model.M{}, nil
+	}
+	return aggs, nil
+}
+
+// GetGroups returns the device groups with the number of devices in each,
+// sorted by number of devices
+func (app *app) GetGroups(
+	ctx context.Context,
+	params *model.GroupsParams,
+) ([]model.GroupCount, error) {
+	params.Size = app.clampBuckets(params.Size, model.GroupsSizeDefault)
+
+	query, err := model.BuildGroupsQuery(*params)
+	if err != nil {
+		return nil, err
+	}
+
+	esRes, err := app.search(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return model.ParseGroupsAggregation(esRes)
+}
+
+// GetPivot returns the device counts grouped by the group-by attributes,
+// as a tree of buckets nested in the group-by order
+func (app *app) GetPivot(
+	ctx context.Context,
+	params *model.PivotParams,
+) (*model.Pivot, error) {
+	for i := range params.GroupBy {
+		params.GroupBy[i].Size = app.clampBuckets(
+			params.GroupBy[i].Size, model.PivotSizeDefault)
+	}
+
+	query, err := model.BuildPivotQuery(*params)
+	if err != nil {
+		return nil, err
+	}
+
+	esRes, err := app.search(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return model.ParsePivotAggregation(esRes, len(params.GroupBy))
+}
+
+// GetIPRanges returns the device counts by CIDR range of an IPv4 attribute;
+// the attribute must be mapped with the 'ip' sub-field
+func (app *app) GetIPRanges(
+	ctx context.Context,
+	params *model.IPRangesParams,
+) (*model.IPRanges, error) {
+	index, err := app.store.GetDevIndex(ctx, params.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	props, err := indexProperties(index)
+	if err != nil {
+		return nil, err
+	}
+	field := model.ToAttr(params.Scope, params.Attribute, model.TypeStr)
+	if !model.IsIPMapping(props[field]) {
+		return nil, fmt.Errorf("%w: %s/%s",
+			model.ErrNotIPAttribute, params.Scope, params.Attribute)
+	}
+
+	query, err := model.BuildIPRangesQuery(*params)
+	if err != nil {
+		return nil, err
+	}
+
+	esRes, err := app.search(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return model.ParseIPRangesAggregation(esRes)
+}
+
+// GetGeohashGrid returns the device counts by geohash cell of a location
+// attribute; the attribute must be mapped with the 'geo' sub-field
+func (app *app) GetGeohashGrid(
+	ctx context.Context,
+	params *model.GeohashGridParams,
+) (*model.GeohashGrid, error) {
+	index, err := app.store.GetDevIndex(ctx, params.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	props, err := indexProperties(index)
+	if err != nil {
+		return nil, err
+	}
+	field := model.ToAttr(params.Scope, params.Attribute, model.TypeStr)
+	if !model.IsGeoPointMapping(props[field]) {
+		return nil, fmt.Errorf("%w: %s/%s",
+			model.ErrNotGeoPointAttribute, params.Scope, params.Attribute)
+	}
+
+	// the number of cells is capped to the max buckets
+	gridParams := *params
+	gridParams.Size = app.clampBuckets(params.Size, model.GeohashGridSizeDefault)
+	query, err := model.BuildGeohashGridQuery(gridParams)
+	if err != nil {
+		return nil, err
+	}
+
+	esRes, err := app.search(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return model.ParseGeohashGridAggregation(esRes)
+}
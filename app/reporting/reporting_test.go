@@ -15,13 +15,17 @@ package reporting
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 
 	"github.com/mendersoftware/reporting/model"
+	"github.com/mendersoftware/reporting/store"
 	mstore "github.com/mendersoftware/reporting/store/mocks"
 )
 
@@ -82,6 +86,105 @@ func TestInventorySearchDevices(t *testing.T) {
 				Scope: "inventory",
 			}},
 		}},
+	}, {
+		Name: "ok, sequence numbers",
+
+		Params: &model.SearchParams{
+			Filters: []model.FilterPredicate{{
+				Attribute: "foo",
+				Value:     "bar",
+				Scope:     "inventory",
+				Type:      "$eq",
+			}},
+			TrackSeqNo: true,
+		},
+		Store: func(t *testing.T, self testCase) *mstore.Store {
+			store := new(mstore.Store)
+			q, _ := model.BuildQuery(*self.Params)
+			store.On("Search", contextMatcher, q).
+				Return(model.M{"hits": map[string]interface{}{"hits": []interface{}{
+					map[string]interface{}{
+						"_source": map[string]interface{}{
+							"id":       "194d1060-1717-44dc-a783-00038f4a8013",
+							"tenantID": "123456789012345678901234",
+							model.ToAttr("inventory", "foo", model.TypeStr): []string{"bar"},
+						},
+						"_seq_no":       float64(42),
+						"_primary_term": float64(3),
+					}},
+					"total": map[string]interface{}{
+						"value": float64(1),
+					}},
+				}, nil)
+			return store
+		},
+		TotalCount: 1,
+		Result: []model.InvDevice{{
+			ID: "194d1060-1717-44dc-a783-00038f4a8013",
+			Attributes: model.DeviceAttributes{{
+				Name:  "foo",
+				Value: []string{"bar"},
+				Scope: "inventory",
+			}},
+			Meta: &model.DeviceMeta{SeqNo: 42, PrimaryTerm: 3},
+		}},
+	}, {
+		Name: "ok, highlighted fragments",
+
+		Params: &model.SearchParams{
+			Filters: []model.FilterPredicate{{
+				Attribute: "foo",
+				Value:     "bar",
+				Scope:     "inventory",
+				Type:      "$eq",
+			}},
+			Highlight: &model.HighlightParams{
+				Attributes: []model.SelectAttribute{{
+					Attribute: "foo",
+					Scope:     "inventory",
+				}},
+			},
+		},
+		Store: func(t *testing.T, self testCase) *mstore.Store {
+			store := new(mstore.Store)
+			q, _ := model.BuildQuery(*self.Params)
+			store.On("Search", contextMatcher, q).
+				Return(model.M{"hits": map[string]interface{}{"hits": []interface{}{
+					map[string]interface{}{
+						"_source": map[string]interface{}{
+							"id":       "194d1060-1717-44dc-a783-00038f4a8013",
+							"tenantID": "123456789012345678901234",
+							model.ToAttr("inventory", "foo", model.TypeStr): []string{"bar"},
+						},
+						"highlight": map[string]interface{}{
+							"inventory_foo_str": []interface{}{
+								"<em>bar</em>",
+							},
+							"inventory_foo_str.text": []interface{}{
+								"foo <em>bar</em>",
+							},
+						},
+					}},
+					"total": map[string]interface{}{
+						"value": float64(1),
+					}},
+				}, nil)
+			return store
+		},
+		TotalCount: 1,
+		Result: []model.InvDevice{{
+			ID: "194d1060-1717-44dc-a783-00038f4a8013",
+			Attributes: model.DeviceAttributes{{
+				Name:  "foo",
+				Value: []string{"bar"},
+				Scope: "inventory",
+			}},
+			Highlights: []model.InvDeviceHighlight{{
+				Scope:     "inventory",
+				Name:      "foo",
+				Fragments: []string{"<em>bar</em>", "foo <em>bar</em>"},
+			}},
+		}},
 	}, {
 		Name: "ok, empty result",
 
@@ -146,6 +249,90 @@ func TestInventorySearchDevices(t *testing.T) {
 			}},
 		},
 		Error: errors.New("filter type not supported"),
+	}, {
+		Name: "ok, numeric range filter",
+
+		Params: &model.SearchParams{
+			Filters: []model.FilterPredicate{{
+				Attribute: "uptime",
+				Value:     3600.0,
+				Scope:     "inventory",
+				Type:      "$gt",
+			}},
+			TenantID: "tenant1",
+		},
+		Store: func(t *testing.T, self testCase) *mstore.Store {
+			store := new(mstore.Store)
+			store.On("GetDevIndex", contextMatcher, "tenant1").
+				Return(map[string]interface{}{
+					"mappings": map[string]interface{}{
+						"properties": map[string]interface{}{
+							"inventory_uptime_num": map[string]interface{}{
+								"type": "double",
+							},
+						},
+					},
+				}, nil)
+			q, _ := model.BuildQuery(*self.Params)
+			q = q.Must(model.M{"term": model.M{"tenantID": "tenant1"}})
+			store.On("Search", contextMatcher, q).
+				Return(model.M{
+					"hits": map[string]interface{}{
+						"hits": []interface{}{},
+						"total": map[string]interface{}{
+							"value": float64(0),
+						},
+					},
+				}, nil)
+			return store
+		},
+		Result: []model.InvDevice{},
+	}, {
+		Name: "error, numeric range filter on a string attribute",
+
+		Params: &model.SearchParams{
+			Or: [][]model.FilterPredicate{{{
+				Attribute: "mac",
+				Value:     10.0,
+				Scope:     "identity",
+				Type:      "$lt",
+			}}},
+			TenantID: "tenant1",
+		},
+		Store: func(t *testing.T, self testCase) *mstore.Store {
+			store := new(mstore.Store)
+			store.On("GetDevIndex", contextMatcher, "tenant1").
+				Return(map[string]interface{}{
+					"mappings": map[string]interface{}{
+						"properties": map[string]interface{}{
+							"identity_mac_str": map[string]interface{}{
+								"type": "keyword",
+							},
+						},
+					},
+				}, nil)
+			return store
+		},
+		Error: errors.New(
+			"attribute identity/mac is not numeric: cannot apply range filter"),
+	}, {
+		Name: "error, getting the index mapping",
+
+		Params: &model.SearchParams{
+			PostFilters: []model.FilterPredicate{{
+				Attribute: "uptime",
+				Value:     3600.0,
+				Scope:     "inventory",
+				Type:      "$gte",
+			}},
+		},
+		Store: func(t *testing.T, self testCase) *mstore.Store {
+			store := new(mstore.Store)
+			store.On("GetDevIndex", contextMatcher, "").
+				Return(nil, errors.New("internal error"))
+			return store
+		},
+		Error: errors.New("internal error"),
 	}}
 	for i := range testCases {
 		tc := testCases[i]
@@ -174,3 +361,1547 @@ func TestInventorySearchDevices(t *testing.T) {
 		})
 	}
 }
+
+func TestExportDevices(t *testing.T) {
+	t.Parallel()
+
+	hit := func(id string) interface{} {
+		return map[string]interface{}{
+			"_source": map[string]interface{}{
+				"id": id,
+			},
+			"sort": []interface{}{id},
+		}
+	}
+	searchRes := func(hits ...interface{}) model.M {
+		return model.M{"hits": map[string]interface{}{
+			"hits":  hits,
+			"total": map[string]interface{}{"value": float64(3)},
+		}}
+	}
+
+	t.Run("ok, paginated with search_after", func(t *testing.T) {
+		t.Parallel()
+
+		var queries []map[string]interface{}
+		store := new(mstore.Store)
+		store.On("Search", contextMatcher, mock.Anything).
+			Run(func(args mock.Arguments) {
+				var q map[string]interface{}
+				b, _ := json.Marshal(args.Get(1))
+				_ = json.Unmarshal(b, &q)
+				queries = append(queries, q)
+			}).
+			Return(searchRes(hit("dev1"), hit("dev2")), nil).Once()
+		store.On("Search", contextMatcher, mock.Anything).
+			Run(func(args mock.Arguments) {
+				var q map[string]interface{}
+				b, _ := json.Marshal(args.Get(1))
+				_ = json.Unmarshal(b, &q)
+				queries = append(queries, q)
+			}).
+			Return(searchRes(hit("dev3")), nil).Once()
+		defer store.AssertExpectations(t)
+
+		app := NewApp(store, nil, nil).(*app)
+		app.exportBatchSize = 2
+
+		var ids []string
+		err := app.ExportDevices(context.Background(),
+			&model.SearchParams{TenantID: "tenant1"},
+			func(dev *model.InvDevice) error {
+				ids = append(ids, string(dev.ID))
+				return nil
+			})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"dev1", "dev2", "dev3"}, ids)
+
+		if assert.Len(t, queries, 2) {
+			assert.Equal(t, float64(0), queries[0]["from"])
+			assert.Equal(t, float64(2), queries[0]["size"])
+			assert.NotContains(t, queries[0], "search_after")
+			assert.Equal(t, []interface{}{
+				map[string]interface{}{"id": "asc"},
+			}, queries[0]["sort"])
+			assert.Equal(t, []interface{}{"dev2"}, queries[1]["search_after"])
+		}
+	})
+
+	t.Run("error, context cancelled", func(t *testing.T) {
+		t.Parallel()
+
+		store := new(mstore.Store)
+		store.On("Search", contextMatcher, mock.Anything).
+			Return(searchRes(hit("dev1"), hit("dev2")), nil).Once()
+		defer store.AssertExpectations(t)
+
+		app := NewApp(store, nil, nil).(*app)
+		app.exportBatchSize = 2
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var ids []string
+		err := app.ExportDevices(ctx,
+			&model.SearchParams{TenantID: "tenant1"},
+			func(dev *model.InvDevice) error {
+				ids = append(ids, string(dev.ID))
+				// the client went away
+				cancel()
+				return nil
+			})
+		assert.Equal(t, context.Canceled, err)
+		assert.Equal(t, []string{"dev1"}, ids)
+	})
+}
+
+func TestScanDevices(t *testing.T) {
+	t.Parallel()
+
+	hit := func(id string) interface{} {
+		return map[string]interface{}{
+			"_source": map[string]interface{}{
+				"id": id,
+			},
+			"sort": []interface{}{id},
+		}
+	}
+	searchRes := func(pitID string, hits ...interface{}) model.M {
+		return model.M{
+			"pit_id": pitID,
+			"hits": map[string]interface{}{
+				"hits":  hits,
+				"total": map[string]interface{}{"value": float64(3)},
+			},
+		}
+	}
+	params := func(cursor string) *model.ScanParams {
+		return &model.ScanParams{
+			SearchParams: model.SearchParams{
+				PerPage:  2,
+				TenantID: "tenant1",
+			},
+			Cursor: cursor,
+		}
+	}
+	captureQuery := func(q *map[string]interface{}) func(mock.Arguments) {
+		return func(args mock.Arguments) {
+			b, _ := json.Marshal(args.Get(1))
+			_ = json.Unmarshal(b, q)
+		}
+	}
+
+	t.Run("ok, resumed from the cursor", func(t *testing.T) {
+		t.Parallel()
+
+		// the first page opens the point in time
+		var query map[string]interface{}
+		store := new(mstore.Store)
+		store.On("OpenPointInTime", contextMatcher, "tenant1", 5*time.Minute).
+			Return("pit1", nil).Once()
+		store.On("Search", contextMatcher, mock.Anything).
+			Run(captureQuery(&query)).
+			Return(searchRes("pit2", hit("dev1"), hit("dev2")), nil).Once()
+
+		app := NewApp(store, nil, nil, WithScanCursorTTL(5*time.Minute))
+		page, err := app.ScanDevices(context.Background(), params(""))
+		assert.NoError(t, err)
+		assert.Equal(t, []model.InvDevice{
+			{ID: "dev1", Attributes: model.DeviceAttributes{}},
+			{ID: "dev2", Attributes: model.DeviceAttributes{}},
+		}, page.Devices)
+		assert.NotEmpty(t, page.Cursor)
+		store.AssertExpectations(t)
+
+		assert.NotContains(t, query, "search_after")
+		assert.Equal(t, map[string]interface{}{
+			"id":         "pit1",
+			"keep_alive": "300000ms",
+		}, query["pit"])
+		assert.Equal(t, []interface{}{
+			map[string]interface{}{"id": "asc"},
+		}, query["sort"])
+
+		// another app, e.g. after a restart, resumes the scan from the
+		// cursor, and closes the point in time after the last page
+		store = new(mstore.Store)
+		store.On("Search", contextMatcher, mock.Anything).
+			Run(captureQuery(&query)).
+			Return(searchRes("pit2", hit("dev3")), nil).Once()
+		store.On("ClosePointInTime", contextMatcher, "pit2").
+			Return(nil).Once()
+		defer store.AssertExpectations(t)
+
+		app = NewApp(store, nil, nil, WithScanCursorTTL(5*time.Minute))
+		page, err = app.ScanDevices(context.Background(), params(page.Cursor))
+		assert.NoError(t, err)
+		assert.Equal(t, []model.InvDevice{
+			{ID: "dev3", Attributes: model.DeviceAttributes{}},
+		}, page.Devices)
+		assert.Empty(t, page.Cursor)
+
+		assert.Equal(t, []interface{}{"dev2"}, query["search_after"])
+		assert.Equal(t, map[string]interface{}{
+			"id":         "pit2",
+			"keep_alive": "300000ms",
+		}, query["pit"])
+	})
+
+	t.Run("error, cursor expired", func(t *testing.T) {
+		t.Parallel()
+
+		st := new(mstore.Store)
+		st.On("Search", contextMatcher, mock.Anything).
+			Return(nil, store.ErrPointInTimeNotFound).Once()
+		defer st.AssertExpectations(t)
+
+		cursor := (&model.ScanCursor{
+			TenantID:    "tenant1",
+			PITID:       "pit1",
+			SearchAfter: []interface{}{"dev2"},
+		}).Encode()
+		app := NewApp(st, nil, nil)
+		_, err := app.ScanDevices(context.Background(), params(cursor))
+		assert.Equal(t, ErrScanCursorExpired, err)
+	})
+
+	t.Run("error, cursor of another tenant", func(t *testing.T) {
+		t.Parallel()
+
+		store := new(mstore.Store)
+		defer store.AssertExpectations(t)
+
+		cursor := (&model.ScanCursor{
+			TenantID:    "tenant2",
+			PITID:       "pit1",
+			SearchAfter: []interface{}{"dev2"},
+		}).Encode()
+		app := NewApp(store, nil, nil)
+		_, err := app.ScanDevices(context.Background(), params(cursor))
+		assert.Equal(t, model.ErrInvalidScanCursor, err)
+	})
+}
+
+func TestGetDeviceChanges(t *testing.T) {
+	t.Parallel()
+
+	hit := func(id string, updated float64) interface{} {
+		return map[string]interface{}{
+			"_source": map[string]interface{}{
+				"id": id,
+			},
+			"sort": []interface{}{updated, id},
+		}
+	}
+	searchRes := func(hits ...interface{}) model.M {
+		return model.M{
+			"hits": map[string]interface{}{
+				"hits": hits,
+			},
+		}
+	}
+	captureQuery := func(q *map[string]interface{}) func(mock.Arguments) {
+		return func(args mock.Arguments) {
+			b, _ := json.Marshal(args.Get(1))
+			_ = json.Unmarshal(b, q)
+		}
+	}
+	since := time.Date(2021, 8, 19, 10, 25, 32, 0, time.UTC)
+
+	t.Run("ok, continued from the cursor", func(t *testing.T) {
+		t.Parallel()
+
+		var query map[string]interface{}
+		store := new(mstore.Store)
+		store.On("Search", contextMatcher, mock.Anything).
+			Run(captureQuery(&query)).
+			Return(searchRes(hit("dev1", 1629368733000), hit("dev2", 1629368733000)), nil).
+			Once()
+
+		app := NewApp(store, nil, nil)
+		page, err := app.GetDeviceChanges(context.Background(), &model.ChangesParams{
+			TenantID: "tenant1",
+			Since:    &since,
+			Size:     2,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []model.InvDevice{
+			{ID: "dev1", Attributes: model.DeviceAttributes{}},
+			{ID: "dev2", Attributes: model.DeviceAttributes{}},
+		}, page.Devices)
+		assert.NotEmpty(t, page.Cursor)
+		store.AssertExpectations(t)
+
+		assert.NotContains(t, query, "search_after")
+		assert.Equal(t, []interface{}{
+			map[string]interface{}{"updatedAt": "asc"},
+			map[string]interface{}{"id": "asc"},
+		}, query["sort"])
+
+		// the next page continues after the last device, ties on
+		// updated_ts broken by id
+		store = new(mstore.Store)
+		store.On("Search", contextMatcher, mock.Anything).
+			Run(captureQuery(&query)).
+			Return(searchRes(hit("dev3", 1629368740000)), nil).Once()
+
+		app = NewApp(store, nil, nil)
+		page, err = app.GetDeviceChanges(context.Background(), &model.ChangesParams{
+			TenantID: "tenant1",
+			Cursor:   page.Cursor,
+			Size:     2,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []model.InvDevice{
+			{ID: "dev3", Attributes: model.DeviceAttributes{}},
+		}, page.Devices)
+		assert.Equal(t, []interface{}{float64(1629368733000), "dev2"},
+			query["search_after"])
+		store.AssertExpectations(t)
+
+		// no changes since: the same cursor is returned to poll again
+		cursor := page.Cursor
+		store = new(mstore.Store)
+		store.On("Search", contextMatcher, mock.Anything).
+			Run(captureQuery(&query)).
+			Return(searchRes(), nil).Once()
+		defer store.AssertExpectations(t)
+
+		app = NewApp(store, nil, nil)
+		page, err = app.GetDeviceChanges(context.Background(), &model.ChangesParams{
+			TenantID: "tenant1",
+			Cursor:   cursor,
+			Size:     2,
+		})
+		assert.NoError(t, err)
+		assert.Empty(t, page.Devices)
+		assert.Equal(t, cursor, page.Cursor)
+		assert.Equal(t, []interface{}{float64(1629368740000), "dev3"},
+			query["search_after"])
+	})
+
+	t.Run("error, cursor of another tenant", func(t *testing.T) {
+		t.Parallel()
+
+		store := new(mstore.Store)
+		defer store.AssertExpectations(t)
+
+		cursor := (&model.ChangesCursor{
+			TenantID:    "tenant2",
+			SearchAfter: []interface{}{float64(1629368733000), "dev2"},
+		}).Encode()
+		app := NewApp(store, nil, nil)
+		_, err := app.GetDeviceChanges(context.Background(), &model.ChangesParams{
+			TenantID: "tenant1",
+			Cursor:   cursor,
+		})
+		assert.Equal(t, model.ErrInvalidChangesCursor, err)
+	})
+}
+
+func TestGetAttributesMetadata(t *testing.T) {
+	t.Parallel()
+
+	store := new(mstore.Store)
+	store.On("GetDevIndex", contextMatcher, "tenant1").
+		Return(map[string]interface{}{
+			"mappings": map[string]interface{}{
+				"properties": map[string]interface{}{
+					"id":                             map[string]interface{}{},
+					"tenantID":                       map[string]interface{}{},
+					"inventory_kernel_osrelease_str": map[string]interface{}{},
+					"inventory_mem_total_kB_str":     map[string]interface{}{},
+					"inventory_mem_total_kB_num":     map[string]interface{}{},
+					"inventory_region_str":           map[string]interface{}{},
+				},
+			},
+		}, nil)
+	defer store.AssertExpectations(t)
+
+	app := NewApp(store, nil, nil, WithAttributeMetadata(
+		map[string]model.AttributeMetadata{
+			"inventory/kernel_osrelease": {DisplayName: "Kernel version"},
+			// the configuration keys are lowercase
+			"inventory/mem_total_kb": {
+				DisplayName: "Total memory",
+				Description: "Total amount of RAM",
+				Unit:        "kB",
+			},
+			// not discovered yet
+			"inventory/serial_no": {DisplayName: "Serial number"},
+		}))
+	res, err := app.GetAttributesMetadata(context.Background(), "tenant1")
+	assert.NoError(t, err)
+	assert.Equal(t, []model.InvAttrMetadata{{
+		Scope: "inventory",
+		Name:  "kernel_osrelease",
+		AttributeMetadata: model.AttributeMetadata{
+			DisplayName: "Kernel version",
+		},
+	}, {
+		Scope: "inventory",
+		Name:  "mem_total_kB",
+		AttributeMetadata: model.AttributeMetadata{
+			DisplayName: "Total memory",
+			Description: "Total amount of RAM",
+			Unit:        "kB",
+		},
+	}, {
+		// no metadata, passed through
+		Scope: "inventory",
+		Name:  "region",
+	}}, res)
+}
+
+func TestMaxConcurrentSearches(t *testing.T) {
+	t.Parallel()
+	params := &model.SearchParams{TenantID: "tenant1", PerPage: 10, Page: 1}
+	esRes := model.M{"hits": map[string]interface{}{
+		"total": map[string]interface{}{"value": float64(0)},
+		"hits":  []interface{}{},
+	}}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	store := new(mstore.Store)
+	store.On("Search", contextMatcher, mock.AnythingOfType("*model.query")).
+		Run(func(mock.Arguments) {
+			started <- struct{}{}
+			<-release
+		}).
+		Return(esRes, nil).
+		Once()
+	store.On("Search", contextMatcher, mock.AnythingOfType("*model.query")).
+		Return(esRes, nil).
+		Once()
+	defer store.AssertExpectations(t)
+
+	app := NewApp(store, nil, nil, WithMaxConcurrentSearches(1))
+
+	// within the limit: proceeds
+	done := make(chan error)
+	go func() {
+		_, _, err := app.InventorySearchDevices(context.Background(), params)
+		done <- err
+	}()
+	<-started
+
+	// above the limit: rejected right away
+	_, _, err := app.InventorySearchDevices(context.Background(), params)
+	assert.Equal(t, ErrTooManySearches, err)
+	_, err = app.GetPivot(context.Background(), &model.PivotParams{
+		GroupBy: []model.PivotDimension{{Scope: "inventory", Attribute: "os"}},
+	})
+	assert.Equal(t, ErrTooManySearches, err)
+
+	// the slot is released by the search in flight
+	close(release)
+	assert.NoError(t, <-done)
+	_, _, err = app.InventorySearchDevices(context.Background(), params)
+	assert.NoError(t, err)
+}
+
+func TestRenameAttribute(t *testing.T) {
+	t.Parallel()
+	params := &model.RenameAttributeParams{
+		TenantID: "tenant1",
+		Scope:    model.AttrScopeInventory,
+		From:     "ipv4",
+		To:       "ip4",
+	}
+	task := &model.Task{TenantID: "tenant1", Completed: true, Total: 2, Updated: 2}
+
+	store := new(mstore.Store)
+	store.On("UpdateTenantDevicesByQuery", contextMatcher, "tenant1",
+		model.BuildRenameAttributeQuery(*params)).
+		Return(task, nil)
+	defer store.AssertExpectations(t)
+
+	app := NewApp(store, nil, nil)
+	res, err := app.RenameAttribute(context.Background(), params)
+	assert.NoError(t, err)
+	assert.Equal(t, task, res)
+
+	// invalid params: ES isn't called
+	_, err = app.RenameAttribute(context.Background(), &model.RenameAttributeParams{
+		TenantID: "tenant1",
+		Scope:    model.AttrScopeInventory,
+		From:     "ipv4",
+		To:       "ipv4",
+	})
+	assert.EqualError(t, err, "to: must differ from the attribute renamed")
+}
+
+func TestGetIndexSettings(t *testing.T) {
+	t.Parallel()
+	store := new(mstore.Store)
+	store.On("GetDevIndex", contextMatcher, "tenant1").
+		Return(map[string]interface{}{
+			"settings": map[string]interface{}{
+				"index": map[string]interface{}{
+					"number_of_shards":   "1",
+					"number_of_replicas": "0",
+					"uuid":               "Jr9dqhvJTZKhRkB2Yd3v7g",
+				},
+			},
+		}, nil)
+	store.On("GetDevicesIndex", "tenant1").Return("devices")
+	defer store.AssertExpectations(t)
+
+	app := NewApp(store, nil, nil)
+	res, err := app.GetIndexSettings(context.Background(), "tenant1")
+	assert.NoError(t, err)
+	assert.Equal(t, &model.IndexSettings{
+		TenantID: "tenant1",
+		Index:    "devices",
+		Settings: map[string]interface{}{
+			"number_of_shards":   "1",
+			"number_of_replicas": "0",
+		},
+	}, res)
+}
+
+func TestBulkGetDevices(t *testing.T) {
+	t.Parallel()
+
+	params := model.BulkGetParams{
+		"tenant1": {"dev1", "dev2"},
+		"tenant2": {"dev1"},
+		// no index for tenant3 yet, the store skips its devices
+		"tenant3": {"dev3"},
+	}
+
+	dev := func(tid, id string) model.Device {
+		d := model.NewDevice(id).SetTenantID(tid)
+		_ = d.AppendAttr(model.NewInventoryAttribute(model.AttrScopeInventory).
+			SetName("os").
+			SetString("linux"))
+		return *d
+	}
+
+	store := new(mstore.Store)
+	store.On("GetDevicesAttributes", contextMatcher, map[string][]string(params),
+		[]model.SelectAttribute(nil)).
+		Return([]model.Device{
+			dev("tenant1", "dev1"),
+			dev("tenant2", "dev1"),
+		}, nil)
+	defer store.AssertExpectations(t)
+
+	app := NewApp(store, nil, nil)
+	res, err := app.BulkGetDevices(context.Background(), params)
+	assert.NoError(t, err)
+
+	// same format as the search results
+	attrs := model.DeviceAttributes{{
+		Name:  "os",
+		Value: []interface{}{"linux"},
+		Scope: model.AttrScopeInventory,
+	}}
+	assert.Equal(t, &model.BulkGetResult{
+		Devices: []model.TenantInvDevice{{
+			TenantID:  "tenant1",
+			InvDevice: model.InvDevice{ID: "dev1", Attributes: attrs},
+		}, {
+			TenantID:  "tenant2",
+			InvDevice: model.InvDevice{ID: "dev1", Attributes: attrs},
+		}},
+		NotFound: map[string][]string{
+			"tenant1": {"dev2"},
+			"tenant3": {"dev3"},
+		},
+	}, res)
+}
+
+func TestCompareDevices(t *testing.T) {
+	t.Parallel()
+
+	dev := func(id, os string) model.Device {
+		d := model.NewDevice(id).SetTenantID("tenant1").SetGroupName("group1")
+		_ = d.AppendAttr(model.NewInventoryAttribute(model.AttrScopeInventory).
+			SetName("os").
+			SetString(os))
+		return *d
+	}
+
+	testCases := map[string]struct {
+		devs   []model.Device
+		groups []string
+
+		res    *model.DeviceComparison
+		err    error
+		errMsg string
+	}{
+		"ok": {
+			devs: []model.Device{dev("dev2", "debian"), dev("dev1", "linux")},
+
+			res: &model.DeviceComparison{
+				DeviceA: "dev1",
+				DeviceB: "dev2",
+				Common:  []model.InvDeviceAttribute{},
+				OnlyInA: []model.InvDeviceAttribute{},
+				OnlyInB: []model.InvDeviceAttribute{},
+				Different: []model.AttributeDiff{{
+					Scope:  model.AttrScopeInventory,
+					Name:   "os",
+					ValueA: []string{"linux"},
+					ValueB: []string{"debian"},
+				}},
+			},
+		},
+		"error, device not found": {
+			devs: []model.Device{dev("dev1", "linux")},
+
+			err:    ErrDeviceNotFound,
+			errMsg: "device not found: dev2",
+		},
+		"error, no devices": {
+			err:    ErrDeviceNotFound,
+			errMsg: "device not found: dev1",
+		},
+		"error, device not in the groups": {
+			devs:   []model.Device{dev("dev2", "debian"), dev("dev1", "linux")},
+			groups: []string{"group2"},
+
+			err:    ErrDeviceNotFound,
+			errMsg: "device not found: dev1",
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			store := new(mstore.Store)
+			store.On("GetDevicesAttributes", contextMatcher,
+				map[string][]string{"tenant1": {"dev1", "dev2"}},
+				[]model.SelectAttribute(nil)).
+				Return(tc.devs, nil)
+			defer store.AssertExpectations(t)
+
+			app := NewApp(store, nil, nil)
+			res, err := app.CompareDevices(context.Background(),
+				&model.CompareDevicesParams{
+					TenantID: "tenant1",
+					DeviceA:  "dev1",
+					DeviceB:  "dev2",
+					Groups:   tc.groups,
+				})
+			if tc.err != nil {
+				assert.True(t, errors.Is(err, tc.err))
+				assert.EqualError(t, err, tc.errMsg)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.res, res)
+			}
+		})
+	}
+}
+
+func TestGetDevice(t *testing.T) {
+	t.Parallel()
+
+	dev := model.NewDevice("dev1").SetTenantID("tenant1").SetGroupName("group1")
+	_ = dev.AppendAttr(model.NewInventoryAttribute(model.AttrScopeInventory).
+		SetName("os").
+		SetString("linux"))
+	_ = dev.AppendAttr(model.NewInventoryAttribute(model.AttrScopeIdentity).
+		SetName("mac").
+		SetString("00:11:22:33:44:55"))
+	_ = dev.AppendAttr(model.NewInventoryAttribute(model.AttrScopeSystem).
+		SetName(model.AttrNameStatus).
+		SetString("accepted"))
+
+	testCases := map[string]struct {
+		devs   []model.Device
+		groups []string
+
+		res    *model.GroupedInvDevice
+		err    error
+		errMsg string
+	}{
+		"ok": {
+			devs: []model.Device{*dev},
+
+			res: &model.GroupedInvDevice{
+				ID: "dev1",
+				Attributes: model.ScopedAttributes{
+					model.AttrScopeInventory: {"os": []interface{}{"linux"}},
+					model.AttrScopeIdentity: {
+						"mac": []interface{}{"00:11:22:33:44:55"},
+					},
+					model.AttrScopeSystem: {
+						model.AttrNameStatus: []interface{}{"accepted"},
+					},
+				},
+			},
+		},
+		"ok, in the groups": {
+			devs:   []model.Device{*dev},
+			groups: []string{"group2", "group1"},
+
+			res: &model.GroupedInvDevice{
+				ID: "dev1",
+				Attributes: model.ScopedAttributes{
+					model.AttrScopeInventory: {"os": []interface{}{"linux"}},
+					model.AttrScopeIdentity: {
+						"mac": []interface{}{"00:11:22:33:44:55"},
+					},
+					model.AttrScopeSystem: {
+						model.AttrNameStatus: []interface{}{"accepted"},
+					},
+				},
+			},
+		},
+		"error, device not found": {
+			err:    ErrDeviceNotFound,
+			errMsg: "device not found: dev1",
+		},
+		"error, device not in the groups": {
+			devs:   []model.Device{*dev},
+			groups: []string{"group2"},
+
+			err:    ErrDeviceNotFound,
+			errMsg: "device not found: dev1",
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			store := new(mstore.Store)
+			store.On("GetDevicesAttributes", contextMatcher,
+				map[string][]string{"tenant1": {"dev1"}},
+				[]model.SelectAttribute(nil)).
+				Return(tc.devs, nil)
+			defer store.AssertExpectations(t)
+
+			app := NewApp(store, nil, nil)
+			res, err := app.GetDevice(context.Background(),
+				&model.DeviceParams{
+					TenantID: "tenant1",
+					DeviceID: "dev1",
+					Groups:   tc.groups,
+				})
+			if tc.err != nil {
+				assert.True(t, errors.Is(err, tc.err))
+				assert.EqualError(t, err, tc.errMsg)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.res, res.GroupByScope())
+			}
+		})
+	}
+}
+
+func TestAggregationsMaxBuckets(t *testing.T) {
+	t.Parallel()
+	testCases := map[string]struct {
+		maxBuckets int
+		size       int
+
+		outSize int
+	}{
+		"no limit": {
+			size:    5000,
+			outSize: 5000,
+		},
+		"default size": {
+			maxBuckets: 1000,
+			outSize:    model.GroupsSizeDefault,
+		},
+		"clamped default size": {
+			maxBuckets: 50,
+			outSize:    50,
+		},
+		"clamped": {
+			maxBuckets: 1000,
+			size:       5000,
+			outSize:    1000,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			params := &model.GroupsParams{TenantID: "tenant1", Size: tc.size}
+
+			q, _ := model.BuildGroupsQuery(model.GroupsParams{
+				TenantID: "tenant1",
+				Size:     tc.outSize,
+			})
+			store := new(mstore.Store)
+			store.On("Search", contextMatcher, q).
+				Return(model.M{"aggregations": map[string]interface{}{
+					"groups": map[string]interface{}{
+						"buckets": []interface{}{},
+					},
+				}}, nil)
+			defer store.AssertExpectations(t)
+
+			app := NewApp(store, nil, nil, WithMaxBuckets(tc.maxBuckets))
+			_, err := app.GetGroups(context.Background(), params)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.outSize, params.Size)
+		})
+	}
+}
+
+func TestGetPivot(t *testing.T) {
+	t.Parallel()
+	params := &model.PivotParams{
+		GroupBy: []model.PivotDimension{
+			{Scope: model.AttrScopeInventory, Attribute: "os"},
+			{Scope: model.AttrScopeSystem, Attribute: "group", Size: 500},
+		},
+		TenantID: "tenant1",
+	}
+
+	// the sizes are defaulted and clamped to the max buckets
+	q, _ := model.BuildPivotQuery(model.PivotParams{
+		GroupBy: []model.PivotDimension{
+			{Scope: model.AttrScopeInventory, Attribute: "os", Size: 10},
+			{Scope: model.AttrScopeSystem, Attribute: "group", Size: 100},
+		},
+		TenantID: "tenant1",
+	})
+	store := new(mstore.Store)
+	store.On("Search", contextMatcher, q).
+		Return(model.M{"aggregations": map[string]interface{}{
+			"pivot": map[string]interface{}{
+				"buckets": []interface{}{
+					map[string]interface{}{
+						"key":       "linux",
+						"doc_count": float64(3),
+						"pivot": map[string]interface{}{
+							"buckets": []interface{}{
+								map[string]interface{}{
+									"key":       "production",
+									"doc_count": float64(3),
+								},
+							},
+						},
+					},
+				},
+			},
+		}}, nil)
+	defer store.AssertExpectations(t)
+
+	app := NewApp(store, nil, nil, WithMaxBuckets(100))
+	res, err := app.GetPivot(context.Background(), params)
+	assert.NoError(t, err)
+	assert.Equal(t, &model.Pivot{
+		Buckets: []model.PivotBucket{{
+			Value: "linux",
+			Count: 3,
+			Buckets: []model.PivotBucket{
+				{Value: "production", Count: 3},
+			},
+		}},
+	}, res)
+}
+
+func TestGetIPRanges(t *testing.T) {
+	t.Parallel()
+	params := &model.IPRangesParams{
+		Scope:     model.AttrScopeInventory,
+		Attribute: "ipv4_eth0",
+		Ranges:    []string{"10.0.0.0/8"},
+		TenantID:  "tenant1",
+	}
+	q, _ := model.BuildIPRangesQuery(*params)
+
+	testCases := map[string]struct {
+		mapping map[string]interface{}
+
+		res *model.IPRanges
+		err error
+	}{
+		"ok": {
+			mapping: map[string]interface{}{
+				"type": "keyword",
+				"fields": map[string]interface{}{
+					"ip": map[string]interface{}{"type": "ip"},
+				},
+			},
+			res: &model.IPRanges{
+				Buckets: []model.IPRangeBucket{{
+					Range: "10.0.0.0/8",
+					From:  "10.0.0.0",
+					To:    "11.0.0.0",
+					Count: 4,
+				}},
+			},
+		},
+		"error, not mapped as ip": {
+			mapping: map[string]interface{}{"type": "keyword"},
+			err:     model.ErrNotIPAttribute,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			store := new(mstore.Store)
+			store.On("GetDevIndex", contextMatcher, "tenant1").
+				Return(map[string]interface{}{
+					"mappings": map[string]interface{}{
+						"properties": map[string]interface{}{
+							"inventory_ipv4_eth0_str": tc.mapping,
+						},
+					},
+				}, nil)
+			if tc.err == nil {
+				store.On("Search", contextMatcher, q).
+					Return(model.M{"aggregations": map[string]interface{}{
+						"ip_ranges": map[string]interface{}{
+							"buckets": []interface{}{
+								map[string]interface{}{
+									"key":       "10.0.0.0/8",
+									"from":      "10.0.0.0",
+									"to":        "11.0.0.0",
+									"doc_count": float64(4),
+								},
+							},
+						},
+					}}, nil)
+			}
+			defer store.AssertExpectations(t)
+
+			app := NewApp(store, nil, nil)
+			res, err := app.GetIPRanges(context.Background(), params)
+			if tc.err != nil {
+				assert.True(t, errors.Is(err, tc.err))
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.res, res)
+			}
+		})
+	}
+}
+
+func TestGetGeohashGrid(t *testing.T) {
+	t.Parallel()
+	params := &model.GeohashGridParams{
+		Scope:     model.AttrScopeInventory,
+		Attribute: "location",
+		Precision: 4,
+		TenantID:  "tenant1",
+	}
+	// the number of cells is capped to the max buckets
+	q, _ := model.BuildGeohashGridQuery(model.GeohashGridParams{
+		Scope:     model.AttrScopeInventory,
+		Attribute: "location",
+		Precision: 4,
+		Size:      100,
+		TenantID:  "tenant1",
+	})
+
+	testCases := map[string]struct {
+		mapping map[string]interface{}
+
+		res *model.GeohashGrid
+		err error
+	}{
+		"ok": {
+			mapping: map[string]interface{}{
+				"type": "keyword",
+				"fields": map[string]interface{}{
+					"geo": map[string]interface{}{"type": "geo_point"},
+				},
+			},
+			res: &model.GeohashGrid{
+				Cells: []model.GeohashCell{{Key: "u4pr", Count: 4}},
+			},
+		},
+		"error, not mapped as geo_point": {
+			mapping: map[string]interface{}{"type": "keyword"},
+			err:     model.ErrNotGeoPointAttribute,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			store := new(mstore.Store)
+			store.On("GetDevIndex", contextMatcher, "tenant1").
+				Return(map[string]interface{}{
+					"mappings": map[string]interface{}{
+						"properties": map[string]interface{}{
+							"inventory_location_str": tc.mapping,
+						},
+					},
+				}, nil)
+			if tc.err == nil {
+				store.On("Search", contextMatcher, q).
+					Return(model.M{"aggregations": map[string]interface{}{
+						"geohash_grid": map[string]interface{}{
+							"buckets": []interface{}{
+								map[string]interface{}{
+									"key":       "u4pr",
+									"doc_count": float64(4),
+								},
+							},
+						},
+					}}, nil)
+			}
+			defer store.AssertExpectations(t)
+
+			app := NewApp(store, nil, nil, WithMaxBuckets(100))
+			res, err := app.GetGeohashGrid(context.Background(), params)
+			if tc.err != nil {
+				assert.True(t, errors.Is(err, tc.err))
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.res, res)
+			}
+		})
+	}
+}
+
+func TestInventorySearchDevicesInfo(t *testing.T) {
+	t.Parallel()
+	profile := map[string]interface{}{
+		"shards": []interface{}{
+			map[string]interface{}{"id": "[node1][devices][0]"},
+		},
+	}
+	testCases := map[string]struct {
+		params *model.SearchParams
+		esRes  model.M
+
+		query map[string]interface{}
+		info  *model.SearchInfo
+	}{
+		"ok, profile": {
+			params: &model.SearchParams{
+				TenantID: "tenant1",
+				Profile:  true,
+			},
+			esRes: model.M{"profile": profile},
+
+			query: map[string]interface{}{"profile": true},
+			info:  &model.SearchInfo{Profile: profile},
+		},
+		"ok, terminated early": {
+			params: &model.SearchParams{
+				TenantID:       "tenant1",
+				TerminateAfter: 100,
+			},
+			esRes: model.M{"terminated_early": true},
+
+			query: map[string]interface{}{"terminate_after": float64(100)},
+			info:  &model.SearchInfo{TerminatedEarly: true},
+		},
+		"ok, not terminated early": {
+			params: &model.SearchParams{
+				TenantID:       "tenant1",
+				TerminateAfter: 100,
+			},
+			esRes: model.M{"terminated_early": false},
+
+			query: map[string]interface{}{"terminate_after": float64(100)},
+			info:  &model.SearchInfo{},
+		},
+		"ok, timed out": {
+			params: &model.SearchParams{
+				TenantID: "tenant1",
+				Timeout:  "2s",
+			},
+			esRes: model.M{"timed_out": true},
+
+			query: map[string]interface{}{"timeout": "2s"},
+			info:  &model.SearchInfo{TimedOut: true},
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			esRes := model.M{
+				"hits": map[string]interface{}{
+					"hits": []interface{}{
+						map[string]interface{}{"_source": map[string]interface{}{
+							"id":       "dev1",
+							"tenantID": "tenant1",
+						}},
+					},
+					"total": map[string]interface{}{
+						"value": float64(1),
+					},
+				},
+			}
+			for k, v := range tc.esRes {
+				esRes[k] = v
+			}
+
+			store := new(mstore.Store)
+			store.On("Search", contextMatcher, mock.MatchedBy(func(q model.Query) bool {
+				b, _ := json.Marshal(q)
+				var body map[string]interface{}
+				_ = json.Unmarshal(b, &body)
+				for k, v := range tc.query {
+					if !assert.ObjectsAreEqual(v, body[k]) {
+						return false
+					}
+				}
+				return true
+			})).Return(esRes, nil)
+			defer store.AssertExpectations(t)
+
+			app := NewApp(store, nil, nil)
+			res, total, info, err := app.InventorySearchDevicesInfo(
+				context.Background(), tc.params)
+			assert.NoError(t, err)
+			assert.Equal(t, 1, total)
+			assert.Equal(t, []model.InvDevice{{
+				ID:         "dev1",
+				Attributes: model.DeviceAttributes{},
+			}}, res)
+			assert.Equal(t, tc.info, info)
+		})
+	}
+}
+
+func TestInventorySearchDevicesHasMore(t *testing.T) {
+	t.Parallel()
+
+	hit := func(id string) interface{} {
+		return map[string]interface{}{
+			"_source": map[string]interface{}{"id": id},
+		}
+	}
+	testCases := map[string]struct {
+		trackTotalHits *model.TrackTotalHits
+		hits           []interface{}
+		total          interface{}
+
+		size    float64
+		devices int
+		count   int
+		hasMore bool
+	}{
+		"ok, has more": {
+			trackTotalHits: &model.TrackTotalHits{},
+			hits:           []interface{}{hit("dev1"), hit("dev2"), hit("dev3")},
+
+			size:    3,
+			devices: 2,
+			hasMore: true,
+		},
+		"ok, last page": {
+			trackTotalHits: &model.TrackTotalHits{},
+			hits:           []interface{}{hit("dev1"), hit("dev2")},
+
+			size:    3,
+			devices: 2,
+		},
+		"ok, counted up to a threshold": {
+			trackTotalHits: &model.TrackTotalHits{Enabled: true, UpTo: 2},
+			hits:           []interface{}{hit("dev1"), hit("dev2"), hit("dev3")},
+			total:          map[string]interface{}{"value": float64(2), "relation": "gte"},
+
+			size:    3,
+			devices: 2,
+			count:   2,
+			hasMore: true,
+		},
+		"ok, all counted": {
+			hits:  []interface{}{hit("dev1"), hit("dev2")},
+			total: map[string]interface{}{"value": float64(5), "relation": "eq"},
+
+			size:    2,
+			devices: 2,
+			count:   5,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			hits := map[string]interface{}{"hits": tc.hits}
+			if tc.total != nil {
+				hits["total"] = tc.total
+			}
+
+			store := new(mstore.Store)
+			store.On("Search", contextMatcher, mock.MatchedBy(func(q model.Query) bool {
+				b, _ := json.Marshal(q)
+				var body map[string]interface{}
+				_ = json.Unmarshal(b, &body)
+				return body["size"] == tc.size
+			})).Return(model.M{"hits": hits}, nil)
+			defer store.AssertExpectations(t)
+
+			app := NewApp(store, nil, nil)
+			res, total, info, err := app.InventorySearchDevicesInfo(
+				context.Background(), &model.SearchParams{
+					Page:           1,
+					PerPage:        2,
+					TrackTotalHits: tc.trackTotalHits,
+				})
+			assert.NoError(t, err)
+			assert.Len(t, res, tc.devices)
+			assert.Equal(t, tc.count, total)
+			assert.Equal(t, tc.hasMore, info.HasMore)
+		})
+	}
+}
+
+func TestValidateSearch(t *testing.T) {
+	t.Parallel()
+
+	params := &model.SearchParams{
+		TenantID: "tenant1",
+		Filters: []model.FilterPredicate{{
+			Scope:     "inventory",
+			Attribute: "mac",
+			Type:      "$eq",
+			Value:     "00:11:22:33:44:55",
+		}},
+		Page:    1,
+		PerPage: 20,
+	}
+
+	t.Run("ok, valid", func(t *testing.T) {
+		t.Parallel()
+
+		var query map[string]interface{}
+		store := new(mstore.Store)
+		store.On("ValidateQuery", contextMatcher, mock.Anything).
+			Run(func(args mock.Arguments) {
+				b, _ := json.Marshal(args.Get(1))
+				_ = json.Unmarshal(b, &query)
+			}).
+			Return(&model.QueryValidation{Valid: true}, nil).
+			Once()
+		defer store.AssertExpectations(t)
+
+		app := NewApp(store, nil, nil)
+		res, err := app.ValidateSearch(context.Background(), params)
+		assert.NoError(t, err)
+		assert.Equal(t, &model.QueryValidation{Valid: true}, res)
+
+		// the query is restricted to the tenant, like the search
+		must := query["query"].(map[string]interface{})["bool"].(map[string]interface{})["must"]
+		assert.Contains(t, must, map[string]interface{}{
+			"term": map[string]interface{}{"tenantID": "tenant1"},
+		})
+	})
+
+	t.Run("ok, invalid", func(t *testing.T) {
+		t.Parallel()
+
+		validation := &model.QueryValidation{
+			Error: "failed to parse date field [yesterday]",
+		}
+		store := new(mstore.Store)
+		store.On("ValidateQuery", contextMatcher, mock.Anything).
+			Return(validation, nil).
+			Once()
+		defer store.AssertExpectations(t)
+
+		app := NewApp(store, nil, nil)
+		res, err := app.ValidateSearch(context.Background(), params)
+		assert.NoError(t, err)
+		assert.Equal(t, validation, res)
+	})
+
+	t.Run("error, store", func(t *testing.T) {
+		t.Parallel()
+
+		store := new(mstore.Store)
+		store.On("ValidateQuery", contextMatcher, mock.Anything).
+			Return(nil, errors.New("connection refused")).
+			Once()
+		defer store.AssertExpectations(t)
+
+		app := NewApp(store, nil, nil)
+		_, err := app.ValidateSearch(context.Background(), params)
+		assert.EqualError(t, err, "connection refused")
+	})
+}
+
+func TestDevicesExist(t *testing.T) {
+	t.Parallel()
+
+	store := new(mstore.Store)
+	store.On("DevicesExist", contextMatcher, "tenant1", []string{"dev1", "dev2", "dev3"}).
+		Return([]string{"dev1", "dev3"}, nil).
+		Once()
+	store.On("DevicesExist", contextMatcher, "tenant2", []string{"dev4"}).
+		Return(nil, errors.New("mget failed")).
+		Once()
+	defer store.AssertExpectations(t)
+
+	app := NewApp(store, nil, nil)
+	res, err := app.DevicesExist(context.Background(), &model.DevicesExistParams{
+		TenantID:  "tenant1",
+		DeviceIDs: []string{"dev1", "dev2", "dev3"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, &model.DevicesExistResult{DeviceIDs: []string{"dev1", "dev3"}}, res)
+
+	_, err = app.DevicesExist(context.Background(), &model.DevicesExistParams{
+		TenantID:  "tenant2",
+		DeviceIDs: []string{"dev4"},
+	})
+	assert.EqualError(t, err, "mget failed")
+}
+
+func TestGetRawAggregations(t *testing.T) {
+	t.Parallel()
+	aggs := map[string]interface{}{
+		"os": map[string]interface{}{
+			"terms": map[string]interface{}{"field": "inventory_os_str"},
+		},
+	}
+	osBuckets := map[string]interface{}{
+		"buckets": []interface{}{
+			map[string]interface{}{"key": "linux", "doc_count": float64(3)},
+		},
+	}
+
+	// the aggregations only run over the tenant's devices
+	q := model.BuildRawAggsQuery(model.RawAggsParams{TenantID: "tenant1", Aggs: aggs})
+	store := new(mstore.Store)
+	store.On("Search", contextMatcher, q).
+		Return(model.M{"aggregations": map[string]interface{}{"os": osBuckets}}, nil).
+		Once()
+	q2 := model.BuildRawAggsQuery(model.RawAggsParams{TenantID: "tenant2", Aggs: aggs})
+	store.On("Search", contextMatcher, q2).
+		Return(model.M{}, nil).
+		Once()
+	defer store.AssertExpectations(t)
+
+	app := NewApp(store, nil, nil)
+	res, err := app.GetRawAggregations(context.Background(), &model.RawAggsParams{
+		TenantID: "tenant1",
+		Aggs:     aggs,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, model.M{"os": osBuckets}, res)
+
+	// no aggregations in the response
+	res, err = app.GetRawAggregations(context.Background(), &model.RawAggsParams{
+		TenantID: "tenant2",
+		Aggs:     aggs,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, model.M{}, res)
+}
+
+func TestMaxClauseCount(t *testing.T) {
+	t.Parallel()
+
+	filter := func(n int) []model.FilterPredicate {
+		values := make([]interface{}, n)
+		for i := range values {
+			values[i] = fmt.Sprintf("dev%d", i)
+		}
+		return []model.FilterPredicate{{
+			Scope:     "inventory",
+			Attribute: "serial",
+			Type:      "$in",
+			Value:     values,
+		}}
+	}
+
+	var query map[string]interface{}
+	store := new(mstore.Store)
+	store.On("ValidateQuery", contextMatcher, mock.Anything).
+		Run(func(args mock.Arguments) {
+			b, _ := json.Marshal(args.Get(1))
+			_ = json.Unmarshal(b, &query)
+		}).
+		Return(&model.QueryValidation{Valid: true}, nil).
+		Once()
+	defer store.AssertExpectations(t)
+
+	app := NewApp(store, nil, nil, WithMaxClauseCount(2))
+
+	// the large $in is split in clauses of at most 2 values
+	_, err := app.ValidateSearch(context.Background(), &model.SearchParams{
+		TenantID: "tenant1",
+		Filters:  filter(3),
+	})
+	assert.NoError(t, err)
+	must := query["query"].(map[string]interface{})["bool"].(map[string]interface{})["must"]
+	assert.Contains(t, must, map[string]interface{}{
+		"bool": map[string]interface{}{
+			"should": []interface{}{
+				map[string]interface{}{"terms": map[string]interface{}{
+					"inventory_serial_str": []interface{}{"dev0", "dev1"},
+				}},
+				map[string]interface{}{"terms": map[string]interface{}{
+					"inventory_serial_str": []interface{}{"dev2"},
+				}},
+			},
+			"minimum_should_match": float64(1),
+		},
+	})
+
+	// more than 2 clauses would be needed
+	_, err = app.ValidateSearch(context.Background(), &model.SearchParams{
+		TenantID: "tenant1",
+		Filters:  filter(5),
+	})
+	assert.True(t, errors.Is(err, model.ErrTooManyValues))
+
+	// the export splits it the same way
+	query = nil
+	store.On("Search", contextMatcher, mock.Anything).
+		Run(func(args mock.Arguments) {
+			b, _ := json.Marshal(args.Get(1))
+			_ = json.Unmarshal(b, &query)
+		}).
+		Return(model.M{"hits": map[string]interface{}{
+			"hits":  []interface{}{},
+			"total": map[string]interface{}{"value": float64(0)},
+		}}, nil).
+		Once()
+	err = app.ExportDevices(context.Background(), &model.SearchParams{
+		TenantID: "tenant1",
+		Filters:  filter(3),
+	}, func(*model.InvDevice) error { return nil })
+	assert.NoError(t, err)
+	must = query["query"].(map[string]interface{})["bool"].(map[string]interface{})["must"]
+	assert.Contains(t, must, map[string]interface{}{
+		"bool": map[string]interface{}{
+			"should": []interface{}{
+				map[string]interface{}{"terms": map[string]interface{}{
+					"inventory_serial_str": []interface{}{"dev0", "dev1"},
+				}},
+				map[string]interface{}{"terms": map[string]interface{}{
+					"inventory_serial_str": []interface{}{"dev2"},
+				}},
+			},
+			"minimum_should_match": float64(1),
+		},
+	})
+
+	// and the scan refuses it alike
+	_, err = app.ScanDevices(context.Background(), &model.ScanParams{
+		SearchParams: model.SearchParams{
+			TenantID: "tenant1",
+			Filters:  filter(5),
+		},
+	})
+	assert.True(t, errors.Is(err, model.ErrTooManyValues))
+}
+
+func TestSortOrders(t *testing.T) {
+	t.Parallel()
+
+	var query map[string]interface{}
+	store := new(mstore.Store)
+	store.On("ValidateQuery", contextMatcher, mock.Anything).
+		Run(func(args mock.Arguments) {
+			b, _ := json.Marshal(args.Get(1))
+			_ = json.Unmarshal(b, &query)
+		}).
+		Return(&model.QueryValidation{Valid: true}, nil).
+		Once()
+	defer store.AssertExpectations(t)
+
+	app := NewApp(store, nil, nil, WithSortOrders(map[string][]string{
+		"Inventory/Severity": {"critical", "warning", "info"},
+	}))
+
+	_, err := app.ValidateSearch(context.Background(), &model.SearchParams{
+		TenantID: "tenant1",
+		Sort: []model.SortCriteria{{
+			Scope:     "inventory",
+			Attribute: "severity",
+			Order:     "asc",
+		}},
+	})
+	assert.NoError(t, err)
+	sort := query["sort"].([]interface{})
+	if assert.Len(t, sort, 1) {
+		script := sort[0].(map[string]interface{})["_script"].(map[string]interface{})
+		assert.Equal(t, "asc", script["order"])
+		assert.Equal(t, map[string]interface{}{
+			"field": "inventory_severity_str",
+			"ranks": map[string]interface{}{
+				"critical": float64(0),
+				"warning":  float64(1),
+				"info":     float64(2),
+			},
+			"missing": float64(3),
+		}, script["script"].(map[string]interface{})["params"])
+	}
+
+	// the scan sorts in the same order, then by id
+	query = nil
+	store.On("OpenPointInTime", contextMatcher, "tenant1", mock.Anything).
+		Return("pit1", nil).
+		Once()
+	store.On("Search", contextMatcher, mock.Anything).
+		Run(func(args mock.Arguments) {
+			b, _ := json.Marshal(args.Get(1))
+			_ = json.Unmarshal(b, &query)
+		}).
+		Return(model.M{"hits": map[string]interface{}{
+			"hits":  []interface{}{},
+			"total": map[string]interface{}{"value": float64(0)},
+		}}, nil).
+		Once()
+	store.On("ClosePointInTime", contextMatcher, "pit1").
+		Return(nil).
+		Once()
+	_, err = app.ScanDevices(context.Background(), &model.ScanParams{
+		SearchParams: model.SearchParams{
+			TenantID: "tenant1",
+			PerPage:  10,
+			Sort: []model.SortCriteria{{
+				Scope:     "inventory",
+				Attribute: "severity",
+				Order:     "asc",
+			}},
+		},
+	})
+	assert.NoError(t, err)
+	scanSort := query["sort"].([]interface{})
+	if assert.Len(t, scanSort, 2) {
+		assert.Equal(t, sort[0], scanSort[0])
+		assert.Equal(t, map[string]interface{}{"id": "asc"}, scanSort[1])
+	}
+}
+
+func TestComplexityLimits(t *testing.T) {
+	t.Parallel()
+
+	eq := func(value string) []model.FilterPredicate {
+		return []model.FilterPredicate{{
+			Scope:     "inventory",
+			Attribute: "os",
+			Type:      "$eq",
+			Value:     value,
+		}}
+	}
+
+	store := new(mstore.Store)
+	store.On("ValidateQuery", contextMatcher, mock.Anything).
+		Return(&model.QueryValidation{Valid: true}, nil).
+		Once()
+	defer store.AssertExpectations(t)
+
+	// a filter and the tenant term are 2 clauses of the top-level bool,
+	// the or groups nest the bool queries 3 deep
+	app := NewApp(store, nil, nil, WithComplexityLimits(model.ComplexityLimits{
+		MaxClauses: 2,
+		MaxDepth:   1,
+	}))
+
+	_, err := app.ValidateSearch(context.Background(), &model.SearchParams{
+		TenantID: "tenant1",
+		Filters:  eq("linux"),
+	})
+	assert.NoError(t, err)
+
+	_, err = app.ValidateSearch(context.Background(), &model.SearchParams{
+		TenantID: "tenant1",
+		Or:       [][]model.FilterPredicate{eq("linux"), eq("bsd")},
+	})
+	assert.True(t, errors.Is(err, model.ErrQueryTooComplex))
+}
@@ -0,0 +1,120 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package reporting
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/reporting/client/inventory"
+	"github.com/mendersoftware/reporting/model"
+)
+
+var (
+	ErrSourceDeviceNotFound = errors.New("device not found in the source service")
+
+	// serviceScopes are the attribute scopes owned by each source service;
+	// reindexing a service only replaces its scopes of the indexed device
+	serviceScopes = map[string][]string{
+		SvcInventory: {
+			model.AttrScopeInventory,
+			model.AttrScopeSystem,
+			model.AttrScopeTags,
+		},
+		SvcDeviceauth: {model.AttrScopeIdentity},
+	}
+)
+
+// servicesScopes returns the scopes owned by the services; false if any
+// of the services doesn't have known scopes, i.e. the whole device must
+// be replaced
+func servicesScopes(services []string) ([]string, bool) {
+	if len(services) == 0 {
+		return nil, false
+	}
+	var scopes []string
+	for _, service := range services {
+		s, ok := serviceScopes[service]
+		if !ok {
+			return nil, false
+		}
+		scopes = append(scopes, s...)
+	}
+	return scopes, true
+}
+
+// SourceClient fetches the current state of a device from a source service,
+// i.e. a service owning (some of) the device's attributes
+type SourceClient interface {
+	FetchDevice(ctx context.Context, tenantID, deviceID string) (*model.Device, error)
+}
+
+// WithSourceClient registers the client of the source service name;
+// the reindex requests are only accepted for the registered services
+func WithSourceClient(name string, client SourceClient) AppOption {
+	return func(app *app) {
+		app.sources[name] = client
+	}
+}
+
+// defaultSources returns the default registry of the source clients:
+// the inventory also holds the identity data and status of the devices,
+// owned by deviceauth
+func defaultSources(client inventory.Client) map[string]SourceClient {
+	sources := map[string]SourceClient{}
+	if client != nil {
+		inv := NewInventorySource(client)
+		sources[SvcInventory] = inv
+		sources[SvcDeviceauth] = inv
+	}
+	return sources
+}
+
+// sourceClient resolves the service name to its registered client
+func (app *app) sourceClient(service string) (SourceClient, error) {
+	client, ok := app.sources[service]
+	if !ok {
+		return nil, ErrUnknownService
+	}
+	return client, nil
+}
+
+// inventorySource fetches the devices from the inventory service
+type inventorySource struct {
+	client inventory.Client
+}
+
+// NewInventorySource returns the SourceClient of the inventory service
+func NewInventorySource(client inventory.Client) SourceClient {
+	return &inventorySource{client: client}
+}
+
+func (s *inventorySource) FetchDevice(
+	ctx context.Context,
+	tenantID,
+	deviceID string,
+) (*model.Device, error) {
+	devs, err := s.client.GetDevices(ctx, tenantID, []string{deviceID})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch the device from inventory")
+	}
+	for i := range devs {
+		if string(devs[i].ID) == deviceID {
+			return model.NewDeviceFromInv(tenantID, &devs[i])
+		}
+	}
+	return nil, ErrSourceDeviceNotFound
+}
@@ -0,0 +1,334 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package reporting
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	minventory "github.com/mendersoftware/reporting/client/inventory/mocks"
+	"github.com/mendersoftware/reporting/model"
+	"github.com/mendersoftware/reporting/store"
+	mstore "github.com/mendersoftware/reporting/store/mocks"
+)
+
+type fakeSource struct {
+	dev *model.Device
+	err error
+}
+
+func (s *fakeSource) FetchDevice(
+	ctx context.Context,
+	tenantID,
+	deviceID string,
+) (*model.Device, error) {
+	return s.dev, s.err
+}
+
+func TestSourceClientRegistration(t *testing.T) {
+	custom := &fakeSource{}
+	a := NewApp(nil, new(minventory.Client), nil,
+		WithSourceClient("custom", custom)).(*app)
+
+	// the inventory backs both inventory and deviceauth by default
+	inv, err := a.sourceClient(SvcInventory)
+	assert.NoError(t, err)
+	assert.IsType(t, &inventorySource{}, inv)
+	devauth, err := a.sourceClient(SvcDeviceauth)
+	assert.NoError(t, err)
+	assert.Equal(t, inv, devauth)
+
+	client, err := a.sourceClient("custom")
+	assert.NoError(t, err)
+	assert.Equal(t, custom, client)
+
+	_, err = a.sourceClient("elasticbogaloo")
+	assert.Equal(t, ErrUnknownService, err)
+
+	// no inventory client, no default sources
+	noInv := NewApp(nil, nil, nil).(*app)
+	_, err = noInv.sourceClient(SvcInventory)
+	assert.Equal(t, ErrUnknownService, err)
+}
+
+func TestReindexSourceDispatch(t *testing.T) {
+	ri := NewReindexer(&ReindexerConfig{BuffLen: 2}, nil, nil)
+	ri.inChan = buffer(ri.conf.BuffLen)
+	app := NewApp(nil, nil, ri, WithSourceClient("custom", &fakeSource{}))
+
+	err := app.Reindex(context.Background(), "t1", "d1", "custom")
+	assert.NoError(t, err)
+	if assert.Len(t, ri.inChan, 1) {
+		assert.Equal(t, reindexReq{
+			Tenant:   "t1",
+			Device:   "d1",
+			Services: []string{"custom"},
+		}, <-ri.inChan)
+	}
+
+	err = app.Reindex(context.Background(), "t1", "d1", SvcInventory)
+	assert.Equal(t, ErrUnknownService, err)
+	assert.Len(t, ri.inChan, 0)
+}
+
+func TestReindexerFetchSource(t *testing.T) {
+	custom := &fakeSource{dev: model.NewDevice("d1").SetTenantID("t1")}
+	failing := &fakeSource{err: errors.New("connection refused")}
+
+	st := new(mstore.Store)
+	defer st.AssertExpectations(t)
+	st.On("GetDevicesIndex", "t1").Return("devices")
+	st.On("GetDevicesRoutingKey", "t1").Return("t1")
+	st.On("GetHistoryIndex", "t1").Return("")
+	// the device whose source failed isn't looked up, nor deleted
+	st.On("GetDevices", contextMatcher, map[string][]string{"t1": {"d1"}}).
+		Return([]model.Device{}, nil)
+
+	// the inventory isn't queried for the other services' devices
+	inv := new(minventory.Client)
+	defer inv.AssertExpectations(t)
+
+	ri := NewReindexer(&ReindexerConfig{BuffLen: 2}, inv, st)
+	NewApp(st, inv, ri,
+		WithSourceClient("custom", custom),
+		WithSourceClient("failing", failing))
+
+	in := make(chan []reindexReq, 1)
+	out := fetch(in, ri.source, ri.inventory, ri.store)
+	in <- []reindexReq{
+		{Tenant: "t1", Device: "d1", Services: []string{"custom"}},
+		{Tenant: "t1", Device: "d2", Services: []string{"failing"}},
+	}
+
+	jobs := <-out
+	if assert.Len(t, jobs, 1) {
+		item, err := merge(&jobs[0])
+		assert.NoError(t, err)
+		assert.Equal(t, "create", item.Action.Type)
+		assert.Equal(t, custom.dev, item.Doc)
+	}
+}
+
+func TestReindexDevice(t *testing.T) {
+	newDevice := func(attrs ...*model.InventoryAttribute) *model.Device {
+		dev := model.NewDevice("dev1").SetTenantID("tenant1")
+		for _, a := range attrs {
+			_ = dev.AppendAttr(a)
+		}
+		return dev
+	}
+	attr := func(scope, name, val string) *model.InventoryAttribute {
+		return model.NewInventoryAttribute(scope).SetName(name).SetString(val)
+	}
+	indexed := func() *model.Device {
+		return newDevice(
+			attr(model.AttrScopeIdentity, "mac", "00:11"),
+			attr(model.AttrScopeInventory, "os", "linux"),
+			attr(model.AttrScopeInventory, "kernel", "5.10"),
+		).WithMeta(&model.DeviceMeta{SeqNo: 3, PrimaryTerm: 1})
+	}
+
+	testCases := map[string]struct {
+		indexed   *model.Device
+		source    *model.Device
+		sourceErr error
+		bulkErrs  []store.BulkItemError
+
+		action string
+		res    *model.ReindexResult
+		err    error
+	}{
+		"ok, update": {
+			indexed: indexed(),
+			source: newDevice(
+				attr(model.AttrScopeInventory, "os", "linux"),
+				attr(model.AttrScopeInventory, "kernel", "5.15"),
+			),
+
+			action: "index",
+			// the identity scope isn't owned by the inventory, it's kept
+			res: &model.ReindexResult{
+				DeviceID: "dev1",
+				Changes: []model.AttributeChange{{
+					Scope:    model.AttrScopeInventory,
+					Name:     "kernel",
+					OldValue: []string{"5.10"},
+					NewValue: []string{"5.15"},
+				}},
+			},
+		},
+		"ok, first index": {
+			source: newDevice(attr(model.AttrScopeInventory, "os", "linux")),
+
+			action: "create",
+			res: &model.ReindexResult{
+				DeviceID: "dev1",
+				Created:  true,
+				Changes: []model.AttributeChange{{
+					Scope:    model.AttrScopeInventory,
+					Name:     "os",
+					NewValue: []string{"linux"},
+				}},
+			},
+		},
+		"ok, removed from the source": {
+			indexed:   indexed(),
+			sourceErr: ErrSourceDeviceNotFound,
+
+			action: "delete",
+			res: &model.ReindexResult{
+				DeviceID: "dev1",
+				Deleted:  true,
+				Changes: []model.AttributeChange{{
+					Scope:    model.AttrScopeIdentity,
+					Name:     "mac",
+					OldValue: []string{"00:11"},
+				}, {
+					Scope:    model.AttrScopeInventory,
+					Name:     "kernel",
+					OldValue: []string{"5.10"},
+				}, {
+					Scope:    model.AttrScopeInventory,
+					Name:     "os",
+					OldValue: []string{"linux"},
+				}},
+			},
+		},
+		"error, device not found": {
+			sourceErr: ErrSourceDeviceNotFound,
+
+			err: errors.New("device not found: dev1"),
+		},
+		"error, update conflict": {
+			indexed: indexed(),
+			source:  newDevice(attr(model.AttrScopeInventory, "os", "linux")),
+			bulkErrs: []store.BulkItemError{{
+				Item: store.BulkItem{Action: &store.BulkAction{
+					Type: "index",
+					Desc: &store.BulkActionDesc{ID: "dev1"},
+				}},
+				Status: 409,
+				Type:   "version_conflict_engine_exception",
+				Reason: "version conflict",
+			}},
+
+			action: "index",
+			err: errors.New("bulk index of dev1 failed, code 409: " +
+				"version_conflict_engine_exception: version conflict"),
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var devs []model.Device
+			if tc.indexed != nil {
+				devs = []model.Device{*tc.indexed}
+			}
+			st := new(mstore.Store)
+			st.On("GetDevices", contextMatcher,
+				map[string][]string{"tenant1": {"dev1"}}).
+				Return(devs, nil)
+			if tc.action != "" {
+				st.On("GetDevicesIndex", "tenant1").Return("devices")
+				st.On("GetDevicesRoutingKey", "tenant1").Return("tenant1")
+				st.On("GetHistoryIndex", "tenant1").Return("")
+				st.On("BulkIndex", contextMatcher,
+					mock.MatchedBy(func(items []store.BulkItem) bool {
+						if !assert.Len(t, items, 1) {
+							return false
+						}
+						action := items[0].Action
+						assert.Equal(t, tc.action, action.Type)
+						assert.Equal(t, "dev1", action.Desc.ID)
+						if tc.indexed != nil {
+							assert.Equal(t, int64(3), action.Desc.IfSeqNo)
+							assert.Equal(t, int64(1), action.Desc.IfPrimaryTerm)
+						}
+						return true
+					})).
+					Return(tc.bulkErrs, nil)
+			}
+			defer st.AssertExpectations(t)
+
+			app := NewApp(st, nil, nil, WithSourceClient(SvcInventory,
+				&fakeSource{dev: tc.source, err: tc.sourceErr}))
+			res, err := app.ReindexDevice(context.Background(),
+				"tenant1", "dev1", SvcInventory)
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.res, res)
+			}
+		})
+	}
+}
+
+func TestInventorySourceFetchDevice(t *testing.T) {
+	testCases := map[string]struct {
+		invDevs []model.InvDevice
+		invErr  error
+
+		dev *model.Device
+		err error
+	}{
+		"ok": {
+			invDevs: []model.InvDevice{{
+				ID: "dev1",
+				Attributes: model.DeviceAttributes{
+					{Scope: "inventory", Name: "os", Value: "linux"},
+				},
+			}},
+			dev: func() *model.Device {
+				dev, _ := model.NewDeviceFromInv("tenant1", &model.InvDevice{
+					ID: "dev1",
+					Attributes: model.DeviceAttributes{
+						{Scope: "inventory", Name: "os", Value: "linux"},
+					},
+				})
+				return dev
+			}(),
+		},
+		"error, not found": {
+			invDevs: []model.InvDevice{},
+			err:     ErrSourceDeviceNotFound,
+		},
+		"error, inventory": {
+			invErr: errors.New("connection refused"),
+			err: errors.New("failed to fetch the device from inventory: " +
+				"connection refused"),
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			inv := new(minventory.Client)
+			inv.On("GetDevices", contextMatcher, "tenant1", []string{"dev1"}).
+				Return(tc.invDevs, tc.invErr)
+			defer inv.AssertExpectations(t)
+
+			dev, err := NewInventorySource(inv).FetchDevice(
+				context.Background(), "tenant1", "dev1")
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.dev, dev)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package reporting
+
+import (
+	"context"
+	"time"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+
+	"github.com/mendersoftware/reporting/model"
+)
+
+// versionCacheTTL is how long the versions of the dependencies are cached
+const versionCacheTTL = 30 * time.Second
+
+// BuildVersion is the version of the service, set at build time with:
+// -ldflags "-X github.com/mendersoftware/reporting/app/reporting.BuildVersion=<version>"
+var BuildVersion = model.VersionUnknown
+
+// GetVersion returns the build version and the versions of the dependencies;
+// a dependency which can't be queried is reported with an unknown version,
+// which isn't cached, so that it's queried again on the next request
+func (app *app) GetVersion(ctx context.Context) *model.Version {
+	app.versionMu.Lock()
+	defer app.versionMu.Unlock()
+
+	if app.version != nil && time.Now().Before(app.versionExpiry) {
+		return app.version
+	}
+
+	ver := &model.Version{
+		Version:       BuildVersion,
+		Elasticsearch: model.VersionUnknown,
+	}
+
+	esVersion, err := app.store.GetVersion(ctx)
+	if err != nil {
+		log.FromContext(ctx).Warnf("failed to get the Elasticsearch version: %v", err)
+		return ver
+	}
+	ver.Elasticsearch = esVersion
+
+	app.version = ver
+	app.versionExpiry = time.Now().Add(versionCacheTTL)
+	return ver
+}
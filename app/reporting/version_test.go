@@ -0,0 +1,59 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package reporting
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/reporting/model"
+	mstore "github.com/mendersoftware/reporting/store/mocks"
+)
+
+func TestGetVersion(t *testing.T) {
+	store := new(mstore.Store)
+	store.On("GetVersion", contextMatcher).
+		Return("", errors.New("connection refused")).Once()
+	store.On("GetVersion", contextMatcher).
+		Return("7.15.1", nil).Once()
+	defer store.AssertExpectations(t)
+
+	app := NewApp(store, nil, nil).(*app)
+
+	// ES is unreachable, the failure isn't cached
+	ver := app.GetVersion(context.Background())
+	assert.Equal(t, &model.Version{
+		Version:       BuildVersion,
+		Elasticsearch: model.VersionUnknown,
+	}, ver)
+
+	ver = app.GetVersion(context.Background())
+	assert.Equal(t, "7.15.1", ver.Elasticsearch)
+
+	// cached
+	ver = app.GetVersion(context.Background())
+	assert.Equal(t, "7.15.1", ver.Elasticsearch)
+
+	// expired, ES is queried again
+	app.versionExpiry = time.Now().Add(-time.Second)
+	store.On("GetVersion", contextMatcher).
+		Return("7.16.0", nil).Once()
+	ver = app.GetVersion(context.Background())
+	assert.Equal(t, "7.16.0", ver.Elasticsearch)
+}
@@ -16,6 +16,7 @@ package server
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
@@ -29,9 +30,11 @@ import (
 	"github.com/mendersoftware/go-lib-micro/log"
 
 	api "github.com/mendersoftware/reporting/api/http"
+	"github.com/mendersoftware/reporting/app/indexer"
 	"github.com/mendersoftware/reporting/app/reporting"
 	"github.com/mendersoftware/reporting/client/inventory"
 	dconfig "github.com/mendersoftware/reporting/config"
+	"github.com/mendersoftware/reporting/model"
 	"github.com/mendersoftware/reporting/store"
 )
 
@@ -57,28 +60,109 @@ func InitAndRun(conf config.Reader, store store.Store) error {
 		false,
 	)
 
+	tenantQuotas, err := indexer.ParseTenantQuotas(
+		conf.GetStringSlice(dconfig.SettingReindexTenantQuotas))
+	if err != nil {
+		return err
+	}
+
 	reindexer := reporting.NewReindexer(
 		&reporting.ReindexerConfig{
-			NumWorkers:  conf.GetInt(dconfig.SettingReindexNumWorkers),
-			BatchSize:   conf.GetInt(dconfig.SettingReindexBatchSize),
-			MaxTimeMsec: conf.GetInt(dconfig.SettingReindexMaxTimeMsec),
-			BuffLen:     conf.GetInt(dconfig.SettingReindexBuffLen),
+			NumWorkers:   conf.GetInt(dconfig.SettingReindexNumWorkers),
+			BatchSize:    conf.GetInt(dconfig.SettingReindexBatchSize),
+			MaxTimeMsec:  conf.GetInt(dconfig.SettingReindexMaxTimeMsec),
+			BuffLen:      conf.GetInt(dconfig.SettingReindexBuffLen),
+			TenantRate:   conf.GetFloat64(dconfig.SettingReindexTenantRate),
+			TenantBurst:  conf.GetInt(dconfig.SettingReindexTenantBurst),
+			TenantQuotas: tenantQuotas,
+			BulkIndexer:  conf.GetBool(dconfig.SettingReindexBulkIndexer),
+			SlowBatchThreshold: time.Duration(conf.GetInt(
+				dconfig.SettingReindexSlowBatchThresholdMsec)) * time.Millisecond,
 		},
 		invClient,
 		store)
 
-	reporting := reporting.NewApp(store, invClient, reindexer)
-	err := reindexer.Run()
+	reporting := reporting.NewApp(store, invClient, reindexer,
+		reporting.WithMaxBuckets(conf.GetInt(dconfig.SettingAggregationMaxBuckets)),
+		reporting.WithAttributeMetadata(attributeMetadata(conf)),
+		reporting.WithSortOrders(sortOrders(conf)),
+		reporting.WithScanCursorTTL(time.Duration(
+			conf.GetInt(dconfig.SettingScanCursorTTLMsec))*time.Millisecond),
+		reporting.WithMaxConcurrentSearches(
+			conf.GetInt(dconfig.SettingMaxConcurrentSearches)),
+		reporting.WithReindexSuspendRefresh(
+			conf.GetBool(dconfig.SettingReindexSuspendRefresh)),
+		reporting.WithMaxClauseCount(conf.GetInt(dconfig.SettingSearchMaxClauseCount)),
+		reporting.WithComplexityLimits(model.ComplexityLimits{
+			MaxClauses: conf.GetInt(dconfig.SettingSearchMaxQueryClauses),
+			MaxDepth:   conf.GetInt(dconfig.SettingSearchMaxQueryDepth),
+		}))
+	err = reindexer.Run()
 	if err != nil {
 		return err
 	}
 
-	var router = api.NewRouter(reporting)
+	// the reconciler runs in the server process rather than in the indexer,
+	// which doesn't serve any API yet: its metrics are exposed by this
+	// process' internal API only
+	reconcileCtx, stopReconcile := context.WithCancel(ctx)
+	defer stopReconcile()
+	if conf.GetBool(dconfig.SettingReconcile) {
+		interval := conf.GetInt(dconfig.SettingReconcileIntervalMsec)
+		reconciler := indexer.NewReconciler(
+			indexer.ReconcilerConfig{
+				Interval:   time.Duration(interval) * time.Millisecond,
+				SampleSize: conf.GetInt(dconfig.SettingReconcileSampleSize),
+			},
+			store,
+			invClient)
+		go reconciler.Run(reconcileCtx)
+	}
+
+	disabledFeatures := conf.GetStringSlice(dconfig.SettingDisabledFeatures)
+	if err := api.ValidateFeatures(disabledFeatures); err != nil {
+		return err
+	}
+
+	var router = api.NewRouter(reporting,
+		api.WithIdentityConfig(api.IdentityConfig{
+			TenantClaim:   conf.GetString(dconfig.SettingTenantClaim),
+			TenantHeader:  conf.GetString(dconfig.SettingTenantHeader),
+			RequireTenant: conf.GetBool(dconfig.SettingRequireTenant),
+		}),
+		api.WithSearchProfile(conf.GetBool(dconfig.SettingSearchProfile)),
+		api.WithStrictDecoding(conf.GetBool(dconfig.SettingStrictDecoding)),
+		api.WithDisabledFeatures(disabledFeatures),
+		api.WithRawAggregations(api.RawAggregationsConfig{
+			Enabled:      conf.GetBool(dconfig.SettingRawAggregations),
+			AllowScripts: conf.GetBool(dconfig.SettingRawAggregationsAllowScripts),
+		}),
+		api.WithAggregationCache(api.AggregationCacheConfig{
+			TTL: time.Duration(conf.GetInt(
+				dconfig.SettingAggregationCacheTTLMsec)) * time.Millisecond,
+			MaxSize: conf.GetInt(dconfig.SettingAggregationCacheMaxSize),
+		}),
+		api.WithSearchLimit(api.SearchLimitConfig{
+			Status: conf.GetInt(dconfig.SettingMaxConcurrentSearchesStatus),
+			RetryAfter: time.Duration(conf.GetInt(
+				dconfig.SettingMaxConcurrentSearchesRetryAfterSec)) * time.Second,
+		}),
+		api.WithAttributeAccess(api.AttributeAccess{
+			RoleClaim: conf.GetString(dconfig.SettingRoleClaim),
+			Roles:     roleAttributes(conf),
+		}))
 	srv := &http.Server{
 		Addr:    listen,
 		Handler: router,
 	}
 
+	// the warm-up runs in the background, the server doesn't wait for it
+	go func() {
+		if err := store.WarmUp(ctx); err != nil {
+			l.Warnf("index warm-up failed: %v", err)
+		}
+	}()
+
 	go func() {
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			l.Fatalf("listen: %s\n", err)
@@ -90,6 +174,7 @@ func InitAndRun(conf config.Reader, store store.Store) error {
 	<-quit
 
 	l.Info("Shutdown Server ...")
+	stopReconcile()
 
 	ctxWithTimeout, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
@@ -99,3 +184,53 @@ func InitAndRun(conf config.Reader, store store.Store) error {
 
 	return nil
 }
+
+// roleAttributes parses the role attributes map from the configuration
+func roleAttributes(conf config.Reader) map[string][]string {
+	roles := make(map[string][]string)
+	for role, v := range conf.GetStringMap(dconfig.SettingRoleAttributes) {
+		patterns, _ := v.([]interface{})
+		for _, p := range patterns {
+			if pattern, ok := p.(string); ok {
+				roles[role] = append(roles[role], pattern)
+			}
+		}
+	}
+	return roles
+}
+
+// sortOrders parses the custom sort orders map from the configuration
+func sortOrders(conf config.Reader) map[string][]string {
+	orders := make(map[string][]string)
+	for key, v := range conf.GetStringMap(dconfig.SettingSortOrders) {
+		values, _ := v.([]interface{})
+		for _, val := range values {
+			orders[key] = append(orders[key], fmt.Sprint(val))
+		}
+	}
+	return orders
+}
+
+// attributeMetadata parses the attribute metadata map from the configuration
+func attributeMetadata(conf config.Reader) map[string]model.AttributeMetadata {
+	meta := make(map[string]model.AttributeMetadata)
+	for key, v := range conf.GetStringMap(dconfig.SettingAttributeMetadata) {
+		props := make(map[string]string)
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for k, val := range v {
+				props[k] = fmt.Sprint(val)
+			}
+		case map[interface{}]interface{}:
+			for k, val := range v {
+				props[fmt.Sprint(k)] = fmt.Sprint(val)
+			}
+		}
+		meta[key] = model.AttributeMetadata{
+			DisplayName: props["display_name"],
+			Description: props["description"],
+			Unit:        props["unit"],
+		}
+	}
+	return meta
+}
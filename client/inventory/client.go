@@ -19,6 +19,7 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -32,12 +33,21 @@ import (
 const (
 	urlSearch      = "/api/internal/v2/inventory/tenants/:tid/filters/search"
 	defaultTimeout = 10 * time.Second
+
+	hdrTotalCount = "X-Total-Count"
 )
 
 //go:generate ../../x/mockgen.sh
 type Client interface {
 	//GetDevices uses the search endpoint to get devices just by ids (not filters)
 	GetDevices(ctx context.Context, tid string, deviceIDs []string) ([]model.InvDevice, error)
+	//SearchDevices uses the search endpoint to get a page of devices matching the filters,
+	//and the total number of matching devices
+	SearchDevices(
+		ctx context.Context,
+		tid string,
+		searchReq *SearchReq,
+	) ([]model.InvDevice, int, error)
 }
 
 type client struct {
@@ -63,15 +73,32 @@ func (c *client) GetDevices(
 	tid string,
 	deviceIDs []string,
 ) ([]model.InvDevice, error) {
-	l := log.FromContext(ctx)
-
 	getReq := &GetDevsReq{
 		DeviceIDs: deviceIDs,
 	}
 
-	body, err := json.Marshal(getReq)
+	invDevs, _, err := c.search(ctx, tid, getReq)
+	return invDevs, err
+}
+
+func (c *client) SearchDevices(
+	ctx context.Context,
+	tid string,
+	searchReq *SearchReq,
+) ([]model.InvDevice, int, error) {
+	return c.search(ctx, tid, searchReq)
+}
+
+func (c *client) search(
+	ctx context.Context,
+	tid string,
+	searchReq interface{},
+) ([]model.InvDevice, int, error) {
+	l := log.FromContext(ctx)
+
+	body, err := json.Marshal(searchReq)
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to serialize get devices request")
+		return nil, 0, errors.Wrapf(err, "failed to serialize get devices request")
 	}
 
 	rd := bytes.NewReader(body)
@@ -84,14 +111,14 @@ func (c *client) GetDevices(
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, rd)
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to create request")
+		return nil, 0, errors.Wrapf(err, "failed to create request")
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 
 	rsp, err := c.client.Do(req)
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to submit %s %s", req.Method, req.URL)
+		return nil, 0, errors.Wrapf(err, "failed to submit %s %s", req.Method, req.URL)
 	}
 	defer rsp.Body.Close()
 
@@ -99,17 +126,22 @@ func (c *client) GetDevices(
 		l.Errorf("request %s %s failed with status %v, response: %s",
 			req.Method, req.URL, rsp.Status, body)
 
-		return nil, errors.Errorf(
+		return nil, 0, errors.Errorf(
 			"%s %s request failed with status %v", req.Method, req.URL, rsp.Status)
 	}
 
 	dec := json.NewDecoder(rsp.Body)
 	var invDevs []model.InvDevice
 	if err = dec.Decode(&invDevs); err != nil {
-		return nil, errors.Wrap(err, "failed to parse request body")
+		return nil, 0, errors.Wrap(err, "failed to parse request body")
+	}
+
+	total, err := strconv.Atoi(rsp.Header.Get(hdrTotalCount))
+	if err != nil {
+		total = len(invDevs)
 	}
 
-	return invDevs, nil
+	return invDevs, total, nil
 }
 
 func joinURL(base, url string) string {
@@ -212,3 +212,46 @@ func TestGetDevices(t *testing.T) {
 		})
 	}
 }
+
+func TestSearchDevices(t *testing.T) {
+	t.Parallel()
+	rspChan := make(chan *http.Response, 1)
+	reqChan := make(chan *http.Request, 1)
+	srv := newTestServer(rspChan, reqChan)
+	defer srv.Close()
+
+	devs := []model.InvDevice{{
+		ID: model.DeviceID("9acfe595-78ff-456a-843a-0fa08bfd7c7a"),
+	}}
+	b, _ := json.Marshal(devs)
+	rspChan <- &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"X-Total-Count": []string{"21"}},
+		Body:       io.NopCloser(bytes.NewReader(b)),
+	}
+
+	searchReq := &SearchReq{
+		Page:    2,
+		PerPage: 20,
+		Filters: []model.FilterPredicate{{
+			Scope:     "system",
+			Attribute: "updated_ts",
+			Type:      "$gt",
+			Value:     "2021-10-01T12:00:00Z",
+		}},
+	}
+	client := NewClient(srv.URL, false)
+	res, total, err := client.SearchDevices(
+		context.Background(), "123456789012345678901234", searchReq)
+	assert.NoError(t, err)
+	assert.Equal(t, devs, res)
+	assert.Equal(t, 21, total)
+
+	req := <-reqChan
+	assert.Equal(t,
+		"/api/internal/v2/inventory/tenants/123456789012345678901234/filters/search",
+		req.URL.Path)
+	var body SearchReq
+	_ = json.NewDecoder(req.Body).Decode(&body)
+	assert.Equal(t, *searchReq, body)
+}
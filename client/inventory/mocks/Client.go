@@ -19,9 +19,9 @@ package mocks
 import (
 	context "context"
 
-	mock "github.com/stretchr/testify/mock"
-
+	inventory "github.com/mendersoftware/reporting/client/inventory"
 	model "github.com/mendersoftware/reporting/model"
+	mock "github.com/stretchr/testify/mock"
 )
 
 // Client is an autogenerated mock type for the Client type
@@ -51,3 +51,33 @@ func (_m *Client) GetDevices(ctx context.Context, tid string, deviceIDs []string
 
 	return r0, r1
 }
+
+// SearchDevices provides a mock function with given fields: ctx, tid, searchReq
+func (_m *Client) SearchDevices(ctx context.Context, tid string, searchReq *inventory.SearchReq) ([]model.InvDevice, int, error) {
+	ret := _m.Called(ctx, tid, searchReq)
+
+	var r0 []model.InvDevice
+	if rf, ok := ret.Get(0).(func(context.Context, string, *inventory.SearchReq) []model.InvDevice); ok {
+		r0 = rf(ctx, tid, searchReq)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.InvDevice)
+		}
+	}
+
+	var r1 int
+	if rf, ok := ret.Get(1).(func(context.Context, string, *inventory.SearchReq) int); ok {
+		r1 = rf(ctx, tid, searchReq)
+	} else {
+		r1 = ret.Get(1).(int)
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(context.Context, string, *inventory.SearchReq) error); ok {
+		r2 = rf(ctx, tid, searchReq)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
@@ -13,8 +13,20 @@
 //    limitations under the License.
 package inventory
 
+import (
+	"github.com/mendersoftware/reporting/model"
+)
+
 //GetDevsReq is a stripped down inventory search query
 // default max 20 devices
 type GetDevsReq struct {
 	DeviceIDs []string `json:"device_ids"`
 }
+
+//SearchReq is an inventory search query by filters
+type SearchReq struct {
+	Page    int                     `json:"page"`
+	PerPage int                     `json:"per_page"`
+	Filters []model.FilterPredicate `json:"filters,omitempty"`
+	Sort    []model.SortCriteria    `json:"sort,omitempty"`
+}
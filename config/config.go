@@ -24,6 +24,51 @@ const (
 	// SettingListenDefault is the default value for the listen address
 	SettingListenDefault = ":8080"
 
+	// SettingTenantClaim is the config key for the JWT claim holding the tenant ID
+	SettingTenantClaim = "tenant_claim"
+	// SettingTenantClaimDefault is the default value for the JWT claim holding
+	// the tenant ID
+	SettingTenantClaimDefault = "mender.tenant"
+
+	// SettingTenantHeader is the config key for the header holding the tenant ID,
+	// taking precedence over the JWT claim (empty disables it)
+	SettingTenantHeader = "tenant_header"
+	// SettingTenantHeaderDefault is the default value for the header holding
+	// the tenant ID
+	SettingTenantHeaderDefault = ""
+
+	// SettingRequireTenant is the config key for rejecting the management
+	// requests without a tenant ID
+	SettingRequireTenant = "require_tenant"
+	// SettingRequireTenantDefault is the default value for rejecting the
+	// management requests without a tenant ID
+	SettingRequireTenantDefault = false
+
+	// SettingRoleClaim is the config key for the JWT claim holding the
+	// user's role(s)
+	SettingRoleClaim = "role_claim"
+	// SettingRoleClaimDefault is the default JWT claim holding the user's role(s)
+	SettingRoleClaimDefault = "roles"
+
+	// SettingRoleAttributes is the config key for the map of user roles to
+	// the "<scope>/<name>" patterns of the attributes they're allowed to see
+	SettingRoleAttributes = "role_attributes"
+	// SettingRoleAttributesDefault is the default role attributes map (none,
+	// all the attributes are visible to all the users)
+	SettingRoleAttributesDefault = ""
+
+	// SettingAttributeMetadata is the config key for the display metadata of
+	// the attributes (display name, description, unit), keyed by "<scope>/<name>"
+	SettingAttributeMetadata = "attribute_metadata"
+	// SettingAttributeMetadataDefault is the default attribute metadata (none)
+	SettingAttributeMetadataDefault = ""
+
+	// SettingSortOrders is the config key for the custom orders of the values
+	// of the attributes which don't sort lexically, keyed by "<scope>/<name>"
+	SettingSortOrders = "sort_orders"
+	// SettingSortOrdersDefault is the default sort orders map (none)
+	SettingSortOrdersDefault = ""
+
 	// SettingElasticsearchAddresses is the config key for the elasticsearch addresses
 	SettingElasticsearchAddresses = "elasticsearch_addresses"
 	// SettingElasticsearchAddressesDefault is the default value for the elasticsearch addresses
@@ -50,6 +95,343 @@ const (
 	// elasticsearch devices index replicas
 	SettingElasticsearchDevicesIndexReplicasDefault = 0
 
+	// SettingElasticsearchTenantIndices is the config key for the dedicated
+	// devices indices of the tenants, keyed by tenant ID
+	SettingElasticsearchTenantIndices = "elasticsearch_tenant_indices"
+	// SettingElasticsearchTenantIndicesDefault is the default value for the
+	// dedicated devices indices of the tenants: all share the devices index
+	SettingElasticsearchTenantIndicesDefault = ""
+
+	// SettingElasticsearchRefreshInterval is the config key for index.refresh_interval
+	// of the devices index template
+	SettingElasticsearchRefreshInterval = "elasticsearch_refresh_interval"
+	// SettingElasticsearchRefreshIntervalDefault is the default value for the
+	// refresh interval of the devices index
+	SettingElasticsearchRefreshIntervalDefault = "1s"
+
+	// SettingElasticsearchDevicesReadAlias is the config key for the alias the
+	// searches run against, which may span the current and the previous devices
+	// index during a migration
+	SettingElasticsearchDevicesReadAlias = "elasticsearch_devices_read_alias"
+	// SettingElasticsearchDevicesReadAliasDefault is the default value for the
+	// devices read alias: searches run against the devices index
+	SettingElasticsearchDevicesReadAliasDefault = ""
+
+	// SettingElasticsearchSourceExcludes is the config key for the list of fields
+	// (wildcards allowed) excluded from the documents returned by Elasticsearch
+	SettingElasticsearchSourceExcludes = "elasticsearch_source_excludes"
+	// SettingElasticsearchSourceExcludesDefault is the default value for the list of
+	// fields excluded from the documents returned by Elasticsearch
+	SettingElasticsearchSourceExcludesDefault = ""
+
+	// SettingElasticsearchMgetBatchSize is the config key for the maximum number
+	// of devices fetched by a single mget request
+	SettingElasticsearchMgetBatchSize = "elasticsearch_mget_batch_size"
+	// SettingElasticsearchMgetBatchSizeDefault is the default value for the
+	// maximum number of devices fetched by a single mget request
+	SettingElasticsearchMgetBatchSizeDefault = 1000
+
+	// SettingElasticsearchTextFields is the config key for the list of field name
+	// patterns which get an analyzed 'text' sub-field for text search
+	SettingElasticsearchTextFields = "elasticsearch_text_fields"
+	// SettingElasticsearchTextFieldsDefault is the default value for the list of
+	// text search fields
+	SettingElasticsearchTextFieldsDefault = ""
+
+	// SettingElasticsearchIPFields is the config key for the list of field name
+	// patterns which get an 'ip' sub-field for the CIDR range aggregations
+	SettingElasticsearchIPFields = "elasticsearch_ip_fields"
+	// SettingElasticsearchIPFieldsDefault is the default value for the list of
+	// IP fields
+	SettingElasticsearchIPFieldsDefault = ""
+
+	// SettingElasticsearchGeoFields is the config key for the list of field name
+	// patterns which get a 'geo' sub-field for the geohash grid aggregations
+	SettingElasticsearchGeoFields = "elasticsearch_geo_fields"
+	// SettingElasticsearchGeoFieldsDefault is the default value for the list of
+	// location fields
+	SettingElasticsearchGeoFieldsDefault = ""
+
+	// SettingElasticsearchTextAnalyzerPattern is the config key for the regex used
+	// by the text fields' tokenizer to split the text into terms
+	SettingElasticsearchTextAnalyzerPattern = "elasticsearch_text_analyzer_pattern"
+	// SettingElasticsearchTextAnalyzerPatternDefault is the default value for the text
+	// fields' tokenizer regex; splits on whitespaces, commas and semicolons, but
+	// not on dots, to keep hostnames and version strings intact
+	SettingElasticsearchTextAnalyzerPatternDefault = `[\s,;]+`
+
+	// SettingElasticsearchMaxRetries is the config key for the max number of retries
+	// of the idempotent runtime requests to Elasticsearch (0 disables the retries)
+	SettingElasticsearchMaxRetries = "elasticsearch_max_retries"
+	// SettingElasticsearchMaxRetriesDefault is the default value for the max number
+	// of retries of the runtime requests to Elasticsearch
+	SettingElasticsearchMaxRetriesDefault = 3
+
+	// SettingElasticsearchRetryBackoffMsec is the config key for the delay before
+	// the first retry, doubled at each subsequent retry
+	SettingElasticsearchRetryBackoffMsec = "elasticsearch_retry_backoff_msec"
+	// SettingElasticsearchRetryBackoffMsecDefault is the default value for the delay
+	// before the first retry
+	SettingElasticsearchRetryBackoffMsecDefault = 100
+
+	// SettingElasticsearchRetryOnStatus is the config key for the list of HTTP status
+	// codes of the Elasticsearch responses which are retried
+	SettingElasticsearchRetryOnStatus = "elasticsearch_retry_on_status"
+	// SettingElasticsearchRetryOnStatusDefault is the default value for the list of
+	// retried HTTP status codes
+	SettingElasticsearchRetryOnStatusDefault = "502 503 504"
+
+	// SettingElasticsearchBreakerThreshold is the config key for the number of
+	// consecutive failed requests opening the circuit breaker (0 disables it)
+	SettingElasticsearchBreakerThreshold = "elasticsearch_breaker_threshold"
+	// SettingElasticsearchBreakerThresholdDefault is the default value for the number
+	// of consecutive failed requests opening the circuit breaker
+	SettingElasticsearchBreakerThresholdDefault = 5
+
+	// SettingElasticsearchBreakerCooldownMsec is the config key for the time the
+	// circuit breaker stays open before probing Elasticsearch again
+	SettingElasticsearchBreakerCooldownMsec = "elasticsearch_breaker_cooldown_msec"
+	// SettingElasticsearchBreakerCooldownMsecDefault is the default value for the
+	// time the circuit breaker stays open
+	SettingElasticsearchBreakerCooldownMsecDefault = 10000
+
+	// SettingElasticsearchFieldLimit is the config key for the max number of fields
+	// of the devices index, i.e. index.mapping.total_fields.limit (0 keeps the ES default)
+	SettingElasticsearchFieldLimit = "elasticsearch_field_limit"
+	// SettingElasticsearchFieldLimitDefault is the default value for the max number
+	// of fields of the devices index
+	SettingElasticsearchFieldLimitDefault = 0
+
+	// SettingElasticsearchFieldLimitPolicy is the config key for the handling of the
+	// devices exceeding the field limit: "reject" or "drop" (the new attributes)
+	SettingElasticsearchFieldLimitPolicy = "elasticsearch_field_limit_policy"
+	// SettingElasticsearchFieldLimitPolicyDefault is the default value for the
+	// handling of the devices exceeding the field limit
+	SettingElasticsearchFieldLimitPolicyDefault = "reject"
+
+	// SettingElasticsearchKeywordIgnoreAbove is the config key for ignore_above of the
+	// string attributes' keyword fields (0 keeps the ES default, i.e. no limit)
+	SettingElasticsearchKeywordIgnoreAbove = "elasticsearch_keyword_ignore_above"
+	// SettingElasticsearchKeywordIgnoreAboveDefault is the default value for
+	// ignore_above of the string attributes' keyword fields
+	SettingElasticsearchKeywordIgnoreAboveDefault = 0
+
+	// SettingElasticsearchTruncateValuesAbove is the config key for the length above
+	// which the string attribute values are truncated at index time (0 disables it)
+	SettingElasticsearchTruncateValuesAbove = "elasticsearch_truncate_values_above"
+	// SettingElasticsearchTruncateValuesAboveDefault is the default value for the
+	// length above which the string attribute values are truncated
+	SettingElasticsearchTruncateValuesAboveDefault = 0
+
+	// SettingElasticsearchIndexAttributesAllow is the config key for the list of
+	// attribute patterns ("scope/name", wildcards allowed) indexed; empty means all
+	SettingElasticsearchIndexAttributesAllow = "elasticsearch_index_attributes_allow"
+	// SettingElasticsearchIndexAttributesAllowDefault is the default value for the
+	// list of attribute patterns indexed
+	SettingElasticsearchIndexAttributesAllowDefault = ""
+
+	// SettingElasticsearchIndexAttributesDeny is the config key for the list of
+	// attribute patterns ("scope/name", wildcards allowed) never indexed
+	SettingElasticsearchIndexAttributesDeny = "elasticsearch_index_attributes_deny"
+	// SettingElasticsearchIndexAttributesDenyDefault is the default value for the
+	// list of attribute patterns never indexed
+	SettingElasticsearchIndexAttributesDenyDefault = ""
+
+	// SettingElasticsearchAttributeTypes is the config key for the declared types
+	// (str, num, bool) of the attributes, keyed by "scope/name"
+	SettingElasticsearchAttributeTypes = "elasticsearch_attribute_types"
+	// SettingElasticsearchAttributeTypesDefault is the default value for the
+	// declared attribute types (none)
+	SettingElasticsearchAttributeTypesDefault = ""
+
+	// SettingElasticsearchAttributeTypePolicy is the config key for the handling of
+	// the devices reporting a declared attribute with another type: "coerce" or "drop"
+	SettingElasticsearchAttributeTypePolicy = "elasticsearch_attribute_type_policy"
+	// SettingElasticsearchAttributeTypePolicyDefault is the default value for the
+	// handling of the attributes of another type than the declared one
+	SettingElasticsearchAttributeTypePolicyDefault = "coerce"
+
+	// SettingElasticsearchScopeMappings is the config key for the mappings of the
+	// attributes of given scopes, overriding the default type-based ones
+	SettingElasticsearchScopeMappings = "elasticsearch_scope_mappings"
+	// SettingElasticsearchScopeMappingsDefault is the default value for the scope
+	// mappings (none)
+	SettingElasticsearchScopeMappingsDefault = ""
+
+	// SettingElasticsearchScaledFloats is the config key for the scaling factors of
+	// the numeric attributes mapped as scaled_float, keyed by "scope/name"
+	SettingElasticsearchScaledFloats = "elasticsearch_scaled_floats"
+	// SettingElasticsearchScaledFloatsDefault is the default value for the
+	// scaled float attributes (none)
+	SettingElasticsearchScaledFloatsDefault = ""
+
+	// SettingElasticsearchILMPolicy is the config key for the name of the index
+	// lifecycle management policy of the devices index (empty disables ILM)
+	SettingElasticsearchILMPolicy = "elasticsearch_ilm_policy"
+	// SettingElasticsearchILMPolicyDefault is the default value for the ILM policy name
+	SettingElasticsearchILMPolicyDefault = ""
+
+	// SettingElasticsearchILMRolloverMaxSize is the config key for the index size
+	// triggering the rollover; not supported by the devices index, must be empty
+	SettingElasticsearchILMRolloverMaxSize = "elasticsearch_ilm_rollover_max_size"
+	// SettingElasticsearchILMRolloverMaxSizeDefault is the default value for the index
+	// size triggering the rollover
+	SettingElasticsearchILMRolloverMaxSizeDefault = ""
+
+	// SettingElasticsearchILMRolloverMaxAge is the config key for the index age
+	// triggering the rollover; not supported by the devices index, must be empty
+	SettingElasticsearchILMRolloverMaxAge = "elasticsearch_ilm_rollover_max_age"
+	// SettingElasticsearchILMRolloverMaxAgeDefault is the default value for the index
+	// age triggering the rollover
+	SettingElasticsearchILMRolloverMaxAgeDefault = ""
+
+	// SettingElasticsearchILMShrinkShards is the config key for the number of shards
+	// the devices index is shrunk to (0 disables the shrink)
+	SettingElasticsearchILMShrinkShards = "elasticsearch_ilm_shrink_shards"
+	// SettingElasticsearchILMShrinkShardsDefault is the default value for the number
+	// of shards the devices index is shrunk to
+	SettingElasticsearchILMShrinkShardsDefault = 0
+
+	// SettingElasticsearchILMDeleteAfter is the config key for the retention of the
+	// index; not supported by the devices index, must be empty
+	SettingElasticsearchILMDeleteAfter = "elasticsearch_ilm_delete_after"
+	// SettingElasticsearchILMDeleteAfterDefault is the default value for the retention
+	// of the index
+	SettingElasticsearchILMDeleteAfterDefault = ""
+
+	// SettingElasticsearchIngestPipeline is the config key for the name of the
+	// ingest pipeline the devices are indexed with (empty disables the pipeline)
+	SettingElasticsearchIngestPipeline = "elasticsearch_ingest_pipeline"
+	// SettingElasticsearchIngestPipelineDefault is the default value for the
+	// ingest pipeline name
+	SettingElasticsearchIngestPipelineDefault = ""
+
+	// SettingElasticsearchIngestPipelineDefinition is the config key for the
+	// body of the ingest pipeline, created by the migration (empty if the
+	// pipeline is managed outside of the service)
+	SettingElasticsearchIngestPipelineDefinition = "elasticsearch_ingest_pipeline_definition"
+	// SettingElasticsearchIngestPipelineDefinitionDefault is the default value
+	// for the body of the ingest pipeline
+	SettingElasticsearchIngestPipelineDefinitionDefault = ""
+
+	// SettingElasticsearchHistoryEnabled is the config key for enabling the history
+	// of the device attributes, appended to a separate index on each update
+	SettingElasticsearchHistoryEnabled = "elasticsearch_history_enabled"
+	// SettingElasticsearchHistoryEnabledDefault is the default value for enabling
+	// the history of the device attributes
+	SettingElasticsearchHistoryEnabledDefault = false
+
+	// SettingElasticsearchHistoryIndexName is the config key for the name of
+	// the attribute history index
+	SettingElasticsearchHistoryIndexName = "elasticsearch_history_index_name"
+	// SettingElasticsearchHistoryIndexNameDefault is the default value for the name
+	// of the attribute history index
+	SettingElasticsearchHistoryIndexNameDefault = "devices-history"
+
+	// SettingSearchProfile is the config key for enabling the ES query profiling
+	// of the internal searches, for debugging
+	SettingSearchProfile = "search_profile"
+	// SettingSearchProfileDefault is the default value for enabling the ES query
+	// profiling of the internal searches
+	SettingSearchProfileDefault = false
+
+	// SettingSearchMaxClauseCount is the config key for the max number of values
+	// of a single $in/$nin clause of the searches; the larger arrays are split
+	SettingSearchMaxClauseCount = "search_max_clause_count"
+	// SettingSearchMaxClauseCountDefault is the default value for the max number
+	// of values of a clause, as the ES indices.query.bool.max_clause_count
+	SettingSearchMaxClauseCountDefault = 1024
+
+	// SettingSearchMaxQueryClauses is the config key for the max total number
+	// of bool clauses of the compiled search queries (0 means no limit)
+	SettingSearchMaxQueryClauses = "search_max_query_clauses"
+	// SettingSearchMaxQueryClausesDefault is the default value for the max
+	// number of bool clauses of the search queries
+	SettingSearchMaxQueryClausesDefault = 4096
+
+	// SettingSearchMaxQueryDepth is the config key for the max nesting depth
+	// of the bool queries of the compiled search queries (0 means no limit)
+	SettingSearchMaxQueryDepth = "search_max_query_depth"
+	// SettingSearchMaxQueryDepthDefault is the default value for the max
+	// nesting depth of the bool queries of the search queries
+	SettingSearchMaxQueryDepthDefault = 10
+
+	// SettingRawAggregations is the config key for enabling the internal
+	// passthrough of the raw ES aggregations
+	SettingRawAggregations = "raw_aggregations"
+	// SettingRawAggregationsDefault is the default value for enabling the
+	// raw aggregations passthrough
+	SettingRawAggregationsDefault = false
+
+	// SettingRawAggregationsAllowScripts is the config key for allowing the
+	// scripts in the raw aggregations
+	SettingRawAggregationsAllowScripts = "raw_aggregations_allow_scripts"
+	// SettingRawAggregationsAllowScriptsDefault is the default value for
+	// allowing the scripts in the raw aggregations
+	SettingRawAggregationsAllowScriptsDefault = false
+
+	// SettingStrictDecoding is the config key for rejecting the search
+	// requests with unknown fields, instead of ignoring them
+	SettingStrictDecoding = "strict_decoding"
+	// SettingStrictDecodingDefault is the default value for rejecting the
+	// search requests with unknown fields
+	SettingStrictDecodingDefault = false
+
+	// SettingDisabledFeatures is the config key for the list of the disabled
+	// endpoints, by feature name (e.g. "export", "pivot")
+	SettingDisabledFeatures = "disabled_features"
+	// SettingDisabledFeaturesDefault is the default value for the list of
+	// the disabled endpoints: all enabled
+	SettingDisabledFeaturesDefault = ""
+
+	// SettingAggregationMaxBuckets is the config key for the max number of buckets
+	// any aggregation endpoint can request (0 means no limit)
+	SettingAggregationMaxBuckets = "aggregation_max_buckets"
+	// SettingAggregationMaxBucketsDefault is the default value for the max number
+	// of aggregation buckets
+	SettingAggregationMaxBucketsDefault = 0
+
+	// SettingAggregationCacheTTLMsec is the config key for how long the results
+	// of the aggregation endpoints are cached (0 disables the cache)
+	SettingAggregationCacheTTLMsec = "aggregation_cache_ttl_msec"
+	// SettingAggregationCacheTTLMsecDefault is the default aggregation cache TTL
+	SettingAggregationCacheTTLMsecDefault = 0
+
+	// SettingAggregationCacheMaxSize is the config key for the max number of
+	// cached aggregation results
+	SettingAggregationCacheMaxSize = "aggregation_cache_max_size"
+	// SettingAggregationCacheMaxSizeDefault is the default max number of cached
+	// aggregation results
+	SettingAggregationCacheMaxSizeDefault = 1000
+
+	// SettingScanCursorTTLMsec is the config key for how long a scan cursor
+	// stays valid after its page is returned
+	SettingScanCursorTTLMsec = "scan_cursor_ttl_msec"
+	// SettingScanCursorTTLMsecDefault is the default scan cursor TTL (10m)
+	SettingScanCursorTTLMsecDefault = 600000
+
+	// SettingMaxConcurrentSearches is the config key for the max number of
+	// searches in flight to Elasticsearch, incl. the aggregations; the searches
+	// beyond it are rejected (0 means no limit)
+	SettingMaxConcurrentSearches = "max_concurrent_searches"
+	// SettingMaxConcurrentSearchesDefault is the default value for the max
+	// number of concurrent searches
+	SettingMaxConcurrentSearchesDefault = 0
+
+	// SettingMaxConcurrentSearchesStatus is the config key for the status of
+	// the responses to the rejected searches, either 503 or 429
+	SettingMaxConcurrentSearchesStatus = "max_concurrent_searches_status"
+	// SettingMaxConcurrentSearchesStatusDefault is the default value for the
+	// status of the rejected searches
+	SettingMaxConcurrentSearchesStatusDefault = 503
+
+	// SettingMaxConcurrentSearchesRetryAfterSec is the config key for the
+	// Retry-After of the responses to the rejected searches
+	SettingMaxConcurrentSearchesRetryAfterSec = "max_concurrent_searches_retry_after_sec"
+	// SettingMaxConcurrentSearchesRetryAfterSecDefault is the default value for
+	// the Retry-After of the rejected searches
+	SettingMaxConcurrentSearchesRetryAfterSecDefault = 1
+
 	SettingInventoryAddr        = "inventory_addr"
 	SettingInventoryAddrDefault = "http://mender-inventory:8080/"
 
@@ -57,6 +439,34 @@ const (
 	SettingReindexBatchSize        = "reindex_batch_size"
 	SettingReindexBatchSizeDefault = 20
 
+	// SettingReindexSuspendRefresh is the config key for suspending the refresh of
+	// the devices index while the tenant's devices are bulk reindexed
+	SettingReindexSuspendRefresh = "reindex_suspend_refresh"
+	// SettingReindexSuspendRefreshDefault is the default value for suspending the
+	// refresh of the devices index during the bulk reindexes
+	SettingReindexSuspendRefreshDefault = false
+
+	// SettingReconcile is the config key for enabling the periodic
+	// reconciliation of the indexed devices with the inventory
+	SettingReconcile = "reconcile"
+	// SettingReconcileDefault is the default value for enabling the
+	// reconciliation
+	SettingReconcileDefault = false
+
+	// SettingReconcileIntervalMsec is the config key for the interval
+	// of the reconciliations
+	SettingReconcileIntervalMsec = "reconcile_interval_msec"
+	// SettingReconcileIntervalMsecDefault is the default value for the
+	// interval of the reconciliations: hourly
+	SettingReconcileIntervalMsecDefault = 3600000
+
+	// SettingReconcileSampleSize is the config key for the number of devices
+	// of each tenant checked to be indexed by the reconciliation (0: none)
+	SettingReconcileSampleSize = "reconcile_sample_size"
+	// SettingReconcileSampleSizeDefault is the default value for the number of
+	// sampled devices of each tenant
+	SettingReconcileSampleSizeDefault = 0
+
 	// SettingReindexTimeMsec is the max time after which reindexing is triggered
 	// (even if buffered requests didn't reach reindex_batch_size yet)
 	SettingReindexMaxTimeMsec        = "reindex_max_time_msec"
@@ -71,6 +481,104 @@ const (
 	SettingReindexNumWorkers        = "reindex_num_workers"
 	SettingReindexNumWorkersDefault = 5
 
+	// SettingReindexTenantRate is the max num of reindex requests per second
+	// processed for each tenant; requests exceeding it are delayed, not dropped
+	// (0 means unlimited)
+	SettingReindexTenantRate        = "reindex_tenant_rate"
+	SettingReindexTenantRateDefault = 0
+
+	// SettingReindexTenantBurst is the num of reindex requests a tenant can
+	// issue at once before being throttled to reindex_tenant_rate
+	SettingReindexTenantBurst        = "reindex_tenant_burst"
+	SettingReindexTenantBurstDefault = 100
+
+	// SettingReindexTenantQuotas overrides reindex_tenant_rate for specific tenants,
+	// as a list of "<tenant_id>=<requests per second>"
+	SettingReindexTenantQuotas        = "reindex_tenant_quotas"
+	SettingReindexTenantQuotasDefault = ""
+
+	// SettingReindexBulkIndexer enables the concurrent bulk indexer for the
+	// reindex updates, instead of a single bulk request per batch
+	SettingReindexBulkIndexer        = "reindex_bulk_indexer"
+	SettingReindexBulkIndexerDefault = false
+
+	// SettingReindexSlowBatchThresholdMsec is the indexing latency of a batch
+	// beyond which a warning is logged (0 means no warnings)
+	SettingReindexSlowBatchThresholdMsec        = "reindex_slow_batch_threshold_msec"
+	SettingReindexSlowBatchThresholdMsecDefault = 5000
+
+	// SettingElasticsearchBulkNumWorkers is the num of concurrent requests of the
+	// bulk indexer (0 means the num of CPUs)
+	SettingElasticsearchBulkNumWorkers        = "elasticsearch_bulk_num_workers"
+	SettingElasticsearchBulkNumWorkersDefault = 0
+
+	// SettingElasticsearchBulkFlushBytes is the size of the bulk indexer requests
+	// (0 means 5MB)
+	SettingElasticsearchBulkFlushBytes        = "elasticsearch_bulk_flush_bytes"
+	SettingElasticsearchBulkFlushBytesDefault = 0
+
+	// SettingElasticsearchBulkFlushIntervalMsec is the max time the bulk indexer
+	// buffers the items before flushing (0 means 30s)
+	SettingElasticsearchBulkFlushIntervalMsec        = "elasticsearch_bulk_flush_interval_msec"
+	SettingElasticsearchBulkFlushIntervalMsecDefault = 0
+
+	// SettingElasticsearchWarmUpQueries is the config key for the search bodies
+	// run against the devices index at startup, to load the caches
+	SettingElasticsearchWarmUpQueries = "elasticsearch_warmup_queries"
+	// SettingElasticsearchWarmUpQueriesDefault is the default value for the
+	// warm-up queries (none, no warm-up)
+	SettingElasticsearchWarmUpQueriesDefault = ""
+
+	// SettingElasticsearchAWSSigV4 is the config key for signing the requests
+	// to Elasticsearch with AWS SigV4, as required by AWS OpenSearch
+	SettingElasticsearchAWSSigV4 = "elasticsearch_aws_sigv4"
+	// SettingElasticsearchAWSSigV4Default is the default value for signing
+	// the requests with AWS SigV4
+	SettingElasticsearchAWSSigV4Default = false
+
+	// SettingElasticsearchAWSRegion is the config key for the AWS region of the
+	// SigV4 signature (empty falls back to the AWS_REGION environment variable)
+	SettingElasticsearchAWSRegion = "elasticsearch_aws_region"
+	// SettingElasticsearchAWSRegionDefault is the default value for the AWS
+	// region of the SigV4 signature
+	SettingElasticsearchAWSRegionDefault = ""
+
+	// SettingElasticsearchAWSService is the config key for the signing name of
+	// the service in the SigV4 signature
+	SettingElasticsearchAWSService = "elasticsearch_aws_service"
+	// SettingElasticsearchAWSServiceDefault is the default value for the
+	// signing name of the service (AWS OpenSearch)
+	SettingElasticsearchAWSServiceDefault = "es"
+
+	// SettingElasticsearchClientName is the config key for the service name
+	// sent to Elasticsearch in the X-Opaque-Id header of all the requests
+	SettingElasticsearchClientName = "elasticsearch_client_name"
+	// SettingElasticsearchClientNameDefault is the default value for the
+	// service name sent to Elasticsearch
+	SettingElasticsearchClientNameDefault = "reporting"
+
+	// SettingElasticsearchClientInstance is the config key for the instance
+	// (e.g. pod) name sent to Elasticsearch along with the service name
+	// (empty falls back to the hostname)
+	SettingElasticsearchClientInstance = "elasticsearch_client_instance"
+	// SettingElasticsearchClientInstanceDefault is the default value for the
+	// instance name sent to Elasticsearch
+	SettingElasticsearchClientInstanceDefault = ""
+
+	// SettingElasticsearchTrace is the config key for logging the full requests
+	// to Elasticsearch and their responses, for debugging
+	SettingElasticsearchTrace = "elasticsearch_trace"
+	// SettingElasticsearchTraceDefault is the default value for logging the
+	// full requests to Elasticsearch and their responses
+	SettingElasticsearchTraceDefault = false
+
+	// SettingElasticsearchTraceMaxBodySize is the config key for the max length
+	// of the request and response bodies in the Elasticsearch traces
+	SettingElasticsearchTraceMaxBodySize = "elasticsearch_trace_max_body_size"
+	// SettingElasticsearchTraceMaxBodySizeDefault is the default value for the
+	// max length of the request and response bodies in the Elasticsearch traces
+	SettingElasticsearchTraceMaxBodySizeDefault = 1024
+
 	// SettingDebugLog is the config key for the truning on the debug log
 	SettingDebugLog = "debug_log"
 	// SettingDebugLogDefault is the default value for the debug log enabling
@@ -81,6 +589,13 @@ var (
 	// Defaults are the default configuration settings
 	Defaults = []config.Default{
 		{Key: SettingListen, Value: SettingListenDefault},
+		{Key: SettingTenantClaim, Value: SettingTenantClaimDefault},
+		{Key: SettingTenantHeader, Value: SettingTenantHeaderDefault},
+		{Key: SettingRequireTenant, Value: SettingRequireTenantDefault},
+		{Key: SettingRoleClaim, Value: SettingRoleClaimDefault},
+		{Key: SettingRoleAttributes, Value: SettingRoleAttributesDefault},
+		{Key: SettingAttributeMetadata, Value: SettingAttributeMetadataDefault},
+		{Key: SettingSortOrders, Value: SettingSortOrdersDefault},
 		{Key: SettingElasticsearchAddresses, Value: SettingElasticsearchAddressesDefault},
 		{Key: SettingElasticsearchDevicesIndexName,
 			Value: SettingElasticsearchDevicesIndexNameDefault},
@@ -88,11 +603,127 @@ var (
 			Value: SettingElasticsearchDevicesIndexShardsDefault},
 		{Key: SettingElasticsearchDevicesIndexReplicas,
 			Value: SettingElasticsearchDevicesIndexReplicasDefault},
+		{Key: SettingElasticsearchTenantIndices,
+			Value: SettingElasticsearchTenantIndicesDefault},
+		{Key: SettingElasticsearchRefreshInterval,
+			Value: SettingElasticsearchRefreshIntervalDefault},
+		{Key: SettingElasticsearchDevicesReadAlias,
+			Value: SettingElasticsearchDevicesReadAliasDefault},
+		{Key: SettingElasticsearchSourceExcludes,
+			Value: SettingElasticsearchSourceExcludesDefault},
+		{Key: SettingElasticsearchMgetBatchSize,
+			Value: SettingElasticsearchMgetBatchSizeDefault},
+		{Key: SettingElasticsearchTextFields,
+			Value: SettingElasticsearchTextFieldsDefault},
+		{Key: SettingElasticsearchTextAnalyzerPattern,
+			Value: SettingElasticsearchTextAnalyzerPatternDefault},
+		{Key: SettingElasticsearchIPFields,
+			Value: SettingElasticsearchIPFieldsDefault},
+		{Key: SettingElasticsearchGeoFields,
+			Value: SettingElasticsearchGeoFieldsDefault},
+		{Key: SettingElasticsearchMaxRetries,
+			Value: SettingElasticsearchMaxRetriesDefault},
+		{Key: SettingElasticsearchRetryBackoffMsec,
+			Value: SettingElasticsearchRetryBackoffMsecDefault},
+		{Key: SettingElasticsearchRetryOnStatus,
+			Value: SettingElasticsearchRetryOnStatusDefault},
+		{Key: SettingElasticsearchBreakerThreshold,
+			Value: SettingElasticsearchBreakerThresholdDefault},
+		{Key: SettingElasticsearchBreakerCooldownMsec,
+			Value: SettingElasticsearchBreakerCooldownMsecDefault},
+		{Key: SettingElasticsearchFieldLimit,
+			Value: SettingElasticsearchFieldLimitDefault},
+		{Key: SettingElasticsearchFieldLimitPolicy,
+			Value: SettingElasticsearchFieldLimitPolicyDefault},
+		{Key: SettingElasticsearchKeywordIgnoreAbove,
+			Value: SettingElasticsearchKeywordIgnoreAboveDefault},
+		{Key: SettingElasticsearchTruncateValuesAbove,
+			Value: SettingElasticsearchTruncateValuesAboveDefault},
+		{Key: SettingElasticsearchIndexAttributesAllow,
+			Value: SettingElasticsearchIndexAttributesAllowDefault},
+		{Key: SettingElasticsearchIndexAttributesDeny,
+			Value: SettingElasticsearchIndexAttributesDenyDefault},
+		{Key: SettingElasticsearchAttributeTypes,
+			Value: SettingElasticsearchAttributeTypesDefault},
+		{Key: SettingElasticsearchAttributeTypePolicy,
+			Value: SettingElasticsearchAttributeTypePolicyDefault},
+		{Key: SettingElasticsearchScopeMappings,
+			Value: SettingElasticsearchScopeMappingsDefault},
+		{Key: SettingElasticsearchScaledFloats,
+			Value: SettingElasticsearchScaledFloatsDefault},
+		{Key: SettingElasticsearchILMPolicy,
+			Value: SettingElasticsearchILMPolicyDefault},
+		{Key: SettingElasticsearchILMRolloverMaxSize,
+			Value: SettingElasticsearchILMRolloverMaxSizeDefault},
+		{Key: SettingElasticsearchILMRolloverMaxAge,
+			Value: SettingElasticsearchILMRolloverMaxAgeDefault},
+		{Key: SettingElasticsearchILMShrinkShards,
+			Value: SettingElasticsearchILMShrinkShardsDefault},
+		{Key: SettingElasticsearchILMDeleteAfter,
+			Value: SettingElasticsearchILMDeleteAfterDefault},
+		{Key: SettingElasticsearchIngestPipeline,
+			Value: SettingElasticsearchIngestPipelineDefault},
+		{Key: SettingElasticsearchIngestPipelineDefinition,
+			Value: SettingElasticsearchIngestPipelineDefinitionDefault},
 		{Key: SettingDebugLog, Value: SettingDebugLogDefault},
+		{Key: SettingElasticsearchHistoryEnabled,
+			Value: SettingElasticsearchHistoryEnabledDefault},
+		{Key: SettingElasticsearchHistoryIndexName,
+			Value: SettingElasticsearchHistoryIndexNameDefault},
+		{Key: SettingSearchProfile, Value: SettingSearchProfileDefault},
+		{Key: SettingSearchMaxClauseCount, Value: SettingSearchMaxClauseCountDefault},
+		{Key: SettingSearchMaxQueryClauses, Value: SettingSearchMaxQueryClausesDefault},
+		{Key: SettingSearchMaxQueryDepth, Value: SettingSearchMaxQueryDepthDefault},
+		{Key: SettingRawAggregations, Value: SettingRawAggregationsDefault},
+		{Key: SettingRawAggregationsAllowScripts,
+			Value: SettingRawAggregationsAllowScriptsDefault},
+		{Key: SettingStrictDecoding, Value: SettingStrictDecodingDefault},
+		{Key: SettingDisabledFeatures, Value: SettingDisabledFeaturesDefault},
+		{Key: SettingAggregationMaxBuckets, Value: SettingAggregationMaxBucketsDefault},
+		{Key: SettingAggregationCacheTTLMsec, Value: SettingAggregationCacheTTLMsecDefault},
+		{Key: SettingAggregationCacheMaxSize, Value: SettingAggregationCacheMaxSizeDefault},
+		{Key: SettingScanCursorTTLMsec, Value: SettingScanCursorTTLMsecDefault},
+		{Key: SettingMaxConcurrentSearches, Value: SettingMaxConcurrentSearchesDefault},
+		{Key: SettingMaxConcurrentSearchesStatus,
+			Value: SettingMaxConcurrentSearchesStatusDefault},
+		{Key: SettingMaxConcurrentSearchesRetryAfterSec,
+			Value: SettingMaxConcurrentSearchesRetryAfterSecDefault},
 		{Key: SettingInventoryAddr, Value: SettingInventoryAddrDefault},
 		{Key: SettingReindexBuffLen, Value: SettingReindexBuffLenDefault},
 		{Key: SettingReindexMaxTimeMsec, Value: SettingReindexMaxTimeMsecDefault},
 		{Key: SettingReindexBatchSize, Value: SettingReindexBatchSizeDefault},
+		{Key: SettingReindexSuspendRefresh, Value: SettingReindexSuspendRefreshDefault},
+		{Key: SettingReconcile, Value: SettingReconcileDefault},
+		{Key: SettingReconcileIntervalMsec, Value: SettingReconcileIntervalMsecDefault},
+		{Key: SettingReconcileSampleSize, Value: SettingReconcileSampleSizeDefault},
 		{Key: SettingReindexNumWorkers, Value: SettingReindexNumWorkersDefault},
+		{Key: SettingReindexTenantRate, Value: SettingReindexTenantRateDefault},
+		{Key: SettingReindexTenantBurst, Value: SettingReindexTenantBurstDefault},
+		{Key: SettingReindexTenantQuotas, Value: SettingReindexTenantQuotasDefault},
+		{Key: SettingReindexBulkIndexer, Value: SettingReindexBulkIndexerDefault},
+		{Key: SettingReindexSlowBatchThresholdMsec,
+			Value: SettingReindexSlowBatchThresholdMsecDefault},
+		{Key: SettingElasticsearchBulkNumWorkers,
+			Value: SettingElasticsearchBulkNumWorkersDefault},
+		{Key: SettingElasticsearchBulkFlushBytes,
+			Value: SettingElasticsearchBulkFlushBytesDefault},
+		{Key: SettingElasticsearchBulkFlushIntervalMsec,
+			Value: SettingElasticsearchBulkFlushIntervalMsecDefault},
+		{Key: SettingElasticsearchWarmUpQueries,
+			Value: SettingElasticsearchWarmUpQueriesDefault},
+		{Key: SettingElasticsearchAWSSigV4,
+			Value: SettingElasticsearchAWSSigV4Default},
+		{Key: SettingElasticsearchAWSRegion,
+			Value: SettingElasticsearchAWSRegionDefault},
+		{Key: SettingElasticsearchAWSService,
+			Value: SettingElasticsearchAWSServiceDefault},
+		{Key: SettingElasticsearchClientName,
+			Value: SettingElasticsearchClientNameDefault},
+		{Key: SettingElasticsearchClientInstance,
+			Value: SettingElasticsearchClientInstanceDefault},
+		{Key: SettingElasticsearchTrace,
+			Value: SettingElasticsearchTraceDefault},
+		{Key: SettingElasticsearchTraceMaxBodySize,
+			Value: SettingElasticsearchTraceMaxBodySizeDefault},
 	}
 )
@@ -16,18 +16,24 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/pkg/errors"
 	"github.com/urfave/cli"
 
 	"github.com/mendersoftware/go-lib-micro/config"
 
 	"github.com/mendersoftware/reporting/app/indexer"
+	"github.com/mendersoftware/reporting/app/reporting"
 	"github.com/mendersoftware/reporting/app/server"
 	dconfig "github.com/mendersoftware/reporting/config"
+	"github.com/mendersoftware/reporting/model"
 	"github.com/mendersoftware/reporting/store"
 )
 
@@ -76,6 +82,37 @@ func doMain(args []string) {
 				Usage:  "Run the migrations",
 				Action: cmdMigrate,
 			},
+			{
+				Name: "check-mapping",
+				Usage: "Check the mapping of the devices index against " +
+					"the expected index template",
+				Action: cmdCheckMapping,
+			},
+			{
+				Name: "rename-attribute",
+				Usage: "Rename an attribute in the indexed devices of a tenant, " +
+					"e.g. after the inventory renamed it",
+				Action: cmdRenameAttribute,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "tenant",
+						Usage: "ID of the tenant.",
+					},
+					&cli.StringFlag{
+						Name:  "scope",
+						Usage: "Scope of the attribute.",
+						Value: model.AttrScopeInventory,
+					},
+					&cli.StringFlag{
+						Name:  "from",
+						Usage: "Current name of the attribute.",
+					},
+					&cli.StringFlag{
+						Name:  "to",
+						Usage: "New name of the attribute.",
+					},
+				},
+			},
 		},
 	}
 	app.Usage = "Reporting"
@@ -142,20 +179,384 @@ func cmdMigrate(args *cli.Context) error {
 	return store.Migrate(ctx)
 }
 
+func cmdCheckMapping(args *cli.Context) error {
+	store, err := getStore(args)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	diff, err := store.CheckMapping(ctx)
+	if err != nil {
+		return err
+	}
+	if len(diff) > 0 {
+		return cli.NewExitError(
+			fmt.Sprintf("the index mapping differs from the expected one:\n%s",
+				strings.Join(diff, "\n")),
+			1)
+	}
+	fmt.Println("the index mapping is up to date")
+	return nil
+}
+
+func cmdRenameAttribute(args *cli.Context) error {
+	params := &model.RenameAttributeParams{
+		TenantID: args.String("tenant"),
+		Scope:    args.String("scope"),
+		From:     args.String("from"),
+		To:       args.String("to"),
+	}
+	if err := params.Validate(); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	store, err := getStore(args)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	task, err := reporting.NewApp(store, nil, nil).RenameAttribute(ctx, params)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("renamed the attribute in %d devices (%d version conflicts)\n",
+		task.Updated, task.Conflicts)
+	if task.Conflicts > 0 {
+		fmt.Println("rerun to rename the attribute in the conflicting devices")
+	}
+	return nil
+}
+
 func getStore(args *cli.Context) (store.Store, error) {
 	addresses := config.Config.GetStringSlice(dconfig.SettingElasticsearchAddresses)
 	devicesIndexName := config.Config.GetString(dconfig.SettingElasticsearchDevicesIndexName)
 	deviceesIndexShards := config.Config.GetInt(dconfig.SettingElasticsearchDevicesIndexShards)
 	deviceesIndexReplicas := config.Config.GetInt(
 		dconfig.SettingElasticsearchDevicesIndexReplicas)
+	sourceExcludes := config.Config.GetStringSlice(dconfig.SettingElasticsearchSourceExcludes)
+	textFields := config.Config.GetStringSlice(dconfig.SettingElasticsearchTextFields)
+	ipFields := config.Config.GetStringSlice(dconfig.SettingElasticsearchIPFields)
+	geoFields := config.Config.GetStringSlice(dconfig.SettingElasticsearchGeoFields)
+	indexAttrsAllow := config.Config.GetStringSlice(
+		dconfig.SettingElasticsearchIndexAttributesAllow)
+	indexAttrsDeny := config.Config.GetStringSlice(
+		dconfig.SettingElasticsearchIndexAttributesDeny)
+	attrTypes := config.Config.GetStringMapString(
+		dconfig.SettingElasticsearchAttributeTypes)
+	textAnalyzerPattern := config.Config.GetString(
+		dconfig.SettingElasticsearchTextAnalyzerPattern)
+	retryOnStatus := []int{}
+	for _, v := range config.Config.GetStringSlice(dconfig.SettingElasticsearchRetryOnStatus) {
+		status, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid %s",
+				dconfig.SettingElasticsearchRetryOnStatus)
+		}
+		retryOnStatus = append(retryOnStatus, status)
+	}
+	retryPolicy := store.RetryPolicy{
+		MaxRetries: config.Config.GetInt(dconfig.SettingElasticsearchMaxRetries),
+		Backoff: time.Duration(config.Config.GetInt(
+			dconfig.SettingElasticsearchRetryBackoffMsec)) * time.Millisecond,
+		RetryOnStatus: retryOnStatus,
+	}
+	breakerPolicy := store.BreakerPolicy{
+		Threshold: config.Config.GetInt(dconfig.SettingElasticsearchBreakerThreshold),
+		Cooldown: time.Duration(config.Config.GetInt(
+			dconfig.SettingElasticsearchBreakerCooldownMsec)) * time.Millisecond,
+	}
+	ilmPolicy := store.ILMPolicy{
+		Name: config.Config.GetString(dconfig.SettingElasticsearchILMPolicy),
+		RolloverMaxSize: config.Config.GetString(
+			dconfig.SettingElasticsearchILMRolloverMaxSize),
+		RolloverMaxAge: config.Config.GetString(
+			dconfig.SettingElasticsearchILMRolloverMaxAge),
+		ShrinkShards: config.Config.GetInt(dconfig.SettingElasticsearchILMShrinkShards),
+		DeleteAfter:  config.Config.GetString(dconfig.SettingElasticsearchILMDeleteAfter),
+	}
+	bulkIndexer := store.BulkIndexerConfig{
+		NumWorkers: config.Config.GetInt(dconfig.SettingElasticsearchBulkNumWorkers),
+		FlushBytes: config.Config.GetInt(dconfig.SettingElasticsearchBulkFlushBytes),
+		FlushInterval: time.Duration(config.Config.GetInt(
+			dconfig.SettingElasticsearchBulkFlushIntervalMsec)) * time.Millisecond,
+	}
+	scopeMappings, err := getScopeMappings()
+	if err != nil {
+		return nil, err
+	}
+	scaledFloats, err := getScaledFloats()
+	if err != nil {
+		return nil, err
+	}
+	warmUpQueries, err := getWarmUpQueries()
+	if err != nil {
+		return nil, err
+	}
+	ingestPipeline, err := getIngestPipeline()
+	if err != nil {
+		return nil, err
+	}
+	tenantIndices, err := getTenantIndices()
+	if err != nil {
+		return nil, err
+	}
+	sigV4, err := getSigV4Config()
+	if err != nil {
+		return nil, err
+	}
+	historyIndexName := ""
+	if config.Config.GetBool(dconfig.SettingElasticsearchHistoryEnabled) {
+		historyIndexName = config.Config.GetString(
+			dconfig.SettingElasticsearchHistoryIndexName)
+	}
 	store, err := store.NewStore(
 		store.WithServerAddresses(addresses),
 		store.WithDevicesIndexName(devicesIndexName),
 		store.WithDevicesIndexShards(deviceesIndexShards),
 		store.WithDevicesIndexReplicas(deviceesIndexReplicas),
+		store.WithTenantIndices(tenantIndices),
+		store.WithRefreshInterval(
+			config.Config.GetString(dconfig.SettingElasticsearchRefreshInterval)),
+		store.WithDevicesReadAlias(
+			config.Config.GetString(dconfig.SettingElasticsearchDevicesReadAlias)),
+		store.WithSourceExcludes(sourceExcludes),
+		store.WithMgetBatchSize(
+			config.Config.GetInt(dconfig.SettingElasticsearchMgetBatchSize)),
+		store.WithTextAnalyzer(textAnalyzerPattern, textFields),
+		store.WithIPFields(ipFields),
+		store.WithGeoFields(geoFields),
+		store.WithRetryPolicy(retryPolicy),
+		store.WithBreakerPolicy(breakerPolicy),
+		store.WithFieldLimit(
+			config.Config.GetInt(dconfig.SettingElasticsearchFieldLimit),
+			config.Config.GetString(dconfig.SettingElasticsearchFieldLimitPolicy),
+		),
+		store.WithValueLengthLimit(
+			config.Config.GetInt(dconfig.SettingElasticsearchKeywordIgnoreAbove),
+			config.Config.GetInt(dconfig.SettingElasticsearchTruncateValuesAbove),
+		),
+		store.WithAttributeFilter(indexAttrsAllow, indexAttrsDeny),
+		store.WithAttributeTypes(attrTypes,
+			config.Config.GetString(dconfig.SettingElasticsearchAttributeTypePolicy),
+		),
+		store.WithScopeMappings(scopeMappings),
+		store.WithScaledFloats(scaledFloats),
+		store.WithILMPolicy(ilmPolicy),
+		store.WithIngestPipeline(ingestPipeline),
+		store.WithHistoryIndexName(historyIndexName),
+		store.WithBulkIndexer(bulkIndexer),
+		store.WithWarmUpQueries(warmUpQueries),
+		store.WithSigV4(sigV4),
+		store.WithOpaqueID(getOpaqueID()),
+		store.WithTraceLogging(
+			config.Config.GetBool(dconfig.SettingElasticsearchTrace),
+			config.Config.GetInt(dconfig.SettingElasticsearchTraceMaxBodySize),
+		),
 	)
 	if err != nil {
 		return nil, err
 	}
 	return store, nil
 }
+
+// getSigV4Config reads the AWS SigV4 signing config; the zero config,
+// i.e. no signing, if disabled
+func getSigV4Config() (store.SigV4Config, error) {
+	if !config.Config.GetBool(dconfig.SettingElasticsearchAWSSigV4) {
+		return store.SigV4Config{}, nil
+	}
+	region := config.Config.GetString(dconfig.SettingElasticsearchAWSRegion)
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	if region == "" {
+		return store.SigV4Config{}, errors.Errorf("%s is required to sign the requests",
+			dconfig.SettingElasticsearchAWSRegion)
+	}
+	return store.SigV4Config{
+		Region:  region,
+		Service: config.Config.GetString(dconfig.SettingElasticsearchAWSService),
+	}, nil
+}
+
+// getOpaqueID returns the X-Opaque-Id of the requests to Elasticsearch:
+// the service name, and the instance name or else the hostname
+func getOpaqueID() string {
+	name := config.Config.GetString(dconfig.SettingElasticsearchClientName)
+	if name == "" {
+		return ""
+	}
+	instance := config.Config.GetString(dconfig.SettingElasticsearchClientInstance)
+	if instance == "" {
+		instance, _ = os.Hostname()
+	}
+	if instance == "" {
+		return name
+	}
+	return name + "/" + instance
+}
+
+// getScopeMappings reads the scope mappings; the YAML decoder yields
+// map[interface{}]interface{} for the nested objects, which can't be
+// encoded as JSON, so the mappings are normalized
+func getScopeMappings() (map[string]map[string]interface{}, error) {
+	mappings := map[string]map[string]interface{}{}
+	for key, v := range config.Config.GetStringMap(dconfig.SettingElasticsearchScopeMappings) {
+		mapping, ok := normalizeMap(v).(map[string]interface{})
+		if !ok {
+			return nil, errors.Errorf("invalid %s: %s is not an object",
+				dconfig.SettingElasticsearchScopeMappings, key)
+		}
+		mappings[key] = mapping
+	}
+	return mappings, nil
+}
+
+// getTenantIndices reads the dedicated indices of the tenants; their
+// shards and replicas default to the ones of the shared index
+func getTenantIndices() (map[string]store.TenantIndex, error) {
+	const key = dconfig.SettingElasticsearchTenantIndices
+	shards := config.Config.GetInt(dconfig.SettingElasticsearchDevicesIndexShards)
+	replicas := config.Config.GetInt(dconfig.SettingElasticsearchDevicesIndexReplicas)
+
+	intField := func(
+		tid string,
+		m map[string]interface{},
+		field string,
+		def int,
+	) (int, error) {
+		val, ok := m[field]
+		if !ok {
+			return def, nil
+		}
+		n, err := strconv.Atoi(fmt.Sprint(val))
+		if err != nil {
+			return 0, errors.Errorf("invalid %s: %s.%s is not an integer",
+				key, tid, field)
+		}
+		return n, nil
+	}
+
+	indices := map[string]store.TenantIndex{}
+	for tid, v := range config.Config.GetStringMap(key) {
+		m, ok := normalizeMap(v).(map[string]interface{})
+		if !ok {
+			return nil, errors.Errorf("invalid %s: %s is not an object", key, tid)
+		}
+		index := store.TenantIndex{}
+		index.Name, _ = m["index"].(string)
+		var err error
+		if index.Shards, err = intField(tid, m, "shards", shards); err != nil {
+			return nil, err
+		}
+		if index.Replicas, err = intField(tid, m, "replicas", replicas); err != nil {
+			return nil, err
+		}
+		indices[tid] = index
+	}
+	return indices, nil
+}
+
+// getScaledFloats reads the scaling factors of the scaled float attributes
+func getScaledFloats() (map[string]float64, error) {
+	factors := map[string]float64{}
+	for key, v := range config.Config.GetStringMapString(
+		dconfig.SettingElasticsearchScaledFloats) {
+		factor, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, errors.Errorf("invalid %s: %s is not a number",
+				dconfig.SettingElasticsearchScaledFloats, key)
+		}
+		factors[key] = factor
+	}
+	return factors, nil
+}
+
+// getIngestPipeline reads the ingest pipeline, whose definition is either
+// a YAML object or, from the environment, a JSON object
+func getIngestPipeline() (store.IngestPipeline, error) {
+	pipeline := store.IngestPipeline{
+		Name: config.Config.GetString(dconfig.SettingElasticsearchIngestPipeline),
+	}
+	v := config.Config.Get(dconfig.SettingElasticsearchIngestPipelineDefinition)
+	if s, ok := v.(string); ok {
+		if s == "" {
+			return pipeline, nil
+		}
+		var definition interface{}
+		if err := json.Unmarshal([]byte(s), &definition); err != nil {
+			return pipeline, errors.Wrapf(err, "invalid %s",
+				dconfig.SettingElasticsearchIngestPipelineDefinition)
+		}
+		v = definition
+	} else if v == nil {
+		return pipeline, nil
+	}
+
+	definition, ok := normalizeMap(v).(map[string]interface{})
+	if !ok {
+		return pipeline, errors.Errorf("invalid %s: not an object",
+			dconfig.SettingElasticsearchIngestPipelineDefinition)
+	}
+	pipeline.Definition = definition
+	return pipeline, nil
+}
+
+// getWarmUpQueries reads the warm-up queries, either a YAML list or,
+// from the environment, a JSON list
+func getWarmUpQueries() ([]map[string]interface{}, error) {
+	v := config.Config.Get(dconfig.SettingElasticsearchWarmUpQueries)
+	if v == nil {
+		return nil, nil
+	} else if s, ok := v.(string); ok {
+		if s == "" {
+			return nil, nil
+		}
+		var queries []interface{}
+		if err := json.Unmarshal([]byte(s), &queries); err != nil {
+			return nil, errors.Wrapf(err, "invalid %s",
+				dconfig.SettingElasticsearchWarmUpQueries)
+		}
+		v = queries
+	}
+
+	list, ok := normalizeMap(v).([]interface{})
+	if !ok {
+		return nil, errors.Errorf("invalid %s: not a list",
+			dconfig.SettingElasticsearchWarmUpQueries)
+	}
+	queries := make([]map[string]interface{}, 0, len(list))
+	for i, q := range list {
+		query, ok := q.(map[string]interface{})
+		if !ok {
+			return nil, errors.Errorf("invalid %s: query %d is not an object",
+				dconfig.SettingElasticsearchWarmUpQueries, i)
+		}
+		queries = append(queries, query)
+	}
+	return queries, nil
+}
+
+func normalizeMap(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			m[fmt.Sprint(k)] = normalizeMap(val)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			m[k] = normalizeMap(val)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, val := range v {
+			s[i] = normalizeMap(val)
+		}
+		return s
+	}
+	return v
+}
@@ -0,0 +1,38 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import "strings"
+
+// AttributeMetadata are the display properties of an attribute, for the UI
+type AttributeMetadata struct {
+	DisplayName string `json:"display_name,omitempty"`
+	Description string `json:"description,omitempty"`
+	Unit        string `json:"unit,omitempty"`
+}
+
+// InvAttrMetadata is a searchable attribute, with its metadata if any
+type InvAttrMetadata struct {
+	Scope string `json:"scope"`
+	Name  string `json:"name"`
+	AttributeMetadata
+}
+
+// AttributeMetadataKey returns the key of the attribute's metadata,
+// "<scope>/<name>"; the keys are case-insensitive, as the configuration
+// keys are
+func AttributeMetadataKey(scope, name string) string {
+	return strings.ToLower(scope + "/" + name)
+}
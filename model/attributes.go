@@ -40,6 +40,24 @@ const (
 	typeBool = "bool"
 )
 
+// IsValidScope checks if scope is one of the attribute scopes
+func IsValidScope(scope string) bool {
+	switch scope {
+	case scopeInventory, scopeIdentity, scopeSystem, scopeTags, scopeMonitor:
+		return true
+	}
+	return false
+}
+
+// IsValidTypeSuffix checks if suffix is one of the attribute type suffixes
+func IsValidTypeSuffix(suffix string) bool {
+	switch suffix {
+	case typeStr, typeNum, typeBool:
+		return true
+	}
+	return false
+}
+
 var (
 	attrSuffixes = map[Type]string{
 		TypeStr:  typeStr,
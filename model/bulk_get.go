@@ -0,0 +1,67 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"github.com/pkg/errors"
+)
+
+// BulkGetMaxDevices is the max number of devices, across all the
+// tenants, fetched by a single bulk get
+const BulkGetMaxDevices = 1000
+
+var (
+	ErrBulkGetEmpty    = errors.New("at least one device id must be provided")
+	ErrBulkGetTooLarge = errors.Errorf(
+		"at most %d devices can be fetched at once", BulkGetMaxDevices)
+)
+
+// BulkGetParams are the device ids to fetch, keyed by tenant id
+type BulkGetParams map[string][]string
+
+func (p BulkGetParams) Validate() error {
+	n := 0
+	for tid, devs := range p {
+		if tid == "" {
+			return errors.New("tenant id must not be empty")
+		}
+		for _, d := range devs {
+			if d == "" {
+				return errors.New("device id must not be empty")
+			}
+		}
+		n += len(devs)
+	}
+	if n == 0 {
+		return ErrBulkGetEmpty
+	} else if n > BulkGetMaxDevices {
+		return ErrBulkGetTooLarge
+	}
+	return nil
+}
+
+// TenantInvDevice is an inventory device tagged with its tenant
+type TenantInvDevice struct {
+	TenantID string `json:"tenant_id"`
+	InvDevice
+}
+
+// BulkGetResult is the result of a cross-tenant bulk get; the devices
+// which don't exist, including the ones of the tenants without an index,
+// are listed by tenant in NotFound
+type BulkGetResult struct {
+	Devices  []TenantInvDevice   `json:"devices"`
+	NotFound map[string][]string `json:"not_found"`
+}
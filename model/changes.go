@@ -0,0 +1,145 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pkg/errors"
+)
+
+const (
+	// ChangesSizeDefault is the default number of devices of a changes page
+	ChangesSizeDefault = 100
+	// ChangesSizeMax is the max number of devices of a changes page
+	ChangesSizeMax = 1000
+)
+
+// ErrInvalidChangesCursor is returned for a malformed changes cursor
+var ErrInvalidChangesCursor = errors.New("invalid changes cursor")
+
+// ChangesParams selects the devices updated after Since, or after the last
+// device of the page Cursor was returned with, in ascending updated_ts
+// order; the devices without updated_ts are never returned
+type ChangesParams struct {
+	TenantID string     `json:"-"`
+	Groups   []string   `json:"-"`
+	Since    *time.Time `json:"since,omitempty"`
+	Cursor   string     `json:"cursor,omitempty"`
+	Size     int        `json:"size,omitempty"`
+}
+
+func (p ChangesParams) Validate() error {
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.Size, validation.Min(0), validation.Max(ChangesSizeMax)))
+}
+
+// ChangesPage is a page of the devices changes feed
+type ChangesPage struct {
+	Devices []InvDevice `json:"devices"`
+	// Cursor resumes the feed after this page; an empty page returns
+	// the cursor it was requested with, to poll again from the same position
+	Cursor string `json:"cursor,omitempty"`
+}
+
+// ChangesCursor is the position in the changes feed: the sort values,
+// i.e. updated_ts and id, of the last device returned
+type ChangesCursor struct {
+	TenantID    string        `json:"tid,omitempty"`
+	SearchAfter []interface{} `json:"after"`
+}
+
+// Encode returns the cursor as an opaque, URL-safe string
+func (c *ChangesCursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeChangesCursor parses a cursor returned by ChangesCursor.Encode
+func DecodeChangesCursor(cursor string) (*ChangesCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidChangesCursor
+	}
+	var c ChangesCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, ErrInvalidChangesCursor
+	}
+	if len(c.SearchAfter) != 2 {
+		return nil, ErrInvalidChangesCursor
+	}
+	return &c, nil
+}
+
+// BuildChangesQuery builds the query of a changes page, sorted by updated_ts
+// and then by id, which breaks the ties of the devices updated at the same
+// time; searchAfter are the sort values of the cursor, if any
+func BuildChangesQuery(params ChangesParams, searchAfter []interface{}) (Query, error) {
+	query := NewQuery()
+
+	if params.TenantID != "" {
+		query = query.Must(M{
+			"term": M{
+				"tenantID": params.TenantID,
+			},
+		})
+	}
+	if len(params.Groups) > 0 {
+		fpart, err := NewFilterIn(FilterPredicate{
+			Scope:     scopeSystem,
+			Attribute: AttrNameGroup,
+			Type:      "$in",
+			Value:     params.Groups,
+		})
+		if err != nil {
+			return nil, err
+		}
+		query = fpart.AddTo(query)
+	}
+
+	// the range also excludes the devices without updated_ts
+	if params.Since != nil {
+		query = query.Must(M{
+			"range": M{
+				"updatedAt": M{
+					"gt": params.Since.Format(time.RFC3339Nano),
+				},
+			},
+		})
+	} else {
+		query = query.Must(M{
+			"exists": M{
+				"field": "updatedAt",
+			},
+		})
+	}
+
+	size := params.Size
+	if size <= 0 {
+		size = ChangesSizeDefault
+	}
+	query = query.
+		WithSort(M{"updatedAt": "asc"}).
+		WithSort(M{"id": "asc"}).
+		WithPage(1, size)
+	if len(searchAfter) > 0 {
+		query = query.With(M{"search_after": searchAfter})
+	}
+
+	return query, nil
+}
@@ -0,0 +1,110 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChangesCursor(t *testing.T) {
+	cursor := &ChangesCursor{
+		TenantID:    "tenant1",
+		SearchAfter: []interface{}{float64(1629368732000), "dev2"},
+	}
+
+	encoded := cursor.Encode()
+	decoded, err := DecodeChangesCursor(encoded)
+	assert.NoError(t, err)
+	assert.Equal(t, cursor, decoded)
+
+	testCases := map[string]string{
+		"not base64":      "not a cursor!",
+		"not json":        base64.RawURLEncoding.EncodeToString([]byte("{")),
+		"no search_after": base64.RawURLEncoding.EncodeToString([]byte(`{"tid":"tenant1"}`)),
+		"no id":           base64.RawURLEncoding.EncodeToString([]byte(`{"after":[1]}`)),
+	}
+	for name, encoded := range testCases {
+		t.Run(name, func(t *testing.T) {
+			_, err := DecodeChangesCursor(encoded)
+			assert.Equal(t, ErrInvalidChangesCursor, err)
+		})
+	}
+}
+
+func TestChangesParamsValidate(t *testing.T) {
+	assert.NoError(t, ChangesParams{}.Validate())
+	assert.NoError(t, ChangesParams{Size: ChangesSizeMax}.Validate())
+	assert.Error(t, ChangesParams{Size: ChangesSizeMax + 1}.Validate())
+}
+
+func TestBuildChangesQuery(t *testing.T) {
+	since := time.Date(2021, 8, 19, 10, 25, 32, 0, time.UTC)
+
+	testCases := map[string]struct {
+		params      ChangesParams
+		searchAfter []interface{}
+
+		query string
+	}{
+		"since": {
+			params: ChangesParams{
+				TenantID: "tenant1",
+				Since:    &since,
+			},
+			query: `{
+				"query": {"bool": {"must": [
+					{"term": {"tenantID": "tenant1"}},
+					{"range": {"updatedAt": {"gt": "2021-08-19T10:25:32Z"}}}
+				]}},
+				"sort": [{"updatedAt": "asc"}, {"id": "asc"}],
+				"from": 0,
+				"size": 100
+			}`,
+		},
+		"cursor, groups": {
+			params: ChangesParams{
+				TenantID: "tenant1",
+				Groups:   []string{"prod"},
+				Size:     10,
+			},
+			searchAfter: []interface{}{float64(1629368732000), "dev2"},
+			query: `{
+				"query": {"bool": {"must": [
+					{"term": {"tenantID": "tenant1"}},
+					{"terms": {"system_group_str": ["prod"]}},
+					{"exists": {"field": "updatedAt"}}
+				]}},
+				"sort": [{"updatedAt": "asc"}, {"id": "asc"}],
+				"from": 0,
+				"size": 10,
+				"search_after": [1629368732000, "dev2"]
+			}`,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			query, err := BuildChangesQuery(tc.params, tc.searchAfter)
+			assert.NoError(t, err)
+			b, err := json.Marshal(query)
+			assert.NoError(t, err)
+			assert.JSONEq(t, tc.query, string(b))
+		})
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"reflect"
+	gosort "sort"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+)
+
+// CompareDevicesParams selects the two devices to compare; if Groups is
+// set, the devices must belong to one of the groups
+type CompareDevicesParams struct {
+	TenantID string   `json:"-"`
+	DeviceA  string   `json:"device_a"`
+	DeviceB  string   `json:"device_b"`
+	Groups   []string `json:"-"`
+}
+
+func (p CompareDevicesParams) Validate() error {
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.DeviceA, validation.Required),
+		validation.Field(&p.DeviceB, validation.Required))
+}
+
+// AttributeDiff is an attribute whose value differs between two devices
+type AttributeDiff struct {
+	Scope  string      `json:"scope"`
+	Name   string      `json:"name"`
+	ValueA interface{} `json:"value_a"`
+	ValueB interface{} `json:"value_b"`
+}
+
+// DeviceComparison is the diff of the attributes of two devices
+type DeviceComparison struct {
+	DeviceA string `json:"device_a"`
+	DeviceB string `json:"device_b"`
+	// Common are the attributes with the same value on both the devices
+	Common []InvDeviceAttribute `json:"common"`
+	// OnlyInA are the attributes of device A only
+	OnlyInA []InvDeviceAttribute `json:"only_in_a"`
+	// OnlyInB are the attributes of device B only
+	OnlyInB []InvDeviceAttribute `json:"only_in_b"`
+	// Different are the attributes of both the devices, with different values
+	Different []AttributeDiff `json:"different"`
+}
+
+// CompareDevices returns the diff of the attributes of devices a and b,
+// each list sorted by scope and name
+func CompareDevices(a, b *Device) *DeviceComparison {
+	valuesA := attributeValues(a)
+	valuesB := attributeValues(b)
+
+	ret := &DeviceComparison{
+		DeviceA:   a.GetID(),
+		DeviceB:   b.GetID(),
+		Common:    []InvDeviceAttribute{},
+		OnlyInA:   []InvDeviceAttribute{},
+		OnlyInB:   []InvDeviceAttribute{},
+		Different: []AttributeDiff{},
+	}
+	attr := func(k attributeKey, val interface{}) InvDeviceAttribute {
+		return InvDeviceAttribute{
+			Scope: k.scope,
+			Name:  k.name,
+			Value: val,
+		}
+	}
+	for k, valA := range valuesA {
+		valB, ok := valuesB[k]
+		switch {
+		case !ok:
+			ret.OnlyInA = append(ret.OnlyInA, attr(k, valA))
+		case reflect.DeepEqual(valA, valB):
+			ret.Common = append(ret.Common, attr(k, valA))
+		default:
+			ret.Different = append(ret.Different, AttributeDiff{
+				Scope:  k.scope,
+				Name:   k.name,
+				ValueA: valA,
+				ValueB: valB,
+			})
+		}
+	}
+	for k, valB := range valuesB {
+		if _, ok := valuesA[k]; !ok {
+			ret.OnlyInB = append(ret.OnlyInB, attr(k, valB))
+		}
+	}
+
+	sortAttrs := func(attrs []InvDeviceAttribute) {
+		gosort.Slice(attrs, func(i, j int) bool {
+			if attrs[i].Scope != attrs[j].Scope {
+				return attrs[i].Scope < attrs[j].Scope
+			}
+			return attrs[i].Name < attrs[j].Name
+		})
+	}
+	sortAttrs(ret.Common)
+	sortAttrs(ret.OnlyInA)
+	sortAttrs(ret.OnlyInB)
+	gosort.Slice(ret.Different, func(i, j int) bool {
+		if ret.Different[i].Scope != ret.Different[j].Scope {
+			return ret.Different[i].Scope < ret.Different[j].Scope
+		}
+		return ret.Different[i].Name < ret.Different[j].Name
+	})
+
+	return ret
+}
@@ -0,0 +1,121 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareDevices(t *testing.T) {
+	newDevice := func(id string, attrs ...*InventoryAttribute) *Device {
+		dev := NewDevice(id).SetTenantID("tenant1")
+		for _, a := range attrs {
+			_ = dev.AppendAttr(a)
+		}
+		return dev
+	}
+	attr := func(scope, name string) *InventoryAttribute {
+		return NewInventoryAttribute(scope).SetName(name)
+	}
+
+	testCases := map[string]struct {
+		a *Device
+		b *Device
+
+		res *DeviceComparison
+	}{
+		"ok": {
+			a: newDevice("dev1",
+				attr(AttrScopeIdentity, "mac").SetString("00:11"),
+				attr(AttrScopeInventory, "os").SetString("linux"),
+				attr(AttrScopeInventory, "mem").SetNumeric(1024),
+				attr(AttrScopeInventory, "kernel").SetString("5.10"),
+				attr(AttrScopeTags, "location").SetString("lab"),
+			),
+			b: newDevice("dev2",
+				attr(AttrScopeIdentity, "mac").SetString("00:22"),
+				attr(AttrScopeInventory, "os").SetString("linux"),
+				attr(AttrScopeInventory, "mem").SetNumeric(2048),
+				attr(AttrScopeInventory, "bootloader").SetString("u-boot"),
+			),
+
+			res: &DeviceComparison{
+				DeviceA: "dev1",
+				DeviceB: "dev2",
+				Common: []InvDeviceAttribute{
+					{Scope: AttrScopeInventory, Name: "os", Value: []string{"linux"}},
+				},
+				OnlyInA: []InvDeviceAttribute{
+					{Scope: AttrScopeInventory, Name: "kernel", Value: []string{"5.10"}},
+					{Scope: AttrScopeTags, Name: "location", Value: []string{"lab"}},
+				},
+				OnlyInB: []InvDeviceAttribute{
+					{Scope: AttrScopeInventory, Name: "bootloader", Value: []string{"u-boot"}},
+				},
+				Different: []AttributeDiff{
+					{
+						Scope:  AttrScopeIdentity,
+						Name:   "mac",
+						ValueA: []string{"00:11"},
+						ValueB: []string{"00:22"},
+					},
+					{
+						Scope:  AttrScopeInventory,
+						Name:   "mem",
+						ValueA: []float64{1024},
+						ValueB: []float64{2048},
+					},
+				},
+			},
+		},
+		"ok, identical devices": {
+			a: newDevice("dev1", attr(AttrScopeInventory, "os").SetString("linux")),
+			b: newDevice("dev2", attr(AttrScopeInventory, "os").SetString("linux")),
+
+			res: &DeviceComparison{
+				DeviceA: "dev1",
+				DeviceB: "dev2",
+				Common: []InvDeviceAttribute{
+					{Scope: AttrScopeInventory, Name: "os", Value: []string{"linux"}},
+				},
+				OnlyInA:   []InvDeviceAttribute{},
+				OnlyInB:   []InvDeviceAttribute{},
+				Different: []AttributeDiff{},
+			},
+		},
+		"ok, no attributes": {
+			a: newDevice("dev1"),
+			b: newDevice("dev2", attr(AttrScopeInventory, "os").SetString("linux")),
+
+			res: &DeviceComparison{
+				DeviceA: "dev1",
+				DeviceB: "dev2",
+				Common:  []InvDeviceAttribute{},
+				OnlyInA: []InvDeviceAttribute{},
+				OnlyInB: []InvDeviceAttribute{
+					{Scope: AttrScopeInventory, Name: "os", Value: []string{"linux"}},
+				},
+				Different: []AttributeDiff{},
+			},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.res, CompareDevices(tc.a, tc.b))
+		})
+	}
+}
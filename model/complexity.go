@@ -0,0 +1,110 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrQueryTooComplex = errors.New("query is too complex")
+)
+
+// boolOccurrences are the keys of the clauses of a bool query
+var boolOccurrences = []string{"must", "must_not", "should", "filter"}
+
+// ComplexityLimits bound the complexity of the compiled queries, i.e.
+// their total number of bool clauses and the nesting depth of the bool
+// queries (0: no limit)
+type ComplexityLimits struct {
+	MaxClauses int
+	MaxDepth   int
+}
+
+// QueryComplexity is the complexity of a compiled query
+type QueryComplexity struct {
+	Clauses int
+	Depth   int
+}
+
+// GetQueryComplexity counts the bool clauses, and the nesting depth of the
+// bool queries, of the query and its post_filter
+func GetQueryComplexity(q Query) (*QueryComplexity, error) {
+	b, err := json.Marshal(q)
+	if err != nil {
+		return nil, err
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(b, &body); err != nil {
+		return nil, err
+	}
+
+	c := &QueryComplexity{}
+	c.walk(body["query"], 0)
+	c.walk(body["post_filter"], 0)
+	return c, nil
+}
+
+func (c *QueryComplexity) walk(node interface{}, depth int) {
+	switch node := node.(type) {
+	case map[string]interface{}:
+		for key, v := range node {
+			clause, ok := v.(map[string]interface{})
+			if key != "bool" || !ok {
+				c.walk(v, depth)
+				continue
+			}
+			if depth+1 > c.Depth {
+				c.Depth = depth + 1
+			}
+			for _, occur := range boolOccurrences {
+				switch clauses := clause[occur].(type) {
+				case []interface{}:
+					c.Clauses += len(clauses)
+					c.walk(clauses, depth+1)
+				case map[string]interface{}:
+					c.Clauses++
+					c.walk(clauses, depth+1)
+				}
+			}
+		}
+	case []interface{}:
+		for _, v := range node {
+			c.walk(v, depth)
+		}
+	}
+}
+
+// CheckComplexity returns ErrQueryTooComplex if the query exceeds the limits
+func CheckComplexity(q Query, limits ComplexityLimits) error {
+	if limits.MaxClauses <= 0 && limits.MaxDepth <= 0 {
+		return nil
+	}
+	c, err := GetQueryComplexity(q)
+	if err != nil {
+		return err
+	}
+	if limits.MaxClauses > 0 && c.Clauses > limits.MaxClauses {
+		return fmt.Errorf("%w: %d clauses, the limit is %d",
+			ErrQueryTooComplex, c.Clauses, limits.MaxClauses)
+	}
+	if limits.MaxDepth > 0 && c.Depth > limits.MaxDepth {
+		return fmt.Errorf("%w: %d nested bool queries, the limit is %d",
+			ErrQueryTooComplex, c.Depth, limits.MaxDepth)
+	}
+	return nil
+}
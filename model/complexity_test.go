@@ -0,0 +1,94 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckComplexity(t *testing.T) {
+	eq := func(attr, value string) FilterPredicate {
+		return FilterPredicate{
+			Scope:     "inventory",
+			Attribute: attr,
+			Type:      "$eq",
+			Value:     value,
+		}
+	}
+	// bool{must: [term, bool{should: [bool{must: [term]}, bool{must: [term]}]}]},
+	// post_filter: bool{must: [term]}
+	q, err := BuildQuery(SearchParams{
+		Filters:     []FilterPredicate{eq("os", "linux")},
+		Or:          [][]FilterPredicate{{eq("arch", "arm")}, {eq("arch", "x86")}},
+		PostFilters: []FilterPredicate{eq("kernel", "5.10")},
+	})
+	assert.NoError(t, err)
+
+	c, err := GetQueryComplexity(q)
+	assert.NoError(t, err)
+	assert.Equal(t, &QueryComplexity{Clauses: 7, Depth: 3}, c)
+
+	testCases := map[string]struct {
+		limits ComplexityLimits
+
+		err string
+	}{
+		"ok, no limits": {},
+		"ok, at the limits": {
+			limits: ComplexityLimits{MaxClauses: 7, MaxDepth: 3},
+		},
+		"error, too many clauses": {
+			limits: ComplexityLimits{MaxClauses: 6, MaxDepth: 3},
+			err:    "query is too complex: 7 clauses, the limit is 6",
+		},
+		"error, too deep": {
+			limits: ComplexityLimits{MaxClauses: 7, MaxDepth: 2},
+			err:    "query is too complex: 3 nested bool queries, the limit is 2",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := CheckComplexity(q, tc.limits)
+			if tc.err != "" {
+				assert.True(t, errors.Is(err, ErrQueryTooComplex))
+				assert.EqualError(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestGetQueryComplexitySample(t *testing.T) {
+	// the function_score wrapping doesn't add any bool clause
+	q, err := BuildQuery(SearchParams{
+		Filters: []FilterPredicate{{
+			Scope:     "inventory",
+			Attribute: "os",
+			Type:      "$in",
+			Value:     []interface{}{"linux", "bsd", "windows"},
+		}},
+		Sample:         &SampleParams{Seed: 1},
+		MaxClauseCount: 2,
+	})
+	assert.NoError(t, err)
+
+	c, err := GetQueryComplexity(q)
+	assert.NoError(t, err)
+	assert.Equal(t, &QueryComplexity{Clauses: 3, Depth: 2}, c)
+}
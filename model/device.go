@@ -43,8 +43,29 @@ type Device struct {
 }
 
 type DeviceMeta struct {
-	SeqNo       int64
-	PrimaryTerm int64
+	SeqNo       int64 `json:"seq_no"`
+	PrimaryTerm int64 `json:"primary_term"`
+	// Version is the external (upstream) version of the device, e.g. the
+	// source revision; if set, the older versions are not written
+	Version int64 `json:"version,omitempty"`
+}
+
+// ParseDeviceMeta parses the sequence number and primary term of a device
+// document or search hit, for the optimistic concurrency control of its
+// updates
+func ParseDeviceMeta(doc map[string]interface{}) (*DeviceMeta, error) {
+	seqNo, ok := doc["_seq_no"].(float64)
+	if !ok {
+		return nil, errors.New("can't process ES _seq_no")
+	}
+	primaryTerm, ok := doc["_primary_term"].(float64)
+	if !ok {
+		return nil, errors.New("can't process ES _primary_term")
+	}
+	return &DeviceMeta{
+		SeqNo:       int64(seqNo),
+		PrimaryTerm: int64(primaryTerm),
+	}, nil
 }
 
 func (d *Device) WithMeta(m *DeviceMeta) *Device {
@@ -143,6 +164,81 @@ func (a *Device) AppendAttr(attr *InventoryAttribute) error {
 
 }
 
+// scopeAttrs returns the attributes of the given scope, nil if unknown
+func (a *Device) scopeAttrs(scope string) *DeviceInventory {
+	switch scope {
+	case scopeIdentity:
+		return &a.IdentityAttributes
+	case scopeInventory:
+		return &a.InventoryAttributes
+	case scopeMonitor:
+		return &a.MonitorAttributes
+	case scopeSystem:
+		return &a.SystemAttributes
+	case scopeTags:
+		return &a.TagsAttributes
+	default:
+		return nil
+	}
+}
+
+// MergeScopes replaces the attributes of the given scopes with src's,
+// leaving the other scopes untouched; the fields promoted from the
+// attributes (status, group) follow their scopes
+func (a *Device) MergeScopes(src *Device, scopes []string) {
+	for _, scope := range scopes {
+		attrs := a.scopeAttrs(scope)
+		if attrs == nil {
+			continue
+		}
+		*attrs = *src.scopeAttrs(scope)
+
+		switch scope {
+		case scopeIdentity:
+			a.Status = src.Status
+		case scopeSystem:
+			a.GroupName = src.GroupName
+		}
+	}
+}
+
+// Copy returns a deep copy of the device, e.g. to merge into it without
+// altering the original
+func (a *Device) Copy() *Device {
+	cp := *a
+	cp.ID = copyString(a.ID)
+	cp.TenantID = copyString(a.TenantID)
+	cp.Name = copyString(a.Name)
+	cp.GroupName = copyString(a.GroupName)
+	cp.Status = copyString(a.Status)
+	cp.IdentityAttributes = a.IdentityAttributes.copy()
+	cp.InventoryAttributes = a.InventoryAttributes.copy()
+	cp.MonitorAttributes = a.MonitorAttributes.copy()
+	cp.SystemAttributes = a.SystemAttributes.copy()
+	cp.TagsAttributes = a.TagsAttributes.copy()
+	if a.CreatedAt != nil {
+		createdAt := *a.CreatedAt
+		cp.CreatedAt = &createdAt
+	}
+	if a.UpdatedAt != nil {
+		updatedAt := *a.UpdatedAt
+		cp.UpdatedAt = &updatedAt
+	}
+	if a.Meta != nil {
+		meta := *a.Meta
+		cp.Meta = &meta
+	}
+	return &cp
+}
+
+func copyString(s *string) *string {
+	if s == nil {
+		return nil
+	}
+	cp := *s
+	return &cp
+}
+
 func (a *Device) GetID() string {
 	if a.ID != nil {
 		return *a.ID
@@ -229,6 +325,30 @@ func (a *Device) SetUpdatedAt(val time.Time) *Device {
 
 type DeviceInventory []*InventoryAttribute
 
+func (inv DeviceInventory) copy() DeviceInventory {
+	if inv == nil {
+		return nil
+	}
+	cp := make(DeviceInventory, len(inv))
+	for i, attr := range inv {
+		if attr == nil {
+			continue
+		}
+		attrCp := *attr
+		if attr.String != nil {
+			attrCp.String = append([]string{}, attr.String...)
+		}
+		if attr.Numeric != nil {
+			attrCp.Numeric = append([]float64{}, attr.Numeric...)
+		}
+		if attr.Boolean != nil {
+			attrCp.Boolean = append([]bool{}, attr.Boolean...)
+		}
+		cp[i] = &attrCp
+	}
+	return cp
+}
+
 type InventoryAttribute struct {
 	Scope   string
 	Name    string
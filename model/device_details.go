@@ -0,0 +1,67 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+)
+
+// DeviceParams selects a single device; if Groups is set,
+// the device must belong to one of the groups
+type DeviceParams struct {
+	TenantID string   `json:"-"`
+	DeviceID string   `json:"device_id"`
+	Groups   []string `json:"-"`
+}
+
+func (p DeviceParams) Validate() error {
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.DeviceID, validation.Required))
+}
+
+// ScopedAttributes are a device's attribute values by scope and name,
+// i.e. {"inventory": {"kernel": ...}, "identity": {"mac": ...}}
+type ScopedAttributes map[string]map[string]interface{}
+
+// GroupedInvDevice is the inventory device with its attributes grouped
+// by scope rather than flattened in a list, e.g. for a device detail page
+type GroupedInvDevice struct {
+	ID         DeviceID         `json:"id"`
+	Attributes ScopedAttributes `json:"attributes"`
+	UpdatedTs  time.Time        `json:"updated_ts"`
+}
+
+// GroupByScope returns the device with its attributes grouped by scope;
+// the attributes with no scope are inventory ones
+func (d *InvDevice) GroupByScope() *GroupedInvDevice {
+	attrs := make(ScopedAttributes)
+	for _, attr := range d.Attributes {
+		scope := attr.Scope
+		if scope == "" {
+			scope = AttrScopeInventory
+		}
+		if attrs[scope] == nil {
+			attrs[scope] = make(map[string]interface{})
+		}
+		attrs[scope][attr.Name] = attr.Value
+	}
+	return &GroupedInvDevice{
+		ID:         d.ID,
+		Attributes: attrs,
+		UpdatedTs:  d.UpdatedTs,
+	}
+}
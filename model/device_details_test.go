@@ -0,0 +1,63 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroupByScope(t *testing.T) {
+	updated := time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)
+	dev := &InvDevice{
+		ID: "dev1",
+		Attributes: DeviceAttributes{
+			{Scope: AttrScopeInventory, Name: "device_type", Value: "rpi4"},
+			{Scope: AttrScopeInventory, Name: "kernel", Value: []interface{}{"5.10", "5.15"}},
+			{Scope: AttrScopeIdentity, Name: "mac", Value: "00:11:22:33:44:55"},
+			{Scope: AttrScopeSystem, Name: AttrNameGroup, Value: "prod"},
+			{Scope: AttrScopeSystem, Name: AttrNameStatus, Value: "accepted"},
+			{Name: "artifact_name", Value: "release-1"},
+		},
+		UpdatedTs: updated,
+	}
+
+	assert.Equal(t, &GroupedInvDevice{
+		ID: "dev1",
+		Attributes: ScopedAttributes{
+			AttrScopeInventory: {
+				"device_type":   "rpi4",
+				"kernel":        []interface{}{"5.10", "5.15"},
+				"artifact_name": "release-1",
+			},
+			AttrScopeIdentity: {
+				"mac": "00:11:22:33:44:55",
+			},
+			AttrScopeSystem: {
+				AttrNameGroup:  "prod",
+				AttrNameStatus: "accepted",
+			},
+		},
+		UpdatedTs: updated,
+	}, dev.GroupByScope())
+
+	// the flat form is untouched
+	assert.Len(t, dev.Attributes, 6)
+
+	empty := (&InvDevice{ID: "dev2"}).GroupByScope()
+	assert.Equal(t, ScopedAttributes{}, empty.Attributes)
+}
@@ -0,0 +1,44 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+)
+
+// DevicesExistMaxDevices is the max number of device ids checked
+// by a single existence check
+const DevicesExistMaxDevices = 1000
+
+// DevicesExistParams are the device ids of the tenant to check
+type DevicesExistParams struct {
+	TenantID  string   `json:"tenant_id"`
+	DeviceIDs []string `json:"device_ids"`
+}
+
+func (p DevicesExistParams) Validate() error {
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.TenantID, validation.Required),
+		validation.Field(&p.DeviceIDs,
+			validation.Required,
+			validation.Length(1, DevicesExistMaxDevices),
+			validation.Each(validation.Required)))
+}
+
+// DevicesExistResult lists the checked device ids which are indexed,
+// in the order they were requested
+type DevicesExistResult struct {
+	DeviceIDs []string `json:"device_ids"`
+}
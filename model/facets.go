@@ -0,0 +1,187 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pkg/errors"
+)
+
+const (
+	// FacetsSizeDefault is the default number of facet buckets per page
+	FacetsSizeDefault = 100
+	// FacetsSizeMax is the max number of facet buckets per page
+	FacetsSizeMax = 1000
+
+	facetsAggName    = "facets"
+	facetsSourceName = "value"
+)
+
+// FacetsParams selects the attribute whose distinct values are counted,
+// over the devices matching the filters; the buckets are paginated
+// by passing the AfterKey of a page as the After of the next one.
+// The PostFilters don't narrow the counts, see SearchParams.PostFilters.
+type FacetsParams struct {
+	Scope       string            `json:"scope"`
+	Attribute   string            `json:"attribute"`
+	Size        int               `json:"size"`
+	After       interface{}       `json:"after,omitempty"`
+	Filters     []FilterPredicate `json:"filters"`
+	PostFilters []FilterPredicate `json:"post_filters,omitempty"`
+	Groups      []string          `json:"-"`
+	TenantID    string            `json:"-"`
+}
+
+// FacetBucket is the number of devices with a given attribute value
+type FacetBucket struct {
+	Value interface{} `json:"value"`
+	Count int         `json:"count"`
+}
+
+// Facets is a page of facet buckets; AfterKey is empty on the last page
+type Facets struct {
+	Buckets  []FacetBucket `json:"buckets"`
+	AfterKey interface{}   `json:"after_key,omitempty"`
+}
+
+func (p FacetsParams) Validate() error {
+	err := validation.ValidateStruct(&p,
+		validation.Field(&p.Scope, validation.Required),
+		validation.Field(&p.Attribute, validation.Required),
+		validation.Field(&p.Size, validation.Min(0), validation.Max(FacetsSizeMax)))
+	if err != nil {
+		return err
+	}
+
+	for _, f := range p.Filters {
+		if err := f.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, f := range p.PostFilters {
+		if err := f.Validate(); err != nil {
+			return errors.Wrap(err, "post_filters")
+		}
+	}
+	return nil
+}
+
+// BuildFacetsQuery builds the composite aggregation over the values of
+// the (string) attribute, restricted to the devices matching the filters
+func BuildFacetsQuery(params FacetsParams) (Query, error) {
+	query, err := BuildQuery(SearchParams{
+		Filters:     params.Filters,
+		PostFilters: params.PostFilters,
+		Groups:      params.Groups,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if params.TenantID != "" {
+		query = query.Must(M{
+			"term": M{
+				"tenantID": params.TenantID,
+			},
+		})
+	}
+
+	size := params.Size
+	if size <= 0 {
+		size = FacetsSizeDefault
+	}
+
+	composite := M{
+		"size": size,
+		"sources": []M{{
+			facetsSourceName: M{
+				"terms": M{
+					"field": ToAttr(params.Scope, params.Attribute, TypeStr),
+				},
+			},
+		}},
+	}
+	if params.After != nil {
+		composite["after"] = M{
+			facetsSourceName: params.After,
+		}
+	}
+
+	// no hits, just the aggregation
+	return query.WithPage(1, 0).With(M{
+		"aggs": M{
+			facetsAggName: M{
+				"composite": composite,
+			},
+		},
+	}), nil
+}
+
+// ParseFacetsAggregation parses the result of the query built with
+// BuildFacetsQuery, requesting size buckets
+func ParseFacetsAggregation(res M, size int) (*Facets, error) {
+	if size <= 0 {
+		size = FacetsSizeDefault
+	}
+
+	aggs, ok := res["aggregations"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("can't process store aggregations")
+	}
+
+	agg, ok := aggs[facetsAggName].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("can't process facets aggregation")
+	}
+
+	buckets, ok := agg["buckets"].([]interface{})
+	if !ok {
+		return nil, errors.New("can't process facets aggregation buckets")
+	}
+
+	ret := &Facets{
+		Buckets: make([]FacetBucket, 0, len(buckets)),
+	}
+	for _, b := range buckets {
+		bucket, ok := b.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("can't process facets aggregation bucket")
+		}
+
+		key, ok := bucket["key"].(map[string]interface{})
+		if !ok {
+			return nil, errors.New("can't process facets aggregation bucket key")
+		}
+
+		count, ok := bucket["doc_count"].(float64)
+		if !ok {
+			return nil, errors.New("can't process facets aggregation bucket count")
+		}
+
+		ret.Buckets = append(ret.Buckets, FacetBucket{
+			Value: key[facetsSourceName],
+			Count: int(count),
+		})
+	}
+
+	// ES returns the after_key of the last bucket even if there are no
+	// more; a short page is the last one, which saves a request
+	if afterKey, ok := agg["after_key"].(map[string]interface{}); ok &&
+		len(buckets) == size {
+		ret.AfterKey = afterKey[facetsSourceName]
+	}
+
+	return ret, nil
+}
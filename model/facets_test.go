@@ -0,0 +1,242 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildFacetsQuery(t *testing.T) {
+	testCases := map[string]struct {
+		params FacetsParams
+
+		query string
+	}{
+		"ok, first page": {
+			params: FacetsParams{
+				Scope:     "inventory",
+				Attribute: "device_type",
+				Size:      10,
+				TenantID:  "tenant1",
+			},
+			query: `{
+				"query": {"bool": {"must": [{"term": {"tenantID": "tenant1"}}]}},
+				"from": 0,
+				"size": 0,
+				"aggs": {
+					"facets": {
+						"composite": {
+							"size": 10,
+							"sources": [{
+								"value": {"terms": {"field": "inventory_device_type_str"}}
+							}]
+						}
+					}
+				}
+			}`,
+		},
+		"ok, next page, with filters": {
+			params: FacetsParams{
+				Scope:     "inventory",
+				Attribute: "device_type",
+				After:     "raspberrypi3",
+				Filters: []FilterPredicate{{
+					Scope:     "identity",
+					Attribute: "status",
+					Type:      "$eq",
+					Value:     "accepted",
+				}},
+				Groups:   []string{"group1"},
+				TenantID: "tenant1",
+			},
+			query: `{
+				"query": {"bool": {"must": [
+					{"term": {"identity_status_str": "accepted"}},
+					{"terms": {"system_group_str": ["group1"]}},
+					{"term": {"tenantID": "tenant1"}}
+				]}},
+				"from": 0,
+				"size": 0,
+				"aggs": {
+					"facets": {
+						"composite": {
+							"size": 100,
+							"sources": [{
+								"value": {"terms": {"field": "inventory_device_type_str"}}
+							}],
+							"after": {"value": "raspberrypi3"}
+						}
+					}
+				}
+			}`,
+		},
+		"ok, selected facet values as post filters": {
+			params: FacetsParams{
+				Scope:     "inventory",
+				Attribute: "device_type",
+				Filters: []FilterPredicate{{
+					Scope:     "identity",
+					Attribute: "status",
+					Type:      "$eq",
+					Value:     "accepted",
+				}},
+				PostFilters: []FilterPredicate{{
+					Scope:     "inventory",
+					Attribute: "device_type",
+					Type:      "$in",
+					Value:     []string{"raspberrypi4"},
+				}},
+				TenantID: "tenant1",
+			},
+			// the selected values don't narrow the aggregation, which
+			// only runs over the main query
+			query: `{
+				"query": {"bool": {"must": [
+					{"term": {"identity_status_str": "accepted"}},
+					{"term": {"tenantID": "tenant1"}}
+				]}},
+				"post_filter": {"bool": {"must": [
+					{"terms": {"inventory_device_type_str": ["raspberrypi4"]}}
+				]}},
+				"from": 0,
+				"size": 0,
+				"aggs": {
+					"facets": {
+						"composite": {
+							"size": 100,
+							"sources": [{
+								"value": {"terms": {"field": "inventory_device_type_str"}}
+							}]
+						}
+					}
+				}
+			}`,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			query, err := BuildFacetsQuery(tc.params)
+			assert.NoError(t, err)
+			b, err := json.Marshal(query)
+			assert.NoError(t, err)
+			assert.JSONEq(t, tc.query, string(b))
+		})
+	}
+}
+
+func TestParseFacetsAggregation(t *testing.T) {
+	testCases := map[string]struct {
+		res  string
+		size int
+
+		facets *Facets
+		err    string
+	}{
+		"ok, more pages": {
+			res: `{"aggregations": {"facets": {
+				"after_key": {"value": "raspberrypi4"},
+				"buckets": [
+					{"key": {"value": "qemux86-64"}, "doc_count": 4},
+					{"key": {"value": "raspberrypi4"}, "doc_count": 12}
+				]
+			}}}`,
+			size: 2,
+			facets: &Facets{
+				Buckets: []FacetBucket{
+					{Value: "qemux86-64", Count: 4},
+					{Value: "raspberrypi4", Count: 12},
+				},
+				AfterKey: "raspberrypi4",
+			},
+		},
+		"ok, last page": {
+			res: `{"aggregations": {"facets": {
+				"after_key": {"value": "raspberrypi4"},
+				"buckets": [
+					{"key": {"value": "raspberrypi4"}, "doc_count": 12}
+				]
+			}}}`,
+			size: 2,
+			facets: &Facets{
+				Buckets: []FacetBucket{
+					{Value: "raspberrypi4", Count: 12},
+				},
+			},
+		},
+		"ok, no buckets": {
+			res: `{"aggregations": {"facets": {"buckets": []}}}`,
+			facets: &Facets{
+				Buckets: []FacetBucket{},
+			},
+		},
+		"error, no aggregation": {
+			res: `{"hits": {}}`,
+			err: "can't process store aggregations",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var res M
+			_ = json.Unmarshal([]byte(tc.res), &res)
+			facets, err := ParseFacetsAggregation(res, tc.size)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.facets, facets)
+			}
+		})
+	}
+}
+
+func TestFacetsAfterKeyRoundTrip(t *testing.T) {
+	res := M{}
+	_ = json.Unmarshal([]byte(`{"aggregations": {"facets": {
+		"after_key": {"value": "raspberrypi4"},
+		"buckets": [{"key": {"value": "raspberrypi4"}, "doc_count": 12}]
+	}}}`), &res)
+	facets, err := ParseFacetsAggregation(res, 1)
+	assert.NoError(t, err)
+
+	// the after_key, as sent to the client and back, continues the pagination
+	b, _ := json.Marshal(facets)
+	var page map[string]interface{}
+	_ = json.Unmarshal(b, &page)
+	params := FacetsParams{
+		Scope:     "inventory",
+		Attribute: "device_type",
+		After:     page["after_key"],
+	}
+
+	query, err := BuildFacetsQuery(params)
+	assert.NoError(t, err)
+	b, _ = json.Marshal(query)
+
+	var q struct {
+		Aggs struct {
+			Facets struct {
+				Composite struct {
+					After map[string]interface{} `json:"after"`
+				} `json:"composite"`
+			} `json:"facets"`
+		} `json:"aggs"`
+	}
+	_ = json.Unmarshal(b, &q)
+	assert.Equal(t, map[string]interface{}{"value": "raspberrypi4"},
+		q.Aggs.Facets.Composite.After)
+}
@@ -15,7 +15,10 @@
 package model
 
 import (
+	"encoding/json"
 	"fmt"
+	"regexp"
+	"strings"
 
 	validation "github.com/go-ozzo/ozzo-validation/v4"
 	"github.com/pkg/errors"
@@ -23,6 +26,7 @@ import (
 
 var validSelectors = []interface{}{
 	"$eq",
+	"$contains",
 	"$gt",
 	"$gte",
 	"$in",
@@ -32,19 +36,146 @@ var validSelectors = []interface{}{
 	"$nin",
 	"$exists",
 	"$regex",
+	"$match",
+	"$fuzzy",
 }
 
+// validMatchOperators are the ES match query operators: "or" matches
+// any of the analyzed words, "and" all of them
+var validMatchOperators = []interface{}{"and", "or"}
+
+// validFuzziness matches the ES fuzziness values: the max edit distance,
+// or AUTO (optionally with the low/high word lengths, e.g. AUTO:3,6)
+var validFuzziness = regexp.MustCompile(`^([0-2]|AUTO(:[0-9]+,[0-9]+)?)$`)
+
 var validSortOrders = []interface{}{"asc", "desc"}
 
+// validSortModes are the ES sort modes, selecting the value
+// a multi-valued (array) attribute is sorted by
+var validSortModes = []interface{}{"min", "max", "avg", "sum", "median"}
+
+// validPreference matches the custom search preferences (e.g. session ids);
+// the values starting with '_' are reserved by ES for its built-in preferences
+var validPreference = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,127}$`)
+
+// validTimeout matches the ES time values of the search timeout, e.g. 2s
+var validTimeout = regexp.MustCompile(`^[0-9]+(nanos|micros|ms|s|m|h|d)$`)
+
+// ES search types; dfs_query_then_fetch computes the term frequencies
+// across all the shards first, for a consistent full-text scoring
+const (
+	SearchTypeQueryThenFetch    = "query_then_fetch"
+	SearchTypeDFSQueryThenFetch = "dfs_query_then_fetch"
+)
+
 type SearchParams struct {
-	Page       int               `json:"page"`
-	PerPage    int               `json:"per_page"`
-	Filters    []FilterPredicate `json:"filters"`
-	Sort       []SortCriteria    `json:"sort"`
-	Attributes []SelectAttribute `json:"attributes"`
-	DeviceIDs  []string          `json:"device_ids"`
-	Groups     []string          `json:"-"`
-	TenantID   string            `json:"-"`
+	Page    int               `json:"page"`
+	PerPage int               `json:"per_page"`
+	Filters []FilterPredicate `json:"filters"`
+	// PostFilters filter the hits but not the aggregations, e.g. the
+	// facet values selected in a faceted search, so that the facets
+	// still count the devices with the other values
+	PostFilters []FilterPredicate `json:"post_filters,omitempty"`
+	// Or are groups of filters of which at least one must match,
+	// in addition to the Filters; the filters of a group are ANDed
+	Or                [][]FilterPredicate `json:"or,omitempty"`
+	Sort              []SortCriteria      `json:"sort"`
+	Attributes        []SelectAttribute   `json:"attributes"`
+	ExcludeAttributes []SelectAttribute   `json:"exclude_attributes"`
+	DeviceIDs         []string            `json:"device_ids"`
+	Preference        string              `json:"preference,omitempty"`
+	SearchType        string              `json:"search_type,omitempty"`
+	Highlight         *HighlightParams    `json:"highlight,omitempty"`
+	Profile           bool                `json:"profile,omitempty"`
+	TerminateAfter    int                 `json:"terminate_after,omitempty"`
+	Groups            []string            `json:"-"`
+	TenantID          string              `json:"-"`
+	// TrackTotalHits bounds the count of the total hits; unless all the
+	// hits are counted, one more device is fetched to tell if there's
+	// a next page (see SearchInfo.HasMore)
+	TrackTotalHits *TrackTotalHits `json:"track_total_hits,omitempty"`
+	// Sample returns the devices in a pseudo-random order instead of
+	// sorting them, see SampleParams
+	Sample *SampleParams `json:"sample,omitempty"`
+	// TrackSeqNo returns the sequence number and primary term of the
+	// devices, for their updates with optimistic concurrency control
+	TrackSeqNo bool `json:"track_seq_no,omitempty"`
+	// Timeout bounds the time ES spends searching each shard (an ES time
+	// value, e.g. 2s); unlike the request deadline, the search isn't
+	// aborted, but returns the hits collected so far (see SearchInfo.TimedOut)
+	Timeout string `json:"timeout,omitempty"`
+	// MaxClauseCount is the max number of values of a single $in or $nin
+	// clause; the larger arrays are split in several clauses (0: no limit)
+	MaxClauseCount int `json:"-"`
+	// SortOrders are the custom orders of the values of the string
+	// attributes, keyed by SortOrderKey, which sort with a script
+	SortOrders map[string][]string `json:"-"`
+}
+
+// SearchInfo is the metadata of the search results
+type SearchInfo struct {
+	// TerminatedEarly is set if the search stopped collecting documents
+	// after SearchParams.TerminateAfter, i.e. the results are approximate
+	TerminatedEarly bool
+	// TimedOut is set if the search hit SearchParams.Timeout,
+	// i.e. the results are partial
+	TimedOut bool
+	// Profile is the ES query profile, if requested
+	Profile interface{}
+	// HasMore is set if there's a next page; it's only computed if the
+	// total isn't fully counted (see SearchParams.TrackTotalHits)
+	HasMore bool
+}
+
+// QueryValidation is the outcome of the dry-run validation of a search
+type QueryValidation struct {
+	Valid bool `json:"valid"`
+	// Error is the reason why ES rejects the query, if invalid
+	Error string `json:"error,omitempty"`
+}
+
+// TrackTotalHits is the ES track_total_hits: either a bool, whether to
+// count the total hits at all, or the number of hits up to which the total
+// is accurate; larger totals are reported as the lower bound
+type TrackTotalHits struct {
+	Enabled bool
+	UpTo    int
+}
+
+// CountsAll tells if all the hits are counted, i.e. the total is exact
+func (t *TrackTotalHits) CountsAll() bool {
+	return t == nil || (t.Enabled && t.UpTo == 0)
+}
+
+// Value returns the value of the track_total_hits search param
+func (t TrackTotalHits) Value() interface{} {
+	if t.UpTo > 0 {
+		return t.UpTo
+	}
+	return t.Enabled
+}
+
+func (t TrackTotalHits) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.Value())
+}
+
+func (t *TrackTotalHits) UnmarshalJSON(b []byte) error {
+	*t = TrackTotalHits{}
+	if err := json.Unmarshal(b, &t.Enabled); err == nil {
+		return nil
+	}
+	if err := json.Unmarshal(b, &t.UpTo); err != nil {
+		return errors.New("track_total_hits must be a boolean or an integer")
+	}
+	t.Enabled = t.UpTo > 0
+	return nil
+}
+
+func (t TrackTotalHits) Validate() error {
+	if t.UpTo < 0 {
+		return errors.New("must be a boolean or a non-negative integer")
+	}
+	return nil
 }
 
 type Filter struct {
@@ -58,12 +189,26 @@ type FilterPredicate struct {
 	Attribute string      `json:"attribute" bson:"attribute"`
 	Type      string      `json:"type" bson:"type"`
 	Value     interface{} `json:"value" bson:"value"`
+	// Boost raises the relevance of the matching devices; it only applies
+	// to the predicates in scoring context, i.e. not to the negations
+	Boost float64 `json:"boost,omitempty" bson:"boost,omitempty"`
+	// Operator and Fuzziness only apply to the $match predicates,
+	// see validMatchOperators and validFuzziness
+	Operator  string `json:"operator,omitempty" bson:"operator,omitempty"`
+	Fuzziness string `json:"fuzziness,omitempty" bson:"fuzziness,omitempty"`
+	// MaxExpansions and PrefixLength only apply to the $fuzzy predicates,
+	// see FuzzyMaxExpansionsDefault and FuzzyPrefixLengthDefault
+	MaxExpansions int `json:"max_expansions,omitempty" bson:"max_expansions,omitempty"`
+	PrefixLength  int `json:"prefix_length,omitempty" bson:"prefix_length,omitempty"`
 }
 
 type SortCriteria struct {
 	Scope     string `json:"scope"`
 	Attribute string `json:"attribute"`
 	Order     string `json:"order"`
+	// Mode selects the element of an array attribute to sort on;
+	// avg, sum and median only apply to numeric attributes
+	Mode string `json:"mode,omitempty"`
 }
 
 type SelectAttribute struct {
@@ -72,11 +217,19 @@ type SelectAttribute struct {
 }
 
 func (sp SearchParams) Validate() error {
-	for _, f := range sp.Filters {
-		err := f.Validate()
-		if err != nil {
-			return err
-		}
+	err := validation.ValidateStruct(&sp,
+		validation.Field(&sp.Preference, validation.Match(validPreference)),
+		validation.Field(&sp.SearchType, validation.In(
+			SearchTypeQueryThenFetch, SearchTypeDFSQueryThenFetch)),
+		validation.Field(&sp.TerminateAfter, validation.Min(0)),
+		validation.Field(&sp.Timeout, validation.Match(validTimeout)),
+		validation.Field(&sp.TrackTotalHits))
+	if err != nil {
+		return err
+	}
+
+	if errs := sp.validateFilters(); len(errs) > 0 {
+		return errs
 	}
 
 	for _, s := range sp.Sort {
@@ -86,12 +239,23 @@ func (sp SearchParams) Validate() error {
 			validation.Field(&s.Order,
 				validation.Required, validation.In(validSortOrders...),
 			),
+			validation.Field(&s.Mode, validation.In(validSortModes...)),
 		)
 		if err != nil {
 			return err
 		}
 	}
 
+	if sp.Sample != nil && len(sp.Sort) > 0 {
+		return errors.New("sample: can't sort the sampled devices")
+	}
+
+	if sp.Highlight != nil {
+		if err := sp.Highlight.Validate(); err != nil {
+			return errors.Wrap(err, "highlight")
+		}
+	}
+
 	for _, s := range sp.Attributes {
 		err := validation.ValidateStruct(&s,
 			validation.Field(&s.Scope, validation.Required),
@@ -100,9 +264,84 @@ func (sp SearchParams) Validate() error {
 			return err
 		}
 	}
+
+	for _, s := range sp.ExcludeAttributes {
+		err := validation.ValidateStruct(&s,
+			validation.Field(&s.Scope, validation.Required),
+			validation.Field(&s.Attribute, validation.Required))
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// FilterError is the validation error of a filter predicate: Field is the
+// list of the predicate, i.e. "filters", "post_filters" or "or[i]" for
+// the i-th group, and Index the position of the predicate in the list
+type FilterError struct {
+	Field string `json:"field"`
+	Index int    `json:"index"`
+	Err   string `json:"error"`
+}
+
+// FilterErrors are the errors of all the invalid filter predicates
+// of the search params
+type FilterErrors []FilterError
+
+func (e FilterErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fmt.Sprintf("%s[%d]: %s", fe.Field, fe.Index, fe.Err)
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// validateFilters validates all the filter predicates, instead of
+// stopping at the first invalid one
+func (sp SearchParams) validateFilters() FilterErrors {
+	var errs FilterErrors
+	validate := func(field string, filters []FilterPredicate) {
+		for i, f := range filters {
+			if err := validateSearchFilter(f); err != nil {
+				errs = append(errs, FilterError{
+					Field: field,
+					Index: i,
+					Err:   err.Error(),
+				})
+			}
+		}
+	}
+
+	validate("filters", sp.Filters)
+	validate("post_filters", sp.PostFilters)
+	for i, group := range sp.Or {
+		if len(group) == 0 {
+			errs = append(errs, FilterError{
+				Field: "or",
+				Index: i,
+				Err:   "filter groups must not be empty",
+			})
+		}
+		validate(fmt.Sprintf("or[%d]", i), group)
+	}
+	return errs
+}
+
+// validateSearchFilter validates the filter predicate, incl. its scope and
+// the kind of its value, e.g. an array for $eq, which would otherwise fail
+// only when building the query
+func validateSearchFilter(f FilterPredicate) error {
+	if err := f.Validate(); err != nil {
+		return err
+	}
+	if !IsValidScope(f.Scope) {
+		return validation.Errors{"scope": validation.ErrInInvalid}
+	}
+	_, err := getFilterPart(f, 0)
+	return err
+}
+
 func (f Filter) Validate() error {
 	err := validation.ValidateStruct(&f,
 		validation.Field(&f.Name, validation.Required))
@@ -129,7 +368,20 @@ func (f FilterPredicate) Validate() error {
 		validation.Field(&f.Scope, validation.Required),
 		validation.Field(&f.Attribute, validation.Required),
 		validation.Field(&f.Type, validation.Required, validation.In(validSelectors...)),
-		validation.Field(&f.Value, validation.NotNil))
+		validation.Field(&f.Value, validation.NotNil),
+		validation.Field(&f.Boost, validation.Min(0.0)),
+		validation.Field(&f.Operator,
+			validation.When(f.Type != "$match", validation.Empty),
+			validation.In(validMatchOperators...)),
+		validation.Field(&f.Fuzziness,
+			validation.When(f.Type != "$match", validation.Empty),
+			validation.Match(validFuzziness)),
+		validation.Field(&f.MaxExpansions,
+			validation.When(f.Type != "$fuzzy", validation.Empty),
+			validation.Min(0), validation.Max(FuzzyMaxExpansionsMax)),
+		validation.Field(&f.PrefixLength,
+			validation.When(f.Type != "$fuzzy", validation.Empty),
+			validation.Min(0)))
 }
 
 // ValueType returns actual type info of the value:
@@ -0,0 +1,388 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchParamsValidatePreference(t *testing.T) {
+	testCases := map[string]struct {
+		preference string
+
+		err string
+	}{
+		"ok, none": {},
+		"ok, session id": {
+			preference: "5f4b1c3e-ab12-4c2e-9d8f-0123456789ab",
+		},
+		"error, reserved preference": {
+			preference: "_local",
+			err:        "preference: must be in a valid format.",
+		},
+		"error, unsafe characters": {
+			preference: "session&routing=x",
+			err:        "preference: must be in a valid format.",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := SearchParams{Preference: tc.preference}.Validate()
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestSearchParamsValidateSearchType(t *testing.T) {
+	testCases := map[string]struct {
+		searchType string
+
+		err string
+	}{
+		"ok, none": {},
+		"ok, dfs_query_then_fetch": {
+			searchType: SearchTypeDFSQueryThenFetch,
+		},
+		"ok, query_then_fetch": {
+			searchType: SearchTypeQueryThenFetch,
+		},
+		"error, unknown search type": {
+			searchType: "scan",
+			err:        "search_type: must be a valid value.",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := SearchParams{SearchType: tc.searchType}.Validate()
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestSearchParamsValidateTimeout(t *testing.T) {
+	testCases := map[string]struct {
+		timeout string
+
+		err string
+	}{
+		"ok, none": {},
+		"ok, seconds": {
+			timeout: "2s",
+		},
+		"ok, milliseconds": {
+			timeout: "500ms",
+		},
+		"error, no unit": {
+			timeout: "2",
+			err:     "timeout: must be in a valid format.",
+		},
+		"error, negative": {
+			timeout: "-1s",
+			err:     "timeout: must be in a valid format.",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := SearchParams{Timeout: tc.timeout}.Validate()
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestSearchParamsValidateSortMode(t *testing.T) {
+	testCases := map[string]struct {
+		mode string
+
+		err string
+	}{
+		"ok, none":   {},
+		"ok, avg":    {mode: "avg"},
+		"ok, median": {mode: "median"},
+		"error, unknown mode": {
+			mode: "first",
+			err:  "mode: must be a valid value.",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := SearchParams{
+				Sort: []SortCriteria{{
+					Scope:     "inventory",
+					Attribute: "temperatures",
+					Order:     "asc",
+					Mode:      tc.mode,
+				}},
+			}.Validate()
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestSearchParamsValidateOr(t *testing.T) {
+	valid := FilterPredicate{
+		Scope:     "inventory",
+		Attribute: "os",
+		Type:      "$eq",
+		Value:     "linux",
+	}
+	testCases := map[string]struct {
+		or [][]FilterPredicate
+
+		err string
+	}{
+		"ok": {
+			or: [][]FilterPredicate{{valid, valid}, {valid}},
+		},
+		"error, empty group": {
+			or:  [][]FilterPredicate{{valid}, {}},
+			err: "or[1]: filter groups must not be empty",
+		},
+		"error, invalid filter": {
+			or: [][]FilterPredicate{{{
+				Scope:     "inventory",
+				Attribute: "os",
+				Type:      "$like",
+				Value:     "linux",
+			}}},
+			err: "or[0][0]: type: must be a valid value.",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := SearchParams{Or: tc.or}.Validate()
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestSearchParamsValidatePostFilters(t *testing.T) {
+	testCases := map[string]struct {
+		postFilters []FilterPredicate
+
+		err string
+	}{
+		"ok": {
+			postFilters: []FilterPredicate{{
+				Scope:     "inventory",
+				Attribute: "os",
+				Type:      "$in",
+				Value:     []interface{}{"linux"},
+			}},
+		},
+		"error, invalid filter": {
+			postFilters: []FilterPredicate{{
+				Scope:     "inventory",
+				Attribute: "os",
+				Type:      "$like",
+				Value:     "linux",
+			}},
+			err: "post_filters[0]: type: must be a valid value.",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := SearchParams{PostFilters: tc.postFilters}.Validate()
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestSearchParamsValidateFilters(t *testing.T) {
+	valid := FilterPredicate{
+		Scope:     "inventory",
+		Attribute: "os",
+		Type:      "$eq",
+		Value:     "linux",
+	}
+	params := SearchParams{
+		Filters: []FilterPredicate{
+			valid,
+			{Scope: "inventory", Attribute: "os", Type: "$like", Value: "linux"},
+			{Scope: "hardware", Attribute: "os", Type: "$eq", Value: "linux"},
+			{Scope: "inventory", Attribute: "os", Type: "$eq",
+				Value: []interface{}{"linux", "bsd"}},
+		},
+		PostFilters: []FilterPredicate{
+			{Scope: "inventory", Attribute: "os", Type: "$regex", Value: true},
+		},
+		Or: [][]FilterPredicate{
+			{valid, {Scope: "inventory", Type: "$eq", Value: "linux"}},
+		},
+	}
+
+	err := params.Validate()
+	assert.Equal(t, FilterErrors{
+		{Field: "filters", Index: 1, Err: "type: must be a valid value."},
+		{Field: "filters", Index: 2, Err: "scope: must be a valid value."},
+		{Field: "filters", Index: 3, Err: ErrArrayNotSupported.Error()},
+		{Field: "post_filters", Index: 0, Err: ErrStrRequired.Error()},
+		{Field: "or[0]", Index: 1, Err: "attribute: cannot be blank."},
+	}, err)
+	assert.EqualError(t, err, "filters[1]: type: must be a valid value.; "+
+		"filters[2]: scope: must be a valid value.; "+
+		"filters[3]: filter doesn't support array values; "+
+		"post_filters[0]: filter supports only string values; "+
+		"or[0][1]: attribute: cannot be blank.")
+}
+
+func TestSearchParamsValidateMatch(t *testing.T) {
+	match := func(value interface{}, operator, fuzziness string) FilterPredicate {
+		return FilterPredicate{
+			Scope:     "inventory",
+			Attribute: "product_name",
+			Type:      "$match",
+			Value:     value,
+			Operator:  operator,
+			Fuzziness: fuzziness,
+		}
+	}
+	testCases := map[string]struct {
+		filter FilterPredicate
+
+		err string
+	}{
+		"ok": {
+			filter: match("lenovo thinkpad", "", ""),
+		},
+		"ok, options": {
+			filter: match("lenovo thinkpad", "and", "AUTO"),
+		},
+		"ok, fuzziness distance": {
+			filter: match("lenovo", "or", "1"),
+		},
+		"ok, fuzziness word lengths": {
+			filter: match("lenovo", "", "AUTO:3,6"),
+		},
+		"error, not a string": {
+			filter: match(float64(42), "", ""),
+			err:    "filters[0]: " + ErrStrRequired.Error(),
+		},
+		"error, array": {
+			filter: match([]interface{}{"lenovo"}, "", ""),
+			err:    "filters[0]: " + ErrArrayNotSupported.Error(),
+		},
+		"error, operator": {
+			filter: match("lenovo", "xor", ""),
+			err:    "filters[0]: operator: must be a valid value.",
+		},
+		"error, fuzziness": {
+			filter: match("lenovo", "", "3"),
+			err:    "filters[0]: fuzziness: must be in a valid format.",
+		},
+		"error, options of another filter": {
+			filter: FilterPredicate{
+				Scope:     "inventory",
+				Attribute: "product_name",
+				Type:      "$eq",
+				Value:     "lenovo",
+				Operator:  "and",
+			},
+			err: "filters[0]: operator: must be blank.",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := SearchParams{Filters: []FilterPredicate{tc.filter}}.Validate()
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestSearchParamsValidateFuzzy(t *testing.T) {
+	fuzzy := func(value interface{}, maxExpansions, prefixLength int) FilterPredicate {
+		return FilterPredicate{
+			Scope:         "inventory",
+			Attribute:     "device_name",
+			Type:          "$fuzzy",
+			Value:         value,
+			MaxExpansions: maxExpansions,
+			PrefixLength:  prefixLength,
+		}
+	}
+	testCases := map[string]struct {
+		filter FilterPredicate
+
+		err string
+	}{
+		"ok": {
+			filter: fuzzy("raspbery", 0, 0),
+		},
+		"ok, options": {
+			filter: fuzzy("raspbery", 100, 2),
+		},
+		"error, not a string": {
+			filter: fuzzy(true, 0, 0),
+			err:    "filters[0]: " + ErrStrRequired.Error(),
+		},
+		"error, too many expansions": {
+			filter: fuzzy("raspbery", FuzzyMaxExpansionsMax+1, 0),
+			err:    "filters[0]: max_expansions: must be no greater than 1024.",
+		},
+		"error, negative prefix length": {
+			filter: fuzzy("raspbery", 0, -1),
+			err:    "filters[0]: prefix_length: must be no less than 0.",
+		},
+		"error, options of another filter": {
+			filter: FilterPredicate{
+				Scope:        "inventory",
+				Attribute:    "device_name",
+				Type:         "$match",
+				Value:        "raspbery",
+				PrefixLength: 2,
+			},
+			err: "filters[0]: prefix_length: must be blank.",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := SearchParams{Filters: []FilterPredicate{tc.filter}}.Validate()
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pkg/errors"
+)
+
+const (
+	// GeohashPrecisionDefault is the default geohash length of the cells,
+	// i.e. about 5km x 5km
+	GeohashPrecisionDefault = 5
+	// GeohashPrecisionMax is the longest geohash supported by ES
+	GeohashPrecisionMax = 12
+	// GeohashGridSizeDefault is the default max number of cells returned
+	GeohashGridSizeDefault = 10000
+
+	// GeoSubfield is the 'geo' sub-field of the designated location attributes
+	GeoSubfield = "geo"
+
+	geohashGridAggName = "geohash_grid"
+)
+
+var (
+	ErrNotGeoPointAttribute = errors.New("attribute is not mapped as geo_point")
+)
+
+// GeoPoint is a location, in degrees
+type GeoPoint struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+func (p GeoPoint) Validate() error {
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.Lat, validation.Min(-90.0), validation.Max(90.0)),
+		validation.Field(&p.Lon, validation.Min(-180.0), validation.Max(180.0)))
+}
+
+// GeoBoundingBox is the area of the map in view; it may cross the
+// antimeridian, i.e. the left longitude be greater than the right one
+type GeoBoundingBox struct {
+	TopLeft     GeoPoint `json:"top_left"`
+	BottomRight GeoPoint `json:"bottom_right"`
+}
+
+func (b GeoBoundingBox) Validate() error {
+	err := validation.ValidateStruct(&b,
+		validation.Field(&b.TopLeft),
+		validation.Field(&b.BottomRight))
+	if err != nil {
+		return err
+	}
+	if b.TopLeft.Lat < b.BottomRight.Lat {
+		return errors.New("the top latitude is below the bottom one")
+	}
+	return nil
+}
+
+// GeohashGridParams selects the (string) location attribute whose values
+// are clustered by geohash cells of the given precision, over the devices
+// matching the filters and located in the optional bounding box;
+// the attribute must have a 'geo' sub-field, see GeoField
+type GeohashGridParams struct {
+	Scope       string            `json:"scope"`
+	Attribute   string            `json:"attribute"`
+	Precision   int               `json:"precision"`
+	BoundingBox *GeoBoundingBox   `json:"bounding_box"`
+	Filters     []FilterPredicate `json:"filters"`
+	Size        int               `json:"-"`
+	Groups      []string          `json:"-"`
+	TenantID    string            `json:"-"`
+}
+
+// GeohashCell is the number of devices located in the geohash cell
+type GeohashCell struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+// GeohashGrid are the device counts by geohash cell, the most populated first
+type GeohashGrid struct {
+	Cells []GeohashCell `json:"cells"`
+}
+
+func (p GeohashGridParams) Validate() error {
+	err := validation.ValidateStruct(&p,
+		validation.Field(&p.Scope, validation.Required),
+		validation.Field(&p.Attribute, validation.Required),
+		validation.Field(&p.Precision,
+			validation.Min(0), validation.Max(GeohashPrecisionMax)),
+		validation.Field(&p.BoundingBox))
+	if err != nil {
+		return err
+	}
+
+	for _, f := range p.Filters {
+		if err := f.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GeoField returns the 'geo' sub-field of a string attribute
+func GeoField(scope, name string) string {
+	return ToAttr(scope, name, TypeStr) + "." + GeoSubfield
+}
+
+// BuildGeohashGridQuery builds the geohash_grid aggregation over the 'geo'
+// sub-field of the attribute, restricted to the devices matching the filters
+// and located in the bounding box
+func BuildGeohashGridQuery(params GeohashGridParams) (Query, error) {
+	query, err := BuildQuery(SearchParams{
+		Filters: params.Filters,
+		Groups:  params.Groups,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if params.TenantID != "" {
+		query = query.Must(M{
+			"term": M{
+				"tenantID": params.TenantID,
+			},
+		})
+	}
+
+	field := GeoField(params.Scope, params.Attribute)
+	if params.BoundingBox != nil {
+		query = query.Must(M{
+			"geo_bounding_box": M{
+				field: M{
+					"top_left": M{
+						"lat": params.BoundingBox.TopLeft.Lat,
+						"lon": params.BoundingBox.TopLeft.Lon,
+					},
+					"bottom_right": M{
+						"lat": params.BoundingBox.BottomRight.Lat,
+						"lon": params.BoundingBox.BottomRight.Lon,
+					},
+				},
+			},
+		})
+	}
+
+	precision := params.Precision
+	if precision <= 0 {
+		precision = GeohashPrecisionDefault
+	}
+	size := params.Size
+	if size <= 0 {
+		size = GeohashGridSizeDefault
+	}
+
+	// no hits, just the aggregation
+	return query.WithPage(1, 0).With(M{
+		"aggs": M{
+			geohashGridAggName: M{
+				"geohash_grid": M{
+					"field":     field,
+					"precision": precision,
+					"size":      size,
+				},
+			},
+		},
+	}), nil
+}
+
+// ParseGeohashGridAggregation parses the result of the query built with
+// BuildGeohashGridQuery
+func ParseGeohashGridAggregation(res M) (*GeohashGrid, error) {
+	aggs, ok := res["aggregations"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("can't process store aggregations")
+	}
+
+	agg, ok := aggs[geohashGridAggName].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("can't process geohash grid aggregation")
+	}
+
+	buckets, ok := agg["buckets"].([]interface{})
+	if !ok {
+		return nil, errors.New("can't process geohash grid aggregation buckets")
+	}
+
+	ret := make([]GeohashCell, 0, len(buckets))
+	for _, b := range buckets {
+		bucket, ok := b.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("can't process geohash grid aggregation bucket")
+		}
+
+		key, ok := bucket["key"].(string)
+		if !ok {
+			return nil, errors.New("can't process geohash grid aggregation bucket key")
+		}
+
+		count, ok := bucket["doc_count"].(float64)
+		if !ok {
+			return nil, errors.New(
+				"can't process geohash grid aggregation bucket count")
+		}
+
+		ret = append(ret, GeohashCell{
+			Key:   key,
+			Count: int(count),
+		})
+	}
+
+	return &GeohashGrid{
+		Cells: ret,
+	}, nil
+}
+
+// IsGeoPointMapping checks if the index mapping of a string attribute, found
+// under 'mappings.properties', has the 'geo' sub-field
+func IsGeoPointMapping(mapping interface{}) bool {
+	m, _ := mapping.(map[string]interface{})
+	fields, _ := m["fields"].(map[string]interface{})
+	sub, _ := fields[GeoSubfield].(map[string]interface{})
+	return sub["type"] == "geo_point"
+}
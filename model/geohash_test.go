@@ -0,0 +1,236 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGeohashGridParamsValidate(t *testing.T) {
+	testCases := map[string]struct {
+		params GeohashGridParams
+
+		err string
+	}{
+		"ok": {
+			params: GeohashGridParams{
+				Scope:     "inventory",
+				Attribute: "location",
+				Precision: 4,
+				BoundingBox: &GeoBoundingBox{
+					TopLeft:     GeoPoint{Lat: 60, Lon: 5},
+					BottomRight: GeoPoint{Lat: 55, Lon: 15},
+				},
+			},
+		},
+		"ok, crossing the antimeridian": {
+			params: GeohashGridParams{
+				Scope:     "inventory",
+				Attribute: "location",
+				BoundingBox: &GeoBoundingBox{
+					TopLeft:     GeoPoint{Lat: 10, Lon: 170},
+					BottomRight: GeoPoint{Lat: -10, Lon: -170},
+				},
+			},
+		},
+		"error, missing attribute": {
+			params: GeohashGridParams{
+				Scope: "inventory",
+			},
+			err: "attribute: cannot be blank.",
+		},
+		"error, precision too high": {
+			params: GeohashGridParams{
+				Scope:     "inventory",
+				Attribute: "location",
+				Precision: 13,
+			},
+			err: "precision: must be no greater than 12.",
+		},
+		"error, latitude out of range": {
+			params: GeohashGridParams{
+				Scope:     "inventory",
+				Attribute: "location",
+				BoundingBox: &GeoBoundingBox{
+					TopLeft:     GeoPoint{Lat: 91, Lon: 5},
+					BottomRight: GeoPoint{Lat: 55, Lon: 15},
+				},
+			},
+			err: "bounding_box: (top_left: (lat: must be no greater than 90.).).",
+		},
+		"error, top below bottom": {
+			params: GeohashGridParams{
+				Scope:     "inventory",
+				Attribute: "location",
+				BoundingBox: &GeoBoundingBox{
+					TopLeft:     GeoPoint{Lat: 55, Lon: 5},
+					BottomRight: GeoPoint{Lat: 60, Lon: 15},
+				},
+			},
+			err: "bounding_box: the top latitude is below the bottom one.",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := tc.params.Validate()
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestBuildGeohashGridQuery(t *testing.T) {
+	testCases := map[string]struct {
+		params GeohashGridParams
+
+		query string
+	}{
+		"ok, defaults": {
+			params: GeohashGridParams{
+				Scope:     "inventory",
+				Attribute: "location",
+				TenantID:  "tenant1",
+			},
+			query: `{
+				"query": {"bool": {"must": [
+					{"term": {"tenantID": "tenant1"}}
+				]}},
+				"from": 0,
+				"size": 0,
+				"aggs": {
+					"geohash_grid": {
+						"geohash_grid": {
+							"field": "inventory_location_str.geo",
+							"precision": 5,
+							"size": 10000
+						}
+					}
+				}
+			}`,
+		},
+		"ok, bounding box": {
+			params: GeohashGridParams{
+				Scope:     "inventory",
+				Attribute: "location",
+				Precision: 3,
+				BoundingBox: &GeoBoundingBox{
+					TopLeft:     GeoPoint{Lat: 60, Lon: 5},
+					BottomRight: GeoPoint{Lat: 55.5, Lon: 15},
+				},
+				Size:     100,
+				Groups:   []string{"group1"},
+				TenantID: "tenant1",
+			},
+			query: `{
+				"query": {"bool": {"must": [
+					{"terms": {"system_group_str": ["group1"]}},
+					{"term": {"tenantID": "tenant1"}},
+					{"geo_bounding_box": {
+						"inventory_location_str.geo": {
+							"top_left": {"lat": 60, "lon": 5},
+							"bottom_right": {"lat": 55.5, "lon": 15}
+						}
+					}}
+				]}},
+				"from": 0,
+				"size": 0,
+				"aggs": {
+					"geohash_grid": {
+						"geohash_grid": {
+							"field": "inventory_location_str.geo",
+							"precision": 3,
+							"size": 100
+						}
+					}
+				}
+			}`,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			query, err := BuildGeohashGridQuery(tc.params)
+			assert.NoError(t, err)
+			b, err := json.Marshal(query)
+			assert.NoError(t, err)
+			assert.JSONEq(t, tc.query, string(b))
+		})
+	}
+}
+
+func TestParseGeohashGridAggregation(t *testing.T) {
+	testCases := map[string]struct {
+		res string
+
+		grid *GeohashGrid
+		err  string
+	}{
+		"ok": {
+			res: `{"aggregations": {"geohash_grid": {"buckets": [
+				{"key": "u4pr", "doc_count": 12},
+				{"key": "u4xs", "doc_count": 3}
+			]}}}`,
+			grid: &GeohashGrid{
+				Cells: []GeohashCell{
+					{Key: "u4pr", Count: 12},
+					{Key: "u4xs", Count: 3},
+				},
+			},
+		},
+		"ok, no devices": {
+			res:  `{"aggregations": {"geohash_grid": {"buckets": []}}}`,
+			grid: &GeohashGrid{Cells: []GeohashCell{}},
+		},
+		"error, no aggregation": {
+			res: `{"hits": {}}`,
+			err: "can't process store aggregations",
+		},
+		"error, bad bucket": {
+			res: `{"aggregations": {"geohash_grid": {"buckets": [
+				{"key": "u4pr"}
+			]}}}`,
+			err: "can't process geohash grid aggregation bucket count",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var res M
+			_ = json.Unmarshal([]byte(tc.res), &res)
+			grid, err := ParseGeohashGridAggregation(res)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.grid, grid)
+			}
+		})
+	}
+}
+
+func TestIsGeoPointMapping(t *testing.T) {
+	assert.True(t, IsGeoPointMapping(map[string]interface{}{
+		"type": "keyword",
+		"fields": map[string]interface{}{
+			"geo": map[string]interface{}{"type": "geo_point"},
+		},
+	}))
+	assert.False(t, IsGeoPointMapping(map[string]interface{}{"type": "keyword"}))
+	assert.False(t, IsGeoPointMapping(nil))
+}
@@ -0,0 +1,139 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"github.com/pkg/errors"
+)
+
+const (
+	// GroupsSizeDefault is the default max number of groups returned
+	GroupsSizeDefault = 100
+
+	groupsAggName = "groups"
+
+	// groupsMissingKey is the bucket key of the devices without a group;
+	// group names can only contain alphanumerics, '-' and '_',
+	// so it can't clash with a real group
+	groupsMissingKey = "<ungrouped>"
+)
+
+// GroupCount is the number of devices in a group; the devices not
+// assigned to any group are reported under an empty group name
+type GroupCount struct {
+	Group string `json:"group"`
+	Count int    `json:"count"`
+}
+
+type GroupsParams struct {
+	Size     int
+	Groups   []string
+	TenantID string
+}
+
+// BuildGroupsQuery builds the terms aggregation over the device groups,
+// sorted by number of devices, including the bucket of ungrouped devices
+func BuildGroupsQuery(params GroupsParams) (Query, error) {
+	query := NewQuery()
+
+	if params.TenantID != "" {
+		query = query.Must(M{
+			"term": M{
+				"tenantID": params.TenantID,
+			},
+		})
+	}
+
+	if len(params.Groups) > 0 {
+		fp := FilterPredicate{
+			Scope:     scopeSystem,
+			Attribute: AttrNameGroup,
+			Type:      "$in",
+			Value:     params.Groups,
+		}
+		fpart, err := NewFilterIn(fp)
+		if err != nil {
+			return nil, err
+		}
+		query = fpart.AddTo(query)
+	}
+
+	size := params.Size
+	if size <= 0 {
+		size = GroupsSizeDefault
+	}
+
+	// no hits, just the aggregation
+	return query.WithPage(1, 0).With(M{
+		"aggs": M{
+			groupsAggName: M{
+				"terms": M{
+					"field":   ToAttr(scopeSystem, AttrNameGroup, TypeStr),
+					"size":    size,
+					"missing": groupsMissingKey,
+					"order": M{
+						"_count": "desc",
+					},
+				},
+			},
+		},
+	}), nil
+}
+
+// ParseGroupsAggregation parses the result of the query built with BuildGroupsQuery
+func ParseGroupsAggregation(res M) ([]GroupCount, error) {
+	aggs, ok := res["aggregations"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("can't process store aggregations")
+	}
+
+	agg, ok := aggs[groupsAggName].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("can't process groups aggregation")
+	}
+
+	buckets, ok := agg["buckets"].([]interface{})
+	if !ok {
+		return nil, errors.New("can't process groups aggregation buckets")
+	}
+
+	ret := make([]GroupCount, 0, len(buckets))
+	for _, b := range buckets {
+		bucket, ok := b.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("can't process groups aggregation bucket")
+		}
+
+		key, ok := bucket["key"].(string)
+		if !ok {
+			return nil, errors.New("can't process groups aggregation bucket key")
+		}
+		if key == groupsMissingKey {
+			key = ""
+		}
+
+		count, ok := bucket["doc_count"].(float64)
+		if !ok {
+			return nil, errors.New("can't process groups aggregation bucket count")
+		}
+
+		ret = append(ret, GroupCount{
+			Group: key,
+			Count: int(count),
+		})
+	}
+
+	return ret, nil
+}
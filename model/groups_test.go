@@ -0,0 +1,134 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildGroupsQuery(t *testing.T) {
+	testCases := map[string]struct {
+		params GroupsParams
+
+		query string
+	}{
+		"ok": {
+			params: GroupsParams{
+				TenantID: "tenant1",
+				Size:     10,
+			},
+			query: `{
+				"query": {"bool": {"must": [{"term": {"tenantID": "tenant1"}}]}},
+				"from": 0,
+				"size": 0,
+				"aggs": {
+					"groups": {
+						"terms": {
+							"field": "system_group_str",
+							"size": 10,
+							"missing": "<ungrouped>",
+							"order": {"_count": "desc"}
+						}
+					}
+				}
+			}`,
+		},
+		"ok, default size, restricted to groups": {
+			params: GroupsParams{
+				TenantID: "tenant1",
+				Groups:   []string{"group1", "group2"},
+			},
+			query: `{
+				"query": {"bool": {"must": [
+					{"term": {"tenantID": "tenant1"}},
+					{"terms": {"system_group_str": ["group1", "group2"]}}
+				]}},
+				"from": 0,
+				"size": 0,
+				"aggs": {
+					"groups": {
+						"terms": {
+							"field": "system_group_str",
+							"size": 100,
+							"missing": "<ungrouped>",
+							"order": {"_count": "desc"}
+						}
+					}
+				}
+			}`,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			query, err := BuildGroupsQuery(tc.params)
+			assert.NoError(t, err)
+			b, err := json.Marshal(query)
+			assert.NoError(t, err)
+			assert.JSONEq(t, tc.query, string(b))
+		})
+	}
+}
+
+func TestParseGroupsAggregation(t *testing.T) {
+	testCases := map[string]struct {
+		res string
+
+		groups []GroupCount
+		err    string
+	}{
+		"ok": {
+			res: `{"aggregations": {"groups": {"buckets": [
+				{"key": "prod", "doc_count": 10},
+				{"key": "<ungrouped>", "doc_count": 5},
+				{"key": "dev", "doc_count": 2}
+			]}}}`,
+			groups: []GroupCount{
+				{Group: "prod", Count: 10},
+				{Group: "", Count: 5},
+				{Group: "dev", Count: 2},
+			},
+		},
+		"ok, no devices": {
+			res:    `{"aggregations": {"groups": {"buckets": []}}}`,
+			groups: []GroupCount{},
+		},
+		"error, no aggregations": {
+			res: `{"hits": {}}`,
+			err: "can't process store aggregations",
+		},
+		"error, malformed bucket": {
+			res: `{"aggregations": {"groups": {"buckets": [
+				{"key": "prod"}
+			]}}}`,
+			err: "can't process groups aggregation bucket count",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var res M
+			_ = json.Unmarshal([]byte(tc.res), &res)
+			groups, err := ParseGroupsAggregation(res)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.groups, groups)
+			}
+		})
+	}
+}
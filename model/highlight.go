@@ -0,0 +1,155 @@
+// Copyright 2021 Northern.tech AS
+// highlight is the ES highlighting config for the matched attributes
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+// highlight is the ES highlighting config for the matched attributes
+//        http://www.apache.org/licenses/LICENSE-2.0
+// highlight is the ES highlighting config for the matched attributes
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	gosort "sort"
+	"strings"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pkg/errors"
+)
+
+// TextSubfield is the analyzed sub-field of the text search fields
+const TextSubfield = "text"
+
+// HighlightParams enables the highlighting of the matches of the filters
+// in the selected attributes; ES defaults apply to the unset sizes
+type HighlightParams struct {
+	Attributes        []SelectAttribute `json:"attributes"`
+	FragmentSize      int               `json:"fragment_size,omitempty"`
+	NumberOfFragments int               `json:"number_of_fragments,omitempty"`
+}
+
+// InvDeviceHighlight are the highlighted fragments of a device attribute
+type InvDeviceHighlight struct {
+	Scope     string   `json:"scope"`
+	Name      string   `json:"name"`
+	Fragments []string `json:"fragments"`
+}
+
+func (h HighlightParams) Validate() error {
+	err := validation.ValidateStruct(&h,
+		validation.Field(&h.Attributes, validation.Required),
+		validation.Field(&h.FragmentSize, validation.Min(0)),
+		validation.Field(&h.NumberOfFragments, validation.Min(0)))
+	if err != nil {
+		return err
+	}
+
+	for _, a := range h.Attributes {
+		err := validation.ValidateStruct(&a,
+			validation.Field(&a.Scope, validation.Required),
+			validation.Field(&a.Attribute, validation.Required))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// highlight is the ES highlighting config for the matched attributes
+type highlight struct {
+	params HighlightParams
+}
+
+func NewHighlight(params HighlightParams) *highlight {
+	return &highlight{
+		params: params,
+	}
+}
+
+func (h *highlight) AddTo(q Query) Query {
+	// both the keyword field and its analyzed sub-field, if any;
+	// ES skips the fields which don't exist or weren't queried
+	fields := M{}
+	for _, a := range h.params.Attributes {
+		attr := ToAttr(a.Scope, a.Attribute, TypeStr)
+		fields[attr] = M{}
+		fields[attr+"."+TextSubfield] = M{}
+	}
+
+	hl := M{
+		"fields": fields,
+	}
+	if h.params.FragmentSize > 0 {
+		hl["fragment_size"] = h.params.FragmentSize
+	}
+	if h.params.NumberOfFragments > 0 {
+		hl["number_of_fragments"] = h.params.NumberOfFragments
+	}
+
+	return q.With(M{
+		"highlight": hl,
+	})
+}
+
+// ParseHighlight parses the highlighted fragments of a search hit, if any
+func ParseHighlight(hit map[string]interface{}) ([]InvDeviceHighlight, error) {
+	hl, ok := hit["highlight"].(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+
+	// keyword fields sort before their text sub-fields
+	fields := make([]string, 0, len(hl))
+	for field := range hl {
+		fields = append(fields, field)
+	}
+	gosort.Strings(fields)
+
+	byAttr := map[string]*InvDeviceHighlight{}
+	for _, field := range fields {
+		fragments, ok := hl[field].([]interface{})
+		if !ok {
+			return nil, errors.New("can't process highlight fragments")
+		}
+
+		scope, name, err := MaybeParseAttr(strings.TrimSuffix(field, "."+TextSubfield))
+		if err != nil {
+			return nil, err
+		}
+		if name == "" {
+			continue
+		}
+
+		key := scope + "/" + name
+		attr, ok := byAttr[key]
+		if !ok {
+			attr = &InvDeviceHighlight{
+				Scope: scope,
+				Name:  Redot(name),
+			}
+			byAttr[key] = attr
+		}
+		for _, f := range fragments {
+			if s, ok := f.(string); ok {
+				attr.Fragments = append(attr.Fragments, s)
+			}
+		}
+	}
+
+	ret := make([]InvDeviceHighlight, 0, len(byAttr))
+	for _, attr := range byAttr {
+		ret = append(ret, *attr)
+	}
+	gosort.Slice(ret, func(i, j int) bool {
+		if ret[i].Scope != ret[j].Scope {
+			return ret[i].Scope < ret[j].Scope
+		}
+		return ret[i].Name < ret[j].Name
+	})
+	return ret, nil
+}
@@ -0,0 +1,186 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"reflect"
+	gosort "sort"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+)
+
+const (
+	// AttributeHistorySizeDefault is the default max number of history entries
+	AttributeHistorySizeDefault = 100
+	// AttributeHistorySizeMax is the max number of history entries per request
+	AttributeHistorySizeMax = 1000
+)
+
+// AttributeHistory is the value of a device attribute from Timestamp on;
+// a nil Value means the attribute was removed
+type AttributeHistory struct {
+	TenantID  string      `json:"tenantID,omitempty"`
+	DeviceID  string      `json:"device_id"`
+	Scope     string      `json:"scope"`
+	Name      string      `json:"name"`
+	Value     interface{} `json:"value"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+// AttributeHistoryParams selects the history of a device attribute,
+// optionally within the time range [From, To]
+type AttributeHistoryParams struct {
+	TenantID  string     `json:"-"`
+	DeviceID  string     `json:"device_id"`
+	Scope     string     `json:"scope"`
+	Attribute string     `json:"attribute"`
+	From      *time.Time `json:"from,omitempty"`
+	To        *time.Time `json:"to,omitempty"`
+	Size      int        `json:"size,omitempty"`
+}
+
+func (p AttributeHistoryParams) Validate() error {
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.DeviceID, validation.Required),
+		validation.Field(&p.Scope, validation.Required),
+		validation.Field(&p.Attribute, validation.Required),
+		validation.Field(&p.Size,
+			validation.Min(0), validation.Max(AttributeHistorySizeMax)),
+		validation.Field(&p.To, validation.By(func(interface{}) error {
+			if p.From != nil && p.To != nil && p.To.Before(*p.From) {
+				return validation.NewError("validation_time_range",
+					"must not be before from")
+			}
+			return nil
+		})))
+}
+
+// attributeKey identifies an attribute of a device
+type attributeKey struct {
+	scope string
+	name  string
+}
+
+// attributeValues returns the values of all the attributes of d
+func attributeValues(d *Device) map[attributeKey]interface{} {
+	ret := map[attributeKey]interface{}{}
+	if d == nil {
+		return ret
+	}
+	for _, attrs := range []DeviceInventory{
+		d.IdentityAttributes,
+		d.InventoryAttributes,
+		d.MonitorAttributes,
+		d.SystemAttributes,
+		d.TagsAttributes,
+	} {
+		for _, a := range attrs {
+			_, val := a.Map()
+			ret[attributeKey{a.Scope, a.Name}] = val
+		}
+	}
+	return ret
+}
+
+// AttributeChanges returns the history entries of the attributes of dev
+// which differ from the ones of old, including the removed ones;
+// all the attributes are new if old is nil
+func AttributeChanges(old, dev *Device, ts time.Time) []AttributeHistory {
+	oldValues := attributeValues(old)
+	newValues := attributeValues(dev)
+
+	var ret []AttributeHistory
+	entry := func(k attributeKey, val interface{}) AttributeHistory {
+		return AttributeHistory{
+			TenantID:  dev.GetTenantID(),
+			DeviceID:  dev.GetID(),
+			Scope:     k.scope,
+			Name:      k.name,
+			Value:     val,
+			Timestamp: ts,
+		}
+	}
+	for k, val := range newValues {
+		if oldVal, ok := oldValues[k]; !ok || !reflect.DeepEqual(oldVal, val) {
+			ret = append(ret, entry(k, val))
+		}
+	}
+	for k := range oldValues {
+		if _, ok := newValues[k]; !ok {
+			ret = append(ret, entry(k, nil))
+		}
+	}
+	gosort.Slice(ret, func(i, j int) bool {
+		if ret[i].Scope != ret[j].Scope {
+			return ret[i].Scope < ret[j].Scope
+		}
+		return ret[i].Name < ret[j].Name
+	})
+	return ret
+}
+
+// BuildAttributeHistoryQuery builds the query of the history of an attribute,
+// in chronological order
+func BuildAttributeHistoryQuery(params AttributeHistoryParams) Query {
+	query := NewQuery()
+
+	if params.TenantID != "" {
+		query = query.Must(M{
+			"term": M{
+				"tenantID": params.TenantID,
+			},
+		})
+	}
+	query = query.Must(M{
+		"term": M{
+			"device_id": params.DeviceID,
+		},
+	}).Must(M{
+		"term": M{
+			"scope": params.Scope,
+		},
+	}).Must(M{
+		"term": M{
+			"name": params.Attribute,
+		},
+	})
+
+	if params.From != nil || params.To != nil {
+		timeRange := M{}
+		if params.From != nil {
+			timeRange["gte"] = params.From
+		}
+		if params.To != nil {
+			timeRange["lte"] = params.To
+		}
+		query = query.Must(M{
+			"range": M{
+				"timestamp": timeRange,
+			},
+		})
+	}
+
+	size := params.Size
+	if size <= 0 {
+		size = AttributeHistorySizeDefault
+	}
+
+	return query.WithSort(M{
+		"timestamp": M{
+			"order": "asc",
+		},
+	}).WithPage(1, size)
+}
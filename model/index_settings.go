@@ -0,0 +1,55 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"github.com/pkg/errors"
+)
+
+// indexSettingsOmitted are the index settings left out of IndexSettings:
+// the internal identifiers of the index, which support doesn't need
+var indexSettingsOmitted = []string{"uuid", "version", "provided_name"}
+
+// IndexSettings are the settings of the devices index of a tenant,
+// e.g. the number of shards and replicas and the refresh interval
+type IndexSettings struct {
+	TenantID string                 `json:"tenant_id"`
+	Index    string                 `json:"index"`
+	Settings map[string]interface{} `json:"settings"`
+}
+
+// ParseIndexSettings returns the 'settings.index' block of the devices index
+// definition, without the omitted settings
+func ParseIndexSettings(index map[string]interface{}) (map[string]interface{}, error) {
+	settings, ok := index["settings"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("can't parse index settings")
+	}
+
+	indexSettings, ok := settings["index"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("can't parse index settings")
+	}
+
+	ret := make(map[string]interface{}, len(indexSettings))
+	for k, v := range indexSettings {
+		ret[k] = v
+	}
+	for _, k := range indexSettingsOmitted {
+		delete(ret, k)
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseIndexSettings(t *testing.T) {
+	testCases := map[string]struct {
+		index string
+
+		settings map[string]interface{}
+		err      string
+	}{
+		"ok": {
+			index: `{
+				"aliases": {},
+				"mappings": {"properties": {}},
+				"settings": {
+					"index": {
+						"number_of_shards": "2",
+						"number_of_replicas": "1",
+						"refresh_interval": "5s",
+						"routing": {"allocation": {"include": {"_tier_preference": "data_content"}}},
+						"provided_name": "devices",
+						"creation_date": "1634050000000",
+						"uuid": "Jr9dqhvJTZKhRkB2Yd3v7g",
+						"version": {"created": "7150199"}
+					}
+				}
+			}`,
+			settings: map[string]interface{}{
+				"number_of_shards":   "2",
+				"number_of_replicas": "1",
+				"refresh_interval":   "5s",
+				"routing": map[string]interface{}{
+					"allocation": map[string]interface{}{
+						"include": map[string]interface{}{
+							"_tier_preference": "data_content",
+						},
+					},
+				},
+				"creation_date": "1634050000000",
+			},
+		},
+		"error, no settings": {
+			index: `{"mappings": {}}`,
+			err:   "can't parse index settings",
+		},
+		"error, no index settings": {
+			index: `{"settings": {"analysis": {}}}`,
+			err:   "can't parse index settings",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var index map[string]interface{}
+			_ = json.Unmarshal([]byte(tc.index), &index)
+			settings, err := ParseIndexSettings(index)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.settings, settings)
+			}
+		})
+	}
+}
@@ -24,6 +24,7 @@ const (
 	AttrScopeInventory = "inventory"
 	AttrScopeIdentity  = "identity"
 	AttrScopeSystem    = "system"
+	AttrScopeTags      = "tags"
 
 	AttrNameID      = "id"
 	AttrNameGroup   = "group"
@@ -60,6 +61,12 @@ type InvDevice struct {
 
 	//device object revision
 	Revision uint `json:"-" bson:"revision,omitempty"`
+
+	//highlighted matches, if requested in the search
+	Highlights []InvDeviceHighlight `json:"highlights,omitempty" bson:"-"`
+
+	//sequence number and primary term, if requested in the search
+	Meta *DeviceMeta `json:"meta,omitempty" bson:"-"`
 }
 
 func (d *DeviceAttributes) UnmarshalJSON(b []byte) error {
@@ -0,0 +1,200 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"net"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pkg/errors"
+)
+
+const (
+	// IPRangesMax is the max number of CIDR ranges of an IP ranges query
+	IPRangesMax = 100
+
+	// IPSubfield is the 'ip' sub-field of the designated IP attributes
+	IPSubfield = "ip"
+
+	ipRangesAggName = "ip_ranges"
+)
+
+var (
+	ErrNotIPAttribute = errors.New("attribute is not mapped as ip")
+)
+
+// IPRangesParams selects the (string) IPv4 attribute whose values are
+// bucketed by the CIDR ranges, over the devices matching the filters;
+// the attribute must have an 'ip' sub-field, see IPField
+type IPRangesParams struct {
+	Scope     string            `json:"scope"`
+	Attribute string            `json:"attribute"`
+	Ranges    []string          `json:"ranges"`
+	Filters   []FilterPredicate `json:"filters"`
+	Groups    []string          `json:"-"`
+	TenantID  string            `json:"-"`
+}
+
+// IPRangeBucket is the number of devices with an address in the CIDR range;
+// From is the first address of the range and To the first one after it
+type IPRangeBucket struct {
+	Range string `json:"range"`
+	From  string `json:"from,omitempty"`
+	To    string `json:"to,omitempty"`
+	Count int    `json:"count"`
+}
+
+// IPRanges are the device counts by CIDR range, in the requested order;
+// a device may be counted in several overlapping ranges
+type IPRanges struct {
+	Buckets []IPRangeBucket `json:"buckets"`
+}
+
+func (p IPRangesParams) Validate() error {
+	err := validation.ValidateStruct(&p,
+		validation.Field(&p.Scope, validation.Required),
+		validation.Field(&p.Attribute, validation.Required),
+		validation.Field(&p.Ranges,
+			validation.Required, validation.Length(1, IPRangesMax)))
+	if err != nil {
+		return err
+	}
+
+	for _, r := range p.Ranges {
+		if _, err := parseIPv4CIDR(r); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range p.Filters {
+		if err := f.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseIPv4CIDR parses an IPv4 CIDR range, e.g. 10.0.0.0/8
+func parseIPv4CIDR(r string) (*net.IPNet, error) {
+	ip, ipNet, err := net.ParseCIDR(r)
+	if err != nil || ip.To4() == nil {
+		return nil, errors.Errorf("ranges: %q is not a valid IPv4 CIDR range", r)
+	}
+	return ipNet, nil
+}
+
+// IPField returns the 'ip' sub-field of a string attribute
+func IPField(scope, name string) string {
+	return ToAttr(scope, name, TypeStr) + "." + IPSubfield
+}
+
+// BuildIPRangesQuery builds the ip_range aggregation over the 'ip' sub-field
+// of the attribute, restricted to the devices matching the filters
+func BuildIPRangesQuery(params IPRangesParams) (Query, error) {
+	query, err := BuildQuery(SearchParams{
+		Filters: params.Filters,
+		Groups:  params.Groups,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if params.TenantID != "" {
+		query = query.Must(M{
+			"term": M{
+				"tenantID": params.TenantID,
+			},
+		})
+	}
+
+	// the masks are normalized, e.g. 10.1.2.3/8 to 10.0.0.0/8, so that
+	// the keys of the buckets match the ranges
+	ranges := make([]M, 0, len(params.Ranges))
+	for _, r := range params.Ranges {
+		ipNet, err := parseIPv4CIDR(r)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, M{
+			"key":  r,
+			"mask": ipNet.String(),
+		})
+	}
+
+	// no hits, just the aggregation
+	return query.WithPage(1, 0).With(M{
+		"aggs": M{
+			ipRangesAggName: M{
+				"ip_range": M{
+					"field":  IPField(params.Scope, params.Attribute),
+					"ranges": ranges,
+				},
+			},
+		},
+	}), nil
+}
+
+// ParseIPRangesAggregation parses the result of the query built with
+// BuildIPRangesQuery
+func ParseIPRangesAggregation(res M) (*IPRanges, error) {
+	aggs, ok := res["aggregations"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("can't process store aggregations")
+	}
+
+	agg, ok := aggs[ipRangesAggName].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("can't process ip ranges aggregation")
+	}
+
+	buckets, ok := agg["buckets"].([]interface{})
+	if !ok {
+		return nil, errors.New("can't process ip ranges aggregation buckets")
+	}
+
+	ret := make([]IPRangeBucket, 0, len(buckets))
+	for _, b := range buckets {
+		bucket, ok := b.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("can't process ip ranges aggregation bucket")
+		}
+
+		count, ok := bucket["doc_count"].(float64)
+		if !ok {
+			return nil, errors.New("can't process ip ranges aggregation bucket count")
+		}
+
+		ib := IPRangeBucket{
+			Count: int(count),
+		}
+		ib.Range, _ = bucket["key"].(string)
+		ib.From, _ = bucket["from"].(string)
+		ib.To, _ = bucket["to"].(string)
+		ret = append(ret, ib)
+	}
+
+	return &IPRanges{
+		Buckets: ret,
+	}, nil
+}
+
+// IsIPMapping checks if the index mapping of a string attribute, found
+// under 'mappings.properties', has the 'ip' sub-field
+func IsIPMapping(mapping interface{}) bool {
+	m, _ := mapping.(map[string]interface{})
+	fields, _ := m["fields"].(map[string]interface{})
+	sub, _ := fields[IPSubfield].(map[string]interface{})
+	return sub["type"] == "ip"
+}
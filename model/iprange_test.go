@@ -0,0 +1,179 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIPRangesParamsValidate(t *testing.T) {
+	testCases := map[string]struct {
+		params IPRangesParams
+
+		err string
+	}{
+		"ok": {
+			params: IPRangesParams{
+				Scope:     "inventory",
+				Attribute: "ipv4_eth0",
+				Ranges:    []string{"10.0.0.0/8", "192.168.1.0/24"},
+			},
+		},
+		"error, no ranges": {
+			params: IPRangesParams{
+				Scope:     "inventory",
+				Attribute: "ipv4_eth0",
+			},
+			err: "ranges: cannot be blank.",
+		},
+		"error, missing attribute": {
+			params: IPRangesParams{
+				Scope:  "inventory",
+				Ranges: []string{"10.0.0.0/8"},
+			},
+			err: "attribute: cannot be blank.",
+		},
+		"error, not a CIDR range": {
+			params: IPRangesParams{
+				Scope:     "inventory",
+				Attribute: "ipv4_eth0",
+				Ranges:    []string{"10.0.0.0"},
+			},
+			err: `ranges: "10.0.0.0" is not a valid IPv4 CIDR range`,
+		},
+		"error, IPv6 range": {
+			params: IPRangesParams{
+				Scope:     "inventory",
+				Attribute: "ipv4_eth0",
+				Ranges:    []string{"fd00::/8"},
+			},
+			err: `ranges: "fd00::/8" is not a valid IPv4 CIDR range`,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := tc.params.Validate()
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestBuildIPRangesQuery(t *testing.T) {
+	query, err := BuildIPRangesQuery(IPRangesParams{
+		Scope:     "inventory",
+		Attribute: "ipv4_eth0",
+		Ranges:    []string{"10.1.2.3/8", "192.168.1.0/24"},
+		Groups:    []string{"group1"},
+		TenantID:  "tenant1",
+	})
+	assert.NoError(t, err)
+	b, err := json.Marshal(query)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"query": {"bool": {"must": [
+			{"terms": {"system_group_str": ["group1"]}},
+			{"term": {"tenantID": "tenant1"}}
+		]}},
+		"from": 0,
+		"size": 0,
+		"aggs": {
+			"ip_ranges": {
+				"ip_range": {
+					"field": "inventory_ipv4_eth0_str.ip",
+					"ranges": [
+						{"key": "10.1.2.3/8", "mask": "10.0.0.0/8"},
+						{"key": "192.168.1.0/24", "mask": "192.168.1.0/24"}
+					]
+				}
+			}
+		}
+	}`, string(b))
+}
+
+func TestParseIPRangesAggregation(t *testing.T) {
+	testCases := map[string]struct {
+		res string
+
+		ranges *IPRanges
+		err    string
+	}{
+		"ok": {
+			res: `{"aggregations": {"ip_ranges": {"buckets": [{
+				"key": "10.0.0.0/8",
+				"from": "10.0.0.0",
+				"to": "11.0.0.0",
+				"doc_count": 12
+			}, {
+				"key": "192.168.1.0/24",
+				"from": "192.168.1.0",
+				"to": "192.168.2.0",
+				"doc_count": 0
+			}]}}}`,
+			ranges: &IPRanges{
+				Buckets: []IPRangeBucket{{
+					Range: "10.0.0.0/8",
+					From:  "10.0.0.0",
+					To:    "11.0.0.0",
+					Count: 12,
+				}, {
+					Range: "192.168.1.0/24",
+					From:  "192.168.1.0",
+					To:    "192.168.2.0",
+				}},
+			},
+		},
+		"error, no aggregation": {
+			res: `{"hits": {}}`,
+			err: "can't process store aggregations",
+		},
+		"error, bad bucket": {
+			res: `{"aggregations": {"ip_ranges": {"buckets": [
+				{"key": "10.0.0.0/8"}
+			]}}}`,
+			err: "can't process ip ranges aggregation bucket count",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var res M
+			_ = json.Unmarshal([]byte(tc.res), &res)
+			ranges, err := ParseIPRangesAggregation(res)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.ranges, ranges)
+			}
+		})
+	}
+}
+
+func TestIsIPMapping(t *testing.T) {
+	assert.True(t, IsIPMapping(map[string]interface{}{
+		"type": "keyword",
+		"fields": map[string]interface{}{
+			"ip": map[string]interface{}{"type": "ip"},
+		},
+	}))
+	assert.False(t, IsIPMapping(map[string]interface{}{"type": "keyword"}))
+	assert.False(t, IsIPMapping(nil))
+}
@@ -0,0 +1,210 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pkg/errors"
+)
+
+const (
+	// PivotDepthMax is the max number of group-by attributes of a pivot
+	PivotDepthMax = 3
+	// PivotSizeDefault is the default number of buckets per level
+	PivotSizeDefault = 10
+	// PivotSizeMax is the max number of buckets per level
+	PivotSizeMax = 100
+	// PivotBucketsMax is the max total number of buckets of a pivot,
+	// i.e. the product of the sizes of all the levels
+	PivotBucketsMax = 10000
+
+	pivotAggName = "pivot"
+)
+
+// PivotDimension is an attribute the devices are grouped by, along with
+// the max number of its values (buckets) returned, the most common first
+type PivotDimension struct {
+	Scope     string `json:"scope"`
+	Attribute string `json:"attribute"`
+	Size      int    `json:"size,omitempty"`
+}
+
+func (d PivotDimension) Validate() error {
+	return validation.ValidateStruct(&d,
+		validation.Field(&d.Scope, validation.Required),
+		validation.Field(&d.Attribute, validation.Required),
+		validation.Field(&d.Size, validation.Min(0), validation.Max(PivotSizeMax)))
+}
+
+// PivotParams selects the attributes the devices matching the filters are
+// grouped by, in order: each bucket of a level is split by the next one
+type PivotParams struct {
+	GroupBy  []PivotDimension  `json:"group_by"`
+	Filters  []FilterPredicate `json:"filters"`
+	Groups   []string          `json:"-"`
+	TenantID string            `json:"-"`
+}
+
+// PivotBucket is the number of devices with a given attribute value,
+// split by the values of the next group-by attribute, if any; OtherCount
+// is the number of devices in the buckets beyond the requested size
+type PivotBucket struct {
+	Value      interface{}   `json:"value"`
+	Count      int           `json:"count"`
+	Buckets    []PivotBucket `json:"buckets,omitempty"`
+	OtherCount int           `json:"other_count,omitempty"`
+}
+
+// Pivot is the tree of the device counts by the group-by attributes
+type Pivot struct {
+	Buckets    []PivotBucket `json:"buckets"`
+	OtherCount int           `json:"other_count,omitempty"`
+}
+
+func (p PivotParams) Validate() error {
+	err := validation.ValidateStruct(&p,
+		validation.Field(&p.GroupBy,
+			validation.Required, validation.Length(1, PivotDepthMax)))
+	if err != nil {
+		return err
+	}
+
+	buckets := 1
+	for _, d := range p.GroupBy {
+		buckets *= d.size()
+	}
+	if buckets > PivotBucketsMax {
+		return errors.Errorf("group_by: at most %d buckets in total", PivotBucketsMax)
+	}
+
+	for _, f := range p.Filters {
+		if err := f.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d PivotDimension) size() int {
+	if d.Size <= 0 {
+		return PivotSizeDefault
+	}
+	return d.Size
+}
+
+// BuildPivotQuery builds the nested terms aggregations over the values of
+// the (string) group-by attributes, restricted to the devices matching
+// the filters
+func BuildPivotQuery(params PivotParams) (Query, error) {
+	query, err := BuildQuery(SearchParams{
+		Filters: params.Filters,
+		Groups:  params.Groups,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if params.TenantID != "" {
+		query = query.Must(M{
+			"term": M{
+				"tenantID": params.TenantID,
+			},
+		})
+	}
+
+	// built from the innermost level out
+	var aggs M
+	for i := len(params.GroupBy) - 1; i >= 0; i-- {
+		d := params.GroupBy[i]
+		agg := M{
+			"terms": M{
+				"field": ToAttr(d.Scope, d.Attribute, TypeStr),
+				"size":  d.size(),
+			},
+		}
+		if aggs != nil {
+			agg["aggs"] = aggs
+		}
+		aggs = M{
+			pivotAggName: agg,
+		}
+	}
+
+	// no hits, just the aggregation
+	return query.WithPage(1, 0).With(M{
+		"aggs": aggs,
+	}), nil
+}
+
+// ParsePivotAggregation parses the result of the query built with
+// BuildPivotQuery, over depth group-by attributes
+func ParsePivotAggregation(res M, depth int) (*Pivot, error) {
+	aggs, ok := res["aggregations"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("can't process store aggregations")
+	}
+
+	buckets, other, err := parsePivotLevel(aggs, depth)
+	if err != nil {
+		return nil, err
+	}
+	return &Pivot{
+		Buckets:    buckets,
+		OtherCount: other,
+	}, nil
+}
+
+// parsePivotLevel parses the pivot aggregation of a level, and recursively
+// the ones of its buckets, up to depth levels
+func parsePivotLevel(aggs map[string]interface{}, depth int) ([]PivotBucket, int, error) {
+	agg, ok := aggs[pivotAggName].(map[string]interface{})
+	if !ok {
+		return nil, 0, errors.New("can't process pivot aggregation")
+	}
+
+	buckets, ok := agg["buckets"].([]interface{})
+	if !ok {
+		return nil, 0, errors.New("can't process pivot aggregation buckets")
+	}
+	other, _ := agg["sum_other_doc_count"].(float64)
+
+	ret := make([]PivotBucket, 0, len(buckets))
+	for _, b := range buckets {
+		bucket, ok := b.(map[string]interface{})
+		if !ok {
+			return nil, 0, errors.New("can't process pivot aggregation bucket")
+		}
+
+		count, ok := bucket["doc_count"].(float64)
+		if !ok {
+			return nil, 0, errors.New("can't process pivot aggregation bucket count")
+		}
+
+		pb := PivotBucket{
+			Value: bucket["key"],
+			Count: int(count),
+		}
+		if depth > 1 {
+			var err error
+			pb.Buckets, pb.OtherCount, err = parsePivotLevel(bucket, depth-1)
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+		ret = append(ret, pb)
+	}
+
+	return ret, int(other), nil
+}
@@ -0,0 +1,239 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPivotParamsValidate(t *testing.T) {
+	dim := func(attr string, size int) PivotDimension {
+		return PivotDimension{Scope: "inventory", Attribute: attr, Size: size}
+	}
+
+	testCases := map[string]struct {
+		params PivotParams
+
+		err string
+	}{
+		"ok": {
+			params: PivotParams{
+				GroupBy: []PivotDimension{dim("os", 0), dim("device_type", 50)},
+			},
+		},
+		"error, no group-by attributes": {
+			params: PivotParams{},
+			err:    "group_by: cannot be blank.",
+		},
+		"error, too deep": {
+			params: PivotParams{
+				GroupBy: []PivotDimension{
+					dim("a", 0), dim("b", 0), dim("c", 0), dim("d", 0),
+				},
+			},
+			err: "group_by: the length must be between 1 and 3.",
+		},
+		"error, missing attribute": {
+			params: PivotParams{
+				GroupBy: []PivotDimension{dim("os", 0), dim("", 0)},
+			},
+			err: "group_by: (1: (attribute: cannot be blank.).).",
+		},
+		"error, size too large": {
+			params: PivotParams{
+				GroupBy: []PivotDimension{dim("os", 1000)},
+			},
+			err: "group_by: (0: (size: must be no greater than 100.).).",
+		},
+		"error, too many buckets": {
+			params: PivotParams{
+				GroupBy: []PivotDimension{
+					dim("a", 100), dim("b", 100), dim("c", 2),
+				},
+			},
+			err: "group_by: at most 10000 buckets in total",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := tc.params.Validate()
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestBuildPivotQuery(t *testing.T) {
+	testCases := map[string]struct {
+		params PivotParams
+
+		query string
+	}{
+		"ok, one level": {
+			params: PivotParams{
+				GroupBy: []PivotDimension{
+					{Scope: "inventory", Attribute: "os"},
+				},
+				TenantID: "tenant1",
+			},
+			query: `{
+				"query": {"bool": {"must": [{"term": {"tenantID": "tenant1"}}]}},
+				"from": 0,
+				"size": 0,
+				"aggs": {
+					"pivot": {
+						"terms": {"field": "inventory_os_str", "size": 10}
+					}
+				}
+			}`,
+		},
+		"ok, two levels, with filters": {
+			params: PivotParams{
+				GroupBy: []PivotDimension{
+					{Scope: "inventory", Attribute: "os", Size: 5},
+					{Scope: "system", Attribute: "group", Size: 20},
+				},
+				Filters: []FilterPredicate{{
+					Scope:     "identity",
+					Attribute: "status",
+					Type:      "$eq",
+					Value:     "accepted",
+				}},
+				Groups:   []string{"group1"},
+				TenantID: "tenant1",
+			},
+			query: `{
+				"query": {"bool": {"must": [
+					{"term": {"identity_status_str": "accepted"}},
+					{"terms": {"system_group_str": ["group1"]}},
+					{"term": {"tenantID": "tenant1"}}
+				]}},
+				"from": 0,
+				"size": 0,
+				"aggs": {
+					"pivot": {
+						"terms": {"field": "inventory_os_str", "size": 5},
+						"aggs": {
+							"pivot": {
+								"terms": {"field": "system_group_str", "size": 20}
+							}
+						}
+					}
+				}
+			}`,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			query, err := BuildPivotQuery(tc.params)
+			assert.NoError(t, err)
+			b, err := json.Marshal(query)
+			assert.NoError(t, err)
+			assert.JSONEq(t, tc.query, string(b))
+		})
+	}
+}
+
+func TestParsePivotAggregation(t *testing.T) {
+	testCases := map[string]struct {
+		res   string
+		depth int
+
+		pivot *Pivot
+		err   string
+	}{
+		"ok, two levels": {
+			res: `{"aggregations": {"pivot": {
+				"sum_other_doc_count": 3,
+				"buckets": [{
+					"key": "linux",
+					"doc_count": 10,
+					"pivot": {
+						"sum_other_doc_count": 0,
+						"buckets": [
+							{"key": "production", "doc_count": 6},
+							{"key": "staging", "doc_count": 4}
+						]
+					}
+				}, {
+					"key": "android",
+					"doc_count": 2,
+					"pivot": {
+						"sum_other_doc_count": 1,
+						"buckets": [
+							{"key": "production", "doc_count": 1}
+						]
+					}
+				}]
+			}}}`,
+			depth: 2,
+			pivot: &Pivot{
+				Buckets: []PivotBucket{{
+					Value: "linux",
+					Count: 10,
+					Buckets: []PivotBucket{
+						{Value: "production", Count: 6},
+						{Value: "staging", Count: 4},
+					},
+				}, {
+					Value: "android",
+					Count: 2,
+					Buckets: []PivotBucket{
+						{Value: "production", Count: 1},
+					},
+					OtherCount: 1,
+				}},
+				OtherCount: 3,
+			},
+		},
+		"ok, no buckets": {
+			res:   `{"aggregations": {"pivot": {"buckets": []}}}`,
+			depth: 2,
+			pivot: &Pivot{
+				Buckets: []PivotBucket{},
+			},
+		},
+		"error, no aggregation": {
+			res: `{"hits": {}}`,
+			err: "can't process store aggregations",
+		},
+		"error, missing nested level": {
+			res: `{"aggregations": {"pivot": {"buckets": [
+				{"key": "linux", "doc_count": 10}
+			]}}}`,
+			depth: 2,
+			err:   "can't process pivot aggregation",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var res M
+			_ = json.Unmarshal([]byte(tc.res), &res)
+			pivot, err := ParsePivotAggregation(res, tc.depth)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.pivot, pivot)
+			}
+		})
+	}
+}
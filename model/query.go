@@ -17,6 +17,11 @@ package model
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/mendersoftware/go-lib-micro/log"
 )
 
 const (
@@ -24,6 +29,19 @@ const (
 	defaultPerPage = 20
 
 	attrDeviceID = "id"
+
+	// MaxClauseCountDefault is the ES default of the max number of clauses
+	// of a query (indices.query.bool.max_clause_count)
+	MaxClauseCountDefault = 1024
+
+	// FuzzyMaxExpansionsDefault is the default max number of terms
+	// a word of a $fuzzy filter expands to, as in ES
+	FuzzyMaxExpansionsDefault = 50
+	// FuzzyMaxExpansionsMax caps the expansions, each of which is a clause
+	FuzzyMaxExpansionsMax = MaxClauseCountDefault
+	// FuzzyPrefixLengthDefault is the default number of leading characters
+	// of the words of a $fuzzy filter which must match exactly, as in ES
+	FuzzyPrefixLengthDefault = 0
 )
 
 type ArrayOpts int
@@ -35,11 +53,14 @@ const (
 )
 
 var (
+	l = log.New(nil)
+
 	ErrArrayNotSupported = errors.New("filter doesn't support array values")
 	ErrArrayRequired     = errors.New("filter supports only array values")
 	ErrStrRequired       = errors.New("filter supports only string values")
 	ErrNumRequired       = errors.New("filter supports only numeric values")
 	ErrBoolRequired      = errors.New("filter supports only boolean values")
+	ErrTooManyValues     = errors.New("filter has too many values")
 )
 
 type M map[string]interface{}
@@ -63,7 +84,30 @@ type Query interface {
 	MustNot(condition interface{}) Query
 	WithSort(sort interface{}) Query
 	WithPage(page, per_page int) Query
+	WithSourceExcludes(excludes ...string) Query
+	WithPreference(preference string) Query
+	WithSearchType(searchType string) Query
+	WithPostFilter(filter Query) Query
+	WithPointInTime(id, keepAlive string) Query
+	WithTrackTotalHits(trackTotalHits interface{}) Query
+	WithRandomScore(seed int64) Query
 	With(parts map[string]interface{}) Query
+	// Copy returns a copy of the query, which can be extended
+	// without altering the original
+	Copy() Query
+
+	// Preference returns the ES search preference, which is passed
+	// as a request parameter and not in the query body
+	Preference() string
+	// SearchType returns the ES search type, which is passed as
+	// a request parameter and not in the query body
+	SearchType() string
+	// PointInTime returns the id of the point in time searched, if any;
+	// the index isn't passed with the request then
+	PointInTime() string
+	// TrackTotalHits returns the ES track_total_hits, if set, which is
+	// passed as a request parameter and not in the query body
+	TrackTotalHits() interface{}
 
 	MarshalJSON() ([]byte, error)
 }
@@ -81,6 +125,22 @@ type query struct {
 	from    int
 	size    int
 
+	sourceExcludes []string
+
+	preference string
+	searchType string
+
+	// postFilter holds the conditions applied as post_filter
+	postFilter *query
+
+	pitID        string
+	pitKeepAlive string
+
+	trackTotalHits interface{}
+
+	// randomSeed scores the hits randomly, see WithRandomScore
+	randomSeed *int64
+
 	extra map[string]interface{}
 }
 
@@ -113,6 +173,75 @@ func (q *query) WithPage(page, perPage int) Query {
 	return q
 }
 
+// WithSourceExcludes drops fields (wildcards allowed) from the returned documents;
+// as in ES, excludes take precedence over the selected fields
+func (q *query) WithSourceExcludes(excludes ...string) Query {
+	q.sourceExcludes = append(q.sourceExcludes, excludes...)
+	return q
+}
+
+// WithPreference pins the search to the same shard copies across requests
+// with the same preference, e.g. a session id
+func (q *query) WithPreference(preference string) Query {
+	q.preference = preference
+	return q
+}
+
+func (q *query) Preference() string {
+	return q.preference
+}
+
+// WithSearchType sets the ES search type, e.g. dfs_query_then_fetch
+// for an accurate scoring of the full-text searches across shards
+func (q *query) WithSearchType(searchType string) Query {
+	q.searchType = searchType
+	return q
+}
+
+func (q *query) SearchType() string {
+	return q.searchType
+}
+
+// WithPostFilter applies the conditions of filter (a query built with
+// NewQuery) as post_filter, i.e. to the hits after the aggregations
+// are computed, which then also count the devices filtered out
+func (q *query) WithPostFilter(filter Query) Query {
+	q.postFilter, _ = filter.(*query)
+	return q
+}
+
+// WithPointInTime searches the point in time id (see
+// store.OpenPointInTime) instead of the live index, and extends
+// its lifetime by keepAlive (an ES time value, e.g. "10m")
+func (q *query) WithPointInTime(id, keepAlive string) Query {
+	q.pitID = id
+	q.pitKeepAlive = keepAlive
+	return q
+}
+
+func (q *query) PointInTime() string {
+	return q.pitID
+}
+
+// WithTrackTotalHits bounds the count of the total hits: either a bool,
+// or the number of hits up to which the total is accurate
+func (q *query) WithTrackTotalHits(trackTotalHits interface{}) Query {
+	q.trackTotalHits = trackTotalHits
+	return q
+}
+
+func (q *query) TrackTotalHits() interface{} {
+	return q.trackTotalHits
+}
+
+// WithRandomScore wraps the query in a function_score replacing the scores
+// with random ones, seeded with seed on the device ids, so that the hits
+// sorted by score come in a pseudo-random, reproducible order
+func (q *query) WithRandomScore(seed int64) Query {
+	q.randomSeed = &seed
+	return q
+}
+
 func (q *query) With(parts map[string]interface{}) Query {
 	if len(parts) == 0 {
 		return q
@@ -125,7 +254,21 @@ func (q *query) With(parts map[string]interface{}) Query {
 	return q
 }
 
-func (q *query) MarshalJSON() ([]byte, error) {
+func (q *query) Copy() Query {
+	cp := *q
+	cp.must = append([]interface{}(nil), q.must...)
+	cp.mustNot = append([]interface{}(nil), q.mustNot...)
+	cp.sort = append([]interface{}(nil), q.sort...)
+	cp.sourceExcludes = append([]string(nil), q.sourceExcludes...)
+	cp.extra = make(map[string]interface{}, len(q.extra))
+	for k, v := range q.extra {
+		cp.extra[k] = v
+	}
+	return &cp
+}
+
+// boolClause returns the body of the query's bool clause
+func (q *query) boolClause() M {
 	qbool := M{}
 
 	if q.must != nil {
@@ -136,12 +279,29 @@ func (q *query) MarshalJSON() ([]byte, error) {
 		qbool["must_not"] = q.mustNot
 	}
 
+	return qbool
+}
+
+func (q *query) MarshalJSON() ([]byte, error) {
 	qjson := M{
 		"query": M{
-			"bool": qbool,
+			"bool": q.boolClause(),
 		},
 	}
 
+	if q.randomSeed != nil {
+		qjson["query"] = M{
+			"function_score": M{
+				"query": qjson["query"],
+				"random_score": M{
+					"seed":  *q.randomSeed,
+					"field": attrDeviceID,
+				},
+				"boost_mode": "replace",
+			},
+		}
+	}
+
 	if q.sort != nil {
 		qjson["sort"] = q.sort
 	}
@@ -149,20 +309,69 @@ func (q *query) MarshalJSON() ([]byte, error) {
 	qjson["from"] = q.from
 	qjson["size"] = q.size
 
+	if q.postFilter != nil {
+		qjson["post_filter"] = M{
+			"bool": q.postFilter.boolClause(),
+		}
+	}
+
+	if q.pitID != "" {
+		qjson["pit"] = M{
+			"id":         q.pitID,
+			"keep_alive": q.pitKeepAlive,
+		}
+	}
+
 	if len(q.extra) > 0 {
 		for k, v := range q.extra {
 			qjson[k] = v
 		}
 	}
 
+	if len(q.sourceExcludes) > 0 {
+		q.applySourceExcludes(qjson)
+	}
+
 	return json.Marshal(qjson)
 }
 
-// filter factory
-func getFilterPart(pred FilterPredicate) (QueryPart, error) {
+// applySourceExcludes applies the excludes either to the selected 'fields'
+// (if the _source is disabled by a select), or as '_source.excludes'
+func (q *query) applySourceExcludes(qjson M) {
+	fields, ok := qjson["fields"].([]string)
+	if !ok {
+		qjson["_source"] = M{
+			"excludes": q.sourceExcludes,
+		}
+		return
+	}
+
+	included := []string{}
+	for _, f := range fields {
+		if f == attrDeviceID || !isExcluded(f, q.sourceExcludes) {
+			included = append(included, f)
+		}
+	}
+	qjson["fields"] = included
+}
+
+func isExcluded(field string, excludes []string) bool {
+	for _, pattern := range excludes {
+		if ok, _ := path.Match(pattern, field); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// filter factory; the $in and $nin values are split in chunks of
+// maxClauseCount values, see chunkValues (0: no chunks)
+func getFilterPart(pred FilterPredicate, maxClauseCount int) (QueryPart, error) {
 	switch pred.Type {
 	case "$eq":
 		return NewFilterEq(pred)
+	case "$contains":
+		return NewFilterContains(pred)
 	case "$ne":
 		return NewFilterNe(pred)
 	case "$gt":
@@ -174,13 +383,25 @@ func getFilterPart(pred FilterPredicate) (QueryPart, error) {
 	case "$lte":
 		return NewFilterRange(pred, "lte")
 	case "$in":
-		return NewFilterIn(pred)
+		f, err := NewFilterIn(pred)
+		if err != nil {
+			return nil, err
+		}
+		return f, f.chunk(pred, maxClauseCount)
 	case "$nin":
-		return NewFilterNin(pred)
+		f, err := NewFilterNin(pred)
+		if err != nil {
+			return nil, err
+		}
+		return f, f.chunk(pred, maxClauseCount)
 	case "$exists":
 		return NewFilterExists(pred)
 	case "$regex":
 		return NewFilterRegex(pred)
+	case "$match":
+		return NewFilterMatch(pred)
+	case "$fuzzy":
+		return NewFilterFuzzy(pred)
 	}
 
 	return nil, errors.New("filter type not supported")
@@ -191,6 +412,36 @@ type filter struct {
 	// computed attr name
 	attr string
 	val  interface{}
+
+	// relevance boost, only applies in scoring (must) context
+	boost float64
+}
+
+// value returns the filter value for the term-level queries,
+// with the boost if any
+func (f *filter) value() interface{} {
+	if f.boost == 0 {
+		return f.val
+	}
+	return M{
+		"value": f.val,
+		"boost": f.boost,
+	}
+}
+
+// withBoost adds the boost (if any) to a query clause
+func (f *filter) withBoost(clause M) M {
+	if f.boost != 0 {
+		clause["boost"] = f.boost
+	}
+	return clause
+}
+
+// ignoreBoost warns about a boost on a filter in non-scoring (must_not) context
+func (f *filter) ignoreBoost(typ string) {
+	if f.boost != 0 {
+		l.Warnf("boost on %s filter on %s is ignored in filter context", typ, f.attr)
+	}
 }
 
 func NewFilter(fp FilterPredicate, arrOpts ArrayOpts, typeOpts Type) (*filter, error) {
@@ -216,7 +467,7 @@ func NewFilter(fp FilterPredicate, arrOpts ArrayOpts, typeOpts Type) (*filter, e
 	}
 
 	if typeOpts != TypeAny && typeOpts != typ {
-		switch typ {
+		switch typeOpts {
 		case TypeStr:
 			return nil, ErrStrRequired
 		case TypeNum:
@@ -234,12 +485,14 @@ func NewFilter(fp FilterPredicate, arrOpts ArrayOpts, typeOpts Type) (*filter, e
 	}
 
 	return &filter{
-		attr: attr,
-		val:  fp.Value,
+		attr:  attr,
+		val:   fp.Value,
+		boost: fp.Boost,
 	}, nil
 }
 
-//
+// "$eq" matches the attributes equal to the value; for array-valued
+// attributes (e.g. ipv4 addresses), it matches if any element is equal
 type filterEq struct {
 	*filter
 }
@@ -257,13 +510,40 @@ func NewFilterEq(fp FilterPredicate) (*filterEq, error) {
 
 func (f *filterEq) AddTo(q Query) Query {
 	return q.Must(M{
-		"match": M{
-			f.attr: f.val,
+		"term": M{
+			f.attr: f.value(),
 		},
 	})
 }
 
-//
+// "$contains" matches the array-valued attributes having an element equal
+// to the value; the semantics are the same as "$eq", which ES applies to
+// each element of an array, but spelled out for readability
+type filterContains struct {
+	*filter
+}
+
+func NewFilterContains(fp FilterPredicate) (*filterContains, error) {
+	f, err := NewFilter(fp, ArrNotAllowed, TypeAny)
+	if err != nil {
+		return nil, err
+	}
+
+	return &filterContains{
+		filter: f,
+	}, nil
+}
+
+func (f *filterContains) AddTo(q Query) Query {
+	return q.Must(M{
+		"term": M{
+			f.attr: f.value(),
+		},
+	})
+}
+
+// "$ne" matches the attributes not equal to the value; for array-valued
+// attributes, it matches only if no element is equal
 type filterNe struct {
 	*filter
 }
@@ -280,8 +560,9 @@ func NewFilterNe(fp FilterPredicate) (*filterNe, error) {
 }
 
 func (f *filterNe) AddTo(q Query) Query {
+	f.ignoreBoost("$ne")
 	return q.MustNot(M{
-		"match": M{
+		"term": M{
 			f.attr: f.val,
 		},
 	})
@@ -305,14 +586,121 @@ func NewFilterRegex(fp FilterPredicate) (*filterRegex, error) {
 func (f *filterRegex) AddTo(q Query) Query {
 	return q.Must(M{
 		"regexp": M{
-			f.attr: f.val,
+			f.attr: f.value(),
+		},
+	})
+}
+
+// "$match" matches the analyzed text sub-field of the attribute (see
+// TextSubfield) against the analyzed value, e.g. "lenovo thinkpad" matches
+// "ThinkPad X1 (Lenovo)"; only the attributes configured as text fields
+// have the sub-field, the others never match
+type filterMatch struct {
+	*filter
+	operator  string
+	fuzziness string
+}
+
+func NewFilterMatch(fp FilterPredicate) (*filterMatch, error) {
+	f, err := NewFilter(fp, ArrNotAllowed, TypeStr)
+	if err != nil {
+		return nil, err
+	}
+	return &filterMatch{
+		filter:    f,
+		operator:  fp.Operator,
+		fuzziness: fp.Fuzziness,
+	}, nil
+}
+
+func (f *filterMatch) AddTo(q Query) Query {
+	match := M{
+		"query": f.val,
+	}
+	if f.operator != "" {
+		match["operator"] = f.operator
+	}
+	if f.fuzziness != "" {
+		match["fuzziness"] = f.fuzziness
+	}
+	return q.Must(M{
+		"match": M{
+			f.attr + "." + TextSubfield: f.withBoost(match),
+		},
+	})
+}
+
+// "$fuzzy" matches the analyzed text sub-field of the attribute, like
+// "$match", tolerating typos: the words of the value match the words up
+// to a few edits away (fuzziness AUTO, scaled with the word length)
+type filterFuzzy struct {
+	*filter
+	maxExpansions int
+	prefixLength  int
+}
+
+func NewFilterFuzzy(fp FilterPredicate) (*filterFuzzy, error) {
+	f, err := NewFilter(fp, ArrNotAllowed, TypeStr)
+	if err != nil {
+		return nil, err
+	}
+	maxExpansions := fp.MaxExpansions
+	if maxExpansions <= 0 {
+		maxExpansions = FuzzyMaxExpansionsDefault
+	}
+	prefixLength := fp.PrefixLength
+	if prefixLength <= 0 {
+		prefixLength = FuzzyPrefixLengthDefault
+	}
+	return &filterFuzzy{
+		filter:        f,
+		maxExpansions: maxExpansions,
+		prefixLength:  prefixLength,
+	}, nil
+}
+
+func (f *filterFuzzy) AddTo(q Query) Query {
+	return q.Must(M{
+		"match": M{
+			f.attr + "." + TextSubfield: f.withBoost(M{
+				"query":          f.val,
+				"fuzziness":      "AUTO",
+				"max_expansions": f.maxExpansions,
+				"prefix_length":  f.prefixLength,
+			}),
 		},
 	})
 }
 
+// chunkValues splits the array value val in chunks of at most size
+// values, so that a large array doesn't exceed the max number of terms of
+// a single clause; the chunks are as many clauses, up to size of them
+func chunkValues(fp FilterPredicate, val interface{}, size int) ([]interface{}, error) {
+	values, ok := val.([]interface{})
+	if !ok || size <= 0 || len(values) <= size {
+		return nil, nil
+	}
+	if len(values) > size*size {
+		return nil, fmt.Errorf("%w: %s %s/%s has %d values, the limit is %d",
+			ErrTooManyValues, fp.Type, fp.Scope, fp.Attribute, len(values), size*size)
+	}
+	chunks := make([]interface{}, 0, (len(values)+size-1)/size)
+	for start := 0; start < len(values); start += size {
+		end := start + size
+		if end > len(values) {
+			end = len(values)
+		}
+		chunks = append(chunks, values[start:end])
+	}
+	return chunks, nil
+}
+
 //
 type filterIn struct {
 	*filter
+
+	// chunks of the values, if split, see chunkValues
+	chunks []interface{}
 }
 
 func NewFilterIn(fp FilterPredicate) (*filterIn, error) {
@@ -325,17 +713,41 @@ func NewFilterIn(fp FilterPredicate) (*filterIn, error) {
 	}, nil
 }
 
+func (f *filterIn) chunk(fp FilterPredicate, size int) (err error) {
+	f.chunks, err = chunkValues(fp, f.val, size)
+	return err
+}
+
 func (f *filterIn) AddTo(q Query) Query {
+	if len(f.chunks) > 0 {
+		should := make([]interface{}, 0, len(f.chunks))
+		for _, chunk := range f.chunks {
+			should = append(should, M{
+				"terms": M{
+					f.attr: chunk,
+				},
+			})
+		}
+		return q.Must(M{
+			"bool": f.withBoost(M{
+				"should":               should,
+				"minimum_should_match": 1,
+			}),
+		})
+	}
 	return q.Must(M{
-		"terms": M{
+		"terms": f.withBoost(M{
 			f.attr: f.val,
-		},
+		}),
 	})
 }
 
 //
 type filterNin struct {
 	*filter
+
+	// chunks of the values, if split, see chunkValues
+	chunks []interface{}
 }
 
 func NewFilterNin(fp FilterPredicate) (*filterNin, error) {
@@ -349,7 +761,24 @@ func NewFilterNin(fp FilterPredicate) (*filterNin, error) {
 	}, nil
 }
 
+func (f *filterNin) chunk(fp FilterPredicate, size int) (err error) {
+	f.chunks, err = chunkValues(fp, f.val, size)
+	return err
+}
+
 func (f *filterNin) AddTo(q Query) Query {
+	f.ignoreBoost("$nin")
+	// none of the chunks may match
+	for _, chunk := range f.chunks {
+		q = q.MustNot(M{
+			"terms": M{
+				f.attr: chunk,
+			},
+		})
+	}
+	if len(f.chunks) > 0 {
+		return q
+	}
 	return q.MustNot(M{
 		"terms": M{
 			f.attr: f.val,
@@ -382,17 +811,18 @@ func (f *filterExists) AddTo(q Query) Query {
 
 	if exists {
 		return q.Must(M{
-			"bool": M{
+			"bool": f.withBoost(M{
 				"minimum_should_match": 1,
 				"should": S{
 					M{"exists": M{"field": astr}},
 					M{"exists": M{"field": anum}},
 					M{"exists": M{"field": abool}},
 				},
-			},
+			}),
 		})
 	}
 
+	f.ignoreBoost("$exists")
 	return q.
 		MustNot(M{"exists": M{"field": astr}}).
 		MustNot(M{"exists": M{"field": anum}}).
@@ -421,9 +851,44 @@ func NewFilterRange(fp FilterPredicate, op string) (*filterRange, error) {
 func (f *filterRange) AddTo(q Query) Query {
 	return q.Must(M{
 		"range": M{
-			f.attr: M{
+			f.attr: f.withBoost(M{
 				f.op: f.val,
-			},
+			}),
+		},
+	})
+}
+
+// filterOr matches the devices matching any of the groups of filters;
+// the filters within a group are ANDed, as the top-level ones
+type filterOr struct {
+	branches []interface{}
+}
+
+func NewFilterOr(groups [][]FilterPredicate, maxClauseCount int) (*filterOr, error) {
+	branches := make([]interface{}, 0, len(groups))
+	for _, group := range groups {
+		branch := &query{}
+		for _, f := range group {
+			fpart, err := getFilterPart(f, maxClauseCount)
+			if err != nil {
+				return nil, err
+			}
+			fpart.AddTo(branch)
+		}
+		branches = append(branches, M{
+			"bool": branch.boolClause(),
+		})
+	}
+	return &filterOr{
+		branches: branches,
+	}, nil
+}
+
+func (f *filterOr) AddTo(q Query) Query {
+	return q.Must(M{
+		"bool": M{
+			"should":               f.branches,
+			"minimum_should_match": 1,
 		},
 	})
 }
@@ -433,6 +898,7 @@ type sort struct {
 	attrStr  string
 	attrNum  string
 	attrBool string
+	mode     string
 }
 
 func NewSort(sc SortCriteria) *sort {
@@ -440,6 +906,7 @@ func NewSort(sc SortCriteria) *sort {
 		attrStr:  ToAttr(sc.Scope, sc.Attribute, TypeStr),
 		attrNum:  ToAttr(sc.Scope, sc.Attribute, TypeNum),
 		attrBool: ToAttr(sc.Scope, sc.Attribute, TypeBool),
+		mode:     sc.Mode,
 	}
 }
 
@@ -447,21 +914,94 @@ func (s *sort) AddTo(q Query) Query {
 	q = q.
 		WithSort(
 			M{
-				s.attrStr: M{
+				s.attrStr: s.withMode(M{
 					"unmapped_type": "keyword",
-				},
+				}, TypeStr),
 			},
 		).WithSort(
 		M{
-			s.attrNum: M{
+			s.attrNum: s.withMode(M{
 				"unmapped_type": "double",
-			},
+			}, TypeNum),
 		},
 	)
 
 	return q
 }
 
+// withMode sets the sort mode, if compatible with the field type:
+// ES rejects avg, sum and median on keyword fields, so the string
+// variant of the attribute falls back to the default mode
+func (s *sort) withMode(clause M, typ Type) M {
+	switch s.mode {
+	case "":
+	case "min", "max":
+		clause["mode"] = s.mode
+	default:
+		if typ == TypeNum {
+			clause["mode"] = s.mode
+		}
+	}
+	return clause
+}
+
+// scriptSortSource ranks the first value of the string attribute by its
+// position in the custom order; the values not in the order, and the devices
+// without the attribute, rank after the listed values
+const scriptSortSource = "if (!doc.containsKey(params.field) || " +
+	"doc[params.field].size() == 0) { return params.missing; } " +
+	"def rank = params.ranks[doc[params.field].value]; " +
+	"return rank == null ? params.missing : rank;"
+
+// SortOrderKey returns the key of the attribute's custom sort order,
+// "<scope>/<name>"; the keys are case-insensitive, as the configuration
+// keys are
+func SortOrderKey(scope, name string) string {
+	return strings.ToLower(scope + "/" + name)
+}
+
+// scriptSort sorts on a string attribute by the rank of its values in a
+// custom order (e.g. "critical", "warning", "info") instead of lexically;
+// the ordering is a script parameter, never user-supplied code
+type scriptSort struct {
+	attrStr string
+	order   string
+	values  []string
+}
+
+func NewScriptSort(sc SortCriteria, values []string) *scriptSort {
+	return &scriptSort{
+		attrStr: ToAttr(sc.Scope, sc.Attribute, TypeStr),
+		order:   sc.Order,
+		values:  values,
+	}
+}
+
+func (s *scriptSort) AddTo(q Query) Query {
+	ranks := make(M, len(s.values))
+	for i, v := range s.values {
+		ranks[v] = i
+	}
+	clause := M{
+		"type": "number",
+		"script": M{
+			"lang":   "painless",
+			"source": scriptSortSource,
+			"params": M{
+				"field":   s.attrStr,
+				"ranks":   ranks,
+				"missing": len(s.values),
+			},
+		},
+	}
+	if s.order != "" {
+		clause["order"] = s.order
+	}
+	return q.WithSort(M{
+		"_script": clause,
+	})
+}
+
 //
 type sel struct {
 	attrs []SelectAttribute
@@ -494,6 +1034,31 @@ func (s *sel) AddTo(q Query) Query {
 
 }
 
+//
+type sourceExcludes struct {
+	attrs []SelectAttribute
+}
+
+func NewSourceExcludes(attrs []SelectAttribute) *sourceExcludes {
+	return &sourceExcludes{
+		attrs: attrs,
+	}
+}
+
+func (s *sourceExcludes) AddTo(q Query) Query {
+	excludes := []string{}
+
+	for _, a := range s.attrs {
+		excludes = append(excludes,
+			ToAttr(a.Scope, a.Attribute, TypeStr),
+			ToAttr(a.Scope, a.Attribute, TypeNum),
+			ToAttr(a.Scope, a.Attribute, TypeBool),
+		)
+	}
+
+	return q.WithSourceExcludes(excludes...)
+}
+
 //
 type devIDsFilter struct {
 	devIDs []string
@@ -513,17 +1078,55 @@ func (f *devIDsFilter) AddTo(q Query) Query {
 	})
 }
 
+// hasScoringQuery tells if q has any conditions in the must context
+func hasScoringQuery(q Query) bool {
+	qq, ok := q.(*query)
+	return ok && len(qq.must) > 0
+}
+
 func BuildQuery(params SearchParams) (Query, error) {
 	query := NewQuery()
 
 	for _, f := range params.Filters {
-		fpart, err := getFilterPart(f)
+		fpart, err := getFilterPart(f, params.MaxClauseCount)
 		if err != nil {
 			return nil, err
 		}
 		query = fpart.AddTo(query)
 	}
 
+	if len(params.Or) > 0 {
+		fpart, err := NewFilterOr(params.Or, params.MaxClauseCount)
+		if err != nil {
+			return nil, err
+		}
+		query = fpart.AddTo(query)
+	}
+
+	if len(params.PostFilters) > 0 {
+		postFilter := NewQuery()
+		for _, f := range params.PostFilters {
+			fpart, err := getFilterPart(f, params.MaxClauseCount)
+			if err != nil {
+				return nil, err
+			}
+			postFilter = fpart.AddTo(postFilter)
+		}
+		query = query.WithPostFilter(postFilter)
+	}
+
+	// highlighting needs a scoring query, i.e. some filters in must
+	// context; the groups and tenant terms don't count
+	if params.Highlight != nil && hasScoringQuery(query) {
+		hl := NewHighlight(*params.Highlight)
+		query = hl.AddTo(query)
+	}
+
+	if params.Sample != nil {
+		sample := NewSample(*params.Sample)
+		query = sample.AddTo(query)
+	}
+
 	if len(params.Groups) > 0 {
 		fp := FilterPredicate{
 			Scope:     scopeSystem,
@@ -539,7 +1142,11 @@ func BuildQuery(params SearchParams) (Query, error) {
 	}
 
 	for _, s := range params.Sort {
-		sort := NewSort(s)
+		var sort QueryPart = NewSort(s)
+		// only the attributes with a configured order get a script sort
+		if values, ok := params.SortOrders[SortOrderKey(s.Scope, s.Attribute)]; ok {
+			sort = NewScriptSort(s, values)
+		}
 		query = sort.AddTo(query)
 	}
 
@@ -550,11 +1157,52 @@ func BuildQuery(params SearchParams) (Query, error) {
 		query = sel.AddTo(query)
 	}
 
+	if len(params.ExcludeAttributes) > 0 {
+		excl := NewSourceExcludes(params.ExcludeAttributes)
+		query = excl.AddTo(query)
+	}
+
 	if len(params.DeviceIDs) > 0 {
 		devs := NewDevIDsFilter(params.DeviceIDs)
 		query = devs.AddTo(query)
 	}
 
+	if params.Preference != "" {
+		query = query.WithPreference(params.Preference)
+	}
+
+	if params.SearchType != "" {
+		query = query.WithSearchType(params.SearchType)
+	}
+
+	if params.Profile {
+		query = query.With(M{
+			"profile": true,
+		})
+	}
+
+	if params.TerminateAfter > 0 {
+		query = query.With(M{
+			"terminate_after": params.TerminateAfter,
+		})
+	}
+
+	if params.Timeout != "" {
+		query = query.With(M{
+			"timeout": params.Timeout,
+		})
+	}
+
+	if params.TrackSeqNo {
+		query = query.With(M{
+			"seq_no_primary_term": true,
+		})
+	}
+
+	if !params.TrackTotalHits.CountsAll() {
+		query = query.WithTrackTotalHits(params.TrackTotalHits.Value())
+	}
+
 	return query, nil
 }
 
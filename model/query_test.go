@@ -15,6 +15,8 @@
 package model
 
 import (
+	"encoding/json"
+	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -45,6 +47,445 @@ func TestBuildQuery(t *testing.T) {
 				},
 			}),
 		},
+		"eq": {
+			inParams: SearchParams{
+				Filters: []FilterPredicate{{
+					Scope:     "inventory",
+					Attribute: "ipv4_addresses",
+					Type:      "$eq",
+					Value:     "10.0.0.2",
+				}},
+				Page:    defaultPage,
+				PerPage: defaultPerPage,
+			},
+			outQuery: NewQuery().Must(M{
+				"term": M{
+					"inventory_ipv4_addresses_str": "10.0.0.2",
+				},
+			}),
+		},
+		"contains": {
+			inParams: SearchParams{
+				Filters: []FilterPredicate{{
+					Scope:     "inventory",
+					Attribute: "ipv4_addresses",
+					Type:      "$contains",
+					Value:     "10.0.0.2",
+				}},
+				Page:    defaultPage,
+				PerPage: defaultPerPage,
+			},
+			// a term query matches a document with
+			// "inventory_ipv4_addresses_str": ["10.0.0.1", "10.0.0.2"]
+			outQuery: NewQuery().Must(M{
+				"term": M{
+					"inventory_ipv4_addresses_str": "10.0.0.2",
+				},
+			}),
+		},
+		"ne": {
+			inParams: SearchParams{
+				Filters: []FilterPredicate{{
+					Scope:     "inventory",
+					Attribute: "ipv4_addresses",
+					Type:      "$ne",
+					Value:     "10.0.0.2",
+				}},
+				Page:    defaultPage,
+				PerPage: defaultPerPage,
+			},
+			outQuery: NewQuery().MustNot(M{
+				"term": M{
+					"inventory_ipv4_addresses_str": "10.0.0.2",
+				},
+			}),
+		},
+		"boost, scoring context": {
+			inParams: SearchParams{
+				Filters: []FilterPredicate{{
+					Scope:     "inventory",
+					Attribute: "hostname",
+					Type:      "$eq",
+					Value:     "raspberrypi",
+					Boost:     2,
+				}, {
+					Scope:     "inventory",
+					Attribute: "region",
+					Type:      "$in",
+					Value:     []interface{}{"eu", "us"},
+					Boost:     1.5,
+				}, {
+					Scope:     "inventory",
+					Attribute: "mem",
+					Type:      "$gt",
+					Value:     float64(512),
+					Boost:     0.5,
+				}},
+				Page:    defaultPage,
+				PerPage: defaultPerPage,
+			},
+			outQuery: NewQuery().Must(M{
+				"term": M{
+					"inventory_hostname_str": M{
+						"value": "raspberrypi",
+						"boost": float64(2),
+					},
+				},
+			}).Must(M{
+				"terms": M{
+					"inventory_region_str": []interface{}{"eu", "us"},
+					"boost":                1.5,
+				},
+			}).Must(M{
+				"range": M{
+					"inventory_mem_num": M{
+						"gt":    float64(512),
+						"boost": 0.5,
+					},
+				},
+			}),
+		},
+		"boost, ignored in filter context": {
+			inParams: SearchParams{
+				Filters: []FilterPredicate{{
+					Scope:     "inventory",
+					Attribute: "hostname",
+					Type:      "$ne",
+					Value:     "raspberrypi",
+					Boost:     2,
+				}, {
+					Scope:     "inventory",
+					Attribute: "region",
+					Type:      "$nin",
+					Value:     []interface{}{"eu", "us"},
+					Boost:     1.5,
+				}},
+				Page:    defaultPage,
+				PerPage: defaultPerPage,
+			},
+			outQuery: NewQuery().MustNot(M{
+				"term": M{
+					"inventory_hostname_str": "raspberrypi",
+				},
+			}).MustNot(M{
+				"terms": M{
+					"inventory_region_str": []interface{}{"eu", "us"},
+				},
+			}),
+		},
+		"in, chunked": {
+			inParams: SearchParams{
+				Filters: []FilterPredicate{{
+					Scope:     "inventory",
+					Attribute: "mac",
+					Type:      "$in",
+					Value:     []interface{}{"m1", "m2", "m3", "m4", "m5"},
+				}, {
+					Scope:     "inventory",
+					Attribute: "region",
+					Type:      "$in",
+					Value:     []interface{}{"eu", "us"},
+				}},
+				MaxClauseCount: 3,
+				Page:           defaultPage,
+				PerPage:        defaultPerPage,
+			},
+			outQuery: NewQuery().Must(M{
+				"bool": M{
+					"should": []interface{}{
+						M{"terms": M{
+							"inventory_mac_str": []interface{}{"m1", "m2", "m3"},
+						}},
+						M{"terms": M{
+							"inventory_mac_str": []interface{}{"m4", "m5"},
+						}},
+					},
+					"minimum_should_match": 1,
+				},
+			}).Must(M{
+				"terms": M{
+					"inventory_region_str": []interface{}{"eu", "us"},
+				},
+			}),
+		},
+		"nin, chunked": {
+			inParams: SearchParams{
+				Filters: []FilterPredicate{{
+					Scope:     "inventory",
+					Attribute: "mac",
+					Type:      "$nin",
+					Value:     []interface{}{"m1", "m2", "m3", "m4", "m5"},
+				}},
+				MaxClauseCount: 3,
+				Page:           defaultPage,
+				PerPage:        defaultPerPage,
+			},
+			outQuery: NewQuery().MustNot(M{
+				"terms": M{
+					"inventory_mac_str": []interface{}{"m1", "m2", "m3"},
+				},
+			}).MustNot(M{
+				"terms": M{
+					"inventory_mac_str": []interface{}{"m4", "m5"},
+				},
+			}),
+		},
+		"preference": {
+			inParams: SearchParams{
+				Preference: "session-1234",
+				Page:       defaultPage,
+				PerPage:    defaultPerPage,
+			},
+			outQuery: NewQuery().WithPreference("session-1234"),
+		},
+		"search type": {
+			inParams: SearchParams{
+				SearchType: SearchTypeDFSQueryThenFetch,
+				Page:       defaultPage,
+				PerPage:    defaultPerPage,
+			},
+			outQuery: NewQuery().WithSearchType(SearchTypeDFSQueryThenFetch),
+		},
+		"sort, avg mode on numeric array": {
+			inParams: SearchParams{
+				Sort: []SortCriteria{{
+					Scope:     "inventory",
+					Attribute: "temperatures",
+					Order:     "asc",
+					Mode:      "avg",
+				}},
+				Page:    defaultPage,
+				PerPage: defaultPerPage,
+			},
+			// avg is not applicable to the keyword variant
+			outQuery: NewQuery().WithSort(M{
+				"inventory_temperatures_str": M{
+					"unmapped_type": "keyword",
+				},
+			}).WithSort(M{
+				"inventory_temperatures_num": M{
+					"unmapped_type": "double",
+					"mode":          "avg",
+				},
+			}),
+		},
+		"sort, max mode": {
+			inParams: SearchParams{
+				Sort: []SortCriteria{{
+					Scope:     "inventory",
+					Attribute: "ipv4_addresses",
+					Order:     "desc",
+					Mode:      "max",
+				}},
+				Page:    defaultPage,
+				PerPage: defaultPerPage,
+			},
+			outQuery: NewQuery().WithSort(M{
+				"inventory_ipv4_addresses_str": M{
+					"unmapped_type": "keyword",
+					"mode":          "max",
+				},
+			}).WithSort(M{
+				"inventory_ipv4_addresses_num": M{
+					"unmapped_type": "double",
+					"mode":          "max",
+				},
+			}),
+		},
+		"or of and groups": {
+			// (group = A AND os = linux) OR (group = B)
+			inParams: SearchParams{
+				Or: [][]FilterPredicate{{{
+					Scope:     "system",
+					Attribute: "group",
+					Type:      "$eq",
+					Value:     "A",
+				}, {
+					Scope:     "inventory",
+					Attribute: "os",
+					Type:      "$ne",
+					Value:     "windows",
+				}}, {{
+					Scope:     "system",
+					Attribute: "group",
+					Type:      "$eq",
+					Value:     "B",
+				}}},
+				Page:    defaultPage,
+				PerPage: defaultPerPage,
+			},
+			outQuery: NewQuery().Must(M{
+				"bool": M{
+					"should": []interface{}{
+						M{"bool": M{
+							"must": []interface{}{
+								M{"term": M{"system_group_str": "A"}},
+							},
+							"must_not": []interface{}{
+								M{"term": M{"inventory_os_str": "windows"}},
+							},
+						}},
+						M{"bool": M{
+							"must": []interface{}{
+								M{"term": M{"system_group_str": "B"}},
+							},
+						}},
+					},
+					"minimum_should_match": 1,
+				},
+			}),
+		},
+		"highlight": {
+			inParams: SearchParams{
+				Filters: []FilterPredicate{{
+					Scope:     "inventory",
+					Attribute: "os",
+					Type:      "$eq",
+					Value:     "linux",
+				}},
+				Highlight: &HighlightParams{
+					Attributes: []SelectAttribute{{
+						Scope:     "inventory",
+						Attribute: "os",
+					}},
+					FragmentSize:      50,
+					NumberOfFragments: 2,
+				},
+				Page:    defaultPage,
+				PerPage: defaultPerPage,
+			},
+			outQuery: NewQuery().Must(M{
+				"term": M{"inventory_os_str": "linux"},
+			}).With(M{
+				"highlight": M{
+					"fields": M{
+						"inventory_os_str":      M{},
+						"inventory_os_str.text": M{},
+					},
+					"fragment_size":       50,
+					"number_of_fragments": 2,
+				},
+			}),
+		},
+		"highlight, no scoring query": {
+			inParams: SearchParams{
+				Highlight: &HighlightParams{
+					Attributes: []SelectAttribute{{
+						Scope:     "inventory",
+						Attribute: "os",
+					}},
+				},
+				Groups:  []string{"A"},
+				Page:    defaultPage,
+				PerPage: defaultPerPage,
+			},
+			outQuery: NewQuery().Must(M{
+				"terms": M{"system_group_str": []string{"A"}},
+			}),
+		},
+		"profile": {
+			inParams: SearchParams{
+				Profile: true,
+				Page:    defaultPage,
+				PerPage: defaultPerPage,
+			},
+			outQuery: NewQuery().With(M{
+				"profile": true,
+			}),
+		},
+		"terminate after": {
+			inParams: SearchParams{
+				TerminateAfter: 1000,
+				Page:           defaultPage,
+				PerPage:        defaultPerPage,
+			},
+			outQuery: NewQuery().With(M{
+				"terminate_after": 1000,
+			}),
+		},
+		"timeout": {
+			inParams: SearchParams{
+				Timeout: "2s",
+				Page:    defaultPage,
+				PerPage: defaultPerPage,
+			},
+			outQuery: NewQuery().With(M{
+				"timeout": "2s",
+			}),
+		},
+		"track seq no": {
+			inParams: SearchParams{
+				TrackSeqNo: true,
+				Page:       defaultPage,
+				PerPage:    defaultPerPage,
+			},
+			outQuery: NewQuery().With(M{
+				"seq_no_primary_term": true,
+			}),
+		},
+		"post filters": {
+			inParams: SearchParams{
+				Filters: []FilterPredicate{{
+					Scope:     "identity",
+					Attribute: "status",
+					Type:      "$eq",
+					Value:     "accepted",
+				}},
+				PostFilters: []FilterPredicate{{
+					Scope:     "inventory",
+					Attribute: "device_type",
+					Type:      "$in",
+					Value:     []interface{}{"rpi3", "rpi4"},
+				}, {
+					Scope:     "inventory",
+					Attribute: "os",
+					Type:      "$ne",
+					Value:     "android",
+				}},
+				Page:    defaultPage,
+				PerPage: defaultPerPage,
+			},
+			// the post filters aren't part of the main query
+			outQuery: NewQuery().Must(M{
+				"term": M{
+					"identity_status_str": "accepted",
+				},
+			}).WithPostFilter(NewQuery().Must(M{
+				"terms": M{
+					"inventory_device_type_str": []interface{}{"rpi3", "rpi4"},
+				},
+			}).MustNot(M{
+				"term": M{
+					"inventory_os_str": "android",
+				},
+			})),
+		},
+		"error, post filter contains with array value": {
+			inParams: SearchParams{
+				PostFilters: []FilterPredicate{{
+					Scope:     "inventory",
+					Attribute: "ipv4_addresses",
+					Type:      "$contains",
+					Value:     []interface{}{"10.0.0.1", "10.0.0.2"},
+				}},
+				Page:    defaultPage,
+				PerPage: defaultPerPage,
+			},
+			outErr: ErrArrayNotSupported,
+		},
+		"error, contains with array value": {
+			inParams: SearchParams{
+				Filters: []FilterPredicate{{
+					Scope:     "inventory",
+					Attribute: "ipv4_addresses",
+					Type:      "$contains",
+					Value:     []interface{}{"10.0.0.1", "10.0.0.2"},
+				}},
+				Page:    defaultPage,
+				PerPage: defaultPerPage,
+			},
+			outErr: ErrArrayNotSupported,
+		},
 	}
 	for name, tc := range testCases {
 		t.Run(name, func(t *testing.T) {
@@ -58,3 +499,369 @@ func TestBuildQuery(t *testing.T) {
 		})
 	}
 }
+
+func TestBuildQueryMatch(t *testing.T) {
+	testCases := map[string]struct {
+		filter FilterPredicate
+
+		match M
+	}{
+		"ok": {
+			filter: FilterPredicate{
+				Scope:     "inventory",
+				Attribute: "product_name",
+				Type:      "$match",
+				Value:     "lenovo thinkpad",
+			},
+			match: M{
+				"inventory_product_name_str.text": M{
+					"query": "lenovo thinkpad",
+				},
+			},
+		},
+		"ok, operator and fuzziness": {
+			filter: FilterPredicate{
+				Scope:     "inventory",
+				Attribute: "product_name",
+				Type:      "$match",
+				Value:     "lenovo thinkpad",
+				Operator:  "and",
+				Fuzziness: "AUTO",
+				Boost:     2,
+			},
+			match: M{
+				"inventory_product_name_str.text": M{
+					"query":     "lenovo thinkpad",
+					"operator":  "and",
+					"fuzziness": "AUTO",
+					"boost":     float64(2),
+				},
+			},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			q, err := BuildQuery(SearchParams{
+				Filters: []FilterPredicate{tc.filter},
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, []interface{}{
+				M{"match": tc.match},
+			}, q.(*query).must)
+		})
+	}
+
+	_, err := BuildQuery(SearchParams{
+		Filters: []FilterPredicate{{
+			Scope:     "inventory",
+			Attribute: "product_name",
+			Type:      "$match",
+			Value:     true,
+		}},
+	})
+	assert.Equal(t, ErrStrRequired, err)
+}
+
+func TestBuildQueryFuzzy(t *testing.T) {
+	testCases := map[string]struct {
+		filter FilterPredicate
+
+		match M
+	}{
+		"ok, defaults": {
+			filter: FilterPredicate{
+				Scope:     "inventory",
+				Attribute: "device_name",
+				Type:      "$fuzzy",
+				Value:     "raspbery",
+			},
+			match: M{
+				"inventory_device_name_str.text": M{
+					"query":          "raspbery",
+					"fuzziness":      "AUTO",
+					"max_expansions": FuzzyMaxExpansionsDefault,
+					"prefix_length":  FuzzyPrefixLengthDefault,
+				},
+			},
+		},
+		"ok, max expansions and prefix length": {
+			filter: FilterPredicate{
+				Scope:         "inventory",
+				Attribute:     "device_name",
+				Type:          "$fuzzy",
+				Value:         "raspbery",
+				MaxExpansions: 10,
+				PrefixLength:  2,
+			},
+			match: M{
+				"inventory_device_name_str.text": M{
+					"query":          "raspbery",
+					"fuzziness":      "AUTO",
+					"max_expansions": 10,
+					"prefix_length":  2,
+				},
+			},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			q, err := BuildQuery(SearchParams{
+				Filters: []FilterPredicate{tc.filter},
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, []interface{}{
+				M{"match": tc.match},
+			}, q.(*query).must)
+		})
+	}
+
+	_, err := BuildQuery(SearchParams{
+		Filters: []FilterPredicate{{
+			Scope:     "inventory",
+			Attribute: "device_name",
+			Type:      "$fuzzy",
+			Value:     float64(1),
+		}},
+	})
+	assert.Equal(t, ErrStrRequired, err)
+}
+
+func TestBuildQueryTooManyValues(t *testing.T) {
+	values := make([]interface{}, 10)
+	for i := range values {
+		values[i] = float64(i)
+	}
+	_, err := BuildQuery(SearchParams{
+		Or: [][]FilterPredicate{{{
+			Scope:     "inventory",
+			Attribute: "mem",
+			Type:      "$in",
+			Value:     values,
+		}}},
+		MaxClauseCount: 3,
+	})
+	assert.True(t, errors.Is(err, ErrTooManyValues))
+	assert.EqualError(t, err,
+		"filter has too many values: $in inventory/mem has 10 values, the limit is 9")
+
+	// no limit
+	_, err = BuildQuery(SearchParams{
+		Filters: []FilterPredicate{{
+			Scope:     "inventory",
+			Attribute: "mem",
+			Type:      "$in",
+			Value:     values,
+		}},
+	})
+	assert.NoError(t, err)
+}
+
+func TestBuildQueryScriptSort(t *testing.T) {
+	q, err := BuildQuery(SearchParams{
+		Sort: []SortCriteria{
+			{Scope: "inventory", Attribute: "Severity", Order: "desc"},
+			{Scope: "inventory", Attribute: "os", Order: "asc"},
+		},
+		SortOrders: map[string][]string{
+			"inventory/severity": {"critical", "warning", "info"},
+		},
+	})
+	assert.NoError(t, err)
+
+	qq := q.(*query)
+	if assert.Len(t, qq.sort, 3) {
+		assert.Equal(t, M{
+			"_script": M{
+				"type": "number",
+				"script": M{
+					"lang":   "painless",
+					"source": scriptSortSource,
+					"params": M{
+						"field": "inventory_Severity_str",
+						"ranks": M{
+							"critical": 0,
+							"warning":  1,
+							"info":     2,
+						},
+						"missing": 3,
+					},
+				},
+				"order": "desc",
+			},
+		}, qq.sort[0])
+		// the attributes without a custom order sort on the fields
+		assert.Contains(t, qq.sort[1], "inventory_os_str")
+		assert.Contains(t, qq.sort[2], "inventory_os_num")
+	}
+}
+
+func TestQueryPostFilterJSON(t *testing.T) {
+	filters := []FilterPredicate{{
+		Scope:     "inventory",
+		Attribute: "device_type",
+		Type:      "$eq",
+		Value:     "rpi4",
+	}}
+
+	// as a main filter, the device type narrows both the hits and the
+	// aggregations; as a post filter, only the hits
+	query, err := BuildQuery(SearchParams{Filters: filters, Page: 1, PerPage: 10})
+	assert.NoError(t, err)
+	b, _ := json.Marshal(query)
+	assert.JSONEq(t, `{
+		"query": {"bool": {"must": [{"term": {"inventory_device_type_str": "rpi4"}}]}},
+		"from": 0,
+		"size": 10
+	}`, string(b))
+
+	query, err = BuildQuery(SearchParams{PostFilters: filters, Page: 1, PerPage: 10})
+	assert.NoError(t, err)
+	b, _ = json.Marshal(query)
+	assert.JSONEq(t, `{
+		"query": {"bool": {}},
+		"post_filter": {"bool": {"must": [
+			{"term": {"inventory_device_type_str": "rpi4"}}
+		]}},
+		"from": 0,
+		"size": 10
+	}`, string(b))
+}
+
+func TestQueryTrackTotalHits(t *testing.T) {
+	testCases := map[string]struct {
+		body string
+
+		trackTotalHits interface{}
+		err            string
+	}{
+		"default, all hits counted": {
+			body: `{"page": 2, "per_page": 10}`,
+		},
+		"all hits counted": {
+			body: `{"page": 2, "per_page": 10, "track_total_hits": true}`,
+		},
+		"not counted": {
+			body:           `{"page": 2, "per_page": 10, "track_total_hits": false}`,
+			trackTotalHits: false,
+		},
+		"counted up to a threshold": {
+			body:           `{"page": 2, "per_page": 10, "track_total_hits": 100}`,
+			trackTotalHits: 100,
+		},
+		"error, not a bool or an integer": {
+			body: `{"track_total_hits": "all"}`,
+			err:  "track_total_hits must be a boolean or an integer",
+		},
+		"error, negative threshold": {
+			body: `{"track_total_hits": -1}`,
+			err:  "track_total_hits: must be a boolean or a non-negative integer.",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var params SearchParams
+			err := json.Unmarshal([]byte(tc.body), &params)
+			if err == nil {
+				err = params.Validate()
+			}
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			assert.NoError(t, err)
+
+			query, err := BuildQuery(params)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.trackTotalHits, query.TrackTotalHits())
+		})
+	}
+}
+
+func TestQuerySourceExcludes(t *testing.T) {
+	testCases := map[string]struct {
+		inParams SearchParams
+		excludes []string
+
+		outSource interface{}
+		outFields interface{}
+	}{
+		"excludes only": {
+			inParams: SearchParams{
+				ExcludeAttributes: []SelectAttribute{{
+					Scope:     "inventory",
+					Attribute: "packages",
+				}},
+			},
+			outSource: map[string]interface{}{
+				"excludes": []interface{}{
+					"inventory_packages_str",
+					"inventory_packages_num",
+					"inventory_packages_bool",
+				},
+			},
+		},
+		"excludes only, global wildcard": {
+			inParams: SearchParams{},
+			excludes: []string{"inventory_packages_*"},
+			outSource: map[string]interface{}{
+				"excludes": []interface{}{"inventory_packages_*"},
+			},
+		},
+		"includes and excludes, excludes take precedence": {
+			inParams: SearchParams{
+				Attributes: []SelectAttribute{{
+					Scope:     "inventory",
+					Attribute: "packages",
+				}, {
+					Scope:     "inventory",
+					Attribute: "mac",
+				}},
+				ExcludeAttributes: []SelectAttribute{{
+					Scope:     "inventory",
+					Attribute: "packages",
+				}},
+			},
+			outSource: false,
+			outFields: []interface{}{
+				"inventory_mac_str",
+				"inventory_mac_num",
+				"inventory_mac_bool",
+				"id",
+			},
+		},
+		"includes and wildcard excludes, device id always included": {
+			inParams: SearchParams{
+				Attributes: []SelectAttribute{{
+					Scope:     "inventory",
+					Attribute: "mac",
+				}},
+			},
+			excludes:  []string{"inventory_*_num", "id"},
+			outSource: false,
+			outFields: []interface{}{
+				"inventory_mac_str",
+				"inventory_mac_bool",
+				"id",
+			},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			query, err := BuildQuery(tc.inParams)
+			assert.NoError(t, err)
+			if len(tc.excludes) > 0 {
+				query = query.WithSourceExcludes(tc.excludes...)
+			}
+
+			b, err := json.Marshal(query)
+			assert.NoError(t, err)
+
+			var res map[string]interface{}
+			err = json.Unmarshal(b, &res)
+			assert.NoError(t, err)
+
+			assert.Equal(t, tc.outSource, res["_source"])
+			assert.Equal(t, tc.outFields, res["fields"])
+		})
+	}
+}
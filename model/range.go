@@ -0,0 +1,102 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"github.com/pkg/errors"
+)
+
+// ErrRangeFilterType is returned when a range filter doesn't apply
+// to the mapped type of its attribute
+var ErrRangeFilterType = errors.New("cannot apply range filter")
+
+// rangeNumericTypes are the mapping types supporting numeric ranges
+var rangeNumericTypes = map[string]bool{
+	"long":          true,
+	"integer":       true,
+	"short":         true,
+	"byte":          true,
+	"double":        true,
+	"float":         true,
+	"half_float":    true,
+	"scaled_float":  true,
+	"unsigned_long": true,
+	"date":          true,
+	"date_nanos":    true,
+}
+
+// isRangeFilter tells if the filter is a range ($gt, $gte, $lt, $lte) one
+func isRangeFilter(fp FilterPredicate) bool {
+	switch fp.Type {
+	case "$gt", "$gte", "$lt", "$lte":
+		return true
+	}
+	return false
+}
+
+// HasRangeFilters tells if any of the filters is a range one
+func HasRangeFilters(filters []FilterPredicate) bool {
+	for _, fp := range filters {
+		if isRangeFilter(fp) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckRangeFilters checks the range filters against fieldTypes, the mapped
+// types of the devices index fields: a numeric range requires a numeric
+// attribute, instead of failing in ES or silently matching no devices;
+// the filters on unknown attributes pass, as they just match no devices
+func CheckRangeFilters(fieldTypes map[string]string, filters []FilterPredicate) error {
+	for _, fp := range filters {
+		if !isRangeFilter(fp) {
+			continue
+		}
+		// the invalid filters are reported when building the query
+		f, err := NewFilter(fp, ArrNotAllowed, TypeAny)
+		if err != nil {
+			continue
+		}
+		typ, _, _ := fp.ValueType()
+
+		if typ != TypeNum {
+			continue
+		}
+
+		fieldType, mapped := fieldTypes[f.attr]
+		switch {
+		case mapped && !rangeNumericTypes[fieldType]:
+			return errors.Wrapf(ErrRangeFilterType,
+				"attribute %s/%s is not numeric", fp.Scope, fp.Attribute)
+		case !mapped && hasOtherTypes(fieldTypes, fp):
+			// the attribute has only non-numeric values
+			return errors.Wrapf(ErrRangeFilterType,
+				"attribute %s/%s is not numeric", fp.Scope, fp.Attribute)
+		}
+	}
+	return nil
+}
+
+// hasOtherTypes tells if the attribute of the filter is mapped with
+// a string or a boolean type
+func hasOtherTypes(fieldTypes map[string]string, fp FilterPredicate) bool {
+	for _, typ := range []Type{TypeStr, TypeBool} {
+		if _, ok := fieldTypes[ToAttr(fp.Scope, fp.Attribute, typ)]; ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckRangeFilters(t *testing.T) {
+	fieldTypes := map[string]string{
+		"id":                           "keyword",
+		"inventory_mem_total_kB_num":   "double",
+		"inventory_uptime_num":         "long",
+		"inventory_cpu_percent_num":    "scaled_float",
+		"inventory_mac_str":            "keyword",
+		"inventory_enabled_bool":       "boolean",
+		"inventory_kernel_version_num": "version",
+		"system_updated_ts_str":        "keyword",
+	}
+	pred := func(attr, typ string, value interface{}) FilterPredicate {
+		return FilterPredicate{
+			Scope:     "inventory",
+			Attribute: attr,
+			Type:      typ,
+			Value:     value,
+		}
+	}
+
+	testCases := map[string]struct {
+		filters []FilterPredicate
+
+		err string
+	}{
+		"ok, no range filters": {
+			filters: []FilterPredicate{pred("mac", "$eq", 1.0)},
+		},
+		"ok, numeric": {
+			filters: []FilterPredicate{
+				pred("mem_total_kB", "$gt", 1024.0),
+				pred("uptime", "$lte", 3600.0),
+			},
+		},
+		"ok, scaled float": {
+			filters: []FilterPredicate{pred("cpu_percent", "$gte", 99.5)},
+		},
+		"ok, string range": {
+			filters: []FilterPredicate{{
+				Scope:     "system",
+				Attribute: "updated_ts",
+				Type:      "$gte",
+				Value:     "2021-10-01T12:00:00Z",
+			}},
+		},
+		"ok, unknown attribute": {
+			filters: []FilterPredicate{pred("foo", "$gt", 1.0)},
+		},
+		"ok, invalid filter": {
+			filters: []FilterPredicate{pred("mac", "$gt", []interface{}{1.0})},
+		},
+		"error, string attribute": {
+			filters: []FilterPredicate{
+				pred("uptime", "$gt", 0.0),
+				pred("mac", "$lt", 10.0),
+			},
+			err: "attribute inventory/mac is not numeric: cannot apply range filter",
+		},
+		"error, boolean attribute": {
+			filters: []FilterPredicate{pred("enabled", "$gte", 1.0)},
+			err:     "attribute inventory/enabled is not numeric: cannot apply range filter",
+		},
+		"error, non-numeric mapping": {
+			filters: []FilterPredicate{pred("kernel_version", "$gt", 5.0)},
+			err: "attribute inventory/kernel_version is not numeric: " +
+				"cannot apply range filter",
+		},
+		"error, device id": {
+			filters: []FilterPredicate{{
+				Scope:     "identity",
+				Attribute: "id",
+				Type:      "$gt",
+				Value:     5.0,
+			}},
+			err: "attribute identity/id is not numeric: cannot apply range filter",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := CheckRangeFilters(fieldTypes, tc.filters)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				assert.ErrorIs(t, err, ErrRangeFilterType)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
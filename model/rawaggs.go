@@ -0,0 +1,161 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// RawAggsMaxDepth is the max nesting depth of the raw aggregations
+	RawAggsMaxDepth = 5
+	// RawAggsMaxCount is the max number of raw aggregations, incl.
+	// the sub-aggregations
+	RawAggsMaxCount = 20
+	// RawAggsMaxSize is the max size (e.g. number of terms buckets)
+	// any raw aggregation can request
+	RawAggsMaxSize = 1000
+)
+
+var (
+	ErrInvalidRawAggs = errors.New("invalid aggregations")
+
+	// rawAggsForbiddenTypes are the aggregation types which would escape
+	// the tenant filter: the global aggregation ignores the query, and the
+	// significant terms compare with a background of the whole index
+	rawAggsForbiddenTypes = map[string]bool{
+		"global":            true,
+		"significant_terms": true,
+		"significant_text":  true,
+	}
+)
+
+// RawAggsParams is a raw ES aggregations object, run over the devices
+// of the tenant; the scripts are rejected unless AllowScripts is set
+type RawAggsParams struct {
+	TenantID     string                 `json:"-"`
+	Aggs         map[string]interface{} `json:"aggs"`
+	AllowScripts bool                   `json:"-"`
+}
+
+func (p RawAggsParams) Validate() error {
+	if len(p.Aggs) == 0 {
+		return errors.Wrap(ErrInvalidRawAggs, "aggs: cannot be blank")
+	}
+	count := 0
+	return p.validateAggs(p.Aggs, "aggs", 1, &count)
+}
+
+// validateAggs validates the aggregations object aggs at path, nested
+// at depth; count is the number of aggregations seen so far
+func (p RawAggsParams) validateAggs(
+	aggs map[string]interface{},
+	path string,
+	depth int,
+	count *int,
+) error {
+	if depth > RawAggsMaxDepth {
+		return errors.Wrapf(ErrInvalidRawAggs,
+			"%s: nested deeper than %d levels", path, RawAggsMaxDepth)
+	}
+	for name, v := range aggs {
+		aggPath := path + "." + name
+		*count++
+		if *count > RawAggsMaxCount {
+			return errors.Wrapf(ErrInvalidRawAggs,
+				"more than %d aggregations", RawAggsMaxCount)
+		}
+		agg, ok := v.(map[string]interface{})
+		if !ok {
+			return errors.Wrapf(ErrInvalidRawAggs, "%s: not an object", aggPath)
+		}
+		for key, body := range agg {
+			switch key {
+			case "aggs", "aggregations":
+				subAggs, ok := body.(map[string]interface{})
+				if !ok {
+					return errors.Wrapf(ErrInvalidRawAggs,
+						"%s.%s: not an object", aggPath, key)
+				}
+				err := p.validateAggs(subAggs, aggPath+"."+key, depth+1, count)
+				if err != nil {
+					return err
+				}
+			case "meta":
+			default:
+				if rawAggsForbiddenTypes[key] {
+					return errors.Wrapf(ErrInvalidRawAggs,
+						"%s: the %s aggregation is not allowed",
+						aggPath, key)
+				}
+				if err := p.validateAggBody(body, aggPath+"."+key); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// validateAggBody checks the parameters of an aggregation for the scripts
+// and the sizes beyond RawAggsMaxSize
+func (p RawAggsParams) validateAggBody(body interface{}, path string) error {
+	switch v := body.(type) {
+	case map[string]interface{}:
+		for key, param := range v {
+			paramPath := path + "." + key
+			isScript := key == "script" || strings.HasSuffix(key, "_script")
+			if isScript && !p.AllowScripts {
+				return errors.Wrapf(ErrInvalidRawAggs,
+					"%s: scripts are not allowed", paramPath)
+			}
+			if key == "size" || key == "shard_size" {
+				if size, ok := param.(float64); ok && size > RawAggsMaxSize {
+					return errors.Wrapf(ErrInvalidRawAggs,
+						"%s: must be no greater than %d",
+						paramPath, RawAggsMaxSize)
+				}
+			}
+			if err := p.validateAggBody(param, paramPath); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, elem := range v {
+			if err := p.validateAggBody(elem, path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// BuildRawAggsQuery builds the query running the raw aggregations over
+// the tenant's devices, without returning any hit
+func BuildRawAggsQuery(params RawAggsParams) Query {
+	query := NewQuery()
+	if params.TenantID != "" {
+		query = query.Must(M{
+			"term": M{
+				"tenantID": params.TenantID,
+			},
+		})
+	}
+	return query.WithPage(1, 0).With(M{
+		"aggs": params.Aggs,
+	})
+}
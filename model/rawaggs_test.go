@@ -0,0 +1,146 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRawAggsParamsValidate(t *testing.T) {
+	testCases := map[string]struct {
+		aggs         string
+		allowScripts bool
+
+		err string
+	}{
+		"ok": {
+			aggs: `{
+				"os": {
+					"terms": {"field": "inventory_os_str", "size": 10},
+					"aggs": {
+						"avg_mem": {"avg": {"field": "inventory_mem_total_kB_num"}}
+					},
+					"meta": {"label": "OS"}
+				}
+			}`,
+		},
+		"ok, scripts allowed": {
+			aggs: `{
+				"mem": {"sum": {"script": {"source": "doc['inventory_mem_total_kB_num'].value"}}}
+			}`,
+			allowScripts: true,
+		},
+		"error, empty": {
+			aggs: `{}`,
+			err:  "aggs: cannot be blank: invalid aggregations",
+		},
+		"error, script": {
+			aggs: `{
+				"os": {
+					"terms": {"field": "inventory_os_str"},
+					"aggs": {
+						"mem": {"sum": {"script": {"source": "1"}}}
+					}
+				}
+			}`,
+			err: "aggs.os.aggs.mem.sum.script: scripts are not allowed: invalid aggregations",
+		},
+		"error, scripted metric": {
+			aggs: `{"m": {"scripted_metric": {"map_script": "state.n++"}}}`,
+			err: "aggs.m.scripted_metric.map_script: scripts are not allowed: " +
+				"invalid aggregations",
+		},
+		"error, global": {
+			aggs: `{"all": {"global": {}}}`,
+			err:  "aggs.all: the global aggregation is not allowed: invalid aggregations",
+		},
+		"error, too large": {
+			aggs: `{"os": {"terms": {"field": "inventory_os_str", "size": 100000}}}`,
+			err:  "aggs.os.terms.size: must be no greater than 1000: invalid aggregations",
+		},
+		"error, too deep": {
+			aggs: nestedAggs(RawAggsMaxDepth + 1),
+			err: "aggs.a.aggs.a.aggs.a.aggs.a.aggs.a.aggs: nested deeper than 5 levels: " +
+				"invalid aggregations",
+		},
+		"error, too many": {
+			aggs: `{` + manyAggs(RawAggsMaxCount+1) + `}`,
+			err:  "more than 20 aggregations: invalid aggregations",
+		},
+		"error, not an object": {
+			aggs: `{"os": "terms"}`,
+			err:  "aggs.os: not an object: invalid aggregations",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			params := RawAggsParams{AllowScripts: tc.allowScripts}
+			if err := json.Unmarshal([]byte(tc.aggs), &params.Aggs); err != nil {
+				t.Fatal(err)
+			}
+
+			err := params.Validate()
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				assert.Equal(t, ErrInvalidRawAggs, errors.Cause(err))
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+// manyAggs returns n sibling aggregations
+func manyAggs(n int) string {
+	aggs := make([]string, n)
+	for i := range aggs {
+		aggs[i] = `"agg` + strconv.Itoa(i) + `": {"max": {"field": "f"}}`
+	}
+	return strings.Join(aggs, ", ")
+}
+
+// nestedAggs returns an aggregation nested depth levels deep
+func nestedAggs(depth int) string {
+	aggs := `{"a": {"max": {"field": "f"}}}`
+	for i := 1; i < depth; i++ {
+		aggs = `{"a": {"terms": {"field": "f"}, "aggs": ` + aggs + `}}`
+	}
+	return aggs
+}
+
+func TestBuildRawAggsQuery(t *testing.T) {
+	query := BuildRawAggsQuery(RawAggsParams{
+		TenantID: "tenant1",
+		Aggs: map[string]interface{}{
+			"os": map[string]interface{}{
+				"terms": map[string]interface{}{"field": "inventory_os_str"},
+			},
+		},
+	})
+
+	b, _ := json.Marshal(query)
+	assert.JSONEq(t, `{
+		"query": {"bool": {"must": [{"term": {"tenantID": "tenant1"}}]}},
+		"from": 0,
+		"size": 0,
+		"aggs": {"os": {"terms": {"field": "inventory_os_str"}}}
+	}`, string(b))
+}
@@ -0,0 +1,79 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"reflect"
+	gosort "sort"
+)
+
+// AttributeChange is an attribute changed by a device update; the old value
+// is nil for the added attributes, and the new one for the removed ones
+type AttributeChange struct {
+	Scope    string      `json:"scope"`
+	Name     string      `json:"name"`
+	OldValue interface{} `json:"old_value"`
+	NewValue interface{} `json:"new_value"`
+}
+
+// ReindexResult is the outcome of the synchronous reindex of a device
+type ReindexResult struct {
+	DeviceID string `json:"device_id"`
+	// Created is set on the device's first index, all its attributes are new
+	Created bool `json:"created"`
+	// Deleted is set if the device was removed from the index, as the
+	// source service doesn't know it anymore
+	Deleted bool `json:"deleted"`
+	// Changes are the attributes added, removed or updated by the reindex
+	Changes []AttributeChange `json:"changes"`
+}
+
+// DiffDevices returns the attributes changed from the old to the new
+// device, sorted by scope and name; either device may be nil, i.e. not
+// indexed yet or removed
+func DiffDevices(old, dev *Device) []AttributeChange {
+	oldValues := attributeValues(old)
+	newValues := attributeValues(dev)
+
+	ret := []AttributeChange{}
+	for k, val := range newValues {
+		oldVal, ok := oldValues[k]
+		if ok && reflect.DeepEqual(oldVal, val) {
+			continue
+		}
+		ret = append(ret, AttributeChange{
+			Scope:    k.scope,
+			Name:     k.name,
+			OldValue: oldVal,
+			NewValue: val,
+		})
+	}
+	for k, oldVal := range oldValues {
+		if _, ok := newValues[k]; !ok {
+			ret = append(ret, AttributeChange{
+				Scope:    k.scope,
+				Name:     k.name,
+				OldValue: oldVal,
+			})
+		}
+	}
+	gosort.Slice(ret, func(i, j int) bool {
+		if ret[i].Scope != ret[j].Scope {
+			return ret[i].Scope < ret[j].Scope
+		}
+		return ret[i].Name < ret[j].Name
+	})
+	return ret
+}
@@ -0,0 +1,107 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffDevices(t *testing.T) {
+	newDevice := func(attrs ...*InventoryAttribute) *Device {
+		dev := NewDevice("dev1").SetTenantID("tenant1")
+		for _, a := range attrs {
+			_ = dev.AppendAttr(a)
+		}
+		return dev
+	}
+	attr := func(scope, name string) *InventoryAttribute {
+		return NewInventoryAttribute(scope).SetName(name)
+	}
+
+	testCases := map[string]struct {
+		old *Device
+		dev *Device
+
+		changes []AttributeChange
+	}{
+		"update": {
+			old: newDevice(
+				attr(AttrScopeIdentity, "mac").SetString("00:11"),
+				attr(AttrScopeInventory, "os").SetString("linux"),
+				attr(AttrScopeInventory, "mem").SetNumeric(1024),
+				attr(AttrScopeInventory, "kernel").SetString("5.10"),
+			),
+			dev: newDevice(
+				attr(AttrScopeIdentity, "mac").SetString("00:11"),
+				attr(AttrScopeInventory, "os").SetString("linux"),
+				attr(AttrScopeInventory, "mem").SetNumeric(2048),
+				attr(AttrScopeInventory, "bootloader").SetString("u-boot"),
+			),
+
+			changes: []AttributeChange{{
+				Scope:    AttrScopeInventory,
+				Name:     "bootloader",
+				NewValue: []string{"u-boot"},
+			}, {
+				Scope:    AttrScopeInventory,
+				Name:     "kernel",
+				OldValue: []string{"5.10"},
+			}, {
+				Scope:    AttrScopeInventory,
+				Name:     "mem",
+				OldValue: []float64{1024},
+				NewValue: []float64{2048},
+			}},
+		},
+		"no changes": {
+			old: newDevice(attr(AttrScopeInventory, "os").SetString("linux")),
+			dev: newDevice(attr(AttrScopeInventory, "os").SetString("linux")),
+
+			changes: []AttributeChange{},
+		},
+		"first index": {
+			dev: newDevice(
+				attr(AttrScopeInventory, "os").SetString("linux"),
+				attr(AttrScopeIdentity, "mac").SetString("00:11"),
+			),
+
+			changes: []AttributeChange{{
+				Scope:    AttrScopeIdentity,
+				Name:     "mac",
+				NewValue: []string{"00:11"},
+			}, {
+				Scope:    AttrScopeInventory,
+				Name:     "os",
+				NewValue: []string{"linux"},
+			}},
+		},
+		"removed": {
+			old: newDevice(attr(AttrScopeInventory, "os").SetString("linux")),
+
+			changes: []AttributeChange{{
+				Scope:    AttrScopeInventory,
+				Name:     "os",
+				OldValue: []string{"linux"},
+			}},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.changes, DiffDevices(tc.old, tc.dev))
+		})
+	}
+}
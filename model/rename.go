@@ -0,0 +1,104 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pkg/errors"
+)
+
+// renameAttributeScript moves the values of the old attribute to the new one,
+// for each type; if the new attribute is already set, e.g. reported by an
+// upgraded device, its value is kept and the old one is dropped. Once run,
+// the documents have no old attribute left, so that reruns are no-ops.
+const renameAttributeScript = `
+for (String t : params.types) {
+	String from = params.from + '_' + t;
+	String to = params.to + '_' + t;
+	if (ctx._source.containsKey(from)) {
+		if (!ctx._source.containsKey(to)) {
+			ctx._source[to] = ctx._source[from];
+		}
+		ctx._source.remove(from);
+	}
+}`
+
+// RenameAttributeParams selects the attribute renamed in the tenant's
+// devices, e.g. after the inventory renamed it
+type RenameAttributeParams struct {
+	TenantID string
+	Scope    string
+	From     string
+	To       string
+}
+
+func (p RenameAttributeParams) Validate() error {
+	err := validation.ValidateStruct(&p,
+		validation.Field(&p.TenantID, validation.Required),
+		validation.Field(&p.Scope, validation.Required),
+		validation.Field(&p.From, validation.Required),
+		validation.Field(&p.To, validation.Required))
+	if err != nil {
+		return err
+	}
+	if !IsValidScope(p.Scope) {
+		return errors.Errorf("scope: unknown scope %q", p.Scope)
+	}
+	if p.From == p.To {
+		return errors.New("to: must differ from the attribute renamed")
+	}
+	return nil
+}
+
+// BuildRenameAttributeQuery builds the update_by_query body moving the
+// values of the attribute to its new name, for all the attribute types,
+// in the tenant's devices which still have the old attribute
+func BuildRenameAttributeQuery(params RenameAttributeParams) M {
+	types := []Type{TypeStr, TypeNum, TypeBool}
+
+	suffixes := make([]string, 0, len(types))
+	exists := make([]M, 0, len(types))
+	for _, typ := range types {
+		suffixes = append(suffixes, attrSuffixes[typ])
+		exists = append(exists, M{
+			"exists": M{
+				"field": ToAttr(params.Scope, params.From, typ),
+			},
+		})
+	}
+
+	return M{
+		"query": M{
+			"bool": M{
+				"must": []M{{
+					"term": M{
+						"tenantID": params.TenantID,
+					},
+				}},
+				"should":               exists,
+				"minimum_should_match": 1,
+			},
+		},
+		"script": M{
+			"lang":   "painless",
+			"source": renameAttributeScript,
+			"params": M{
+				"from":  params.Scope + "_" + Dedot(params.From),
+				"to":    params.Scope + "_" + Dedot(params.To),
+				"types": suffixes,
+			},
+		},
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenameAttributeParamsValidate(t *testing.T) {
+	testCases := map[string]struct {
+		params RenameAttributeParams
+
+		err string
+	}{
+		"ok": {
+			params: RenameAttributeParams{
+				TenantID: "tenant1",
+				Scope:    "inventory",
+				From:     "ipv4",
+				To:       "ip4",
+			},
+		},
+		"error, missing tenant": {
+			params: RenameAttributeParams{
+				Scope: "inventory",
+				From:  "ipv4",
+				To:    "ip4",
+			},
+			err: "TenantID: cannot be blank.",
+		},
+		"error, unknown scope": {
+			params: RenameAttributeParams{
+				TenantID: "tenant1",
+				Scope:    "foo",
+				From:     "ipv4",
+				To:       "ip4",
+			},
+			err: `scope: unknown scope "foo"`,
+		},
+		"error, same name": {
+			params: RenameAttributeParams{
+				TenantID: "tenant1",
+				Scope:    "inventory",
+				From:     "ipv4",
+				To:       "ipv4",
+			},
+			err: "to: must differ from the attribute renamed",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := tc.params.Validate()
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestBuildRenameAttributeQuery(t *testing.T) {
+	query := BuildRenameAttributeQuery(RenameAttributeParams{
+		TenantID: "tenant1",
+		Scope:    "inventory",
+		From:     "ipv4",
+		To:       "ip4",
+	})
+
+	var body map[string]interface{}
+	b, _ := json.Marshal(query)
+	_ = json.Unmarshal(b, &body)
+
+	// only the devices with the old attribute are updated, so that a rerun
+	// after a complete rename matches no devices
+	assert.Equal(t, map[string]interface{}{
+		"bool": map[string]interface{}{
+			"must": []interface{}{
+				map[string]interface{}{
+					"term": map[string]interface{}{"tenantID": "tenant1"},
+				},
+			},
+			"should": []interface{}{
+				map[string]interface{}{
+					"exists": map[string]interface{}{"field": "inventory_ipv4_str"},
+				},
+				map[string]interface{}{
+					"exists": map[string]interface{}{"field": "inventory_ipv4_num"},
+				},
+				map[string]interface{}{
+					"exists": map[string]interface{}{"field": "inventory_ipv4_bool"},
+				},
+			},
+			"minimum_should_match": float64(1),
+		},
+	}, body["query"])
+
+	script := body["script"].(map[string]interface{})
+	assert.Equal(t, "painless", script["lang"])
+	assert.Equal(t, map[string]interface{}{
+		"from":  "inventory_ipv4",
+		"to":    "inventory_ip4",
+		"types": []interface{}{"str", "num", "bool"},
+	}, script["params"])
+
+	// the old attribute is removed, and the new one never overwritten
+	source := script["source"].(string)
+	assert.Contains(t, source, "ctx._source.remove(from)")
+	assert.Contains(t, source, "if (!ctx._source.containsKey(to))")
+}
@@ -0,0 +1,37 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+// SampleParams returns the matching devices in a pseudo-random order,
+// e.g. to sample a page of them; the order is reproducible for the same
+// seed, as long as the devices don't change
+type SampleParams struct {
+	Seed int64 `json:"seed"`
+}
+
+// sample scores the devices randomly, replacing the scores of the filters
+type sample struct {
+	params SampleParams
+}
+
+func NewSample(params SampleParams) *sample {
+	return &sample{
+		params: params,
+	}
+}
+
+func (s *sample) AddTo(q Query) Query {
+	return q.WithRandomScore(s.params.Seed)
+}
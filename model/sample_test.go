@@ -0,0 +1,90 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildQuerySample(t *testing.T) {
+	q, err := BuildQuery(SearchParams{
+		Filters: []FilterPredicate{{
+			Scope:     "inventory",
+			Attribute: "os",
+			Type:      "$eq",
+			Value:     "linux",
+		}},
+		Sample:  &SampleParams{Seed: 42},
+		Page:    1,
+		PerPage: 5,
+	})
+	assert.NoError(t, err)
+
+	b, err := json.Marshal(q)
+	assert.NoError(t, err)
+	var body map[string]interface{}
+	_ = json.Unmarshal(b, &body)
+
+	assert.Equal(t, map[string]interface{}{
+		"function_score": map[string]interface{}{
+			"query": map[string]interface{}{
+				"bool": map[string]interface{}{
+					"must": []interface{}{
+						map[string]interface{}{
+							"term": map[string]interface{}{
+								"inventory_os_str": "linux",
+							},
+						},
+					},
+				},
+			},
+			"random_score": map[string]interface{}{
+				"seed":  float64(42),
+				"field": "id",
+			},
+			"boost_mode": "replace",
+		},
+	}, body["query"])
+	assert.Equal(t, float64(5), body["size"])
+	assert.NotContains(t, body, "sort")
+
+	// no sample, no function_score
+	q, err = BuildQuery(SearchParams{})
+	assert.NoError(t, err)
+	b, _ = json.Marshal(q)
+	body = nil
+	_ = json.Unmarshal(b, &body)
+	assert.Contains(t, body["query"], "bool")
+}
+
+func TestSearchParamsValidateSample(t *testing.T) {
+	err := SearchParams{
+		Sample: &SampleParams{Seed: 42},
+	}.Validate()
+	assert.NoError(t, err)
+
+	err = SearchParams{
+		Sample: &SampleParams{Seed: 42},
+		Sort: []SortCriteria{{
+			Scope:     "inventory",
+			Attribute: "os",
+			Order:     "asc",
+		}},
+	}.Validate()
+	assert.EqualError(t, err, "sample: can't sort the sampled devices")
+}
@@ -0,0 +1,73 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidScanCursor is returned for a malformed scan cursor
+var ErrInvalidScanCursor = errors.New("invalid scan cursor")
+
+// ScanParams are the search params of a device scan; the scan returns
+// the devices page by page, sorted by id after the user-defined sort
+type ScanParams struct {
+	SearchParams
+	// Cursor resumes the scan after the page it was returned with;
+	// empty starts a new scan
+	Cursor string `json:"cursor,omitempty"`
+}
+
+// ScanPage is a page of a device scan
+type ScanPage struct {
+	Devices []InvDevice `json:"devices"`
+	// Cursor resumes the scan after this page; it's omitted
+	// from the last page
+	Cursor string `json:"cursor,omitempty"`
+}
+
+// ScanCursor is the position of a device scan: the ES point in time,
+// i.e. the snapshot of the index the scan pages through, and the
+// sort values of the last device returned
+type ScanCursor struct {
+	TenantID    string        `json:"tid,omitempty"`
+	PITID       string        `json:"pit"`
+	SearchAfter []interface{} `json:"after"`
+}
+
+// Encode returns the cursor as an opaque, URL-safe string
+func (c *ScanCursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeScanCursor parses a cursor returned by ScanCursor.Encode
+func DecodeScanCursor(cursor string) (*ScanCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidScanCursor
+	}
+	var c ScanCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, ErrInvalidScanCursor
+	}
+	if c.PITID == "" || len(c.SearchAfter) == 0 {
+		return nil, ErrInvalidScanCursor
+	}
+	return &c, nil
+}
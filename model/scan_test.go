@@ -0,0 +1,48 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScanCursor(t *testing.T) {
+	cursor := &ScanCursor{
+		TenantID:    "tenant1",
+		PITID:       "46ToAwMDaWR5BXV1aWQy",
+		SearchAfter: []interface{}{"rpi4", "dev2"},
+	}
+
+	encoded := cursor.Encode()
+	decoded, err := DecodeScanCursor(encoded)
+	assert.NoError(t, err)
+	assert.Equal(t, cursor, decoded)
+
+	testCases := map[string]string{
+		"not base64":      "not a cursor!",
+		"not json":        base64.RawURLEncoding.EncodeToString([]byte("{")),
+		"no pit":          base64.RawURLEncoding.EncodeToString([]byte(`{"after":["dev1"]}`)),
+		"no search_after": base64.RawURLEncoding.EncodeToString([]byte(`{"pit":"pit1"}`)),
+	}
+	for name, encoded := range testCases {
+		t.Run(name, func(t *testing.T) {
+			_, err := DecodeScanCursor(encoded)
+			assert.Equal(t, ErrInvalidScanCursor, err)
+		})
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+// Task is the status of an asynchronous ES task,
+// e.g. a tenant-wide reindex started with wait_for_completion=false
+type Task struct {
+	ID        string `json:"id"`
+	TenantID  string `json:"tenant_id,omitempty"`
+	Completed bool   `json:"completed"`
+	Cancelled bool   `json:"cancelled"`
+	Total     int    `json:"total"`
+	Updated   int    `json:"updated"`
+	Created   int    `json:"created"`
+	Deleted   int    `json:"deleted"`
+	Conflicts int    `json:"version_conflicts"`
+}
+
+// Progress returns the fraction [0, 1] of documents processed so far
+func (t *Task) Progress() float64 {
+	if t.Completed {
+		return 1
+	}
+	if t.Total <= 0 {
+		return 0
+	}
+	done := t.Updated + t.Created + t.Deleted + t.Conflicts
+	return float64(done) / float64(t.Total)
+}
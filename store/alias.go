@@ -0,0 +1,109 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"context"
+
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/elastic/go-elasticsearch/v7/esutil"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+
+	"github.com/mendersoftware/reporting/model"
+)
+
+// WithDevicesReadAlias sets the alias the searches run against; while the
+// devices are migrated to a new index, the alias can span both the current
+// and the previous index, whereas the writes keep going to the devices
+// index (the write alias). Empty searches the devices index.
+func WithDevicesReadAlias(alias string) StoreOption {
+	return func(s *store) {
+		s.devicesReadAlias = alias
+	}
+}
+
+// GetDevicesReadIndex returns the index (or alias) the searches of the
+// tenant tid run against
+func (s *store) GetDevicesReadIndex(tid string) string {
+	// the read alias spans the shared indices only
+	if _, ok := s.tenantIndices[tid]; ok {
+		return s.GetDevicesIndex(tid)
+	}
+	if s.devicesReadAlias != "" {
+		return s.devicesReadAlias
+	}
+	return s.GetDevicesIndex(tid)
+}
+
+// UpdateReadAlias adds the indices add to the read alias and removes the
+// indices remove from it, in a single atomic update, so that the searches
+// never see the alias in between
+func (s *store) UpdateReadAlias(ctx context.Context, add, remove []string) error {
+	if s.devicesReadAlias == "" {
+		return errors.New("the devices read alias is not configured")
+	}
+	l := log.FromContext(ctx)
+	l.Infof("update the read alias %s: add %v, remove %v",
+		s.devicesReadAlias, add, remove)
+
+	actions := make([]model.M, 0, len(add)+len(remove))
+	for _, index := range add {
+		actions = append(actions, model.M{
+			"add": model.M{
+				"index": index,
+				"alias": s.devicesReadAlias,
+			},
+		})
+	}
+	for _, index := range remove {
+		actions = append(actions, model.M{
+			"remove": model.M{
+				"index": index,
+				"alias": s.devicesReadAlias,
+			},
+		})
+	}
+	if len(actions) == 0 {
+		return nil
+	}
+
+	req := esapi.IndicesUpdateAliasesRequest{
+		Body: esutil.NewJSONReader(model.M{
+			"actions": actions,
+		}),
+	}
+
+	// adding and removing the same indices again is harmless
+	res, err := req.Do(withIdempotent(ctx), s.client)
+	if err != nil {
+		return errors.Wrap(err, "failed to update the read alias")
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return errors.Errorf("failed to update the read alias, code %d", res.StatusCode)
+	}
+	return nil
+}
+
+// migrateReadAlias adds the devices index to the read alias, if configured
+func (s *store) migrateReadAlias(ctx context.Context, indexName string) error {
+	if s.devicesReadAlias == "" {
+		return nil
+	}
+	return s.UpdateReadAlias(ctx, []string{indexName}, nil)
+}
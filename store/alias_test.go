@@ -0,0 +1,155 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/reporting/model"
+)
+
+func TestSearchReadAlias(t *testing.T) {
+	// during a migration the read alias resolves to both the previous
+	// and the current index, the hits come from either
+	s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/devices-read/_search", r.URL.Path)
+		_, _ = w.Write([]byte(`{"hits":{"total":{"value":2},"hits":[
+			{"_index":"devices-v1","_id":"dev1","_source":{"id":"dev1"}},
+			{"_index":"devices-v2","_id":"dev2","_source":{"id":"dev2"}}
+		]}}`))
+	}, WithDevicesReadAlias("devices-read"))
+
+	res, err := s.Search(testIdentityCtx(), model.NewQuery())
+	assert.NoError(t, err)
+	hits := res["hits"].(map[string]interface{})["hits"].([]interface{})
+	if assert.Len(t, hits, 2) {
+		assert.Equal(t, "devices-v1", hits[0].(map[string]interface{})["_index"])
+		assert.Equal(t, "devices-v2", hits[1].(map[string]interface{})["_index"])
+	}
+
+	assert.Equal(t, "devices-read", s.GetDevicesReadIndex("tenant1"))
+	assert.Equal(t, "devices", s.GetDevicesIndex("tenant1"))
+}
+
+func TestSearchNoReadAlias(t *testing.T) {
+	s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/devices/_search", r.URL.Path)
+		_, _ = w.Write([]byte(`{"hits":{"total":{"value":0},"hits":[]}}`))
+	})
+
+	_, err := s.Search(testIdentityCtx(), model.NewQuery())
+	assert.NoError(t, err)
+	assert.Equal(t, "devices", s.GetDevicesReadIndex("tenant1"))
+}
+
+func TestOpenPointInTimeReadAlias(t *testing.T) {
+	s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/devices-read/_pit", r.URL.Path)
+		_, _ = w.Write([]byte(`{"id":"pit1"}`))
+	}, WithDevicesReadAlias("devices-read"))
+
+	pitID, err := s.OpenPointInTime(context.Background(), "tenant1", time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, "pit1", pitID)
+}
+
+func TestUpdateReadAlias(t *testing.T) {
+	testCases := map[string]struct {
+		alias  string
+		add    []string
+		remove []string
+		code   int
+
+		actions []interface{}
+		err     string
+	}{
+		"ok, add and remove": {
+			alias:  "devices-read",
+			add:    []string{"devices-v2"},
+			remove: []string{"devices-v1"},
+			code:   http.StatusOK,
+			actions: []interface{}{
+				map[string]interface{}{"add": map[string]interface{}{
+					"index": "devices-v2", "alias": "devices-read",
+				}},
+				map[string]interface{}{"remove": map[string]interface{}{
+					"index": "devices-v1", "alias": "devices-read",
+				}},
+			},
+		},
+		"ok, nothing to do": {
+			alias: "devices-read",
+		},
+		"error, no alias": {
+			add: []string{"devices-v2"},
+			err: "the devices read alias is not configured",
+		},
+		"error, es": {
+			alias: "devices-read",
+			add:   []string{"devices-v2"},
+			code:  http.StatusNotFound,
+			actions: []interface{}{
+				map[string]interface{}{"add": map[string]interface{}{
+					"index": "devices-v2", "alias": "devices-read",
+				}},
+			},
+			err: "failed to update the read alias, code 404",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var actions []interface{}
+			s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, http.MethodPost, r.Method)
+				assert.Equal(t, "/_aliases", r.URL.Path)
+				var body map[string]interface{}
+				_ = json.NewDecoder(r.Body).Decode(&body)
+				actions, _ = body["actions"].([]interface{})
+				w.WriteHeader(tc.code)
+				_, _ = w.Write([]byte(`{"acknowledged":true}`))
+			}, WithDevicesReadAlias(tc.alias))
+
+			err := s.UpdateReadAlias(context.Background(), tc.add, tc.remove)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.actions, actions)
+		})
+	}
+}
+
+func TestDevicesIndexTemplateReadAlias(t *testing.T) {
+	s := &store{devicesReadAlias: "devices-read"}
+
+	template, err := s.devicesIndexTemplate("devices")
+	assert.NoError(t, err)
+	tmpl := template["template"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{
+		"devices-read": map[string]interface{}{},
+	}, tmpl["aliases"])
+
+	s = &store{}
+	template, err = s.devicesIndexTemplate("devices")
+	assert.NoError(t, err)
+	assert.NotContains(t, template["template"], "aliases")
+}
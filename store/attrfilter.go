@@ -0,0 +1,135 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"context"
+	"expvar"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+
+	"github.com/mendersoftware/reporting/model"
+)
+
+var (
+	// metricDroppedAttributes counts the attributes dropped at index time
+	// by the attribute allowlist/denylist
+	metricDroppedAttributes = expvar.NewInt("elasticsearch_dropped_attributes")
+)
+
+// WithAttributeFilter sets the attributes allowlist and denylist applied at
+// index time; the patterns are "scope/name", lowercase, with path.Match
+// wildcards (e.g. "inventory/*", "*/mac"); if the allowlist is not empty
+// only the matching attributes are indexed, and the attributes matching
+// the denylist are never indexed
+func WithAttributeFilter(allow, deny []string) StoreOption {
+	return func(s *store) {
+		s.attrsAllow = allow
+		s.attrsDeny = deny
+	}
+}
+
+// validateAttributeFilter checks the allowlist and denylist patterns
+func validateAttributeFilter(patterns ...[]string) error {
+	for _, list := range patterns {
+		for _, p := range list {
+			if _, err := path.Match(p, ""); err != nil {
+				return errors.Wrapf(err, "invalid attribute pattern %q", p)
+			}
+		}
+	}
+	return nil
+}
+
+// matchAttribute reports whether the attribute scope/name matches any of
+// the patterns
+func matchAttribute(patterns []string, scope, name string) bool {
+	key := strings.ToLower(scope + "/" + name)
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// indexAttribute reports whether the attribute is to be indexed
+func (s *store) indexAttribute(scope, name string) bool {
+	if len(s.attrsAllow) > 0 && !matchAttribute(s.attrsAllow, scope, name) {
+		return false
+	}
+	return !matchAttribute(s.attrsDeny, scope, name)
+}
+
+// filterAttributes returns the device without the attributes excluded by
+// the allowlist/denylist; the device is copied if modified
+func (s *store) filterAttributes(ctx context.Context, dev *model.Device) *model.Device {
+	if (len(s.attrsAllow) == 0 && len(s.attrsDeny) == 0) || dev == nil {
+		return dev
+	}
+	l := log.FromContext(ctx)
+
+	dropped := 0
+	filter := func(attrs model.DeviceInventory) model.DeviceInventory {
+		var ret model.DeviceInventory
+		for i, a := range attrs {
+			if s.indexAttribute(a.Scope, a.Name) {
+				if ret != nil {
+					ret = append(ret, a)
+				}
+				continue
+			}
+			if ret == nil {
+				ret = make(model.DeviceInventory, i, len(attrs))
+				copy(ret, attrs[:i])
+			}
+			l.Debugf("device %s: attribute %s/%s not indexed",
+				dev.GetID(), a.Scope, a.Name)
+			dropped++
+		}
+		if ret == nil {
+			return attrs
+		}
+		return ret
+	}
+
+	ret := *dev
+	ret.IdentityAttributes = filter(dev.IdentityAttributes)
+	ret.InventoryAttributes = filter(dev.InventoryAttributes)
+	ret.MonitorAttributes = filter(dev.MonitorAttributes)
+	ret.SystemAttributes = filter(dev.SystemAttributes)
+	ret.TagsAttributes = filter(dev.TagsAttributes)
+	if dropped == 0 {
+		return dev
+	}
+	metricDroppedAttributes.Add(int64(dropped))
+
+	return &ret
+}
+
+// prepareDevice applies the index-time transformations to the device:
+// the attribute filter, the attribute types and the truncation of the values;
+// returns ErrAttributeTypeConflict if the device is not to be indexed
+func (s *store) prepareDevice(ctx context.Context, dev *model.Device) (*model.Device, error) {
+	dev, err := s.enforceAttributeTypes(ctx, s.filterAttributes(ctx, dev))
+	if err != nil {
+		return nil, err
+	}
+	return s.truncateValues(dev), nil
+}
@@ -0,0 +1,134 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/reporting/model"
+)
+
+func newFilterTestDevice() *model.Device {
+	dev := model.NewDevice("dev1").SetTenantID("tenant1")
+	_ = dev.AppendAttr(model.NewInventoryAttribute(model.AttrScopeIdentity).
+		SetName("mac").
+		SetString("00:11:22:33:44:55"))
+	_ = dev.AppendAttr(model.NewInventoryAttribute(model.AttrScopeInventory).
+		SetName("os").
+		SetString("linux"))
+	_ = dev.AppendAttr(model.NewInventoryAttribute(model.AttrScopeInventory).
+		SetName("software_packages").
+		SetString("openssl"))
+	return dev
+}
+
+func TestFilterAttributes(t *testing.T) {
+	testCases := map[string]struct {
+		allow []string
+		deny  []string
+
+		identity  []string
+		inventory []string
+	}{
+		"ok, no filter": {
+			identity:  []string{"mac"},
+			inventory: []string{"os", "software_packages"},
+		},
+		"ok, allowlist": {
+			allow: []string{"inventory/*"},
+
+			inventory: []string{"os", "software_packages"},
+		},
+		"ok, denylist": {
+			deny: []string{"inventory/software_*"},
+
+			identity:  []string{"mac"},
+			inventory: []string{"os"},
+		},
+		"ok, allowlist and denylist": {
+			allow: []string{"identity/*", "inventory/*"},
+			deny:  []string{"*/mac", "inventory/os"},
+
+			inventory: []string{"software_packages"},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			s := &store{}
+			WithAttributeFilter(tc.allow, tc.deny)(s)
+
+			dev := newFilterTestDevice()
+			out := s.filterAttributes(context.Background(), dev)
+
+			names := func(attrs model.DeviceInventory) []string {
+				var ret []string
+				for _, a := range attrs {
+					ret = append(ret, a.Name)
+				}
+				return ret
+			}
+			assert.Equal(t, tc.identity, names(out.IdentityAttributes))
+			assert.Equal(t, tc.inventory, names(out.InventoryAttributes))
+
+			// the input device is left untouched
+			assert.Equal(t, newFilterTestDevice(), dev)
+		})
+	}
+}
+
+func TestAttributeFilterInvalidPattern(t *testing.T) {
+	_, err := NewStore(WithAttributeFilter(nil, []string{"inventory/[a-"}))
+	assert.Error(t, err)
+}
+
+func TestAttributeFilterIndexDevice(t *testing.T) {
+	var doc string
+	s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/devices/_doc/dev1", r.URL.Path)
+		body, _ := ioutil.ReadAll(r.Body)
+		doc = string(body)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"result": "created"}`))
+	}, WithAttributeFilter(nil, []string{"inventory/software_*"}))
+	dropped := metricDroppedAttributes.Value()
+
+	err := s.IndexDevice(context.Background(), newFilterTestDevice())
+	assert.NoError(t, err)
+	assert.Contains(t, doc, `"inventory_os_str":["linux"]`)
+	assert.NotContains(t, doc, "inventory_software_packages")
+	assert.Equal(t, dropped+1, metricDroppedAttributes.Value())
+}
+
+func TestAttributeFilterBulkIndexDevices(t *testing.T) {
+	var bulk string
+	s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/_bulk", r.URL.Path)
+		body, _ := ioutil.ReadAll(r.Body)
+		bulk = string(body)
+		_, _ = w.Write([]byte(`{"errors": false, "items": []}`))
+	}, WithAttributeFilter([]string{"identity/*"}, nil))
+
+	err := s.BulkIndexDevices(context.Background(),
+		[]*model.Device{newFilterTestDevice()})
+	assert.NoError(t, err)
+	assert.Contains(t, bulk, `"identity_mac_str":["00:11:22:33:44:55"]`)
+	assert.NotContains(t, bulk, "inventory_os")
+	assert.NotContains(t, bulk, "inventory_software_packages")
+}
@@ -0,0 +1,236 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"context"
+	"expvar"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+
+	"github.com/mendersoftware/reporting/model"
+)
+
+// attributeTypeConflictType is the error type of the bulk items
+// dropped because of ErrAttributeTypeConflict
+const attributeTypeConflictType = "attribute_type_conflict"
+
+// attribute type policies, i.e. what to do with a device reporting a
+// declared attribute with a value of another type
+const (
+	// AttributeTypePolicyCoerce converts the values to the declared type,
+	// the device is dropped if they can't be converted
+	AttributeTypePolicyCoerce = "coerce"
+	// AttributeTypePolicyDrop drops the device
+	AttributeTypePolicyDrop = "drop"
+)
+
+var (
+	ErrInvalidAttributeTypePolicy = errors.New("invalid attribute type policy")
+	ErrInvalidAttributeType       = errors.New("invalid attribute type")
+	ErrAttributeTypeConflict      = errors.New("attribute type conflict")
+
+	// metricCoercedAttributes counts the attributes converted at index
+	// time to their declared type
+	metricCoercedAttributes = expvar.NewInt("elasticsearch_coerced_attributes")
+	// metricAttributeTypeDropped counts the device documents not indexed
+	// because of an attribute of the wrong type
+	metricAttributeTypeDropped = expvar.NewInt("elasticsearch_attribute_type_dropped_docs")
+)
+
+// WithAttributeTypes declares the types ("str", "num" or "bool") of the
+// attributes, keyed by "scope/name", lowercase, and the policy applied at
+// index time to the devices reporting them with another type; a device
+// with a conflicting type would otherwise be rejected by the mapping,
+// failing the whole bulk request
+func WithAttributeTypes(types map[string]string, policy string) StoreOption {
+	return func(s *store) {
+		s.attrTypes = types
+		s.attrTypePolicy = policy
+	}
+}
+
+// validateAttributeTypes checks the declared types and the policy
+func validateAttributeTypes(types map[string]string, policy string) error {
+	for key, typ := range types {
+		if !model.IsValidTypeSuffix(typ) {
+			return errors.Wrapf(ErrInvalidAttributeType, "%s: %s", key, typ)
+		}
+	}
+	switch policy {
+	case AttributeTypePolicyCoerce, AttributeTypePolicyDrop:
+		return nil
+	}
+	return errors.Wrap(ErrInvalidAttributeTypePolicy, policy)
+}
+
+// enforceAttributeTypes returns the device with the declared attributes
+// converted to their type, or ErrAttributeTypeConflict if the device is
+// to be dropped; the device is copied if modified
+func (s *store) enforceAttributeTypes(
+	ctx context.Context,
+	dev *model.Device,
+) (*model.Device, error) {
+	if len(s.attrTypes) == 0 || dev == nil {
+		return dev, nil
+	}
+	l := log.FromContext(ctx)
+
+	var err error
+	coerced := 0
+	enforce := func(attrs model.DeviceInventory) model.DeviceInventory {
+		var ret model.DeviceInventory
+		for i, a := range attrs {
+			if err != nil {
+				break
+			}
+			typ, ok := s.attrTypes[strings.ToLower(a.Scope+"/"+a.Name)]
+			if !ok || hasAttributeType(a, typ) {
+				continue
+			}
+			var attr *model.InventoryAttribute
+			if s.attrTypePolicy == AttributeTypePolicyCoerce {
+				attr = coerceAttribute(a, typ)
+			}
+			if attr == nil {
+				err = errors.Wrapf(ErrAttributeTypeConflict,
+					"%s/%s is not of type %s", a.Scope, a.Name, typ)
+				break
+			}
+			if ret == nil {
+				ret = make(model.DeviceInventory, len(attrs))
+				copy(ret, attrs)
+			}
+			ret[i] = attr
+			l.Debugf("device %s: attribute %s/%s converted to %s",
+				dev.GetID(), a.Scope, a.Name, typ)
+			coerced++
+		}
+		if ret == nil {
+			return attrs
+		}
+		return ret
+	}
+
+	ret := *dev
+	ret.IdentityAttributes = enforce(dev.IdentityAttributes)
+	ret.InventoryAttributes = enforce(dev.InventoryAttributes)
+	ret.MonitorAttributes = enforce(dev.MonitorAttributes)
+	ret.SystemAttributes = enforce(dev.SystemAttributes)
+	ret.TagsAttributes = enforce(dev.TagsAttributes)
+	if err != nil {
+		l.Warnf("device %s not indexed: %s", dev.GetID(), err)
+		metricAttributeTypeDropped.Add(1)
+		return nil, err
+	}
+	if coerced == 0 {
+		return dev, nil
+	}
+	metricCoercedAttributes.Add(int64(coerced))
+
+	return &ret, nil
+}
+
+// hasAttributeType reports whether the attribute's values are of type typ
+func hasAttributeType(a *model.InventoryAttribute, typ string) bool {
+	switch typ {
+	case "str":
+		return a.IsStr()
+	case "num":
+		return a.IsNum()
+	case "bool":
+		return a.IsBool()
+	}
+	return true
+}
+
+// coerceAttribute returns a copy of the attribute with the values converted
+// to type typ, or nil if they can't be converted: the numbers and booleans
+// are formatted as strings, the strings are parsed, and the booleans are
+// 1 or 0 as numbers and vice versa
+func coerceAttribute(a *model.InventoryAttribute, typ string) *model.InventoryAttribute {
+	ret := &model.InventoryAttribute{
+		Scope: a.Scope,
+		Name:  a.Name,
+	}
+	switch typ {
+	case "str":
+		values := make([]string, 0, len(a.Numeric)+len(a.Boolean))
+		for _, v := range a.Numeric {
+			values = append(values, strconv.FormatFloat(v, 'f', -1, 64))
+		}
+		for _, v := range a.Boolean {
+			values = append(values, strconv.FormatBool(v))
+		}
+		ret.SetStrings(values)
+	case "num":
+		values := make([]float64, 0, len(a.String)+len(a.Boolean))
+		for _, v := range a.String {
+			f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil {
+				return nil
+			}
+			values = append(values, f)
+		}
+		for _, v := range a.Boolean {
+			if v {
+				values = append(values, 1)
+			} else {
+				values = append(values, 0)
+			}
+		}
+		ret.SetNumerics(values)
+	case "bool":
+		values := make([]bool, 0, len(a.String)+len(a.Numeric))
+		for _, v := range a.String {
+			b, err := strconv.ParseBool(strings.TrimSpace(v))
+			if err != nil {
+				return nil
+			}
+			values = append(values, b)
+		}
+		for _, v := range a.Numeric {
+			if v != 0 && v != 1 {
+				return nil
+			}
+			values = append(values, v == 1)
+		}
+		ret.SetBooleans(values)
+	default:
+		return nil
+	}
+	return ret
+}
+
+// droppedBulkItemResult returns the bulk response item of a device dropped
+// because of err, in the format of the items rejected by ES
+func droppedBulkItemResult(item BulkItem, err error) map[string]interface{} {
+	return map[string]interface{}{
+		item.Action.Type: map[string]interface{}{
+			"_id":    item.Action.Desc.ID,
+			"_index": item.Action.Desc.Index,
+			"status": float64(http.StatusBadRequest),
+			"error": map[string]interface{}{
+				"type":   attributeTypeConflictType,
+				"reason": err.Error(),
+			},
+		},
+	}
+}
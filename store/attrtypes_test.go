@@ -0,0 +1,250 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/reporting/model"
+)
+
+func newTypesTestDevice(id string, cpus interface{}) *model.Device {
+	dev := model.NewDevice(id).SetTenantID("tenant1")
+	_ = dev.AppendAttr(model.NewInventoryAttribute(model.AttrScopeInventory).
+		SetName("os").
+		SetString("linux"))
+	_ = dev.AppendAttr(model.NewInventoryAttribute(model.AttrScopeInventory).
+		SetName("cpu_count").
+		SetVal(cpus))
+	return dev
+}
+
+func TestEnforceAttributeTypes(t *testing.T) {
+	testCases := map[string]struct {
+		types  map[string]string
+		policy string
+		cpus   interface{}
+
+		out *model.InventoryAttribute
+		err error
+	}{
+		"ok, declared type": {
+			types:  map[string]string{"inventory/cpu_count": "num"},
+			policy: AttributeTypePolicyDrop,
+			cpus:   float64(4),
+
+			out: &model.InventoryAttribute{Scope: "inventory", Name: "cpu_count",
+				Numeric: []float64{4}},
+		},
+		"ok, not declared": {
+			types:  map[string]string{"inventory/os": "str"},
+			policy: AttributeTypePolicyDrop,
+			cpus:   "4",
+
+			out: &model.InventoryAttribute{Scope: "inventory", Name: "cpu_count",
+				String: []string{"4"}},
+		},
+		"ok, coerced to num": {
+			types:  map[string]string{"inventory/cpu_count": "num"},
+			policy: AttributeTypePolicyCoerce,
+			cpus:   " 4",
+
+			out: &model.InventoryAttribute{Scope: "inventory", Name: "cpu_count",
+				Numeric: []float64{4}},
+		},
+		"ok, coerced to str": {
+			types:  map[string]string{"inventory/cpu_count": "str"},
+			policy: AttributeTypePolicyCoerce,
+			cpus:   float64(2.5),
+
+			out: &model.InventoryAttribute{Scope: "inventory", Name: "cpu_count",
+				String: []string{"2.5"}},
+		},
+		"ok, coerced to bool": {
+			types:  map[string]string{"inventory/cpu_count": "bool"},
+			policy: AttributeTypePolicyCoerce,
+			cpus:   float64(1),
+
+			out: &model.InventoryAttribute{Scope: "inventory", Name: "cpu_count",
+				Boolean: []bool{true}},
+		},
+		"dropped, can't be coerced": {
+			types:  map[string]string{"inventory/cpu_count": "num"},
+			policy: AttributeTypePolicyCoerce,
+			cpus:   "four",
+
+			err: ErrAttributeTypeConflict,
+		},
+		"dropped, drop policy": {
+			types:  map[string]string{"inventory/cpu_count": "num"},
+			policy: AttributeTypePolicyDrop,
+			cpus:   "4",
+
+			err: ErrAttributeTypeConflict,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			s := &store{}
+			WithAttributeTypes(tc.types, tc.policy)(s)
+
+			dev := newTypesTestDevice("dev1", tc.cpus)
+			out, err := s.enforceAttributeTypes(context.Background(), dev)
+			if tc.err != nil {
+				assert.Equal(t, tc.err, errors.Cause(err))
+				assert.Nil(t, out)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.out, out.InventoryAttributes[1])
+
+			// the input device is left untouched
+			assert.Equal(t, newTypesTestDevice("dev1", tc.cpus), dev)
+		})
+	}
+}
+
+func TestAttributeTypesInvalid(t *testing.T) {
+	_, err := NewStore(WithAttributeTypes(
+		map[string]string{"inventory/cpu_count": "int"}, AttributeTypePolicyCoerce))
+	assert.Equal(t, ErrInvalidAttributeType, errors.Cause(err))
+
+	_, err = NewStore(WithAttributeTypes(
+		map[string]string{"inventory/cpu_count": "num"}, "ignore"))
+	assert.Equal(t, ErrInvalidAttributeTypePolicy, errors.Cause(err))
+}
+
+func TestAttributeTypesBulkRaw(t *testing.T) {
+	newItem := func(id string, cpus interface{}) BulkItem {
+		return BulkItem{
+			Action: &BulkAction{
+				Type: "index",
+				Desc: &BulkActionDesc{ID: id, Tenant: "tenant1"},
+			},
+			Doc: newTypesTestDevice(id, cpus),
+		}
+	}
+	items := []BulkItem{
+		newItem("dev1", float64(4)),
+		newItem("dev2", "8"),
+	}
+
+	testCases := map[string]struct {
+		policy string
+
+		bulk    []string
+		coerced int64
+		dropped []string
+	}{
+		"coerce": {
+			policy: AttributeTypePolicyCoerce,
+
+			bulk: []string{
+				`"inventory_cpu_count_num":[4]`,
+				`"inventory_cpu_count_num":[8]`,
+			},
+			coerced: 1,
+		},
+		"drop": {
+			policy: AttributeTypePolicyDrop,
+
+			bulk:    []string{`"inventory_cpu_count_num":[4]`},
+			dropped: []string{"dev2"},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var bulk string
+			s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "/_bulk", r.URL.Path)
+				body, _ := ioutil.ReadAll(r.Body)
+				bulk = string(body)
+				_, _ = w.Write([]byte(`{"errors": false, "items": []}`))
+			}, WithAttributeTypes(
+				map[string]string{"inventory/cpu_count": "num"}, tc.policy))
+			coerced := metricCoercedAttributes.Value()
+			dropped := metricAttributeTypeDropped.Value()
+
+			res, err := s.BulkRaw(context.Background(), items)
+			assert.NoError(t, err)
+			for _, doc := range tc.bulk {
+				assert.Contains(t, bulk, doc)
+			}
+			assert.NotContains(t, bulk, "inventory_cpu_count_str")
+
+			// the dropped devices are reported as failed items
+			assert.Equal(t, len(tc.dropped) > 0, res["errors"])
+			resItems, _ := res["items"].([]interface{})
+			var ids []string
+			for _, resItem := range resItems {
+				id, itemErr := bulkItemResult(resItem)
+				assert.Equal(t, attributeTypeConflictType, itemErr["type"])
+				ids = append(ids, id)
+			}
+			assert.Equal(t, tc.dropped, ids)
+
+			assert.Equal(t, coerced+tc.coerced, metricCoercedAttributes.Value())
+			assert.Equal(t, dropped+int64(len(tc.dropped)), metricAttributeTypeDropped.Value())
+		})
+	}
+}
+
+func TestAttributeTypesIndexDevice(t *testing.T) {
+	s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the device is not to be indexed")
+	}, WithAttributeTypes(
+		map[string]string{"inventory/cpu_count": "num"}, AttributeTypePolicyDrop))
+
+	err := s.IndexDevice(context.Background(), newTypesTestDevice("dev1", "4"))
+	assert.Equal(t, ErrAttributeTypeConflict, errors.Cause(err))
+}
+
+func TestAttributeTypesBulkIndexDevices(t *testing.T) {
+	var bulk string
+	s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/_bulk", r.URL.Path)
+		body, _ := ioutil.ReadAll(r.Body)
+		bulk = string(body)
+		_, _ = w.Write([]byte(`{"errors": false, "items": []}`))
+	}, WithAttributeTypes(
+		map[string]string{"inventory/cpu_count": "num"}, AttributeTypePolicyDrop))
+
+	// the others are indexed, the dropped ones reported
+	err := s.BulkIndexDevices(context.Background(), []*model.Device{
+		newTypesTestDevice("dev1", float64(4)),
+		newTypesTestDevice("dev2", "four"),
+	})
+	assert.Equal(t, ErrAttributeTypeConflict, errors.Cause(err))
+	assert.Contains(t, err.Error(), "devices not indexed: dev2")
+	assert.Contains(t, bulk, `"_id":"dev1"`)
+	assert.NotContains(t, bulk, `"_id":"dev2"`)
+
+	// no request without any device to index
+	s = newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("no device is to be indexed")
+	}, WithAttributeTypes(
+		map[string]string{"inventory/cpu_count": "num"}, AttributeTypePolicyDrop))
+
+	err = s.BulkIndexDevices(context.Background(), []*model.Device{
+		newTypesTestDevice("dev2", "four"),
+	})
+	assert.Equal(t, ErrAttributeTypeConflict, errors.Cause(err))
+}
@@ -0,0 +1,184 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"context"
+	"expvar"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	BreakerThresholdDefault = 5
+	BreakerCooldownDefault  = 10 * time.Second
+)
+
+var (
+	ErrStoreUnavailable = errors.New("store unavailable")
+
+	// metricBreakerState is the state of the circuit breaker:
+	// "closed", "open" or "half-open"
+	metricBreakerState = expvar.NewString("elasticsearch_circuit_breaker_state")
+	// metricBreakerOpened counts the times the circuit breaker opened
+	metricBreakerOpened = expvar.NewInt("elasticsearch_circuit_breaker_opened")
+)
+
+func init() {
+	metricBreakerState.Set(breakerClosed.String())
+}
+
+// BreakerPolicy configures the circuit breaker of the requests to
+// Elasticsearch: after Threshold consecutive failures the breaker opens
+// and the requests fail fast with ErrStoreUnavailable; after Cooldown,
+// a single request is let through to probe whether ES recovered
+type BreakerPolicy struct {
+	Threshold int
+	Cooldown  time.Duration
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// breakerTransport wraps the HTTP transport of the ES client, short-circuiting
+// the requests while ES is failing; it's the outermost transport, so that a
+// request retried by the retry transport counts as a single failure
+type breakerTransport struct {
+	next   http.RoundTripper
+	policy BreakerPolicy
+	now    func() time.Time
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func newBreakerTransport(next http.RoundTripper, policy BreakerPolicy) *breakerTransport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &breakerTransport{
+		next:   next,
+		policy: policy,
+		now:    time.Now,
+	}
+}
+
+func (t *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.policy.Threshold <= 0 {
+		return t.next.RoundTrip(req)
+	}
+
+	if !t.allow() {
+		return nil, ErrStoreUnavailable
+	}
+
+	res, err := t.next.RoundTrip(req)
+	t.record(req.Context(), res, err)
+	return res, err
+}
+
+// allow reports whether a request can be sent in the current state
+func (t *breakerTransport) allow() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch t.state {
+	case breakerOpen:
+		if t.now().Sub(t.openedAt) < t.policy.Cooldown {
+			return false
+		}
+		t.setState(breakerHalfOpen)
+		fallthrough
+	case breakerHalfOpen:
+		// only one probe at a time
+		if t.probing {
+			return false
+		}
+		t.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// record updates the state with the outcome of a request; transport errors
+// and 5xx responses are failures, while the requests cancelled by the caller
+// say nothing about the health of ES and are ignored
+func (t *breakerTransport) record(ctx context.Context, res *http.Response, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	cancelled := err != nil && ctx.Err() != nil
+	failed := err != nil || res.StatusCode >= http.StatusInternalServerError
+
+	switch t.state {
+	case breakerHalfOpen:
+		t.probing = false
+		if cancelled {
+			return
+		}
+		if failed {
+			t.open()
+		} else {
+			t.failures = 0
+			t.setState(breakerClosed)
+		}
+	case breakerClosed:
+		if cancelled {
+			return
+		}
+		if !failed {
+			t.failures = 0
+			return
+		}
+		t.failures++
+		if t.failures >= t.policy.Threshold {
+			t.open()
+		}
+	}
+}
+
+func (t *breakerTransport) open() {
+	t.openedAt = t.now()
+	t.setState(breakerOpen)
+	metricBreakerOpened.Add(1)
+}
+
+func (t *breakerTransport) setState(state breakerState) {
+	t.state = state
+	metricBreakerState.Set(state.String())
+}
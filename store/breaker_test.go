@@ -0,0 +1,141 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestBreakerTransport(t *testing.T) {
+	var (
+		status int
+		err    error
+		calls  int
+	)
+	next := roundTripFunc(func(*http.Request) (*http.Response, error) {
+		calls++
+		if err != nil {
+			return nil, err
+		}
+		return &http.Response{StatusCode: status}, nil
+	})
+
+	now := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+	tr := newBreakerTransport(next, BreakerPolicy{
+		Threshold: 2,
+		Cooldown:  time.Minute,
+	})
+	tr.now = func() time.Time { return now }
+
+	send := func() error {
+		req, _ := http.NewRequest(http.MethodGet, "http://localhost:9200/", nil)
+		_, e := tr.RoundTrip(req)
+		return e
+	}
+
+	// closed: failures below the threshold, interleaved with
+	// a success, don't open the breaker
+	status = http.StatusServiceUnavailable
+	assert.NoError(t, send())
+	status = http.StatusOK
+	assert.NoError(t, send())
+	status = http.StatusServiceUnavailable
+	assert.NoError(t, send())
+	assert.Equal(t, breakerClosed, tr.state)
+
+	// closed -> open: the second consecutive failure
+	err = errors.New("connection refused")
+	assert.Error(t, send())
+	assert.Equal(t, breakerOpen, tr.state)
+	assert.Equal(t, "open", metricBreakerState.Value())
+
+	// open: short-circuited, ES is not called
+	calls = 0
+	assert.Equal(t, ErrStoreUnavailable, send())
+	assert.Equal(t, 0, calls)
+
+	// open -> half-open -> open: the probe fails
+	now = now.Add(time.Minute)
+	assert.Error(t, send())
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, breakerOpen, tr.state)
+	assert.Equal(t, ErrStoreUnavailable, send())
+
+	// open -> half-open: only one probe at a time
+	now = now.Add(time.Minute)
+	assert.True(t, tr.allow())
+	assert.Equal(t, breakerHalfOpen, tr.state)
+	assert.Equal(t, ErrStoreUnavailable, send())
+	tr.probing = false
+
+	// half-open -> closed: the probe succeeds
+	err = nil
+	status = http.StatusOK
+	assert.NoError(t, send())
+	assert.Equal(t, breakerClosed, tr.state)
+	assert.Equal(t, "closed", metricBreakerState.Value())
+}
+
+func TestBreakerTransportCancelled(t *testing.T) {
+	next := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return nil, req.Context().Err()
+	})
+	tr := newBreakerTransport(next, BreakerPolicy{
+		Threshold: 1,
+		Cooldown:  time.Minute,
+	})
+
+	// requests cancelled by the caller are not failures
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, _ := http.NewRequestWithContext(ctx,
+		http.MethodGet, "http://localhost:9200/", nil)
+	_, err := tr.RoundTrip(req)
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, breakerClosed, tr.state)
+}
+
+func TestBreakerStore(t *testing.T) {
+	var calls int32
+	s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	},
+		WithRetryPolicy(RetryPolicy{}),
+		WithBreakerPolicy(BreakerPolicy{Threshold: 2, Cooldown: time.Minute}),
+	)
+
+	for i := 0; i < 2; i++ {
+		_, err := s.ReindexTenant(context.Background(), "tenant1")
+		assert.Error(t, err)
+	}
+
+	_, err := s.ReindexTenant(context.Background(), "tenant1")
+	assert.Equal(t, ErrStoreUnavailable, errors.Cause(err))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
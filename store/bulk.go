@@ -0,0 +1,183 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v7/esutil"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+
+	"github.com/mendersoftware/reporting/model"
+)
+
+// BulkIndexerConfig configures the concurrent bulk path, see BulkIndex;
+// the zero values keep the esutil.BulkIndexer defaults
+type BulkIndexerConfig struct {
+	// NumWorkers is the num of concurrent bulk requests (per routing key)
+	NumWorkers int
+	// FlushBytes is the size of the bulk request bodies
+	FlushBytes int
+	// FlushInterval is the max time the items are buffered before flushing
+	FlushInterval time.Duration
+}
+
+// WithBulkIndexer configures the concurrent bulk path
+func WithBulkIndexer(conf BulkIndexerConfig) StoreOption {
+	return func(s *store) {
+		s.bulkIndexer = conf
+	}
+}
+
+// BulkItemError is the failure of a single item of a bulk request
+type BulkItemError struct {
+	Item   BulkItem
+	Status int
+	Type   string
+	Reason string
+}
+
+func (e BulkItemError) Error() string {
+	return fmt.Sprintf("bulk %s of %s failed, code %d: %s: %s",
+		e.Item.Action.Type, e.Item.Action.Desc.ID, e.Status, e.Type, e.Reason)
+}
+
+// BulkIndex sends the items through esutil.BulkIndexer, i.e. in concurrent
+// bulk requests flushed by size or time; returns once all the items are
+// processed, along with the failed items.
+// The bulk indexer supports neither per-item routing nor the conditional
+// writes: the items are routed by separate indexers for each routing key,
+// and the sequence numbers are ignored, i.e. the last write wins.
+func (s *store) BulkIndex(ctx context.Context, items []BulkItem) ([]BulkItemError, error) {
+	l := log.FromContext(ctx)
+
+	var (
+		mu       sync.Mutex
+		failed   []BulkItemError
+		flushErr error
+	)
+	onError := func(_ context.Context, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if flushErr == nil {
+			flushErr = err
+		}
+	}
+
+	var err error
+	indexers := map[string]esutil.BulkIndexer{}
+	for i := range items {
+		item := items[i]
+		desc := s.tenantBulkAction(item.Action).Desc
+
+		indexer, ok := indexers[desc.Routing]
+		if !ok {
+			indexer, err = esutil.NewBulkIndexer(esutil.BulkIndexerConfig{
+				Client:        s.client,
+				NumWorkers:    s.bulkIndexer.NumWorkers,
+				FlushBytes:    s.bulkIndexer.FlushBytes,
+				FlushInterval: s.bulkIndexer.FlushInterval,
+				Routing:       desc.Routing,
+				Pipeline:      s.ingestPipeline.Name,
+				OnError:       onError,
+			})
+			if err != nil {
+				break
+			}
+			indexers[desc.Routing] = indexer
+		}
+
+		var body io.Reader
+		if item.Doc != nil {
+			doc := item.Doc
+			if dev, ok := doc.(*model.Device); ok {
+				prepared, prepareErr := s.prepareDevice(ctx, dev)
+				if prepareErr != nil {
+					mu.Lock()
+					failed = append(failed, BulkItemError{
+						Item:   item,
+						Status: http.StatusBadRequest,
+						Type:   attributeTypeConflictType,
+						Reason: prepareErr.Error(),
+					})
+					mu.Unlock()
+					continue
+				}
+				doc = prepared
+			}
+			b, marshalErr := json.Marshal(doc)
+			if marshalErr != nil {
+				err = marshalErr
+				break
+			}
+			body = bytes.NewReader(b)
+		}
+
+		err = indexer.Add(ctx, esutil.BulkIndexerItem{
+			Index:      desc.Index,
+			Action:     item.Action.Type,
+			DocumentID: desc.ID,
+			Body:       body,
+			OnFailure: func(
+				_ context.Context,
+				_ esutil.BulkIndexerItem,
+				res esutil.BulkIndexerResponseItem,
+				err error,
+			) {
+				itemErr := BulkItemError{
+					Item:   item,
+					Status: res.Status,
+					Type:   res.Error.Type,
+					Reason: res.Error.Reason,
+				}
+				if err != nil {
+					itemErr.Reason = err.Error()
+				}
+				mu.Lock()
+				failed = append(failed, itemErr)
+				mu.Unlock()
+			},
+		})
+		if err != nil {
+			break
+		}
+	}
+
+	// closing flushes the remaining items, and waits for the workers
+	for _, indexer := range indexers {
+		if closeErr := indexer.Close(ctx); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	if err == nil {
+		err = flushErr
+	}
+	if err != nil {
+		return failed, errors.Wrap(err, "failed to bulk index")
+	}
+
+	l.Debugf("bulk indexed %d items, %d failed", len(items), len(failed))
+
+	return failed, nil
+}
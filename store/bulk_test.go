@@ -0,0 +1,248 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/reporting/model"
+)
+
+func TestBulkIndex(t *testing.T) {
+	newItem := func(tenant, id string) BulkItem {
+		dev := model.NewDevice(id).SetTenantID(tenant)
+		return BulkItem{
+			Action: &BulkAction{
+				Type: "index",
+				Desc: &BulkActionDesc{
+					ID:      id,
+					Index:   "devices",
+					Routing: tenant,
+					Tenant:  tenant,
+				},
+			},
+			Doc: dev,
+		}
+	}
+	items := []BulkItem{
+		newItem("tenant1", "dev1"),
+		newItem("tenant1", "dev2"),
+		newItem("tenant2", "dev3"),
+	}
+
+	var (
+		mu      sync.Mutex
+		indexed = map[string][]string{}
+	)
+	s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/_bulk", r.URL.Path)
+		routing := r.URL.Query().Get("routing")
+
+		// action and document lines
+		var resItems []string
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var action map[string]map[string]string
+			_ = json.Unmarshal(scanner.Bytes(), &action)
+			id := action["index"]["_id"]
+			assert.Equal(t, "devices", action["index"]["_index"])
+			scanner.Scan()
+
+			mu.Lock()
+			indexed[routing] = append(indexed[routing], id)
+			mu.Unlock()
+			if id == "dev2" {
+				resItems = append(resItems, `{"index": {"_id": "dev2",
+					"status": 409, "error": {
+						"type": "version_conflict_engine_exception",
+						"reason": "version conflict"
+					}}}`)
+			} else {
+				resItems = append(resItems,
+					`{"index": {"_id": "`+id+`", "status": 200}}`)
+			}
+		}
+		_, _ = w.Write([]byte(`{"errors": true, "items": [` +
+			strings.Join(resItems, ",") + `]}`))
+	}, WithBulkIndexer(BulkIndexerConfig{NumWorkers: 2}))
+
+	failed, err := s.BulkIndex(context.Background(), items)
+	assert.NoError(t, err)
+
+	// the items are routed by tenant
+	for _, ids := range indexed {
+		sort.Strings(ids)
+	}
+	assert.Equal(t, map[string][]string{
+		"tenant1": {"dev1", "dev2"},
+		"tenant2": {"dev3"},
+	}, indexed)
+
+	if assert.Len(t, failed, 1) {
+		assert.Equal(t, items[1], failed[0].Item)
+		assert.Equal(t, http.StatusConflict, failed[0].Status)
+		assert.Equal(t, "version_conflict_engine_exception", failed[0].Type)
+		assert.Equal(t, "version conflict", failed[0].Reason)
+	}
+}
+
+func TestBulkIndexRequestError(t *testing.T) {
+	s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error": {"type": "parse_exception"}}`))
+	}, WithRetryPolicy(RetryPolicy{}))
+
+	_, err := s.BulkIndex(context.Background(), []BulkItem{{
+		Action: &BulkAction{
+			Type: "index",
+			Desc: &BulkActionDesc{ID: "dev1", Index: "devices", Routing: "tenant1"},
+		},
+		Doc: model.NewDevice("dev1"),
+	}})
+	assert.Error(t, err)
+}
+
+func TestBulkIndexMarshalError(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		indexed []string
+	)
+	s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var action map[string]map[string]string
+			_ = json.Unmarshal(scanner.Bytes(), &action)
+			mu.Lock()
+			indexed = append(indexed, action["index"]["_id"])
+			mu.Unlock()
+			scanner.Scan()
+		}
+		_, _ = w.Write([]byte(`{"errors": false, "items": []}`))
+	}, WithBulkIndexer(BulkIndexerConfig{NumWorkers: 1}))
+
+	_, err := s.BulkIndex(context.Background(), []BulkItem{{
+		Action: &BulkAction{
+			Type: "index",
+			Desc: &BulkActionDesc{ID: "dev1", Index: "devices", Routing: "tenant1"},
+		},
+		Doc: model.NewDevice("dev1"),
+	}, {
+		Action: &BulkAction{
+			Type: "index",
+			Desc: &BulkActionDesc{ID: "dev2", Index: "devices", Routing: "tenant1"},
+		},
+		Doc: map[string]interface{}{"unsupported": make(chan int)},
+	}})
+	assert.Error(t, err)
+
+	// the items queued before the error are still flushed
+	assert.Equal(t, []string{"dev1"}, indexed)
+}
+
+func TestBulkRawTenants(t *testing.T) {
+	newItem := func(tenant, id string) BulkItem {
+		return BulkItem{
+			Action: &BulkAction{
+				Type: "index",
+				Desc: &BulkActionDesc{ID: id, Tenant: tenant},
+			},
+			Doc: model.NewDevice(id).SetTenantID(tenant),
+		}
+	}
+	items := []BulkItem{
+		newItem("tenant1", "dev1"),
+		newItem("tenant2", "dev2"),
+		// the tenant's routing wins over an explicit one
+		newItem("tenant3", "dev3"),
+		// an explicit index is kept
+		{
+			Action: &BulkAction{
+				Type: "create",
+				Desc: &BulkActionDesc{Index: "history", Tenant: "tenant1"},
+			},
+			Doc: map[string]string{"device_id": "dev1"},
+		},
+	}
+	items[2].Action.Desc.Routing = "tenant1"
+
+	actions := map[string]map[string]string{}
+	s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/_bulk", r.URL.Path)
+		assert.Empty(t, r.URL.Query().Get("routing"))
+
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var action map[string]map[string]string
+			_ = json.Unmarshal(scanner.Bytes(), &action)
+			for typ, desc := range action {
+				actions[typ+"/"+desc["_id"]] = desc
+			}
+			scanner.Scan()
+		}
+		_, _ = w.Write([]byte(`{"errors": false, "items": []}`))
+	})
+
+	_, err := s.BulkRaw(context.Background(), items)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]map[string]string{
+		"index/dev1": {"_id": "dev1", "_index": "devices", "routing": "tenant1"},
+		"index/dev2": {"_id": "dev2", "_index": "devices", "routing": "tenant2"},
+		"index/dev3": {"_id": "dev3", "_index": "devices", "routing": "tenant3"},
+		"create/":    {"_index": "history", "routing": "tenant1"},
+	}, actions)
+
+	// the items aren't modified
+	assert.Equal(t, "", items[0].Action.Desc.Index)
+	assert.Equal(t, "tenant1", items[2].Action.Desc.Routing)
+}
+
+func TestBulkRawBody(t *testing.T) {
+	items := []BulkItem{{
+		Action: &BulkAction{
+			Type: "index",
+			Desc: &BulkActionDesc{ID: "dev1", Index: "devices", Routing: "tenant1"},
+		},
+		Doc: map[string]string{"id": "dev1"},
+	}, {
+		Action: &BulkAction{
+			Type: "delete",
+			Desc: &BulkActionDesc{ID: "dev2", Index: "devices", Routing: "tenant1"},
+		},
+	}}
+
+	var body []byte
+	s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+		_, _ = w.Write([]byte(`{"errors": false, "items": []}`))
+	})
+
+	_, err := s.BulkRaw(context.Background(), items)
+	assert.NoError(t, err)
+	assert.Equal(t,
+		`{"index":{"_id":"dev1","_index":"devices","routing":"tenant1"}}`+"\n"+
+			`{"id":"dev1"}`+"\n"+
+			`{"delete":{"_id":"dev2","_index":"devices","routing":"tenant1"}}`+"\n",
+		string(body))
+}
@@ -0,0 +1,100 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/elastic/go-elasticsearch/v7/esutil"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/reporting/model"
+)
+
+// tenantCountsPageSize is the number of tenants per page of the composite
+// aggregation counting the devices of all the tenants
+const tenantCountsPageSize = 1000
+
+// CountTenantsDevices returns the number of indexed devices of each tenant,
+// across all the tenants; no devices are indexed before the index exists
+func (s *store) CountTenantsDevices(ctx context.Context) (map[string]int, error) {
+	counts := map[string]int{}
+	var after interface{}
+	for {
+		composite := model.M{
+			"size": tenantCountsPageSize,
+			"sources": []interface{}{
+				model.M{"tenant": model.M{"terms": model.M{"field": "tenantID"}}},
+			},
+		}
+		if after != nil {
+			composite["after"] = after
+		}
+		query := model.M{
+			"size": 0,
+			"aggs": model.M{
+				"tenants": model.M{"composite": composite},
+			},
+		}
+
+		req := esapi.SearchRequest{
+			Index: []string{s.GetDevicesReadIndex("")},
+			Body:  esutil.NewJSONReader(query),
+		}
+		res, err := req.Do(withIdempotent(ctx), s.client)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to count the devices")
+		}
+
+		var searchRes struct {
+			Aggregations struct {
+				Tenants struct {
+					AfterKey interface{} `json:"after_key"`
+					Buckets  []struct {
+						Key struct {
+							Tenant string `json:"tenant"`
+						} `json:"key"`
+						DocCount int `json:"doc_count"`
+					} `json:"buckets"`
+				} `json:"tenants"`
+			} `json:"aggregations"`
+		}
+		if res.StatusCode == http.StatusNotFound {
+			res.Body.Close()
+			return counts, nil
+		} else if res.IsError() {
+			res.Body.Close()
+			return nil, errors.Errorf(
+				"failed to count the devices, code %d", res.StatusCode)
+		}
+		err = json.NewDecoder(res.Body).Decode(&searchRes)
+		res.Body.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, "can't parse the device counts")
+		}
+
+		tenants := searchRes.Aggregations.Tenants
+		for _, b := range tenants.Buckets {
+			counts[b.Key.Tenant] = b.DocCount
+		}
+		if len(tenants.Buckets) < tenantCountsPageSize || tenants.AfterKey == nil {
+			return counts, nil
+		}
+		after = tenants.AfterKey
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountTenantsDevices(t *testing.T) {
+	// a full page of tenants, followed by the last page
+	var bodies []map[string]interface{}
+	s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/devices/_search", r.URL.Path)
+		var body map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		bodies = append(bodies, body)
+
+		if len(bodies) == 1 {
+			buckets := make([]string, tenantCountsPageSize)
+			for i := range buckets {
+				buckets[i] = fmt.Sprintf(
+					`{"key": {"tenant": "tenant%d"}, "doc_count": 1}`, i)
+			}
+			_, _ = w.Write([]byte(`{"aggregations": {"tenants": {
+				"after_key": {"tenant": "tenant999"},
+				"buckets": [` + strings.Join(buckets, ",") + `]
+			}}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"aggregations": {"tenants": {
+			"after_key": {"tenant": "tenantX"},
+			"buckets": [{"key": {"tenant": "tenantX"}, "doc_count": 42}]
+		}}}`))
+	})
+
+	counts, err := s.CountTenantsDevices(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, counts, tenantCountsPageSize+1)
+	assert.Equal(t, 1, counts["tenant0"])
+	assert.Equal(t, 42, counts["tenantX"])
+
+	// the next page starts after the last key
+	if assert.Len(t, bodies, 2) {
+		aggs := bodies[1]["aggs"].(map[string]interface{})
+		composite := aggs["tenants"].(map[string]interface{})["composite"]
+		assert.Equal(t, map[string]interface{}{"tenant": "tenant999"},
+			composite.(map[string]interface{})["after"])
+	}
+}
+
+func TestCountTenantsDevicesNoIndex(t *testing.T) {
+	s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error":{"type":"index_not_found_exception"}}`))
+	})
+
+	counts, err := s.CountTenantsDevices(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, counts)
+}
+
+func TestCountTenantsDevicesError(t *testing.T) {
+	s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":{"type":"search_phase_execution_exception"}}`))
+	})
+
+	_, err := s.CountTenantsDevices(context.Background())
+	assert.EqualError(t, err, "failed to count the devices, code 400")
+}
@@ -0,0 +1,257 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+
+	"github.com/mendersoftware/reporting/model"
+)
+
+// field limit policies, i.e. what to do with a device document which
+// would push the index over index.mapping.total_fields.limit
+const (
+	// FieldLimitPolicyReject leaves the document rejected by ES
+	FieldLimitPolicyReject = "reject"
+	// FieldLimitPolicyDrop indexes the document again,
+	// without the attributes not mapped yet
+	FieldLimitPolicyDrop = "drop"
+)
+
+var (
+	ErrInvalidFieldLimitPolicy = errors.New("invalid field limit policy")
+
+	// metricFieldLimitRejected counts the device documents rejected
+	// because of the field limit
+	metricFieldLimitRejected = expvar.NewInt("elasticsearch_field_limit_rejected_docs")
+	// metricFieldLimitDropped counts the attributes dropped from the
+	// device documents because of the field limit
+	metricFieldLimitDropped = expvar.NewInt("elasticsearch_field_limit_dropped_fields")
+)
+
+// WithFieldLimit sets index.mapping.total_fields.limit of the devices index
+// (0 keeps the ES default), and the policy applied to the devices exceeding it
+func WithFieldLimit(limit int, policy string) StoreOption {
+	return func(s *store) {
+		s.fieldLimit = limit
+		s.fieldLimitPolicy = policy
+	}
+}
+
+func validFieldLimitPolicy(policy string) bool {
+	switch policy {
+	case FieldLimitPolicyReject, FieldLimitPolicyDrop:
+		return true
+	}
+	return false
+}
+
+// isFieldLimitError detects the bulk item errors caused by the field limit
+func isFieldLimitError(itemErr map[string]interface{}) bool {
+	typ, _ := itemErr["type"].(string)
+	reason, _ := itemErr["reason"].(string)
+	return typ == "illegal_argument_exception" &&
+		strings.Contains(reason, "Limit of total fields")
+}
+
+// handleFieldLimitErrors applies the field limit policy to the items of
+// the bulk response storeRes which failed because of the field limit;
+// with the drop policy, the items are sent again without the new attributes
+// and their results replace the original ones in storeRes
+func (s *store) handleFieldLimitErrors(
+	ctx context.Context,
+	items []BulkItem,
+	storeRes map[string]interface{},
+) (map[string]interface{}, error) {
+	l := log.FromContext(ctx)
+
+	if hasErrs, _ := storeRes["errors"].(bool); !hasErrs {
+		return storeRes, nil
+	}
+	resItems, _ := storeRes["items"].([]interface{})
+
+	// the response items are matched to the request items by device id
+	// (UUIDs, unique across tenants)
+	itemsByID := make(map[string]BulkItem, len(items))
+	for _, item := range items {
+		itemsByID[item.Action.Desc.ID] = item
+	}
+
+	var failed []BulkItem
+	for _, resItem := range resItems {
+		id, itemErr := bulkItemResult(resItem)
+		item, ok := itemsByID[id]
+		if ok && itemErr != nil && isFieldLimitError(itemErr) {
+			failed = append(failed, item)
+			delete(itemsByID, id)
+		}
+	}
+	if len(failed) == 0 {
+		return storeRes, nil
+	}
+
+	if s.fieldLimitPolicy != FieldLimitPolicyDrop {
+		for _, item := range failed {
+			l.Warnf("device %s rejected, exceeding the field limit of index %s",
+				item.Action.Desc.ID, item.Action.Desc.Index)
+		}
+		metricFieldLimitRejected.Add(int64(len(failed)))
+		return storeRes, nil
+	}
+
+	mapped := map[string]map[string]bool{}
+	retries := make([]BulkItem, 0, len(failed))
+	for _, item := range failed {
+		dev, ok := item.Doc.(*model.Device)
+		if !ok {
+			return nil, errors.Errorf("can't drop the fields of a %T", item.Doc)
+		}
+
+		index := item.Action.Desc.Index
+		if _, ok := mapped[index]; !ok {
+			fields, err := s.getMappedFields(ctx, index)
+			if err != nil {
+				return nil, err
+			}
+			mapped[index] = fields
+		}
+
+		stripped, dropped := stripUnmappedAttrs(dev, mapped[index])
+		l.Warnf("dropping %d attributes of device %s, "+
+			"exceeding the field limit of index %s",
+			dropped, item.Action.Desc.ID, index)
+		metricFieldLimitDropped.Add(int64(dropped))
+
+		retries = append(retries, BulkItem{
+			Action: item.Action,
+			Doc:    stripped,
+		})
+	}
+
+	retryRes, err := s.bulk(ctx, retries)
+	if err != nil {
+		return nil, err
+	}
+	retryItems, _ := retryRes["items"].([]interface{})
+	retriedByID := make(map[string]interface{}, len(retryItems))
+	for _, resItem := range retryItems {
+		id, _ := bulkItemResult(resItem)
+		retriedByID[id] = resItem
+	}
+
+	hasErrs := false
+	for i, resItem := range resItems {
+		id, _ := bulkItemResult(resItem)
+		if retried, ok := retriedByID[id]; ok {
+			resItems[i] = retried
+		}
+		if _, itemErr := bulkItemResult(resItems[i]); itemErr != nil {
+			hasErrs = true
+		}
+	}
+	storeRes["errors"] = hasErrs
+
+	return storeRes, nil
+}
+
+// bulkItemResult returns the document id and the error, if any,
+// of a bulk response item
+func bulkItemResult(resItem interface{}) (string, map[string]interface{}) {
+	actionM, _ := resItem.(map[string]interface{})
+	for _, v := range actionM {
+		resM, _ := v.(map[string]interface{})
+		id, _ := resM["_id"].(string)
+		itemErr, _ := resM["error"].(map[string]interface{})
+		return id, itemErr
+	}
+	return "", nil
+}
+
+// getMappedFields returns the names of the fields mapped in the index
+// (or in any of the indices, if index is an alias)
+func (s *store) getMappedFields(ctx context.Context, index string) (map[string]bool, error) {
+	req := esapi.IndicesGetMappingRequest{
+		Index: []string{index},
+	}
+
+	res, err := req.Do(ctx, s.client)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get the index mapping")
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, errors.Errorf("failed to get the index mapping, code %d",
+			res.StatusCode)
+	}
+
+	var mappingRes map[string]struct {
+		Mappings struct {
+			Properties map[string]json.RawMessage `json:"properties"`
+			Runtime    map[string]json.RawMessage `json:"runtime"`
+		} `json:"mappings"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&mappingRes); err != nil {
+		return nil, errors.Wrap(err, "can't parse the index mapping")
+	}
+
+	fields := map[string]bool{}
+	for _, idx := range mappingRes {
+		for name := range idx.Mappings.Properties {
+			fields[name] = true
+		}
+		for name := range idx.Mappings.Runtime {
+			fields[name] = true
+		}
+	}
+	return fields, nil
+}
+
+// stripUnmappedAttrs returns a copy of the device without the attributes
+// which aren't mapped yet, and the number of attributes dropped
+func stripUnmappedAttrs(dev *model.Device, mapped map[string]bool) (*model.Device, int) {
+	dropped := 0
+	strip := func(attrs model.DeviceInventory) model.DeviceInventory {
+		if attrs == nil {
+			return nil
+		}
+		ret := model.DeviceInventory{}
+		for _, a := range attrs {
+			if name, _ := a.Map(); mapped[name] {
+				ret = append(ret, a)
+			} else {
+				dropped++
+			}
+		}
+		return ret
+	}
+
+	stripped := *dev
+	stripped.IdentityAttributes = strip(dev.IdentityAttributes)
+	stripped.InventoryAttributes = strip(dev.InventoryAttributes)
+	stripped.MonitorAttributes = strip(dev.MonitorAttributes)
+	stripped.SystemAttributes = strip(dev.SystemAttributes)
+	stripped.TagsAttributes = strip(dev.TagsAttributes)
+
+	return &stripped, dropped
+}
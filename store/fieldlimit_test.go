@@ -0,0 +1,148 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/reporting/model"
+)
+
+func TestFieldLimitTemplate(t *testing.T) {
+	s := &store{fieldLimit: 2000}
+	template, err := s.devicesIndexTemplate("devices")
+	assert.NoError(t, err)
+	assert.Equal(t, 2000, templateSettings(template)["index.mapping.total_fields.limit"])
+
+	// the ES default is kept
+	s = &store{}
+	template, err = s.devicesIndexTemplate("devices")
+	assert.NoError(t, err)
+	assert.NotContains(t, templateSettings(template), "index.mapping.total_fields.limit")
+}
+
+func TestFieldLimitInvalidPolicy(t *testing.T) {
+	_, err := NewStore(WithFieldLimit(1000, "ignore"))
+	assert.Equal(t, ErrInvalidFieldLimitPolicy, errors.Cause(err))
+}
+
+func TestFieldLimitPolicy(t *testing.T) {
+	const limitErr = `{
+		"type": "illegal_argument_exception",
+		"reason": "Limit of total fields [1000] has been exceeded"
+	}`
+
+	newItem := func(id string, attrs ...string) BulkItem {
+		dev := model.NewDevice(id).SetTenantID("tenant1")
+		for _, name := range attrs {
+			_ = dev.AppendAttr(model.NewInventoryAttribute(model.AttrScopeInventory).
+				SetName(name).
+				SetString("value"))
+		}
+		return BulkItem{
+			Action: &BulkAction{
+				Type: "index",
+				Desc: &BulkActionDesc{ID: id, Index: "devices", Routing: "tenant1"},
+			},
+			Doc: dev,
+		}
+	}
+	items := []BulkItem{
+		newItem("dev1", "os"),
+		newItem("dev2", "os", "new_attr"),
+	}
+
+	testCases := map[string]struct {
+		policy string
+
+		bulks    int
+		retried  string
+		errors   bool
+		rejected int64
+		dropped  int64
+	}{
+		"reject": {
+			policy: FieldLimitPolicyReject,
+
+			bulks:    1,
+			errors:   true,
+			rejected: 1,
+		},
+		"drop": {
+			policy: FieldLimitPolicyDrop,
+
+			bulks:   2,
+			retried: "dev2",
+			errors:  false,
+			dropped: 1,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var bulks []string
+			s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/_bulk":
+					body, _ := ioutil.ReadAll(r.Body)
+					bulks = append(bulks, string(body))
+					if len(bulks) == 1 {
+						_, _ = w.Write([]byte(`{"errors": true, "items": [
+							{"index": {"_id": "dev1", "status": 200}},
+							{"index": {"_id": "dev2", "status": 400,
+								"error": ` + limitErr + `}}
+						]}`))
+					} else {
+						_, _ = w.Write([]byte(`{"errors": false, "items": [
+							{"index": {"_id": "dev2", "status": 200}}
+						]}`))
+					}
+				case "/devices/_mapping":
+					_, _ = w.Write([]byte(`{"devices-000001": {"mappings": {
+						"properties": {
+							"id": {"type": "keyword"},
+							"inventory_os_str": {"type": "keyword"}
+						}
+					}}}`))
+				default:
+					t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+				}
+			}, WithFieldLimit(1000, tc.policy))
+
+			rejected := metricFieldLimitRejected.Value()
+			dropped := metricFieldLimitDropped.Value()
+
+			res, err := s.BulkRaw(context.Background(), items)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.errors, res["errors"])
+
+			if assert.Len(t, bulks, tc.bulks) && tc.retried != "" {
+				retry := bulks[1]
+				assert.Contains(t, retry, tc.retried)
+				assert.Contains(t, retry, "inventory_os_str")
+				assert.NotContains(t, retry, "inventory_new_attr_str")
+			}
+			// the original device is left untouched
+			assert.Len(t, items[1].Doc.(*model.Device).InventoryAttributes, 2)
+			assert.Equal(t, tc.rejected, metricFieldLimitRejected.Value()-rejected)
+			assert.Equal(t, tc.dropped, metricFieldLimitDropped.Value()-dropped)
+		})
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/elastic/go-elasticsearch/v7/esutil"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+
+	"github.com/mendersoftware/reporting/model"
+)
+
+var (
+	ErrHistoryDisabled = errors.New("attribute history is disabled")
+)
+
+// historyIndexTemplate is the index template of the attribute history index;
+// the values are kept as they are, but not indexed
+const historyIndexTemplate = `{
+	"index_patterns": ["%s*"],
+	"priority": 1,
+	"template": {
+		"settings": {
+			"number_of_shards": %d,
+			"number_of_replicas": %d
+		},
+		"mappings": {
+			"_routing": {
+				"required": true
+			},
+			"dynamic": "strict",
+			"properties": {
+				"tenantID": {"type": "keyword"},
+				"device_id": {"type": "keyword"},
+				"scope": {"type": "keyword"},
+				"name": {"type": "keyword"},
+				"value": {"type": "object", "enabled": false},
+				"timestamp": {"type": "date"}
+			}
+		}
+	}
+}`
+
+// WithHistoryIndexName enables the attribute history, appended to
+// the index name by the device updates
+func WithHistoryIndexName(name string) StoreOption {
+	return func(s *store) {
+		s.historyIndexName = name
+	}
+}
+
+// GetHistoryIndex returns the name of the attribute history index of
+// the tenant, or an empty string if the history is disabled; the index
+// is shared by the tenants, and routed like the devices index
+func (s *store) GetHistoryIndex(tid string) string {
+	return s.historyIndexName
+}
+
+// migrateHistoryIndex sets up the attribute history index, if enabled
+func (s *store) migrateHistoryIndex(ctx context.Context) error {
+	l := log.FromContext(ctx)
+
+	indexName := s.historyIndexName
+	if indexName == "" {
+		return nil
+	}
+
+	l.Infof("put the index template for %s", indexName)
+	template := []byte(fmt.Sprintf(historyIndexTemplate,
+		indexName, s.devicesIndexShards, s.devicesIndexReplicas))
+	req := esapi.IndicesPutIndexTemplateRequest{
+		Name: indexName,
+		Body: bytes.NewReader(template),
+	}
+	res, err := req.Do(ctx, s.client)
+	if err != nil {
+		return errors.Wrap(err, "failed to put the history index template")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return errors.New("failed to set up the history index template")
+	}
+
+	existsReq := esapi.IndicesExistsRequest{
+		Index: []string{indexName},
+	}
+	existsRes, err := existsReq.Do(ctx, s.client)
+	if err != nil {
+		return errors.Wrap(err, "failed to verify the history index")
+	}
+	defer existsRes.Body.Close()
+
+	switch existsRes.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		l.Infof("create the index %s", indexName)
+	default:
+		return errors.New("failed to verify the history index")
+	}
+
+	createReq := esapi.IndicesCreateRequest{
+		Index: indexName,
+	}
+	createRes, err := createReq.Do(ctx, s.client)
+	if err != nil {
+		return errors.Wrap(err, "failed to create the history index")
+	}
+	defer createRes.Body.Close()
+
+	if createRes.StatusCode != http.StatusOK {
+		return errors.New("failed to create the history index")
+	}
+	return nil
+}
+
+// GetAttributeHistory returns the history of a device attribute,
+// in chronological order
+func (s *store) GetAttributeHistory(
+	ctx context.Context,
+	params model.AttributeHistoryParams,
+) ([]model.AttributeHistory, error) {
+	if s.historyIndexName == "" {
+		return nil, ErrHistoryDisabled
+	}
+
+	query := model.BuildAttributeHistoryQuery(params)
+	req := esapi.SearchRequest{
+		Index:   []string{s.GetHistoryIndex(params.TenantID)},
+		Routing: []string{s.GetDevicesRoutingKey(params.TenantID)},
+		Body:    esutil.NewJSONReader(query),
+	}
+
+	res, err := req.Do(withIdempotent(ctx), s.client)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to search the attribute history")
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, errors.Errorf(
+			"failed to search the attribute history, code %d", res.StatusCode,
+		)
+	}
+
+	var searchRes struct {
+		Hits struct {
+			Hits []struct {
+				Source model.AttributeHistory `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&searchRes); err != nil {
+		return nil, errors.Wrap(err, "can't parse the attribute history")
+	}
+
+	ret := make([]model.AttributeHistory, 0, len(searchRes.Hits.Hits))
+	for _, hit := range searchRes.Hits.Hits {
+		ret = append(ret, hit.Source)
+	}
+	return ret, nil
+}
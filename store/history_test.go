@@ -0,0 +1,96 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/reporting/model"
+)
+
+func TestGetAttributeHistory(t *testing.T) {
+	from := time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)
+	params := model.AttributeHistoryParams{
+		TenantID:  "tenant1",
+		DeviceID:  "dev1",
+		Scope:     "inventory",
+		Attribute: "kernel",
+		From:      &from,
+	}
+
+	var body map[string]interface{}
+	s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/devices-history/_search", r.URL.Path)
+		assert.Equal(t, "tenant1", r.URL.Query().Get("routing"))
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		_, _ = w.Write([]byte(`{"hits": {"hits": [{
+			"_source": {
+				"tenantID": "tenant1",
+				"device_id": "dev1",
+				"scope": "inventory",
+				"name": "kernel",
+				"value": ["5.10"],
+				"timestamp": "2021-10-02T00:00:00Z"
+			}
+		}, {
+			"_source": {
+				"tenantID": "tenant1",
+				"device_id": "dev1",
+				"scope": "inventory",
+				"name": "kernel",
+				"value": null,
+				"timestamp": "2021-10-03T00:00:00Z"
+			}
+		}]}}`))
+	}, WithHistoryIndexName("devices-history"))
+
+	history, err := s.GetAttributeHistory(context.Background(), params)
+	assert.NoError(t, err)
+	assert.Equal(t, []model.AttributeHistory{{
+		TenantID:  "tenant1",
+		DeviceID:  "dev1",
+		Scope:     "inventory",
+		Name:      "kernel",
+		Value:     []interface{}{"5.10"},
+		Timestamp: time.Date(2021, 10, 2, 0, 0, 0, 0, time.UTC),
+	}, {
+		TenantID:  "tenant1",
+		DeviceID:  "dev1",
+		Scope:     "inventory",
+		Name:      "kernel",
+		Timestamp: time.Date(2021, 10, 3, 0, 0, 0, 0, time.UTC),
+	}}, history)
+
+	b, _ := json.Marshal(model.BuildAttributeHistoryQuery(params))
+	var expected map[string]interface{}
+	_ = json.Unmarshal(b, &expected)
+	assert.Equal(t, expected, body)
+}
+
+func TestGetAttributeHistoryDisabled(t *testing.T) {
+	s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+	})
+
+	_, err := s.GetAttributeHistory(context.Background(),
+		model.AttributeHistoryParams{DeviceID: "dev1"})
+	assert.Equal(t, ErrHistoryDisabled, err)
+}
@@ -0,0 +1,117 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"context"
+
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/elastic/go-elasticsearch/v7/esutil"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+
+	"github.com/mendersoftware/reporting/model"
+)
+
+// ErrInvalidILMPolicy is returned by NewStore if the ILM policy has an
+// action the devices index doesn't support
+var ErrInvalidILMPolicy = errors.New("invalid ILM policy")
+
+// ILMPolicy configures the index lifecycle management policy of the devices
+// index; an empty Name disables ILM, the empty thresholds disable the
+// corresponding actions
+type ILMPolicy struct {
+	Name string
+
+	// rollover thresholds and retention, e.g. "50gb", "30d", "90d": not
+	// supported, the devices are updated in place by ID, so they must stay
+	// in a single index which is never deleted; NewStore rejects them
+	RolloverMaxSize string
+	RolloverMaxAge  string
+	DeleteAfter     string
+
+	// number of shards the index is shrunk to
+	ShrinkShards int
+}
+
+func validateILMPolicy(p ILMPolicy) error {
+	if p.RolloverMaxSize != "" || p.RolloverMaxAge != "" {
+		return errors.Wrap(ErrInvalidILMPolicy,
+			"the rollover would spread the devices over several indices")
+	}
+	if p.DeleteAfter != "" {
+		return errors.Wrap(ErrInvalidILMPolicy,
+			"the delete phase would delete the devices")
+	}
+	return nil
+}
+
+// body returns the ILM policy definition
+func (p ILMPolicy) body() model.M {
+	phases := model.M{
+		"hot": model.M{
+			"actions": model.M{},
+		},
+	}
+	if p.ShrinkShards > 0 {
+		phases["warm"] = model.M{
+			"actions": model.M{
+				"shrink": model.M{
+					"number_of_shards": p.ShrinkShards,
+				},
+			},
+		}
+	}
+
+	return model.M{
+		"policy": model.M{
+			"phases": phases,
+		},
+	}
+}
+
+// WithILMPolicy enables the index lifecycle management of the devices index
+func WithILMPolicy(policy ILMPolicy) StoreOption {
+	return func(s *store) {
+		s.ilmPolicy = policy
+	}
+}
+
+// migratePutILMPolicy creates or updates the ILM policy, if enabled
+func (s *store) migratePutILMPolicy(ctx context.Context) error {
+	l := log.FromContext(ctx)
+	if s.ilmPolicy.Name == "" {
+		l.Debug("ILM disabled, skip the ILM policy")
+		return nil
+	}
+	l.Infof("put the ILM policy %s", s.ilmPolicy.Name)
+
+	req := esapi.ILMPutLifecycleRequest{
+		Policy: s.ilmPolicy.Name,
+		Body:   esutil.NewJSONReader(s.ilmPolicy.body()),
+	}
+
+	res, err := req.Do(ctx, s.client)
+	if err != nil {
+		return errors.Wrap(err, "failed to put the ILM policy")
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return errors.Errorf("failed to put the ILM policy, code %d", res.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrateILMPolicy(t *testing.T) {
+	testCases := map[string]struct {
+		policy ILMPolicy
+
+		ilmBody  map[string]interface{}
+		settings map[string]interface{}
+	}{
+		"ok": {
+			policy: ILMPolicy{
+				Name:         "reporting-devices",
+				ShrinkShards: 1,
+			},
+			ilmBody: map[string]interface{}{
+				"policy": map[string]interface{}{
+					"phases": map[string]interface{}{
+						"hot": map[string]interface{}{
+							"actions": map[string]interface{}{},
+						},
+						"warm": map[string]interface{}{
+							"actions": map[string]interface{}{
+								"shrink": map[string]interface{}{
+									"number_of_shards": float64(1),
+								},
+							},
+						},
+					},
+				},
+			},
+			settings: map[string]interface{}{
+				"number_of_shards":       float64(0),
+				"number_of_replicas":     float64(0),
+				"index.refresh_interval": "1s",
+				"index.lifecycle.name":   "reporting-devices",
+			},
+		},
+		"ok, ILM disabled": {
+			settings: map[string]interface{}{
+				"number_of_shards":       float64(0),
+				"number_of_replicas":     float64(0),
+				"index.refresh_interval": "1s",
+			},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var ilmBody, template map[string]interface{}
+			s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodPut &&
+					r.URL.Path == "/_ilm/policy/reporting-devices":
+					_ = json.NewDecoder(r.Body).Decode(&ilmBody)
+					_, _ = w.Write([]byte(`{"acknowledged":true}`))
+				case r.Method == http.MethodPut &&
+					r.URL.Path == "/_index_template/devices":
+					_ = json.NewDecoder(r.Body).Decode(&template)
+					_, _ = w.Write([]byte(`{"acknowledged":true}`))
+				case r.Method == http.MethodHead && r.URL.Path == "/devices":
+					w.WriteHeader(http.StatusNotFound)
+				case r.Method == http.MethodPut && r.URL.Path == "/devices":
+					_, _ = w.Write([]byte(`{"acknowledged":true}`))
+				default:
+					t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+					w.WriteHeader(http.StatusInternalServerError)
+				}
+			}, WithILMPolicy(tc.policy))
+
+			err := s.Migrate(context.Background())
+			assert.NoError(t, err)
+
+			assert.Equal(t, tc.ilmBody, ilmBody)
+			if assert.NotNil(t, template) {
+				assert.Equal(t, tc.settings, templateSettings(template))
+			}
+		})
+	}
+}
+
+func TestValidateILMPolicy(t *testing.T) {
+	testCases := map[string]struct {
+		policy ILMPolicy
+
+		err string
+	}{
+		"ok": {
+			policy: ILMPolicy{Name: "reporting-devices", ShrinkShards: 1},
+		},
+		"ok, ILM disabled": {},
+		"error, rollover on size": {
+			policy: ILMPolicy{Name: "reporting-devices", RolloverMaxSize: "50gb"},
+			err:    "the rollover would spread the devices over several indices",
+		},
+		"error, rollover on age": {
+			policy: ILMPolicy{Name: "reporting-devices", RolloverMaxAge: "30d"},
+			err:    "the rollover would spread the devices over several indices",
+		},
+		"error, delete phase": {
+			policy: ILMPolicy{Name: "reporting-devices", DeleteAfter: "90d"},
+			err:    "the delete phase would delete the devices",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := validateILMPolicy(tc.policy)
+			if tc.err != "" {
+				assert.True(t, errors.Is(err, ErrInvalidILMPolicy))
+				assert.Contains(t, err.Error(), tc.err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
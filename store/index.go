@@ -14,6 +14,28 @@
 
 package store
 
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/reporting/model"
+)
+
+const (
+	textAnalyzerName  = "reporting_text"
+	textTokenizerName = "reporting_text_tokenizer"
+
+	// textSubfield is the analyzed sub-field added to the designated text fields
+	textSubfield = model.TextSubfield
+	// ipSubfield is the 'ip' sub-field added to the designated IP fields
+	ipSubfield = model.IPSubfield
+	// geoSubfield is the 'geo' sub-field added to the designated location fields
+	geoSubfield = model.GeoSubfield
+)
+
 const indexDevicesTemplate = `{
 	"index_patterns": ["%s*"],
 	"priority": 1,
@@ -89,3 +111,176 @@ const indexDevicesTemplate = `{
 		}
 	}
 }`
+
+// devicesIndexTemplate renders the devices index template, including
+// the configurable parts on top of the base indexDevicesTemplate
+func (s *store) devicesIndexTemplate(indexName string) (map[string]interface{}, error) {
+	shards, replicas := s.devicesIndexShards, s.devicesIndexReplicas
+	tenantIndex, dedicated := s.tenantIndex(indexName)
+	if dedicated {
+		shards, replicas = tenantIndex.Shards, tenantIndex.Replicas
+	}
+	base := fmt.Sprintf(indexDevicesTemplate,
+		indexName,
+		shards,
+		replicas,
+	)
+
+	var template map[string]interface{}
+	if err := json.Unmarshal([]byte(base), &template); err != nil {
+		return nil, errors.Wrap(err, "can't parse the index template")
+	}
+
+	tmpl := template["template"].(map[string]interface{})
+	settings := tmpl["settings"].(map[string]interface{})
+	mappings := tmpl["mappings"].(map[string]interface{})
+
+	if s.refreshInterval != "" {
+		settings["index.refresh_interval"] = s.refreshInterval
+	}
+
+	if dedicated {
+		template["priority"] = tenantIndexTemplatePriority
+	}
+
+	// the indices created from the template, e.g. by a migration,
+	// are searchable through the read alias right away
+	if s.devicesReadAlias != "" && !dedicated {
+		tmpl["aliases"] = map[string]interface{}{
+			s.devicesReadAlias: map[string]interface{}{},
+		}
+	}
+
+	if s.fieldLimit > 0 {
+		settings["index.mapping.total_fields.limit"] = s.fieldLimit
+	}
+
+	if s.ilmPolicy.Name != "" {
+		settings["index.lifecycle.name"] = s.ilmPolicy.Name
+	}
+
+	// the first matching dynamic template wins, so the more specific
+	// templates are prepended to the generic ones
+	if len(s.scopeMappings) > 0 {
+		mappings["dynamic_templates"] = append(s.scopeDynamicTemplates(),
+			mappings["dynamic_templates"].([]interface{})...)
+	}
+	// the attributes' templates are more specific than the scopes' ones
+	if len(s.scaledFloats) > 0 {
+		mappings["dynamic_templates"] = append(s.scaledFloatDynamicTemplates(),
+			mappings["dynamic_templates"].([]interface{})...)
+	}
+
+	if len(s.textFields) > 0 {
+		settings["analysis"] = map[string]interface{}{
+			"tokenizer": map[string]interface{}{
+				textTokenizerName: map[string]interface{}{
+					"type":    "pattern",
+					"pattern": s.textAnalyzerPattern,
+				},
+			},
+			"analyzer": map[string]interface{}{
+				textAnalyzerName: map[string]interface{}{
+					"type":      "custom",
+					"tokenizer": textTokenizerName,
+					"filter":    []interface{}{"lowercase"},
+				},
+			},
+		}
+
+		// the text fields' templates must precede the generic ones
+		// (the first matching dynamic template wins)
+		dynamicTemplates := []interface{}{}
+		for i, pattern := range s.textFields {
+			dynamicTemplates = append(dynamicTemplates, map[string]interface{}{
+				fmt.Sprintf("texts_%d", i): map[string]interface{}{
+					"match": pattern,
+					"mapping": map[string]interface{}{
+						"type": "keyword",
+						"fields": map[string]interface{}{
+							textSubfield: map[string]interface{}{
+								"type":     "text",
+								"analyzer": textAnalyzerName,
+							},
+						},
+					},
+				},
+			})
+		}
+		mappings["dynamic_templates"] = append(dynamicTemplates,
+			mappings["dynamic_templates"].([]interface{})...)
+	}
+
+	// the values which aren't plain IP addresses (e.g. 10.0.0.1/24) are
+	// still indexed as keywords, but not in the 'ip' sub-field
+	if len(s.ipFields) > 0 {
+		dynamicTemplates := []interface{}{}
+		for i, pattern := range s.ipFields {
+			dynamicTemplates = append(dynamicTemplates, map[string]interface{}{
+				fmt.Sprintf("ips_%d", i): map[string]interface{}{
+					"match": pattern,
+					"mapping": map[string]interface{}{
+						"type": "keyword",
+						"fields": map[string]interface{}{
+							ipSubfield: map[string]interface{}{
+								"type":             "ip",
+								"ignore_malformed": true,
+							},
+						},
+					},
+				},
+			})
+		}
+		mappings["dynamic_templates"] = append(dynamicTemplates,
+			mappings["dynamic_templates"].([]interface{})...)
+	}
+
+	// the values which aren't valid locations ("lat,lon" or geohash) are
+	// still indexed as keywords, but not in the 'geo' sub-field
+	if len(s.geoFields) > 0 {
+		dynamicTemplates := []interface{}{}
+		for i, pattern := range s.geoFields {
+			dynamicTemplates = append(dynamicTemplates, map[string]interface{}{
+				fmt.Sprintf("geos_%d", i): map[string]interface{}{
+					"match": pattern,
+					"mapping": map[string]interface{}{
+						"type": "keyword",
+						"fields": map[string]interface{}{
+							geoSubfield: map[string]interface{}{
+								"type":             "geo_point",
+								"ignore_malformed": true,
+							},
+						},
+					},
+				},
+			})
+		}
+		mappings["dynamic_templates"] = append(dynamicTemplates,
+			mappings["dynamic_templates"].([]interface{})...)
+	}
+
+	if s.ignoreAbove > 0 {
+		setIgnoreAbove(mappings["dynamic_templates"].([]interface{}), s.ignoreAbove)
+	}
+
+	return template, nil
+}
+
+// setIgnoreAbove sets ignore_above on the keyword mappings of the string
+// attributes' dynamic templates, i.e. the generic, the text, the IP and
+// the location fields' ones
+func setIgnoreAbove(dynamicTemplates []interface{}, ignoreAbove int) {
+	for _, t := range dynamicTemplates {
+		for name, tmpl := range t.(map[string]interface{}) {
+			if name != "strings" && !strings.HasPrefix(name, "texts_") &&
+				!strings.HasPrefix(name, "ips_") &&
+				!strings.HasPrefix(name, "geos_") {
+				continue
+			}
+			mapping := tmpl.(map[string]interface{})["mapping"].(map[string]interface{})
+			if mapping["type"] == "keyword" {
+				mapping["ignore_above"] = ignoreAbove
+			}
+		}
+	}
+}
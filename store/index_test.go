@@ -0,0 +1,159 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func templateSettings(template map[string]interface{}) map[string]interface{} {
+	tmpl := template["template"].(map[string]interface{})
+	return tmpl["settings"].(map[string]interface{})
+}
+
+func templateMappings(template map[string]interface{}) map[string]interface{} {
+	tmpl := template["template"].(map[string]interface{})
+	return tmpl["mappings"].(map[string]interface{})
+}
+
+func TestDevicesIndexTemplate(t *testing.T) {
+	s := &store{
+		devicesIndexShards:   2,
+		devicesIndexReplicas: 1,
+	}
+
+	template, err := s.devicesIndexTemplate("devices")
+	assert.NoError(t, err)
+
+	assert.Equal(t, []interface{}{"devices*"}, template["index_patterns"])
+	settings := templateSettings(template)
+	assert.Equal(t, float64(2), settings["number_of_shards"])
+	assert.Equal(t, float64(1), settings["number_of_replicas"])
+	assert.NotContains(t, settings, "analysis")
+	mappings := templateMappings(template)
+	assert.Len(t, mappings["dynamic_templates"], 4)
+}
+
+func TestDevicesIndexTemplateTextAnalyzer(t *testing.T) {
+	s := &store{}
+	WithTextAnalyzer(`[\s,;]+`, []string{
+		"inventory_hostname_str",
+		"inventory_*_version_str",
+	})(s)
+
+	template, err := s.devicesIndexTemplate("devices")
+	assert.NoError(t, err)
+
+	settings := templateSettings(template)
+	assert.Equal(t, map[string]interface{}{
+		"tokenizer": map[string]interface{}{
+			"reporting_text_tokenizer": map[string]interface{}{
+				"type":    "pattern",
+				"pattern": `[\s,;]+`,
+			},
+		},
+		"analyzer": map[string]interface{}{
+			"reporting_text": map[string]interface{}{
+				"type":      "custom",
+				"tokenizer": "reporting_text_tokenizer",
+				"filter":    []interface{}{"lowercase"},
+			},
+		},
+	}, settings["analysis"])
+
+	mappings := templateMappings(template)
+	dynamicTemplates := mappings["dynamic_templates"].([]interface{})
+	assert.Len(t, dynamicTemplates, 6)
+	textMapping := map[string]interface{}{
+		"type": "keyword",
+		"fields": map[string]interface{}{
+			"text": map[string]interface{}{
+				"type":     "text",
+				"analyzer": "reporting_text",
+			},
+		},
+	}
+	assert.Equal(t, map[string]interface{}{
+		"texts_0": map[string]interface{}{
+			"match":   "inventory_hostname_str",
+			"mapping": textMapping,
+		},
+	}, dynamicTemplates[0])
+	assert.Equal(t, map[string]interface{}{
+		"texts_1": map[string]interface{}{
+			"match":   "inventory_*_version_str",
+			"mapping": textMapping,
+		},
+	}, dynamicTemplates[1])
+}
+
+func TestDevicesIndexTemplateIPFields(t *testing.T) {
+	s := &store{}
+	WithIPFields([]string{"inventory_ipv4_*_str"})(s)
+	WithValueLengthLimit(256, 0)(s)
+
+	template, err := s.devicesIndexTemplate("devices")
+	assert.NoError(t, err)
+
+	mappings := templateMappings(template)
+	dynamicTemplates := mappings["dynamic_templates"].([]interface{})
+	assert.Len(t, dynamicTemplates, 5)
+	assert.Equal(t, map[string]interface{}{
+		"ips_0": map[string]interface{}{
+			"match": "inventory_ipv4_*_str",
+			"mapping": map[string]interface{}{
+				"type":         "keyword",
+				"ignore_above": 256,
+				"fields": map[string]interface{}{
+					"ip": map[string]interface{}{
+						"type":             "ip",
+						"ignore_malformed": true,
+					},
+				},
+			},
+		},
+	}, dynamicTemplates[0])
+}
+
+func TestDevicesIndexTemplateGeoFields(t *testing.T) {
+	s := &store{}
+	WithGeoFields([]string{"inventory_location_str"})(s)
+	WithIPFields([]string{"inventory_ipv4_*_str"})(s)
+	WithValueLengthLimit(256, 0)(s)
+
+	template, err := s.devicesIndexTemplate("devices")
+	assert.NoError(t, err)
+
+	mappings := templateMappings(template)
+	dynamicTemplates := mappings["dynamic_templates"].([]interface{})
+	assert.Len(t, dynamicTemplates, 6)
+	assert.Equal(t, map[string]interface{}{
+		"geos_0": map[string]interface{}{
+			"match": "inventory_location_str",
+			"mapping": map[string]interface{}{
+				"type":         "keyword",
+				"ignore_above": 256,
+				"fields": map[string]interface{}{
+					"geo": map[string]interface{}{
+						"type":             "geo_point",
+						"ignore_malformed": true,
+					},
+				},
+			},
+		},
+	}, dynamicTemplates[0])
+}
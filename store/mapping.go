@@ -0,0 +1,136 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// CheckMapping compares the mapping of the live devices index with the one
+// generated from the index template, and returns a human-readable list of
+// the differences; an empty list means the mapping is up to date
+func (s *store) CheckMapping(ctx context.Context) ([]string, error) {
+	template, err := s.devicesIndexTemplate(s.devicesIndexName)
+	if err != nil {
+		return nil, err
+	}
+	tmpl := template["template"].(map[string]interface{})
+	expected := tmpl["mappings"].(map[string]interface{})
+
+	index, err := s.GetDevIndex(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	current, ok := index["mappings"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("can't parse the index mapping")
+	}
+
+	return diffMapping(current, expected), nil
+}
+
+// diffMapping returns the differences between the current and the expected
+// mappings: the static fields and their types, the dynamic templates and
+// the dynamic mapping mode; fields which exist only in the current mapping,
+// e.g. inventory attributes added by the dynamic templates, are not reported
+func diffMapping(current, expected map[string]interface{}) []string {
+	var diff []string
+
+	if cur, exp := current["dynamic"], expected["dynamic"]; cur != exp {
+		diff = append(diff, fmt.Sprintf(
+			"~ dynamic: %v, expected %v", cur, exp))
+	}
+
+	curProps, _ := current["properties"].(map[string]interface{})
+	expProps, _ := expected["properties"].(map[string]interface{})
+	for _, field := range sortedKeys(expProps) {
+		cur, ok := curProps[field]
+		expType := mappingType(expProps[field])
+		if !ok {
+			diff = append(diff, fmt.Sprintf(
+				"- field %q: missing, expected type %q", field, expType))
+		} else if curType := mappingType(cur); curType != expType {
+			diff = append(diff, fmt.Sprintf(
+				"~ field %q: type %q, expected %q", field, curType, expType))
+		}
+	}
+
+	curTemplates := dynamicTemplates(current)
+	expTemplates := dynamicTemplates(expected)
+	for _, name := range sortedKeys(expTemplates) {
+		cur, ok := curTemplates[name]
+		exp := expTemplates[name]
+		if !ok {
+			diff = append(diff, fmt.Sprintf(
+				"- dynamic template %q: missing", name))
+			continue
+		}
+		if curMatch, expMatch := cur["match"], exp["match"]; curMatch != expMatch {
+			diff = append(diff, fmt.Sprintf(
+				"~ dynamic template %q: match %v, expected %v",
+				name, curMatch, expMatch))
+		}
+		curType, expType := mappingType(cur["mapping"]), mappingType(exp["mapping"])
+		if curType != expType {
+			diff = append(diff, fmt.Sprintf(
+				"~ dynamic template %q: type %q, expected %q",
+				name, curType, expType))
+		}
+	}
+	for _, name := range sortedKeys(curTemplates) {
+		if _, ok := expTemplates[name]; !ok {
+			diff = append(diff, fmt.Sprintf(
+				"+ dynamic template %q: unexpected", name))
+		}
+	}
+
+	return diff
+}
+
+// dynamicTemplates returns the dynamic templates of the mapping by name
+func dynamicTemplates(mapping map[string]interface{}) map[string]map[string]interface{} {
+	templates := map[string]map[string]interface{}{}
+	list, _ := mapping["dynamic_templates"].([]interface{})
+	for _, item := range list {
+		named, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for name, tmpl := range named {
+			templates[name], _ = tmpl.(map[string]interface{})
+		}
+	}
+	return templates
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch m := m.(type) {
+	case map[string]interface{}:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]map[string]interface{}:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
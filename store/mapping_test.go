@@ -0,0 +1,150 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffMapping(t *testing.T) {
+	expected := map[string]interface{}{
+		"dynamic": "runtime",
+		"properties": map[string]interface{}{
+			"id":        map[string]interface{}{"type": "keyword"},
+			"createdAt": map[string]interface{}{"type": "date"},
+		},
+		"dynamic_templates": []interface{}{
+			map[string]interface{}{
+				"strings": map[string]interface{}{
+					"match":   "*_str",
+					"mapping": map[string]interface{}{"type": "keyword"},
+				},
+			},
+			map[string]interface{}{
+				"nums": map[string]interface{}{
+					"match":   "*_num",
+					"mapping": map[string]interface{}{"type": "double"},
+				},
+			},
+		},
+	}
+
+	testCases := map[string]struct {
+		current map[string]interface{}
+
+		diff []string
+	}{
+		"ok, matching": {
+			current: map[string]interface{}{
+				"dynamic": "runtime",
+				"properties": map[string]interface{}{
+					"id":                 map[string]interface{}{"type": "keyword"},
+					"createdAt":          map[string]interface{}{"type": "date"},
+					"inventory_mac_str":  map[string]interface{}{"type": "keyword"},
+					"inventory_cpus_num": map[string]interface{}{"type": "double"},
+				},
+				"dynamic_templates": []interface{}{
+					map[string]interface{}{
+						"strings": map[string]interface{}{
+							"match":   "*_str",
+							"mapping": map[string]interface{}{"type": "keyword"},
+						},
+					},
+					map[string]interface{}{
+						"nums": map[string]interface{}{
+							"match":   "*_num",
+							"mapping": map[string]interface{}{"type": "double"},
+						},
+					},
+				},
+			},
+		},
+		"mismatch": {
+			current: map[string]interface{}{
+				"dynamic": "true",
+				"properties": map[string]interface{}{
+					"createdAt": map[string]interface{}{"type": "keyword"},
+				},
+				"dynamic_templates": []interface{}{
+					map[string]interface{}{
+						"strings": map[string]interface{}{
+							"match":   "*_string",
+							"mapping": map[string]interface{}{"type": "text"},
+						},
+					},
+					map[string]interface{}{
+						"bools": map[string]interface{}{
+							"match":   "*_bool",
+							"mapping": map[string]interface{}{"type": "boolean"},
+						},
+					},
+				},
+			},
+			diff: []string{
+				`~ dynamic: true, expected runtime`,
+				`~ field "createdAt": type "keyword", expected "date"`,
+				`- field "id": missing, expected type "keyword"`,
+				`- dynamic template "nums": missing`,
+				`~ dynamic template "strings": match *_string, expected *_str`,
+				`~ dynamic template "strings": type "text", expected "keyword"`,
+				`+ dynamic template "bools": unexpected`,
+			},
+		},
+		"mismatch, empty index": {
+			current: map[string]interface{}{},
+			diff: []string{
+				`~ dynamic: <nil>, expected runtime`,
+				`- field "createdAt": missing, expected type "date"`,
+				`- field "id": missing, expected type "keyword"`,
+				`- dynamic template "nums": missing`,
+				`- dynamic template "strings": missing`,
+			},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			diff := diffMapping(tc.current, expected)
+			assert.Equal(t, tc.diff, diff)
+		})
+	}
+}
+
+func TestCheckMapping(t *testing.T) {
+	var s *store
+	s = newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodGet, r.Method)
+		assert.Equal(t, "/devices", r.URL.Path)
+
+		// serve the expected mapping back, plus a dynamically mapped field
+		template, _ := s.devicesIndexTemplate("devices")
+		mappings := templateMappings(template)
+		properties := mappings["properties"].(map[string]interface{})
+		properties["inventory_mac_str"] = map[string]interface{}{"type": "keyword"}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"devices": map[string]interface{}{
+				"mappings": mappings,
+			},
+		})
+	})
+
+	diff, err := s.CheckMapping(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, diff)
+}
@@ -0,0 +1,111 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrateUpdateMapping(t *testing.T) {
+	testCases := map[string]struct {
+		properties map[string]interface{}
+
+		update map[string]interface{}
+		err    error
+	}{
+		"ok, up to date": {
+			properties: map[string]interface{}{
+				"id":        map[string]interface{}{"type": "keyword"},
+				"tenantID":  map[string]interface{}{"type": "keyword"},
+				"name":      map[string]interface{}{"type": "keyword"},
+				"groupName": map[string]interface{}{"type": "keyword"},
+				"status":    map[string]interface{}{"type": "keyword"},
+				"createdAt": map[string]interface{}{"type": "date"},
+				"updatedAt": map[string]interface{}{"type": "date"},
+				"inventory_mac_str": map[string]interface{}{
+					"type": "keyword",
+				},
+			},
+		},
+		"ok, new fields added": {
+			properties: map[string]interface{}{
+				"id":        map[string]interface{}{"type": "keyword"},
+				"tenantID":  map[string]interface{}{"type": "keyword"},
+				"name":      map[string]interface{}{"type": "keyword"},
+				"groupName": map[string]interface{}{"type": "keyword"},
+				"createdAt": map[string]interface{}{"type": "date"},
+			},
+			update: map[string]interface{}{
+				"properties": map[string]interface{}{
+					"status":    map[string]interface{}{"type": "keyword"},
+					"updatedAt": map[string]interface{}{"type": "date"},
+				},
+			},
+		},
+		"error, breaking change": {
+			properties: map[string]interface{}{
+				"id":        map[string]interface{}{"type": "keyword"},
+				"tenantID":  map[string]interface{}{"type": "keyword"},
+				"name":      map[string]interface{}{"type": "text"},
+				"groupName": map[string]interface{}{"type": "keyword"},
+			},
+			err: ErrMappingConflict,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var update map[string]interface{}
+			s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodPut &&
+					r.URL.Path == "/_index_template/devices":
+					_, _ = w.Write([]byte(`{"acknowledged":true}`))
+				case r.Method == http.MethodHead && r.URL.Path == "/devices":
+					w.WriteHeader(http.StatusOK)
+				case r.Method == http.MethodGet && r.URL.Path == "/devices/_mapping":
+					_ = json.NewEncoder(w).Encode(map[string]interface{}{
+						"devices": map[string]interface{}{
+							"mappings": map[string]interface{}{
+								"properties": tc.properties,
+							},
+						},
+					})
+				case r.Method == http.MethodPut && r.URL.Path == "/devices/_mapping":
+					_ = json.NewDecoder(r.Body).Decode(&update)
+					_, _ = w.Write([]byte(`{"acknowledged":true}`))
+				default:
+					t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+					w.WriteHeader(http.StatusInternalServerError)
+				}
+			})
+
+			err := s.Migrate(context.Background())
+			if tc.err != nil {
+				assert.Error(t, err)
+				assert.Equal(t, tc.err, errors.Cause(err))
+				assert.Contains(t, err.Error(), `field "name" is mapped as "text"`)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.update, update)
+		})
+	}
+}
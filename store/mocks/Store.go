@@ -17,11 +17,11 @@ package mocks
 
 import (
 	context "context"
+	time "time"
 
 	model "github.com/mendersoftware/reporting/model"
-	mock "github.com/stretchr/testify/mock"
-
 	store "github.com/mendersoftware/reporting/store"
+	mock "github.com/stretchr/testify/mock"
 )
 
 // Store is an autogenerated mock type for the Store type
@@ -29,6 +29,29 @@ type Store struct {
 	mock.Mock
 }
 
+// BulkIndex provides a mock function with given fields: ctx, items
+func (_m *Store) BulkIndex(ctx context.Context, items []store.BulkItem) ([]store.BulkItemError, error) {
+	ret := _m.Called(ctx, items)
+
+	var r0 []store.BulkItemError
+	if rf, ok := ret.Get(0).(func(context.Context, []store.BulkItem) []store.BulkItemError); ok {
+		r0 = rf(ctx, items)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]store.BulkItemError)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, []store.BulkItem) error); ok {
+		r1 = rf(ctx, items)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // BulkIndexDevices provides a mock function with given fields: ctx, devices
 func (_m *Store) BulkIndexDevices(ctx context.Context, devices []*model.Device) error {
 	ret := _m.Called(ctx, devices)
@@ -66,6 +89,161 @@ func (_m *Store) BulkRaw(ctx context.Context, items []store.BulkItem) (map[strin
 	return r0, r1
 }
 
+// CancelTask provides a mock function with given fields: ctx, taskID
+func (_m *Store) CancelTask(ctx context.Context, taskID string) error {
+	ret := _m.Called(ctx, taskID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, taskID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// CheckMapping provides a mock function with given fields: ctx
+func (_m *Store) CheckMapping(ctx context.Context) ([]string, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []string
+	if rf, ok := ret.Get(0).(func(context.Context) []string); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]string)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ClosePointInTime provides a mock function with given fields: ctx, pitID
+func (_m *Store) ClosePointInTime(ctx context.Context, pitID string) error {
+	ret := _m.Called(ctx, pitID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, pitID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// CountTenantsDevices provides a mock function with given fields: ctx
+func (_m *Store) CountTenantsDevices(ctx context.Context) (map[string]int, error) {
+	ret := _m.Called(ctx)
+
+	var r0 map[string]int
+	if rf, ok := ret.Get(0).(func(context.Context) map[string]int); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(map[string]int)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CreateDevice provides a mock function with given fields: ctx, device
+func (_m *Store) CreateDevice(ctx context.Context, device *model.Device) error {
+	ret := _m.Called(ctx, device)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.Device) error); ok {
+		r0 = rf(ctx, device)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteTenantDevices provides a mock function with given fields: ctx, tenantID
+func (_m *Store) DeleteTenantDevices(ctx context.Context, tenantID string) (int, error) {
+	ret := _m.Called(ctx, tenantID)
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func(context.Context, string) int); ok {
+		r0 = rf(ctx, tenantID)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, tenantID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DevicesExist provides a mock function with given fields: ctx, tenantID, deviceIDs
+func (_m *Store) DevicesExist(ctx context.Context, tenantID string, deviceIDs []string) ([]string, error) {
+	ret := _m.Called(ctx, tenantID, deviceIDs)
+
+	var r0 []string
+	if rf, ok := ret.Get(0).(func(context.Context, string, []string) []string); ok {
+		r0 = rf(ctx, tenantID, deviceIDs)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]string)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, []string) error); ok {
+		r1 = rf(ctx, tenantID, deviceIDs)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetAttributeHistory provides a mock function with given fields: ctx, params
+func (_m *Store) GetAttributeHistory(ctx context.Context, params model.AttributeHistoryParams) ([]model.AttributeHistory, error) {
+	ret := _m.Called(ctx, params)
+
+	var r0 []model.AttributeHistory
+	if rf, ok := ret.Get(0).(func(context.Context, model.AttributeHistoryParams) []model.AttributeHistory); ok {
+		r0 = rf(ctx, params)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.AttributeHistory)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, model.AttributeHistoryParams) error); ok {
+		r1 = rf(ctx, params)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetDevIndex provides a mock function with given fields: ctx, tid
 func (_m *Store) GetDevIndex(ctx context.Context, tid string) (map[string]interface{}, error) {
 	ret := _m.Called(ctx, tid)
@@ -135,6 +313,29 @@ func (_m *Store) GetDevices(ctx context.Context, tenantDevs map[string][]string)
 	return r0, r1
 }
 
+// GetDevicesAttributes provides a mock function with given fields: ctx, tenantDevs, attrs
+func (_m *Store) GetDevicesAttributes(ctx context.Context, tenantDevs map[string][]string, attrs []model.SelectAttribute) ([]model.Device, error) {
+	ret := _m.Called(ctx, tenantDevs, attrs)
+
+	var r0 []model.Device
+	if rf, ok := ret.Get(0).(func(context.Context, map[string][]string, []model.SelectAttribute) []model.Device); ok {
+		r0 = rf(ctx, tenantDevs, attrs)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.Device)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, map[string][]string, []model.SelectAttribute) error); ok {
+		r1 = rf(ctx, tenantDevs, attrs)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetDevicesIndex provides a mock function with given fields: tid
 func (_m *Store) GetDevicesIndex(tid string) string {
 	ret := _m.Called(tid)
@@ -149,6 +350,20 @@ func (_m *Store) GetDevicesIndex(tid string) string {
 	return r0
 }
 
+// GetDevicesReadIndex provides a mock function with given fields: tid
+func (_m *Store) GetDevicesReadIndex(tid string) string {
+	ret := _m.Called(tid)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(string) string); ok {
+		r0 = rf(tid)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	return r0
+}
+
 // GetDevicesRoutingKey provides a mock function with given fields: tid
 func (_m *Store) GetDevicesRoutingKey(tid string) string {
 	ret := _m.Called(tid)
@@ -163,6 +378,64 @@ func (_m *Store) GetDevicesRoutingKey(tid string) string {
 	return r0
 }
 
+// GetHistoryIndex provides a mock function with given fields: tid
+func (_m *Store) GetHistoryIndex(tid string) string {
+	ret := _m.Called(tid)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(string) string); ok {
+		r0 = rf(tid)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	return r0
+}
+
+// GetTask provides a mock function with given fields: ctx, taskID
+func (_m *Store) GetTask(ctx context.Context, taskID string) (*model.Task, error) {
+	ret := _m.Called(ctx, taskID)
+
+	var r0 *model.Task
+	if rf, ok := ret.Get(0).(func(context.Context, string) *model.Task); ok {
+		r0 = rf(ctx, taskID)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Task)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, taskID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetVersion provides a mock function with given fields: ctx
+func (_m *Store) GetVersion(ctx context.Context) (string, error) {
+	ret := _m.Called(ctx)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(context.Context) string); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // IndexDevice provides a mock function with given fields: ctx, device
 func (_m *Store) IndexDevice(ctx context.Context, device *model.Device) error {
 	ret := _m.Called(ctx, device)
@@ -191,6 +464,62 @@ func (_m *Store) Migrate(ctx context.Context) error {
 	return r0
 }
 
+// OpenPointInTime provides a mock function with given fields: ctx, tenantID, ttl
+func (_m *Store) OpenPointInTime(ctx context.Context, tenantID string, ttl time.Duration) (string, error) {
+	ret := _m.Called(ctx, tenantID, ttl)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Duration) string); ok {
+		r0 = rf(ctx, tenantID, ttl)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, time.Duration) error); ok {
+		r1 = rf(ctx, tenantID, ttl)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ReindexTenant provides a mock function with given fields: ctx, tenantID
+func (_m *Store) ReindexTenant(ctx context.Context, tenantID string) (string, error) {
+	ret := _m.Called(ctx, tenantID)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(context.Context, string) string); ok {
+		r0 = rf(ctx, tenantID)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, tenantID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RestoreRefresh provides a mock function with given fields: ctx, tenantID
+func (_m *Store) RestoreRefresh(ctx context.Context, tenantID string) error {
+	ret := _m.Called(ctx, tenantID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, tenantID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // Search provides a mock function with given fields: ctx, query
 func (_m *Store) Search(ctx context.Context, query interface{}) (model.M, error) {
 	ret := _m.Called(ctx, query)
@@ -214,6 +543,20 @@ func (_m *Store) Search(ctx context.Context, query interface{}) (model.M, error)
 	return r0, r1
 }
 
+// SuspendRefresh provides a mock function with given fields: ctx, tenantID
+func (_m *Store) SuspendRefresh(ctx context.Context, tenantID string) error {
+	ret := _m.Called(ctx, tenantID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, tenantID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // UpdateDevice provides a mock function with given fields: ctx, tenantID, deviceID, updateDev
 func (_m *Store) UpdateDevice(ctx context.Context, tenantID string, deviceID string, updateDev *model.Device) error {
 	ret := _m.Called(ctx, tenantID, deviceID, updateDev)
@@ -227,3 +570,77 @@ func (_m *Store) UpdateDevice(ctx context.Context, tenantID string, deviceID str
 
 	return r0
 }
+
+// UpdateReadAlias provides a mock function with given fields: ctx, add, remove
+func (_m *Store) UpdateReadAlias(ctx context.Context, add []string, remove []string) error {
+	ret := _m.Called(ctx, add, remove)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, []string, []string) error); ok {
+		r0 = rf(ctx, add, remove)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UpdateTenantDevicesByQuery provides a mock function with given fields: ctx, tenantID, body
+func (_m *Store) UpdateTenantDevicesByQuery(ctx context.Context, tenantID string, body interface{}) (*model.Task, error) {
+	ret := _m.Called(ctx, tenantID, body)
+
+	var r0 *model.Task
+	if rf, ok := ret.Get(0).(func(context.Context, string, interface{}) *model.Task); ok {
+		r0 = rf(ctx, tenantID, body)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Task)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, interface{}) error); ok {
+		r1 = rf(ctx, tenantID, body)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ValidateQuery provides a mock function with given fields: ctx, query
+func (_m *Store) ValidateQuery(ctx context.Context, query model.Query) (*model.QueryValidation, error) {
+	ret := _m.Called(ctx, query)
+
+	var r0 *model.QueryValidation
+	if rf, ok := ret.Get(0).(func(context.Context, model.Query) *model.QueryValidation); ok {
+		r0 = rf(ctx, query)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.QueryValidation)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, model.Query) error); ok {
+		r1 = rf(ctx, query)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// WarmUp provides a mock function with given fields: ctx
+func (_m *Store) WarmUp(ctx context.Context) error {
+	ret := _m.Called(ctx)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context) error); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
@@ -0,0 +1,71 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"context"
+
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/elastic/go-elasticsearch/v7/esutil"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+)
+
+// IngestPipeline configures the ingest pipeline the devices are indexed
+// with, for the server-side enrichments (e.g. geoip); an empty Name disables
+// the pipeline
+type IngestPipeline struct {
+	Name string
+
+	// Definition is the pipeline body (description, processors), which
+	// Migrate creates or updates; if empty, the pipeline is expected
+	// to be managed outside of the service
+	Definition map[string]interface{}
+}
+
+// WithIngestPipeline indexes the devices with the ingest pipeline
+func WithIngestPipeline(pipeline IngestPipeline) StoreOption {
+	return func(s *store) {
+		s.ingestPipeline = pipeline
+	}
+}
+
+// migratePutIngestPipeline creates or updates the ingest pipeline,
+// if enabled and defined
+func (s *store) migratePutIngestPipeline(ctx context.Context) error {
+	l := log.FromContext(ctx)
+	if s.ingestPipeline.Name == "" || len(s.ingestPipeline.Definition) == 0 {
+		l.Debug("no ingest pipeline definition, skip the ingest pipeline")
+		return nil
+	}
+	l.Infof("put the ingest pipeline %s", s.ingestPipeline.Name)
+
+	req := esapi.IngestPutPipelineRequest{
+		PipelineID: s.ingestPipeline.Name,
+		Body:       esutil.NewJSONReader(s.ingestPipeline.Definition),
+	}
+
+	res, err := req.Do(ctx, s.client)
+	if err != nil {
+		return errors.Wrap(err, "failed to put the ingest pipeline")
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return errors.Errorf("failed to put the ingest pipeline, code %d", res.StatusCode)
+	}
+	return nil
+}
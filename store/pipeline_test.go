@@ -0,0 +1,149 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/reporting/model"
+)
+
+func TestIndexDevicePipeline(t *testing.T) {
+	testCases := map[string]struct {
+		pipeline IngestPipeline
+
+		param string
+	}{
+		"ok, pipeline": {
+			pipeline: IngestPipeline{Name: "reporting-geoip"},
+			param:    "reporting-geoip",
+		},
+		"ok, no pipeline": {},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var params []string
+			s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+				params = append(params, r.URL.Query().Get("pipeline"))
+				switch r.URL.Path {
+				case "/devices/_doc/dev1":
+					_, _ = w.Write([]byte(`{"result": "created"}`))
+				case "/_bulk":
+					_, _ = w.Write([]byte(`{"errors": false, "items": []}`))
+				default:
+					t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+				}
+			}, WithIngestPipeline(tc.pipeline))
+
+			dev := model.NewDevice("dev1").SetTenantID("tenant1")
+			err := s.IndexDevice(context.Background(), dev)
+			assert.NoError(t, err)
+
+			err = s.BulkIndexDevices(context.Background(), []*model.Device{dev})
+			assert.NoError(t, err)
+
+			_, err = s.BulkRaw(context.Background(), []BulkItem{{
+				Action: &BulkAction{
+					Type: "index",
+					Desc: &BulkActionDesc{ID: "dev1", Index: "devices", Routing: "tenant1"},
+				},
+				Doc: dev,
+			}})
+			assert.NoError(t, err)
+
+			assert.Equal(t, []string{tc.param, tc.param, tc.param}, params)
+		})
+	}
+}
+
+func TestMigrateIngestPipeline(t *testing.T) {
+	definition := map[string]interface{}{
+		"description": "geoip of the devices",
+		"processors": []interface{}{
+			map[string]interface{}{
+				"geoip": map[string]interface{}{
+					"field":          "inventory_ipv4_str",
+					"target_field":   "inventory_geoip",
+					"ignore_missing": true,
+				},
+			},
+		},
+	}
+	testCases := map[string]struct {
+		pipeline IngestPipeline
+
+		body map[string]interface{}
+	}{
+		"ok": {
+			pipeline: IngestPipeline{
+				Name:       "reporting-geoip",
+				Definition: definition,
+			},
+			body: definition,
+		},
+		"ok, pipeline managed outside": {
+			pipeline: IngestPipeline{Name: "reporting-geoip"},
+		},
+		"ok, no pipeline": {},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var body map[string]interface{}
+			s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodPut &&
+					r.URL.Path == "/_ingest/pipeline/reporting-geoip":
+					_ = json.NewDecoder(r.Body).Decode(&body)
+					_, _ = w.Write([]byte(`{"acknowledged":true}`))
+				case r.Method == http.MethodPut &&
+					r.URL.Path == "/_index_template/devices":
+					_, _ = w.Write([]byte(`{"acknowledged":true}`))
+				case r.Method == http.MethodHead && r.URL.Path == "/devices":
+					w.WriteHeader(http.StatusNotFound)
+				case r.Method == http.MethodPut && r.URL.Path == "/devices":
+					_, _ = w.Write([]byte(`{"acknowledged":true}`))
+				case r.Method == http.MethodHead && r.URL.Path == "/_alias/devices":
+					w.WriteHeader(http.StatusNotFound)
+				default:
+					t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+					w.WriteHeader(http.StatusInternalServerError)
+				}
+			}, WithIngestPipeline(tc.pipeline))
+
+			err := s.Migrate(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, tc.body, body)
+		})
+	}
+}
+
+func TestMigrateIngestPipelineError(t *testing.T) {
+	s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/_ingest/pipeline/reporting-geoip", r.URL.Path)
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":{"type":"parse_exception"}}`))
+	}, WithIngestPipeline(IngestPipeline{
+		Name:       "reporting-geoip",
+		Definition: map[string]interface{}{"processors": "none"},
+	}))
+
+	err := s.Migrate(context.Background())
+	assert.EqualError(t, err, "failed to put the ingest pipeline, code 400")
+}
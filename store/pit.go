@@ -0,0 +1,99 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/elastic/go-elasticsearch/v7/esutil"
+	"github.com/pkg/errors"
+)
+
+// ErrPointInTimeNotFound is returned when searching a point in time
+// which expired or was closed
+var ErrPointInTimeNotFound = errors.New("point in time not found")
+
+// esSearchContextMissingException is the search of an expired point in time
+const esSearchContextMissingException = "search_context_missing_exception"
+
+// keepAlive formats the duration as an ES time value
+func keepAlive(ttl time.Duration) string {
+	return fmt.Sprintf("%dms", ttl.Milliseconds())
+}
+
+// OpenPointInTime opens a point in time of the tenant's devices index,
+// i.e. a snapshot of the index which the searches can page through
+// consistently (see model.Query.WithPointInTime); it's kept alive for ttl
+// after each search
+func (s *store) OpenPointInTime(
+	ctx context.Context,
+	tenantID string,
+	ttl time.Duration,
+) (string, error) {
+	req := esapi.OpenPointInTimeRequest{
+		Index:     []string{s.GetDevicesReadIndex(tenantID)},
+		Routing:   s.GetDevicesRoutingKey(tenantID),
+		KeepAlive: keepAlive(ttl),
+	}
+
+	res, err := req.Do(withIdempotent(ctx), s.client)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to open the point in time")
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return "", errors.Errorf(
+			"failed to open the point in time, code %d", res.StatusCode,
+		)
+	}
+
+	var pitRes struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&pitRes); err != nil {
+		return "", errors.Wrap(err, "can't parse the point in time")
+	}
+
+	return pitRes.ID, nil
+}
+
+// ClosePointInTime releases the point in time pitID before it expires
+func (s *store) ClosePointInTime(ctx context.Context, pitID string) error {
+	req := esapi.ClosePointInTimeRequest{
+		Body: esutil.NewJSONReader(map[string]string{"id": pitID}),
+	}
+
+	res, err := req.Do(ctx, s.client)
+	if err != nil {
+		return errors.Wrap(err, "failed to close the point in time")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return ErrPointInTimeNotFound
+	} else if res.IsError() {
+		return errors.Errorf(
+			"failed to close the point in time, code %d", res.StatusCode,
+		)
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+
+	"github.com/mendersoftware/reporting/model"
+)
+
+func TestOpenPointInTime(t *testing.T) {
+	s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/devices/_pit", r.URL.Path)
+		assert.Equal(t, "tenant1", r.URL.Query().Get("routing"))
+		assert.Equal(t, "600000ms", r.URL.Query().Get("keep_alive"))
+		_, _ = w.Write([]byte(`{"id":"pit1"}`))
+	})
+
+	pitID, err := s.OpenPointInTime(context.Background(), "tenant1", 10*time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, "pit1", pitID)
+}
+
+func TestClosePointInTime(t *testing.T) {
+	testCases := map[string]struct {
+		code int
+
+		err error
+	}{
+		"ok": {
+			code: http.StatusOK,
+		},
+		"error, not found": {
+			code: http.StatusNotFound,
+			err:  ErrPointInTimeNotFound,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, http.MethodDelete, r.Method)
+				assert.Equal(t, "/_pit", r.URL.Path)
+				var body map[string]interface{}
+				_ = json.NewDecoder(r.Body).Decode(&body)
+				assert.Equal(t, map[string]interface{}{"id": "pit1"}, body)
+				w.WriteHeader(tc.code)
+				_, _ = w.Write([]byte(`{"succeeded":true,"num_freed":1}`))
+			})
+
+			err := s.ClosePointInTime(context.Background(), "pit1")
+			assert.Equal(t, tc.err, err)
+		})
+	}
+}
+
+func TestSearchPointInTime(t *testing.T) {
+	testCases := map[string]struct {
+		code int
+		body string
+
+		err error
+	}{
+		"ok": {
+			code: http.StatusOK,
+			body: `{"pit_id":"pit2","hits":{"hits":[]}}`,
+		},
+		"error, expired": {
+			code: http.StatusNotFound,
+			body: `{"error": {
+				"root_cause": [{"type": "search_context_missing_exception"}],
+				"type": "search_phase_execution_exception"
+			}, "status": 404}`,
+			err: ErrPointInTimeNotFound,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var body map[string]interface{}
+			s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+				// the point in time determines the index and the routing
+				assert.Equal(t, "/_search", r.URL.Path)
+				assert.Empty(t, r.URL.Query().Get("routing"))
+				assert.Empty(t, r.URL.Query().Get("preference"))
+				_ = json.NewDecoder(r.Body).Decode(&body)
+				w.WriteHeader(tc.code)
+				_, _ = w.Write([]byte(tc.body))
+			}, WithRetryPolicy(RetryPolicy{}))
+
+			ctx := identity.WithContext(context.Background(),
+				&identity.Identity{Tenant: "tenant1"})
+			query := model.NewQuery().
+				WithPreference("session1").
+				WithPointInTime("pit1", "10m")
+			res, err := s.Search(ctx, query)
+			if tc.err != nil {
+				assert.Equal(t, tc.err, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, "pit2", res["pit_id"])
+			}
+			assert.Equal(t, map[string]interface{}{
+				"id":         "pit1",
+				"keep_alive": "10m",
+			}, body["pit"])
+		})
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"context"
+	"expvar"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+)
+
+var (
+	// metricReconnects counts the times the connection to ES was recovered
+	// after a transport error
+	metricReconnects = expvar.NewInt("elasticsearch_reconnects")
+)
+
+// reconnectTransport wraps the base HTTP transport of the ES client; after
+// a transport error, e.g. because ES restarted, the pooled connections are
+// dropped and the next request re-pings ES on a fresh connection before
+// being sent, so that a total outage recovers without restarting the service.
+// It's the innermost transport, so that each retry re-pings ES.
+type reconnectTransport struct {
+	next http.RoundTripper
+
+	mu           sync.Mutex
+	disconnected bool
+}
+
+// closeIdler is implemented by the transports with a connection pool,
+// e.g. *http.Transport
+type closeIdler interface {
+	CloseIdleConnections()
+}
+
+func newReconnectTransport(next http.RoundTripper) *reconnectTransport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &reconnectTransport{
+		next: next,
+	}
+}
+
+func (t *reconnectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.reconnect(req); err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+
+	res, err := t.next.RoundTrip(req)
+	if err != nil && req.Context().Err() == nil {
+		t.disconnect()
+	}
+	return res, err
+}
+
+// disconnect drops the pooled connections, which may be stale
+func (t *reconnectTransport) disconnect() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.disconnected = true
+	if c, ok := t.next.(closeIdler); ok {
+		c.CloseIdleConnections()
+	}
+}
+
+// reconnect re-pings ES if the last request failed; concurrent requests
+// wait for the one ping, and fail with its error
+func (t *reconnectTransport) reconnect(req *http.Request) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.disconnected {
+		return nil
+	}
+
+	ctx := req.Context()
+	if err := t.ping(ctx, req); err != nil {
+		return errors.Wrap(err, "unable to reconnect to Elasticsearch")
+	}
+
+	t.disconnected = false
+	metricReconnects.Add(1)
+	log.FromContext(ctx).Infof("reconnected to Elasticsearch at %s", req.URL.Host)
+	return nil
+}
+
+// ping sends a HEAD request to the root of the node of req; any response
+// means the node is reachable again, its status is up to the request
+func (t *reconnectTransport) ping(ctx context.Context, req *http.Request) error {
+	u := url.URL{
+		Scheme: req.URL.Scheme,
+		Host:   req.URL.Host,
+		User:   req.URL.User,
+		Path:   "/",
+	}
+	ping, err := http.NewRequestWithContext(ctx, http.MethodHead, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	ping.Header = req.Header.Clone()
+
+	res, err := t.next.RoundTrip(ping)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+	return nil
+}
@@ -0,0 +1,129 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/reporting/model"
+)
+
+func TestReconnectTransport(t *testing.T) {
+	var (
+		down     bool
+		pings    int
+		requests int
+	)
+	next := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if req.Method == http.MethodHead {
+			pings++
+			assert.Equal(t, "/", req.URL.Path)
+			assert.Equal(t, "Basic Zm9vOmJhcg==", req.Header.Get("Authorization"))
+		} else {
+			requests++
+		}
+		if down {
+			return nil, errors.New("connection refused")
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	})
+	tr := newReconnectTransport(next)
+
+	send := func() error {
+		req, _ := http.NewRequest(http.MethodGet, "http://localhost:9200/devices/_search", nil)
+		req.Header.Set("Authorization", "Basic Zm9vOmJhcg==")
+		_, err := tr.RoundTrip(req)
+		return err
+	}
+
+	// connected: no ping
+	assert.NoError(t, send())
+	assert.Equal(t, 0, pings)
+	assert.Equal(t, 1, requests)
+
+	// ES goes down: the request fails
+	down = true
+	assert.Error(t, send())
+	assert.True(t, tr.disconnected)
+
+	// still down: the ping fails, the request isn't sent
+	requests, pings = 0, 0
+	assert.EqualError(t, send(), "unable to reconnect to Elasticsearch: connection refused")
+	assert.Equal(t, 1, pings)
+	assert.Equal(t, 0, requests)
+
+	// ES recovers: re-pinged once, then the requests go through
+	reconnects := metricReconnects.Value()
+	down = false
+	requests, pings = 0, 0
+	assert.NoError(t, send())
+	assert.NoError(t, send())
+	assert.Equal(t, 1, pings)
+	assert.Equal(t, 2, requests)
+	assert.False(t, tr.disconnected)
+	assert.Equal(t, reconnects+1, metricReconnects.Value())
+}
+
+func TestReconnectTransportConcurrent(t *testing.T) {
+	var pings int32
+	next := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if req.Method == http.MethodHead {
+			atomic.AddInt32(&pings, 1)
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	})
+	tr := newReconnectTransport(next)
+	tr.disconnected = true
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest(http.MethodGet, "http://localhost:9200/", nil)
+			_, err := tr.RoundTrip(req)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	// the first request reconnects, the others find it connected
+	assert.Equal(t, int32(1), atomic.LoadInt32(&pings))
+}
+
+func TestStoreReconnect(t *testing.T) {
+	var calls int32
+	s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+		// the first attempt fails with a dropped connection, e.g. ES restarting
+		if atomic.AddInt32(&calls, 1) == 1 {
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		_, _ = w.Write([]byte(`{"hits":{"total":{"value":0},"hits":[]}}`))
+	}, WithRetryPolicy(RetryPolicy{MaxRetries: 1}))
+
+	reconnects := metricReconnects.Value()
+	_, err := s.Search(testIdentityCtx(), model.NewQuery())
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	assert.Equal(t, reconnects+1, metricReconnects.Value())
+}
@@ -0,0 +1,123 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/elastic/go-elasticsearch/v7/esutil"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+
+	"github.com/mendersoftware/reporting/model"
+)
+
+const (
+	// RefreshIntervalDefault is the default index.refresh_interval
+	// of the devices index
+	RefreshIntervalDefault = "1s"
+
+	// refreshDisabled is the index.refresh_interval disabling the
+	// periodic refresh of an index
+	refreshDisabled = "-1"
+)
+
+var (
+	ErrInvalidRefreshInterval = errors.New("invalid refresh interval")
+
+	// refreshIntervalRegex matches the ES time values, or -1
+	refreshIntervalRegex = regexp.MustCompile(`^(-1|[0-9]+(nanos|micros|ms|s|m|h|d))$`)
+)
+
+// WithRefreshInterval sets index.refresh_interval of the devices index
+// template, an ES time value (e.g. "30s"); a longer interval improves the
+// indexing throughput, at the expense of the delay before the writes are
+// visible to the searches; empty keeps the ES default
+func WithRefreshInterval(interval string) StoreOption {
+	return func(s *store) {
+		s.refreshInterval = interval
+	}
+}
+
+func validRefreshInterval(interval string) bool {
+	return interval == "" || refreshIntervalRegex.MatchString(interval)
+}
+
+// SuspendRefresh disables the periodic refresh of the tenant's devices
+// index, to speed up a bulk reindex; RestoreRefresh must follow.
+// The devices index may be shared by the tenants, so the refresh is
+// suspended for all of them.
+func (s *store) SuspendRefresh(ctx context.Context, tenantID string) error {
+	return s.putRefreshInterval(ctx, s.GetDevicesIndex(tenantID), refreshDisabled)
+}
+
+// RestoreRefresh sets the refresh interval of the tenant's devices index
+// back to the configured one (or the ES default), and refreshes the index right away,
+// so that the writes made while suspended are visible to the searches
+func (s *store) RestoreRefresh(ctx context.Context, tenantID string) error {
+	index := s.GetDevicesIndex(tenantID)
+	if err := s.putRefreshInterval(ctx, index, s.refreshInterval); err != nil {
+		return err
+	}
+
+	req := esapi.IndicesRefreshRequest{
+		Index: []string{index},
+	}
+	res, err := req.Do(withIdempotent(ctx), s.client)
+	if err != nil {
+		return errors.Wrap(err, "failed to refresh the index")
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return errors.Errorf("failed to refresh the index, code %d", res.StatusCode)
+	}
+	return nil
+}
+
+// putRefreshInterval updates index.refresh_interval of the index;
+// an empty interval resets it to the ES default
+func (s *store) putRefreshInterval(ctx context.Context, index, interval string) error {
+	l := log.FromContext(ctx)
+	l.Infof("set the refresh interval of %s to %q", index, interval)
+
+	var value interface{}
+	if interval != "" {
+		value = interval
+	}
+	req := esapi.IndicesPutSettingsRequest{
+		Index: []string{index},
+		Body: esutil.NewJSONReader(model.M{
+			"index": model.M{
+				"refresh_interval": value,
+			},
+		}),
+	}
+
+	// setting the same interval twice is harmless
+	res, err := req.Do(withIdempotent(ctx), s.client)
+	if err != nil {
+		return errors.Wrap(err, "failed to set the refresh interval")
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return errors.Errorf("failed to set the refresh interval, code %d", res.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRefreshIntervalTemplate(t *testing.T) {
+	s := &store{refreshInterval: "30s"}
+	template, err := s.devicesIndexTemplate("devices")
+	assert.NoError(t, err)
+	assert.Equal(t, "30s", templateSettings(template)["index.refresh_interval"])
+
+	// the ES default is kept
+	s = &store{}
+	template, err = s.devicesIndexTemplate("devices")
+	assert.NoError(t, err)
+	assert.NotContains(t, templateSettings(template), "index.refresh_interval")
+}
+
+func TestRefreshIntervalInvalid(t *testing.T) {
+	_, err := NewStore(WithRefreshInterval("30 seconds"))
+	assert.Equal(t, ErrInvalidRefreshInterval, errors.Cause(err))
+}
+
+func TestSuspendRestoreRefresh(t *testing.T) {
+	testCases := map[string]struct {
+		interval string
+
+		restored interface{}
+	}{
+		"ok": {
+			interval: "30s",
+			restored: "30s",
+		},
+		"ok, ES default": {
+			restored: nil,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var (
+				intervals []interface{}
+				refreshed bool
+			)
+			s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodPut && r.URL.Path == "/devices/_settings":
+					var body map[string]map[string]interface{}
+					_ = json.NewDecoder(r.Body).Decode(&body)
+					intervals = append(intervals, body["index"]["refresh_interval"])
+					_, _ = w.Write([]byte(`{"acknowledged":true}`))
+				case r.Method == http.MethodPost && r.URL.Path == "/devices/_refresh":
+					refreshed = true
+					_, _ = w.Write([]byte(`{"_shards":{"total":1,"successful":1}}`))
+				default:
+					t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+					w.WriteHeader(http.StatusInternalServerError)
+				}
+			}, WithRefreshInterval(tc.interval))
+
+			err := s.SuspendRefresh(context.Background(), "tenant1")
+			assert.NoError(t, err)
+			assert.Equal(t, []interface{}{"-1"}, intervals)
+			assert.False(t, refreshed)
+
+			err = s.RestoreRefresh(context.Background(), "tenant1")
+			assert.NoError(t, err)
+			assert.Equal(t, []interface{}{"-1", tc.restored}, intervals)
+			assert.True(t, refreshed)
+		})
+	}
+}
+
+func TestSuspendRefreshError(t *testing.T) {
+	s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error":{"type":"index_not_found_exception"}}`))
+	})
+
+	err := s.SuspendRefresh(context.Background(), "tenant1")
+	assert.EqualError(t, err, "failed to set the refresh interval, code 404")
+}
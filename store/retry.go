@@ -0,0 +1,152 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	RetryMaxRetriesDefault = 3
+	RetryBackoffDefault    = 100 * time.Millisecond
+)
+
+var (
+	RetryOnStatusDefault = []int{
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+	}
+)
+
+// RetryPolicy configures the retries of the requests to Elasticsearch
+// at runtime, after the store is set up; transport errors and the
+// RetryOnStatus status codes are retried up to MaxRetries times, with
+// an exponential backoff starting at Backoff
+type RetryPolicy struct {
+	MaxRetries    int
+	Backoff       time.Duration
+	RetryOnStatus []int
+}
+
+type ctxKeyIdempotent struct{}
+
+// withIdempotent marks the requests sent with ctx as safe to retry;
+// needed for the POST requests, which are never retried otherwise,
+// but are used by ES also for read-only operations like search and mget
+func withIdempotent(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ctxKeyIdempotent{}, true)
+}
+
+// isConditionalWrite tells if req is a write which only applies if the
+// document is absent (op_type=create) or older (external version, seq no):
+// once the first attempt succeeds, a retry (e.g. after its response is lost)
+// fails with a conflict, reported as ErrDeviceExists or ErrStaleUpdate
+func isConditionalWrite(req *http.Request) bool {
+	q := req.URL.Query()
+	return q.Get("op_type") == "create" ||
+		q.Get("version_type") == "external" ||
+		q.Get("if_seq_no") != "" ||
+		strings.Contains(req.URL.Path, "/_create/")
+}
+
+func isIdempotent(req *http.Request) bool {
+	if isConditionalWrite(req) {
+		return false
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	idempotent, _ := req.Context().Value(ctxKeyIdempotent{}).(bool)
+	return idempotent
+}
+
+// retryTransport wraps the HTTP transport of the ES client, retrying
+// the idempotent requests according to the retry policy
+type retryTransport struct {
+	next   http.RoundTripper
+	policy RetryPolicy
+}
+
+func newRetryTransport(next http.RoundTripper, policy RetryPolicy) *retryTransport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &retryTransport{
+		next:   next,
+		policy: policy,
+	}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.policy.MaxRetries <= 0 || !isIdempotent(req) {
+		return t.next.RoundTrip(req)
+	}
+
+	// buffer the body, to send it again on retries
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ctx := req.Context()
+	backoff := t.policy.Backoff
+	for attempt := 0; ; attempt++ {
+		// the caller's request must not be modified
+		try := req.Clone(ctx)
+		if body != nil {
+			try.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+
+		res, err := t.next.RoundTrip(try)
+		if attempt >= t.policy.MaxRetries || !t.retryable(ctx, res, err) {
+			return res, err
+		}
+		if res != nil {
+			_, _ = ioutil.ReadAll(res.Body)
+			res.Body.Close()
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (t *retryTransport) retryable(ctx context.Context, res *http.Response, err error) bool {
+	if err != nil {
+		return ctx.Err() == nil
+	}
+	for _, status := range t.policy.RetryOnStatus {
+		if res.StatusCode == status {
+			return true
+		}
+	}
+	return false
+}
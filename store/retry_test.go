@@ -0,0 +1,174 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+
+	"github.com/mendersoftware/reporting/model"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	policy := RetryPolicy{
+		MaxRetries:    2,
+		Backoff:       time.Millisecond,
+		RetryOnStatus: RetryOnStatusDefault,
+	}
+	searchRes := `{"hits":{"total":{"value":0},"hits":[]}}`
+
+	testCases := map[string]struct {
+		codes []int
+		call  func(s *store) error
+
+		calls int
+		err   bool
+	}{
+		"ok, transient 503 is retried": {
+			codes: []int{http.StatusServiceUnavailable, http.StatusOK},
+			call: func(s *store) error {
+				_, err := s.Search(testIdentityCtx(), model.NewQuery())
+				return err
+			},
+			calls: 2,
+		},
+		"error, 400 is not retried": {
+			codes: []int{http.StatusBadRequest, http.StatusOK},
+			call: func(s *store) error {
+				_, err := s.Search(testIdentityCtx(), model.NewQuery())
+				return err
+			},
+			calls: 1,
+			err:   true,
+		},
+		"error, retries exhausted": {
+			codes: []int{
+				http.StatusServiceUnavailable,
+				http.StatusServiceUnavailable,
+				http.StatusServiceUnavailable,
+				http.StatusOK,
+			},
+			call: func(s *store) error {
+				_, err := s.Search(testIdentityCtx(), model.NewQuery())
+				return err
+			},
+			calls: 3,
+			err:   true,
+		},
+		"error, create is not retried": {
+			codes: []int{http.StatusServiceUnavailable, http.StatusOK},
+			call: func(s *store) error {
+				dev := model.NewDevice("dev1").SetTenantID("tenant1")
+				return s.CreateDevice(context.Background(), dev)
+			},
+			calls: 1,
+			err:   true,
+		},
+		"error, externally versioned write is not retried": {
+			codes: []int{http.StatusServiceUnavailable, http.StatusOK},
+			call: func(s *store) error {
+				dev := model.NewDevice("dev1").
+					SetTenantID("tenant1").
+					WithMeta(&model.DeviceMeta{Version: 2})
+				return s.IndexDevice(context.Background(), dev)
+			},
+			calls: 1,
+			err:   true,
+		},
+		"error, non-idempotent request is not retried": {
+			codes: []int{http.StatusServiceUnavailable, http.StatusOK},
+			call: func(s *store) error {
+				_, err := s.ReindexTenant(context.Background(), "tenant1")
+				return err
+			},
+			calls: 1,
+			err:   true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var calls int32
+			s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+				n := atomic.AddInt32(&calls, 1)
+				// the body must be resent on retries
+				body, _ := ioutil.ReadAll(r.Body)
+				if r.Method == http.MethodPost {
+					assert.NotEmpty(t, body)
+				}
+				w.WriteHeader(tc.codes[n-1])
+				_, _ = w.Write([]byte(searchRes))
+			}, WithRetryPolicy(policy))
+
+			err := tc.call(s)
+			if tc.err {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, int32(tc.calls), atomic.LoadInt32(&calls))
+		})
+	}
+}
+
+func testIdentityCtx() context.Context {
+	return identity.WithContext(context.Background(), &identity.Identity{
+		Tenant: "tenant1",
+	})
+}
+
+func TestRetryTransportRequestUntouched(t *testing.T) {
+	var reqs []*http.Request
+	next := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		reqs = append(reqs, req)
+		body, _ := ioutil.ReadAll(req.Body)
+		assert.Equal(t, `{"query":{}}`, string(body))
+		code := http.StatusServiceUnavailable
+		if len(reqs) > 1 {
+			code = http.StatusOK
+		}
+		return &http.Response{
+			StatusCode: code,
+			Body:       ioutil.NopCloser(strings.NewReader(`{}`)),
+		}, nil
+	})
+	tr := newRetryTransport(next, RetryPolicy{
+		MaxRetries:    1,
+		Backoff:       time.Millisecond,
+		RetryOnStatus: RetryOnStatusDefault,
+	})
+
+	req, _ := http.NewRequest(http.MethodGet,
+		"http://localhost:9200/devices/_search", strings.NewReader(`{"query":{}}`))
+	body := req.Body
+	res, err := tr.RoundTrip(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	// the attempts are sent with copies of the caller's request
+	if assert.Len(t, reqs, 2) {
+		assert.NotSame(t, req, reqs[0])
+		assert.NotSame(t, req, reqs[1])
+	}
+	assert.Equal(t, body, req.Body)
+}
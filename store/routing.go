@@ -0,0 +1,37 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"context"
+)
+
+type ctxKeyRouting struct{}
+
+// WithRouting overrides the routing of the device reads (GetDevice,
+// GetDevices) sent with ctx, e.g. for the admin tooling reading the legacy
+// documents indexed under a routing other than the tenant's one
+func WithRouting(ctx context.Context, routing string) context.Context {
+	return context.WithValue(ctx, ctxKeyRouting{}, routing)
+}
+
+// readRoutingKey returns the routing of the device reads of tenant tid:
+// the override of ctx, if any, or the tenant's routing key
+func (s *store) readRoutingKey(ctx context.Context, tid string) string {
+	if routing, _ := ctx.Value(ctxKeyRouting{}).(string); routing != "" {
+		return routing
+	}
+	return s.GetDevicesRoutingKey(tid)
+}
@@ -0,0 +1,91 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+)
+
+func TestGetDeviceRouting(t *testing.T) {
+	testCases := map[string]struct {
+		routing string
+
+		expected string
+	}{
+		"ok, tenant routing": {
+			expected: "tenant1",
+		},
+		"ok, override": {
+			routing:  "legacy",
+			expected: "legacy",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "/devices/_doc/dev1", r.URL.Path)
+				assert.Equal(t, tc.expected, r.URL.Query().Get("routing"))
+				_, _ = w.Write([]byte(`{
+					"_index": "devices",
+					"_id": "dev1",
+					"_seq_no": 4,
+					"_primary_term": 1,
+					"found": true,
+					"_source": {"id": "dev1", "tenantID": "tenant1"}
+				}`))
+			})
+
+			ctx := identity.WithContext(context.Background(),
+				&identity.Identity{Tenant: "tenant1"})
+			if tc.routing != "" {
+				ctx = WithRouting(ctx, tc.routing)
+			}
+			dev, err := s.GetDevice(ctx, "tenant1", "dev1")
+			assert.NoError(t, err)
+			if assert.NotNil(t, dev) {
+				assert.Equal(t, "dev1", dev.GetID())
+			}
+		})
+	}
+}
+
+func TestGetDevicesRouting(t *testing.T) {
+	var body map[string]interface{}
+	s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/_mget", r.URL.Path)
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		_, _ = w.Write([]byte(`{"docs": []}`))
+	})
+
+	ctx := WithRouting(context.Background(), "legacy")
+	_, err := s.GetDevices(ctx, map[string][]string{
+		"tenant1": {"dev1"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{
+			"_id":     "dev1",
+			"_index":  "devices",
+			"routing": "legacy",
+		},
+	}, body["docs"])
+}
@@ -0,0 +1,88 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/reporting/model"
+)
+
+var (
+	ErrInvalidScaledFloat = errors.New("invalid scaled float attribute")
+)
+
+// WithScaledFloats maps the numeric values of the given attributes as
+// scaled_float, i.e. as longs scaled by the factor, which takes less space
+// than the default double mapping for e.g. percentages; the keys are
+// "scope/name", the values the scaling factors (e.g. 100 for two decimals).
+// Like all the template mappings, they only apply to the new fields.
+func WithScaledFloats(factors map[string]float64) StoreOption {
+	return func(s *store) {
+		s.scaledFloats = factors
+	}
+}
+
+// splitAttributeKey splits a "scope/name" attribute key
+func splitAttributeKey(key string) (scope, name string, ok bool) {
+	parts := strings.SplitN(key, "/", 2)
+	if len(parts) != 2 || parts[1] == "" || !model.IsValidScope(parts[0]) {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+func validateScaledFloats(factors map[string]float64) error {
+	for key, factor := range factors {
+		if _, _, ok := splitAttributeKey(key); !ok {
+			return errors.Wrapf(ErrInvalidScaledFloat,
+				"%q is not a valid scope/name", key)
+		}
+		if factor <= 0 {
+			return errors.Wrapf(ErrInvalidScaledFloat,
+				"%s: the scaling factor must be positive", key)
+		}
+	}
+	return nil
+}
+
+// scaledFloatDynamicTemplates renders the dynamic templates of the
+// scaled float attributes, matching their numeric fields exactly
+func (s *store) scaledFloatDynamicTemplates() []interface{} {
+	keys := make([]string, 0, len(s.scaledFloats))
+	for key := range s.scaledFloats {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	templates := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		scope, name, _ := splitAttributeKey(key)
+		field := model.ToAttr(scope, name, model.TypeNum)
+		templates = append(templates, map[string]interface{}{
+			"scaled_" + field: map[string]interface{}{
+				"match": field,
+				"mapping": map[string]interface{}{
+					"type":           "scaled_float",
+					"scaling_factor": s.scaledFloats[key],
+				},
+			},
+		})
+	}
+	return templates
+}
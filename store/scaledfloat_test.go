@@ -0,0 +1,137 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/reporting/model"
+)
+
+func TestDevicesIndexTemplateScaledFloats(t *testing.T) {
+	s := &store{}
+	WithScopeMappings(map[string]map[string]interface{}{
+		"monitor_num": {"type": "float"},
+	})(s)
+	WithScaledFloats(map[string]float64{
+		"monitor/cpu_percent": 100,
+		"inventory/mem_ratio": 1000,
+	})(s)
+
+	template, err := s.devicesIndexTemplate("devices")
+	assert.NoError(t, err)
+
+	// the attributes' templates precede the scope ones
+	mappings := templateMappings(template)
+	dynamicTemplates := mappings["dynamic_templates"].([]interface{})
+	if assert.Len(t, dynamicTemplates, 7) {
+		assert.Equal(t, map[string]interface{}{
+			"scaled_inventory_mem_ratio_num": map[string]interface{}{
+				"match": "inventory_mem_ratio_num",
+				"mapping": map[string]interface{}{
+					"type":           "scaled_float",
+					"scaling_factor": float64(1000),
+				},
+			},
+		}, dynamicTemplates[0])
+		assert.Equal(t, map[string]interface{}{
+			"scaled_monitor_cpu_percent_num": map[string]interface{}{
+				"match": "monitor_cpu_percent_num",
+				"mapping": map[string]interface{}{
+					"type":           "scaled_float",
+					"scaling_factor": float64(100),
+				},
+			},
+		}, dynamicTemplates[1])
+		assert.Contains(t, dynamicTemplates[2], "scope_monitor_num")
+	}
+}
+
+func TestScaledFloatRangeQuery(t *testing.T) {
+	// the range filters and the sorts on a scaled float attribute
+	// target the field its dynamic template maps
+	query, err := model.BuildQuery(model.SearchParams{
+		Filters: []model.FilterPredicate{{
+			Scope:     "monitor",
+			Attribute: "cpu_percent",
+			Type:      "$gte",
+			Value:     99.5,
+		}},
+		Sort: []model.SortCriteria{{
+			Scope:     "monitor",
+			Attribute: "cpu_percent",
+			Order:     "desc",
+		}},
+		Page:    1,
+		PerPage: 20,
+	})
+	assert.NoError(t, err)
+
+	b, err := json.Marshal(query)
+	assert.NoError(t, err)
+	assert.Contains(t, string(b),
+		`{"range":{"monitor_cpu_percent_num":{"gte":99.5}}}`)
+	assert.Contains(t, string(b),
+		`{"monitor_cpu_percent_num":{"unmapped_type":"double"}}`)
+
+	assert.NoError(t, model.CheckRangeFilters(map[string]string{
+		"monitor_cpu_percent_num": "scaled_float",
+	}, []model.FilterPredicate{{
+		Scope:     "monitor",
+		Attribute: "cpu_percent",
+		Type:      "$gte",
+		Value:     99.5,
+	}}))
+}
+
+func TestValidateScaledFloats(t *testing.T) {
+	testCases := map[string]struct {
+		factors map[string]float64
+
+		err string
+	}{
+		"ok": {
+			factors: map[string]float64{"monitor/cpu_percent": 100},
+		},
+		"error, unknown scope": {
+			factors: map[string]float64{"metrics/cpu_percent": 100},
+			err:     `"metrics/cpu_percent" is not a valid scope/name: invalid scaled float attribute`,
+		},
+		"error, no name": {
+			factors: map[string]float64{"monitor/": 100},
+			err:     `"monitor/" is not a valid scope/name: invalid scaled float attribute`,
+		},
+		"error, factor": {
+			factors: map[string]float64{"monitor/cpu_percent": 0},
+			err: "monitor/cpu_percent: the scaling factor must be positive: " +
+				"invalid scaled float attribute",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := validateScaledFloats(tc.factors)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				assert.Equal(t, ErrInvalidScaledFloat, errors.Cause(err))
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
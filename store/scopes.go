@@ -0,0 +1,94 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/reporting/model"
+)
+
+var (
+	ErrInvalidScopeMapping = errors.New("invalid scope mapping")
+)
+
+// WithScopeMappings sets the mappings of the attributes of given scopes,
+// overriding the default type-based ones; the keys are either a scope
+// (e.g. "identity"), applying to all the scope's attributes, or a scope and
+// a type suffix (e.g. "monitor_num"), applying to the attributes of that type
+func WithScopeMappings(mappings map[string]map[string]interface{}) StoreOption {
+	return func(s *store) {
+		s.scopeMappings = mappings
+	}
+}
+
+// scopeMappingMatch returns the dynamic template pattern of a scope mapping key
+func scopeMappingMatch(key string) (string, error) {
+	parts := strings.SplitN(key, "_", 2)
+	if !model.IsValidScope(parts[0]) {
+		return "", errors.Wrapf(ErrInvalidScopeMapping, "unknown scope %q", parts[0])
+	}
+	if len(parts) == 1 {
+		return parts[0] + "_*", nil
+	}
+	if !model.IsValidTypeSuffix(parts[1]) {
+		return "", errors.Wrapf(ErrInvalidScopeMapping, "unknown type %q", parts[1])
+	}
+	return parts[0] + "_*_" + parts[1], nil
+}
+
+func validateScopeMappings(mappings map[string]map[string]interface{}) error {
+	for key, mapping := range mappings {
+		if _, err := scopeMappingMatch(key); err != nil {
+			return err
+		}
+		if _, ok := mapping["type"].(string); !ok {
+			return errors.Wrapf(ErrInvalidScopeMapping, "%s: missing field type", key)
+		}
+	}
+	return nil
+}
+
+// scopeDynamicTemplates renders the dynamic templates of the scope mappings;
+// the scope and type mappings precede the scope-wide ones
+func (s *store) scopeDynamicTemplates() []interface{} {
+	keys := make([]string, 0, len(s.scopeMappings))
+	for key := range s.scopeMappings {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		iTyped := strings.Contains(keys[i], "_")
+		jTyped := strings.Contains(keys[j], "_")
+		if iTyped != jTyped {
+			return iTyped
+		}
+		return keys[i] < keys[j]
+	})
+
+	templates := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		match, _ := scopeMappingMatch(key)
+		templates = append(templates, map[string]interface{}{
+			"scope_" + key: map[string]interface{}{
+				"match":   match,
+				"mapping": s.scopeMappings[key],
+			},
+		})
+	}
+	return templates
+}
@@ -0,0 +1,117 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDevicesIndexTemplateScopeMappings(t *testing.T) {
+	s := &store{
+		scopeMappings: map[string]map[string]interface{}{
+			"identity": {"type": "keyword"},
+			"monitor_num": {
+				"type":           "scaled_float",
+				"scaling_factor": 100,
+			},
+		},
+		textFields:          []string{"inventory_hostname_str"},
+		textAnalyzerPattern: `\s+`,
+	}
+
+	template, err := s.devicesIndexTemplate("devices")
+	assert.NoError(t, err)
+
+	templates := templateMappings(template)["dynamic_templates"].([]interface{})
+	names := []string{}
+	for _, tmpl := range templates {
+		for name := range tmpl.(map[string]interface{}) {
+			names = append(names, name)
+		}
+	}
+	// text fields, then scope and type, then scope-wide, then the defaults
+	assert.Equal(t, []string{
+		"texts_0",
+		"scope_monitor_num",
+		"scope_identity",
+		"versions",
+		"nums",
+		"strings",
+		"bools",
+	}, names)
+
+	assert.Equal(t, map[string]interface{}{
+		"scope_identity": map[string]interface{}{
+			"match":   "identity_*",
+			"mapping": map[string]interface{}{"type": "keyword"},
+		},
+	}, templates[2])
+	assert.Equal(t, map[string]interface{}{
+		"scope_monitor_num": map[string]interface{}{
+			"match": "monitor_*_num",
+			"mapping": map[string]interface{}{
+				"type":           "scaled_float",
+				"scaling_factor": 100,
+			},
+		},
+	}, templates[1])
+}
+
+func TestValidateScopeMappings(t *testing.T) {
+	testCases := map[string]struct {
+		mappings map[string]map[string]interface{}
+
+		err string
+	}{
+		"ok": {
+			mappings: map[string]map[string]interface{}{
+				"identity":      {"type": "keyword"},
+				"inventory_str": {"type": "keyword", "ignore_above": 512},
+			},
+		},
+		"error, unknown scope": {
+			mappings: map[string]map[string]interface{}{
+				"hardware": {"type": "keyword"},
+			},
+			err: `unknown scope "hardware": invalid scope mapping`,
+		},
+		"error, unknown type": {
+			mappings: map[string]map[string]interface{}{
+				"monitor_float": {"type": "double"},
+			},
+			err: `unknown type "float": invalid scope mapping`,
+		},
+		"error, no field type": {
+			mappings: map[string]map[string]interface{}{
+				"identity": {"index": false},
+			},
+			err: "identity: missing field type: invalid scope mapping",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := validateScopeMappings(tc.mappings)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				assert.Equal(t, ErrInvalidScopeMapping, errors.Cause(err))
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/reporting/model"
+)
+
+func TestSearchPreference(t *testing.T) {
+	testCases := map[string]struct {
+		query interface{}
+
+		preference string
+	}{
+		"ok, preference": {
+			query:      model.NewQuery().WithPreference("session-1234"),
+			preference: "session-1234",
+		},
+		"ok, no preference": {
+			query: model.NewQuery(),
+		},
+		"ok, raw query": {
+			query: model.M{"query": model.M{"match_all": model.M{}}},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "/devices/_search", r.URL.Path)
+				assert.Equal(t, tc.preference, r.URL.Query().Get("preference"))
+				_, _ = w.Write([]byte(`{"hits":{"total":{"value":0},"hits":[]}}`))
+			})
+
+			_, err := s.Search(testIdentityCtx(), tc.query)
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestSearchType(t *testing.T) {
+	testCases := map[string]struct {
+		query interface{}
+
+		searchType string
+	}{
+		"ok, dfs_query_then_fetch": {
+			query: model.NewQuery().
+				WithSearchType(model.SearchTypeDFSQueryThenFetch),
+			searchType: model.SearchTypeDFSQueryThenFetch,
+		},
+		"ok, default search type": {
+			query: model.NewQuery(),
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "/devices/_search", r.URL.Path)
+				assert.Equal(t, tc.searchType, r.URL.Query().Get("search_type"))
+				_, _ = w.Write([]byte(`{"hits":{"total":{"value":0},"hits":[]}}`))
+			})
+
+			_, err := s.Search(testIdentityCtx(), tc.query)
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestSearchConnectionError(t *testing.T) {
+	s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+	}, WithRetryPolicy(RetryPolicy{}))
+
+	// no response: a clean error, not a panic
+	res, err := s.Search(testIdentityCtx(), model.NewQuery())
+	assert.Error(t, err)
+	assert.Nil(t, res)
+}
+
+func TestSearchSourceExcludes(t *testing.T) {
+	var bodies []map[string]interface{}
+	s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		bodies = append(bodies, body)
+		_, _ = w.Write([]byte(`{"hits":{"total":{"value":0},"hits":[]}}`))
+	}, WithSourceExcludes([]string{"inventory_blob*"}))
+
+	// the query is reused across the searches, and left untouched
+	query := model.NewQuery()
+	for i := 0; i < 2; i++ {
+		_, err := s.Search(testIdentityCtx(), query)
+		assert.NoError(t, err)
+	}
+
+	expected := map[string]interface{}{
+		"excludes": []interface{}{"inventory_blob*"},
+	}
+	if assert.Len(t, bodies, 2) {
+		assert.Equal(t, expected, bodies[0]["_source"])
+		assert.Equal(t, expected, bodies[1]["_source"])
+	}
+	b, _ := json.Marshal(query)
+	assert.NotContains(t, string(b), "inventory_blob")
+}
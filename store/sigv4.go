@@ -0,0 +1,229 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// SigV4ServiceDefault is the signing name of AWS OpenSearch
+	SigV4ServiceDefault = "es"
+
+	sigV4Algorithm  = "AWS4-HMAC-SHA256"
+	sigV4DateFormat = "20060102T150405Z"
+
+	hdrAmzDate          = "X-Amz-Date"
+	hdrAmzSecurityToken = "X-Amz-Security-Token"
+)
+
+// SigV4Config configures the AWS SigV4 signing of the requests to
+// Elasticsearch, required by AWS OpenSearch; the signing is disabled
+// if Region is empty
+type SigV4Config struct {
+	Region string
+	// Service is the signing name of the service, SigV4ServiceDefault
+	// if empty ("aoss" for OpenSearch Serverless)
+	Service string
+	// Credentials provides the credentials, by default from the
+	// environment or the IAM role, see DefaultAWSCredentials
+	Credentials AWSCredentialsProvider
+}
+
+// WithSigV4 signs the requests to Elasticsearch with AWS SigV4
+func WithSigV4(conf SigV4Config) StoreOption {
+	return func(s *store) {
+		s.sigV4 = conf
+	}
+}
+
+// sigV4Transport wraps the HTTP transport of the ES client, signing the
+// requests with AWS SigV4; it's the innermost transport, so that every
+// retry is signed anew
+type sigV4Transport struct {
+	next        http.RoundTripper
+	region      string
+	service     string
+	credentials AWSCredentialsProvider
+	now         func() time.Time
+}
+
+func newSigV4Transport(next http.RoundTripper, conf SigV4Config) *sigV4Transport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	if conf.Service == "" {
+		conf.Service = SigV4ServiceDefault
+	}
+	if conf.Credentials == nil {
+		conf.Credentials = DefaultAWSCredentials()
+	}
+	return &sigV4Transport{
+		next:        next,
+		region:      conf.Region,
+		service:     conf.Service,
+		credentials: conf.Credentials,
+		now:         time.Now,
+	}
+}
+
+func (t *sigV4Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	creds, err := t.credentials(req.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	// the request must not be modified, sign a copy
+	signed := req.Clone(req.Context())
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		signed.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	t.sign(signed, body, creds, t.now().UTC())
+
+	return t.next.RoundTrip(signed)
+}
+
+// sign adds the SigV4 Authorization header, and the headers it signs,
+// to the request
+func (t *sigV4Transport) sign(
+	req *http.Request,
+	body []byte,
+	creds *AWSCredentials,
+	now time.Time,
+) {
+	amzDate := now.Format(sigV4DateFormat)
+	date := amzDate[:8]
+
+	req.Header.Set(hdrAmzDate, amzDate)
+	if creds.SessionToken != "" {
+		req.Header.Set(hdrAmzSecurityToken, creds.SessionToken)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if name == "x-amz-date" || name == "x-amz-security-token" {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	payloadHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		sigV4Escape(req.URL.EscapedPath(), false),
+		sigV4Query(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := strings.Join([]string{date, t.region, t.service, "aws4_request"}, "/")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		sigV4Algorithm,
+		amzDate,
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	key = hmacSHA256(key, t.region)
+	key = hmacSHA256(key, t.service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, creds.AccessKeyID, scope, signedHeaders, signature,
+	))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// sigV4Query returns the canonical query string: the escaped
+// parameters sorted by name, then by value
+func sigV4Query(query url.Values) string {
+	escaped := make(map[string][]string, len(query))
+	names := make([]string, 0, len(query))
+	for name, values := range query {
+		name = sigV4Escape(name, true)
+		for _, value := range values {
+			escaped[name] = append(escaped[name], sigV4Escape(value, true))
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	params := make([]string, 0, len(query))
+	for _, name := range names {
+		values := escaped[name]
+		sort.Strings(values)
+		for _, value := range values {
+			params = append(params, name+"="+value)
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// sigV4Escape escapes all the characters but the unreserved ones
+// (and '/', unless escapeSlash is set)
+func sigV4Escape(s string, escapeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' ||
+			(c == '/' && !escapeSlash) {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
@@ -0,0 +1,221 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// awsCredentialsRefreshWindow is how long before their expiration
+	// the temporary credentials are refreshed
+	awsCredentialsRefreshWindow = 5 * time.Minute
+
+	awsCredentialsTimeout = 5 * time.Second
+)
+
+var (
+	ErrAWSCredentialsNotFound = errors.New("no AWS credentials found")
+
+	// the credentials endpoints, variables for the tests
+	awsContainerCredentialsHost = "http://169.254.170.2"
+	awsInstanceMetadataHost     = "http://169.254.169.254"
+)
+
+// AWSCredentials are the credentials signing the requests; the
+// temporary credentials have a session token and an expiration
+type AWSCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expiration      time.Time
+}
+
+// AWSCredentialsProvider returns the credentials signing the requests
+type AWSCredentialsProvider func(ctx context.Context) (*AWSCredentials, error)
+
+// StaticAWSCredentials always provides the same credentials
+func StaticAWSCredentials(creds AWSCredentials) AWSCredentialsProvider {
+	return func(context.Context) (*AWSCredentials, error) {
+		return &creds, nil
+	}
+}
+
+// DefaultAWSCredentials provides the credentials from the environment
+// (AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN), or else
+// of the IAM role of the ECS task or of the EC2 instance; the temporary
+// credentials of the IAM roles are cached until shortly before they expire
+func DefaultAWSCredentials() AWSCredentialsProvider {
+	var (
+		mu     sync.Mutex
+		cached *AWSCredentials
+	)
+	return func(ctx context.Context) (*AWSCredentials, error) {
+		if creds := envAWSCredentials(); creds != nil {
+			return creds, nil
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if cached != nil &&
+			time.Until(cached.Expiration) > awsCredentialsRefreshWindow {
+			return cached, nil
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, awsCredentialsTimeout)
+		defer cancel()
+		var (
+			creds *AWSCredentials
+			err   error
+		)
+		if os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI") != "" ||
+			os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI") != "" {
+			creds, err = containerAWSCredentials(ctx)
+		} else {
+			creds, err = instanceAWSCredentials(ctx)
+		}
+		if err != nil {
+			return nil, errors.Wrap(ErrAWSCredentialsNotFound, err.Error())
+		}
+		cached = creds
+		return creds, nil
+	}
+}
+
+// envAWSCredentials returns the credentials from the environment, if set
+func envAWSCredentials() *AWSCredentials {
+	creds := &AWSCredentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return nil
+	}
+	return creds
+}
+
+// awsRoleCredentials is the format of the IAM role credentials returned
+// by both the ECS and the EC2 metadata endpoints
+type awsRoleCredentials struct {
+	AccessKeyID     string    `json:"AccessKeyId"`
+	SecretAccessKey string    `json:"SecretAccessKey"`
+	Token           string    `json:"Token"`
+	Expiration      time.Time `json:"Expiration"`
+}
+
+// containerAWSCredentials fetches the credentials of the ECS task role
+func containerAWSCredentials(ctx context.Context) (*AWSCredentials, error) {
+	uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+	if relative := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); relative != "" {
+		uri = awsContainerCredentialsHost + relative
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN"); token != "" {
+		req.Header.Set("Authorization", token)
+	}
+
+	var creds awsRoleCredentials
+	if err := getAWSMetadata(req, &creds); err != nil {
+		return nil, err
+	}
+	return &AWSCredentials{
+		AccessKeyID:     creds.AccessKeyID,
+		SecretAccessKey: creds.SecretAccessKey,
+		SessionToken:    creds.Token,
+		Expiration:      creds.Expiration,
+	}, nil
+}
+
+// instanceAWSCredentials fetches the credentials of the EC2 instance
+// role from the instance metadata service (IMDSv2)
+func instanceAWSCredentials(ctx context.Context) (*AWSCredentials, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut,
+		awsInstanceMetadataHost+"/latest/api/token", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Aws-Ec2-Metadata-Token-Ttl-Seconds", "60")
+	var token string
+	if err := getAWSMetadata(req, &token); err != nil {
+		return nil, err
+	}
+
+	const rolesPath = "/latest/meta-data/iam/security-credentials/"
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet,
+		awsInstanceMetadataHost+rolesPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Aws-Ec2-Metadata-Token", token)
+	var role string
+	if err := getAWSMetadata(req, &role); err != nil {
+		return nil, err
+	}
+	role = strings.TrimSpace(strings.SplitN(role, "\n", 2)[0])
+	if role == "" {
+		return nil, errors.New("no IAM role attached to the instance")
+	}
+
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet,
+		awsInstanceMetadataHost+rolesPath+role, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Aws-Ec2-Metadata-Token", token)
+	var creds awsRoleCredentials
+	if err := getAWSMetadata(req, &creds); err != nil {
+		return nil, err
+	}
+	return &AWSCredentials{
+		AccessKeyID:     creds.AccessKeyID,
+		SecretAccessKey: creds.SecretAccessKey,
+		SessionToken:    creds.Token,
+		Expiration:      creds.Expiration,
+	}, nil
+}
+
+// getAWSMetadata sends the request to a metadata endpoint, and decodes
+// the response into out: as JSON, or as is if out is a *string
+func getAWSMetadata(req *http.Request, out interface{}) error {
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return errors.Errorf("%s %s: code %d",
+			req.Method, req.URL.Path, res.StatusCode)
+	}
+	if s, ok := out.(*string); ok {
+		b, err := ioutil.ReadAll(res.Body)
+		*s = string(b)
+		return err
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
@@ -0,0 +1,266 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestSigV4Sign(t *testing.T) {
+	// test vectors of the AWS SigV4 test suite
+	creds := &AWSCredentials{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+	testCases := map[string]struct {
+		url string
+
+		authorization string
+	}{
+		"get-vanilla": {
+			url: "https://example.amazonaws.com/",
+
+			authorization: "AWS4-HMAC-SHA256 " +
+				"Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+				"SignedHeaders=host;x-amz-date, " +
+				"Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		"get-vanilla-query-order-key-case": {
+			url: "https://example.amazonaws.com/?Param2=value2&Param1=value1",
+
+			authorization: "AWS4-HMAC-SHA256 " +
+				"Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+				"SignedHeaders=host;x-amz-date, " +
+				"Signature=b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			tr := newSigV4Transport(nil, SigV4Config{
+				Region:      "us-east-1",
+				Service:     "service",
+				Credentials: StaticAWSCredentials(*creds),
+			})
+			req, _ := http.NewRequest(http.MethodGet, tc.url, nil)
+			tr.sign(req, nil, creds, now)
+
+			assert.Equal(t, "20150830T123600Z", req.Header.Get(hdrAmzDate))
+			assert.Equal(t, tc.authorization, req.Header.Get("Authorization"))
+		})
+	}
+}
+
+func TestSigV4Transport(t *testing.T) {
+	var signed *http.Request
+	var body string
+	next := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		signed = req
+		b, _ := ioutil.ReadAll(req.Body)
+		body = string(b)
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	})
+	tr := newSigV4Transport(next, SigV4Config{
+		Region: "eu-west-1",
+		Credentials: StaticAWSCredentials(AWSCredentials{
+			AccessKeyID:     "AKID",
+			SecretAccessKey: "secret",
+			SessionToken:    "token",
+		}),
+	})
+	tr.now = func() time.Time {
+		return time.Date(2021, 11, 2, 10, 0, 0, 0, time.UTC)
+	}
+
+	req, _ := http.NewRequest(http.MethodPost,
+		"http://localhost:9200/devices/_search?routing=tenant1",
+		strings.NewReader(`{"query":{"match_all":{}}}`))
+	_, err := tr.RoundTrip(req)
+	assert.NoError(t, err)
+
+	assert.Equal(t, `{"query":{"match_all":{}}}`, body)
+	assert.Equal(t, "20211102T100000Z", signed.Header.Get(hdrAmzDate))
+	assert.Equal(t, "token", signed.Header.Get(hdrAmzSecurityToken))
+	assert.True(t, strings.HasPrefix(signed.Header.Get("Authorization"),
+		"AWS4-HMAC-SHA256 Credential=AKID/20211102/eu-west-1/es/aws4_request, "+
+			"SignedHeaders=host;x-amz-date;x-amz-security-token, Signature="))
+
+	// the original request is left untouched
+	assert.Empty(t, req.Header.Get("Authorization"))
+}
+
+func TestStoreSigV4(t *testing.T) {
+	var signed, unsigned int
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" {
+			signed++
+		} else {
+			unsigned++
+		}
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"result": "created"}`))
+	}
+
+	s := newTestStore(t, handler, WithSigV4(SigV4Config{
+		Region: "eu-west-1",
+		Credentials: StaticAWSCredentials(AWSCredentials{
+			AccessKeyID:     "AKID",
+			SecretAccessKey: "secret",
+		}),
+	}))
+	err := s.CreateDevice(context.Background(), newFilterTestDevice())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, signed)
+	assert.Equal(t, 0, unsigned)
+
+	// disabled
+	signed = 0
+	s = newTestStore(t, handler)
+	err = s.CreateDevice(context.Background(), newFilterTestDevice())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, signed)
+	assert.Equal(t, 1, unsigned)
+}
+
+func TestDefaultAWSCredentials(t *testing.T) {
+	setenv := func(key, value string) {
+		old, ok := os.LookupEnv(key)
+		os.Setenv(key, value)
+		t.Cleanup(func() {
+			if ok {
+				os.Setenv(key, old)
+			} else {
+				os.Unsetenv(key)
+			}
+		})
+	}
+	for _, key := range []string{
+		"AWS_ACCESS_KEY_ID",
+		"AWS_SECRET_ACCESS_KEY",
+		"AWS_SESSION_TOKEN",
+		"AWS_CONTAINER_CREDENTIALS_RELATIVE_URI",
+		"AWS_CONTAINER_CREDENTIALS_FULL_URI",
+		"AWS_CONTAINER_AUTHORIZATION_TOKEN",
+	} {
+		setenv(key, "")
+	}
+	expiration := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	roleCreds := awsRoleCredentials{
+		AccessKeyID:     "AKIDROLE",
+		SecretAccessKey: "secret",
+		Token:           "token",
+		Expiration:      expiration,
+	}
+
+	t.Run("ok, instance role", func(t *testing.T) {
+		requests := 0
+		srv := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				switch r.URL.Path {
+				case "/latest/api/token":
+					assert.Equal(t, http.MethodPut, r.Method)
+					_, _ = w.Write([]byte("imds-token"))
+				case "/latest/meta-data/iam/security-credentials/":
+					assert.Equal(t, "imds-token", r.Header.Get("X-Aws-Ec2-Metadata-Token"))
+					_, _ = w.Write([]byte("reporting-role"))
+				case "/latest/meta-data/iam/security-credentials/reporting-role":
+					assert.Equal(t, "imds-token", r.Header.Get("X-Aws-Ec2-Metadata-Token"))
+					_ = json.NewEncoder(w).Encode(roleCreds)
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+		defer srv.Close()
+		host := awsInstanceMetadataHost
+		awsInstanceMetadataHost = srv.URL
+		defer func() { awsInstanceMetadataHost = host }()
+
+		provider := DefaultAWSCredentials()
+		creds, err := provider(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, &AWSCredentials{
+			AccessKeyID:     "AKIDROLE",
+			SecretAccessKey: "secret",
+			SessionToken:    "token",
+			Expiration:      expiration,
+		}, creds)
+
+		// cached until shortly before the expiration
+		_, err = provider(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 3, requests)
+	})
+
+	t.Run("ok, container role", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "/v2/credentials/1234", r.URL.Path)
+				assert.Equal(t, "auth-token", r.Header.Get("Authorization"))
+				_ = json.NewEncoder(w).Encode(roleCreds)
+			}))
+		defer srv.Close()
+		setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", srv.URL+"/v2/credentials/1234")
+		setenv("AWS_CONTAINER_AUTHORIZATION_TOKEN", "auth-token")
+
+		creds, err := DefaultAWSCredentials()(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, "AKIDROLE", creds.AccessKeyID)
+		assert.Equal(t, "token", creds.SessionToken)
+	})
+
+	t.Run("ok, environment", func(t *testing.T) {
+		setenv("AWS_ACCESS_KEY_ID", "AKIDENV")
+		setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+		creds, err := DefaultAWSCredentials()(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, &AWSCredentials{
+			AccessKeyID:     "AKIDENV",
+			SecretAccessKey: "secret",
+		}, creds)
+	})
+
+	t.Run("error, no credentials", func(t *testing.T) {
+		setenv("AWS_ACCESS_KEY_ID", "")
+		setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", "")
+		srv := httptest.NewServer(http.NotFoundHandler())
+		defer srv.Close()
+		host := awsInstanceMetadataHost
+		awsInstanceMetadataHost = srv.URL
+		defer func() { awsInstanceMetadataHost = host }()
+
+		_, err := DefaultAWSCredentials()(context.Background())
+		assert.True(t, errors.Is(err, ErrAWSCredentialsNotFound))
+	})
+}
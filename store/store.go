@@ -19,8 +19,11 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"sort"
 	"strings"
+	"time"
 
 	es "github.com/elastic/go-elasticsearch/v7"
 	"github.com/elastic/go-elasticsearch/v7/esapi"
@@ -37,18 +40,71 @@ import (
 //go:generate ../x/mockgen.sh
 type Store interface {
 	IndexDevice(ctx context.Context, device *model.Device) error
+	BulkIndex(ctx context.Context, items []BulkItem) ([]BulkItemError, error)
 	BulkIndexDevices(ctx context.Context, devices []*model.Device) error
 	BulkRaw(ctx context.Context, items []BulkItem) (map[string]interface{}, error)
+	CancelTask(ctx context.Context, taskID string) error
+	CheckMapping(ctx context.Context) ([]string, error)
+	ClosePointInTime(ctx context.Context, pitID string) error
+	CountTenantsDevices(ctx context.Context) (map[string]int, error)
+	CreateDevice(ctx context.Context, device *model.Device) error
+	DeleteTenantDevices(ctx context.Context, tenantID string) (int, error)
+	DevicesExist(ctx context.Context, tenantID string, deviceIDs []string) ([]string, error)
+	GetAttributeHistory(
+		ctx context.Context,
+		params model.AttributeHistoryParams,
+	) ([]model.AttributeHistory, error)
 	GetDevice(ctx context.Context, tenant, devid string) (*model.Device, error)
 	GetDevices(ctx context.Context, tenantDevs map[string][]string) ([]model.Device, error)
+	GetDevicesAttributes(
+		ctx context.Context,
+		tenantDevs map[string][]string,
+		attrs []model.SelectAttribute,
+	) ([]model.Device, error)
 	GetDevicesIndex(tid string) string
+	GetDevicesReadIndex(tid string) string
 	GetDevicesRoutingKey(tid string) string
 	GetDevIndex(ctx context.Context, tid string) (map[string]interface{}, error)
+	GetHistoryIndex(tid string) string
+	GetTask(ctx context.Context, taskID string) (*model.Task, error)
+	GetVersion(ctx context.Context) (string, error)
 	Migrate(ctx context.Context) error
+	OpenPointInTime(ctx context.Context, tenantID string, ttl time.Duration) (string, error)
+	ReindexTenant(ctx context.Context, tenantID string) (string, error)
+	RestoreRefresh(ctx context.Context, tenantID string) error
 	Search(ctx context.Context, query interface{}) (model.M, error)
+	SuspendRefresh(ctx context.Context, tenantID string) error
 	UpdateDevice(ctx context.Context, tenantID, deviceID string, updateDev *model.Device) error
+	UpdateReadAlias(ctx context.Context, add, remove []string) error
+	UpdateTenantDevicesByQuery(
+		ctx context.Context,
+		tenantID string,
+		body interface{},
+	) (*model.Task, error)
+	ValidateQuery(ctx context.Context, query model.Query) (*model.QueryValidation, error)
+	WarmUp(ctx context.Context) error
 }
 
+var (
+	ErrMappingConflict = errors.New("index mapping conflict")
+	// ErrTooManyBuckets is returned when a search exceeds the cluster's
+	// search.max_buckets limit
+	ErrTooManyBuckets = errors.New("too many aggregation buckets")
+	// ErrInvalidQuery is returned when ES can't run a search query, e.g.
+	// a range filter on an attribute whose mapped type doesn't support it
+	ErrInvalidQuery = errors.New("invalid query")
+	// ErrStaleUpdate is returned when a versioned device write is ignored,
+	// because the stored device has the same or a newer version
+	ErrStaleUpdate = errors.New("stale update ignored")
+	// ErrDeviceExists is returned by CreateDevice if the device is
+	// already indexed
+	ErrDeviceExists = errors.New("device already exists")
+)
+
+// hdrOpaqueID is the header identifying the source of the requests in the
+// ES slow logs and task lists
+const hdrOpaqueID = "X-Opaque-Id"
+
 type StoreOption func(*store)
 
 type store struct {
@@ -56,17 +112,104 @@ type store struct {
 	devicesIndexName     string
 	devicesIndexShards   int
 	devicesIndexReplicas int
+	tenantIndices        map[string]TenantIndex
+	refreshInterval      string
+	devicesReadAlias     string
+	sourceExcludes       []string
+	mgetBatchSize        int
+	textAnalyzerPattern  string
+	textFields           []string
+	ipFields             []string
+	geoFields            []string
+	retryPolicy          RetryPolicy
+	breakerPolicy        BreakerPolicy
+	fieldLimit           int
+	fieldLimitPolicy     string
+	scopeMappings        map[string]map[string]interface{}
+	scaledFloats         map[string]float64
+	ilmPolicy            ILMPolicy
+	historyIndexName     string
+	ignoreAbove          int
+	truncateAbove        int
+	attrsAllow           []string
+	attrsDeny            []string
+	attrTypes            map[string]string
+	attrTypePolicy       string
+	bulkIndexer          BulkIndexerConfig
+	ingestPipeline       IngestPipeline
+	warmUpQueries        []map[string]interface{}
+	sigV4                SigV4Config
+	opaqueID             string
+	trace                *traceLogger
 	client               *es.Client
 }
 
 func NewStore(opts ...StoreOption) (Store, error) {
-	store := &store{}
+	store := &store{
+		retryPolicy: RetryPolicy{
+			MaxRetries:    RetryMaxRetriesDefault,
+			Backoff:       RetryBackoffDefault,
+			RetryOnStatus: RetryOnStatusDefault,
+		},
+		breakerPolicy: BreakerPolicy{
+			Threshold: BreakerThresholdDefault,
+			Cooldown:  BreakerCooldownDefault,
+		},
+		refreshInterval:  RefreshIntervalDefault,
+		fieldLimitPolicy: FieldLimitPolicyReject,
+		attrTypePolicy:   AttributeTypePolicyCoerce,
+	}
 	for _, opt := range opts {
 		opt(store)
 	}
 
+	if !validRefreshInterval(store.refreshInterval) {
+		return nil, errors.Wrap(ErrInvalidRefreshInterval, store.refreshInterval)
+	}
+	if !validFieldLimitPolicy(store.fieldLimitPolicy) {
+		return nil, errors.Wrap(ErrInvalidFieldLimitPolicy, store.fieldLimitPolicy)
+	}
+	if err := validateScopeMappings(store.scopeMappings); err != nil {
+		return nil, err
+	}
+	if err := validateScaledFloats(store.scaledFloats); err != nil {
+		return nil, err
+	}
+	if err := validateAttributeFilter(store.attrsAllow, store.attrsDeny); err != nil {
+		return nil, err
+	}
+	if err := validateAttributeTypes(store.attrTypes, store.attrTypePolicy); err != nil {
+		return nil, err
+	}
+	if err := validateTenantIndices(store.devicesIndexName, store.tenantIndices); err != nil {
+		return nil, err
+	}
+	if err := validateILMPolicy(store.ilmPolicy); err != nil {
+		return nil, err
+	}
+
+	// the retries are handled by the retry transport, which knows
+	// which requests are safe to retry
+	var next http.RoundTripper = newReconnectTransport(nil)
+	if store.sigV4.Region != "" {
+		next = newSigV4Transport(next, store.sigV4)
+	}
+	transport := newBreakerTransport(
+		newRetryTransport(next, store.retryPolicy),
+		store.breakerPolicy,
+	)
 	cfg := es.Config{
-		Addresses: store.addresses,
+		Addresses:    store.addresses,
+		Transport:    transport,
+		DisableRetry: true,
+	}
+	if store.trace != nil {
+		cfg.Logger = store.trace
+	}
+	if store.opaqueID != "" {
+		cfg.Header = http.Header{
+			hdrOpaqueID: []string{store.opaqueID},
+		}
 	}
 	esClient, err := es.NewClient(cfg)
 	if err != nil {
@@ -106,12 +249,119 @@ func WithDevicesIndexReplicas(indexReplicas int) StoreOption {
 	}
 }
 
+// WithSourceExcludes sets the fields (wildcards allowed) which are never returned
+// by the search and get operations serving the clients, e.g. large inventory
+// blobs; GetDevices still fetches them, as the base of the device updates
+func WithSourceExcludes(excludes []string) StoreOption {
+	return func(s *store) {
+		s.sourceExcludes = excludes
+	}
+}
+
+// WithMgetBatchSize caps the number of devices fetched by a single mget
+// request of GetDevices, so that large lists of ids don't exceed the request
+// size limits; the batches are fetched sequentially (0: no limit)
+func WithMgetBatchSize(size int) StoreOption {
+	return func(s *store) {
+		s.mgetBatchSize = size
+	}
+}
+
+// WithTextAnalyzer adds an analyzed 'text' sub-field to the fields matching
+// the textFields patterns, tokenized by the tokenizerPattern regex and lowercased
+func WithTextAnalyzer(tokenizerPattern string, textFields []string) StoreOption {
+	return func(s *store) {
+		s.textAnalyzerPattern = tokenizerPattern
+		s.textFields = textFields
+	}
+}
+
+// WithIPFields adds an 'ip' sub-field to the (string) fields matching the
+// ipFields patterns, so that they can be aggregated by CIDR ranges
+func WithIPFields(ipFields []string) StoreOption {
+	return func(s *store) {
+		s.ipFields = ipFields
+	}
+}
+
+// WithGeoFields adds a 'geo' geo_point sub-field to the (string) fields
+// matching the geoFields patterns, e.g. "41.12,-71.34", so that they can be
+// clustered by geohash cells
+func WithGeoFields(geoFields []string) StoreOption {
+	return func(s *store) {
+		s.geoFields = geoFields
+	}
+}
+
+// WithRetryPolicy sets the retry policy of the runtime requests to Elasticsearch;
+// only the idempotent requests are retried
+func WithRetryPolicy(policy RetryPolicy) StoreOption {
+	return func(s *store) {
+		s.retryPolicy = policy
+	}
+}
+
+// WithBreakerPolicy sets the circuit breaker policy of the requests to
+// Elasticsearch; a zero threshold disables the circuit breaker
+func WithBreakerPolicy(policy BreakerPolicy) StoreOption {
+	return func(s *store) {
+		s.breakerPolicy = policy
+	}
+}
+
+// WithOpaqueID tags all the requests to Elasticsearch with the X-Opaque-Id
+// header, which identifies their source in the ES slow logs and task lists
+func WithOpaqueID(id string) StoreOption {
+	return func(s *store) {
+		s.opaqueID = id
+	}
+}
+
+// WithTraceLogging logs all the requests to Elasticsearch and their
+// responses, incl. the bodies truncated to maxBodySize (if > 0);
+// for debugging only, as it's verbose and exposes the device data
+func WithTraceLogging(enabled bool, maxBodySize int) StoreOption {
+	return func(s *store) {
+		if enabled {
+			s.trace = newTraceLogger(maxBodySize)
+		} else {
+			s.trace = nil
+		}
+	}
+}
+
+// IndexDevice indexes the device; if the device has an external version
+// (see model.DeviceMeta), ES rejects the write if the stored device is
+// not older, and ErrStaleUpdate is returned
 func (s *store) IndexDevice(ctx context.Context, device *model.Device) error {
+	return s.indexDevice(ctx, device, false)
+}
+
+// CreateDevice indexes the device only if it's not indexed yet
+// (op_type=create), otherwise returns ErrDeviceExists
+func (s *store) CreateDevice(ctx context.Context, device *model.Device) error {
+	return s.indexDevice(ctx, device, true)
+}
+
+func (s *store) indexDevice(ctx context.Context, device *model.Device, create bool) error {
+	device, err := s.prepareDevice(ctx, device)
+	if err != nil {
+		return err
+	}
 	req := esapi.IndexRequest{
 		Index:      s.GetDevicesIndex(device.GetTenantID()),
 		Routing:    s.GetDevicesRoutingKey(device.GetTenantID()),
 		DocumentID: device.GetID(),
 		Body:       esutil.NewJSONReader(device),
+		Pipeline:   s.ingestPipeline.Name,
+	}
+	if create {
+		// the create operations only support the internal versioning
+		req.OpType = "create"
+	} else if device.Meta != nil && device.Meta.Version > 0 {
+		version := int(device.Meta.Version)
+		req.Version = &version
+		req.VersionType = "external"
 	}
 
 	l := log.FromContext(ctx)
@@ -123,10 +373,15 @@ func (s *store) IndexDevice(ctx context.Context, device *model.Device) error {
 	}
 	defer res.Body.Close()
 
-	if res.StatusCode != http.StatusOK {
-		var body []byte
-		_, _ = res.Body.Read(body)
-		return errors.Wrapf(err, "failed to index: %v", body)
+	if res.StatusCode == http.StatusConflict && create {
+		return ErrDeviceExists
+	} else if res.StatusCode == http.StatusConflict && req.VersionType != "" {
+		l.Debugf("stale update of device %s ignored, version %d",
+			device.GetID(), device.Meta.Version)
+		return ErrStaleUpdate
+	} else if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusCreated {
+		body, _ := ioutil.ReadAll(res.Body)
+		return errors.Errorf("failed to index, code %d: %s", res.StatusCode, body)
 	}
 
 	return nil
@@ -137,6 +392,9 @@ type BulkAction struct {
 	Desc *BulkActionDesc
 }
 
+// BulkActionDesc describes the document of a bulk action; if Tenant is set,
+// the routing is the tenant's one and the index defaults to the tenant's
+// devices index, so that a bulk request can span several tenants
 type BulkActionDesc struct {
 	ID            string `json:"_id"`
 	Index         string `json:"_index"`
@@ -151,9 +409,26 @@ type BulkItem struct {
 	Doc    interface{}
 }
 
+// tenantBulkAction returns the action of a bulk item, with the index and
+// the routing derived from the tenant of its description
+func (s *store) tenantBulkAction(action *BulkAction) *BulkAction {
+	if action == nil || action.Desc == nil || action.Desc.Tenant == "" {
+		return action
+	}
+	desc := *action.Desc
+	desc.Routing = s.GetDevicesRoutingKey(desc.Tenant)
+	if desc.Index == "" {
+		desc.Index = s.GetDevicesIndex(desc.Tenant)
+	}
+	return &BulkAction{
+		Type: action.Type,
+		Desc: &desc,
+	}
+}
+
 func (bad BulkActionDesc) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
-		ID      string `json:"_id"`
+		ID      string `json:"_id,omitempty"`
 		Index   string `json:"_index"`
 		Routing string `json:"routing"`
 	}{
@@ -195,34 +470,64 @@ func (bi BulkItem) Marshal() ([]byte, error) {
 }
 
 func (s *store) BulkRaw(ctx context.Context, items []BulkItem) (map[string]interface{}, error) {
+	storeRes, err := s.bulk(ctx, items)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.handleFieldLimitErrors(ctx, items, storeRes)
+}
+
+// bulk sends the items in a single bulk request, as they are
+func (s *store) bulk(ctx context.Context, items []BulkItem) (map[string]interface{}, error) {
 	l := log.FromContext(ctx)
 
-	var buf *bytes.Buffer
+	var (
+		buf     bytes.Buffer
+		dropped []interface{}
+	)
 	for _, bi := range items {
+		bi.Action = s.tenantBulkAction(bi.Action)
+		if dev, ok := bi.Doc.(*model.Device); ok {
+			doc, err := s.prepareDevice(ctx, dev)
+			if err != nil {
+				dropped = append(dropped, droppedBulkItemResult(bi, err))
+				continue
+			}
+			bi.Doc = doc
+		}
 		b, err := bi.Marshal()
 		if err != nil {
 			return nil, err
 		}
-
-		if buf == nil {
-			buf = bytes.NewBuffer(b)
-		}
-
 		buf.Write(b)
 	}
 
-	req := esapi.BulkRequest{
-		Body: buf,
+	storeRes := map[string]interface{}{
+		"errors": false,
+		"items":  []interface{}{},
 	}
-	res, err := req.Do(ctx, s.client)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to bulk index")
+	if buf.Len() > 0 {
+		req := esapi.BulkRequest{
+			Body:     &buf,
+			Pipeline: s.ingestPipeline.Name,
+		}
+		res, err := req.Do(ctx, s.client)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to bulk index")
+		}
+		defer res.Body.Close()
+
+		if err := json.NewDecoder(res.Body).Decode(&storeRes); err != nil {
+			return nil, err
+		}
 	}
-	defer res.Body.Close()
 
-	var storeRes map[string]interface{}
-	if err := json.NewDecoder(res.Body).Decode(&storeRes); err != nil {
-		return nil, err
+	// the dropped devices are reported as items rejected by ES
+	if len(dropped) > 0 {
+		resItems, _ := storeRes["items"].([]interface{})
+		storeRes["items"] = append(resItems, dropped...)
+		storeRes["errors"] = true
 	}
 
 	l.Debugf("bulk response: %v", storeRes)
@@ -230,41 +535,147 @@ func (s *store) BulkRaw(ctx context.Context, items []BulkItem) (map[string]inter
 	return storeRes, nil
 }
 
+// BulkIndexDevices indexes the devices in a single bulk request; the
+// devices rejected by prepareDevice are skipped, and reported in the
+// returned error once the others are indexed
 func (s *store) BulkIndexDevices(ctx context.Context, devices []*model.Device) error {
-	data := ""
+	var (
+		data    strings.Builder
+		dropped []string
+	)
 	for _, device := range devices {
+		doc, err := s.prepareDevice(ctx, device)
+		if err != nil {
+			dropped = append(dropped, device.GetID())
+			continue
+		}
 		actionJSON, err := json.Marshal(BulkAction{
 			Type: "index",
 			Desc: &BulkActionDesc{
-				ID:      device.GetID(),
-				Index:   s.GetDevicesIndex(device.GetTenantID()),
-				Routing: s.GetDevicesRoutingKey(device.GetTenantID()),
+				ID:      doc.GetID(),
+				Index:   s.GetDevicesIndex(doc.GetTenantID()),
+				Routing: s.GetDevicesRoutingKey(doc.GetTenantID()),
 			},
 		})
 		if err != nil {
 			return err
 		}
-		deviceJSON, err := json.Marshal(device)
+		deviceJSON, err := json.Marshal(doc)
 		if err != nil {
 			return err
 		}
-		data += string(actionJSON) + "\n" + string(deviceJSON) + "\n"
+		data.Write(actionJSON)
+		data.WriteString("\n")
+		data.Write(deviceJSON)
+		data.WriteString("\n")
+	}
+
+	if data.Len() > 0 {
+		req := esapi.BulkRequest{
+			Body:     strings.NewReader(data.String()),
+			Pipeline: s.ingestPipeline.Name,
+		}
+		res, err := req.Do(ctx, s.client)
+		if err != nil {
+			return errors.Wrap(err, "failed to bulk index")
+		}
+		defer res.Body.Close()
+	}
+
+	if len(dropped) > 0 {
+		return errors.Wrapf(ErrAttributeTypeConflict,
+			"devices not indexed: %s", strings.Join(dropped, ", "))
+	}
+	return nil
+}
+
+// DeleteTenantDevices deletes all the tenant's devices, and their attribute
+// history if enabled; returns the number of devices deleted
+func (s *store) DeleteTenantDevices(ctx context.Context, tenantID string) (int, error) {
+	l := log.FromContext(ctx)
+
+	deleted, err := s.deleteByTenant(ctx, s.GetDevicesIndex(tenantID), tenantID)
+	if err != nil {
+		return 0, err
+	}
+	l.Infof("deleted %d devices of tenant %s", deleted, tenantID)
+
+	if historyIndex := s.GetHistoryIndex(tenantID); historyIndex != "" {
+		entries, err := s.deleteByTenant(ctx, historyIndex, tenantID)
+		if err != nil {
+			return deleted, err
+		}
+		l.Infof("deleted %d history entries of tenant %s", entries, tenantID)
+	}
+
+	return deleted, nil
+}
 
+// deleteByTenant synchronously deletes all the tenant's documents of the index
+func (s *store) deleteByTenant(ctx context.Context, index, tenantID string) (int, error) {
+	query := model.M{
+		"query": model.M{
+			"term": model.M{
+				"tenantID": tenantID,
+			},
+		},
 	}
-	req := esapi.BulkRequest{
-		Body: strings.NewReader(data),
+
+	refresh := true
+	req := esapi.DeleteByQueryRequest{
+		Index:     []string{index},
+		Routing:   []string{s.GetDevicesRoutingKey(tenantID)},
+		Body:      esutil.NewJSONReader(query),
+		Conflicts: "proceed",
+		Refresh:   &refresh,
 	}
+
 	res, err := req.Do(ctx, s.client)
 	if err != nil {
-		return errors.Wrap(err, "failed to bulk index")
+		return 0, errors.Wrap(err, "failed to delete the tenant's documents")
 	}
 	defer res.Body.Close()
 
-	return nil
+	if res.IsError() {
+		return 0, errors.Errorf(
+			"failed to delete the tenant's documents, code %d", res.StatusCode,
+		)
+	}
+
+	var deleteRes struct {
+		Deleted int `json:"deleted"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&deleteRes); err != nil {
+		return 0, errors.Wrap(err, "can't parse the delete response")
+	}
+
+	return deleteRes.Deleted, nil
 }
 
 func (s *store) Migrate(ctx context.Context) error {
 	indexName := s.GetDevicesIndex("")
+	err := s.migratePutILMPolicy(ctx)
+	if err == nil {
+		err = s.migratePutIngestPipeline(ctx)
+	}
+	if err == nil {
+		err = s.migrateDevicesIndex(ctx, indexName)
+	}
+	if err == nil {
+		err = s.migrateReadAlias(ctx, indexName)
+	}
+	if err == nil {
+		err = s.migrateTenantIndices(ctx)
+	}
+	if err == nil {
+		err = s.migrateHistoryIndex(ctx)
+	}
+	return err
+}
+
+// migrateDevicesIndex puts the index template of the devices index
+// indexName, and creates the index or updates its mapping
+func (s *store) migrateDevicesIndex(ctx context.Context, indexName string) error {
 	err := s.migratePutIndexTemplate(ctx, indexName)
 	if err == nil {
 		err = s.migrateCreateIndex(ctx, indexName)
@@ -276,14 +687,13 @@ func (s *store) migratePutIndexTemplate(ctx context.Context, indexName string) e
 	l := log.FromContext(ctx)
 	l.Infof("put the index template for %s", indexName)
 
-	template := fmt.Sprintf(indexDevicesTemplate,
-		indexName,
-		s.devicesIndexShards,
-		s.devicesIndexReplicas,
-	)
+	template, err := s.devicesIndexTemplate(indexName)
+	if err != nil {
+		return err
+	}
 	req := esapi.IndicesPutIndexTemplateRequest{
 		Name: indexName,
-		Body: strings.NewReader(template),
+		Body: esutil.NewJSONReader(template),
 	}
 
 	res, err := req.Do(ctx, s.client)
@@ -328,14 +738,159 @@ func (s *store) migrateCreateIndex(ctx context.Context, indexName string) error
 		}
 	} else if res.StatusCode != http.StatusOK {
 		return errors.New("failed to verify the index")
+	} else {
+		return s.migrateUpdateMapping(ctx, indexName)
 	}
 
 	return nil
 }
 
+// migrateUpdateMapping applies the non-breaking changes (new fields) of the
+// index template mappings to the existing index; breaking changes (type changes)
+// can't be applied in place, and require reindexing into a new index
+func (s *store) migrateUpdateMapping(ctx context.Context, indexName string) error {
+	l := log.FromContext(ctx)
+	l.Infof("verify the mapping of the index %s", indexName)
+
+	expected, err := s.templateMappingProperties(indexName)
+	if err != nil {
+		return err
+	}
+
+	current, err := s.getMappingProperties(ctx, indexName)
+	if err != nil {
+		return err
+	}
+
+	update, err := diffMappingProperties(current, expected)
+	if err != nil {
+		return errors.Wrapf(err, "can't update the mapping of the index %s", indexName)
+	}
+
+	if len(update) == 0 {
+		l.Infof("the mapping of the index %s is up to date", indexName)
+		return nil
+	}
+
+	l.Infof("update the mapping of the index %s, new fields: %v", indexName, update)
+
+	req := esapi.IndicesPutMappingRequest{
+		Index: []string{indexName},
+		Body: esutil.NewJSONReader(model.M{
+			"properties": update,
+		}),
+	}
+	res, err := req.Do(ctx, s.client)
+	if err != nil {
+		return errors.Wrap(err, "failed to update the index mapping")
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return errors.Errorf("failed to update the index mapping, code %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+// templateMappingProperties returns the 'properties' of the index template mappings
+func (s *store) templateMappingProperties(
+	indexName string,
+) (map[string]interface{}, error) {
+	template, err := s.devicesIndexTemplate(indexName)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl := template["template"].(map[string]interface{})
+	mappings := tmpl["mappings"].(map[string]interface{})
+	properties := mappings["properties"].(map[string]interface{})
+
+	return properties, nil
+}
+
+// getMappingProperties returns the 'properties' of the existing index mapping
+func (s *store) getMappingProperties(
+	ctx context.Context,
+	indexName string,
+) (map[string]interface{}, error) {
+	req := esapi.IndicesGetMappingRequest{
+		Index: []string{indexName},
+	}
+	res, err := req.Do(ctx, s.client)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get the index mapping")
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, errors.Errorf(
+			"failed to get the index mapping, code %d", res.StatusCode,
+		)
+	}
+
+	var mappingRes map[string]struct {
+		Mappings struct {
+			Properties map[string]interface{} `json:"properties"`
+		} `json:"mappings"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&mappingRes); err != nil {
+		return nil, errors.Wrap(err, "can't parse the index mapping")
+	}
+
+	index, ok := mappingRes[indexName]
+	if !ok {
+		return nil, errors.New("can't parse the index mapping")
+	}
+
+	return index.Mappings.Properties, nil
+}
+
+// diffMappingProperties returns the expected fields missing from the current mapping,
+// or ErrMappingConflict if a field is mapped with a different type
+func diffMappingProperties(
+	current, expected map[string]interface{},
+) (map[string]interface{}, error) {
+	update := map[string]interface{}{}
+
+	for field, exp := range expected {
+		cur, ok := current[field]
+		if !ok {
+			update[field] = exp
+			continue
+		}
+
+		curType := mappingType(cur)
+		expType := mappingType(exp)
+		if curType != expType {
+			return nil, errors.Wrapf(ErrMappingConflict,
+				"field %q is mapped as %q, expected %q; "+
+					"changing the type of a field requires reindexing "+
+					"into a new index",
+				field, curType, expType)
+		}
+	}
+
+	return update, nil
+}
+
+func mappingType(mapping interface{}) string {
+	m, ok := mapping.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	typ, _ := m["type"].(string)
+	return typ
+}
+
 func (s *store) Search(ctx context.Context, query interface{}) (model.M, error) {
 	l := log.FromContext(ctx)
 
+	// the caller's query may be reused, e.g. across pages
+	if q, ok := query.(model.Query); ok && len(s.sourceExcludes) > 0 {
+		query = q.Copy().WithSourceExcludes(s.sourceExcludes...)
+	}
+
 	var buf bytes.Buffer
 	if err := json.NewEncoder(&buf).Encode(query); err != nil {
 		return nil, err
@@ -345,21 +900,53 @@ func (s *store) Search(ctx context.Context, query interface{}) (model.M, error)
 
 	id := identity.FromContext(ctx)
 
-	resp, err := s.client.Search(
-		s.client.Search.WithContext(ctx),
-		s.client.Search.WithIndex(s.GetDevicesIndex(id.Tenant)),
-		s.client.Search.WithRouting(s.GetDevicesRoutingKey(id.Tenant)),
+	opts := []func(*esapi.SearchRequest){
+		s.client.Search.WithContext(withIdempotent(ctx)),
 		s.client.Search.WithBody(&buf),
-		s.client.Search.WithTrackTotalHits(true),
-	)
-	defer resp.Body.Close()
+	}
+	// the point in time determines the index and the routing, and
+	// ES rejects searches with a point in time which set them
+	if q, ok := query.(model.Query); !ok || q.PointInTime() == "" {
+		opts = append(opts,
+			s.client.Search.WithIndex(s.GetDevicesReadIndex(id.Tenant)),
+			s.client.Search.WithRouting(s.GetDevicesRoutingKey(id.Tenant)),
+		)
+	}
+	var trackTotalHits interface{} = true
+	if q, ok := query.(model.Query); ok && q.TrackTotalHits() != nil {
+		trackTotalHits = q.TrackTotalHits()
+	}
+	opts = append(opts, s.client.Search.WithTrackTotalHits(trackTotalHits))
+	if q, ok := query.(model.Query); ok {
+		if q.Preference() != "" && q.PointInTime() == "" {
+			opts = append(opts, s.client.Search.WithPreference(q.Preference()))
+		}
+		if q.SearchType() != "" {
+			opts = append(opts, s.client.Search.WithSearchType(q.SearchType()))
+		}
+	}
 
+	resp, err := s.client.Search(opts...)
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 
 	if resp.IsError() {
-		return nil, errors.New(resp.String())
+		body, _ := ioutil.ReadAll(resp.Body)
+		errRes := parseErrorResponse(body)
+		if errRes.hasCause(esTooManyBucketsException) {
+			return nil, ErrTooManyBuckets
+		}
+		if errRes.hasCause(esSearchContextMissingException) {
+			return nil, ErrPointInTimeNotFound
+		}
+		if cause := errRes.cause(esQueryShardException); cause != nil &&
+			resp.StatusCode == http.StatusBadRequest {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidQuery, cause.Reason)
+		}
+		return nil, errors.Errorf("[%d %s] %s",
+			resp.StatusCode, http.StatusText(resp.StatusCode), body)
 	}
 
 	var ret map[string]interface{}
@@ -370,6 +957,57 @@ func (s *store) Search(ctx context.Context, query interface{}) (model.M, error)
 	return ret, nil
 }
 
+// ES exception types translated to typed errors
+const (
+	esTooManyBucketsException = "too_many_buckets_exception"
+	// esQueryShardException is the failure to create the query,
+	// e.g. a value which can't be parsed as the field's type
+	esQueryShardException = "query_shard_exception"
+)
+
+// esErrorCause is an ES exception
+type esErrorCause struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+// esErrorResponse is the body of the ES error responses; the exception is
+// reported either as the root cause or as the cause of e.g. a search phase
+// failure
+type esErrorResponse struct {
+	Error struct {
+		esErrorCause
+		RootCause []esErrorCause `json:"root_cause"`
+		CausedBy  *esErrorCause  `json:"caused_by"`
+	} `json:"error"`
+}
+
+func parseErrorResponse(body []byte) *esErrorResponse {
+	var errRes esErrorResponse
+	_ = json.Unmarshal(body, &errRes)
+	return &errRes
+}
+
+// cause returns the exception of type typ, if any
+func (r *esErrorResponse) cause(typ string) *esErrorCause {
+	if r.Error.Type == typ {
+		return &r.Error.esErrorCause
+	}
+	if r.Error.CausedBy != nil && r.Error.CausedBy.Type == typ {
+		return r.Error.CausedBy
+	}
+	for i := range r.Error.RootCause {
+		if r.Error.RootCause[i].Type == typ {
+			return &r.Error.RootCause[i]
+		}
+	}
+	return nil
+}
+
+func (r *esErrorResponse) hasCause(typ string) bool {
+	return r.cause(typ) != nil
+}
+
 func (s *store) GetDevice(ctx context.Context, tenant, devid string) (*model.Device, error) {
 	//l := log.FromContext(ctx)
 
@@ -377,7 +1015,7 @@ func (s *store) GetDevice(ctx context.Context, tenant, devid string) (*model.Dev
 
 	req := esapi.GetRequest{
 		Index:      s.GetDevicesIndex(id.Tenant),
-		Routing:    s.GetDevicesRoutingKey(id.Tenant),
+		Routing:    s.readRoutingKey(ctx, id.Tenant),
 		DocumentID: devid,
 	}
 
@@ -408,8 +1046,15 @@ func (s *store) GetDevice(ctx context.Context, tenant, devid string) (*model.Dev
 		return nil, errors.New("can't process ES _source")
 	}
 
-	return model.NewDeviceFromEsSource(source)
-
+	dev, err := model.NewDeviceFromEsSource(source)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := model.ParseDeviceMeta(storeRes)
+	if err != nil {
+		return nil, err
+	}
+	return dev.WithMeta(meta), nil
 }
 
 type mgetDocs struct {
@@ -422,34 +1067,93 @@ type mgetDoc struct {
 	Routing string `json:"routing"`
 }
 
+// GetDevices fetches the whole devices, incl. the fields of the source
+// excludes, e.g. as the base of the updates which replace the documents
 func (s *store) GetDevices(ctx context.Context,
 	tenantDevs map[string][]string) ([]model.Device, error) {
-	l := log.FromContext(ctx)
+	return s.getDevices(ctx, tenantDevs, nil, nil)
+}
 
-	body := mgetDocs{
-		Docs: []mgetDoc{},
+// GetDevicesAttributes fetches the devices to be returned to the clients:
+// only the attributes attrs (plus the device and tenant IDs), to cut down
+// the payload, or the whole devices if no attrs, and never the fields of
+// the source excludes
+func (s *store) GetDevicesAttributes(
+	ctx context.Context,
+	tenantDevs map[string][]string,
+	attrs []model.SelectAttribute,
+) ([]model.Device, error) {
+	return s.getDevices(ctx, tenantDevs, attrs, s.sourceExcludes)
+}
+
+// getDevices fetches the devices in sequential mget requests of up to
+// mgetBatchSize ids, and returns them in order, the tenants sorted by id
+func (s *store) getDevices(
+	ctx context.Context,
+	tenantDevs map[string][]string,
+	attrs []model.SelectAttribute,
+	excludes []string,
+) ([]model.Device, error) {
+	tenants := make([]string, 0, len(tenantDevs))
+	for tid := range tenantDevs {
+		tenants = append(tenants, tid)
 	}
+	sort.Strings(tenants)
 
-	for tid, devs := range tenantDevs {
-		for _, d := range devs {
-			body.Docs = append(body.Docs, mgetDoc{
+	docs := []mgetDoc{}
+	for _, tid := range tenants {
+		for _, d := range tenantDevs[tid] {
+			docs = append(docs, mgetDoc{
 				d,
 				s.GetDevicesIndex(tid),
-				s.GetDevicesRoutingKey(tid),
+				s.readRoutingKey(ctx, tid),
 			})
 		}
 	}
 
-	data, err := json.Marshal(body)
+	ret := []model.Device{}
+	if len(docs) == 0 {
+		return ret, nil
+	}
+	batchSize := s.mgetBatchSize
+	if batchSize <= 0 {
+		batchSize = len(docs)
+	}
+	for start := 0; start < len(docs); start += batchSize {
+		end := start + batchSize
+		if end > len(docs) {
+			end = len(docs)
+		}
+		devs, err := s.mgetDevices(ctx, docs[start:end], attrs, excludes)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, devs...)
+	}
+	return ret, nil
+}
+
+// mgetDevices fetches the devices docs in a single mget request
+func (s *store) mgetDevices(
+	ctx context.Context,
+	docs []mgetDoc,
+	attrs []model.SelectAttribute,
+	excludes []string,
+) ([]model.Device, error) {
+	l := log.FromContext(ctx)
+
+	data, err := json.Marshal(mgetDocs{Docs: docs})
 	if err != nil {
 		return nil, err
 	}
 
 	req := esapi.MgetRequest{
-		Body: bytes.NewReader(data),
+		Body:           bytes.NewReader(data),
+		SourceExcludes: excludes,
+		SourceIncludes: sourceIncludes(attrs),
 	}
 
-	res, err := req.Do(ctx, s.client)
+	res, err := req.Do(withIdempotent(ctx), s.client)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to mget devices")
 	}
@@ -493,11 +1197,11 @@ func (s *store) GetDevices(ctx context.Context,
 				return nil, errors.Wrap(err, "can't parse _source into model")
 			}
 
-			dev = dev.WithMeta(&model.DeviceMeta{
-				SeqNo:       int64(docM["_seq_no"].(float64)),
-				PrimaryTerm: int64(docM["_primary_term"].(float64)),
-			})
-			ret = append(ret, *dev)
+			meta, err := model.ParseDeviceMeta(docM)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, *dev.WithMeta(meta))
 		}
 
 		// source not parsed after all - maybe doc triggered an error
@@ -528,12 +1232,79 @@ func (s *store) GetDevices(ctx context.Context,
 	return ret, nil
 }
 
+// DevicesExist returns the device ids of the tenant which are indexed, in
+// the order of deviceIDs; the documents aren't fetched, only their ids
+func (s *store) DevicesExist(
+	ctx context.Context,
+	tenantID string,
+	deviceIDs []string,
+) ([]string, error) {
+	body := mgetDocs{
+		Docs: make([]mgetDoc, 0, len(deviceIDs)),
+	}
+	for _, d := range deviceIDs {
+		body.Docs = append(body.Docs, mgetDoc{
+			d,
+			s.GetDevicesIndex(tenantID),
+			s.GetDevicesRoutingKey(tenantID),
+		})
+	}
+
+	req := esapi.MgetRequest{
+		Body:   esutil.NewJSONReader(body),
+		Source: []string{"false"},
+	}
+
+	res, err := req.Do(withIdempotent(ctx), s.client)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to mget devices")
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, errors.Errorf("failed to mget devices, code %d", res.StatusCode)
+	}
+
+	var mgetRes struct {
+		Docs []struct {
+			ID    string `json:"_id"`
+			Found bool   `json:"found"`
+			Error *struct {
+				Type string `json:"type"`
+			} `json:"error"`
+		} `json:"docs"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&mgetRes); err != nil {
+		return nil, errors.Wrap(err, "can't parse the mget response")
+	}
+
+	ids := []string{}
+	for _, doc := range mgetRes.Docs {
+		// no device exists before the index is created
+		if doc.Error != nil && doc.Error.Type != "index_not_found_exception" {
+			return nil, errors.New("unexpected error " + doc.Error.Type)
+		}
+		if doc.Found {
+			ids = append(ids, doc.ID)
+		}
+	}
+	return ids, nil
+}
+
 func (s *store) UpdateDevice(ctx context.Context,
 	tenantID,
 	deviceID string,
 	updateDev *model.Device) error {
 	l := log.FromContext(ctx)
 
+	updateDev, err := s.prepareDevice(ctx, updateDev)
+	if err != nil {
+		return err
+	}
+	if updateDev.Meta != nil && updateDev.Meta.Version > 0 {
+		return s.updateDeviceVersioned(ctx, tenantID, deviceID, updateDev)
+	}
+
 	body := map[string]interface{}{
 		"doc": updateDev,
 	}
@@ -569,6 +1340,108 @@ func (s *store) UpdateDevice(ctx context.Context,
 	}
 }
 
+// updateDeviceVersioned applies the partial update to the stored device,
+// and writes it back with the update's external version; the update API
+// doesn't support external versioning, so it's a get followed by an index,
+// which ES rejects if a newer version was written in between
+func (s *store) updateDeviceVersioned(ctx context.Context,
+	tenantID,
+	deviceID string,
+	updateDev *model.Device) error {
+	req := esapi.GetRequest{
+		Index:      s.GetDevicesIndex(tenantID),
+		Routing:    s.GetDevicesRoutingKey(tenantID),
+		DocumentID: deviceID,
+	}
+	res, err := req.Do(ctx, s.client)
+	if err != nil {
+		return errors.Wrap(err, "failed to get device from ES")
+	}
+	defer res.Body.Close()
+
+	var getRes struct {
+		Version int64                  `json:"_version"`
+		Source  map[string]interface{} `json:"_source"`
+	}
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		getRes.Source = map[string]interface{}{}
+	case res.IsError():
+		return errors.Errorf("failed to get device from ES, code %d", res.StatusCode)
+	default:
+		if err := json.NewDecoder(res.Body).Decode(&getRes); err != nil {
+			return errors.Wrap(err, "can't parse ES device")
+		}
+	}
+	if getRes.Version >= updateDev.Meta.Version {
+		return ErrStaleUpdate
+	}
+
+	b, err := json.Marshal(updateDev)
+	if err != nil {
+		return err
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return err
+	}
+	for k, v := range doc {
+		getRes.Source[k] = v
+	}
+
+	version := int(updateDev.Meta.Version)
+	indexReq := esapi.IndexRequest{
+		Index:       s.GetDevicesIndex(tenantID),
+		Routing:     s.GetDevicesRoutingKey(tenantID),
+		DocumentID:  deviceID,
+		Body:        esutil.NewJSONReader(getRes.Source),
+		Version:     &version,
+		VersionType: "external",
+	}
+	indexRes, err := indexReq.Do(ctx, s.client)
+	if err != nil {
+		return errors.Wrap(err, "failed to update device in ES")
+	}
+	defer indexRes.Body.Close()
+
+	switch {
+	case indexRes.StatusCode == http.StatusConflict:
+		return ErrStaleUpdate
+	case indexRes.IsError():
+		return errors.Errorf("failed to update device in ES, code %d",
+			indexRes.StatusCode)
+	default:
+		return nil
+	}
+}
+
+// GetVersion returns the version of the Elasticsearch cluster
+func (s *store) GetVersion(ctx context.Context) (string, error) {
+	req := esapi.InfoRequest{}
+
+	res, err := req.Do(ctx, s.client)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get the cluster info")
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return "", errors.Errorf("failed to get the cluster info, code %d",
+			res.StatusCode)
+	}
+
+	var info struct {
+		Version struct {
+			Number string `json:"number"`
+		} `json:"version"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&info); err != nil {
+		return "", errors.Wrap(err, "can't parse the cluster info")
+	}
+
+	return info.Version.Number, nil
+}
+
 // GetDevIndex retrieves the "devices*" index definition for tenant 'tid'
 // existing fields, incl. inventory attributes, are found under 'properties'
 // see: https://www.elastic.co/guide/en/elasticsearch/reference/current/indices-get-index.html
@@ -598,7 +1471,15 @@ func (s *store) GetDevIndex(ctx context.Context, tid string) (map[string]interfa
 		return nil, err
 	}
 
+	// the response is keyed by the concrete index, which differs from
+	// the devices index name if the latter is an alias, e.g. after an
+	// ILM shrink
 	index, ok := indexRes[idx]
+	if !ok && len(indexRes) == 1 {
+		for _, v := range indexRes {
+			index, ok = v, true
+		}
+	}
 	if !ok {
 		return nil, errors.New("can't parse index defintion response")
 	}
@@ -613,8 +1494,30 @@ func (s *store) GetDevIndex(ctx context.Context, tid string) (map[string]interfa
 	return indexM, nil
 }
 
-// GetDevicesIndex returns the index name for the tenant tid
+// sourceIncludes returns the '_source' fields of the attributes attrs,
+// of any type; the device and tenant IDs are always included
+func sourceIncludes(attrs []model.SelectAttribute) []string {
+	if len(attrs) == 0 {
+		return nil
+	}
+
+	includes := []string{"id", "tenantID"}
+	for _, a := range attrs {
+		includes = append(includes,
+			model.ToAttr(a.Scope, a.Attribute, model.TypeStr),
+			model.ToAttr(a.Scope, a.Attribute, model.TypeNum),
+			model.ToAttr(a.Scope, a.Attribute, model.TypeBool),
+		)
+	}
+	return includes
+}
+
+// GetDevicesIndex returns the index name for the tenant tid: its dedicated
+// index, if any, or else the shared one
 func (s *store) GetDevicesIndex(tid string) string {
+	if index, ok := s.tenantIndices[tid]; ok {
+		return index.Name
+	}
 	return s.devicesIndexName
 }
 
@@ -0,0 +1,788 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+
+	"github.com/mendersoftware/reporting/model"
+)
+
+// newTestStore returns a store backed by a fake Elasticsearch server;
+// the product check and ping requests are answered by the fake itself,
+// all other requests are passed to handler
+func newTestStore(
+	t *testing.T,
+	handler http.HandlerFunc,
+	opts ...StoreOption,
+) *store {
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Elastic-Product", "Elasticsearch")
+			w.Header().Set("Content-Type", "application/json")
+			if r.URL.Path == "/" {
+				_, _ = w.Write([]byte(`{"version":{"number":"7.15.1"}}`))
+				return
+			}
+			handler(w, r)
+		}))
+	t.Cleanup(srv.Close)
+
+	opts = append([]StoreOption{
+		WithServerAddresses([]string{srv.URL}),
+		WithDevicesIndexName("devices"),
+	}, opts...)
+
+	s, err := NewStore(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s.(*store)
+}
+
+func TestGetDevicesIndexNotFound(t *testing.T) {
+	s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/_mget", r.URL.Path)
+		_, _ = w.Write([]byte(`{"docs": [{
+			"_index": "devices",
+			"_id": "dev1",
+			"_seq_no": 1,
+			"_primary_term": 1,
+			"found": true,
+			"_source": {"id": "dev1", "tenantID": "tenant1"}
+		}, {
+			"_index": "devices",
+			"_id": "dev2",
+			"found": false
+		}, {
+			"_index": "devices-tenant3",
+			"_id": "dev3",
+			"error": {"type": "index_not_found_exception"}
+		}]}`))
+	})
+
+	devs, err := s.GetDevices(context.Background(), map[string][]string{
+		"tenant1": {"dev1", "dev2"},
+		"tenant3": {"dev3"},
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, devs, 1) {
+		assert.Equal(t, "dev1", devs[0].GetID())
+		assert.Equal(t, "tenant1", devs[0].GetTenantID())
+	}
+}
+
+func TestGetDevIndex(t *testing.T) {
+	testCases := map[string]struct {
+		res string
+
+		settings interface{}
+		err      string
+	}{
+		"ok": {
+			res: `{"devices": {"settings": {"index": {"number_of_shards": "1"}}}}`,
+
+			settings: map[string]interface{}{"number_of_shards": "1"},
+		},
+		"ok, alias": {
+			res: `{"shrink-devices": {"settings": {"index": {"number_of_shards": "1"}}}}`,
+
+			settings: map[string]interface{}{"number_of_shards": "1"},
+		},
+		"error, alias of several indices": {
+			res: `{"devices-1": {}, "devices-2": {}}`,
+
+			err: "can't parse index defintion response",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "/devices", r.URL.Path)
+				_, _ = w.Write([]byte(tc.res))
+			})
+
+			index, err := s.GetDevIndex(context.Background(), "tenant1")
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+			} else if assert.NoError(t, err) {
+				settings := index["settings"].(map[string]interface{})
+				assert.Equal(t, tc.settings, settings["index"])
+			}
+		})
+	}
+}
+
+func TestGetDeviceMeta(t *testing.T) {
+	testCases := map[string]struct {
+		body string
+
+		meta *model.DeviceMeta
+		err  string
+	}{
+		"ok": {
+			body: `{
+				"_index": "devices",
+				"_id": "dev1",
+				"_seq_no": 12,
+				"_primary_term": 3,
+				"found": true,
+				"_source": {"id": "dev1", "tenantID": "tenant1"}
+			}`,
+			meta: &model.DeviceMeta{SeqNo: 12, PrimaryTerm: 3},
+		},
+		"error, no seq_no": {
+			body: `{
+				"_index": "devices",
+				"_id": "dev1",
+				"found": true,
+				"_source": {"id": "dev1", "tenantID": "tenant1"}
+			}`,
+			err: "can't process ES _seq_no",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "/devices/_doc/dev1", r.URL.Path)
+				_, _ = w.Write([]byte(tc.body))
+			})
+
+			ctx := identity.WithContext(context.Background(),
+				&identity.Identity{Tenant: "tenant1"})
+			dev, err := s.GetDevice(ctx, "tenant1", "dev1")
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+			} else if assert.NoError(t, err) {
+				assert.Equal(t, "dev1", dev.GetID())
+				assert.Equal(t, tc.meta, dev.Meta)
+			}
+		})
+	}
+}
+
+func TestDevicesExist(t *testing.T) {
+	var body map[string]interface{}
+	s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/_mget", r.URL.Path)
+		assert.Equal(t, "false", r.URL.Query().Get("_source"))
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		_, _ = w.Write([]byte(`{"docs": [{
+			"_index": "devices",
+			"_id": "dev1",
+			"_seq_no": 1,
+			"_primary_term": 1,
+			"found": true
+		}, {
+			"_index": "devices",
+			"_id": "dev2",
+			"found": false
+		}, {
+			"_index": "devices",
+			"_id": "dev3",
+			"_seq_no": 4,
+			"_primary_term": 1,
+			"found": true
+		}]}`))
+	})
+
+	ids, err := s.DevicesExist(context.Background(), "tenant1",
+		[]string{"dev1", "dev2", "dev3"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"dev1", "dev3"}, ids)
+	if docs, ok := body["docs"].([]interface{}); assert.True(t, ok) && assert.Len(t, docs, 3) {
+		assert.Equal(t, map[string]interface{}{
+			"_id":     "dev2",
+			"_index":  "devices",
+			"routing": "tenant1",
+		}, docs[1])
+	}
+}
+
+func TestDevicesExistIndexNotFound(t *testing.T) {
+	s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"docs": [{
+			"_index": "devices",
+			"_id": "dev1",
+			"error": {"type": "index_not_found_exception"}
+		}]}`))
+	})
+
+	ids, err := s.DevicesExist(context.Background(), "tenant1", []string{"dev1"})
+	assert.NoError(t, err)
+	assert.Empty(t, ids)
+}
+
+func TestGetDevicesAttributes(t *testing.T) {
+	s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/_mget", r.URL.Path)
+		assert.Equal(t, "id,tenantID,"+
+			"inventory_os_str,inventory_os_num,inventory_os_bool,"+
+			"identity_mac_str,identity_mac_num,identity_mac_bool",
+			r.URL.Query().Get("_source_includes"))
+		_, _ = w.Write([]byte(`{"docs": [{
+			"_index": "devices",
+			"_id": "dev1",
+			"_seq_no": 1,
+			"_primary_term": 1,
+			"found": true,
+			"_source": {
+				"id": "dev1",
+				"tenantID": "tenant1",
+				"inventory_os_str": ["linux"]
+			}
+		}]}`))
+	})
+
+	devs, err := s.GetDevicesAttributes(context.Background(), map[string][]string{
+		"tenant1": {"dev1"},
+	}, []model.SelectAttribute{
+		{Scope: "inventory", Attribute: "os"},
+		{Scope: "identity", Attribute: "mac"},
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, devs, 1) {
+		assert.Equal(t, "dev1", devs[0].GetID())
+		assert.Equal(t, "tenant1", devs[0].GetTenantID())
+		assert.Empty(t, devs[0].IdentityAttributes)
+		if assert.Len(t, devs[0].InventoryAttributes, 1) {
+			assert.Equal(t, "os", devs[0].InventoryAttributes[0].Name)
+		}
+	}
+}
+
+func TestGetDevicesFullSource(t *testing.T) {
+	s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/_mget", r.URL.Path)
+		_, ok := r.URL.Query()["_source_includes"]
+		assert.False(t, ok)
+		_, _ = w.Write([]byte(`{"docs": []}`))
+	})
+
+	devs, err := s.GetDevices(context.Background(), map[string][]string{
+		"tenant1": {"dev1"},
+	})
+	assert.NoError(t, err)
+	assert.Empty(t, devs)
+}
+
+func TestGetDevicesSourceExcludes(t *testing.T) {
+	var excludes []string
+	s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/_mget", r.URL.Path)
+		excludes = append(excludes, r.URL.Query().Get("_source_excludes"))
+		_, _ = w.Write([]byte(`{"docs": []}`))
+	}, WithSourceExcludes([]string{"inventory_blob*"}))
+
+	// the whole devices are the base of the updates, which would
+	// otherwise drop the excluded fields
+	_, err := s.GetDevices(context.Background(), map[string][]string{
+		"tenant1": {"dev1"},
+	})
+	assert.NoError(t, err)
+	_, err = s.GetDevicesAttributes(context.Background(), map[string][]string{
+		"tenant1": {"dev1"},
+	}, nil)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"", "inventory_blob*"}, excludes)
+}
+
+func TestGetDevicesBatches(t *testing.T) {
+	var batches [][]string
+	s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/_mget", r.URL.Path)
+		var body mgetDocs
+		_ = json.NewDecoder(r.Body).Decode(&body)
+
+		// echo the requested devices back
+		ids := []string{}
+		docs := []map[string]interface{}{}
+		for _, doc := range body.Docs {
+			ids = append(ids, doc.ID)
+			docs = append(docs, map[string]interface{}{
+				"_index":        doc.Index,
+				"_id":           doc.ID,
+				"_seq_no":       1,
+				"_primary_term": 1,
+				"found":         true,
+				"_source": map[string]interface{}{
+					"id":       doc.ID,
+					"tenantID": doc.Routing,
+				},
+			})
+		}
+		batches = append(batches, ids)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"docs": docs})
+	}, WithMgetBatchSize(2))
+
+	devs, err := s.GetDevices(context.Background(), map[string][]string{
+		"tenant2": {"dev4", "dev5"},
+		"tenant1": {"dev1", "dev2", "dev3"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{
+		{"dev1", "dev2"},
+		{"dev3", "dev4"},
+		{"dev5"},
+	}, batches)
+
+	ids := []string{}
+	for _, dev := range devs {
+		ids = append(ids, dev.GetTenantID()+"/"+dev.GetID())
+	}
+	assert.Equal(t, []string{
+		"tenant1/dev1", "tenant1/dev2", "tenant1/dev3",
+		"tenant2/dev4", "tenant2/dev5",
+	}, ids)
+
+	// no devices, no requests
+	batches = nil
+	devs, err = s.GetDevices(context.Background(), map[string][]string{})
+	assert.NoError(t, err)
+	assert.Empty(t, devs)
+	assert.Empty(t, batches)
+}
+
+func TestGetVersion(t *testing.T) {
+	// the fake server answers GET / itself
+	s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+	})
+
+	version, err := s.GetVersion(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "7.15.1", version)
+}
+
+func TestSearchTooManyBuckets(t *testing.T) {
+	testCases := map[string]struct {
+		code int
+		body string
+
+		err error
+	}{
+		"too many buckets, root cause": {
+			code: http.StatusBadRequest,
+			body: `{"error": {
+				"root_cause": [{"type": "too_many_buckets_exception"}],
+				"type": "too_many_buckets_exception"
+			}, "status": 400}`,
+			err: ErrTooManyBuckets,
+		},
+		"too many buckets, search phase failure": {
+			code: http.StatusServiceUnavailable,
+			body: `{"error": {
+				"root_cause": [],
+				"type": "search_phase_execution_exception",
+				"caused_by": {"type": "too_many_buckets_exception"}
+			}, "status": 503}`,
+			err: ErrTooManyBuckets,
+		},
+		"invalid query": {
+			code: http.StatusBadRequest,
+			body: `{"error": {
+				"root_cause": [{
+					"type": "query_shard_exception",
+					"reason": "failed to create query: Illegal version string: 5"
+				}],
+				"type": "search_phase_execution_exception",
+				"reason": "all shards failed"
+			}, "status": 400}`,
+			err: fmt.Errorf("%w: failed to create query: Illegal version string: 5",
+				ErrInvalidQuery),
+		},
+		"other error": {
+			code: http.StatusBadRequest,
+			body: `{"error": {"type": "parsing_exception"}, "status": 400}`,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "/devices/_search", r.URL.Path)
+				w.WriteHeader(tc.code)
+				_, _ = w.Write([]byte(tc.body))
+			}, WithRetryPolicy(RetryPolicy{}))
+
+			ctx := identity.WithContext(context.Background(),
+				&identity.Identity{Tenant: "tenant1"})
+			_, err := s.Search(ctx, model.NewQuery())
+			if tc.err != nil {
+				assert.Equal(t, tc.err, err)
+			} else {
+				assert.Error(t, err)
+				assert.NotEqual(t, ErrTooManyBuckets, err)
+				assert.False(t, errors.Is(err, ErrInvalidQuery))
+			}
+		})
+	}
+}
+
+func TestSearchTrackTotalHits(t *testing.T) {
+	testCases := map[string]struct {
+		query interface{}
+
+		param string
+	}{
+		"default": {
+			query: model.NewQuery(),
+			param: "true",
+		},
+		"raw query": {
+			query: model.M{"query": model.M{"match_all": model.M{}}},
+			param: "true",
+		},
+		"not counted": {
+			query: model.NewQuery().WithTrackTotalHits(false),
+			param: "false",
+		},
+		"threshold": {
+			query: model.NewQuery().WithTrackTotalHits(100),
+			param: "100",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, tc.param, r.URL.Query().Get("track_total_hits"))
+				_, _ = w.Write([]byte(`{"hits":{"hits":[]}}`))
+			})
+
+			ctx := identity.WithContext(context.Background(),
+				&identity.Identity{Tenant: "tenant1"})
+			_, err := s.Search(ctx, tc.query)
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestIndexDeviceVersioned(t *testing.T) {
+	testCases := map[string]struct {
+		version int64
+		stored  int64
+
+		err error
+	}{
+		"ok, newer version": {
+			version: 5,
+			stored:  4,
+		},
+		"stale, older version": {
+			version: 3,
+			stored:  4,
+			err:     ErrStaleUpdate,
+		},
+		"stale, same version": {
+			version: 4,
+			stored:  4,
+			err:     ErrStaleUpdate,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, http.MethodPut, r.Method)
+				assert.Equal(t, "/devices/_doc/dev1", r.URL.Path)
+				assert.Equal(t, "external", r.URL.Query().Get("version_type"))
+				assert.Equal(t, strconv.FormatInt(tc.version, 10),
+					r.URL.Query().Get("version"))
+
+				// external versioning: only newer versions are written
+				if tc.version <= tc.stored {
+					w.WriteHeader(http.StatusConflict)
+					_, _ = w.Write([]byte(`{"error": {
+						"type": "version_conflict_engine_exception"
+					}, "status": 409}`))
+					return
+				}
+				_, _ = w.Write([]byte(`{"result": "updated"}`))
+			})
+
+			dev := model.NewDevice("dev1").
+				SetTenantID("tenant1").
+				WithMeta(&model.DeviceMeta{Version: tc.version})
+			err := s.IndexDevice(context.Background(), dev)
+			assert.Equal(t, tc.err, err)
+		})
+	}
+}
+
+func TestIndexDevice(t *testing.T) {
+	testCases := map[string]struct {
+		code int
+		body string
+
+		err string
+	}{
+		"ok, created": {
+			code: http.StatusCreated,
+			body: `{"result": "created"}`,
+		},
+		"ok, updated": {
+			code: http.StatusOK,
+			body: `{"result": "updated"}`,
+		},
+		"error": {
+			code: http.StatusBadRequest,
+			body: `{"error":{"type":"mapper_parsing_exception"},"status":400}`,
+			err: "failed to index, code 400: " +
+				`{"error":{"type":"mapper_parsing_exception"},"status":400}`,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, http.MethodPut, r.Method)
+				assert.Equal(t, "/devices/_doc/dev1", r.URL.Path)
+				w.WriteHeader(tc.code)
+				_, _ = w.Write([]byte(tc.body))
+			}, WithRetryPolicy(RetryPolicy{}))
+
+			dev := model.NewDevice("dev1").SetTenantID("tenant1")
+			err := s.IndexDevice(context.Background(), dev)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestCreateDevice(t *testing.T) {
+	testCases := map[string]struct {
+		exists bool
+
+		err error
+	}{
+		"ok, absent": {},
+		"error, already exists": {
+			exists: true,
+			err:    ErrDeviceExists,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, http.MethodPut, r.Method)
+				assert.Equal(t, "/devices/_doc/dev1", r.URL.Path)
+				assert.Equal(t, "create", r.URL.Query().Get("op_type"))
+				assert.Equal(t, "tenant1", r.URL.Query().Get("routing"))
+				// create doesn't support the external versioning
+				assert.Empty(t, r.URL.Query().Get("version_type"))
+
+				if tc.exists {
+					w.WriteHeader(http.StatusConflict)
+					_, _ = w.Write([]byte(`{"error": {
+						"type": "version_conflict_engine_exception"
+					}, "status": 409}`))
+					return
+				}
+				w.WriteHeader(http.StatusCreated)
+				_, _ = w.Write([]byte(`{"result": "created"}`))
+			})
+
+			dev := model.NewDevice("dev1").
+				SetTenantID("tenant1").
+				WithMeta(&model.DeviceMeta{Version: 5})
+			err := s.CreateDevice(context.Background(), dev)
+			assert.Equal(t, tc.err, err)
+		})
+	}
+}
+
+func TestStoreOpaqueID(t *testing.T) {
+	testCases := map[string]struct {
+		opaqueID string
+	}{
+		"ok": {
+			opaqueID: "reporting/reporting-5d8f7b9c4-x2x7q",
+		},
+		"ok, not set": {},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var requests int
+			s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				assert.Equal(t, tc.opaqueID, r.Header.Get("X-Opaque-Id"))
+				w.WriteHeader(http.StatusCreated)
+				_, _ = w.Write([]byte(`{"result": "created"}`))
+			}, WithOpaqueID(tc.opaqueID))
+
+			dev := model.NewDevice("dev1").SetTenantID("tenant1")
+			err := s.CreateDevice(context.Background(), dev)
+			assert.NoError(t, err)
+			err = s.IndexDevice(context.Background(), dev)
+			assert.NoError(t, err)
+			assert.Equal(t, 2, requests)
+		})
+	}
+}
+
+func TestUpdateDeviceVersioned(t *testing.T) {
+	testCases := map[string]struct {
+		version int64
+		stored  int64
+
+		indexed bool
+		err     error
+	}{
+		"ok, newer version": {
+			version: 5,
+			stored:  4,
+			indexed: true,
+		},
+		"stale, older version": {
+			version: 3,
+			stored:  4,
+			err:     ErrStaleUpdate,
+		},
+		"stale, newer version written concurrently": {
+			version: 5,
+			stored:  4,
+			indexed: true,
+			err:     ErrStaleUpdate,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var indexed map[string]interface{}
+			s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "/devices/_doc/dev1", r.URL.Path)
+				switch r.Method {
+				case http.MethodGet:
+					_, _ = w.Write([]byte(`{
+						"_id": "dev1",
+						"_version": ` + strconv.FormatInt(tc.stored, 10) + `,
+						"found": true,
+						"_source": {
+							"id": "dev1",
+							"tenantID": "tenant1",
+							"inventory_os_str": ["linux"]
+						}
+					}`))
+				case http.MethodPut:
+					assert.Equal(t, "external", r.URL.Query().Get("version_type"))
+					_ = json.NewDecoder(r.Body).Decode(&indexed)
+					if tc.err != nil {
+						w.WriteHeader(http.StatusConflict)
+						_, _ = w.Write([]byte(`{"status": 409}`))
+						return
+					}
+					_, _ = w.Write([]byte(`{"result": "updated"}`))
+				default:
+					t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+				}
+			})
+
+			dev := model.NewDevice("dev1").
+				SetTenantID("tenant1").
+				WithMeta(&model.DeviceMeta{Version: tc.version})
+			_ = dev.AppendAttr(model.NewInventoryAttribute("inventory").
+				SetName("kernel").
+				SetString("5.15"))
+
+			err := s.UpdateDevice(context.Background(), "tenant1", "dev1", dev)
+			assert.Equal(t, tc.err, err)
+			if tc.indexed {
+				// the update is applied on top of the stored device
+				assert.Equal(t, []interface{}{"linux"}, indexed["inventory_os_str"])
+				assert.Equal(t, []interface{}{"5.15"}, indexed["inventory_kernel_str"])
+			} else {
+				assert.Nil(t, indexed)
+			}
+		})
+	}
+}
+
+func TestDeleteTenantDevices(t *testing.T) {
+	testCases := map[string]struct {
+		history bool
+		code    int
+
+		paths   []string
+		deleted int
+		err     bool
+	}{
+		"ok": {
+			code:    http.StatusOK,
+			paths:   []string{"/devices/_delete_by_query"},
+			deleted: 3,
+		},
+		"ok, with history": {
+			history: true,
+			code:    http.StatusOK,
+			paths: []string{
+				"/devices/_delete_by_query",
+				"/devices-history/_delete_by_query",
+			},
+			deleted: 3,
+		},
+		"error": {
+			code:  http.StatusBadRequest,
+			paths: []string{"/devices/_delete_by_query"},
+			err:   true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var paths []string
+			opts := []StoreOption{WithRetryPolicy(RetryPolicy{})}
+			if tc.history {
+				opts = append(opts, WithHistoryIndexName("devices-history"))
+			}
+			s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+				paths = append(paths, r.URL.Path)
+				assert.Equal(t, http.MethodPost, r.Method)
+				assert.Equal(t, "tenant1", r.URL.Query().Get("routing"))
+				assert.Equal(t, "proceed", r.URL.Query().Get("conflicts"))
+
+				var body map[string]interface{}
+				_ = json.NewDecoder(r.Body).Decode(&body)
+				assert.Equal(t, map[string]interface{}{
+					"query": map[string]interface{}{
+						"term": map[string]interface{}{"tenantID": "tenant1"},
+					},
+				}, body)
+
+				w.WriteHeader(tc.code)
+				_, _ = w.Write([]byte(`{"deleted": 3}`))
+			}, opts...)
+
+			deleted, err := s.DeleteTenantDevices(context.Background(), "tenant1")
+			if tc.err {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.deleted, deleted)
+			}
+			assert.Equal(t, tc.paths, paths)
+		})
+	}
+}
@@ -0,0 +1,217 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/elastic/go-elasticsearch/v7/esutil"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+
+	"github.com/mendersoftware/reporting/model"
+)
+
+var (
+	ErrTaskNotFound = errors.New("task not found")
+)
+
+// esTaskResponse is the response of GET _tasks/<task_id>
+type esTaskResponse struct {
+	Completed bool `json:"completed"`
+	Task      struct {
+		Node      string       `json:"node"`
+		ID        int64        `json:"id"`
+		Cancelled bool         `json:"cancelled"`
+		Status    esTaskStatus `json:"status"`
+	} `json:"task"`
+}
+
+// esTaskStatus is the status of a bulk by-query task
+// (update_by_query, delete_by_query, reindex)
+type esTaskStatus struct {
+	Total            int    `json:"total"`
+	Updated          int    `json:"updated"`
+	Created          int    `json:"created"`
+	Deleted          int    `json:"deleted"`
+	VersionConflicts int    `json:"version_conflicts"`
+	Canceled         string `json:"canceled"`
+}
+
+// ReindexTenant starts an asynchronous update_by_query over all the tenant's
+// documents, so that they're reindexed with the current mappings;
+// returns the ES task id which can be polled with GetTask
+func (s *store) ReindexTenant(ctx context.Context, tenantID string) (string, error) {
+	l := log.FromContext(ctx)
+
+	query := model.M{
+		"query": model.M{
+			"term": model.M{
+				"tenantID": tenantID,
+			},
+		},
+	}
+
+	waitForCompletion := false
+	req := esapi.UpdateByQueryRequest{
+		Index:             []string{s.GetDevicesIndex(tenantID)},
+		Routing:           []string{s.GetDevicesRoutingKey(tenantID)},
+		Body:              esutil.NewJSONReader(query),
+		Conflicts:         "proceed",
+		WaitForCompletion: &waitForCompletion,
+	}
+
+	res, err := req.Do(ctx, s.client)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to start the tenant reindex")
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return "", errors.Errorf(
+			"failed to start the tenant reindex, code %d", res.StatusCode,
+		)
+	}
+
+	var taskRes struct {
+		Task string `json:"task"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&taskRes); err != nil {
+		return "", err
+	}
+
+	l.Debugf("started reindex task %s for tenant %s", taskRes.Task, tenantID)
+
+	return taskRes.Task, nil
+}
+
+// UpdateTenantDevicesByQuery runs the update_by_query body over the tenant's
+// documents and waits for its completion; the version conflicts with
+// concurrent updates are counted, not retried, and the documents are
+// refreshed so that the update is visible to the searches right away
+func (s *store) UpdateTenantDevicesByQuery(
+	ctx context.Context,
+	tenantID string,
+	body interface{},
+) (*model.Task, error) {
+	refresh := true
+	req := esapi.UpdateByQueryRequest{
+		Index:     []string{s.GetDevicesIndex(tenantID)},
+		Routing:   []string{s.GetDevicesRoutingKey(tenantID)},
+		Body:      esutil.NewJSONReader(body),
+		Conflicts: "proceed",
+		Refresh:   &refresh,
+	}
+
+	res, err := req.Do(ctx, s.client)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to update the devices")
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, errors.Errorf("failed to update the devices, code %d", res.StatusCode)
+	}
+
+	var status esTaskStatus
+	if err := json.NewDecoder(res.Body).Decode(&status); err != nil {
+		return nil, errors.Wrap(err, "can't parse the update response")
+	}
+
+	return &model.Task{
+		TenantID:  tenantID,
+		Completed: true,
+		Total:     status.Total,
+		Updated:   status.Updated,
+		Conflicts: status.VersionConflicts,
+	}, nil
+}
+
+// GetTask polls the status of the ES task taskID
+func (s *store) GetTask(ctx context.Context, taskID string) (*model.Task, error) {
+	req := esapi.TasksGetRequest{
+		TaskID: taskID,
+	}
+
+	res, err := req.Do(ctx, s.client)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get task")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, ErrTaskNotFound
+	} else if res.IsError() {
+		return nil, errors.Errorf("failed to get task, code %d", res.StatusCode)
+	}
+
+	var taskRes esTaskResponse
+	if err := json.NewDecoder(res.Body).Decode(&taskRes); err != nil {
+		return nil, errors.Wrap(err, "can't parse task status")
+	}
+
+	status := taskRes.Task.Status
+	return &model.Task{
+		ID:        taskID,
+		Completed: taskRes.Completed,
+		Cancelled: taskRes.Task.Cancelled || status.Canceled != "",
+		Total:     status.Total,
+		Updated:   status.Updated,
+		Created:   status.Created,
+		Deleted:   status.Deleted,
+		Conflicts: status.VersionConflicts,
+	}, nil
+}
+
+// CancelTask cancels the ES task taskID
+func (s *store) CancelTask(ctx context.Context, taskID string) error {
+	l := log.FromContext(ctx)
+
+	req := esapi.TasksCancelRequest{
+		TaskID: taskID,
+	}
+
+	// cancelling a task twice is harmless
+	res, err := req.Do(withIdempotent(ctx), s.client)
+	if err != nil {
+		return errors.Wrap(err, "failed to cancel task")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return ErrTaskNotFound
+	} else if res.IsError() {
+		return errors.Errorf("failed to cancel task, code %d", res.StatusCode)
+	}
+
+	// a cancel request for a finished/unknown task may still return 200,
+	// but reports the problem in 'node_failures'
+	var cancelRes struct {
+		NodeFailures []interface{} `json:"node_failures"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&cancelRes); err != nil {
+		return errors.Wrap(err, "can't parse task cancel response")
+	}
+	if len(cancelRes.NodeFailures) > 0 {
+		l.Debugf("cancel task %s failed: %v", taskID, cancelRes.NodeFailures)
+		return ErrTaskNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,245 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/reporting/model"
+)
+
+func TestReindexTenant(t *testing.T) {
+	var body map[string]interface{}
+	s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/devices/_update_by_query", r.URL.Path)
+		assert.Equal(t, "tenant1", r.URL.Query().Get("routing"))
+		assert.Equal(t, "false", r.URL.Query().Get("wait_for_completion"))
+		assert.Equal(t, "proceed", r.URL.Query().Get("conflicts"))
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		_, _ = w.Write([]byte(`{"task":"node1:123"}`))
+	})
+
+	taskID, err := s.ReindexTenant(context.Background(), "tenant1")
+	assert.NoError(t, err)
+	assert.Equal(t, "node1:123", taskID)
+	assert.Equal(t, map[string]interface{}{
+		"query": map[string]interface{}{
+			"term": map[string]interface{}{"tenantID": "tenant1"},
+		},
+	}, body)
+}
+
+func TestUpdateTenantDevicesByQuery(t *testing.T) {
+	// the first run updates the devices with the old attribute, a rerun
+	// matches none left
+	responses := []string{
+		`{"took":12,"total":3,"updated":2,"version_conflicts":1,"failures":[]}`,
+		`{"took":2,"total":0,"updated":0,"version_conflicts":0,"failures":[]}`,
+	}
+	var bodies []map[string]interface{}
+	s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/devices/_update_by_query", r.URL.Path)
+		assert.Equal(t, "tenant1", r.URL.Query().Get("routing"))
+		assert.Equal(t, "proceed", r.URL.Query().Get("conflicts"))
+		assert.Equal(t, "true", r.URL.Query().Get("refresh"))
+		var body map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		bodies = append(bodies, body)
+		_, _ = w.Write([]byte(responses[len(bodies)-1]))
+	})
+
+	query := model.BuildRenameAttributeQuery(model.RenameAttributeParams{
+		TenantID: "tenant1",
+		Scope:    "inventory",
+		From:     "ipv4",
+		To:       "ip4",
+	})
+	task, err := s.UpdateTenantDevicesByQuery(context.Background(), "tenant1", query)
+	assert.NoError(t, err)
+	assert.Equal(t, &model.Task{
+		TenantID:  "tenant1",
+		Completed: true,
+		Total:     3,
+		Updated:   2,
+		Conflicts: 1,
+	}, task)
+
+	task, err = s.UpdateTenantDevicesByQuery(context.Background(), "tenant1", query)
+	assert.NoError(t, err)
+	assert.Equal(t, &model.Task{
+		TenantID:  "tenant1",
+		Completed: true,
+	}, task)
+
+	// the reruns send the same update
+	if assert.Len(t, bodies, 2) {
+		assert.Equal(t, bodies[0], bodies[1])
+		assert.Contains(t, bodies[0], "script")
+	}
+}
+
+func TestUpdateTenantDevicesByQueryError(t *testing.T) {
+	s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":{"type":"script_exception"}}`))
+	})
+
+	_, err := s.UpdateTenantDevicesByQuery(context.Background(), "tenant1", model.M{})
+	assert.EqualError(t, err, "failed to update the devices, code 400")
+}
+
+func TestGetTask(t *testing.T) {
+	testCases := map[string]struct {
+		code int
+		body string
+
+		task *model.Task
+		err  error
+	}{
+		"ok, running": {
+			code: http.StatusOK,
+			body: `{
+				"completed": false,
+				"task": {
+					"node": "node1",
+					"id": 123,
+					"cancellable": true,
+					"cancelled": false,
+					"status": {
+						"total": 200,
+						"updated": 40,
+						"created": 0,
+						"deleted": 0,
+						"batches": 1,
+						"version_conflicts": 10
+					}
+				}
+			}`,
+			task: &model.Task{
+				ID:        "node1:123",
+				Total:     200,
+				Updated:   40,
+				Conflicts: 10,
+			},
+		},
+		"ok, completed": {
+			code: http.StatusOK,
+			body: `{
+				"completed": true,
+				"task": {
+					"node": "node1",
+					"id": 123,
+					"status": {"total": 200, "updated": 200}
+				},
+				"response": {"total": 200, "updated": 200}
+			}`,
+			task: &model.Task{
+				ID:        "node1:123",
+				Completed: true,
+				Total:     200,
+				Updated:   200,
+			},
+		},
+		"ok, cancelled": {
+			code: http.StatusOK,
+			body: `{
+				"completed": true,
+				"task": {
+					"node": "node1",
+					"id": 123,
+					"status": {
+						"total": 200,
+						"updated": 20,
+						"canceled": "by user request"
+					}
+				}
+			}`,
+			task: &model.Task{
+				ID:        "node1:123",
+				Completed: true,
+				Cancelled: true,
+				Total:     200,
+				Updated:   20,
+			},
+		},
+		"error, not found": {
+			code: http.StatusNotFound,
+			body: `{"error":{"type":"resource_not_found_exception"}}`,
+			err:  ErrTaskNotFound,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, http.MethodGet, r.Method)
+				assert.Equal(t, "/_tasks/node1:123", r.URL.Path)
+				w.WriteHeader(tc.code)
+				_, _ = w.Write([]byte(tc.body))
+			})
+
+			task, err := s.GetTask(context.Background(), "node1:123")
+			if tc.err != nil {
+				assert.Equal(t, tc.err, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.task, task)
+			}
+		})
+	}
+}
+
+func TestCancelTask(t *testing.T) {
+	testCases := map[string]struct {
+		code int
+		body string
+
+		err error
+	}{
+		"ok": {
+			code: http.StatusOK,
+			body: `{"nodes":{"node1":{"tasks":{"node1:123":{}}}}}`,
+		},
+		"error, task already finished": {
+			code: http.StatusOK,
+			body: `{"node_failures":[{"type":"failed_node_exception"}]}`,
+			err:  ErrTaskNotFound,
+		},
+		"error, not found": {
+			code: http.StatusNotFound,
+			body: `{}`,
+			err:  ErrTaskNotFound,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, http.MethodPost, r.Method)
+				assert.Equal(t, "/_tasks/node1:123/_cancel", r.URL.Path)
+				w.WriteHeader(tc.code)
+				_, _ = w.Write([]byte(tc.body))
+			})
+
+			err := s.CancelTask(context.Background(), "node1:123")
+			assert.Equal(t, tc.err, err)
+		})
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"context"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+)
+
+// tenantIndexTemplatePriority is the priority of the dedicated indices'
+// templates, above the shared one's, whose pattern (<name>*) may match
+// the dedicated indices too
+const tenantIndexTemplatePriority = 2
+
+var (
+	ErrInvalidTenantIndex = errors.New("invalid tenant index")
+)
+
+// TenantIndex is the dedicated devices index of a tenant, e.g. a large one
+// needing more shards; several tenants may share a dedicated index
+type TenantIndex struct {
+	Name     string
+	Shards   int
+	Replicas int
+}
+
+// WithTenantIndices stores the devices of the listed tenants, keyed by
+// tenant ID, in their dedicated indices rather than in the shared one
+func WithTenantIndices(indices map[string]TenantIndex) StoreOption {
+	return func(s *store) {
+		s.tenantIndices = indices
+	}
+}
+
+// validateTenantIndices checks that the dedicated indices are named, apart
+// from the shared index, and that the tenants sharing an index agree on
+// its settings
+func validateTenantIndices(sharedIndex string, indices map[string]TenantIndex) error {
+	byName := make(map[string]TenantIndex, len(indices))
+	for tid, index := range indices {
+		switch {
+		case index.Name == "":
+			return errors.Wrapf(ErrInvalidTenantIndex, "%s: missing index name", tid)
+		case index.Name == sharedIndex:
+			return errors.Wrapf(ErrInvalidTenantIndex,
+				"%s: %s is the shared index", tid, index.Name)
+		case index.Shards <= 0:
+			return errors.Wrapf(ErrInvalidTenantIndex,
+				"%s: the number of shards must be positive", tid)
+		case index.Replicas < 0:
+			return errors.Wrapf(ErrInvalidTenantIndex,
+				"%s: the number of replicas can't be negative", tid)
+		}
+		if other, ok := byName[index.Name]; ok && other != index {
+			return errors.Wrapf(ErrInvalidTenantIndex,
+				"%s: conflicting settings of the index %s", tid, index.Name)
+		}
+		byName[index.Name] = index
+	}
+	return nil
+}
+
+// tenantIndex returns the dedicated index named name, if any
+func (s *store) tenantIndex(name string) (TenantIndex, bool) {
+	for _, index := range s.tenantIndices {
+		if index.Name == name {
+			return index, true
+		}
+	}
+	return TenantIndex{}, false
+}
+
+// tenantIndexNames returns the names of the dedicated indices, sorted
+func (s *store) tenantIndexNames() []string {
+	seen := make(map[string]bool, len(s.tenantIndices))
+	names := make([]string, 0, len(s.tenantIndices))
+	for _, index := range s.tenantIndices {
+		if !seen[index.Name] {
+			seen[index.Name] = true
+			names = append(names, index.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// migrateTenantIndices creates or updates the dedicated indices and their
+// templates, the same way as the shared index
+func (s *store) migrateTenantIndices(ctx context.Context) error {
+	l := log.FromContext(ctx)
+	for _, name := range s.tenantIndexNames() {
+		l.Infof("migrate the dedicated index %s", name)
+		if err := s.migrateDevicesIndex(ctx, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTenantIndices(t *testing.T) {
+	s := &store{
+		devicesIndexName: "devices",
+		devicesReadAlias: "devices-read",
+		tenantIndices: map[string]TenantIndex{
+			"tenant1": {Name: "devices-large", Shards: 6, Replicas: 1},
+			"tenant2": {Name: "devices-large", Shards: 6, Replicas: 1},
+		},
+	}
+
+	// a listed tenant resolves to its dedicated index,
+	// not behind the read alias
+	assert.Equal(t, "devices-large", s.GetDevicesIndex("tenant1"))
+	assert.Equal(t, "devices-large", s.GetDevicesReadIndex("tenant1"))
+	assert.Equal(t, "devices-large", s.GetDevicesIndex("tenant2"))
+
+	// an unlisted one to the shared index
+	assert.Equal(t, "devices", s.GetDevicesIndex("tenant3"))
+	assert.Equal(t, "devices-read", s.GetDevicesReadIndex("tenant3"))
+	assert.Equal(t, "devices", s.GetDevicesIndex(""))
+
+	assert.Equal(t, []string{"devices-large"}, s.tenantIndexNames())
+}
+
+func TestDevicesIndexTemplateTenantIndex(t *testing.T) {
+	s := &store{
+		devicesIndexShards:   1,
+		devicesIndexReplicas: 0,
+		devicesReadAlias:     "devices-read",
+		tenantIndices: map[string]TenantIndex{
+			"tenant1": {Name: "devices-large", Shards: 6, Replicas: 1},
+		},
+	}
+
+	template, err := s.devicesIndexTemplate("devices-large")
+	assert.NoError(t, err)
+	assert.Equal(t, tenantIndexTemplatePriority, template["priority"])
+	tmpl := template["template"].(map[string]interface{})
+	settings := tmpl["settings"].(map[string]interface{})
+	assert.Equal(t, float64(6), settings["number_of_shards"])
+	assert.Equal(t, float64(1), settings["number_of_replicas"])
+	assert.NotContains(t, tmpl, "aliases")
+
+	template, err = s.devicesIndexTemplate("devices")
+	assert.NoError(t, err)
+	assert.Equal(t, float64(1), template["priority"])
+	tmpl = template["template"].(map[string]interface{})
+	settings = tmpl["settings"].(map[string]interface{})
+	assert.Equal(t, float64(1), settings["number_of_shards"])
+	assert.Equal(t, float64(0), settings["number_of_replicas"])
+	assert.Contains(t, tmpl, "aliases")
+}
+
+func TestValidateTenantIndices(t *testing.T) {
+	testCases := map[string]struct {
+		indices map[string]TenantIndex
+
+		err string
+	}{
+		"ok": {
+			indices: map[string]TenantIndex{
+				"tenant1": {Name: "devices-large", Shards: 6, Replicas: 1},
+				"tenant2": {Name: "devices-large", Shards: 6, Replicas: 1},
+				"tenant3": {Name: "devices-small", Shards: 1},
+			},
+		},
+		"ok, none": {},
+		"error, missing name": {
+			indices: map[string]TenantIndex{"tenant1": {Shards: 1}},
+			err:     "tenant1: missing index name: invalid tenant index",
+		},
+		"error, shared index": {
+			indices: map[string]TenantIndex{"tenant1": {Name: "devices", Shards: 1}},
+			err:     "tenant1: devices is the shared index: invalid tenant index",
+		},
+		"error, no shards": {
+			indices: map[string]TenantIndex{"tenant1": {Name: "devices-large"}},
+			err: "tenant1: the number of shards must be positive: " +
+				"invalid tenant index",
+		},
+		"error, negative replicas": {
+			indices: map[string]TenantIndex{
+				"tenant1": {Name: "devices-large", Shards: 1, Replicas: -1},
+			},
+			err: "tenant1: the number of replicas can't be negative: " +
+				"invalid tenant index",
+		},
+		"error, conflicting settings": {
+			indices: map[string]TenantIndex{
+				"tenant1": {Name: "devices-large", Shards: 6},
+				"tenant2": {Name: "devices-large", Shards: 3},
+			},
+			err: "conflicting settings of the index devices-large",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := validateTenantIndices("devices", tc.indices)
+			if tc.err != "" {
+				assert.True(t, errors.Is(err, ErrInvalidTenantIndex))
+				assert.Contains(t, err.Error(), tc.err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestMigrateTenantIndices(t *testing.T) {
+	templates := map[string]map[string]interface{}{}
+	created := []string{}
+	s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut &&
+			(r.URL.Path == "/_index_template/devices" ||
+				r.URL.Path == "/_index_template/devices-large"):
+			var body map[string]interface{}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			templates[r.URL.Path] = body
+			_, _ = w.Write([]byte(`{"acknowledged":true}`))
+		case r.Method == http.MethodHead &&
+			(r.URL.Path == "/devices" || r.URL.Path == "/devices-large"):
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodPut &&
+			(r.URL.Path == "/devices" || r.URL.Path == "/devices-large"):
+			created = append(created, r.URL.Path)
+			_, _ = w.Write([]byte(`{"acknowledged":true}`))
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}, WithDevicesIndexShards(1), WithTenantIndices(map[string]TenantIndex{
+		"tenant1": {Name: "devices-large", Shards: 6, Replicas: 1},
+	}))
+
+	err := s.Migrate(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/devices", "/devices-large"}, created)
+	if assert.Contains(t, templates, "/_index_template/devices-large") {
+		template := templates["/_index_template/devices-large"]
+		assert.Equal(t, []interface{}{"devices-large*"}, template["index_patterns"])
+		assert.Equal(t, float64(tenantIndexTemplatePriority), template["priority"])
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+)
+
+// traceLogger logs the full ES requests and responses, incl. the bodies,
+// for debugging; it's an estransport.Logger, so that the ES client
+// passes it copies of the bodies
+type traceLogger struct {
+	// maxBodySize truncates the logged bodies, if > 0
+	maxBodySize int
+}
+
+func newTraceLogger(maxBodySize int) *traceLogger {
+	return &traceLogger{maxBodySize: maxBodySize}
+}
+
+// LogRoundTrip logs the request and its response, with the logger of the
+// request's context; the bodies are consumed, as they're copies
+func (l *traceLogger) LogRoundTrip(
+	req *http.Request,
+	res *http.Response,
+	err error,
+	start time.Time,
+	dur time.Duration,
+) error {
+	if req == nil {
+		return nil
+	}
+	reqBody := l.readBody(req.Body)
+	status := 0
+	resBody := ""
+	if res != nil {
+		status = res.StatusCode
+		resBody = l.readBody(res.Body)
+	}
+
+	logger := log.FromContext(req.Context())
+	if err != nil {
+		logger.Infof("es trace: %s %s failed after %s: %v; request: %s",
+			req.Method, req.URL.String(), dur, err, reqBody)
+		return nil
+	}
+	logger.Infof("es trace: %s %s %d %s; request: %s; response: %s",
+		req.Method, req.URL.String(), status, dur, reqBody, resBody)
+	return nil
+}
+
+// RequestBodyEnabled makes the ES client pass a copy of the request body
+func (l *traceLogger) RequestBodyEnabled() bool { return true }
+
+// ResponseBodyEnabled makes the ES client pass a copy of the response body
+func (l *traceLogger) ResponseBodyEnabled() bool { return true }
+
+// readBody reads and closes the body, truncated to the max body size
+func (l *traceLogger) readBody(body io.ReadCloser) string {
+	if body == nil || body == http.NoBody {
+		return ""
+	}
+	defer body.Close()
+
+	var r io.Reader = body
+	if l.maxBodySize > 0 {
+		// read one more byte to tell if the body was truncated
+		r = io.LimitReader(body, int64(l.maxBodySize)+1)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "<unreadable body>"
+	}
+	b = bytes.TrimSpace(b)
+	if l.maxBodySize > 0 && len(b) > l.maxBodySize {
+		return string(b[:l.maxBodySize]) + "...(truncated)"
+	}
+	return string(b)
+}
@@ -0,0 +1,92 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+)
+
+func TestTraceLogging(t *testing.T) {
+	testCases := map[string]struct {
+		enabled     bool
+		maxBodySize int
+
+		traces   []string
+		noTraces []string
+	}{
+		"disabled": {
+			noTraces: []string{"es trace"},
+		},
+		"enabled": {
+			enabled: true,
+			traces: []string{
+				"es trace: POST ",
+				"/devices/_update_by_query?",
+				" 200 ",
+				`request: {"query":{"term":{"tenantID":"tenant1"}}}`,
+				`response: {"task":"node1:123"}`,
+			},
+		},
+		"enabled, truncated bodies": {
+			enabled:     true,
+			maxBodySize: 8,
+			traces: []string{
+				`request: {"query"...(truncated)`,
+				`response: {"task":...(truncated)`,
+			},
+			noTraces: []string{"tenantID", "node1:123"},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(`{"task":"node1:123"}`))
+			}, WithTraceLogging(tc.enabled, tc.maxBodySize))
+			if tc.enabled {
+				assert.NotNil(t, s.trace)
+			} else {
+				assert.Nil(t, s.trace)
+			}
+
+			var out bytes.Buffer
+			logger := logrus.New()
+			logger.Out = &out
+			logger.Formatter = &logrus.TextFormatter{DisableQuote: true}
+			ctx := log.WithContext(context.Background(),
+				log.NewFromLogger(logger, log.Ctx{}))
+
+			// the traced bodies are copies, the response is still parsed
+			taskID, err := s.ReindexTenant(ctx, "tenant1")
+			assert.NoError(t, err)
+			assert.Equal(t, "node1:123", taskID)
+
+			logs := out.String()
+			for _, trace := range tc.traces {
+				assert.Contains(t, logs, trace)
+			}
+			for _, trace := range tc.noTraces {
+				assert.NotContains(t, logs, trace)
+			}
+		})
+	}
+}
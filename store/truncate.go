@@ -0,0 +1,115 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"expvar"
+	"unicode/utf8"
+
+	"github.com/mendersoftware/reporting/model"
+)
+
+var (
+	// metricTruncatedValues counts the string attribute values
+	// truncated at index time
+	metricTruncatedValues = expvar.NewInt("elasticsearch_truncated_values")
+)
+
+// WithValueLengthLimit sets ignore_above of the keyword fields of the string
+// attributes (0 keeps the ES default, i.e. no limit), and the length above
+// which the string values are truncated at index time (0 disables it);
+// values longer than ignore_above are stored but not indexed, so not
+// searchable at all, while truncated values are searchable up to the cap
+func WithValueLengthLimit(ignoreAbove, truncateAbove int) StoreOption {
+	return func(s *store) {
+		s.ignoreAbove = ignoreAbove
+		s.truncateAbove = truncateAbove
+	}
+}
+
+// truncateValues returns the device with the string values longer than
+// s.truncateAbove characters truncated; the device is copied if modified
+func (s *store) truncateValues(dev *model.Device) *model.Device {
+	if s.truncateAbove <= 0 || dev == nil {
+		return dev
+	}
+	truncated, count := truncateDevice(dev, s.truncateAbove)
+	if count > 0 {
+		metricTruncatedValues.Add(int64(count))
+	}
+	return truncated
+}
+
+// truncateDevice truncates the string values of dev's attributes to max
+// characters, returns the (copied, if modified) device and the number of
+// truncated values
+func truncateDevice(dev *model.Device, max int) (*model.Device, int) {
+	truncated := 0
+	truncate := func(attrs model.DeviceInventory) model.DeviceInventory {
+		var ret model.DeviceInventory
+		for i, a := range attrs {
+			var values []string
+			for j, v := range a.String {
+				if utf8.RuneCountInString(v) <= max {
+					continue
+				}
+				if values == nil {
+					values = make([]string, len(a.String))
+					copy(values, a.String)
+				}
+				values[j] = truncateString(v, max)
+				truncated++
+			}
+			if values == nil {
+				continue
+			}
+			if ret == nil {
+				ret = make(model.DeviceInventory, len(attrs))
+				copy(ret, attrs)
+			}
+			attr := *a
+			attr.String = values
+			ret[i] = &attr
+		}
+		if ret == nil {
+			return attrs
+		}
+		return ret
+	}
+
+	ret := *dev
+	ret.IdentityAttributes = truncate(dev.IdentityAttributes)
+	ret.InventoryAttributes = truncate(dev.InventoryAttributes)
+	ret.MonitorAttributes = truncate(dev.MonitorAttributes)
+	ret.SystemAttributes = truncate(dev.SystemAttributes)
+	ret.TagsAttributes = truncate(dev.TagsAttributes)
+	if truncated == 0 {
+		return dev, 0
+	}
+
+	return &ret, truncated
+}
+
+// truncateString returns the first max characters of s
+func truncateString(s string, max int) string {
+	n := 0
+	for i := range s {
+		if n == max {
+			return s[:i]
+		}
+		n++
+	}
+	return s
+}
@@ -0,0 +1,141 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/reporting/model"
+)
+
+func TestValueLengthLimitTemplate(t *testing.T) {
+	s := &store{}
+	WithTextAnalyzer(`\s+`, []string{"inventory_hostname_str"})(s)
+	WithValueLengthLimit(512, 0)(s)
+
+	template, err := s.devicesIndexTemplate("devices")
+	assert.NoError(t, err)
+
+	mapping := func(tmpl interface{}) map[string]interface{} {
+		for _, v := range tmpl.(map[string]interface{}) {
+			return v.(map[string]interface{})["mapping"].(map[string]interface{})
+		}
+		return nil
+	}
+	for _, tmpl := range templateMappings(template)["dynamic_templates"].([]interface{}) {
+		m := mapping(tmpl)
+		if m["type"] == "keyword" {
+			assert.Equal(t, 512, m["ignore_above"])
+		} else {
+			assert.NotContains(t, m, "ignore_above")
+		}
+	}
+
+	// the ES default is kept
+	s = &store{}
+	template, err = s.devicesIndexTemplate("devices")
+	assert.NoError(t, err)
+	for _, tmpl := range templateMappings(template)["dynamic_templates"].([]interface{}) {
+		assert.NotContains(t, mapping(tmpl), "ignore_above")
+	}
+}
+
+func TestTruncateDevice(t *testing.T) {
+	newDevice := func(values ...string) *model.Device {
+		dev := model.NewDevice("dev1").SetTenantID("tenant1")
+		_ = dev.AppendAttr(model.NewInventoryAttribute(model.AttrScopeInventory).
+			SetName("os").
+			SetStrings(values))
+		_ = dev.AppendAttr(model.NewInventoryAttribute(model.AttrScopeInventory).
+			SetName("mem").
+			SetNumeric(1024))
+		return dev
+	}
+
+	testCases := map[string]struct {
+		dev *model.Device
+		max int
+
+		out   *model.Device
+		count int
+	}{
+		"ok, nothing to truncate": {
+			dev: newDevice("linux", "debian"),
+			max: 6,
+
+			out: newDevice("linux", "debian"),
+		},
+		"ok, truncated": {
+			dev: newDevice("linux", "debian bullseye"),
+			max: 6,
+
+			out:   newDevice("linux", "debian"),
+			count: 1,
+		},
+		"ok, multi-byte characters": {
+			dev: newDevice("zażółć gęślą jaźń"),
+			max: 6,
+
+			out:   newDevice("zażółć"),
+			count: 1,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			orig := *tc.dev.InventoryAttributes[0]
+
+			out, count := truncateDevice(tc.dev, tc.max)
+			assert.Equal(t, tc.out, out)
+			assert.Equal(t, tc.count, count)
+
+			// the input device is left untouched
+			assert.Equal(t, orig, *tc.dev.InventoryAttributes[0])
+		})
+	}
+}
+
+func TestTruncateValuesBulk(t *testing.T) {
+	var bulk string
+	s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/_bulk", r.URL.Path)
+		body, _ := ioutil.ReadAll(r.Body)
+		bulk = string(body)
+		_, _ = w.Write([]byte(`{"errors": false, "items": []}`))
+	}, WithValueLengthLimit(0, 8))
+
+	dev := model.NewDevice("dev1").SetTenantID("tenant1")
+	_ = dev.AppendAttr(model.NewInventoryAttribute(model.AttrScopeInventory).
+		SetName("description").
+		SetString(strings.Repeat("x", 100)))
+	truncated := metricTruncatedValues.Value()
+
+	_, err := s.BulkRaw(context.Background(), []BulkItem{{
+		Action: &BulkAction{
+			Type: "index",
+			Desc: &BulkActionDesc{ID: "dev1", Index: "devices", Routing: "tenant1"},
+		},
+		Doc: dev,
+	}})
+	assert.NoError(t, err)
+	assert.Contains(t, bulk, `"inventory_description_str":["xxxxxxxx"]`)
+	assert.NotContains(t, bulk, "xxxxxxxxx")
+	assert.Less(t, truncated, metricTruncatedValues.Value())
+}
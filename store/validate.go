@@ -0,0 +1,113 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/elastic/go-elasticsearch/v7/esutil"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+
+	"github.com/mendersoftware/reporting/model"
+)
+
+// esValidateResponse is the response of _validate/query?explain=true
+type esValidateResponse struct {
+	Valid bool `json:"valid"`
+	// Error is set if the query can't be parsed at all
+	Error        string `json:"error"`
+	Explanations []struct {
+		Valid bool   `json:"valid"`
+		Error string `json:"error"`
+	} `json:"explanations"`
+}
+
+// ValidateQuery checks with ES whether it accepts the query, without
+// running it; only the query and the post filter are validated,
+// not e.g. the sort or the aggregations
+func (s *store) ValidateQuery(
+	ctx context.Context,
+	query model.Query,
+) (*model.QueryValidation, error) {
+	data, err := json.Marshal(query)
+	if err != nil {
+		return nil, err
+	}
+	var body model.M
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil, err
+	}
+	q := body["query"]
+	if postFilter, ok := body["post_filter"]; ok {
+		q = model.M{
+			"bool": model.M{
+				"must": []interface{}{q, postFilter},
+			},
+		}
+	}
+
+	id := identity.FromContext(ctx)
+	explain := true
+	req := esapi.IndicesValidateQueryRequest{
+		Index:   []string{s.GetDevicesReadIndex(id.Tenant)},
+		Body:    esutil.NewJSONReader(model.M{"query": q}),
+		Explain: &explain,
+	}
+
+	res, err := req.Do(withIdempotent(ctx), s.client)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to validate the query")
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, errors.Errorf("failed to validate the query, code %d", res.StatusCode)
+	}
+
+	var validateRes esValidateResponse
+	if err := json.NewDecoder(res.Body).Decode(&validateRes); err != nil {
+		return nil, errors.Wrap(err, "can't parse the validation response")
+	}
+
+	validation := &model.QueryValidation{Valid: validateRes.Valid}
+	if !validation.Valid {
+		reasons := []string{}
+		if validateRes.Error != "" {
+			reasons = append(reasons, validateRes.Error)
+		}
+		for _, e := range validateRes.Explanations {
+			// the indices behind the read alias fail alike
+			if !e.Valid && e.Error != "" && !inStrings(reasons, e.Error) {
+				reasons = append(reasons, e.Error)
+			}
+		}
+		validation.Error = strings.Join(reasons, "; ")
+	}
+	return validation, nil
+}
+
+func inStrings(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
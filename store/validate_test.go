@@ -0,0 +1,151 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/reporting/model"
+)
+
+func TestValidateQuery(t *testing.T) {
+	testCases := map[string]struct {
+		query model.Query
+		code  int
+		res   string
+
+		body       map[string]interface{}
+		validation *model.QueryValidation
+		err        string
+	}{
+		"ok, valid": {
+			query: model.NewQuery().Must(model.M{
+				"term": model.M{"tenantID": "tenant1"},
+			}),
+			code: http.StatusOK,
+			res:  `{"valid":true,"explanations":[{"index":"devices","valid":true}]}`,
+			body: map[string]interface{}{
+				"query": map[string]interface{}{
+					"bool": map[string]interface{}{
+						"must": []interface{}{
+							map[string]interface{}{
+								"term": map[string]interface{}{"tenantID": "tenant1"},
+							},
+						},
+					},
+				},
+			},
+			validation: &model.QueryValidation{Valid: true},
+		},
+		"ok, invalid": {
+			query: model.NewQuery().
+				Must(model.M{"range": model.M{
+					"inventory_date_str": model.M{"gt": "yesterday"},
+				}}).
+				WithPostFilter(model.NewQuery().Must(model.M{
+					"term": model.M{"groupName": "group1"},
+				})),
+			code: http.StatusOK,
+			res: `{"valid":false,"explanations":[
+				{"index":"devices-v1","valid":false,"error":"failed to parse [yesterday]"},
+				{"index":"devices-v2","valid":false,"error":"failed to parse [yesterday]"}
+			]}`,
+			body: map[string]interface{}{
+				"query": map[string]interface{}{
+					"bool": map[string]interface{}{
+						"must": []interface{}{
+							map[string]interface{}{
+								"bool": map[string]interface{}{
+									"must": []interface{}{
+										map[string]interface{}{
+											"range": map[string]interface{}{
+												"inventory_date_str": map[string]interface{}{
+													"gt": "yesterday",
+												},
+											},
+										},
+									},
+								},
+							},
+							map[string]interface{}{
+								"bool": map[string]interface{}{
+									"must": []interface{}{
+										map[string]interface{}{
+											"term": map[string]interface{}{
+												"groupName": "group1",
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			validation: &model.QueryValidation{
+				Error: "failed to parse [yesterday]",
+			},
+		},
+		"ok, parse error": {
+			query: model.NewQuery(),
+			code:  http.StatusOK,
+			res:   `{"valid":false,"error":"ParsingException[unknown query [foo]]"}`,
+			body: map[string]interface{}{
+				"query": map[string]interface{}{
+					"bool": map[string]interface{}{},
+				},
+			},
+			validation: &model.QueryValidation{
+				Error: "ParsingException[unknown query [foo]]",
+			},
+		},
+		"error, es": {
+			query: model.NewQuery(),
+			code:  http.StatusNotFound,
+			res:   `{"error":{"type":"index_not_found_exception"}}`,
+			body: map[string]interface{}{
+				"query": map[string]interface{}{
+					"bool": map[string]interface{}{},
+				},
+			},
+			err: "failed to validate the query, code 404",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var body map[string]interface{}
+			s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "/devices/_validate/query", r.URL.Path)
+				assert.Equal(t, "true", r.URL.Query().Get("explain"))
+				_ = json.NewDecoder(r.Body).Decode(&body)
+				w.WriteHeader(tc.code)
+				_, _ = w.Write([]byte(tc.res))
+			})
+
+			validation, err := s.ValidateQuery(testIdentityCtx(), tc.query)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.validation, validation)
+			}
+			assert.Equal(t, tc.body, body)
+		})
+	}
+}
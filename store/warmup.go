@@ -0,0 +1,80 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/elastic/go-elasticsearch/v7/esutil"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+)
+
+// WithWarmUpQueries sets the search bodies run by WarmUp against the
+// devices index, to load the caches before the first user queries
+func WithWarmUpQueries(queries []map[string]interface{}) StoreOption {
+	return func(s *store) {
+		s.warmUpQueries = queries
+	}
+}
+
+// WarmUp runs the warm-up queries against the devices index (all the
+// shards, with the request cache enabled); a failed query doesn't stop
+// the others, the first error is returned once they're all done
+func (s *store) WarmUp(ctx context.Context) error {
+	l := log.FromContext(ctx)
+
+	var firstErr error
+	requestCache := true
+	for i, query := range s.warmUpQueries {
+		req := esapi.SearchRequest{
+			Index:        []string{s.GetDevicesIndex("")},
+			Body:         esutil.NewJSONReader(query),
+			RequestCache: &requestCache,
+		}
+
+		err := s.warmUpQuery(withIdempotent(ctx), req)
+		if err != nil {
+			l.Warnf("warm-up query %d failed: %v", i, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		l.Debugf("warm-up query %d done", i)
+	}
+
+	return firstErr
+}
+
+func (s *store) warmUpQuery(ctx context.Context, req esapi.SearchRequest) error {
+	res, err := req.Do(ctx, s.client)
+	if err != nil {
+		return errors.Wrap(err, "failed to run the warm-up query")
+	}
+	defer res.Body.Close()
+
+	// the results don't matter, only loading the caches
+	_, _ = io.Copy(ioutil.Discard, res.Body)
+	if res.IsError() {
+		return errors.Errorf("failed to run the warm-up query, code %d",
+			res.StatusCode)
+	}
+	return nil
+}
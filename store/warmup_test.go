@@ -0,0 +1,82 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWarmUp(t *testing.T) {
+	queries := []map[string]interface{}{{
+		"size":  float64(0),
+		"query": map[string]interface{}{"match_all": map[string]interface{}{}},
+	}, {
+		"size": float64(0),
+		"aggs": map[string]interface{}{
+			"groups": map[string]interface{}{
+				"terms": map[string]interface{}{"field": "system_group_str"},
+			},
+		},
+	}}
+
+	testCases := map[string]struct {
+		queries []map[string]interface{}
+		codes   []int
+
+		err bool
+	}{
+		"ok": {
+			queries: queries,
+			codes:   []int{http.StatusOK, http.StatusOK},
+		},
+		"ok, no queries": {},
+		"error, the other queries still run": {
+			queries: queries,
+			codes:   []int{http.StatusBadRequest, http.StatusOK},
+			err:     true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var bodies []map[string]interface{}
+			s := newTestStore(t, func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "/devices/_search", r.URL.Path)
+				assert.Equal(t, "true", r.URL.Query().Get("request_cache"))
+				// no routing, all the shards are warmed up
+				assert.Empty(t, r.URL.Query().Get("routing"))
+
+				var body map[string]interface{}
+				_ = json.NewDecoder(r.Body).Decode(&body)
+				bodies = append(bodies, body)
+
+				w.WriteHeader(tc.codes[len(bodies)-1])
+				_, _ = w.Write([]byte(`{}`))
+			}, WithRetryPolicy(RetryPolicy{}), WithWarmUpQueries(tc.queries))
+
+			err := s.WarmUp(context.Background())
+			if tc.err {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.queries, bodies)
+		})
+	}
+}